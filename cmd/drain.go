@@ -0,0 +1,94 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	k8sapi "github.com/openebs/lib-csi/pkg/client/k8s"
+	"github.com/openebs/zfs-localpv/pkg/drain"
+	clientset "github.com/openebs/zfs-localpv/pkg/generated/clientset/internalclientset"
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
+)
+
+// newDrainCommand returns the command migrating all the volumes of a node
+// to the other nodes, it is run in the controller pod before the node is
+// decommissioned
+func newDrainCommand() *cobra.Command {
+	migrator := &drain.SendRecvMigrator{}
+
+	cmd := &cobra.Command{
+		Use:   "drain <nodeid>",
+		Short: "migrate the volumes of a node to the other nodes",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := k8sapi.Config().Get()
+			if err != nil {
+				return fmt.Errorf("drain: failed to build kubeconfig: %w", err)
+			}
+			kubeClient, err := kubernetes.NewForConfig(cfg)
+			if err != nil {
+				return fmt.Errorf("drain: failed to build k8s clientset: %w", err)
+			}
+			openebsClient, err := clientset.NewForConfig(cfg)
+			if err != nil {
+				return fmt.Errorf("drain: failed to build openebs clientset: %w", err)
+			}
+			migrator.WithClients(kubeClient, openebsClient)
+			return runDrain(context.Background(), drain.NewDrainer(args[0], migrator), migrator)
+		},
+	}
+
+	cmd.Flags().StringVar(
+		&migrator.SendAddr, "send-addr", "", "ip:port of the relay the node being drained sends the volumes to",
+	)
+
+	cmd.Flags().StringVar(
+		&migrator.RecvAddr, "recv-addr", "", "ip:port of the relay the target nodes receive the volumes from",
+	)
+
+	cmd.Flags().DurationVar(
+		&migrator.Timeout, "timeout", time.Hour, "Time each step of the migration of a volume is given",
+	)
+
+	return cmd
+}
+
+// runDrain drains the node, printing the progress of every volume, and
+// fails if some volumes could not be migrated
+func runDrain(ctx context.Context, drainer *drain.Drainer, migrator *drain.SendRecvMigrator) error {
+	if migrator.SendAddr == "" || migrator.RecvAddr == "" {
+		return errors.New("drain: --send-addr and --recv-addr are required")
+	}
+
+	report, err := drainer.WithProgress(func(st drain.VolumeStatus) {
+		fmt.Printf("volume %s to node %q: %s %s\n", st.Volume, st.Target, st.Phase, st.Reason)
+	}).Drain(ctx)
+	if err != nil {
+		return err
+	}
+
+	if blockers := report.Blockers(); len(blockers) > 0 {
+		return fmt.Errorf("drain: %d volumes of node %s could not be migrated", len(blockers), report.Node)
+	}
+	fmt.Printf("node %s drained\n", report.Node)
+	return nil
+}
@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"time"
 
 	config "github.com/openebs/zfs-localpv/pkg/config"
 	"github.com/openebs/zfs-localpv/pkg/driver"
@@ -67,13 +68,219 @@ func main() {
 	)
 
 	cmd.PersistentFlags().StringVar(
-		&config.DriverName, "name", "zfs.csi.openebs.io", "Name of this driver",
+		&config.DriverName, "name", zfs.DefaultDriverName, "Name of this driver, the instances running side by side need distinct names, the topology keys are <name>/nodeid and <name>/nodename for the names other than the default, each instance needs its own namespace",
 	)
 
 	cmd.PersistentFlags().StringVar(
 		&config.PluginType, "plugin", "csi-plugin", "Type of this driver i.e. controller or node",
 	)
 
+	cmd.PersistentFlags().StringVar(
+		&config.DefaultFsType, "default-fstype", zfs.DefaultFsType, "Filesystem used for the volume when the PVC and StorageClass do not specify one",
+	)
+
+	cmd.PersistentFlags().StringVar(
+		&config.ListenAddress, "listen-address", "", "TCP address to listen on for prometheus metrics, metrics are disabled if empty",
+	)
+
+	cmd.PersistentFlags().StringVar(
+		&config.MetricsPath, "metrics-path", "/metrics", "The HTTP path where prometheus metrics will be exposed",
+	)
+
+	cmd.PersistentFlags().DurationVar(
+		&config.VolumeHealthInterval, "volume-health-interval", time.Minute, "Interval at which the node plugin checks the condition of the published volumes, disabled if 0",
+	)
+
+	cmd.PersistentFlags().StringVar(
+		&config.ZFSPath, "zfs-path", zfs.ZFSVolCmd, "Name or path of the zfs binary used by the node plugin",
+	)
+
+	cmd.PersistentFlags().StringVar(
+		&config.ZPoolPath, "zpool-path", zfs.ZPoolCmd, "Name or path of the zpool binary used by the node plugin",
+	)
+
+	cmd.PersistentFlags().StringVar(
+		&config.CommandPrefix, "command-prefix", "", "Command every zfs and zpool invocation is wrapped in, e.g. \"sudo -n\"",
+	)
+
+	cmd.PersistentFlags().StringSliceVar(
+		&config.TopologyKeys, "topology-keys", nil, "Comma separated node label keys set in the volume topology along with the node, e.g. topology.kubernetes.io/zone,topology.kubernetes.io/region",
+	)
+
+	cmd.PersistentFlags().StringSliceVar(
+		&config.PropagatePrefixes, "propagate-prefixes", nil, "Comma separated prefixes of the pvc label and annotation keys copied to the ZFSVolume and kept in sync, e.g. team,example.com/",
+	)
+
+	cmd.PersistentFlags().StringVar(
+		&config.ThinExpandPolicy, "thin-expand-policy", "allow", "What to do when a thin volume is expanded beyond the size of its pool: allow, warn or reject",
+	)
+
+	cmd.PersistentFlags().StringVar(
+		&config.VolumeLockPolicy, "volume-lock-policy", driver.VolumeLockWait, "What the controller does with an expand, a snapshot or a delete of a volume which already has one in progress: wait runs them in order, abort fails it with Aborted",
+	)
+
+	cmd.PersistentFlags().StringVar(
+		&config.AbandonedVolumePolicy, "abandoned-volume-policy", driver.AbandonedVolumeCancel, "What the controller does with a volume whose pvc is deleted before the volume is ready: cancel destroys the dataset created for it and deletes the volume, keep leaves it",
+	)
+
+	cmd.PersistentFlags().StringVar(
+		&config.PoolDegradedPolicy, "pool-degraded-policy", driver.PoolDegradedReport, "What the controller does when the health of a pool degrades: report raises an event on the ZFSNode, mark also sets the PoolDegraded condition of its volumes, cordon also stops placing new volumes on it",
+	)
+
+	cmd.PersistentFlags().StringVar(
+		&config.UnschedulableResponse, "unschedulable-response", driver.UnschedulableReschedule, "How a volume which no node can hold is failed: reschedule asks the provisioner for another node, retry retries it on the same node",
+	)
+
+	cmd.PersistentFlags().IntVar(
+		&config.MaxDatasetsPerPool, "max-datasets-per-pool", 0, "Number of datasets a pool can hold before the controller stops placing volumes on it, unlimited if 0",
+	)
+
+	cmd.PersistentFlags().BoolVar(
+		&config.DestroyGuard, "destroy-guard", false, "Pause the destroy of the volumes and snapshots larger than --destroy-guard-size until they are annotated with openebs.io/confirm-destroy=true",
+	)
+
+	cmd.PersistentFlags().StringVar(
+		&config.DestroyGuardSize, "destroy-guard-size", "100Gi", "Size above which the destroy guard pauses the destroy",
+	)
+
+	cmd.PersistentFlags().StringVar(
+		&config.StaleMountCleanup, "stale-mount-cleanup", "report", "What to do at startup with the stale mounts of the driver on the node: off, report or clean",
+	)
+
+	cmd.PersistentFlags().StringVar(
+		&config.FsGroupMode, "fsgroup-mode", zfs.FsGroupKubelet, "How the fsGroup of the pods is applied to the filesystem volumes: kubelet, or by the node plugin with onrootmismatch, recursive or rootonly",
+	)
+
+	cmd.PersistentFlags().StringVar(
+		&config.SnapshotClonesPolicy, "snapshot-clones-policy", zfs.SnapshotClonesRefuse, "What is done with the clones of a snapshot being deleted: refuse, or promote a clone which takes the snapshot over",
+	)
+
+	cmd.PersistentFlags().StringVar(
+		&config.PublishFailurePolicy, "publish-failure-policy", driver.PublishFailureRollback, "What is done with the mount of a publish which fails on a later step: rollback unmounts it, keep leaves it for the retry",
+	)
+
+	cmd.PersistentFlags().StringVar(
+		&config.MissingTargetParent, "missing-target-parent", zfs.MissingParentCreate, "What is done when the parent directory of the target path of a publish is missing: create makes it, fail fails the publish",
+	)
+
+	cmd.PersistentFlags().StringVar(
+		&config.DeviceRescan, "device-rescan", zfs.DeviceRescanAuto, "What is done when the kernel still sees the old size of an expanded zvol: auto rescans the device and waits for the new size before growing the filesystem, off grows it right away",
+	)
+
+	cmd.PersistentFlags().StringVar(
+		&config.DataCheckRate, "data-check-rate", zfs.DefaultDataCheckRate, "Bytes read per second by a check of the data of a volume asked with the openebs.io/check-data annotation, e.g. 100Mi, 0 does not bound them",
+	)
+
+	cmd.PersistentFlags().StringVar(
+		&config.KubeletDir, "kubelet-dir", "/var/lib/kubelet", "Root directory of kubelet on the node, the stale mounts are looked for below it",
+	)
+
+	cmd.PersistentFlags().IntVar(
+		&config.BusyRetries, "busy-retries", 5, "Number of times a zfs create or zfs set failing because the pool is busy is retried, e.g. during a scrub",
+	)
+
+	cmd.PersistentFlags().DurationVar(
+		&config.BusyRetryInterval, "busy-retry-interval", time.Second, "Wait before the first retry of a command failing because the pool is busy, it doubles for each retry",
+	)
+
+	cmd.PersistentFlags().DurationVar(
+		&config.BusyRetryMaxInterval, "busy-retry-max-interval", 30*time.Second, "Maximum wait between the retries of a command failing because the pool is busy",
+	)
+
+	cmd.PersistentFlags().IntVar(
+		&config.MaxSends, "max-concurrent-sends", zfs.DefaultMaxSends, "Number of zfs send, of the backups and the migrations, run at the same time on the node, the other ones wait for a free slot, unbounded if 0",
+	)
+
+	cmd.PersistentFlags().StringVar(
+		&config.ZFSVersionPolicy, "zfs-version-policy", zfs.ZFSVersionWarn, "What the node plugin does at startup when the zfs version of the node is out of the supported range: warn or refuse",
+	)
+
+	cmd.PersistentFlags().StringVar(
+		&config.MinZFSVersion, "min-zfs-version", zfs.DefaultMinZFSVersion, "Lowest major.minor zfs version supported by the node plugin",
+	)
+
+	cmd.PersistentFlags().StringVar(
+		&config.MaxZFSVersion, "max-zfs-version", zfs.DefaultMaxZFSVersion, "Highest major.minor zfs version supported by the node plugin",
+	)
+
+	cmd.PersistentFlags().IntVar(
+		&config.ReservedSpacePercent, "reserved-space-percent", 0, "Percentage of each pool kept free as emergency space by a placeholder dataset, 0 reserves nothing",
+	)
+
+	cmd.PersistentFlags().DurationVar(
+		&config.FailedCleanupGrace, "failed-cleanup-grace", 0, "Time a partially created volume is kept for inspection before it is destroyed",
+	)
+
+	cmd.PersistentFlags().DurationVar(
+		&config.StatusUpdateInterval, "status-update-interval", zfs.DefaultStatusUpdateInterval, "Minimum interval in between two writes of the noisy status fields of an object by the node plugin",
+	)
+
+	cmd.PersistentFlags().DurationVar(
+		&config.PoolSummaryInterval, "pool-summary-interval", zfs.DefaultPoolSummaryInterval, "Interval at which the capacity summary of the pools is refreshed in the ZFSNode, 0 disables the summary",
+	)
+
+	cmd.PersistentFlags().DurationVar(
+		&config.DeadmanWindow, "deadman-window", zfs.DefaultDeadmanWindow, "Time the deadman events of a pool are counted, the IOHung condition is kept until it passes without new events",
+	)
+
+	cmd.PersistentFlags().IntVar(
+		&config.DeadmanThreshold, "deadman-threshold", zfs.DefaultDeadmanThreshold, "Number of deadman events of a pool in the deadman window which mark its IO as hung, 0 disables the detection",
+	)
+
+	cmd.PersistentFlags().IntVar(
+		&config.PoolFullThreshold, "pool-full-threshold", zfs.DefaultPoolFullThreshold, "Percent of the size of a pool above which it is reported as full, 0 disables the detection",
+	)
+
+	cmd.PersistentFlags().StringVar(
+		&config.PoolFullCleanup, "pool-full-cleanup", zfs.PoolCleanupOff, "What is done to reclaim the space of a full pool: off or unreferenced-snapshots",
+	)
+
+	cmd.PersistentFlags().DurationVar(
+		&config.FenceLease, "fence-lease", zfs.DefaultFenceLease, "Time the lease of a node on a zvol stays fresh once renewed, 0 disables the fencing",
+	)
+
+	cmd.PersistentFlags().StringVar(
+		&config.CapacityDriftMode, "capacity-drift-mode", zfs.DriftModeReport, "What is done when the quota or volsize of a volume has been changed behind the driver: off, report or enforce",
+	)
+
+	cmd.PersistentFlags().StringVar(
+		&config.DanglingSnapshotPolicy, "dangling-snapshot-policy", zfs.DanglingPolicyMark, "What is done with a ZFSSnapshot whose zfs snapshot has been destroyed behind the driver: off, mark or delete",
+	)
+
+	cmd.PersistentFlags().BoolVar(
+		&config.PoolAutoImport, "pool-auto-import", false, "Import the expected pools of the ZFSNode which are not imported on the node",
+	)
+
+	cmd.PersistentFlags().IntVar(
+		&config.DestroyConcurrency, "destroy-concurrency", zfs.DefaultDestroyConcurrency, "Number of volumes the node plugin destroys at the same time",
+	)
+
+	cmd.PersistentFlags().BoolVar(
+		&config.PoolAliasUpdate, "pool-alias-update", false, "Update the pool of the ZFSVolumes and ZFSSnapshots of a renamed pool with its current name instead of resolving it in memory",
+	)
+
+	cmd.PersistentFlags().IntVar(
+		&config.NodeUnreachableRetries, "node-unreachable-retries", 3, "Number of times the controller checks again a node whose agent is not alive before placing the volume on another node",
+	)
+
+	cmd.PersistentFlags().DurationVar(
+		&config.NodeUnreachableRetryInterval, "node-unreachable-retry-interval", 2*time.Second, "Wait before checking again a node whose agent is not alive, it doubles for each retry",
+	)
+
+	cmd.PersistentFlags().DurationVar(
+		&config.NodeGoneThreshold, "node-gone-threshold", 5*time.Minute, "Time after which a node whose agent is not alive is considered gone, the volumes are then placed on another node or rescheduled",
+	)
+
+	cmd.PersistentFlags().BoolVar(
+		&config.GRPCReflection, "grpc-reflection", false, "Register the grpc reflection service on the csi endpoint for debugging with grpcurl",
+	)
+
+	cmd.PersistentFlags().DurationVar(
+		&config.SocketReadyTimeout, "socket-ready-timeout", 30*time.Second, "Time the csi endpoint is given to accept the connections at startup, the driver exits if it is not ready by then",
+	)
+
+	cmd.AddCommand(newDrainCommand())
+
 	err := cmd.Execute()
 	if err != nil {
 		_, _ = fmt.Fprintf(os.Stderr, "%s", err.Error())
@@ -154,6 +154,18 @@ Enable zfsController containers leader election if replicas > 1
 {{- end }}
 {{- end }}
 
+{{/*
+Directory of the plugin socket below the kubelet plugins directory, the
+default driver name keeps the zfs-localpv directory of the existing installs
+*/}}
+{{- define "zfslocalpv.zfsNode.pluginDir" -}}
+{{- if eq .Values.zfsPlugin.driverName "zfs.csi.openebs.io" -}}
+zfs-localpv
+{{- else -}}
+{{- .Values.zfsPlugin.driverName -}}
+{{- end -}}
+{{- end }}
+
 {{/*
 Ensure that the path to kubelet ends with a slash
 */}}
@@ -36,8 +36,13 @@ type ZFSBackup struct {
 	metav1.ObjectMeta `json:"metadata,omitempty"`
 	Spec              ZFSBackupSpec `json:"spec"`
 	// +kubebuilder:validation:Required
-	// +kubebuilder:validation:Enum=Init;Done;Failed;Pending;InProgress;Invalid
+	// +kubebuilder:validation:Enum=Init;Done;Failed;Pending;InProgress;Invalid;Cancelled
 	Status ZFSBackupStatus `json:"status"`
+	// Progress of the zfs send, updated while the backup is running
+	Progress ZFSBackupProgress `json:"progress,omitempty"`
+	// Verification is the result of the verification of the snapshot, it
+	// is only set for a backup with VerifySnapshot
+	Verification *SnapshotVerification `json:"verification,omitempty"`
 }
 
 // ZFSBackupSpec is the spec for a ZFSBackup resource
@@ -65,6 +70,33 @@ type ZFSBackupSpec struct {
 	// +kubebuilder:validation:MinLength=1
 	// +kubebuilder:validation:Pattern="^([0-9]+.[0-9]+.[0-9]+.[0-9]+:[0-9]+)$"
 	BackupDest string `json:"backupDest"`
+
+	// Cancel asks the node to stop the backup. The zfs send in flight is
+	// killed and the backup is marked Cancelled.
+	Cancel bool `json:"cancel,omitempty"`
+
+	// KeepForResume keeps the snapshot of a cancelled backup so that the
+	// send can be resumed later with the ResumeToken of the receiver,
+	// otherwise the snapshot is destroyed on cancellation.
+	KeepForResume bool `json:"keepForResume,omitempty"`
+
+	// ResumeToken is the receive_resume_token of the receiver. When set,
+	// the send resumes from where an interrupted send has stopped.
+	ResumeToken string `json:"resumeToken,omitempty"`
+
+	// VerifySnapshot reads the whole snapshot before it is sent, so that a
+	// corrupted snapshot fails the backup rather than being restored.
+	VerifySnapshot bool `json:"verifySnapshot,omitempty"`
+}
+
+// ZFSBackupProgress is the progress of the zfs send of the backup
+type ZFSBackupProgress struct {
+	// BytesSent is the number of bytes of the stream sent so far
+	BytesSent int64 `json:"bytesSent,omitempty"`
+
+	// EstimatedBytes is the size of the stream as estimated by a dry run
+	// of the zfs send, zero if it could not be estimated
+	EstimatedBytes int64 `json:"estimatedBytes,omitempty"`
 }
 
 // ZFSBackupStatus is to hold status of backup
@@ -89,6 +121,9 @@ const (
 
 	// BKPZFSStatusInvalid , backup operation is invalid.
 	BKPZFSStatusInvalid ZFSBackupStatus = "Invalid"
+
+	// BKPZFSStatusCancelled , backup has been cancelled.
+	BKPZFSStatusCancelled ZFSBackupStatus = "Cancelled"
 )
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -31,11 +31,302 @@ import (
 // ZFSNode has an owner reference pointing to the corresponding node object.
 // +kubebuilder:object:root=true
 // +kubebuilder:resource:scope=Namespaced,shortName=zfsnode
+// +kubebuilder:printcolumn:name="Pools",type=string,JSONPath=`.status.pools[*].name`,description="ZFS pools on the node"
+// +kubebuilder:printcolumn:name="Health",type=string,JSONPath=`.status.pools[*].health`,description="Health of the pools",priority=1
+// +kubebuilder:printcolumn:name="Size",type=string,JSONPath=`.status.pools[*].size`,description="Size of the pools",priority=1
+// +kubebuilder:printcolumn:name="Allocated",type=string,JSONPath=`.status.pools[*].allocated`,description="Allocated capacity of the pools",priority=1
+// +kubebuilder:printcolumn:name="Free",type=string,JSONPath=`.status.pools[*].free`,description="Free capacity of the pools",priority=1
+// +kubebuilder:printcolumn:name="Fragmentation",type=string,JSONPath=`.status.pools[*].fragmentation`,description="Fragmentation of the pools",priority=1
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`,description="Age of the node"
 type ZFSNode struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
 
 	Pools []Pool `json:"pools"`
+
+	// PerformanceClasses maps the name of a zpool to its performance
+	// class, e.g. nvme or hdd. It is set by the operators, the node agent
+	// does not change it. The scheduler matches it against the
+	// performanceclass parameter of the StorageClass.
+	PerformanceClasses map[string]string `json:"performanceClasses,omitempty"`
+
+	// ExpectedPools are the zfs pools which should be imported on the node.
+	// It is set by the operators, the node agent does not change it. With
+	// the pool auto import enabled, the node agent imports the expected
+	// pools which have been exported, e.g. after a maintenance.
+	ExpectedPools []string `json:"expectedPools,omitempty"`
+
+	// PoolAliases maps the former names of the zfs pools to their current
+	// names, e.g. after a pool has been exported and imported under
+	// another name. It is set by the operators, the node agent does not
+	// change it. The volumes and the snapshots of a renamed pool are
+	// resolved to its current name.
+	PoolAliases map[string]string `json:"poolAliases,omitempty"`
+
+	// TenantQuotas maps a tenant dataset, e.g. zfspv-pool/tenant-a, to the
+	// quota bounding the space used by all its volumes together. It is set
+	// by the operators, the node agent creates the dataset if needed and
+	// keeps its quota. The volumes of the tenant are provisioned with the
+	// dataset, or a child of it, as their poolname.
+	TenantQuotas map[string]resource.Quantity `json:"tenantQuotas,omitempty"`
+
+	// Status is the capacity summary of the zpools on the node
+	Status ZFSNodeStatus `json:"status,omitempty"`
+}
+
+// ZFSNodeStatus summarizes the capacity of the zpools on the node as
+// reported by `zpool list`. It is refreshed periodically by the node agent.
+type ZFSNodeStatus struct {
+	// Pools is the capacity summary of each zpool on the node.
+	Pools []PoolSummary `json:"pools,omitempty"`
+
+	// Imports are the last attempts of the node agent to import each of
+	// the expected pools which was not imported.
+	Imports []PoolImport `json:"imports,omitempty"`
+
+	// FsTypes are the filesystems the zvols can be formatted with and
+	// whether their tools are installed on the node. It is not set by the
+	// node agents which do not check the tools.
+	FsTypes []FsTypeTools `json:"fsTypes,omitempty"`
+
+	// ZFSVersion is the zfs version detected by the node agent at startup
+	// and whether the driver supports it. It is not set by the node
+	// agents which do not detect the version.
+	ZFSVersion *ZFSVersionStatus `json:"zfsVersion,omitempty"`
+
+	// Tenants is the usage of the tenant datasets of TenantQuotas.
+	Tenants []TenantUsage `json:"tenants,omitempty"`
+
+	// Archives are the volumes of the node which have been archived before
+	// they were destroyed, they outlive the volumes to restore them from
+	// their archive.
+	Archives []VolumeArchive `json:"archives,omitempty"`
+}
+
+// TenantUsage is the space of a tenant dataset bounded by its quota
+type TenantUsage struct {
+	// Name of the tenant dataset, e.g. zfspv-pool/tenant-a.
+	Name string `json:"name"`
+
+	// Quota is the quota of the dataset as set in TenantQuotas.
+	Quota resource.Quantity `json:"quota"`
+
+	// Used is the space used by the dataset and all its children. It is
+	// empty if the quota could not be applied.
+	Used *resource.Quantity `json:"used,omitempty"`
+
+	// Available is the space left to the volumes of the tenant, the
+	// lesser of what is left of the quota and of the free space of the
+	// zpool. It is empty if the quota could not be applied.
+	Available *resource.Quantity `json:"available,omitempty"`
+
+	// Message is the reason the quota could not be applied.
+	Message string `json:"message,omitempty"`
+}
+
+// ZFSVersionStatus is the zfs version of the node
+type ZFSVersionStatus struct {
+	// Version is the version of the zfs kernel module, or of the zfs
+	// tools if the module does not report it, e.g. 2.1.5-1ubuntu6. It is
+	// empty if the version could not be detected.
+	Version string `json:"version,omitempty"`
+
+	// Supported tells whether the version is in the supported range, the
+	// node agent may run with an unsupported version as per its policy.
+	Supported bool `json:"supported"`
+
+	// SupportedRange is the range of the supported versions, e.g. 0.8-2.2
+	SupportedRange string `json:"supportedRange,omitempty"`
+}
+
+// FsTypeTools tells which userspace tools of a filesystem are installed
+// on the node
+type FsTypeTools struct {
+	// Name is the filesystem, e.g. xfs
+	Name string `json:"name"`
+
+	// Mkfs tells whether mkfs.<name> is installed, the zvols can only be
+	// formatted with the filesystem if it is.
+	Mkfs bool `json:"mkfs"`
+
+	// Fsck tells whether fsck.<name> is installed, the filesystem is
+	// mounted without being checked if it is not.
+	Fsck bool `json:"fsck"`
+}
+
+// PoolImport is an attempt to import an expected zpool
+type PoolImport struct {
+	// Name of the zpool.
+	Name string `json:"name"`
+
+	// Result of the attempt, Imported, Refused when the pool might be in
+	// use by another host, or Failed.
+	// +kubebuilder:validation:Enum=Imported;Refused;Failed
+	Result string `json:"result"`
+
+	// Message is the reason of the result.
+	Message string `json:"message,omitempty"`
+
+	// LastAttempt is the time of the attempt.
+	LastAttempt metav1.Time `json:"lastAttempt"`
+}
+
+// PoolSummary is the capacity and health of a zpool.
+type PoolSummary struct {
+	// Name of the zpool.
+	Name string `json:"name"`
+
+	// Size is the total size of the zpool.
+	Size resource.Quantity `json:"size"`
+
+	// Allocated is the space allocated in the zpool.
+	Allocated resource.Quantity `json:"allocated"`
+
+	// Free is the unallocated space in the zpool.
+	Free resource.Quantity `json:"free"`
+
+	// Fragmentation is the fragmentation of the free space in the zpool,
+	// e.g. 12%. It is empty if zfs can not report it.
+	Fragmentation string `json:"fragmentation,omitempty"`
+
+	// Health of the zpool, e.g. ONLINE, DEGRADED, FAULTED.
+	Health string `json:"health"`
+
+	// SnapshotUsed is the sum of the space used by the snapshots of the
+	// zpool. The blocks shared by several snapshots are not part of it.
+	// It is empty if the snapshots could not be listed.
+	SnapshotUsed *resource.Quantity `json:"snapshotUsed,omitempty"`
+
+	// Snapshots is the number of snapshots in the zpool.
+	Snapshots int64 `json:"snapshots,omitempty"`
+
+	// Datasets is the number of datasets in the zpool, the filesystems
+	// and the zvols along with the root dataset. It is empty if the
+	// datasets could not be listed.
+	Datasets int64 `json:"datasets,omitempty"`
+
+	// Features maps the feature flags of the zpool to their state,
+	// enabled, active or disabled, as reported by `zpool get all`. It is
+	// empty if they could not be probed.
+	Features map[string]string `json:"features,omitempty"`
+
+	// DeadmanEvents is the number of deadman events posted by zfs for the
+	// zpool within the detection window, i.e. the IOs or txg syncs which
+	// have not completed in time.
+	DeadmanEvents int64 `json:"deadmanEvents,omitempty"`
+
+	// Conditions are the observed conditions of the zpool. The IOHung
+	// condition is true while the deadman events reach the threshold. It
+	// is empty if the events could not be read.
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// Scan is the progress of the scrub or the resilver in progress, or
+	// the result of the last one. It is empty if the zpool has never been
+	// scanned or if its status could not be read.
+	Scan *PoolScan `json:"scan,omitempty"`
+
+	// Vdevs are the vdevs of the zpool along with the devices backing
+	// them, as reported by `zpool status -P`. It is empty if the status
+	// could not be read.
+	Vdevs []PoolVdev `json:"vdevs,omitempty"`
+}
+
+// PoolVdev is a top level vdev of a zpool
+type PoolVdev struct {
+	// Name of the vdev, e.g. mirror-0 or raidz2-1, or the path of the
+	// device of a single disk vdev.
+	Name string `json:"name"`
+
+	// Class of the vdev, logs, cache, spares, special or dedup. It is
+	// empty for the vdevs holding the data.
+	Class string `json:"class,omitempty"`
+
+	// State of the vdev, e.g. ONLINE, DEGRADED or FAULTED.
+	State string `json:"state"`
+
+	// Devices are the devices backing the vdev.
+	Devices []PoolDevice `json:"devices,omitempty"`
+}
+
+// PoolDevice is a device backing a vdev of a zpool
+type PoolDevice struct {
+	// Path of the device as used by the zpool, e.g.
+	// /dev/disk/by-id/wwn-0x5000c500a1b2c3d4-part1.
+	Path string `json:"path"`
+
+	// State of the device, e.g. ONLINE, FAULTED, UNAVAIL or AVAIL for a
+	// spare.
+	State string `json:"state"`
+
+	// Disk is the name of the whole disk holding the device, e.g. sdb, or
+	// of the multipath device, e.g. mpatha. It is empty if it could not
+	// be resolved, e.g. for a file.
+	Disk string `json:"disk,omitempty"`
+
+	// Serial is the serial number of the disk.
+	Serial string `json:"serial,omitempty"`
+
+	// WWN is the world wide name of the disk.
+	WWN string `json:"wwn,omitempty"`
+
+	// Paths are the disks the multipath device goes through, e.g. sdb
+	// and sdc. It is empty for a disk which is not multipathed.
+	Paths []string `json:"paths,omitempty"`
+}
+
+// PoolScanState is the state of the scan of a zpool
+type PoolScanState string
+
+const (
+	// PoolScanInProgress , the zpool is being scanned.
+	PoolScanInProgress PoolScanState = "InProgress"
+	// PoolScanPaused , the scrub has been paused.
+	PoolScanPaused PoolScanState = "Paused"
+	// PoolScanFinished , the scan is over.
+	PoolScanFinished PoolScanState = "Finished"
+	// PoolScanCanceled , the scan has been stopped before it was over.
+	PoolScanCanceled PoolScanState = "Canceled"
+)
+
+// PoolScan is the progress of a scrub or a resilver of a zpool, as
+// reported by the scan line of `zpool status`
+type PoolScan struct {
+	// Function of the scan, scrub or resilver.
+	Function string `json:"function"`
+
+	// State of the scan.
+	// +kubebuilder:validation:Enum=InProgress;Paused;Finished;Canceled
+	State PoolScanState `json:"state"`
+
+	// Percent is the part of the data the scan has gone through, e.g.
+	// 7.86. It is only set while the scan is not over.
+	Percent string `json:"percent,omitempty"`
+
+	// Scanned is the bytes of metadata scanned so far.
+	Scanned int64 `json:"scanned,omitempty"`
+
+	// Issued is the bytes of data read or written so far.
+	Issued int64 `json:"issued,omitempty"`
+
+	// Total is the bytes of data to be scanned.
+	Total int64 `json:"total,omitempty"`
+
+	// Processed is the bytes repaired by the scrub or rebuilt by the
+	// resilver.
+	Processed int64 `json:"processed,omitempty"`
+
+	// Errors is the number of errors found by the scan once it is over.
+	Errors int64 `json:"errors,omitempty"`
+
+	// Remaining is the estimated time to complete the scan. It is empty
+	// if zfs can not estimate it.
+	Remaining *metav1.Duration `json:"remaining,omitempty"`
+
+	// StartTime is when the scan in progress has started.
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// EndTime is when the scan has finished or has been canceled.
+	EndTime *metav1.Time `json:"endTime,omitempty"`
 }
 
 // Pool specifies attributes of a given zfs pool that exists on the node.
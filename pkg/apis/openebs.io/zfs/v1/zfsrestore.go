@@ -33,6 +33,8 @@ type ZFSRestore struct {
 	// +kubebuilder:validation:Required
 	// +kubebuilder:validation:Enum=Init;Done;Failed;Pending;InProgress;Invalid
 	Status ZFSRestoreStatus `json:"status"`
+	// Retry records the failed attempts of the zfs receive
+	Retry ZFSRestoreRetry `json:"retry,omitempty"`
 }
 
 // ZFSRestoreSpec is the spec for a ZFSRestore resource
@@ -51,6 +53,45 @@ type ZFSRestoreSpec struct {
 	// +kubebuilder:validation:MinLength=1
 	// +kubebuilder:validation:Pattern="^([0-9]+.[0-9]+.[0-9]+.[0-9]+:[0-9]+)$"
 	RestoreSrc string `json:"restoreSrc"`
+
+	// RecordSize is set on the restored dataset once it has been
+	// received. It only applies to the data written after the restore,
+	// the received blocks keep the recordsize of the source. It is
+	// ignored for the zvols.
+	// +kubebuilder:validation:Pattern="^([0-9]+[kKmM]?)?$"
+	RecordSize string `json:"recordSize,omitempty"`
+
+	// MaxRetries is the number of times a failed zfs receive is attempted
+	// again before the restore is marked Failed. The partial dataset left
+	// by the failed receive is destroyed before a retry, unless it can be
+	// resumed. Default Value: 0.
+	// +kubebuilder:validation:Minimum=0
+	MaxRetries int32 `json:"maxRetries,omitempty"`
+
+	// Resumable receives the stream with zfs receive -s, so that a failed
+	// receive keeps the partial dataset and records its resume token in
+	// the retry status. The sender resumes the send from the token with
+	// zfs send -t, e.g. with a ZFSBackup having the resumeToken set.
+	Resumable bool `json:"resumable,omitempty"`
+
+	// Incremental receives an incremental stream into the dataset of a
+	// previous restore of the volume, the properties of the volume are
+	// not set again.
+	Incremental bool `json:"incremental,omitempty"`
+}
+
+// ZFSRestoreRetry is the state of the retries of the zfs receive
+type ZFSRestoreRetry struct {
+	// Attempts is the number of failed zfs receive which have been
+	// attempted again
+	Attempts int32 `json:"attempts,omitempty"`
+
+	// ResumeToken is the receive_resume_token of the partial dataset,
+	// it is set when the next attempt resumes the receive
+	ResumeToken string `json:"resumeToken,omitempty"`
+
+	// LastError is the error of the last failed zfs receive
+	LastError string `json:"lastError,omitempty"`
 }
 
 // ZFSRestoreStatus is to hold result of action.
@@ -32,8 +32,20 @@ type ZFSSnapshot struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
 
-	Spec   VolumeInfo `json:"spec"`
-	Status SnapStatus `json:"status"`
+	Spec   SnapshotSpec `json:"spec"`
+	Status SnapStatus   `json:"status"`
+}
+
+// SnapshotSpec is the spec of the volume the snapshot has been taken of,
+// along with the fields which only apply to the snapshot
+type SnapshotSpec struct {
+	VolumeInfo `json:",inline"`
+
+	// SnapshotProperties are the user properties set on the zfs snapshot,
+	// e.g. to tag it with a retention class or a backup job for the
+	// external backup tooling. The names need a colon as per the zfs user
+	// properties.
+	SnapshotProperties map[string]string `json:"snapshotProperties,omitempty"`
 }
 
 // ZFSSnapshotList is a list of ZFSSnapshot resources
@@ -49,4 +61,35 @@ type ZFSSnapshotList struct {
 // SnapStatus string that reflects if the snapshot was created successfully
 type SnapStatus struct {
 	State string `json:"state,omitempty"`
+
+	// Written is the amount of data in bytes written to the volume between
+	// the predecessor snapshot and this snapshot. For the first snapshot of
+	// the volume it is the data written since the volume was created.
+	Written string `json:"written,omitempty"`
+
+	// Predecessor is the name of the previous snapshot of the volume which
+	// Written is relative to, it is empty for the first snapshot.
+	Predecessor string `json:"predecessor,omitempty"`
+
+	// CreationTime is the time the zfs snapshot has been taken at, as
+	// reported by its creation property. It is set once the snapshot is
+	// Ready.
+	CreationTime *metav1.Time `json:"creationTime,omitempty"`
+
+	// Size is the amount of data in bytes referenced by the snapshot. It
+	// is set once the snapshot is Ready.
+	Size string `json:"size,omitempty"`
+
+	// Error is the reason the snapshot has Failed, e.g. it could not be
+	// taken within its timeout, or the reason its destroy is paused.
+	Error string `json:"error,omitempty"`
+
+	// Properties are the user properties of the spec which have been set
+	// on the zfs snapshot. It is set once the snapshot is Ready.
+	Properties map[string]string `json:"properties,omitempty"`
+
+	// Conditions are the observed conditions of the snapshot. The
+	// SnapshotMissing condition is true once the zfs snapshot is found to
+	// be gone from the node.
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
@@ -74,10 +74,22 @@ type VolumeInfo struct {
 	// +kubebuilder:validation:MinLength=1
 	PoolName string `json:"poolName"`
 
+	// DatasetName is the name of the dataset in the pool when it differs
+	// from the volume name. It is set at provision time when the volume
+	// name is too long for zfs and the name is shortened.
+	// DatasetName can not be edited after the volume has been provisioned.
+	DatasetName string `json:"datasetName,omitempty"`
+
 	// SnapName specifies the name of the snapshot where the volume has been cloned from.
 	// Snapname can not be edited after the volume has been provisioned.
 	SnapName string `json:"snapname,omitempty"`
 
+	// SourcePool is the pool of the volume the volume is cloned from when
+	// it is on another pool of the same node. A volume on another zpool is
+	// copied with send/receive instead of cloned.
+	// SourcePool can not be edited after the volume has been provisioned.
+	SourcePool string `json:"sourcePool,omitempty"`
+
 	// Capacity of the volume
 	// +kubebuilder:validation:Required
 	// +kubebuilder:validation:MinLength=1
@@ -98,6 +110,39 @@ type VolumeInfo struct {
 	// +kubebuilder:validation:MinLength=1
 	VolBlockSize string `json:"volblocksize,omitempty"`
 
+	// DnodeSize specifies the size of the dnodes of the dataset. Setting it to
+	// "auto" benefits the workloads which use lots of xattrs or small files.
+	// DnodeSize affects the metadata layout, it only applies to the objects
+	// created after it has been set. It is applicable only for the DATASET.
+	// DnodeSize can not be edited after the volume has been provisioned.
+	// Default Value: legacy.
+	// +kubebuilder:validation:Enum=legacy;auto;1k;2k;4k;8k;16k
+	DnodeSize string `json:"dnodesize,omitempty"`
+
+	// AclType specifies the type of the ACLs supported by the dataset. The
+	// value "posix" enables the POSIX ACLs and needs the xattrs to be stored
+	// as system attributes, so xattr is set to "sa" when it is not specified.
+	// It is applicable only for the DATASET.
+	// AclType can not be edited after the volume has been provisioned.
+	// Default Value: off.
+	// +kubebuilder:validation:Enum=off;posix;nfsv4
+	AclType string `json:"acltype,omitempty"`
+
+	// AclMode controls how the ACL of a file is modified when its mode is
+	// changed with chmod. It is applicable only for the DATASET.
+	// AclMode can not be edited after the volume has been provisioned.
+	// Default Value: discard.
+	// +kubebuilder:validation:Enum=discard;groupmask;passthrough;restricted
+	AclMode string `json:"aclmode,omitempty"`
+
+	// Xattr controls how the extended attributes are stored in the dataset.
+	// The value "sa" stores them as system attributes, which is required by
+	// the POSIX ACLs. It is applicable only for the DATASET.
+	// Xattr can not be edited after the volume has been provisioned.
+	// Default Value: on.
+	// +kubebuilder:validation:Enum=on;off;sa;dir
+	Xattr string `json:"xattr,omitempty"`
+
 	// Compression specifies the block-level compression algorithm to be applied to the ZFS Volume.
 	// The value "on" indicates ZFS to use the default compression algorithm. The default compression
 	// algorithm used by ZFS will be either lzjb or, if the lz4_compress feature is enabled, lz4.
@@ -123,6 +168,15 @@ type VolumeInfo struct {
 	// +kubebuilder:validation:Enum=on;off
 	Dedup string `json:"dedup,omitempty"`
 
+	// RedundantMetadata controls how many copies of the metadata ZFS keeps.
+	// "most" and "some" reduce the metadata write overhead, which helps
+	// performance critical volumes on redundant pools. "some" and "none"
+	// need OpenZFS 2.2 or later on the node.
+	// RedundantMetadata property can be edited after the volume has been created.
+	// Default Value: all.
+	// +kubebuilder:validation:Enum=all;most;some;none
+	RedundantMetadata string `json:"redundantMetadata,omitempty"`
+
 	// Enabling the encryption feature allows for the creation of
 	// encrypted filesystems and volumes. ZFS will encrypt file and zvol data,
 	// file attributes, ACLs, permission bits, directory listings, FUID mappings,
@@ -142,6 +196,11 @@ type VolumeInfo struct {
 	// +kubebuilder:validation:Enum=passphrase;raw;hex
 	KeyFormat string `json:"keyformat,omitempty"`
 
+	// KeySecret is the namespace/name of the Secret holding the encryption
+	// key generated for this volume at provision time. The key is passed
+	// to zfs on stdin when the volume is created or its key is loaded.
+	KeySecret string `json:"keySecret,omitempty"`
+
 	// ThinProvision describes whether space reservation for the source volume is required or not.
 	// The value "yes" indicates that volume should be thin provisioned and "no" means thick provisioning of the volume.
 	// If thinProvision is set to "yes" then volume can be provisioned even if the ZPOOL does not
@@ -184,6 +243,124 @@ type VolumeInfo struct {
 	// +kubebuilder:validation:Required
 	// +kubebuilder:validation:Enum=yes;no
 	Shared string `json:"shared,omitempty"`
+
+	// ReadOnly specifies whether the volume is created with the ZFS readonly
+	// property set, the volume is then always mounted read-only and can not
+	// be used with the write access modes. It is meant for the immutable
+	// content like golden images served to many consumers via clones.
+	// ReadOnly can not be modified once volume has been provisioned.
+	// +kubebuilder:validation:Enum=yes;no
+	ReadOnly string `json:"readonly,omitempty"`
+
+	// ShareNFS is the sharenfs property of a dataset volume, "on", "off"
+	// or the export options, e.g. "rw=@10.0.0.0/24". The dataset is then
+	// exported by the zfs share integration of the node while it is
+	// mounted, the NFS server has to be configured on the node.
+	// ShareNFS can be edited after the volume has been created.
+	// +kubebuilder:validation:Pattern="^[^\\s]+$"
+	ShareNFS string `json:"shareNFS,omitempty"`
+
+	// ShareSMB is the sharesmb property of a dataset volume, "on", "off"
+	// or the share options. Samba with the usershares enabled has to be
+	// configured on the node.
+	// ShareSMB can be edited after the volume has been created.
+	// +kubebuilder:validation:Pattern="^[^\\s]+$"
+	ShareSMB string `json:"shareSMB,omitempty"`
+
+	// HostMountPoint is the path on the node the dataset is mounted at by
+	// zfs, besides the bind mounts of the pods using it, so that it can be
+	// reached out of band, e.g. by the backups of the host.
+	// HostMountPoint can not be modified once volume has been provisioned.
+	HostMountPoint string `json:"hostMountPoint,omitempty"`
+
+	// Layout is the named profile of the child datasets the dataset is
+	// created with, e.g. postgres has data with a recordsize of 8k and wal
+	// and logs with 128k. The children are mounted below the volume and
+	// share its quota, they are included in its snapshots.
+	// Layout can not be modified once volume has been provisioned.
+	// +kubebuilder:validation:Enum=postgres;mysql
+	Layout string `json:"layout,omitempty"`
+
+	// MkfsOptions are the extra arguments of mkfs used to format a zvol,
+	// e.g. "-E stride=16,stripe_width=64" for ext4 or "-d su=64k,sw=4" for
+	// xfs, to match the filesystem to the geometry of the vdevs. They are
+	// only used when the zvol is formatted for the first time.
+	// MkfsOptions can not be modified once volume has been provisioned.
+	MkfsOptions string `json:"mkfsOptions,omitempty"`
+
+	// SnapshotPolicy specifies what happens to the snapshots of the volume
+	// when the volume is deleted. "block" fails the deletion as long as the
+	// volume has snapshots, "delete" deletes the snapshots along with the
+	// volume and "orphan" moves the snapshots to an independent dataset in
+	// the pool so that they can still be restored after the volume is gone.
+	// Default Value: block.
+	// +kubebuilder:validation:Enum=block;delete;orphan
+	SnapshotPolicy string `json:"snapshotPolicy,omitempty"`
+
+	// FreezePlugin is the name of the plugin making the application
+	// writing to the volume consistent while the node agent takes the zfs
+	// snapshot of a ZFSSnapshot, e.g. mysql or fsfreeze. It is not used for
+	// a ZFSVolume.
+	FreezePlugin string `json:"freezePlugin,omitempty"`
+
+	// FreezeParams are the parameters of the freeze plugin, e.g. the pod
+	// running the application. It is not used for a ZFSVolume.
+	FreezeParams map[string]string `json:"freezeParams,omitempty"`
+
+	// RootUID is the owner uid set on the root directory of a filesystem
+	// volume when it is mounted for the first time, e.g. for the pods which
+	// do not run as root. It is not changed on the later mounts, so that the
+	// permissions set by the application are kept.
+	// +kubebuilder:validation:Pattern="^[0-9]+$"
+	RootUID string `json:"rootUID,omitempty"`
+
+	// RootGID is the group gid set on the root directory of a filesystem
+	// volume when it is mounted for the first time.
+	// +kubebuilder:validation:Pattern="^[0-9]+$"
+	RootGID string `json:"rootGID,omitempty"`
+
+	// RootMode is the octal mode, e.g. "2775", set on the root directory of
+	// a filesystem volume when it is mounted for the first time.
+	// +kubebuilder:validation:Pattern="^0?[0-7]{3,4}$"
+	RootMode string `json:"rootMode,omitempty"`
+
+	// IndependenceThreshold is the divergence in percent, i.e. the data
+	// written to a clone since it was created compared to all the data it
+	// references, at which the clone is made independent of its origin
+	// snapshot. The data is then copied into a new dataset replacing the
+	// clone, so that the origin snapshot can be deleted. 0 never makes the
+	// clone independent. Only datasets which are not mounted are made
+	// independent, the divergence of a zvol is only reported.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	IndependenceThreshold int32 `json:"independenceThreshold,omitempty"`
+
+	// VerifySource reads the whole origin snapshot of a clone before the
+	// clone is created, so that a corrupted snapshot fails the creation
+	// rather than being propagated into the clone.
+	// +kubebuilder:validation:Enum=yes;no
+	VerifySource string `json:"verifySource,omitempty"`
+
+	// Preallocate writes the whole zvol once it is created, so that its
+	// blocks are allocated before the volume is used. It is only supported
+	// for the thick zvols, the volume is published once it is done.
+	// Preallocate can not be modified once volume has been provisioned.
+	// +kubebuilder:validation:Enum=yes;no
+	Preallocate string `json:"preallocate,omitempty"`
+
+	// ReclaimPolicy specifies what happens to the data of the volume when
+	// the volume is deleted. "Delete" destroys the dataset or the zvol and
+	// "ArchiveThenDelete" first sends a final snapshot of it to the
+	// ArchiveTarget, the volume is only destroyed once the archive has
+	// succeeded. It is not used for a ZFSSnapshot.
+	// Default Value: Delete.
+	// +kubebuilder:validation:Enum=Delete;ArchiveThenDelete
+	ReclaimPolicy string `json:"reclaimPolicy,omitempty"`
+
+	// ArchiveTarget is the host:port of the receiver the final snapshot of
+	// a volume with the ArchiveThenDelete reclaim policy is sent to, e.g.
+	// a zfs receive or an object storage gateway listening on it.
+	ArchiveTarget string `json:"archiveTarget,omitempty"`
 }
 
 // VolStatus string that specifies the current state of the volume provisioning request.
@@ -194,4 +371,323 @@ type VolStatus struct {
 	// and it is ready for the use.
 	// +kubebuilder:validation:Enum=Pending;Ready;Failed
 	State string `json:"state,omitempty"`
+
+	// DnodeSize is the effective dnodesize of the dataset as reported by ZFS.
+	DnodeSize string `json:"dnodesize,omitempty"`
+
+	// AclType is the effective acltype of the dataset as reported by ZFS.
+	AclType string `json:"acltype,omitempty"`
+
+	// AclMode is the effective aclmode of the dataset as reported by ZFS.
+	AclMode string `json:"aclmode,omitempty"`
+
+	// Xattr is the effective xattr of the dataset as reported by ZFS.
+	Xattr string `json:"xattr,omitempty"`
+
+	// RedundantMetadata is the effective redundant_metadata of the volume
+	// as reported by ZFS.
+	RedundantMetadata string `json:"redundantMetadata,omitempty"`
+
+	// LiveCapacity is the quota or volsize found on the node when it
+	// differs from the capacity in the spec, e.g. after a manual change.
+	LiveCapacity string `json:"liveCapacity,omitempty"`
+
+	// RequestedCapacity is the capacity the volume would have had without
+	// the alignment to the recordsize, it is only set when the alignment
+	// has rounded the capacity up.
+	RequestedCapacity string `json:"requestedCapacity,omitempty"`
+
+	// SourceVerification is the result of the verification of the origin
+	// snapshot, it is only set for a clone with VerifySource.
+	SourceVerification *SnapshotVerification `json:"sourceVerification,omitempty"`
+
+	// Origin is the snapshot the volume has been cloned from, it is not
+	// set for a volume which has not been created as a clone.
+	Origin *CloneOrigin `json:"origin,omitempty"`
+
+	// CloneDivergence is how far a clone has diverged from its origin
+	// snapshot, it is not set for a volume which is not a clone.
+	CloneDivergence *CloneDivergence `json:"cloneDivergence,omitempty"`
+
+	// Preallocation is the progress of the preallocation of the zvol, it
+	// is only set for a volume with Preallocate.
+	Preallocation *Preallocation `json:"preallocation,omitempty"`
+
+	// Defragmentation is the progress of the last defragmentation of the
+	// volume, asked with the openebs.io/defragment annotation.
+	Defragmentation *Defragmentation `json:"defragmentation,omitempty"`
+
+	// DataCheck is the result of the last read-through of the data of the
+	// volume, asked with the openebs.io/check-data annotation.
+	DataCheck *DataCheck `json:"dataCheck,omitempty"`
+
+	// Archive is the result of the archive of the volume being deleted, it
+	// is only set for a volume with the ArchiveThenDelete reclaim policy.
+	Archive *VolumeArchive `json:"archive,omitempty"`
+
+	// SpaceUsage is the breakdown of the space used by the volume, it is
+	// refreshed on each reconcile of the volume.
+	SpaceUsage *VolumeSpaceUsage `json:"spaceUsage,omitempty"`
+
+	// Properties are the properties of the volume managed by the driver,
+	// the value requested in the spec along with the value in effect.
+	Properties []PropertyStatus `json:"properties,omitempty"`
+
+	// Conditions are the observed conditions of the volume. The
+	// CapacityDrift condition is true while the live capacity differs
+	// from the spec.
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// PreallocationPhase is the phase of the preallocation of a zvol
+type PreallocationPhase string
+
+const (
+	// PreallocationInProgress , the zvol is being written.
+	PreallocationInProgress PreallocationPhase = "InProgress"
+	// PreallocationDone , the whole zvol has been written.
+	PreallocationDone PreallocationPhase = "Done"
+	// PreallocationCancelled , the preallocation has been cancelled, the
+	// rest of the zvol is allocated on the first write.
+	PreallocationCancelled PreallocationPhase = "Cancelled"
+	// PreallocationFailed , the zvol could not be written.
+	PreallocationFailed PreallocationPhase = "Failed"
+)
+
+// Preallocation is the progress of the preallocation of a zvol
+type Preallocation struct {
+	// Phase is the phase of the preallocation.
+	// +kubebuilder:validation:Enum=InProgress;Done;Cancelled;Failed
+	Phase PreallocationPhase `json:"phase"`
+
+	// Written is the bytes of the zvol written so far.
+	Written int64 `json:"written"`
+
+	// Total is the size of the zvol in bytes.
+	Total int64 `json:"total"`
+
+	// Message is the reason the preallocation has failed or has been
+	// cancelled.
+	Message string `json:"message,omitempty"`
+}
+
+// PropertyStatus is a property of the volume as requested and as in
+// effect on the node
+type PropertyStatus struct {
+	// Name is the name of the ZFS property, e.g. compression.
+	Name string `json:"name"`
+
+	// Requested is the value set in the spec, it is empty if the spec
+	// leaves the property to the pool.
+	Requested string `json:"requested,omitempty"`
+
+	// Effective is the value of the property as reported by ZFS.
+	Effective string `json:"effective,omitempty"`
+
+	// Source is where the effective value comes from as reported by ZFS,
+	// e.g. local, default or inherited from zfspv-pool.
+	Source string `json:"source,omitempty"`
+
+	// Differs is true if the effective value is not the requested one,
+	// e.g. when the property could not be set.
+	Differs bool `json:"differs,omitempty"`
+}
+
+// DefragmentationPhase is the phase of the defragmentation of a volume
+type DefragmentationPhase string
+
+const (
+	// DefragmentationInProgress , the volume is being copied into a new
+	// dataset.
+	DefragmentationInProgress DefragmentationPhase = "InProgress"
+	// DefragmentationDone , the volume has been replaced by its copy.
+	DefragmentationDone DefragmentationPhase = "Done"
+	// DefragmentationFailed , the volume has been left as it was.
+	DefragmentationFailed DefragmentationPhase = "Failed"
+)
+
+// Defragmentation is the progress of the defragmentation of a volume,
+// which rewrites the volume into a new dataset with send/receive
+type Defragmentation struct {
+	// Phase is the phase of the defragmentation.
+	// +kubebuilder:validation:Enum=InProgress;Done;Failed
+	Phase DefragmentationPhase `json:"phase"`
+
+	// Step is the step the defragmentation is at while it is in
+	// progress, e.g. Copying or Swapping.
+	Step string `json:"step,omitempty"`
+
+	// Message is the reason the defragmentation has failed.
+	Message string `json:"message,omitempty"`
+
+	// StartTime is when the defragmentation has started.
+	StartTime metav1.Time `json:"startTime,omitempty"`
+
+	// CompletionTime is when the defragmentation has finished.
+	CompletionTime metav1.Time `json:"completionTime,omitempty"`
+}
+
+// DataCheckPhase is the phase of the check of the data of a volume
+type DataCheckPhase string
+
+const (
+	// DataCheckInProgress , the data of the volume is being read.
+	DataCheckInProgress DataCheckPhase = "InProgress"
+	// DataCheckPassed , all the data has been read without error.
+	DataCheckPassed DataCheckPhase = "Passed"
+	// DataCheckFailed , the data could not be read or zfs has found
+	// checksum errors in it.
+	DataCheckFailed DataCheckPhase = "Failed"
+	// DataCheckCancelled , the check has been cancelled before the end.
+	DataCheckCancelled DataCheckPhase = "Cancelled"
+)
+
+// DataCheck is the check of the data of a volume, all its blocks are read
+// so that zfs verifies their checksums, as a scrub does for the whole pool
+type DataCheck struct {
+	// Phase is the phase of the check.
+	// +kubebuilder:validation:Enum=InProgress;Passed;Failed;Cancelled
+	Phase DataCheckPhase `json:"phase"`
+
+	// BytesRead is the bytes of the volume read so far.
+	BytesRead int64 `json:"bytesRead"`
+
+	// ChecksumErrors is the number of the files or objects of the volume
+	// zfs has found errors in.
+	ChecksumErrors int64 `json:"checksumErrors"`
+
+	// Errors are the first files or objects of the volume with errors, as
+	// listed by zpool status.
+	Errors []string `json:"errors,omitempty"`
+
+	// Message is the reason the check has failed or has been cancelled.
+	Message string `json:"message,omitempty"`
+
+	// StartTime is when the check has started.
+	StartTime metav1.Time `json:"startTime,omitempty"`
+
+	// CompletionTime is when the check has finished.
+	CompletionTime metav1.Time `json:"completionTime,omitempty"`
+}
+
+// ArchivePhase is the phase of the archive of a volume
+type ArchivePhase string
+
+const (
+	// ArchiveCompleted , the final snapshot has been sent to the target.
+	ArchiveCompleted ArchivePhase = "Completed"
+	// ArchiveFailed , the final snapshot could not be sent, the deletion
+	// of the volume is paused until it is.
+	ArchiveFailed ArchivePhase = "Failed"
+)
+
+// VolumeArchive is the archive of a volume with the ArchiveThenDelete
+// reclaim policy, its final snapshot is sent to the archive target before
+// the volume is destroyed
+type VolumeArchive struct {
+	// Phase is the phase of the archive.
+	// +kubebuilder:validation:Enum=Completed;Failed
+	Phase ArchivePhase `json:"phase"`
+
+	// Snapshot is the zfs snapshot which has been sent, e.g.
+	// zfspv-pool/pvc-1@openebs-archive. It is the snapshot to restore the
+	// volume from once received.
+	Snapshot string `json:"snapshot,omitempty"`
+
+	// Target is the host:port the snapshot has been sent to.
+	Target string `json:"target,omitempty"`
+
+	// Bytes is the size of the stream which has been sent.
+	Bytes int64 `json:"bytes,omitempty"`
+
+	// Message is the reason the archive has failed.
+	Message string `json:"message,omitempty"`
+
+	// Time is when the archive has completed or has last failed.
+	Time metav1.Time `json:"time,omitempty"`
+
+	// Volume is the ZFSVolume which has been archived, it is only set in
+	// the archives of the ZFSNode.
+	Volume string `json:"volume,omitempty"`
+}
+
+// VolumeSpaceUsage is the breakdown of the space used by a volume, as
+// reported by the usedby properties of ZFS, it tells the growth of the
+// live data from the space held by the snapshots
+type VolumeSpaceUsage struct {
+	// UsedBySnapshots is the space held by the snapshots of the volume,
+	// which would be freed if all of them were destroyed.
+	UsedBySnapshots int64 `json:"usedBySnapshots"`
+
+	// UsedByDataset is the space used by the data of the volume itself.
+	UsedByDataset int64 `json:"usedByDataset"`
+
+	// UsedByChildren is the space used by the children of the volume.
+	UsedByChildren int64 `json:"usedByChildren"`
+
+	// UsedByRefreservation is the space set aside by the refreservation
+	// of the volume beyond the space used by its data.
+	UsedByRefreservation int64 `json:"usedByRefreservation"`
+}
+
+// SnapshotVerificationResult is the result of a snapshot verification
+type SnapshotVerificationResult string
+
+const (
+	// SnapshotVerificationPassed , the snapshot has been read without error.
+	SnapshotVerificationPassed SnapshotVerificationResult = "Passed"
+
+	// SnapshotVerificationFailed , the snapshot could not be read.
+	SnapshotVerificationFailed SnapshotVerificationResult = "Failed"
+)
+
+// SnapshotVerification is the result of the verification of a snapshot,
+// all its blocks are read back so that zfs checks their checksums
+type SnapshotVerification struct {
+	// Snapshot is the zfs snapshot which has been verified
+	Snapshot string `json:"snapshot"`
+
+	// Result of the verification
+	// +kubebuilder:validation:Enum=Passed;Failed
+	Result SnapshotVerificationResult `json:"result"`
+
+	// Message is the error found by a failed verification
+	Message string `json:"message,omitempty"`
+
+	// Time is when the verification has been done
+	Time metav1.Time `json:"time,omitempty"`
+}
+
+// CloneOrigin is the origin snapshot of a clone
+type CloneOrigin struct {
+	// Snapshot is the zfs snapshot the clone depends on, as reported by
+	// the origin property, e.g. zfspv-pool/pvc-1@snap-1. It is cleared
+	// once the clone is independent.
+	Snapshot string `json:"snapshot,omitempty"`
+
+	// Volume is the ZFSVolume the origin snapshot belongs to, it is empty
+	// if the snapshot is not of a volume of the driver.
+	Volume string `json:"volume,omitempty"`
+
+	// Independent is true once the clone does not depend on the origin
+	// snapshot anymore, e.g. after a zfs promote or once it has been
+	// replaced by a full copy.
+	Independent bool `json:"independent,omitempty"`
+}
+
+// CloneDivergence is the data written to a clone since it was created
+// from its origin snapshot
+type CloneDivergence struct {
+	// Origin is the snapshot the clone was created from.
+	Origin string `json:"origin"`
+
+	// Written is the bytes written to the clone since it was created,
+	// this data is not shared with the origin snapshot.
+	Written int64 `json:"written"`
+
+	// Referenced is all the bytes referenced by the clone.
+	Referenced int64 `json:"referenced"`
+
+	// Percent is Written compared to Referenced.
+	Percent int32 `json:"percent"`
 }
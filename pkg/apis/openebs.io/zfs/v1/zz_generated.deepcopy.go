@@ -22,9 +22,100 @@ limitations under the License.
 package v1
 
 import (
+	resource "k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CloneDivergence) DeepCopyInto(out *CloneDivergence) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CloneDivergence.
+func (in *CloneDivergence) DeepCopy() *CloneDivergence {
+	if in == nil {
+		return nil
+	}
+	out := new(CloneDivergence)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CloneOrigin) DeepCopyInto(out *CloneOrigin) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CloneOrigin.
+func (in *CloneOrigin) DeepCopy() *CloneOrigin {
+	if in == nil {
+		return nil
+	}
+	out := new(CloneOrigin)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DataCheck) DeepCopyInto(out *DataCheck) {
+	*out = *in
+	if in.Errors != nil {
+		in, out := &in.Errors, &out.Errors
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	in.StartTime.DeepCopyInto(&out.StartTime)
+	in.CompletionTime.DeepCopyInto(&out.CompletionTime)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DataCheck.
+func (in *DataCheck) DeepCopy() *DataCheck {
+	if in == nil {
+		return nil
+	}
+	out := new(DataCheck)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Defragmentation) DeepCopyInto(out *Defragmentation) {
+	*out = *in
+	in.StartTime.DeepCopyInto(&out.StartTime)
+	in.CompletionTime.DeepCopyInto(&out.CompletionTime)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Defragmentation.
+func (in *Defragmentation) DeepCopy() *Defragmentation {
+	if in == nil {
+		return nil
+	}
+	out := new(Defragmentation)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FsTypeTools) DeepCopyInto(out *FsTypeTools) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FsTypeTools.
+func (in *FsTypeTools) DeepCopy() *FsTypeTools {
+	if in == nil {
+		return nil
+	}
+	out := new(FsTypeTools)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Pool) DeepCopyInto(out *Pool) {
 	*out = *in
@@ -43,9 +134,223 @@ func (in *Pool) DeepCopy() *Pool {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PoolImport) DeepCopyInto(out *PoolImport) {
+	*out = *in
+	in.LastAttempt.DeepCopyInto(&out.LastAttempt)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PoolImport.
+func (in *PoolImport) DeepCopy() *PoolImport {
+	if in == nil {
+		return nil
+	}
+	out := new(PoolImport)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PoolDevice) DeepCopyInto(out *PoolDevice) {
+	*out = *in
+	if in.Paths != nil {
+		in, out := &in.Paths, &out.Paths
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PoolDevice.
+func (in *PoolDevice) DeepCopy() *PoolDevice {
+	if in == nil {
+		return nil
+	}
+	out := new(PoolDevice)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PoolScan) DeepCopyInto(out *PoolScan) {
+	*out = *in
+	if in.Remaining != nil {
+		in, out := &in.Remaining, &out.Remaining
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.StartTime != nil {
+		in, out := &in.StartTime, &out.StartTime
+		*out = (*in).DeepCopy()
+	}
+	if in.EndTime != nil {
+		in, out := &in.EndTime, &out.EndTime
+		*out = (*in).DeepCopy()
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PoolScan.
+func (in *PoolScan) DeepCopy() *PoolScan {
+	if in == nil {
+		return nil
+	}
+	out := new(PoolScan)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PoolSummary) DeepCopyInto(out *PoolSummary) {
+	*out = *in
+	out.Size = in.Size.DeepCopy()
+	out.Allocated = in.Allocated.DeepCopy()
+	out.Free = in.Free.DeepCopy()
+	if in.Features != nil {
+		in, out := &in.Features, &out.Features
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.SnapshotUsed != nil {
+		in, out := &in.SnapshotUsed, &out.SnapshotUsed
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Scan != nil {
+		in, out := &in.Scan, &out.Scan
+		*out = new(PoolScan)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Vdevs != nil {
+		in, out := &in.Vdevs, &out.Vdevs
+		*out = make([]PoolVdev, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PoolSummary.
+func (in *PoolSummary) DeepCopy() *PoolSummary {
+	if in == nil {
+		return nil
+	}
+	out := new(PoolSummary)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PoolVdev) DeepCopyInto(out *PoolVdev) {
+	*out = *in
+	if in.Devices != nil {
+		in, out := &in.Devices, &out.Devices
+		*out = make([]PoolDevice, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PoolVdev.
+func (in *PoolVdev) DeepCopy() *PoolVdev {
+	if in == nil {
+		return nil
+	}
+	out := new(PoolVdev)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Preallocation) DeepCopyInto(out *Preallocation) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Preallocation.
+func (in *Preallocation) DeepCopy() *Preallocation {
+	if in == nil {
+		return nil
+	}
+	out := new(Preallocation)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PropertyStatus) DeepCopyInto(out *PropertyStatus) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PropertyStatus.
+func (in *PropertyStatus) DeepCopy() *PropertyStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(PropertyStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SnapshotSpec) DeepCopyInto(out *SnapshotSpec) {
+	*out = *in
+	in.VolumeInfo.DeepCopyInto(&out.VolumeInfo)
+	if in.SnapshotProperties != nil {
+		in, out := &in.SnapshotProperties, &out.SnapshotProperties
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SnapshotSpec.
+func (in *SnapshotSpec) DeepCopy() *SnapshotSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(SnapshotSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SnapStatus) DeepCopyInto(out *SnapStatus) {
 	*out = *in
+	if in.CreationTime != nil {
+		in, out := &in.CreationTime, &out.CreationTime
+		*out = (*in).DeepCopy()
+	}
+	if in.Properties != nil {
+		in, out := &in.Properties, &out.Properties
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	return
 }
 
@@ -59,9 +364,105 @@ func (in *SnapStatus) DeepCopy() *SnapStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SnapshotVerification) DeepCopyInto(out *SnapshotVerification) {
+	*out = *in
+	in.Time.DeepCopyInto(&out.Time)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SnapshotVerification.
+func (in *SnapshotVerification) DeepCopy() *SnapshotVerification {
+	if in == nil {
+		return nil
+	}
+	out := new(SnapshotVerification)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TenantUsage) DeepCopyInto(out *TenantUsage) {
+	*out = *in
+	out.Quota = in.Quota.DeepCopy()
+	if in.Used != nil {
+		in, out := &in.Used, &out.Used
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+	if in.Available != nil {
+		in, out := &in.Available, &out.Available
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TenantUsage.
+func (in *TenantUsage) DeepCopy() *TenantUsage {
+	if in == nil {
+		return nil
+	}
+	out := new(TenantUsage)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *VolStatus) DeepCopyInto(out *VolStatus) {
 	*out = *in
+	if in.SourceVerification != nil {
+		in, out := &in.SourceVerification, &out.SourceVerification
+		*out = new(SnapshotVerification)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Origin != nil {
+		in, out := &in.Origin, &out.Origin
+		*out = new(CloneOrigin)
+		**out = **in
+	}
+	if in.CloneDivergence != nil {
+		in, out := &in.CloneDivergence, &out.CloneDivergence
+		*out = new(CloneDivergence)
+		**out = **in
+	}
+	if in.Preallocation != nil {
+		in, out := &in.Preallocation, &out.Preallocation
+		*out = new(Preallocation)
+		**out = **in
+	}
+	if in.Defragmentation != nil {
+		in, out := &in.Defragmentation, &out.Defragmentation
+		*out = new(Defragmentation)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.DataCheck != nil {
+		in, out := &in.DataCheck, &out.DataCheck
+		*out = new(DataCheck)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Archive != nil {
+		in, out := &in.Archive, &out.Archive
+		*out = new(VolumeArchive)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.SpaceUsage != nil {
+		in, out := &in.SpaceUsage, &out.SpaceUsage
+		*out = new(VolumeSpaceUsage)
+		**out = **in
+	}
+	if in.Properties != nil {
+		in, out := &in.Properties, &out.Properties
+		*out = make([]PropertyStatus, len(*in))
+		copy(*out, *in)
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	return
 }
 
@@ -75,9 +476,33 @@ func (in *VolStatus) DeepCopy() *VolStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VolumeArchive) DeepCopyInto(out *VolumeArchive) {
+	*out = *in
+	in.Time.DeepCopyInto(&out.Time)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VolumeArchive.
+func (in *VolumeArchive) DeepCopy() *VolumeArchive {
+	if in == nil {
+		return nil
+	}
+	out := new(VolumeArchive)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *VolumeInfo) DeepCopyInto(out *VolumeInfo) {
 	*out = *in
+	if in.FreezeParams != nil {
+		in, out := &in.FreezeParams, &out.FreezeParams
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
 	return
 }
 
@@ -91,12 +516,34 @@ func (in *VolumeInfo) DeepCopy() *VolumeInfo {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VolumeSpaceUsage) DeepCopyInto(out *VolumeSpaceUsage) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VolumeSpaceUsage.
+func (in *VolumeSpaceUsage) DeepCopy() *VolumeSpaceUsage {
+	if in == nil {
+		return nil
+	}
+	out := new(VolumeSpaceUsage)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ZFSBackup) DeepCopyInto(out *ZFSBackup) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	out.Spec = in.Spec
+	out.Progress = in.Progress
+	if in.Verification != nil {
+		in, out := &in.Verification, &out.Verification
+		*out = new(SnapshotVerification)
+		(*in).DeepCopyInto(*out)
+	}
 	return
 }
 
@@ -151,6 +598,22 @@ func (in *ZFSBackupList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ZFSBackupProgress) DeepCopyInto(out *ZFSBackupProgress) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ZFSBackupProgress.
+func (in *ZFSBackupProgress) DeepCopy() *ZFSBackupProgress {
+	if in == nil {
+		return nil
+	}
+	out := new(ZFSBackupProgress)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ZFSBackupSpec) DeepCopyInto(out *ZFSBackupSpec) {
 	*out = *in
@@ -179,6 +642,33 @@ func (in *ZFSNode) DeepCopyInto(out *ZFSNode) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.PerformanceClasses != nil {
+		in, out := &in.PerformanceClasses, &out.PerformanceClasses
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.ExpectedPools != nil {
+		in, out := &in.ExpectedPools, &out.ExpectedPools
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.PoolAliases != nil {
+		in, out := &in.PoolAliases, &out.PoolAliases
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.TenantQuotas != nil {
+		in, out := &in.TenantQuotas, &out.TenantQuotas
+		*out = make(map[string]resource.Quantity, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val.DeepCopy()
+		}
+	}
+	in.Status.DeepCopyInto(&out.Status)
 	return
 }
 
@@ -233,13 +723,68 @@ func (in *ZFSNodeList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ZFSNodeStatus) DeepCopyInto(out *ZFSNodeStatus) {
+	*out = *in
+	if in.Pools != nil {
+		in, out := &in.Pools, &out.Pools
+		*out = make([]PoolSummary, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Imports != nil {
+		in, out := &in.Imports, &out.Imports
+		*out = make([]PoolImport, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.FsTypes != nil {
+		in, out := &in.FsTypes, &out.FsTypes
+		*out = make([]FsTypeTools, len(*in))
+		copy(*out, *in)
+	}
+	if in.ZFSVersion != nil {
+		in, out := &in.ZFSVersion, &out.ZFSVersion
+		*out = new(ZFSVersionStatus)
+		**out = **in
+	}
+	if in.Tenants != nil {
+		in, out := &in.Tenants, &out.Tenants
+		*out = make([]TenantUsage, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Archives != nil {
+		in, out := &in.Archives, &out.Archives
+		*out = make([]VolumeArchive, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ZFSNodeStatus.
+func (in *ZFSNodeStatus) DeepCopy() *ZFSNodeStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ZFSNodeStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ZFSRestore) DeepCopyInto(out *ZFSRestore) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	out.Spec = in.Spec
-	out.VolSpec = in.VolSpec
+	in.VolSpec.DeepCopyInto(&out.VolSpec)
+	out.Retry = in.Retry
 	return
 }
 
@@ -294,6 +839,22 @@ func (in *ZFSRestoreList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ZFSRestoreRetry) DeepCopyInto(out *ZFSRestoreRetry) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ZFSRestoreRetry.
+func (in *ZFSRestoreRetry) DeepCopy() *ZFSRestoreRetry {
+	if in == nil {
+		return nil
+	}
+	out := new(ZFSRestoreRetry)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ZFSRestoreSpec) DeepCopyInto(out *ZFSRestoreSpec) {
 	*out = *in
@@ -315,8 +876,8 @@ func (in *ZFSSnapshot) DeepCopyInto(out *ZFSSnapshot) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
-	out.Status = in.Status
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
 	return
 }
 
@@ -371,13 +932,29 @@ func (in *ZFSSnapshotList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ZFSVersionStatus) DeepCopyInto(out *ZFSVersionStatus) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ZFSVersionStatus.
+func (in *ZFSVersionStatus) DeepCopy() *ZFSVersionStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ZFSVersionStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ZFSVolume) DeepCopyInto(out *ZFSVolume) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
-	out.Status = in.Status
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
 	return
 }
 
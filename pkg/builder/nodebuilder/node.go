@@ -106,6 +106,24 @@ func (b *Builder) WithPools(pools []apis.Pool) *Builder {
 	return b
 }
 
+// WithPoolSummary sets the capacity summary of the pools of ZFSNode
+func (b *Builder) WithPoolSummary(summary []apis.PoolSummary) *Builder {
+	b.node.Object.Status.Pools = summary
+	return b
+}
+
+// WithFsTypes sets the filesystem tools installed on the node of ZFSNode
+func (b *Builder) WithFsTypes(fsTypes []apis.FsTypeTools) *Builder {
+	b.node.Object.Status.FsTypes = fsTypes
+	return b
+}
+
+// WithZFSVersion sets the zfs version of the node of ZFSNode
+func (b *Builder) WithZFSVersion(version *apis.ZFSVersionStatus) *Builder {
+	b.node.Object.Status.ZFSVersion = version
+	return b
+}
+
 // WithOwnerReferences sets the owner references of ZFSNode
 func (b *Builder) WithOwnerReferences(ownerRefs ...metav1.OwnerReference) *Builder {
 	b.node.Object.OwnerReferences = ownerRefs
@@ -141,6 +141,18 @@ func (b *Builder) WithRemote(server string) *Builder {
 	return b
 }
 
+// WithRecordSize sets the recordsize of the restored dataset
+func (b *Builder) WithRecordSize(rs string) *Builder {
+	b.rstr.Object.Spec.RecordSize = rs
+	return b
+}
+
+// WithIncremental sets whether the restore receives an incremental stream
+func (b *Builder) WithIncremental(incremental bool) *Builder {
+	b.rstr.Object.Spec.Incremental = incremental
+	return b
+}
+
 // WithLabels merges existing labels if any
 // with the ones that are provided here
 func (b *Builder) WithLabels(labels map[string]string) *Builder {
@@ -101,6 +101,23 @@ func (b *Builder) WithLabels(labels map[string]string) *Builder {
 	return b
 }
 
+// WithAnnotations merges existing annotations if any
+// with the ones that are provided here
+func (b *Builder) WithAnnotations(annotations map[string]string) *Builder {
+	if len(annotations) == 0 {
+		return b
+	}
+
+	if b.snap.Object.Annotations == nil {
+		b.snap.Object.Annotations = map[string]string{}
+	}
+
+	for key, value := range annotations {
+		b.snap.Object.Annotations[key] = value
+	}
+	return b
+}
+
 // WithFinalizer merge existing finalizers if any
 // with the ones that are provided here
 func (b *Builder) WithFinalizer(finalizer []string) *Builder {
@@ -22,6 +22,7 @@ import (
 	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
 	clientset "github.com/openebs/zfs-localpv/pkg/generated/clientset/internalclientset"
 	"github.com/pkg/errors"
+	k8serror "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -383,6 +384,49 @@ func (k *Kubeclient) List(opts metav1.ListOptions) (*apis.ZFSSnapshotList, error
 	return k.list(cli, k.namespace, opts)
 }
 
+// StatusStateField is the field of the state of the zfs snapshots, it can be
+// used in a field selector where the apiserver supports the selectable
+// fields of the CRDs
+const StatusStateField = "status.state"
+
+// ListByStatus lists the zfs snapshots in the given state. The filtering is
+// done by the apiserver with a field selector on the state, and on the
+// client side when the apiserver refuses the field, e.g. the selectable
+// fields of the CRDs are not supported by the kubernetes version.
+func (k *Kubeclient) ListByStatus(state string, opts metav1.ListOptions) (*apis.ZFSSnapshotList, error) {
+	cli, err := k.getClientOrCached()
+	if err != nil {
+		return nil, errors.Wrapf(
+			err,
+			"failed to list zfssnap volumes in namespace {%s}",
+			k.namespace,
+		)
+	}
+
+	serverOpts := opts
+	serverOpts.FieldSelector = StatusStateField + "=" + state
+	if opts.FieldSelector != "" {
+		serverOpts.FieldSelector = opts.FieldSelector + "," + serverOpts.FieldSelector
+	}
+	list, err := k.list(cli, k.namespace, serverOpts)
+	if err == nil || !k8serror.IsBadRequest(err) {
+		return list, err
+	}
+
+	list, err = k.list(cli, k.namespace, opts)
+	if err != nil {
+		return nil, err
+	}
+	items := list.Items[:0]
+	for _, item := range list.Items {
+		if item.Status.State == state {
+			items = append(items, item)
+		}
+	}
+	list.Items = items
+	return list, nil
+}
+
 // Delete deletes the zfssnap volume from
 // kubernetes
 func (k *Kubeclient) Delete(name string) error {
@@ -112,6 +112,12 @@ func (b *Builder) WithKeyLocation(kl string) *Builder {
 	return b
 }
 
+// WithKeySecret sets the Secret holding the encryption key of ZFSVolume
+func (b *Builder) WithKeySecret(secret string) *Builder {
+	b.volume.Object.Spec.KeySecret = secret
+	return b
+}
+
 // WithKeyFormat sets the encryption key format on ZFSVolume
 func (b *Builder) WithKeyFormat(kf string) *Builder {
 	b.volume.Object.Spec.KeyFormat = kf
@@ -130,6 +136,26 @@ func (b *Builder) WithDedup(dedup string) *Builder {
 	return b
 }
 
+// WithRedundantMetadata sets the redundant_metadata of the volume
+func (b *Builder) WithRedundantMetadata(rm string) *Builder {
+	b.volume.Object.Spec.RedundantMetadata = rm
+	return b
+}
+
+// WithSnapshotPolicy sets the policy for the snapshots on volume deletion
+func (b *Builder) WithSnapshotPolicy(policy string) *Builder {
+	b.volume.Object.Spec.SnapshotPolicy = policy
+	return b
+}
+
+// WithReclaimPolicy sets the policy for the data on volume deletion and
+// the target the volume is archived to
+func (b *Builder) WithReclaimPolicy(policy, archiveTarget string) *Builder {
+	b.volume.Object.Spec.ReclaimPolicy = policy
+	b.volume.Object.Spec.ArchiveTarget = archiveTarget
+	return b
+}
+
 // WithThinProv sets if ZFSVolume needs to be thin provisioned
 func (b *Builder) WithThinProv(thinprov string) *Builder {
 	b.volume.Object.Spec.ThinProvision = thinprov
@@ -154,6 +180,30 @@ func (b *Builder) WithVolBlockSize(bs string) *Builder {
 	return b
 }
 
+// WithDnodeSize sets the dnodesize of the dataset
+func (b *Builder) WithDnodeSize(ds string) *Builder {
+	b.volume.Object.Spec.DnodeSize = ds
+	return b
+}
+
+// WithAclType sets the acltype of the dataset
+func (b *Builder) WithAclType(acltype string) *Builder {
+	b.volume.Object.Spec.AclType = acltype
+	return b
+}
+
+// WithAclMode sets the aclmode of the dataset
+func (b *Builder) WithAclMode(aclmode string) *Builder {
+	b.volume.Object.Spec.AclMode = aclmode
+	return b
+}
+
+// WithXattr sets the xattr property of the dataset
+func (b *Builder) WithXattr(xattr string) *Builder {
+	b.volume.Object.Spec.Xattr = xattr
+	return b
+}
+
 // WithVolumeType sets if ZFSVolume needs to be thin provisioned
 func (b *Builder) WithVolumeType(vtype string) *Builder {
 	b.volume.Object.Spec.VolumeType = vtype
@@ -188,6 +238,57 @@ func (b *Builder) WithShared(shared string) *Builder {
 	return b
 }
 
+// WithReadOnly sets whether the volume is read-only
+func (b *Builder) WithReadOnly(readonly string) *Builder {
+	b.volume.Object.Spec.ReadOnly = readonly
+	return b
+}
+
+// WithHostMountPoint sets the path on the node the dataset is mounted at
+func (b *Builder) WithHostMountPoint(path string) *Builder {
+	b.volume.Object.Spec.HostMountPoint = path
+	return b
+}
+
+// WithLayout sets the profile of the child datasets of the dataset
+func (b *Builder) WithLayout(layout string) *Builder {
+	b.volume.Object.Spec.Layout = layout
+	return b
+}
+
+// WithMkfsOptions sets the extra arguments of mkfs formatting the zvol
+func (b *Builder) WithMkfsOptions(options string) *Builder {
+	b.volume.Object.Spec.MkfsOptions = options
+	return b
+}
+
+// WithShareNFS sets the sharenfs property of the dataset
+func (b *Builder) WithShareNFS(sharenfs string) *Builder {
+	b.volume.Object.Spec.ShareNFS = sharenfs
+	return b
+}
+
+// WithShareSMB sets the sharesmb property of the dataset
+func (b *Builder) WithShareSMB(sharesmb string) *Builder {
+	b.volume.Object.Spec.ShareSMB = sharesmb
+	return b
+}
+
+// WithPreallocate sets whether the zvol is written once it is created
+func (b *Builder) WithPreallocate(preallocate string) *Builder {
+	b.volume.Object.Spec.Preallocate = preallocate
+	return b
+}
+
+// WithRootPermissions sets the owner and the mode of the root directory
+// of the filesystem volume
+func (b *Builder) WithRootPermissions(uid, gid, mode string) *Builder {
+	b.volume.Object.Spec.RootUID = uid
+	b.volume.Object.Spec.RootGID = gid
+	b.volume.Object.Spec.RootMode = mode
+	return b
+}
+
 // WithSnapshot sets Snapshot name for creating clone volume
 func (b *Builder) WithSnapshot(snap string) *Builder {
 	b.volume.Object.Spec.SnapName = snap
@@ -209,6 +310,13 @@ func (b *Builder) WithPoolName(pool string) *Builder {
 	return b
 }
 
+// WithDatasetName sets the name of the dataset in the pool when it
+// differs from the volume name
+func (b *Builder) WithDatasetName(name string) *Builder {
+	b.volume.Object.Spec.DatasetName = name
+	return b
+}
+
 // WithNodeName sets NodeID for creating the volume
 func (b *Builder) WithNodeName(name string) *Builder {
 	if name == "" {
@@ -241,6 +349,23 @@ func (b *Builder) WithLabels(labels map[string]string) *Builder {
 	return b
 }
 
+// WithAnnotations merges existing annotations if any
+// with the ones that are provided here
+func (b *Builder) WithAnnotations(annotations map[string]string) *Builder {
+	if len(annotations) == 0 {
+		return b
+	}
+
+	if b.volume.Object.Annotations == nil {
+		b.volume.Object.Annotations = map[string]string{}
+	}
+
+	for key, value := range annotations {
+		b.volume.Object.Annotations[key] = value
+	}
+	return b
+}
+
 // WithFinalizer sets Finalizer name creating the volume
 func (b *Builder) WithFinalizer(finalizer []string) *Builder {
 	b.volume.Object.Finalizers = append(b.volume.Object.Finalizers, finalizer...)
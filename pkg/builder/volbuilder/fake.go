@@ -0,0 +1,33 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volbuilder
+
+import (
+	"github.com/openebs/zfs-localpv/pkg/generated/clientset/internalclientset/fake"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// NewFakeKubeclient returns a kubeclient backed by the fake clientset
+// preloaded with the given objects, it is meant for unit testing the
+// zfs volume operations without a kubernetes cluster. The objects are
+// kept per namespace and the missing ones return NotFound errors as the
+// api server does.
+func NewFakeKubeclient(objects ...runtime.Object) *Kubeclient {
+	k := &Kubeclient{clientset: fake.NewSimpleClientset(objects...)}
+	k.withDefaults()
+	return k
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volbuilder
+
+import (
+	"testing"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	k8serror "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func fakeVolume(name, namespace string) *apis.ZFSVolume {
+	vol, err := NewBuilder().
+		WithName(name).
+		WithNamespace(namespace).
+		WithCapacity("1073741824").
+		WithOwnerNodeID("node1").
+		WithPoolName("zfspv").
+		Build()
+	if err != nil {
+		panic(err)
+	}
+	return vol
+}
+
+func TestFakeKubeclient(t *testing.T) {
+	k := NewFakeKubeclient(fakeVolume("pvc-a", "openebs"), fakeVolume("pvc-b", "other")).
+		WithNamespace("openebs")
+
+	if _, err := k.Create(fakeVolume("pvc-c", "openebs")); err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+	if _, err := k.Create(fakeVolume("pvc-c", "openebs")); !k8serror.IsAlreadyExists(err) {
+		t.Errorf("expected AlreadyExists, got %v", err)
+	}
+
+	vol, err := k.Get("pvc-a", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	if vol.Spec.PoolName != "zfspv" {
+		t.Errorf("expected pool zfspv, got %s", vol.Spec.PoolName)
+	}
+
+	// pvc-b is in another namespace
+	if _, err = k.Get("pvc-b", metav1.GetOptions{}); !k8serror.IsNotFound(err) {
+		t.Errorf("expected NotFound for pvc-b, got %v", err)
+	}
+
+	list, err := k.List(metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("list failed: %v", err)
+	}
+	if len(list.Items) != 2 {
+		t.Errorf("expected 2 volumes, got %d", len(list.Items))
+	}
+
+	vol.Spec.Capacity = "2147483648"
+	if _, err = k.Update(vol); err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+	if vol, _ = k.Get("pvc-a", metav1.GetOptions{}); vol.Spec.Capacity != "2147483648" {
+		t.Errorf("expected updated capacity, got %s", vol.Spec.Capacity)
+	}
+
+	if err = k.Delete("pvc-a"); err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	if _, err = k.Get("pvc-a", metav1.GetOptions{}); !k8serror.IsNotFound(err) {
+		t.Errorf("expected NotFound after delete, got %v", err)
+	}
+	if err = k.Delete("pvc-a"); !k8serror.IsNotFound(err) {
+		t.Errorf("expected NotFound deleting again, got %v", err)
+	}
+}
@@ -22,6 +22,7 @@ import (
 	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
 	clientset "github.com/openebs/zfs-localpv/pkg/generated/clientset/internalclientset"
 	"github.com/pkg/errors"
+	k8serror "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -39,7 +40,7 @@ type getClientsetForPathFn func(kubeConfigPath string) (
 // createFn is a typed function that abstracts
 // creating zfs volume instance
 type createFn func(
-	cs *clientset.Clientset,
+	cs clientset.Interface,
 	upgradeResultObj *apis.ZFSVolume,
 	namespace string,
 ) (*apis.ZFSVolume, error)
@@ -47,7 +48,7 @@ type createFn func(
 // getFn is a typed function that abstracts
 // fetching a zfs volume instance
 type getFn func(
-	cli *clientset.Clientset,
+	cli clientset.Interface,
 	name,
 	namespace string,
 	opts metav1.GetOptions,
@@ -56,7 +57,7 @@ type getFn func(
 // listFn is a typed function that abstracts
 // listing of zfs volume instances
 type listFn func(
-	cli *clientset.Clientset,
+	cli clientset.Interface,
 	namespace string,
 	opts metav1.ListOptions,
 ) (*apis.ZFSVolumeList, error)
@@ -64,7 +65,7 @@ type listFn func(
 // delFn is a typed function that abstracts
 // deleting a zfs volume instance
 type delFn func(
-	cli *clientset.Clientset,
+	cli clientset.Interface,
 	name,
 	namespace string,
 	opts *metav1.DeleteOptions,
@@ -73,7 +74,7 @@ type delFn func(
 // updateFn is a typed function that abstracts
 // updating zfs volume instance
 type updateFn func(
-	cs *clientset.Clientset,
+	cs clientset.Interface,
 	vol *apis.ZFSVolume,
 	namespace string,
 ) (*apis.ZFSVolume, error)
@@ -84,7 +85,7 @@ type Kubeclient struct {
 	// clientset refers to zfs volume's
 	// clientset that will be responsible to
 	// make kubernetes API calls
-	clientset *clientset.Clientset
+	clientset clientset.Interface
 
 	kubeConfigPath string
 
@@ -137,7 +138,7 @@ func defaultGetClientsetForPath(
 // defaultGet is the default implementation to get
 // a zfs volume instance in kubernetes cluster
 func defaultGet(
-	cli *clientset.Clientset,
+	cli clientset.Interface,
 	name, namespace string,
 	opts metav1.GetOptions,
 ) (*apis.ZFSVolume, error) {
@@ -149,7 +150,7 @@ func defaultGet(
 // defaultList is the default implementation to list
 // zfs volume instances in kubernetes cluster
 func defaultList(
-	cli *clientset.Clientset,
+	cli clientset.Interface,
 	namespace string,
 	opts metav1.ListOptions,
 ) (*apis.ZFSVolumeList, error) {
@@ -161,7 +162,7 @@ func defaultList(
 // defaultCreate is the default implementation to delete
 // a zfs volume instance in kubernetes cluster
 func defaultDel(
-	cli *clientset.Clientset,
+	cli clientset.Interface,
 	name, namespace string,
 	opts *metav1.DeleteOptions,
 ) error {
@@ -176,7 +177,7 @@ func defaultDel(
 // defaultCreate is the default implementation to create
 // a zfs volume instance in kubernetes cluster
 func defaultCreate(
-	cli *clientset.Clientset,
+	cli clientset.Interface,
 	vol *apis.ZFSVolume,
 	namespace string,
 ) (*apis.ZFSVolume, error) {
@@ -188,7 +189,7 @@ func defaultCreate(
 // defaultUpdate is the default implementation to update
 // a zfs volume instance in kubernetes cluster
 func defaultUpdate(
-	cli *clientset.Clientset,
+	cli clientset.Interface,
 	vol *apis.ZFSVolume,
 	namespace string,
 ) (*apis.ZFSVolume, error) {
@@ -279,7 +280,7 @@ func (k *Kubeclient) getClientsetForPathOrDirect() (
 
 // getClientOrCached returns either a new instance
 // of kubernetes client or its cached copy
-func (k *Kubeclient) getClientOrCached() (*clientset.Clientset, error) {
+func (k *Kubeclient) getClientOrCached() (clientset.Interface, error) {
 	if k.clientset != nil {
 		return k.clientset, nil
 	}
@@ -383,6 +384,49 @@ func (k *Kubeclient) List(opts metav1.ListOptions) (*apis.ZFSVolumeList, error)
 	return k.list(cli, k.namespace, opts)
 }
 
+// StatusStateField is the field of the state of the zfs volumes, it can be
+// used in a field selector where the apiserver supports the selectable
+// fields of the CRDs
+const StatusStateField = "status.state"
+
+// ListByStatus lists the zfs volumes in the given state. The filtering is
+// done by the apiserver with a field selector on the state, and on the
+// client side when the apiserver refuses the field, e.g. the selectable
+// fields of the CRDs are not supported by the kubernetes version.
+func (k *Kubeclient) ListByStatus(state string, opts metav1.ListOptions) (*apis.ZFSVolumeList, error) {
+	cli, err := k.getClientOrCached()
+	if err != nil {
+		return nil, errors.Wrapf(
+			err,
+			"failed to list zfs volumes in namespace {%s}",
+			k.namespace,
+		)
+	}
+
+	serverOpts := opts
+	serverOpts.FieldSelector = StatusStateField + "=" + state
+	if opts.FieldSelector != "" {
+		serverOpts.FieldSelector = opts.FieldSelector + "," + serverOpts.FieldSelector
+	}
+	list, err := k.list(cli, k.namespace, serverOpts)
+	if err == nil || !k8serror.IsBadRequest(err) {
+		return list, err
+	}
+
+	list, err = k.list(cli, k.namespace, opts)
+	if err != nil {
+		return nil, err
+	}
+	items := list.Items[:0]
+	for _, item := range list.Items {
+		if item.Status.State == state {
+			items = append(items, item)
+		}
+	}
+	list.Items = items
+	return list, nil
+}
+
 // Delete deletes the zfs volume from
 // kubernetes
 func (k *Kubeclient) Delete(name string) error {
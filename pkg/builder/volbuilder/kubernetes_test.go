@@ -0,0 +1,117 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volbuilder
+
+import (
+	"testing"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	clientset "github.com/openebs/zfs-localpv/pkg/generated/clientset/internalclientset"
+	k8serror "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+)
+
+func stateVolume(name, state string) *apis.ZFSVolume {
+	vol := fakeVolume(name, "openebs")
+	vol.Status.State = state
+	return vol
+}
+
+// selectingList filters on the field selector as an apiserver supporting
+// the selectable fields does, or refuses it as an older one does
+func selectingList(selectable bool, selectors *[]string) listFn {
+	return func(cli clientset.Interface, namespace string, opts metav1.ListOptions) (*apis.ZFSVolumeList, error) {
+		*selectors = append(*selectors, opts.FieldSelector)
+		list, err := defaultList(cli, namespace, metav1.ListOptions{})
+		if err != nil || opts.FieldSelector == "" {
+			return list, err
+		}
+		if !selectable {
+			return nil, k8serror.NewBadRequest("field label not supported: " + StatusStateField)
+		}
+		sel, err := fields.ParseSelector(opts.FieldSelector)
+		if err != nil {
+			return nil, err
+		}
+		items := list.Items[:0]
+		for _, vol := range list.Items {
+			if sel.Matches(fields.Set{"metadata.name": vol.Name, StatusStateField: vol.Status.State}) {
+				items = append(items, vol)
+			}
+		}
+		list.Items = items
+		return list, nil
+	}
+}
+
+func TestListByStatus(t *testing.T) {
+	objects := []*apis.ZFSVolume{
+		stateVolume("pvc-a", "Ready"),
+		stateVolume("pvc-b", "Failed"),
+		stateVolume("pvc-c", "Failed"),
+	}
+
+	for _, selectable := range []bool{true, false} {
+		k := NewFakeKubeclient(objects[0], objects[1], objects[2]).WithNamespace("openebs")
+		var selectors []string
+		k.list = selectingList(selectable, &selectors)
+
+		list, err := k.ListByStatus("Failed", metav1.ListOptions{})
+		if err != nil {
+			t.Fatalf("selectable %v: unexpected error %v", selectable, err)
+		}
+		if len(list.Items) != 2 || list.Items[0].Name != "pvc-b" || list.Items[1].Name != "pvc-c" {
+			t.Errorf("selectable %v: got %v", selectable, list.Items)
+		}
+
+		want := 1
+		if !selectable {
+			// refused by the apiserver, listed again without the selector
+			want = 2
+		}
+		if len(selectors) != want || selectors[0] != "status.state=Failed" {
+			t.Errorf("selectable %v: unexpected selectors %q", selectable, selectors)
+		}
+	}
+}
+
+func TestListByStatusKeepsSelector(t *testing.T) {
+	k := NewFakeKubeclient(stateVolume("pvc-a", "Failed"), stateVolume("pvc-b", "Failed")).
+		WithNamespace("openebs")
+	var selectors []string
+	k.list = selectingList(true, &selectors)
+
+	list, err := k.ListByStatus("Failed", metav1.ListOptions{FieldSelector: "metadata.name=pvc-b"})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(list.Items) != 1 || list.Items[0].Name != "pvc-b" {
+		t.Errorf("got %v", list.Items)
+	}
+	if selectors[0] != "metadata.name=pvc-b,status.state=Failed" {
+		t.Errorf("unexpected selector %q", selectors[0])
+	}
+
+	// other errors are not retried
+	k.list = func(clientset.Interface, string, metav1.ListOptions) (*apis.ZFSVolumeList, error) {
+		return nil, k8serror.NewForbidden(apis.Resource("zfsvolumes"), "", nil)
+	}
+	if _, err = k.ListByStatus("Failed", metav1.ListOptions{}); !k8serror.IsForbidden(err) {
+		t.Errorf("expected Forbidden, got %v", err)
+	}
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/klog/v2"
+)
+
+// arcStats is the size and the efficiency of the ARC derived from the
+// arcstats kstat
+type arcStats struct {
+	// Size is the current size of the ARC
+	Size uint64
+	// TargetSize is the size the ARC is trying to reach
+	TargetSize uint64
+	// MFUSize and MRUSize are the sizes of the most frequently and most
+	// recently used lists
+	MFUSize uint64
+	MRUSize uint64
+	// Hits and Misses are the ARC lookups since the module was loaded,
+	// MFUHits and MRUHits the hits served from each list
+	Hits    uint64
+	Misses  uint64
+	MFUHits uint64
+	MRUHits uint64
+}
+
+// HitRatio is the ratio of the ARC lookups served from the cache, 0 if
+// there has not been any lookup yet
+func (s arcStats) HitRatio() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// toARCStats picks the ARC stats from the arcstats counters
+func toARCStats(stats map[string]uint64) arcStats {
+	return arcStats{
+		Size:       stats["size"],
+		TargetSize: stats["c"],
+		MFUSize:    stats["mfu_size"],
+		MRUSize:    stats["mru_size"],
+		Hits:       stats["hits"],
+		Misses:     stats["misses"],
+		MFUHits:    stats["mfu_hits"],
+		MRUHits:    stats["mru_hits"],
+	}
+}
+
+type arcCollector struct {
+	kstatDir string
+	node     string
+
+	size       *prometheus.Desc
+	targetSize *prometheus.Desc
+	mfuSize    *prometheus.Desc
+	mruSize    *prometheus.Desc
+	hitRatio   *prometheus.Desc
+	hits       *prometheus.Desc
+	misses     *prometheus.Desc
+	mfuHits    *prometheus.Desc
+	mruHits    *prometheus.Desc
+
+	// warn only once if the arcstats are not available
+	warnOnce sync.Once
+}
+
+// NewARCCollector returns the collector of the ARC size and efficiency of
+// the node read from the arcstats kstat
+func NewARCCollector(node string) prometheus.Collector {
+	return newARCCollector(KstatDir, node)
+}
+
+func newARCCollector(kstatDir, node string) *arcCollector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "arc", name), help, []string{"node"}, nil)
+	}
+	return &arcCollector{
+		kstatDir:   kstatDir,
+		node:       node,
+		size:       desc("size_bytes", "Current size of the ARC."),
+		targetSize: desc("target_size_bytes", "Size the ARC is trying to reach."),
+		mfuSize:    desc("mfu_size_bytes", "Size of the most frequently used list of the ARC."),
+		mruSize:    desc("mru_size_bytes", "Size of the most recently used list of the ARC."),
+		hitRatio:   desc("hit_ratio", "Ratio of the ARC lookups served from the cache since the module was loaded."),
+		hits:       desc("hits_total", "Number of ARC lookups served from the cache."),
+		misses:     desc("misses_total", "Number of ARC lookups not served from the cache."),
+		mfuHits:    desc("mfu_hits_total", "Number of ARC hits served from the most frequently used list."),
+		mruHits:    desc("mru_hits_total", "Number of ARC hits served from the most recently used list."),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *arcCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{
+		c.size, c.targetSize, c.mfuSize, c.mruSize, c.hitRatio, c.hits, c.misses, c.mfuHits, c.mruHits,
+	} {
+		ch <- desc
+	}
+}
+
+// Collect implements prometheus.Collector
+func (c *arcCollector) Collect(ch chan<- prometheus.Metric) {
+	path := filepath.Join(c.kstatDir, "arcstats")
+	f, err := os.Open(path)
+	if err != nil {
+		// arcstats are not exposed on this platform, report nothing
+		c.warnOnce.Do(func() {
+			klog.Warningf("collector: zfs arcstats not available at %s: %v", path, err)
+		})
+		return
+	}
+	counters, err := parseNamedKstat(f)
+	f.Close()
+	if err != nil {
+		klog.Errorf("collector: failed to parse arcstats kstat: %v", err)
+		return
+	}
+	stats := toARCStats(counters)
+
+	gauge := func(desc *prometheus.Desc, v float64) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v, c.node)
+	}
+	counter := func(desc *prometheus.Desc, v uint64) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, float64(v), c.node)
+	}
+	gauge(c.size, float64(stats.Size))
+	gauge(c.targetSize, float64(stats.TargetSize))
+	gauge(c.mfuSize, float64(stats.MFUSize))
+	gauge(c.mruSize, float64(stats.MRUSize))
+	gauge(c.hitRatio, stats.HitRatio())
+	counter(c.hits, stats.Hits)
+	counter(c.misses, stats.Misses)
+	counter(c.mfuHits, stats.MFUHits)
+	counter(c.mruHits, stats.MRUHits)
+}
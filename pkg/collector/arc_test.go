@@ -0,0 +1,75 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const arcstats = `13 1 0x01 123 33456 4413633334 1183931798420
+name                            type data
+hits                            4    9000
+misses                          4    1000
+demand_data_hits                4    7000
+mru_hits                        4    3000
+mfu_hits                        4    5500
+p                               4    536870912
+c                               4    2147483648
+c_min                           4    134217728
+c_max                           4    4294967296
+size                            4    1073741824
+mru_size                        4    402653184
+mfu_size                        4    536870912
+arc_no_grow                     4    0
+`
+
+func TestParseARCStats(t *testing.T) {
+	counters, err := parseNamedKstat(strings.NewReader(arcstats))
+	assert.NoError(t, err)
+
+	stats := toARCStats(counters)
+	assert.Equal(t, uint64(1073741824), stats.Size)
+	assert.Equal(t, uint64(2147483648), stats.TargetSize)
+	assert.Equal(t, uint64(536870912), stats.MFUSize)
+	assert.Equal(t, uint64(402653184), stats.MRUSize)
+	assert.Equal(t, uint64(5500), stats.MFUHits)
+	assert.Equal(t, uint64(3000), stats.MRUHits)
+	assert.Equal(t, 0.9, stats.HitRatio())
+
+	// no lookup yet
+	assert.Equal(t, float64(0), arcStats{}.HitRatio())
+}
+
+func TestARCCollector(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "arcstats"), []byte(arcstats), 0644))
+
+	// 5 gauges and 4 counters
+	assert.Equal(t, 9, collect(newARCCollector(dir, "node1")))
+
+	// a malformed kstat is not reported
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "arcstats"), []byte("garbage\n"), 0644))
+	assert.Equal(t, 0, collect(newARCCollector(dir, "node1")))
+
+	// arcstats not exposed, nothing is reported
+	assert.Equal(t, 0, collect(newARCCollector(filepath.Join(dir, "missing"), "node1")))
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package collector exposes the zfs stats of the node as prometheus metrics
+package collector
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/klog/v2"
+)
+
+// KstatDir is the directory where the zfs module exposes its kstats
+const KstatDir = "/proc/spl/kstat/zfs"
+
+const namespace = "zfs"
+
+// dmu_tx counters reporting the write throttle
+var dmuTxCounters = map[string]string{
+	"dmu_tx_delay":          "Number of times a transaction was delayed by the write throttle.",
+	"dmu_tx_dirty_throttle": "Number of times a transaction was throttled because of too much dirty data.",
+	"dmu_tx_dirty_delay":    "Number of times a transaction was delayed because of dirty data.",
+	"dmu_tx_dirty_over_max": "Number of times the dirty data went over zfs_dirty_data_max.",
+}
+
+type poolCollector struct {
+	kstatDir string
+
+	dirtyBytes  *prometheus.Desc
+	syncSeconds *prometheus.Desc
+	waitSeconds *prometheus.Desc
+	dmuTx       map[string]*prometheus.Desc
+
+	// warn only once if the kstats are not available
+	warnOnce sync.Once
+}
+
+// NewPoolCollector returns the collector of the per pool txg write
+// pressure and the write throttle counters read from the zfs kstats
+func NewPoolCollector() prometheus.Collector {
+	return newPoolCollector(KstatDir)
+}
+
+func newPoolCollector(kstatDir string) *poolCollector {
+	c := &poolCollector{
+		kstatDir: kstatDir,
+		dirtyBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "pool", "dirty_data_bytes"),
+			"Dirty data of the open txg of the pool.",
+			[]string{"pool"}, nil,
+		),
+		syncSeconds: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "pool", "txg_sync_seconds"),
+			"Time taken to sync the last committed txg of the pool.",
+			[]string{"pool"}, nil,
+		),
+		waitSeconds: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "pool", "txg_wait_seconds"),
+			"Time the last committed txg of the pool waited before getting synced.",
+			[]string{"pool"}, nil,
+		),
+		dmuTx: map[string]*prometheus.Desc{},
+	}
+	for name, help := range dmuTxCounters {
+		c.dmuTx[name] = prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", name+"_total"), help, nil, nil,
+		)
+	}
+	return c
+}
+
+// Describe implements prometheus.Collector
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.dirtyBytes
+	ch <- c.syncSeconds
+	ch <- c.waitSeconds
+	for _, desc := range c.dmuTx {
+		ch <- desc
+	}
+}
+
+// Collect implements prometheus.Collector
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	if _, err := os.Stat(c.kstatDir); err != nil {
+		// kstats are not exposed on this platform, report nothing
+		c.warnOnce.Do(func() {
+			klog.Warningf("collector: zfs kstats not available at %s: %v", c.kstatDir, err)
+		})
+		return
+	}
+
+	if f, err := os.Open(filepath.Join(c.kstatDir, "dmu_tx")); err == nil {
+		stats, err := parseNamedKstat(f)
+		f.Close()
+		if err != nil {
+			klog.Errorf("collector: failed to parse dmu_tx kstat: %v", err)
+		}
+		for name, desc := range c.dmuTx {
+			if v, ok := stats[name]; ok {
+				ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, float64(v))
+			}
+		}
+	}
+
+	txgs, err := filepath.Glob(filepath.Join(c.kstatDir, "*", "txgs"))
+	if err != nil {
+		return
+	}
+	for _, path := range txgs {
+		pool := filepath.Base(filepath.Dir(path))
+		f, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		stats, err := parseTxgs(f)
+		f.Close()
+		if err != nil {
+			klog.Errorf("collector: failed to parse txgs kstat of pool %s: %v", pool, err)
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(c.dirtyBytes, prometheus.GaugeValue, float64(stats.DirtyBytes), pool)
+		ch <- prometheus.MustNewConstMetric(c.syncSeconds, prometheus.GaugeValue, stats.SyncSeconds, pool)
+		ch <- prometheus.MustNewConstMetric(c.waitSeconds, prometheus.GaugeValue, stats.WaitSeconds, pool)
+	}
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// PoolDatasets tracks the number of datasets of each pool, as last listed
+// by the node agent
+type PoolDatasets struct {
+	mu    sync.Mutex
+	pools map[string]int64
+
+	countDesc *prometheus.Desc
+}
+
+// Datasets is the number of datasets of the pools of the node agent
+var Datasets = NewPoolDatasets()
+
+// NewPoolDatasets returns an empty tracker of the datasets
+func NewPoolDatasets() *PoolDatasets {
+	return &PoolDatasets{
+		pools: map[string]int64{},
+		countDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "pool", "dataset_count"),
+			"Number of datasets, the filesystems and the zvols, in the pool.",
+			[]string{"pool"}, nil,
+		),
+	}
+}
+
+// Reset replaces the number of datasets of all the pools, the pools
+// missing from the given ones are not reported anymore
+func (p *PoolDatasets) Reset(pools map[string]int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pools = pools
+}
+
+// Describe implements prometheus.Collector
+func (p *PoolDatasets) Describe(ch chan<- *prometheus.Desc) {
+	ch <- p.countDesc
+}
+
+// Collect implements prometheus.Collector
+func (p *PoolDatasets) Collect(ch chan<- prometheus.Metric) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for pool, count := range p.pools {
+		ch <- prometheus.MustNewConstMetric(p.countDesc, prometheus.GaugeValue, float64(count), pool)
+	}
+}
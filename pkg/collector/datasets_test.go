@@ -0,0 +1,43 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPoolDatasets(t *testing.T) {
+	p := NewPoolDatasets()
+	assert.Equal(t, 0, collect(p))
+
+	p.Reset(map[string]int64{"zfspv-pool": 12034, "backup": 1})
+	want := `
+# HELP zfs_pool_dataset_count Number of datasets, the filesystems and the zvols, in the pool.
+# TYPE zfs_pool_dataset_count gauge
+zfs_pool_dataset_count{pool="backup"} 1
+zfs_pool_dataset_count{pool="zfspv-pool"} 12034
+`
+	assert.NoError(t, testutil.CollectAndCompare(p, strings.NewReader(want)))
+
+	// the pools gone are not reported anymore
+	p.Reset(map[string]int64{"zfspv-pool": 12035})
+	assert.Equal(t, 1, collect(p))
+}
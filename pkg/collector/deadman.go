@@ -0,0 +1,89 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DeadmanState is the hung IO detection of a pool
+type DeadmanState struct {
+	// Events is the number of deadman events within the detection window
+	Events int64
+	// Hung tells whether the events have reached the threshold
+	Hung bool
+}
+
+// PoolDeadman tracks the hung IO detection of each pool, as last checked
+// by the node agent
+type PoolDeadman struct {
+	mu    sync.Mutex
+	pools map[string]DeadmanState
+
+	eventsDesc *prometheus.Desc
+	hungDesc   *prometheus.Desc
+}
+
+// Deadman is the hung IO detection of the pools of the node agent
+var Deadman = NewPoolDeadman()
+
+// NewPoolDeadman returns an empty tracker of the hung IO detection
+func NewPoolDeadman() *PoolDeadman {
+	return &PoolDeadman{
+		pools: map[string]DeadmanState{},
+		eventsDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "pool", "deadman_events"),
+			"Number of deadman events of the pool within the detection window.",
+			[]string{"pool"}, nil,
+		),
+		hungDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "pool", "io_hung"),
+			"Whether zfs has detected hung IO on the pool, 1 if so.",
+			[]string{"pool"}, nil,
+		),
+	}
+}
+
+// Reset replaces the hung IO detection of all the pools, the pools missing
+// from the given ones are not reported anymore
+func (p *PoolDeadman) Reset(pools map[string]DeadmanState) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pools = pools
+}
+
+// Describe implements prometheus.Collector
+func (p *PoolDeadman) Describe(ch chan<- *prometheus.Desc) {
+	ch <- p.eventsDesc
+	ch <- p.hungDesc
+}
+
+// Collect implements prometheus.Collector
+func (p *PoolDeadman) Collect(ch chan<- prometheus.Metric) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for pool, state := range p.pools {
+		var hung float64
+		if state.Hung {
+			hung = 1
+		}
+		ch <- prometheus.MustNewConstMetric(p.eventsDesc, prometheus.GaugeValue, float64(state.Events), pool)
+		ch <- prometheus.MustNewConstMetric(p.hungDesc, prometheus.GaugeValue, hung, pool)
+	}
+}
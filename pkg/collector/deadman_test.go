@@ -0,0 +1,50 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPoolDeadman(t *testing.T) {
+	p := NewPoolDeadman()
+	assert.Equal(t, 0, collect(p))
+
+	p.Reset(map[string]DeadmanState{
+		"zfspv-pool": {Events: 3, Hung: true},
+		"backup":     {},
+	})
+	want := `
+# HELP zfs_pool_deadman_events Number of deadman events of the pool within the detection window.
+# TYPE zfs_pool_deadman_events gauge
+zfs_pool_deadman_events{pool="backup"} 0
+zfs_pool_deadman_events{pool="zfspv-pool"} 3
+# HELP zfs_pool_io_hung Whether zfs has detected hung IO on the pool, 1 if so.
+# TYPE zfs_pool_io_hung gauge
+zfs_pool_io_hung{pool="backup"} 0
+zfs_pool_io_hung{pool="zfspv-pool"} 1
+`
+	assert.NoError(t, testutil.CollectAndCompare(p, strings.NewReader(want)))
+
+	// the pools gone are not reported anymore
+	p.Reset(map[string]DeadmanState{"zfspv-pool": {}})
+	assert.Equal(t, 2, collect(p))
+}
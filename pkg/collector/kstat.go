@@ -0,0 +1,148 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// txg states as reported in the txgs kstat
+const (
+	txgStateOpen      = "O"
+	txgStateCommitted = "C"
+)
+
+// txgStats is the write pressure of a pool derived from its txgs kstat
+type txgStats struct {
+	// DirtyBytes is the dirty data of the open txg
+	DirtyBytes uint64
+	// SyncSeconds is the time taken to sync the last committed txg
+	SyncSeconds float64
+	// WaitSeconds is the time the last committed txg waited to be synced
+	WaitSeconds float64
+}
+
+// parseTxgs parses the /proc/spl/kstat/zfs/<pool>/txgs kstat, which has a
+// header line followed by one line per recent txg:
+//
+//	txg birth state ndirty nread nwritten reads writes otime qtime wtime stime
+//
+// the times are in nanoseconds.
+func parseTxgs(r io.Reader) (txgStats, error) {
+	var stats txgStats
+	var header map[string]int
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if header == nil {
+			if fields[0] != "txg" {
+				// skip the kstat preamble if present
+				continue
+			}
+			header = make(map[string]int, len(fields))
+			for i, f := range fields {
+				header[f] = i
+			}
+			for _, col := range []string{"state", "ndirty", "wtime", "stime"} {
+				if _, ok := header[col]; !ok {
+					return stats, fmt.Errorf("txgs: missing column %s", col)
+				}
+			}
+			continue
+		}
+		if len(fields) != len(header) {
+			return stats, fmt.Errorf("txgs: malformed line %q", scanner.Text())
+		}
+
+		col := func(name string) (uint64, error) {
+			v, err := strconv.ParseUint(fields[header[name]], 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("txgs: invalid %s %q", name, fields[header[name]])
+			}
+			return v, nil
+		}
+
+		// the lines are sorted by txg, the last one of each state wins
+		switch fields[header["state"]] {
+		case txgStateOpen:
+			ndirty, err := col("ndirty")
+			if err != nil {
+				return stats, err
+			}
+			stats.DirtyBytes = ndirty
+		case txgStateCommitted:
+			wtime, err := col("wtime")
+			if err != nil {
+				return stats, err
+			}
+			stime, err := col("stime")
+			if err != nil {
+				return stats, err
+			}
+			stats.WaitSeconds = float64(wtime) / 1e9
+			stats.SyncSeconds = float64(stime) / 1e9
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return stats, err
+	}
+	if header == nil {
+		return stats, fmt.Errorf("txgs: header not found")
+	}
+	return stats, nil
+}
+
+// parseNamedKstat parses a named kstat like /proc/spl/kstat/zfs/dmu_tx
+// which has a preamble line, a "name type data" header and one line per
+// counter. It returns the counters by their name.
+func parseNamedKstat(r io.Reader) (map[string]uint64, error) {
+	stats := map[string]uint64{}
+	inData := false
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if !inData {
+			inData = len(fields) == 3 && fields[0] == "name" && fields[1] == "type" && fields[2] == "data"
+			continue
+		}
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("kstat: malformed line %q", scanner.Text())
+		}
+		v, err := strconv.ParseUint(fields[2], 10, 64)
+		if err != nil {
+			// not a numeric counter
+			continue
+		}
+		stats[fields[0]] = v
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if !inData {
+		return nil, fmt.Errorf("kstat: header not found")
+	}
+	return stats, nil
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+const txgs = `txg      birth            state ndirty       nread        nwritten     reads    writes   otime        qtime        wtime        stime
+4121     2621328829156    C     1048576      0            2097152      0        12       5000115473   30410        1998901      250000000
+4122     2626328944629    C     524288       0            1048576      0        8        5000100468   31129        3000000      500000000
+4123     2631329045097    S     0            0            0            0        0        5000121924   28359        29944        0
+4124     2636329167021    O     8388608      0            0            0        0        0            0            0            0
+`
+
+const dmuTx = `5 1 0x01 13 3536 4413633334 1183931798420
+name                            type data
+dmu_tx_assigned                 4    1231
+dmu_tx_delay                    4    7
+dmu_tx_error                    4    0
+dmu_tx_dirty_throttle           4    3
+dmu_tx_dirty_delay              4    42
+dmu_tx_dirty_over_max           4    1
+`
+
+func TestParseTxgs(t *testing.T) {
+	stats, err := parseTxgs(strings.NewReader(txgs))
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(8388608), stats.DirtyBytes)
+	assert.Equal(t, 0.5, stats.SyncSeconds)
+	assert.Equal(t, 0.003, stats.WaitSeconds)
+
+	// txg history disabled, only the header is present
+	stats, err = parseTxgs(strings.NewReader(strings.SplitN(txgs, "\n", 2)[0] + "\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, txgStats{}, stats)
+
+	_, err = parseTxgs(strings.NewReader(""))
+	assert.Error(t, err)
+
+	_, err = parseTxgs(strings.NewReader("txg birth state\n1 2 C\n"))
+	assert.Error(t, err)
+
+	_, err = parseTxgs(strings.NewReader(strings.Replace(txgs, "8388608", "bad", 1)))
+	assert.Error(t, err)
+}
+
+func TestParseNamedKstat(t *testing.T) {
+	stats, err := parseNamedKstat(strings.NewReader(dmuTx))
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(7), stats["dmu_tx_delay"])
+	assert.Equal(t, uint64(3), stats["dmu_tx_dirty_throttle"])
+	assert.Equal(t, uint64(42), stats["dmu_tx_dirty_delay"])
+	assert.Len(t, stats, 6)
+
+	_, err = parseNamedKstat(strings.NewReader("garbage\n"))
+	assert.Error(t, err)
+}
+
+func collect(c prometheus.Collector) int {
+	ch := make(chan prometheus.Metric, 64)
+	c.Collect(ch)
+	close(ch)
+	return len(ch)
+}
+
+func TestPoolCollector(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "zfspv"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "zfspv", "txgs"), []byte(txgs), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "dmu_tx"), []byte(dmuTx), 0644))
+
+	// 3 pool metrics and 4 dmu_tx counters
+	assert.Equal(t, 7, collect(newPoolCollector(dir)))
+
+	// kstats not exposed, nothing is reported
+	assert.Equal(t, 0, collect(newPoolCollector(filepath.Join(dir, "missing"))))
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"strconv"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// LatencyQuantile is a quantile of the latency of the reads or the writes
+// of a pool
+type LatencyQuantile struct {
+	// Operation is read or write
+	Operation string
+	// Wait is total for the time from the IO being queued by zfs to its
+	// completion, or disk for the time spent in the device
+	Wait string
+	// Quantile is the part of the IOs completed within Seconds, e.g. 0.99
+	Quantile float64
+	Seconds  float64
+}
+
+// PoolLatency tracks the latency quantiles of the IOs of each pool, as
+// last checked by the node agent
+type PoolLatency struct {
+	mu    sync.Mutex
+	pools map[string][]LatencyQuantile
+
+	latencyDesc *prometheus.Desc
+}
+
+// Latency is the latency of the IOs of the pools of the node agent
+var Latency = NewPoolLatency()
+
+// NewPoolLatency returns an empty tracker of the latency
+func NewPoolLatency() *PoolLatency {
+	return &PoolLatency{
+		pools: map[string][]LatencyQuantile{},
+		latencyDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "pool", "io_latency_seconds"),
+			"Latency the given quantile of the reads or the writes of the pool completed within, since the last check.",
+			[]string{"pool", "operation", "wait", "quantile"}, nil,
+		),
+	}
+}
+
+// Reset replaces the latency of all the pools, the pools missing from the
+// given ones are not reported anymore
+func (p *PoolLatency) Reset(pools map[string][]LatencyQuantile) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pools = pools
+}
+
+// Describe implements prometheus.Collector
+func (p *PoolLatency) Describe(ch chan<- *prometheus.Desc) {
+	ch <- p.latencyDesc
+}
+
+// Collect implements prometheus.Collector
+func (p *PoolLatency) Collect(ch chan<- prometheus.Metric) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for pool, quantiles := range p.pools {
+		for _, q := range quantiles {
+			ch <- prometheus.MustNewConstMetric(p.latencyDesc, prometheus.GaugeValue, q.Seconds,
+				pool, q.Operation, q.Wait, strconv.FormatFloat(q.Quantile, 'f', -1, 64))
+		}
+	}
+}
@@ -0,0 +1,49 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPoolLatency(t *testing.T) {
+	p := NewPoolLatency()
+	assert.Equal(t, 0, collect(p))
+
+	p.Reset(map[string][]LatencyQuantile{
+		"zfspv-pool": {
+			{Operation: "read", Wait: "total", Quantile: 0.5, Seconds: 0.000262143},
+			{Operation: "read", Wait: "total", Quantile: 0.99, Seconds: 0.016777215},
+			{Operation: "write", Wait: "disk", Quantile: 0.9, Seconds: 0.004194303},
+		},
+	})
+	want := `
+# HELP zfs_pool_io_latency_seconds Latency the given quantile of the reads or the writes of the pool completed within, since the last check.
+# TYPE zfs_pool_io_latency_seconds gauge
+zfs_pool_io_latency_seconds{operation="read",pool="zfspv-pool",quantile="0.5",wait="total"} 0.000262143
+zfs_pool_io_latency_seconds{operation="read",pool="zfspv-pool",quantile="0.99",wait="total"} 0.016777215
+zfs_pool_io_latency_seconds{operation="write",pool="zfspv-pool",quantile="0.9",wait="disk"} 0.004194303
+`
+	assert.NoError(t, testutil.CollectAndCompare(p, strings.NewReader(want)))
+
+	p.Reset(nil)
+	assert.Equal(t, 0, collect(p))
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// FillState is the fill of a pool
+type FillState struct {
+	// Percent is the percent of the size of the pool allocated
+	Percent int64
+	// Full tells whether the percent has reached the threshold
+	Full bool
+}
+
+// PoolFill tracks the fill of each pool, as last checked by the node agent
+type PoolFill struct {
+	mu    sync.Mutex
+	pools map[string]FillState
+
+	percentDesc *prometheus.Desc
+	fullDesc    *prometheus.Desc
+}
+
+// Fill is the fill of the pools of the node agent
+var Fill = NewPoolFill()
+
+// NewPoolFill returns an empty tracker of the fill of the pools
+func NewPoolFill() *PoolFill {
+	return &PoolFill{
+		pools: map[string]FillState{},
+		percentDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "pool", "fill_percent"),
+			"Percent of the size of the pool allocated.",
+			[]string{"pool"}, nil,
+		),
+		fullDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "pool", "full"),
+			"Whether the pool is filled above the threshold, 1 if so.",
+			[]string{"pool"}, nil,
+		),
+	}
+}
+
+// Reset replaces the fill of all the pools, the pools missing from the
+// given ones are not reported anymore
+func (p *PoolFill) Reset(pools map[string]FillState) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pools = pools
+}
+
+// Describe implements prometheus.Collector
+func (p *PoolFill) Describe(ch chan<- *prometheus.Desc) {
+	ch <- p.percentDesc
+	ch <- p.fullDesc
+}
+
+// Collect implements prometheus.Collector
+func (p *PoolFill) Collect(ch chan<- prometheus.Metric) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for pool, state := range p.pools {
+		var full float64
+		if state.Full {
+			full = 1
+		}
+		ch <- prometheus.MustNewConstMetric(p.percentDesc, prometheus.GaugeValue, float64(state.Percent), pool)
+		ch <- prometheus.MustNewConstMetric(p.fullDesc, prometheus.GaugeValue, full, pool)
+	}
+}
@@ -0,0 +1,50 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPoolFill(t *testing.T) {
+	p := NewPoolFill()
+	assert.Equal(t, 0, collect(p))
+
+	p.Reset(map[string]FillState{
+		"zfspv-pool": {Percent: 93, Full: true},
+		"backup":     {Percent: 12},
+	})
+	want := `
+# HELP zfs_pool_fill_percent Percent of the size of the pool allocated.
+# TYPE zfs_pool_fill_percent gauge
+zfs_pool_fill_percent{pool="backup"} 12
+zfs_pool_fill_percent{pool="zfspv-pool"} 93
+# HELP zfs_pool_full Whether the pool is filled above the threshold, 1 if so.
+# TYPE zfs_pool_full gauge
+zfs_pool_full{pool="backup"} 0
+zfs_pool_full{pool="zfspv-pool"} 1
+`
+	assert.NoError(t, testutil.CollectAndCompare(p, strings.NewReader(want)))
+
+	// the pools gone are not reported anymore
+	p.Reset(map[string]FillState{"zfspv-pool": {}})
+	assert.Equal(t, 2, collect(p))
+}
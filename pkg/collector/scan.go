@@ -0,0 +1,135 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ScanProgress is the progress of the scrub or the resilver of a pool
+type ScanProgress struct {
+	// Function is scrub or resilver
+	Function string
+	// Active tells whether the scan is in progress
+	Active bool
+	// Percent is the part of the data the scan has gone through
+	Percent float64
+	// Issued, Total and Processed are the bytes read or written so far,
+	// the bytes to scan and the bytes repaired or rebuilt
+	Issued, Total, Processed int64
+	// Remaining is the estimated seconds to complete the scan, it is
+	// negative if zfs can not estimate it
+	Remaining float64
+}
+
+// PoolScans tracks the progress of the scrubs and the resilvers of each
+// pool, as last checked by the node agent
+type PoolScans struct {
+	mu    sync.Mutex
+	pools map[string]ScanProgress
+
+	activeDesc    *prometheus.Desc
+	percentDesc   *prometheus.Desc
+	issuedDesc    *prometheus.Desc
+	totalDesc     *prometheus.Desc
+	processedDesc *prometheus.Desc
+	remainingDesc *prometheus.Desc
+}
+
+// Scans is the progress of the scans of the pools of the node agent
+var Scans = NewPoolScans()
+
+// NewPoolScans returns an empty tracker of the scans
+func NewPoolScans() *PoolScans {
+	labels := []string{"pool", "function"}
+	return &PoolScans{
+		pools: map[string]ScanProgress{},
+		activeDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "pool", "scan_active"),
+			"Whether a scrub or a resilver of the pool is in progress, 1 if so.",
+			labels, nil,
+		),
+		percentDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "pool", "scan_percent"),
+			"Percent of the data of the pool the last scrub or resilver has gone through.",
+			labels, nil,
+		),
+		issuedDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "pool", "scan_issued_bytes"),
+			"Bytes read or written so far by the scrub or the resilver in progress.",
+			labels, nil,
+		),
+		totalDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "pool", "scan_total_bytes"),
+			"Bytes to be scanned by the scrub or the resilver in progress.",
+			labels, nil,
+		),
+		processedDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "pool", "scan_processed_bytes"),
+			"Bytes repaired by the last scrub or rebuilt by the last resilver of the pool.",
+			labels, nil,
+		),
+		remainingDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "pool", "scan_remaining_seconds"),
+			"Estimated time to complete the scrub or the resilver in progress, only reported once zfs can estimate it.",
+			labels, nil,
+		),
+	}
+}
+
+// Reset replaces the scans of all the pools, the pools missing from the
+// given ones are not reported anymore
+func (p *PoolScans) Reset(pools map[string]ScanProgress) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pools = pools
+}
+
+// Describe implements prometheus.Collector
+func (p *PoolScans) Describe(ch chan<- *prometheus.Desc) {
+	ch <- p.activeDesc
+	ch <- p.percentDesc
+	ch <- p.issuedDesc
+	ch <- p.totalDesc
+	ch <- p.processedDesc
+	ch <- p.remainingDesc
+}
+
+// Collect implements prometheus.Collector
+func (p *PoolScans) Collect(ch chan<- prometheus.Metric) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for pool, scan := range p.pools {
+		var active float64
+		if scan.Active {
+			active = 1
+		}
+		ch <- prometheus.MustNewConstMetric(p.activeDesc, prometheus.GaugeValue, active, pool, scan.Function)
+		ch <- prometheus.MustNewConstMetric(p.percentDesc, prometheus.GaugeValue, scan.Percent, pool, scan.Function)
+		ch <- prometheus.MustNewConstMetric(p.processedDesc, prometheus.GaugeValue, float64(scan.Processed), pool, scan.Function)
+		if !scan.Active {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(p.issuedDesc, prometheus.GaugeValue, float64(scan.Issued), pool, scan.Function)
+		ch <- prometheus.MustNewConstMetric(p.totalDesc, prometheus.GaugeValue, float64(scan.Total), pool, scan.Function)
+		if scan.Remaining >= 0 {
+			ch <- prometheus.MustNewConstMetric(p.remainingDesc, prometheus.GaugeValue, scan.Remaining, pool, scan.Function)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPoolScans(t *testing.T) {
+	p := NewPoolScans()
+	assert.Equal(t, 0, collect(p))
+
+	p.Reset(map[string]ScanProgress{
+		"zfspv-pool": {Function: "resilver", Active: true, Percent: 20, Issued: 2 << 30,
+			Total: 10 << 30, Processed: 2 << 30, Remaining: 36},
+		"backup": {Function: "scrub", Percent: 100, Processed: 4096, Remaining: -1},
+	})
+	want := `
+# HELP zfs_pool_scan_active Whether a scrub or a resilver of the pool is in progress, 1 if so.
+# TYPE zfs_pool_scan_active gauge
+zfs_pool_scan_active{function="resilver",pool="zfspv-pool"} 1
+zfs_pool_scan_active{function="scrub",pool="backup"} 0
+# HELP zfs_pool_scan_issued_bytes Bytes read or written so far by the scrub or the resilver in progress.
+# TYPE zfs_pool_scan_issued_bytes gauge
+zfs_pool_scan_issued_bytes{function="resilver",pool="zfspv-pool"} 2.147483648e+09
+# HELP zfs_pool_scan_percent Percent of the data of the pool the last scrub or resilver has gone through.
+# TYPE zfs_pool_scan_percent gauge
+zfs_pool_scan_percent{function="resilver",pool="zfspv-pool"} 20
+zfs_pool_scan_percent{function="scrub",pool="backup"} 100
+# HELP zfs_pool_scan_processed_bytes Bytes repaired by the last scrub or rebuilt by the last resilver of the pool.
+# TYPE zfs_pool_scan_processed_bytes gauge
+zfs_pool_scan_processed_bytes{function="resilver",pool="zfspv-pool"} 2.147483648e+09
+zfs_pool_scan_processed_bytes{function="scrub",pool="backup"} 4096
+# HELP zfs_pool_scan_remaining_seconds Estimated time to complete the scrub or the resilver in progress, only reported once zfs can estimate it.
+# TYPE zfs_pool_scan_remaining_seconds gauge
+zfs_pool_scan_remaining_seconds{function="resilver",pool="zfspv-pool"} 36
+# HELP zfs_pool_scan_total_bytes Bytes to be scanned by the scrub or the resilver in progress.
+# TYPE zfs_pool_scan_total_bytes gauge
+zfs_pool_scan_total_bytes{function="resilver",pool="zfspv-pool"} 1.073741824e+10
+`
+	assert.NoError(t, testutil.CollectAndCompare(p, strings.NewReader(want)))
+
+	// the pools gone are not reported anymore
+	p.Reset(map[string]ScanProgress{"zfspv-pool": {Function: "scrub", Remaining: -1}})
+	assert.Equal(t, 3, collect(p))
+}
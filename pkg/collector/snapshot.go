@@ -0,0 +1,86 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// SnapshotUsage is the space used by the snapshots of a pool
+type SnapshotUsage struct {
+	// Used is the sum of the space used by each snapshot in bytes
+	Used int64
+	// Count is the number of snapshots
+	Count int64
+}
+
+// PoolSnapshots tracks the space used by the snapshots of each pool, as
+// last listed by the node agent
+type PoolSnapshots struct {
+	mu    sync.Mutex
+	pools map[string]SnapshotUsage
+
+	usedDesc  *prometheus.Desc
+	countDesc *prometheus.Desc
+}
+
+// SnapshotSpace is the space used by the snapshots of the pools of the
+// node agent
+var SnapshotSpace = NewPoolSnapshots()
+
+// NewPoolSnapshots returns an empty tracker of the snapshot space
+func NewPoolSnapshots() *PoolSnapshots {
+	return &PoolSnapshots{
+		pools: map[string]SnapshotUsage{},
+		usedDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "pool", "snapshot_used_bytes"),
+			"Sum of the space used by the snapshots of the pool.",
+			[]string{"pool"}, nil,
+		),
+		countDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "pool", "snapshots"),
+			"Number of snapshots in the pool.",
+			[]string{"pool"}, nil,
+		),
+	}
+}
+
+// Reset replaces the snapshot space of all the pools, the pools missing
+// from the given ones are not reported anymore
+func (p *PoolSnapshots) Reset(pools map[string]SnapshotUsage) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pools = pools
+}
+
+// Describe implements prometheus.Collector
+func (p *PoolSnapshots) Describe(ch chan<- *prometheus.Desc) {
+	ch <- p.usedDesc
+	ch <- p.countDesc
+}
+
+// Collect implements prometheus.Collector
+func (p *PoolSnapshots) Collect(ch chan<- prometheus.Metric) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for pool, usage := range p.pools {
+		ch <- prometheus.MustNewConstMetric(p.usedDesc, prometheus.GaugeValue, float64(usage.Used), pool)
+		ch <- prometheus.MustNewConstMetric(p.countDesc, prometheus.GaugeValue, float64(usage.Count), pool)
+	}
+}
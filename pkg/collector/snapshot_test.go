@@ -0,0 +1,50 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPoolSnapshots(t *testing.T) {
+	p := NewPoolSnapshots()
+	assert.Equal(t, 0, collect(p))
+
+	p.Reset(map[string]SnapshotUsage{
+		"zfspv-pool": {Used: 1 << 30, Count: 12},
+		"backup":     {},
+	})
+	want := `
+# HELP zfs_pool_snapshot_used_bytes Sum of the space used by the snapshots of the pool.
+# TYPE zfs_pool_snapshot_used_bytes gauge
+zfs_pool_snapshot_used_bytes{pool="backup"} 0
+zfs_pool_snapshot_used_bytes{pool="zfspv-pool"} 1.073741824e+09
+# HELP zfs_pool_snapshots Number of snapshots in the pool.
+# TYPE zfs_pool_snapshots gauge
+zfs_pool_snapshots{pool="backup"} 0
+zfs_pool_snapshots{pool="zfspv-pool"} 12
+`
+	assert.NoError(t, testutil.CollectAndCompare(p, strings.NewReader(want)))
+
+	// the pools gone are not reported anymore
+	p.Reset(map[string]SnapshotUsage{"zfspv-pool": {Used: 4096, Count: 1}})
+	assert.Equal(t, 2, collect(p))
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// VolumeBreakdown is the breakdown of the space used by a volume in bytes
+type VolumeBreakdown struct {
+	// Pool is the pool of the volume
+	Pool string
+	// Snapshots, Dataset, Children and Refreservation are the space used
+	// by the snapshots of the volume, by its data, by its children and
+	// by its refreservation
+	Snapshots, Dataset, Children, Refreservation int64
+}
+
+// VolumeSpace tracks the breakdown of the space used by each volume, as
+// last listed by the node agent
+type VolumeSpace struct {
+	mu      sync.Mutex
+	volumes map[string]VolumeBreakdown
+
+	usedByDesc *prometheus.Desc
+}
+
+// Breakdown is the breakdown of the space of the volumes of the node agent
+var Breakdown = NewVolumeSpace()
+
+// NewVolumeSpace returns an empty tracker of the space of the volumes
+func NewVolumeSpace() *VolumeSpace {
+	return &VolumeSpace{
+		volumes: map[string]VolumeBreakdown{},
+		usedByDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "volume", "used_by_bytes"),
+			"Space used by the volume broken down by what uses it: its snapshots, its dataset, its children or its refreservation.",
+			[]string{"volume", "pool", "by"}, nil,
+		),
+	}
+}
+
+// Reset replaces the breakdown of all the volumes, the volumes missing
+// from the given ones are not reported anymore
+func (v *VolumeSpace) Reset(volumes map[string]VolumeBreakdown) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.volumes = volumes
+}
+
+// Describe implements prometheus.Collector
+func (v *VolumeSpace) Describe(ch chan<- *prometheus.Desc) {
+	ch <- v.usedByDesc
+}
+
+// Collect implements prometheus.Collector
+func (v *VolumeSpace) Collect(ch chan<- prometheus.Metric) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for volume, b := range v.volumes {
+		for by, bytes := range map[string]int64{
+			"snapshots":      b.Snapshots,
+			"dataset":        b.Dataset,
+			"children":       b.Children,
+			"refreservation": b.Refreservation,
+		} {
+			ch <- prometheus.MustNewConstMetric(v.usedByDesc, prometheus.GaugeValue, float64(bytes), volume, b.Pool, by)
+		}
+	}
+}
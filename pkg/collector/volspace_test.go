@@ -0,0 +1,47 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVolumeSpace(t *testing.T) {
+	v := NewVolumeSpace()
+	assert.Equal(t, 0, collect(v))
+
+	v.Reset(map[string]VolumeBreakdown{
+		"pvc-1": {Pool: "zfspv-pool", Snapshots: 1024, Dataset: 4096},
+	})
+	want := `
+# HELP zfs_volume_used_by_bytes Space used by the volume broken down by what uses it: its snapshots, its dataset, its children or its refreservation.
+# TYPE zfs_volume_used_by_bytes gauge
+zfs_volume_used_by_bytes{by="children",pool="zfspv-pool",volume="pvc-1"} 0
+zfs_volume_used_by_bytes{by="dataset",pool="zfspv-pool",volume="pvc-1"} 4096
+zfs_volume_used_by_bytes{by="refreservation",pool="zfspv-pool",volume="pvc-1"} 0
+zfs_volume_used_by_bytes{by="snapshots",pool="zfspv-pool",volume="pvc-1"} 1024
+`
+	assert.NoError(t, testutil.CollectAndCompare(v, strings.NewReader(want)))
+
+	// the volumes gone are not reported anymore
+	v.Reset(nil)
+	assert.Equal(t, 0, collect(v))
+}
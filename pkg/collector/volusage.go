@@ -0,0 +1,77 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// VolumeFill is the fill of a volume
+type VolumeFill struct {
+	// Pool is the pool of the volume
+	Pool string
+	// Percent is the percent of the size of the volume used
+	Percent float64
+}
+
+// VolumeUsage tracks the fill of each volume, as last checked by the node
+// agent
+type VolumeUsage struct {
+	mu      sync.Mutex
+	volumes map[string]VolumeFill
+
+	percentDesc *prometheus.Desc
+}
+
+// Volumes is the fill of the volumes of the node agent
+var Volumes = NewVolumeUsage()
+
+// NewVolumeUsage returns an empty tracker of the fill of the volumes
+func NewVolumeUsage() *VolumeUsage {
+	return &VolumeUsage{
+		volumes: map[string]VolumeFill{},
+		percentDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "volume", "used_percent"),
+			"Percent of the size of the volume used, its volsize for a zvol and its quota for a dataset.",
+			[]string{"volume", "pool"}, nil,
+		),
+	}
+}
+
+// Reset replaces the fill of all the volumes, the volumes missing from the
+// given ones are not reported anymore
+func (v *VolumeUsage) Reset(volumes map[string]VolumeFill) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.volumes = volumes
+}
+
+// Describe implements prometheus.Collector
+func (v *VolumeUsage) Describe(ch chan<- *prometheus.Desc) {
+	ch <- v.percentDesc
+}
+
+// Collect implements prometheus.Collector
+func (v *VolumeUsage) Collect(ch chan<- prometheus.Metric) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for volume, fill := range v.volumes {
+		ch <- prometheus.MustNewConstMetric(v.percentDesc, prometheus.GaugeValue, fill.Percent, volume, fill.Pool)
+	}
+}
@@ -0,0 +1,46 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVolumeUsage(t *testing.T) {
+	v := NewVolumeUsage()
+	assert.Equal(t, 0, collect(v))
+
+	v.Reset(map[string]VolumeFill{
+		"pvc-1": {Pool: "zfspv-pool", Percent: 97.5},
+		"pvc-2": {Pool: "zfspv-pool", Percent: 10},
+	})
+	want := `
+# HELP zfs_volume_used_percent Percent of the size of the volume used, its volsize for a zvol and its quota for a dataset.
+# TYPE zfs_volume_used_percent gauge
+zfs_volume_used_percent{pool="zfspv-pool",volume="pvc-1"} 97.5
+zfs_volume_used_percent{pool="zfspv-pool",volume="pvc-2"} 10
+`
+	assert.NoError(t, testutil.CollectAndCompare(v, strings.NewReader(want)))
+
+	// the volumes gone are not reported anymore
+	v.Reset(map[string]VolumeFill{"pvc-1": {Pool: "zfspv-pool", Percent: 98}})
+	assert.Equal(t, 1, collect(v))
+}
@@ -0,0 +1,211 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// WorkqueueMetrics is the workqueue.MetricsProvider of the node agent
+// controllers, it exposes the metrics of each named queue with the name
+// label, e.g. ZV for the volumes. It has to be set with
+// workqueue.SetProvider before the queues are created.
+type WorkqueueMetrics struct {
+	depth        *prometheus.GaugeVec
+	adds         *prometheus.CounterVec
+	latency      *prometheus.HistogramVec
+	workDuration *prometheus.HistogramVec
+	unfinished   *prometheus.GaugeVec
+	longest      *prometheus.GaugeVec
+	retries      *prometheus.CounterVec
+}
+
+// NewWorkqueueMetrics returns the workqueue metrics, which are also a
+// prometheus collector
+func NewWorkqueueMetrics() *WorkqueueMetrics {
+	label := []string{"name"}
+	buckets := prometheus.ExponentialBuckets(0.001, 4, 10)
+	return &WorkqueueMetrics{
+		depth: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace, Subsystem: "workqueue", Name: "depth",
+			Help: "Number of items waiting in the workqueue.",
+		}, label),
+		adds: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: "workqueue", Name: "adds_total",
+			Help: "Number of items added to the workqueue.",
+		}, label),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace, Subsystem: "workqueue", Name: "queue_duration_seconds",
+			Help: "Time an item waits in the workqueue before being processed.", Buckets: buckets,
+		}, label),
+		workDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace, Subsystem: "workqueue", Name: "work_duration_seconds",
+			Help: "Time taken to process an item of the workqueue.", Buckets: buckets,
+		}, label),
+		unfinished: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace, Subsystem: "workqueue", Name: "unfinished_work_seconds",
+			Help: "Time the items being processed have been running for.",
+		}, label),
+		longest: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace, Subsystem: "workqueue", Name: "longest_running_processor_seconds",
+			Help: "Time the longest running item of the workqueue has been processed for.",
+		}, label),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: "workqueue", Name: "retries_total",
+			Help: "Number of items requeued with a rate limit after a failure.",
+		}, label),
+	}
+}
+
+// NewDepthMetric implements workqueue.MetricsProvider
+func (m *WorkqueueMetrics) NewDepthMetric(name string) workqueue.GaugeMetric {
+	return m.depth.WithLabelValues(name)
+}
+
+// NewAddsMetric implements workqueue.MetricsProvider
+func (m *WorkqueueMetrics) NewAddsMetric(name string) workqueue.CounterMetric {
+	return m.adds.WithLabelValues(name)
+}
+
+// NewLatencyMetric implements workqueue.MetricsProvider
+func (m *WorkqueueMetrics) NewLatencyMetric(name string) workqueue.HistogramMetric {
+	return m.latency.WithLabelValues(name)
+}
+
+// NewWorkDurationMetric implements workqueue.MetricsProvider
+func (m *WorkqueueMetrics) NewWorkDurationMetric(name string) workqueue.HistogramMetric {
+	return m.workDuration.WithLabelValues(name)
+}
+
+// NewUnfinishedWorkSecondsMetric implements workqueue.MetricsProvider
+func (m *WorkqueueMetrics) NewUnfinishedWorkSecondsMetric(name string) workqueue.SettableGaugeMetric {
+	return m.unfinished.WithLabelValues(name)
+}
+
+// NewLongestRunningProcessorSecondsMetric implements workqueue.MetricsProvider
+func (m *WorkqueueMetrics) NewLongestRunningProcessorSecondsMetric(name string) workqueue.SettableGaugeMetric {
+	return m.longest.WithLabelValues(name)
+}
+
+// NewRetriesMetric implements workqueue.MetricsProvider
+func (m *WorkqueueMetrics) NewRetriesMetric(name string) workqueue.CounterMetric {
+	return m.retries.WithLabelValues(name)
+}
+
+func (m *WorkqueueMetrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{m.depth, m.adds, m.latency, m.workDuration, m.unfinished, m.longest, m.retries}
+}
+
+// Describe implements prometheus.Collector
+func (m *WorkqueueMetrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range m.collectors() {
+		c.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector
+func (m *WorkqueueMetrics) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range m.collectors() {
+		c.Collect(ch)
+	}
+}
+
+// OperationProvision is the pending operation of the volumes waiting to be
+// created on the node
+const OperationProvision = "provision"
+
+// PendingOperations tracks the operations which have been requested but
+// are not done yet, e.g. the volumes waiting to be provisioned, along with
+// the time each one has been requested at
+type PendingOperations struct {
+	mu      sync.Mutex
+	pending map[string]map[string]time.Time
+	now     func() time.Time
+
+	count  *prometheus.Desc
+	oldest *prometheus.Desc
+}
+
+// Pending are the pending operations of the node agent
+var Pending = NewPendingOperations()
+
+// NewPendingOperations returns an empty tracker of the pending operations
+func NewPendingOperations() *PendingOperations {
+	return &PendingOperations{
+		pending: map[string]map[string]time.Time{},
+		now:     time.Now,
+		count: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "pending_operations"),
+			"Number of operations requested and not done yet.",
+			[]string{"operation"}, nil,
+		),
+		oldest: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "oldest_pending_operation_seconds"),
+			"Age of the oldest operation requested and not done yet.",
+			[]string{"operation"}, nil,
+		),
+	}
+}
+
+// Start records the operation on the key as pending since the given time,
+// an operation already pending keeps its time
+func (p *PendingOperations) Start(operation, key string, since time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ops, ok := p.pending[operation]
+	if !ok {
+		ops = map[string]time.Time{}
+		p.pending[operation] = ops
+	}
+	if _, ok := ops[key]; !ok {
+		ops[key] = since
+	}
+}
+
+// Done records that the operation on the key is done
+func (p *PendingOperations) Done(operation, key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.pending[operation], key)
+}
+
+// Describe implements prometheus.Collector
+func (p *PendingOperations) Describe(ch chan<- *prometheus.Desc) {
+	ch <- p.count
+	ch <- p.oldest
+}
+
+// Collect implements prometheus.Collector
+func (p *PendingOperations) Collect(ch chan<- prometheus.Metric) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.now()
+	for operation, ops := range p.pending {
+		var oldest time.Duration
+		for _, since := range ops {
+			if age := now.Sub(since); age > oldest {
+				oldest = age
+			}
+		}
+		ch <- prometheus.MustNewConstMetric(p.count, prometheus.GaugeValue, float64(len(ops)), operation)
+		ch <- prometheus.MustNewConstMetric(p.oldest, prometheus.GaugeValue, oldest.Seconds(), operation)
+	}
+}
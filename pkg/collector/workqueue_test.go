@@ -0,0 +1,87 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/util/workqueue"
+)
+
+func TestWorkqueueMetricsDepth(t *testing.T) {
+	m := NewWorkqueueMetrics()
+	q := workqueue.NewWithConfig(workqueue.QueueConfig{Name: "ZV", MetricsProvider: m})
+	defer q.ShutDown()
+
+	q.Add("openebs/pvc-1")
+	q.Add("openebs/pvc-2")
+	// an item already queued is neither added nor counted twice
+	q.Add("openebs/pvc-2")
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.depth.WithLabelValues("ZV")))
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.adds.WithLabelValues("ZV")))
+
+	item, _ := q.Get()
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.depth.WithLabelValues("ZV")))
+	q.Done(item)
+
+	// the metrics of the other queues are kept apart
+	snap := workqueue.NewWithConfig(workqueue.QueueConfig{Name: "Snap", MetricsProvider: m})
+	defer snap.ShutDown()
+	snap.Add("openebs/snap-1")
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.depth.WithLabelValues("Snap")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.depth.WithLabelValues("ZV")))
+	assert.Equal(t, 2, collect(m.depth))
+}
+
+func TestPendingOperations(t *testing.T) {
+	now := time.Now()
+	p := NewPendingOperations()
+	p.now = func() time.Time { return now }
+	assert.Equal(t, 0, collect(p))
+
+	p.Start(OperationProvision, "pvc-1", now.Add(-time.Minute))
+	p.Start(OperationProvision, "pvc-2", now.Add(-10*time.Second))
+	// the time of an operation already pending is kept
+	p.Start(OperationProvision, "pvc-1", now)
+	assert.Equal(t, 2, collect(p))
+
+	want := `
+# HELP zfs_oldest_pending_operation_seconds Age of the oldest operation requested and not done yet.
+# TYPE zfs_oldest_pending_operation_seconds gauge
+zfs_oldest_pending_operation_seconds{operation="provision"} 60
+# HELP zfs_pending_operations Number of operations requested and not done yet.
+# TYPE zfs_pending_operations gauge
+zfs_pending_operations{operation="provision"} 2
+`
+	assert.NoError(t, testutil.CollectAndCompare(p, strings.NewReader(want)))
+
+	p.Done(OperationProvision, "pvc-1")
+	p.Done(OperationProvision, "pvc-3")
+	want = `
+# HELP zfs_oldest_pending_operation_seconds Age of the oldest operation requested and not done yet.
+# TYPE zfs_oldest_pending_operation_seconds gauge
+zfs_oldest_pending_operation_seconds{operation="provision"} 10
+# HELP zfs_pending_operations Number of operations requested and not done yet.
+# TYPE zfs_pending_operations gauge
+zfs_pending_operations{operation="provision"} 1
+`
+	assert.NoError(t, testutil.CollectAndCompare(p, strings.NewReader(want)))
+}
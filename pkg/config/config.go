@@ -16,6 +16,8 @@ limitations under the License.
 
 package config
 
+import "time"
+
 // Config struct fills the parameters of request or user input
 type Config struct {
 	// DriverName to be registered at CSI
@@ -41,6 +43,210 @@ type Config struct {
 	// which node drivers are running. This is used
 	// to set the topologies for the driver
 	Nodename string
+
+	// DefaultFsType is the filesystem used for the
+	// volume when neither the PVC nor the StorageClass
+	// specifies one
+	DefaultFsType string
+
+	// ListenAddress is the TCP address on which the
+	// node plugin exposes the prometheus metrics,
+	// metrics are disabled if it is empty
+	ListenAddress string
+
+	// MetricsPath is the path under which the
+	// prometheus metrics are exposed
+	MetricsPath string
+
+	// VolumeHealthInterval is the interval at which the
+	// node plugin checks the condition of the published
+	// volumes, the check is disabled if it is zero
+	VolumeHealthInterval time.Duration
+
+	// ZFSPath and ZPoolPath are the names or the
+	// paths of the zfs and zpool binaries used by
+	// the node plugin
+	ZFSPath   string
+	ZPoolPath string
+
+	// CommandPrefix is the command every zfs and
+	// zpool invocation is wrapped in, e.g. sudo -n
+	CommandPrefix string
+
+	// TopologyKeys are the node label keys, e.g. the
+	// zone and the region, which are advertised and
+	// set in the topology of the volumes along with
+	// the node
+	TopologyKeys []string
+
+	// PropagatePrefixes are the prefixes of the pvc label
+	// and annotation keys, e.g. team, which the controller
+	// copies to the ZFSVolume and keeps in sync
+	PropagatePrefixes []string
+
+	// ThinExpandPolicy is what the controller does when a
+	// thin volume is expanded beyond the size of its pool,
+	// one of allow, warn or reject
+	ThinExpandPolicy string
+
+	// UnschedulableResponse is how the controller fails a
+	// volume which no node can hold, reschedule or retry
+	UnschedulableResponse string
+
+	// VolumeLockPolicy is what the controller does with a
+	// mutating operation on a volume which already has one
+	// in progress, wait or abort
+	VolumeLockPolicy string
+
+	// AbandonedVolumePolicy is what the controller does with
+	// a volume whose pvc is gone before it is ready, cancel or
+	// keep
+	AbandonedVolumePolicy string
+
+	// PoolDegradedPolicy is what the controller does when
+	// the health of a pool degrades, report, mark or cordon
+	PoolDegradedPolicy string
+
+	// MaxDatasetsPerPool is the number of datasets a
+	// pool can hold before the controller stops placing
+	// volumes on it, unlimited if 0
+	MaxDatasetsPerPool int
+
+	// DestroyGuard enables the guard which pauses the
+	// destroy of the volumes and snapshots larger than
+	// DestroyGuardSize until it is confirmed with an
+	// annotation
+	DestroyGuard     bool
+	DestroyGuardSize string
+
+	// StaleMountCleanup is what is done at startup with the
+	// mounts of the driver which are not expected anymore,
+	// one of off, report or clean
+	StaleMountCleanup string
+
+	// FsGroupMode is how the fsGroup of the pods is applied
+	// to the filesystem volumes, by the kubelet or by the
+	// node plugin, one of kubelet, onrootmismatch, recursive
+	// or rootonly
+	FsGroupMode string
+
+	// SnapshotClonesPolicy is what is done with the clones
+	// of a snapshot being deleted, refuse or promote
+	SnapshotClonesPolicy string
+
+	// PublishFailurePolicy is what is done with the mount of
+	// a publish which fails on a later step, rollback or keep
+	PublishFailurePolicy string
+
+	// MissingTargetParent is what is done when the parent
+	// directory of the target path is missing, create or fail
+	MissingTargetParent string
+
+	// DeviceRescan is what is done when the kernel has not
+	// noticed the new size of an expanded zvol, auto or off
+	DeviceRescan string
+
+	// DataCheckRate is the bytes read per second by a check
+	// of the data of a volume, e.g. 100Mi, 0 does not bound them
+	DataCheckRate string
+
+	// KubeletDir is the root directory of kubelet on the node
+	KubeletDir string
+
+	// BusyRetries is the number of times a zfs create
+	// or zfs set failing on a busy pool is retried, the
+	// wait doubles from BusyRetryInterval up to
+	// BusyRetryMaxInterval
+	BusyRetries          int
+	BusyRetryInterval    time.Duration
+	BusyRetryMaxInterval time.Duration
+
+	// MaxSends is the number of zfs send, of the
+	// backups and the migrations, run at the same time
+	// by the node plugin, the other ones are queued
+	MaxSends int
+
+	// ZFSVersionPolicy is what the node plugin does at
+	// startup when the zfs version of the node is out of
+	// the range from MinZFSVersion to MaxZFSVersion, one
+	// of warn or refuse
+	ZFSVersionPolicy string
+	MinZFSVersion    string
+	MaxZFSVersion    string
+
+	// ReservedSpacePercent is the percentage of each pool
+	// kept free as emergency space, 0 reserves nothing
+	ReservedSpacePercent int
+
+	// FailedCleanupGrace is the time a partially created
+	// volume is kept for inspection before it is destroyed
+	FailedCleanupGrace time.Duration
+
+	// StatusUpdateInterval is the minimum interval in between
+	// two writes of the noisy status fields of an object
+	StatusUpdateInterval time.Duration
+
+	// PoolSummaryInterval is the interval at which the
+	// capacity summary of the pools is refreshed, 0 disables
+	// the summary
+	PoolSummaryInterval time.Duration
+
+	// DeadmanThreshold is the number of deadman events of a
+	// pool in DeadmanWindow which mark its IO as hung, 0
+	// disables the detection
+	DeadmanWindow    time.Duration
+	DeadmanThreshold int
+
+	// PoolFullThreshold is the percent of the size of a pool
+	// above which it is reported as full, PoolFullCleanup is
+	// what is done to reclaim its space, one of off or
+	// unreferenced-snapshots
+	PoolFullThreshold int
+	PoolFullCleanup   string
+
+	// FenceLease is the time the lease of the node on a zvol
+	// stays fresh once renewed, 0 disables the fencing
+	FenceLease time.Duration
+
+	// CapacityDriftMode is what is done when the capacity of
+	// a volume has been changed behind the driver, one of
+	// off, report or enforce
+	CapacityDriftMode string
+
+	// DanglingSnapshotPolicy is what is done with a
+	// ZFSSnapshot whose zfs snapshot is gone, one of off,
+	// mark or delete
+	DanglingSnapshotPolicy string
+
+	// PoolAutoImport imports the expected pools of the
+	// ZFSNode which are not imported
+	PoolAutoImport bool
+
+	// DestroyConcurrency is the number of volumes the node
+	// plugin destroys at the same time
+	DestroyConcurrency int
+
+	// PoolAliasUpdate updates the ZFSVolumes and the
+	// ZFSSnapshots of a renamed pool with its current name
+	PoolAliasUpdate bool
+
+	// NodeUnreachableRetries is the number of times the
+	// controller checks again a node whose agent has not
+	// renewed its lease before skipping it, the wait doubles
+	// from NodeUnreachableRetryInterval. A node whose lease
+	// expired NodeGoneThreshold ago is skipped at once.
+	NodeUnreachableRetries       int
+	NodeUnreachableRetryInterval time.Duration
+	NodeGoneThreshold            time.Duration
+
+	// GRPCReflection registers the grpc reflection service on
+	// the csi endpoint for the debugging tools like grpcurl
+	GRPCReflection bool
+
+	// SocketReadyTimeout is the time the csi endpoint is given
+	// to accept the connections once the driver listens on it,
+	// the driver exits if it is not ready by then
+	SocketReadyTimeout time.Duration
 }
 
 // Default returns a new instance of config
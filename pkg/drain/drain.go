@@ -0,0 +1,287 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package drain moves all the ZFSVolumes owned by a node to the other
+// nodes of the cluster, so that the node can be decommissioned. Each
+// volume is migrated using the snapshot + send/recv path and the drain
+// reports the progress of every volume along with a clear blocker for
+// the volumes which can not be moved.
+package drain
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/klog/v2"
+)
+
+// Phase is the migration phase of a volume being drained
+type Phase string
+
+const (
+	// PhasePending means the volume has not been picked up yet
+	PhasePending Phase = "Pending"
+	// PhaseMigrating means the volume is being migrated to the target node
+	PhaseMigrating Phase = "Migrating"
+	// PhaseDone means the volume has been migrated to the target node
+	PhaseDone Phase = "Done"
+	// PhaseBlocked means the volume can not be migrated, Reason has the details
+	PhaseBlocked Phase = "Blocked"
+	// PhaseFailed means the migration was attempted and failed
+	PhaseFailed Phase = "Failed"
+)
+
+// VolumeStatus is the drain progress of a single volume
+type VolumeStatus struct {
+	// Volume is the name of the ZFSVolume
+	Volume string
+	// Pool is the zfs pool of the volume
+	Pool string
+	// Target is the node id the volume is migrated to
+	Target string
+	// Phase is the current migration phase
+	Phase Phase
+	// Reason explains why the volume is blocked or failed
+	Reason string
+}
+
+// Report is the result of draining a node
+type Report struct {
+	// Node is the node id being drained
+	Node string
+	// Volumes has the status of every volume owned by the node
+	Volumes []VolumeStatus
+}
+
+// Complete returns true if all the volumes have been migrated
+func (r *Report) Complete() bool {
+	for _, v := range r.Volumes {
+		if v.Phase != PhaseDone {
+			return false
+		}
+	}
+	return true
+}
+
+// Blockers returns the volumes which could not be migrated
+func (r *Report) Blockers() []VolumeStatus {
+	var blocked []VolumeStatus
+	for _, v := range r.Volumes {
+		if v.Phase == PhaseBlocked || v.Phase == PhaseFailed {
+			blocked = append(blocked, v)
+		}
+	}
+	return blocked
+}
+
+// Migrator migrates a volume to the target node
+type Migrator interface {
+	Migrate(ctx context.Context, vol *apis.ZFSVolume, target string) error
+}
+
+// DisruptionChecker tells whether the workload using the volume can be
+// disrupted right now. It returns false along with the reason if a
+// PodDisruptionBudget does not allow the disruption.
+type DisruptionChecker interface {
+	CanDisrupt(ctx context.Context, vol *apis.ZFSVolume) (bool, string, error)
+}
+
+// Drainer migrates the volumes owned by a node to the other nodes
+type Drainer struct {
+	// Node is the node id to drain
+	Node string
+
+	listVolumes func() ([]apis.ZFSVolume, error)
+	listNodes   func() ([]apis.ZFSNode, error)
+	migrator    Migrator
+	checker     DisruptionChecker
+
+	// progress is called whenever the status of a volume changes
+	progress func(VolumeStatus)
+}
+
+// NewDrainer returns a drainer for the given node which works on the
+// ZFSVolume and ZFSNode objects present in the openebs namespace.
+func NewDrainer(node string, migrator Migrator) *Drainer {
+	return &Drainer{
+		Node:        node,
+		listVolumes: listVolumes,
+		listNodes:   listNodes,
+		migrator:    migrator,
+		checker:     &pdbChecker{},
+		progress:    func(VolumeStatus) {},
+	}
+}
+
+// WithDisruptionChecker sets the checker used before disrupting a workload
+func (d *Drainer) WithDisruptionChecker(c DisruptionChecker) *Drainer {
+	d.checker = c
+	return d
+}
+
+// WithProgress sets the callback which is notified of the volume progress
+func (d *Drainer) WithProgress(fn func(VolumeStatus)) *Drainer {
+	d.progress = fn
+	return d
+}
+
+// VolumesOnNode returns the volumes owned by the given node id which are
+// not being deleted, sorted by name.
+func VolumesOnNode(vols []apis.ZFSVolume, nodeID string) []apis.ZFSVolume {
+	var owned []apis.ZFSVolume
+	for _, vol := range vols {
+		if vol.Spec.OwnerNodeID != nodeID || vol.DeletionTimestamp != nil {
+			continue
+		}
+		owned = append(owned, vol)
+	}
+	sort.Slice(owned, func(i, j int) bool {
+		return owned[i].Name < owned[j].Name
+	})
+	return owned
+}
+
+// poolFree tracks the free space of the pools while planning so that two
+// volumes are not placed on the same space.
+type poolFree map[string]map[string]int64
+
+func newPoolFree(nodes []apis.ZFSNode, exclude string) poolFree {
+	free := make(poolFree)
+	for _, node := range nodes {
+		if node.Name == exclude {
+			continue
+		}
+		pools := make(map[string]int64)
+		for _, pool := range node.Pools {
+			pools[pool.Name] = pool.Free.Value()
+		}
+		free[node.Name] = pools
+	}
+	return free
+}
+
+// pickTarget returns the node having the pool of the volume with the most
+// free space to hold the volume, empty string if there is none.
+func (pf poolFree) pickTarget(pool string, size int64) string {
+	var target string
+	var best int64 = -1
+	for node, pools := range pf {
+		free, ok := pools[pool]
+		if !ok || free < size {
+			continue
+		}
+		if free > best || (free == best && node < target) {
+			target, best = node, free
+		}
+	}
+	if target != "" {
+		pf[target][pool] -= size
+	}
+	return target
+}
+
+// Plan assigns a target node to each of the volumes. The volumes which can
+// not be placed anywhere are marked blocked with the reason.
+func Plan(vols []apis.ZFSVolume, nodes []apis.ZFSNode, source string) []VolumeStatus {
+	free := newPoolFree(nodes, source)
+	var plan []VolumeStatus
+
+	for _, vol := range vols {
+		st := VolumeStatus{
+			Volume: vol.Name,
+			Pool:   vol.Spec.PoolName,
+			Phase:  PhasePending,
+		}
+
+		size, err := strconv.ParseInt(vol.Spec.Capacity, 10, 64)
+		if err != nil {
+			st.Phase = PhaseBlocked
+			st.Reason = fmt.Sprintf("invalid capacity %q", vol.Spec.Capacity)
+		} else if st.Target = free.pickTarget(vol.Spec.PoolName, size); st.Target == "" {
+			st.Phase = PhaseBlocked
+			st.Reason = fmt.Sprintf("no other node has pool %s with %s free",
+				vol.Spec.PoolName, resource.NewQuantity(size, resource.BinarySI).String())
+		}
+		plan = append(plan, st)
+	}
+
+	return plan
+}
+
+// Drain migrates all the volumes of the node and returns the report. The
+// volumes are migrated one at a time, a blocked or failed volume does not
+// stop the migration of the rest.
+func (d *Drainer) Drain(ctx context.Context) (*Report, error) {
+	allVols, err := d.listVolumes()
+	if err != nil {
+		return nil, fmt.Errorf("drain: failed to list volumes: %w", err)
+	}
+	nodes, err := d.listNodes()
+	if err != nil {
+		return nil, fmt.Errorf("drain: failed to list zfs nodes: %w", err)
+	}
+
+	vols := VolumesOnNode(allVols, d.Node)
+	report := &Report{
+		Node:    d.Node,
+		Volumes: Plan(vols, nodes, d.Node),
+	}
+
+	for i := range vols {
+		st := &report.Volumes[i]
+		if st.Phase == PhaseBlocked {
+			klog.Warningf("drain: volume %s on node %s is blocked: %s", st.Volume, d.Node, st.Reason)
+			d.progress(*st)
+			continue
+		}
+
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+
+		ok, reason, err := d.checker.CanDisrupt(ctx, &vols[i])
+		if err != nil {
+			st.Phase, st.Reason = PhaseFailed, err.Error()
+			d.progress(*st)
+			continue
+		}
+		if !ok {
+			st.Phase, st.Reason = PhaseBlocked, reason
+			klog.Warningf("drain: volume %s on node %s is blocked: %s", st.Volume, d.Node, st.Reason)
+			d.progress(*st)
+			continue
+		}
+
+		st.Phase = PhaseMigrating
+		d.progress(*st)
+		klog.Infof("drain: migrating volume %s from node %s to %s", st.Volume, d.Node, st.Target)
+
+		if err := d.migrator.Migrate(ctx, &vols[i], st.Target); err != nil {
+			st.Phase, st.Reason = PhaseFailed, err.Error()
+			klog.Errorf("drain: volume %s migration to %s failed: %v", st.Volume, st.Target, err)
+		} else {
+			st.Phase = PhaseDone
+			klog.Infof("drain: volume %s migrated to node %s", st.Volume, st.Target)
+		}
+		d.progress(*st)
+	}
+
+	return report, nil
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drain
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func vol(name, node, pool, capacity string) apis.ZFSVolume {
+	v := apis.ZFSVolume{}
+	v.Name = name
+	v.Spec.OwnerNodeID = node
+	v.Spec.PoolName = pool
+	v.Spec.Capacity = capacity
+	return v
+}
+
+func node(name, pool, free string) apis.ZFSNode {
+	n := apis.ZFSNode{}
+	n.Name = name
+	n.Pools = []apis.Pool{{Name: pool, Free: resource.MustParse(free)}}
+	return n
+}
+
+type fakeMigrator struct {
+	migrated map[string]string
+	fail     map[string]bool
+}
+
+func (f *fakeMigrator) Migrate(ctx context.Context, vol *apis.ZFSVolume, target string) error {
+	if f.fail[vol.Name] {
+		return errors.New("send failed")
+	}
+	f.migrated[vol.Name] = target
+	return nil
+}
+
+type fakeChecker struct {
+	blocked map[string]bool
+}
+
+func (f *fakeChecker) CanDisrupt(ctx context.Context, vol *apis.ZFSVolume) (bool, string, error) {
+	if f.blocked[vol.Name] {
+		return false, "pdb does not allow disruption", nil
+	}
+	return true, "", nil
+}
+
+func TestVolumesOnNode(t *testing.T) {
+	deleting := vol("pvc-d", "node1", "zfspv", "1024")
+	now := metav1.Now()
+	deleting.DeletionTimestamp = &now
+
+	vols := []apis.ZFSVolume{
+		vol("pvc-c", "node1", "zfspv", "1024"),
+		vol("pvc-b", "node2", "zfspv", "1024"),
+		vol("pvc-a", "node1", "zfspv", "1024"),
+		deleting,
+	}
+
+	got := VolumesOnNode(vols, "node1")
+	assert.Len(t, got, 2)
+	assert.Equal(t, "pvc-a", got[0].Name)
+	assert.Equal(t, "pvc-c", got[1].Name)
+}
+
+func TestPlan(t *testing.T) {
+	nodes := []apis.ZFSNode{
+		node("node1", "zfspv", "100Gi"),
+		node("node2", "zfspv", "3Gi"),
+		node("node3", "zfspv", "2Gi"),
+		node("node4", "other", "100Gi"),
+	}
+	vols := []apis.ZFSVolume{
+		vol("pvc-a", "node1", "zfspv", "2147483648"),
+		vol("pvc-b", "node1", "zfspv", "2147483648"),
+		vol("pvc-c", "node1", "zfspv", "2147483648"),
+		vol("pvc-d", "node1", "missing", "1024"),
+		vol("pvc-e", "node1", "zfspv", "bad"),
+	}
+
+	plan := Plan(vols, nodes, "node1")
+	assert.Equal(t, "node2", plan[0].Target)
+	assert.Equal(t, "node3", plan[1].Target)
+
+	// node2 and node3 are already used up by the first two volumes
+	assert.Equal(t, PhaseBlocked, plan[2].Phase)
+	assert.Equal(t, "no other node has pool zfspv with 2Gi free", plan[2].Reason)
+	assert.Equal(t, PhaseBlocked, plan[3].Phase)
+	assert.Equal(t, PhaseBlocked, plan[4].Phase)
+}
+
+func TestDrain(t *testing.T) {
+	vols := []apis.ZFSVolume{
+		vol("pvc-a", "node1", "zfspv", "1024"),
+		vol("pvc-b", "node1", "zfspv", "1024"),
+		vol("pvc-c", "node1", "zfspv", "1024"),
+		vol("pvc-d", "node1", "nopool", "1024"),
+		vol("pvc-e", "node2", "zfspv", "1024"),
+	}
+	nodes := []apis.ZFSNode{
+		node("node1", "zfspv", "10Gi"),
+		node("node2", "zfspv", "10Gi"),
+	}
+
+	migrator := &fakeMigrator{
+		migrated: map[string]string{},
+		fail:     map[string]bool{"pvc-b": true},
+	}
+	var events []VolumeStatus
+
+	d := NewDrainer("node1", migrator).
+		WithDisruptionChecker(&fakeChecker{blocked: map[string]bool{"pvc-c": true}}).
+		WithProgress(func(st VolumeStatus) { events = append(events, st) })
+	d.listVolumes = func() ([]apis.ZFSVolume, error) { return vols, nil }
+	d.listNodes = func() ([]apis.ZFSNode, error) { return nodes, nil }
+
+	report, err := d.Drain(context.Background())
+	assert.NoError(t, err)
+	assert.False(t, report.Complete())
+	assert.Len(t, report.Volumes, 4)
+
+	assert.Equal(t, map[string]string{"pvc-a": "node2"}, migrator.migrated)
+	assert.Equal(t, PhaseDone, report.Volumes[0].Phase)
+	assert.Equal(t, PhaseFailed, report.Volumes[1].Phase)
+	assert.Equal(t, "send failed", report.Volumes[1].Reason)
+	assert.Equal(t, PhaseBlocked, report.Volumes[2].Phase)
+	assert.Equal(t, "pdb does not allow disruption", report.Volumes[2].Reason)
+	assert.Equal(t, PhaseBlocked, report.Volumes[3].Phase)
+	assert.Contains(t, report.Volumes[3].Reason, "nopool")
+
+	blockers := report.Blockers()
+	assert.Len(t, blockers, 3)
+	// migrating and done for the two migrated, one each for the blocked
+	assert.Len(t, events, 6)
+}
+
+func TestDrainNoVolumes(t *testing.T) {
+	d := NewDrainer("node1", &fakeMigrator{})
+	d.listVolumes = func() ([]apis.ZFSVolume, error) { return nil, nil }
+	d.listNodes = func() ([]apis.ZFSNode, error) { return nil, nil }
+
+	report, err := d.Drain(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, report.Complete())
+}
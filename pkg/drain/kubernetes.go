@@ -0,0 +1,522 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drain
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	k8sapi "github.com/openebs/lib-csi/pkg/client/k8s"
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"github.com/openebs/zfs-localpv/pkg/builder/bkpbuilder"
+	"github.com/openebs/zfs-localpv/pkg/builder/nodebuilder"
+	"github.com/openebs/zfs-localpv/pkg/builder/restorebuilder"
+	"github.com/openebs/zfs-localpv/pkg/builder/volbuilder"
+	clientset "github.com/openebs/zfs-localpv/pkg/generated/clientset/internalclientset"
+	"github.com/openebs/zfs-localpv/pkg/zfs"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	k8serror "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+// pollInterval is the interval at which the migration objects are checked
+const pollInterval = 2 * time.Second
+
+func listVolumes() ([]apis.ZFSVolume, error) {
+	vols, err := volbuilder.NewKubeclient().
+		WithNamespace(zfs.OpenEBSNamespace).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return vols.Items, nil
+}
+
+func listNodes() ([]apis.ZFSNode, error) {
+	nodes, err := nodebuilder.NewKubeclient().
+		WithNamespace(zfs.OpenEBSNamespace).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return nodes.Items, nil
+}
+
+// pdbChecker finds the pods using the volume and refuses the disruption
+// if any PodDisruptionBudget selecting those pods has no disruption left.
+type pdbChecker struct{}
+
+func (c *pdbChecker) CanDisrupt(ctx context.Context, vol *apis.ZFSVolume) (bool, string, error) {
+	cs, err := k8sapi.Clientset().Get()
+	if err != nil {
+		return false, "", err
+	}
+
+	pv, err := cs.CoreV1().PersistentVolumes().Get(ctx, vol.Name, metav1.GetOptions{})
+	if err != nil {
+		return false, "", fmt.Errorf("failed to get pv %s: %w", vol.Name, err)
+	}
+	if pv.Spec.ClaimRef == nil {
+		return true, "", nil
+	}
+	ns, claim := pv.Spec.ClaimRef.Namespace, pv.Spec.ClaimRef.Name
+
+	pods, err := cs.CoreV1().Pods(ns).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return false, "", err
+	}
+	pdbs, err := cs.PolicyV1().PodDisruptionBudgets(ns).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return false, "", err
+	}
+
+	for _, pod := range pods.Items {
+		if !usesClaim(&pod, claim) {
+			continue
+		}
+		for _, pdb := range pdbs.Items {
+			sel, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+			if err != nil || !sel.Matches(labels.Set(pod.Labels)) {
+				continue
+			}
+			if pdb.Status.DisruptionsAllowed < 1 {
+				return false, fmt.Sprintf("pod %s/%s is protected by PodDisruptionBudget %s with no disruptions allowed",
+					ns, pod.Name, pdb.Name), nil
+			}
+		}
+	}
+
+	return true, "", nil
+}
+
+func usesClaim(pod *corev1.Pod, claim string) bool {
+	for _, v := range pod.Spec.Volumes {
+		if v.PersistentVolumeClaim != nil && v.PersistentVolumeClaim.ClaimName == claim {
+			return true
+		}
+	}
+	return false
+}
+
+// SendRecvMigrator migrates a volume by sending its snapshots from the
+// source node to the target node using ZFSBackup and ZFSRestore objects.
+// Both the send and the recv side connect to a relay (e.g. socat) which
+// pipes the stream from SendAddr to RecvAddr.
+//
+// The bulk of the data is sent while the workload keeps running. The node
+// is then cordoned and the pods using the volume are evicted, once they
+// are gone what they wrote in the meantime is sent incrementally. The
+// ZFSVolume is handed over to the target node, the PersistentVolume is
+// created again with its node affinity pointing to the target node and the
+// dataset of the source node is destroyed. The snapshots of the volume are
+// not sent, a volume having snapshots is not migrated.
+type SendRecvMigrator struct {
+	// SendAddr is the ip:port the source node sends the stream to
+	SendAddr string
+	// RecvAddr is the ip:port the target node receives the stream from
+	RecvAddr string
+	// Timeout for each step of the migration
+	Timeout time.Duration
+
+	kubeClient    kubernetes.Interface
+	openebsClient clientset.Interface
+}
+
+// WithClients sets the clients of the api server used by the migration
+func (m *SendRecvMigrator) WithClients(kube kubernetes.Interface, openebs clientset.Interface) *SendRecvMigrator {
+	m.kubeClient, m.openebsClient = kube, openebs
+	return m
+}
+
+// Migrate migrates the volume to the target node. The dataset received on
+// the target node is destroyed if the volume could not be handed over, the
+// source node is left cordoned.
+func (m *SendRecvMigrator) Migrate(ctx context.Context, vol *apis.ZFSVolume, target string) (err error) {
+	name := "drain-" + vol.Name
+	ns := zfs.OpenEBSNamespace
+	source := vol.Spec.OwnerNodeID
+
+	// the source dataset is destroyed along with its snapshots
+	snaps, err := m.openebsClient.ZfsV1().ZFSSnapshots(ns).List(ctx, metav1.ListOptions{
+		LabelSelector: zfs.ZFSVolKey + "=" + vol.Name,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list the snapshots of %s: %w", vol.Name, err)
+	}
+	if len(snaps.Items) > 0 {
+		return fmt.Errorf("volume %s has snapshots, they can not be migrated", vol.Name)
+	}
+
+	pv, err := m.kubeClient.CoreV1().PersistentVolumes().Get(ctx, vol.Name, metav1.GetOptions{})
+	if k8serror.IsNotFound(err) {
+		pv, err = nil, nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get pv %s: %w", vol.Name, err)
+	}
+
+	// the snapshots of the backups are destroyed along with the backups,
+	// on the target node once the volume has been handed over
+	var backups []string
+	handedOver := false
+	defer func() {
+		for _, bkp := range backups {
+			m.deleteBackup(bkp, source, target, handedOver)
+		}
+	}()
+	defer func() {
+		if err == nil || handedOver || len(backups) == 0 {
+			return
+		}
+		if rerr := m.retire(vol, target, name); rerr != nil {
+			klog.Errorf("drain: failed to destroy %s received on node %s: %v", vol.Name, target, rerr)
+		}
+	}()
+
+	// the bulk of the data is sent while the workload keeps running
+	backups = append(backups, name)
+	if err = m.send(ctx, vol, target, name, ""); err != nil {
+		return err
+	}
+
+	if err = m.stopWorkload(ctx, source, pv); err != nil {
+		return err
+	}
+
+	// and what the workload wrote in the meantime once it is stopped
+	backups = append(backups, name+"-final")
+	if err = m.send(ctx, vol, target, name+"-final", name); err != nil {
+		return err
+	}
+
+	if err = m.handOver(ctx, vol.Name, target); err != nil {
+		return err
+	}
+	if pv != nil {
+		if err = m.movePV(ctx, pv, source, target); err != nil {
+			if herr := m.handOver(ctx, vol.Name, source); herr != nil {
+				klog.Errorf("drain: failed to hand volume %s back to node %s: %v", vol.Name, source, herr)
+				handedOver = true
+			}
+			return err
+		}
+	}
+	handedOver = true
+
+	// and destroy the dataset left on the source node
+	if err = m.retire(vol, source, name); err != nil {
+		return fmt.Errorf("failed to destroy %s on node %s: %w", vol.Name, source, err)
+	}
+	return nil
+}
+
+// send takes the snapshot snap of the volume on its node and receives it
+// on the target node, incrementally from the snapshot prev if it is set.
+// The backup is left for the caller to delete, its snapshot is needed for
+// the next incremental send.
+func (m *SendRecvMigrator) send(ctx context.Context, vol *apis.ZFSVolume, target, snap, prev string) error {
+	ns := zfs.OpenEBSNamespace
+
+	rstr, err := restorebuilder.NewBuilder().
+		WithName(snap).
+		WithVolume(vol.Name).
+		WithVolSpec(vol.Spec).
+		WithNode(target).
+		WithStatus(apis.RSTZFSStatusInit).
+		WithRemote(m.RecvAddr).
+		WithIncremental(prev != "").
+		Build()
+	if err != nil {
+		return err
+	}
+	rstr.VolSpec.OwnerNodeID = target
+	if _, err = m.openebsClient.ZfsV1().ZFSRestores(ns).Create(ctx, rstr, metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("failed to create restore %s: %w", snap, err)
+	}
+	defer func() {
+		err := m.openebsClient.ZfsV1().ZFSRestores(ns).Delete(context.TODO(), snap, metav1.DeleteOptions{})
+		if err != nil {
+			klog.Errorf("drain: failed to delete restore %s: %v", snap, err)
+		}
+	}()
+
+	bkp, err := bkpbuilder.NewBuilder().
+		WithName(snap).
+		WithVolume(vol.Name).
+		WithSnap(snap).
+		WithPrevSnap(prev).
+		WithNode(vol.Spec.OwnerNodeID).
+		WithStatus(apis.BKPZFSStatusInit).
+		WithRemote(m.SendAddr).
+		Build()
+	if err != nil {
+		return err
+	}
+	if _, err = m.openebsClient.ZfsV1().ZFSBackups(ns).Create(ctx, bkp, metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("failed to create backup %s: %w", snap, err)
+	}
+
+	return m.waitFor(ctx, func() (bool, error) {
+		b, err := m.openebsClient.ZfsV1().ZFSBackups(ns).Get(ctx, snap, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		if b.Status == apis.BKPZFSStatusFailed {
+			return false, fmt.Errorf("backup %s on node %s failed", snap, vol.Spec.OwnerNodeID)
+		}
+		r, err := m.openebsClient.ZfsV1().ZFSRestores(ns).Get(ctx, snap, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		switch r.Status {
+		case apis.RSTZFSStatusFailed, apis.RSTZFSStatusInvalid:
+			return false, fmt.Errorf("restore %s on node %s failed", snap, target)
+		}
+		return r.Status == apis.RSTZFSStatusDone, nil
+	})
+}
+
+// deleteBackup deletes the backup, which destroys its snapshot on the node
+// owning the backup. The backup is moved to the target node first once the
+// volume has been handed over, the snapshot received there goes away and
+// the one of the source node is destroyed along with the source dataset.
+func (m *SendRecvMigrator) deleteBackup(name, source, target string, handedOver bool) {
+	backups := m.openebsClient.ZfsV1().ZFSBackups(zfs.OpenEBSNamespace)
+	if handedOver {
+		bkp, err := backups.Get(context.TODO(), name, metav1.GetOptions{})
+		if err == nil && bkp.Spec.OwnerNodeID == source {
+			bkp.Spec.OwnerNodeID = target
+			_, err = backups.Update(context.TODO(), bkp, metav1.UpdateOptions{})
+		}
+		if err != nil && !k8serror.IsNotFound(err) {
+			klog.Errorf("drain: failed to move backup %s to node %s: %v", name, target, err)
+		}
+	}
+	if err := backups.Delete(context.TODO(), name, metav1.DeleteOptions{}); err != nil && !k8serror.IsNotFound(err) {
+		klog.Errorf("drain: failed to delete backup %s: %v", name, err)
+	}
+}
+
+// stopWorkload cordons the node and evicts the pods using the claim bound
+// to the PV, honouring their PodDisruptionBudgets. It returns once the
+// pods are gone, the kubelet only removes a pod after its volumes have been
+// unpublished. The pods created again by their controller stay pending as
+// the node affinity of the PV points to the cordoned node.
+func (m *SendRecvMigrator) stopWorkload(ctx context.Context, nodeID string, pv *corev1.PersistentVolume) error {
+	if pv == nil || pv.Spec.ClaimRef == nil {
+		return nil
+	}
+	ns, claim := pv.Spec.ClaimRef.Namespace, pv.Spec.ClaimRef.Name
+
+	if err := m.cordon(ctx, nodeID); err != nil {
+		return err
+	}
+
+	return m.waitFor(ctx, func() (bool, error) {
+		pods, err := m.claimPods(ctx, ns, claim)
+		if err != nil || len(pods) == 0 {
+			return err == nil, err
+		}
+		for _, pod := range pods {
+			if pod.DeletionTimestamp != nil {
+				continue
+			}
+			eviction := &policyv1.Eviction{
+				ObjectMeta: metav1.ObjectMeta{Name: pod.Name, Namespace: ns},
+			}
+			err := m.kubeClient.CoreV1().Pods(ns).EvictV1(ctx, eviction)
+			switch {
+			case err == nil, k8serror.IsNotFound(err):
+				klog.Infof("drain: evicted pod %s/%s using volume %s", ns, pod.Name, pv.Name)
+			case k8serror.IsTooManyRequests(err):
+				klog.Infof("drain: eviction of pod %s/%s refused by its disruption budget, retrying", ns, pod.Name)
+			default:
+				return false, fmt.Errorf("failed to evict pod %s/%s: %w", ns, pod.Name, err)
+			}
+		}
+		// the pods without a grace period are gone already
+		pods, err = m.claimPods(ctx, ns, claim)
+		return err == nil && len(pods) == 0, err
+	})
+}
+
+// claimPods returns the pods using the claim which have been scheduled
+func (m *SendRecvMigrator) claimPods(ctx context.Context, ns, claim string) ([]corev1.Pod, error) {
+	pods, err := m.kubeClient.CoreV1().Pods(ns).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	var scheduled []corev1.Pod
+	for _, pod := range pods.Items {
+		if pod.Spec.NodeName != "" && usesClaim(&pod, claim) {
+			scheduled = append(scheduled, pod)
+		}
+	}
+	return scheduled, nil
+}
+
+// cordon marks the kubernetes node of the node id unschedulable
+func (m *SendRecvMigrator) cordon(ctx context.Context, nodeID string) error {
+	nodes, err := m.kubeClient.CoreV1().Nodes().List(ctx, metav1.ListOptions{
+		LabelSelector: zfs.ZFSTopologyKey + "=" + nodeID,
+	})
+	if err != nil {
+		return err
+	}
+	var node *corev1.Node
+	if len(nodes.Items) > 0 {
+		node = &nodes.Items[0]
+	} else if node, err = m.kubeClient.CoreV1().Nodes().Get(ctx, nodeID, metav1.GetOptions{}); err != nil {
+		// node is not labelled, the node name is the nodeid
+		return fmt.Errorf("failed to get node %s: %w", nodeID, err)
+	}
+
+	if node.Spec.Unschedulable {
+		return nil
+	}
+	node.Spec.Unschedulable = true
+	if _, err = m.kubeClient.CoreV1().Nodes().Update(ctx, node, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to cordon node %s: %w", node.Name, err)
+	}
+	klog.Infof("drain: cordoned node %s", node.Name)
+	return nil
+}
+
+// handOver makes the node the owner of the volume
+func (m *SendRecvMigrator) handOver(ctx context.Context, name, node string) error {
+	vols := m.openebsClient.ZfsV1().ZFSVolumes(zfs.OpenEBSNamespace)
+	vol, err := vols.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	vol.Spec.OwnerNodeID = node
+	if vol.Labels != nil {
+		vol.Labels[zfs.ZFSNodeKey] = node
+	}
+	if _, err = vols.Update(ctx, vol, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to hand volume %s over to node %s: %w", name, node, err)
+	}
+	return nil
+}
+
+// movePV points the node affinity of the PV to the target node. The node
+// affinity is immutable, so the PV is deleted, with its reclaim policy set
+// to Retain meanwhile, and created again. The claim keeps its volume name
+// and binds to the PV again. The PV is created as it was if the new one
+// could not be.
+func (m *SendRecvMigrator) movePV(ctx context.Context, pv *corev1.PersistentVolume, source, target string) error {
+	if pv.Spec.NodeAffinity == nil || pv.Spec.NodeAffinity.Required == nil {
+		return nil
+	}
+	pvs := m.kubeClient.CoreV1().PersistentVolumes()
+
+	pv, err := pvs.Get(ctx, pv.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	original := recreatedPV(pv)
+	moved := recreatedPV(pv)
+	for i := range moved.Spec.NodeAffinity.Required.NodeSelectorTerms {
+		term := &moved.Spec.NodeAffinity.Required.NodeSelectorTerms[i]
+		for j := range term.MatchExpressions {
+			expr := &term.MatchExpressions[j]
+			if expr.Key != zfs.ZFSTopologyKey {
+				continue
+			}
+			for k, v := range expr.Values {
+				if v == source {
+					expr.Values[k] = target
+				}
+			}
+		}
+	}
+
+	if pv.Spec.PersistentVolumeReclaimPolicy != corev1.PersistentVolumeReclaimRetain {
+		pv.Spec.PersistentVolumeReclaimPolicy = corev1.PersistentVolumeReclaimRetain
+		if _, err = pvs.Update(ctx, pv, metav1.UpdateOptions{}); err != nil {
+			return fmt.Errorf("failed to retain pv %s: %w", pv.Name, err)
+		}
+	}
+	if err = pvs.Delete(ctx, pv.Name, metav1.DeleteOptions{}); err != nil && !k8serror.IsNotFound(err) {
+		return fmt.Errorf("failed to delete pv %s: %w", pv.Name, err)
+	}
+	if err = m.waitFor(ctx, func() (bool, error) {
+		_, err := pvs.Get(ctx, pv.Name, metav1.GetOptions{})
+		if k8serror.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	}); err != nil {
+		return err
+	}
+
+	if _, err = pvs.Create(ctx, moved, metav1.CreateOptions{}); err != nil {
+		if _, rerr := pvs.Create(context.TODO(), original, metav1.CreateOptions{}); rerr != nil {
+			klog.Errorf("drain: failed to create pv %s again: %v", pv.Name, rerr)
+		}
+		return fmt.Errorf("failed to create pv %s on node %s: %w", pv.Name, target, err)
+	}
+	klog.Infof("drain: moved pv %s to node %s", pv.Name, target)
+	return nil
+}
+
+// recreatedPV returns a copy of the PV which can be created again
+func recreatedPV(pv *corev1.PersistentVolume) *corev1.PersistentVolume {
+	created := &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        pv.Name,
+			Labels:      pv.Labels,
+			Annotations: pv.Annotations,
+		},
+		Spec: pv.Spec,
+	}
+	return created.DeepCopy()
+}
+
+// retire destroys the dataset of the volume on the node
+func (m *SendRecvMigrator) retire(vol *apis.ZFSVolume, node, name string) error {
+	vols := m.openebsClient.ZfsV1().ZFSVolumes(zfs.OpenEBSNamespace)
+	_, err := vols.Create(context.TODO(), zfs.RetiredVolume(vol, node, name), metav1.CreateOptions{})
+	if err != nil && !k8serror.IsAlreadyExists(err) {
+		return err
+	}
+	return vols.Delete(context.TODO(), name, metav1.DeleteOptions{})
+}
+
+func (m *SendRecvMigrator) waitFor(ctx context.Context, done func() (bool, error)) error {
+	timeout := m.Timeout
+	if timeout == 0 {
+		timeout = time.Hour
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for {
+		ok, err := done()
+		if err != nil || ok {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("drain: %w", ctx.Err())
+		case <-time.After(pollInterval):
+		}
+	}
+}
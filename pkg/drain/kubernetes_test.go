@@ -0,0 +1,284 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drain
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"github.com/openebs/zfs-localpv/pkg/generated/clientset/internalclientset/fake"
+	"github.com/openebs/zfs-localpv/pkg/zfs"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// migration runs Migrate against the fake clientsets, the node agents mark
+// the backups and restores done as soon as they are created and the
+// kubelet removes the evicted pods right away
+type migration struct {
+	migrator *SendRecvMigrator
+	kube     *kubefake.Clientset
+	openebs  *fake.Clientset
+	// steps are the backups, restores and evictions in their order
+	steps []string
+	// failRestore is the restore which fails
+	failRestore string
+}
+
+func newMigration(t *testing.T) *migration {
+	vol := &apis.ZFSVolume{}
+	vol.Name = "pvc-1"
+	vol.Namespace = zfs.OpenEBSNamespace
+	vol.Labels = map[string]string{zfs.ZFSNodeKey: "node-1"}
+	vol.Spec.OwnerNodeID = "node-1"
+	vol.Spec.PoolName = "zfspv"
+	vol.Spec.Capacity = "4096"
+
+	node := &corev1.Node{}
+	node.Name = "worker-1"
+	node.Labels = map[string]string{zfs.ZFSTopologyKey: "node-1"}
+
+	pv := &corev1.PersistentVolume{}
+	pv.Name = "pvc-1"
+	pv.Spec.PersistentVolumeReclaimPolicy = corev1.PersistentVolumeReclaimDelete
+	pv.Spec.ClaimRef = &corev1.ObjectReference{Namespace: "app", Name: "data", UID: "claim-uid"}
+	pv.Spec.NodeAffinity = &corev1.VolumeNodeAffinity{
+		Required: &corev1.NodeSelector{
+			NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+				MatchExpressions: []corev1.NodeSelectorRequirement{{
+					Key:      zfs.ZFSTopologyKey,
+					Operator: corev1.NodeSelectorOpIn,
+					Values:   []string{"node-1"},
+				}},
+			}},
+		},
+	}
+
+	claimPod := func(name, node, claim string) *corev1.Pod {
+		pod := &corev1.Pod{}
+		pod.Name = name
+		pod.Namespace = "app"
+		pod.Spec.NodeName = node
+		pod.Spec.Volumes = []corev1.Volume{{
+			Name: "data",
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: claim},
+			},
+		}}
+		return pod
+	}
+
+	m := &migration{
+		kube: kubefake.NewSimpleClientset(node, pv,
+			claimPod("app-0", "worker-1", "data"),
+			// created again by its controller, pending on the cordoned node
+			claimPod("app-1", "", "data"),
+			claimPod("other", "worker-1", "other")),
+		openebs: fake.NewSimpleClientset(vol),
+	}
+	m.migrator = (&SendRecvMigrator{
+		SendAddr: "10.0.0.1:9010",
+		RecvAddr: "10.0.0.2:9010",
+		Timeout:  time.Second,
+	}).WithClients(m.kube, m.openebs)
+
+	m.kube.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "eviction" {
+			return false, nil, nil
+		}
+		eviction := action.(k8stesting.CreateAction).GetObject().(metav1.Object)
+		m.steps = append(m.steps, "evict "+eviction.GetName())
+		gvr := corev1.SchemeGroupVersion.WithResource("pods")
+		return true, nil, m.kube.Tracker().Delete(gvr, "app", eviction.GetName())
+	})
+	m.openebs.PrependReactor("create", "zfsbackups", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		bkp := action.(k8stesting.CreateAction).GetObject().(*apis.ZFSBackup)
+		m.steps = append(m.steps, "backup "+bkp.Name)
+		bkp.Status = apis.BKPZFSStatusDone
+		return false, nil, nil
+	})
+	m.openebs.PrependReactor("create", "zfsrestores", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		rstr := action.(k8stesting.CreateAction).GetObject().(*apis.ZFSRestore)
+		m.steps = append(m.steps, "restore "+rstr.Name)
+		rstr.Status = apis.RSTZFSStatusDone
+		if rstr.Name == m.failRestore {
+			rstr.Status = apis.RSTZFSStatusFailed
+		}
+		return false, nil, nil
+	})
+	return m
+}
+
+func (m *migration) volume(t *testing.T) *apis.ZFSVolume {
+	vol, err := m.openebs.ZfsV1().ZFSVolumes(zfs.OpenEBSNamespace).Get(context.TODO(), "pvc-1", metav1.GetOptions{})
+	assert.NoError(t, err)
+	return vol
+}
+
+func (m *migration) pv(t *testing.T) *corev1.PersistentVolume {
+	pv, err := m.kube.CoreV1().PersistentVolumes().Get(context.TODO(), "pvc-1", metav1.GetOptions{})
+	assert.NoError(t, err)
+	return pv
+}
+
+// openebsActions returns the verb, name and node of the changes to the
+// resource
+func (m *migration) openebsActions(resource string) []string {
+	var actions []string
+	for _, action := range m.openebs.Actions() {
+		if action.GetResource().Resource != resource || action.GetVerb() == "get" || action.GetVerb() == "list" {
+			continue
+		}
+		if a, ok := action.(k8stesting.DeleteAction); ok {
+			actions = append(actions, "delete "+a.GetName())
+			continue
+		}
+		obj := action.(k8stesting.CreateAction).GetObject()
+		owner := obj.(metav1.Object).GetLabels()[zfs.ZFSNodeKey]
+		switch o := obj.(type) {
+		case *apis.ZFSBackup:
+			owner = o.Spec.OwnerNodeID
+		case *apis.ZFSRestore:
+			owner = o.Spec.OwnerNodeID
+		}
+		actions = append(actions, action.GetVerb()+" "+obj.(metav1.Object).GetName()+" "+owner)
+	}
+	return actions
+}
+
+func TestMigrate(t *testing.T) {
+	m := newMigration(t)
+
+	vol := m.volume(t)
+	assert.NoError(t, m.migrator.Migrate(context.Background(), vol, "node-2"))
+
+	// a full send while the workload runs, the final incremental send once
+	// the pod using the volume has been evicted
+	assert.Equal(t, []string{
+		"restore drain-pvc-1",
+		"backup drain-pvc-1",
+		"evict app-0",
+		"restore drain-pvc-1-final",
+		"backup drain-pvc-1-final",
+	}, m.steps)
+	var incremental []bool
+	for _, action := range m.openebs.Actions() {
+		if c, ok := action.(k8stesting.CreateAction); ok && action.Matches("create", "zfsrestores") {
+			incremental = append(incremental, c.GetObject().(*apis.ZFSRestore).Spec.Incremental)
+		}
+	}
+	assert.Equal(t, []bool{false, true}, incremental)
+
+	node, err := m.kube.CoreV1().Nodes().Get(context.TODO(), "worker-1", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.True(t, node.Spec.Unschedulable)
+
+	vol = m.volume(t)
+	assert.Equal(t, "node-2", vol.Spec.OwnerNodeID)
+	assert.Equal(t, "node-2", vol.Labels[zfs.ZFSNodeKey])
+
+	// the pv is created again pointing to the target node, bound to the claim
+	pv := m.pv(t)
+	assert.Equal(t, []string{"node-2"}, pv.Spec.NodeAffinity.Required.NodeSelectorTerms[0].MatchExpressions[0].Values)
+	assert.Equal(t, corev1.PersistentVolumeReclaimDelete, pv.Spec.PersistentVolumeReclaimPolicy)
+	assert.Equal(t, "data", pv.Spec.ClaimRef.Name)
+
+	// the snapshots received on the target node are destroyed with the
+	// backups moved there, the source dataset with the retired volume
+	assert.Equal(t, []string{
+		"create drain-pvc-1 node-1",
+		"create drain-pvc-1-final node-1",
+		"update drain-pvc-1 node-2",
+		"delete drain-pvc-1",
+		"update drain-pvc-1-final node-2",
+		"delete drain-pvc-1-final",
+	}, m.openebsActions("zfsbackups"))
+	assert.Equal(t, []string{
+		"update pvc-1 node-2",
+		"create drain-pvc-1 node-1",
+		"delete drain-pvc-1",
+	}, m.openebsActions("zfsvolumes"))
+	assert.Equal(t, []string{
+		"create drain-pvc-1 node-2",
+		"delete drain-pvc-1",
+		"create drain-pvc-1-final node-2",
+		"delete drain-pvc-1-final",
+	}, m.openebsActions("zfsrestores"))
+}
+
+func TestMigrateFailure(t *testing.T) {
+	// the final send fails, the dataset received on the target is destroyed
+	m := newMigration(t)
+	m.failRestore = "drain-pvc-1-final"
+
+	err := m.migrator.Migrate(context.Background(), m.volume(t), "node-2")
+	assert.EqualError(t, err, "restore drain-pvc-1-final on node node-2 failed")
+	assert.Equal(t, "node-1", m.volume(t).Spec.OwnerNodeID)
+	assert.Equal(t, []string{"node-1"}, m.pv(t).Spec.NodeAffinity.Required.NodeSelectorTerms[0].MatchExpressions[0].Values)
+	assert.Equal(t, []string{
+		"create drain-pvc-1 node-2",
+		"delete drain-pvc-1",
+	}, m.openebsActions("zfsvolumes"))
+	assert.Equal(t, []string{
+		"create drain-pvc-1 node-1",
+		"create drain-pvc-1-final node-1",
+		"delete drain-pvc-1",
+		"delete drain-pvc-1-final",
+	}, m.openebsActions("zfsbackups"))
+
+	// the pv can not be created again, the volume is handed back
+	m = newMigration(t)
+	m.kube.PrependReactor("create", "persistentvolumes", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		pv := action.(k8stesting.CreateAction).GetObject().(*corev1.PersistentVolume)
+		if pv.Spec.NodeAffinity.Required.NodeSelectorTerms[0].MatchExpressions[0].Values[0] == "node-2" {
+			return true, nil, errors.New("admission denied")
+		}
+		return false, nil, nil
+	})
+
+	err = m.migrator.Migrate(context.Background(), m.volume(t), "node-2")
+	assert.EqualError(t, err, "failed to create pv pvc-1 on node node-2: admission denied")
+	assert.Equal(t, "node-1", m.volume(t).Spec.OwnerNodeID)
+	assert.Equal(t, []string{"node-1"}, m.pv(t).Spec.NodeAffinity.Required.NodeSelectorTerms[0].MatchExpressions[0].Values)
+	assert.Equal(t, []string{
+		"update pvc-1 node-2",
+		"update pvc-1 node-1",
+		"create drain-pvc-1 node-2",
+		"delete drain-pvc-1",
+	}, m.openebsActions("zfsvolumes"))
+}
+
+func TestMigrateSnapshots(t *testing.T) {
+	m := newMigration(t)
+	snap := &apis.ZFSSnapshot{}
+	snap.Name = "snapshot-1"
+	snap.Namespace = zfs.OpenEBSNamespace
+	snap.Labels = map[string]string{zfs.ZFSVolKey: "pvc-1"}
+	_, err := m.openebs.ZfsV1().ZFSSnapshots(zfs.OpenEBSNamespace).Create(context.TODO(), snap, metav1.CreateOptions{})
+	assert.NoError(t, err)
+
+	err = m.migrator.Migrate(context.Background(), m.volume(t), "node-2")
+	assert.EqualError(t, err, "volume pvc-1 has snapshots, they can not be migrated")
+	assert.Empty(t, m.steps)
+}
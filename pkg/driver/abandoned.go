@@ -0,0 +1,137 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	clientset "github.com/openebs/zfs-localpv/pkg/generated/clientset/internalclientset"
+	"github.com/openebs/zfs-localpv/pkg/zfs"
+	corev1 "k8s.io/api/core/v1"
+	k8serror "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+// what the controller does with a volume whose pvc is deleted before its
+// provisioning is complete, the provisioner does not retry it anymore
+const (
+	// AbandonedVolumeCancel cancels the provisioning, the node agent
+	// destroys the dataset created for the volume and deletes it
+	AbandonedVolumeCancel = "cancel"
+	// AbandonedVolumeKeep only logs the volume, it is left to be deleted
+	// by hand
+	AbandonedVolumeKeep = "keep"
+)
+
+const (
+	// abandonedCollectInterval is the interval at which the volumes being
+	// provisioned are checked for their pvc
+	abandonedCollectInterval = time.Minute
+	// abandonedGrace is the age of a volume before it is checked, the
+	// provisioner is still retrying it before
+	abandonedGrace = 2 * time.Minute
+)
+
+// parseAbandonedVolumePolicy validates the policy of the volumes whose pvc
+// is deleted before their provisioning is complete
+func parseAbandonedVolumePolicy(policy string) (string, error) {
+	switch policy {
+	case AbandonedVolumeCancel, AbandonedVolumeKeep:
+		return policy, nil
+	}
+	return "", fmt.Errorf("invalid abandoned volume policy %q, it should be %s or %s",
+		policy, AbandonedVolumeCancel, AbandonedVolumeKeep)
+}
+
+// pvcAnnotations returns the annotations recording the pvc of the volume
+// on the ZFSVolume. The pvc is known only if the provisioner passes its
+// name, with --extra-create-metadata.
+func pvcAnnotations(params map[string]string) map[string]string {
+	ns, name := params["csi.storage.k8s.io/pvc/namespace"], params["csi.storage.k8s.io/pvc/name"]
+	if ns == "" || name == "" {
+		return nil
+	}
+	return map[string]string{zfs.PVCNamespaceKey: ns, zfs.PVCNameKey: name}
+}
+
+// pvcGone tells whether the pvc the volume is provisioned for is gone,
+// i.e. deleted, being deleted or bound to another volume
+func pvcGone(kube kubernetes.Interface, vol *apis.ZFSVolume) (bool, error) {
+	pvc, err := kube.CoreV1().PersistentVolumeClaims(vol.Annotations[zfs.PVCNamespaceKey]).
+		Get(context.TODO(), vol.Annotations[zfs.PVCNameKey], metav1.GetOptions{})
+	if k8serror.IsNotFound(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return abandonedBy(pvc, vol.Name), nil
+}
+
+// abandonedBy tells whether the pvc no longer waits for the volume
+func abandonedBy(pvc *corev1.PersistentVolumeClaim, volName string) bool {
+	return pvc.DeletionTimestamp != nil || (pvc.Spec.VolumeName != "" && pvc.Spec.VolumeName != volName)
+}
+
+// collectAbandonedVolumes cancels the provisioning of the volumes whose
+// pvc is gone before they are ready, it is run periodically by the
+// controller. Only the volumes recording their pvc are checked, the
+// volumes which are ready are complete and never cancelled.
+func (cs *controller) collectAbandonedVolumes() {
+	collectAbandonedVolumes(cs.openebsClient, cs.kubeClient, cs.abandonedVolume, time.Now())
+}
+
+func collectAbandonedVolumes(openebs clientset.Interface, kube kubernetes.Interface, policy string, now time.Time) {
+	volumes := openebs.ZfsV1().ZFSVolumes(zfs.OpenEBSNamespace)
+	vols, err := volumes.List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		klog.Errorf("could not list the volumes being provisioned: %v", err)
+		return
+	}
+	for i := range vols.Items {
+		vol := &vols.Items[i]
+		if zfs.IsVolumeReady(vol) || vol.DeletionTimestamp != nil || zfs.ProvisioningCancelled(vol) ||
+			vol.Annotations[zfs.PVCNameKey] == "" || now.Sub(vol.CreationTimestamp.Time) < abandonedGrace {
+			continue
+		}
+		gone, err := pvcGone(kube, vol)
+		if err != nil {
+			klog.Errorf("could not get pvc %s/%s of volume %s: %v", vol.Annotations[zfs.PVCNamespaceKey],
+				vol.Annotations[zfs.PVCNameKey], vol.Name, err)
+			continue
+		}
+		if !gone {
+			continue
+		}
+		if policy == AbandonedVolumeKeep {
+			klog.Warningf("pvc %s/%s of volume %s is gone before the volume is ready, the volume is kept",
+				vol.Annotations[zfs.PVCNamespaceKey], vol.Annotations[zfs.PVCNameKey], vol.Name)
+			continue
+		}
+		klog.Infof("pvc %s/%s of volume %s is gone before the volume is ready, cancelling its provisioning",
+			vol.Annotations[zfs.PVCNamespaceKey], vol.Annotations[zfs.PVCNameKey], vol.Name)
+		vol.Annotations[zfs.ProvisioningCancelKey] = "true"
+		if _, err = volumes.Update(context.TODO(), vol, metav1.UpdateOptions{}); err != nil {
+			klog.Errorf("could not cancel the provisioning of volume %s: %v", vol.Name, err)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	clientset "github.com/openebs/zfs-localpv/pkg/generated/clientset/internalclientset"
+	openebsfake "github.com/openebs/zfs-localpv/pkg/generated/clientset/internalclientset/fake"
+	"github.com/openebs/zfs-localpv/pkg/zfs"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// cancelledVolumes returns the volumes whose provisioning has been
+// cancelled
+func cancelledVolumes(t *testing.T, openebs clientset.Interface) []string {
+	vols, err := openebs.ZfsV1().ZFSVolumes(zfs.OpenEBSNamespace).List(context.TODO(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	var cancelled []string
+	for _, vol := range vols.Items {
+		if zfs.ProvisioningCancelled(&vol) {
+			cancelled = append(cancelled, vol.Name)
+		}
+	}
+	return cancelled
+}
+
+func abandonedPVC(name string) *corev1.PersistentVolumeClaim {
+	pvc := &corev1.PersistentVolumeClaim{}
+	pvc.Namespace = "app"
+	pvc.Name = name
+	return pvc
+}
+
+func abandonedVolume(name, pvc, state string, created time.Time) *apis.ZFSVolume {
+	vol := &apis.ZFSVolume{}
+	vol.Namespace = zfs.OpenEBSNamespace
+	vol.Name = name
+	vol.CreationTimestamp = metav1.NewTime(created)
+	vol.Status.State = state
+	if pvc != "" {
+		vol.Annotations = pvcAnnotations(map[string]string{
+			"csi.storage.k8s.io/pvc/namespace": "app",
+			"csi.storage.k8s.io/pvc/name":      pvc,
+		})
+	}
+	return vol
+}
+
+func TestCollectAbandonedVolumes(t *testing.T) {
+	now := time.Now()
+	old := now.Add(-10 * time.Minute)
+	deleting := abandonedPVC("deleting")
+	deleting.DeletionTimestamp = &metav1.Time{Time: now}
+	deleting.Finalizers = []string{"kubernetes.io/pvc-protection"}
+	rebound := abandonedPVC("rebound")
+	rebound.Spec.VolumeName = "pvc-other"
+	bound := abandonedPVC("bound")
+	bound.Spec.VolumeName = "pvc-bound"
+	kube := fake.NewSimpleClientset(deleting, rebound, abandonedPVC("waiting"), bound)
+
+	openebs := openebsfake.NewSimpleClientset(
+		abandonedVolume("pvc-gone", "gone", zfs.ZFSStatusPending, old),
+		abandonedVolume("pvc-failed", "failed", zfs.ZFSStatusFailed, old),
+		abandonedVolume("pvc-deleting", "deleting", zfs.ZFSStatusPending, old),
+		abandonedVolume("pvc-rebound", "rebound", zfs.ZFSStatusPending, old),
+		// still waited for by their pvc
+		abandonedVolume("pvc-waiting", "waiting", zfs.ZFSStatusPending, old),
+		abandonedVolume("pvc-bound", "bound", zfs.ZFSStatusPending, old),
+		// complete, too young to tell or with no pvc recorded
+		abandonedVolume("pvc-ready", "gone", zfs.ZFSStatusReady, old),
+		abandonedVolume("pvc-young", "gone", zfs.ZFSStatusPending, now.Add(-time.Minute)),
+		abandonedVolume("pvc-static", "", zfs.ZFSStatusPending, old),
+	)
+
+	collectAbandonedVolumes(openebs, kube, AbandonedVolumeCancel, now)
+	assert.ElementsMatch(t, []string{"pvc-gone", "pvc-failed", "pvc-deleting", "pvc-rebound"}, cancelledVolumes(t, openebs))
+
+	// the volumes already cancelled are not updated again
+	openebs.ClearActions()
+	collectAbandonedVolumes(openebs, kube, AbandonedVolumeCancel, now)
+	for _, action := range openebs.Actions() {
+		assert.NotEqual(t, "update", action.GetVerb())
+	}
+}
+
+func TestCollectAbandonedVolumesKeep(t *testing.T) {
+	now := time.Now()
+	openebs := openebsfake.NewSimpleClientset(
+		abandonedVolume("pvc-gone", "gone", zfs.ZFSStatusPending, now.Add(-time.Hour)),
+	)
+
+	collectAbandonedVolumes(openebs, fake.NewSimpleClientset(), AbandonedVolumeKeep, now)
+	assert.Empty(t, cancelledVolumes(t, openebs))
+}
+
+func TestParseAbandonedVolumePolicy(t *testing.T) {
+	for _, policy := range []string{AbandonedVolumeCancel, AbandonedVolumeKeep} {
+		got, err := parseAbandonedVolumePolicy(policy)
+		assert.NoError(t, err)
+		assert.Equal(t, policy, got)
+	}
+	_, err := parseAbandonedVolumePolicy("delete")
+	assert.Error(t, err)
+}
+
+func TestPVCAnnotations(t *testing.T) {
+	assert.Equal(t, map[string]string{zfs.PVCNamespaceKey: "app", zfs.PVCNameKey: "data"},
+		pvcAnnotations(map[string]string{
+			"csi.storage.k8s.io/pvc/namespace": "app",
+			"csi.storage.k8s.io/pvc/name":      "data",
+		}))
+	// the provisioner does not pass the pvc without --extra-create-metadata
+	assert.Nil(t, pvcAnnotations(map[string]string{"poolname": "zfspv-pool"}))
+}
@@ -17,34 +17,85 @@ limitations under the License.
 package driver
 
 import (
+	"errors"
+	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	k8sapi "github.com/openebs/lib-csi/pkg/client/k8s"
-	"github.com/openebs/lib-csi/pkg/mount"
 	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
 	"github.com/openebs/zfs-localpv/pkg/builder/volbuilder"
+	"github.com/openebs/zfs-localpv/pkg/collector"
+	clientset "github.com/openebs/zfs-localpv/pkg/generated/clientset/internalclientset"
 	"github.com/openebs/zfs-localpv/pkg/mgmt/backup"
 	"github.com/openebs/zfs-localpv/pkg/mgmt/restore"
 	"github.com/openebs/zfs-localpv/pkg/mgmt/snapshot"
 	"github.com/openebs/zfs-localpv/pkg/mgmt/volume"
 	"github.com/openebs/zfs-localpv/pkg/mgmt/zfsnode"
 	"github.com/openebs/zfs-localpv/pkg/zfs"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"golang.org/x/net/context"
 	"golang.org/x/sys/unix"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	k8serror "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog/v2"
 	"sigs.k8s.io/controller-runtime/pkg/runtime/signals"
 )
 
+// startMetricsServer registers the zfs collectors along with the
+// workqueue metrics of the controllers and serves them on the given address
+func startMetricsServer(addr, path string, queues *collector.WorkqueueMetrics) error {
+	registry := prometheus.NewRegistry()
+	for _, c := range []prometheus.Collector{
+		collector.NewPoolCollector(),
+		collector.NewARCCollector(zfs.NodeID),
+		queues,
+		collector.Pending,
+		collector.SnapshotSpace,
+		collector.Datasets,
+		collector.Deadman,
+		collector.Scans,
+		collector.Latency,
+		collector.Fill,
+		collector.Volumes,
+		collector.Breakdown,
+	} {
+		if err := registry.Register(c); err != nil {
+			return err
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(path, promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+
+	klog.Infof("serving metrics on %s%s", addr, path)
+	return http.ListenAndServe(addr, mux)
+}
+
+// getNode fetches the kubernetes node
+var getNode = k8sapi.GetNode
+
 // node is the server implementation
 // for CSI NodeServer
 type node struct {
-	driver *CSIDriver
+	driver  *CSIDriver
+	health  *volumeHealth
+	fences  *fenceLeases
+	mounter *mounter
+
+	// kubeClient reads the generated encryption keys of the volumes and
+	// openebsClient the ZFSVolumes unpublished, it saves those of the
+	// ephemeral volumes
+	kubeClient    kubernetes.Interface
+	openebsClient clientset.Interface
 }
 
 // NewNode returns a new instance
@@ -52,6 +103,49 @@ type node struct {
 func NewNode(d *CSIDriver) csi.NodeServer {
 	var ControllerMutex = sync.RWMutex{}
 
+	// fail fast on an invalid flag or if the zfs commands can not be run
+	// on this node
+	mounter, err := newMounter(d.config.PublishFailurePolicy, d.config.MissingTargetParent, d.config.FsGroupMode)
+	err = errors.Join(err,
+		zfs.SetCommands(d.config.ZFSPath, d.config.ZPoolPath, d.config.CommandPrefix),
+		zfs.SetDestroyGuard(d.config.DestroyGuard, d.config.DestroyGuardSize),
+		zfs.SetBusyRetry(d.config.BusyRetries, d.config.BusyRetryInterval, d.config.BusyRetryMaxInterval),
+		zfs.SetMaxSends(d.config.MaxSends),
+		zfs.SetSnapshotClonesPolicy(d.config.SnapshotClonesPolicy),
+		validateStaleMountMode(d.config.StaleMountCleanup),
+		zfs.SetDeviceRescanPolicy(d.config.DeviceRescan),
+		zfs.SetDataCheckRate(d.config.DataCheckRate),
+		zfs.SetZFSVersionPolicy(d.config.ZFSVersionPolicy, d.config.MinZFSVersion, d.config.MaxZFSVersion),
+		zfs.SetReservedSpacePercent(d.config.ReservedSpacePercent),
+		zfs.SetFailedCleanupGrace(d.config.FailedCleanupGrace),
+		zfs.SetStatusUpdateInterval(d.config.StatusUpdateInterval),
+		zfs.SetPoolSummaryInterval(d.config.PoolSummaryInterval),
+		zfs.SetDeadman(d.config.DeadmanWindow, d.config.DeadmanThreshold),
+		zfs.SetPoolFull(d.config.PoolFullThreshold, d.config.PoolFullCleanup),
+		zfs.SetFenceLease(d.config.FenceLease),
+		zfs.SetCapacityDriftMode(d.config.CapacityDriftMode),
+		zfs.SetDanglingSnapshotPolicy(d.config.DanglingSnapshotPolicy),
+		zfs.SetDestroyConcurrency(d.config.DestroyConcurrency),
+	)
+	if err != nil {
+		klog.Fatalf("init node: %v", err)
+	}
+	// refuse to start with an unsupported zfs version if asked to, the
+	// version is reported in the ZFSNode
+	if err := zfs.DetectZFSVersion(); err != nil {
+		klog.Fatalf("init node: %v", err)
+	}
+	zfs.SharedMountDir = filepath.Join(d.config.KubeletDir, "plugins", "zfs-localpv", "shared")
+	zfs.PoolAutoImport = d.config.PoolAutoImport
+	zfs.PoolAliasUpdate = d.config.PoolAliasUpdate
+
+	// the workqueues of the controllers report their metrics, they are
+	// created once the controllers are started below
+	queueMetrics := collector.NewWorkqueueMetrics()
+	if len(d.config.ListenAddress) > 0 {
+		workqueue.SetProvider(queueMetrics)
+	}
+
 	// set up signals so we handle the first shutdown signal gracefully
 	stopCh := signals.SetupSignalHandler()
 
@@ -63,6 +157,32 @@ func NewNode(d *CSIDriver) csi.NodeServer {
 		}
 	}()
 
+	kubeClient, err := k8sapi.Clientset().Get()
+	if err != nil {
+		klog.Fatalf("init node: %v", err)
+	}
+	cfg, err := k8sapi.Config().Get()
+	if err != nil {
+		klog.Fatalf("init node: %v", err)
+	}
+	openebsClient, err := clientset.NewForConfig(cfg)
+	if err != nil {
+		klog.Fatalf("init node: %v", err)
+	}
+
+	// look for the mounts left behind while the driver was down
+	cleaner := newMountCleaner(d.config.StaleMountCleanup, d.config.KubeletDir, d.config.DriverName,
+		kubeClient, openebsClient)
+	go func() {
+		if err := cleaner.run(); err != nil {
+			klog.Errorf("stale mounts: %v", err)
+		}
+	}()
+
+	// renew the lease of the agent, the controller only places the volumes
+	// on the nodes whose agent renews it
+	go zfs.RunNodeLease(kubeClient, zfs.NodeID, stopCh)
+
 	// start the zfsvolume watcher
 	go func() {
 		err := volume.Start(&ControllerMutex, stopCh)
@@ -95,8 +215,37 @@ func NewNode(d *CSIDriver) csi.NodeServer {
 		}
 	}()
 
+	// expose the zfs metrics of the node
+	if len(d.config.ListenAddress) > 0 {
+		go func() {
+			err := startMetricsServer(d.config.ListenAddress, d.config.MetricsPath, queueMetrics)
+			if err != nil {
+				klog.Errorf("Failed to start the metrics server: %s", err.Error())
+			}
+		}()
+	}
+
+	// check the condition of the published volumes in the background
+	var health *volumeHealth
+	if d.config.VolumeHealthInterval > 0 {
+		health = newVolumeHealth()
+		go health.run(d.config.VolumeHealthInterval, stopCh)
+	}
+
+	// keep the leases on the zvols published as block devices fresh
+	var fences *fenceLeases
+	if zfs.FenceLease > 0 {
+		fences = newFenceLeases()
+		go fences.run(zfs.FenceLease/3, stopCh)
+	}
+
 	return &node{
-		driver: d,
+		driver:        d,
+		health:        health,
+		fences:        fences,
+		mounter:       mounter,
+		kubeClient:    kubeClient,
+		openebsClient: openebsClient,
 	}
 }
 
@@ -104,15 +253,7 @@ func NewNode(d *CSIDriver) csi.NodeServer {
 func GetVolAndMountInfo(
 	req *csi.NodePublishVolumeRequest,
 ) (*apis.ZFSVolume, *zfs.MountInfo, error) {
-	var mountinfo zfs.MountInfo
-
-	mountinfo.FSType = req.GetVolumeCapability().GetMount().GetFsType()
-	mountinfo.MountPath = req.GetTargetPath()
-	mountinfo.MountOptions = append(mountinfo.MountOptions, req.GetVolumeCapability().GetMount().GetMountFlags()...)
-
-	if req.GetReadonly() {
-		mountinfo.MountOptions = append(mountinfo.MountOptions, "ro")
-	}
+	mountinfo := getMountInfo(req)
 
 	volName := strings.ToLower(req.GetVolumeId())
 
@@ -125,7 +266,26 @@ func GetVolAndMountInfo(
 		return nil, nil, err
 	}
 
-	return vol, &mountinfo, nil
+	// the pool may have been renamed, the volume controller updates the spec
+	if _, err = zfs.ResolveVolumePool(nil, vol, false); err != nil {
+		return nil, nil, err
+	}
+
+	return vol, mountinfo, nil
+}
+
+// getMountInfo returns the mount info from node csi volume request
+func getMountInfo(req *csi.NodePublishVolumeRequest) *zfs.MountInfo {
+	var mountinfo zfs.MountInfo
+
+	mountinfo.FSType = req.GetVolumeCapability().GetMount().GetFsType()
+	mountinfo.MountPath = req.GetTargetPath()
+	mountinfo.MountOptions = append(mountinfo.MountOptions, req.GetVolumeCapability().GetMount().GetMountFlags()...)
+
+	if req.GetReadonly() {
+		mountinfo.MountOptions = append(mountinfo.MountOptions, "ro")
+	}
+	return &mountinfo
 }
 
 // NodePublishVolume publishes (mounts) the volume
@@ -145,23 +305,54 @@ func (ns *node) NodePublishVolume(
 		return nil, err
 	}
 
+	if isEphemeralRequest(req) {
+		if req.GetVolumeCapability().GetBlock() != nil {
+			return nil, status.Error(codes.InvalidArgument,
+				"ephemeral inline volumes can only be mounted as a filesystem")
+		}
+		// the dataset only lives as long as the pod
+		if _, err = ns.publishEphemeral(req, getMountInfo(req)); err != nil {
+			return nil, err
+		}
+		if ns.health != nil {
+			ns.health.track(req.GetVolumeId(), req.GetTargetPath(), false)
+		}
+		return &csi.NodePublishVolumeResponse{}, nil
+	}
+
 	vol, mountInfo, err := GetVolAndMountInfo(req)
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
-	// If the access type is block, do nothing for stage
-	switch req.GetVolumeCapability().GetAccessType().(type) {
-	case *csi.VolumeCapability_Block:
-		// attempt block mount operation on the requested path
-		err = zfs.MountBlock(vol, mountInfo)
-	case *csi.VolumeCapability_Mount:
-		// attempt filesystem mount operation on the requested path
-		err = zfs.MountFilesystem(vol, mountInfo)
+	if err = ns.mounter.accessType(vol, req.GetVolumeCapability()); err != nil {
+		return nil, err
+	}
+	// the zvol must not be written to while it is preallocated
+	if err = zfs.CheckPreallocated(vol); err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
 	}
-
-	if err != nil {
+	// nor while it is copied into a new dataset
+	if err = zfs.CheckDefragmentation(vol); err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	// load the generated encryption key, e.g. after a node reboot
+	if err = zfs.LoadVolumeKey(ns.kubeClient, vol); err != nil {
+		if errors.Is(err, zfs.ErrKeyLost) {
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		}
 		return nil, status.Error(codes.Internal, err.Error())
 	}
+	if err = ns.mounter.publish(vol, mountInfo, req.GetVolumeCapability()); err != nil {
+		return nil, err
+	}
+
+	_, block := req.GetVolumeCapability().GetAccessType().(*csi.VolumeCapability_Block)
+	if ns.health != nil {
+		ns.health.track(req.GetVolumeId(), req.GetTargetPath(), block)
+	}
+	if ns.fences != nil && block {
+		ns.fences.track(req.GetVolumeId(), req.GetTargetPath())
+	}
 	return &csi.NodePublishVolumeResponse{}, nil
 }
 
@@ -186,13 +377,44 @@ func (ns *node) NodeUnpublishVolume(
 	targetPath := req.GetTargetPath()
 	volumeID := req.GetVolumeId()
 
-	if vol, err = zfs.GetZFSVolume(volumeID); err != nil {
+	if vol, err = ns.openebsClient.ZfsV1().ZFSVolumes(zfs.OpenEBSNamespace).
+		Get(ctx, volumeID, metav1.GetOptions{}); err != nil {
+		if !k8serror.IsNotFound(err) {
+			return nil, status.Errorf(codes.Internal,
+				"not able to get the ZFSVolume %s err : %s",
+				volumeID, err.Error())
+		}
+		// the volume is gone, the mounts left on the target path are
+		// cleaned up as per the mount table
+		if err = zfs.CleanupMountPoint(targetPath); err != nil {
+			return nil, status.Errorf(codes.Internal,
+				"unable to clean up the target path %s of the deleted volume %s err : %s",
+				targetPath, volumeID, err.Error())
+		}
+		klog.Infof("hostpath: volume %s is gone, path: %s has been cleaned up", volumeID, targetPath)
+		return &csi.NodeUnpublishVolumeResponse{}, nil
+	}
+	if _, err = zfs.ResolveVolumePool(nil, vol, false); err != nil {
 		return nil, status.Errorf(codes.Internal,
-			"not able to get the ZFSVolume %s err : %s",
+			"not able to resolve the pool of the ZFSVolume %s err : %s",
 			volumeID, err.Error())
 	}
 
+	if zfs.IsEphemeral(vol) {
+		if err = ns.unpublishEphemeral(vol, targetPath); err != nil {
+			return nil, err
+		}
+		if ns.health != nil {
+			ns.health.untrack(volumeID, targetPath)
+		}
+		return &csi.NodeUnpublishVolumeResponse{}, nil
+	}
+
 	err = zfs.UmountVolume(vol, targetPath)
+	if err == nil {
+		// a lingering bind mount or a path left by an earlier call
+		err = zfs.CleanupMountPoint(targetPath)
+	}
 
 	if err != nil {
 		return nil, status.Errorf(codes.Internal,
@@ -202,6 +424,17 @@ func (ns *node) NodeUnpublishVolume(
 	klog.Infof("hostpath: volume %s path: %s has been unmounted.",
 		volumeID, targetPath)
 
+	if ns.health != nil {
+		ns.health.untrack(volumeID, targetPath)
+	}
+
+	// the zvol might still be published at another block path
+	if ns.fences == nil || ns.fences.untrack(volumeID, targetPath) {
+		if err = zfs.ReleaseFence(vol); err != nil {
+			klog.Errorf("hostpath: failed to release the fence of volume %s: %v", volumeID, err)
+		}
+	}
+
 	return &csi.NodeUnpublishVolumeResponse{}, nil
 }
 
@@ -213,7 +446,7 @@ func (ns *node) NodeGetInfo(
 	req *csi.NodeGetInfoRequest,
 ) (*csi.NodeGetInfoResponse, error) {
 
-	node, err := k8sapi.GetNode(ns.driver.config.Nodename)
+	node, err := getNode(ns.driver.config.Nodename)
 	if err != nil {
 		klog.Errorf("failed to get the node %s", ns.driver.config.Nodename)
 		return nil, err
@@ -235,31 +468,8 @@ func (ns *node) NodeGetInfo(
 	 * }
 	 */
 
-	topology := map[string]string{}
-
-	// support topologykeys from env ALLOWED_TOPOLOGIES
-	allowedTopologies := strings.Trim(os.Getenv("ALLOWED_TOPOLOGIES"), " ")
-	if strings.ToLower(allowedTopologies) == "all" {
-		topology = node.Labels
-	} else {
-		allowedKeys := strings.Split(allowedTopologies, ",")
-		for _, key := range allowedKeys {
-			if key != "" {
-				if value, ok := node.Labels[key]; ok {
-					topology[key] = value
-				} else {
-					klog.Warningf("failed to get value for topology key: %s", key)
-				}
-			}
-		}
-	}
-
-	// add driver's topology key if not labelled already
-	if _, ok := topology[zfs.ZFSTopologyKey]; !ok {
-		topology[zfs.ZFSTopologyKey] = ns.driver.config.Nodename
-	}
-	// add old topology key to support backward compatibility for velero
-	topology[zfs.ZFSTopoNodenameKey] = ns.driver.config.Nodename
+	topology := nodeTopology(node.Labels, os.Getenv("ALLOWED_TOPOLOGIES"),
+		ns.driver.config.TopologyKeys, ns.driver.config.Nodename)
 
 	return &csi.NodeGetInfoResponse{
 		NodeId: ns.driver.config.Nodename,
@@ -278,7 +488,7 @@ func (ns *node) NodeGetCapabilities(
 	req *csi.NodeGetCapabilitiesRequest,
 ) (*csi.NodeGetCapabilitiesResponse, error) {
 
-	return &csi.NodeGetCapabilitiesResponse{
+	caps := &csi.NodeGetCapabilitiesResponse{
 		Capabilities: []*csi.NodeServiceCapability{
 			{
 				Type: &csi.NodeServiceCapability_Rpc{
@@ -295,7 +505,30 @@ func (ns *node) NodeGetCapabilities(
 				},
 			},
 		},
-	}, nil
+	}
+
+	if ns.health != nil {
+		caps.Capabilities = append(caps.Capabilities, &csi.NodeServiceCapability{
+			Type: &csi.NodeServiceCapability_Rpc{
+				Rpc: &csi.NodeServiceCapability_RPC{
+					Type: csi.NodeServiceCapability_RPC_VOLUME_CONDITION,
+				},
+			},
+		})
+	}
+
+	// the kubelet leaves the fsGroup to the node plugin
+	if zfs.FsGroupDelegated(ns.mounter.fsGroupMode) {
+		caps.Capabilities = append(caps.Capabilities, &csi.NodeServiceCapability{
+			Type: &csi.NodeServiceCapability_Rpc{
+				Rpc: &csi.NodeServiceCapability_RPC{
+					Type: csi.NodeServiceCapability_RPC_VOLUME_MOUNT_GROUP,
+				},
+			},
+		})
+	}
+
+	return caps, nil
 }
 
 // TODO
@@ -360,6 +593,11 @@ func (ns *node) NodeExpandVolume(
 			err.Error(),
 		)
 	}
+	if _, err = zfs.ResolveVolumePool(nil, vol, false); err != nil {
+		return nil, status.Errorf(codes.Internal,
+			"not able to resolve the pool of the ZFSVolume %s err : %s",
+			volumeID, err.Error())
+	}
 
 	// find if it is block device so that we don't attempt filesystem resize
 	st, err := os.Stat(req.GetVolumePath())
@@ -407,7 +645,7 @@ func (ns *node) NodeGetVolumeStats(
 		return nil, status.Error(codes.InvalidArgument, "path is not provided")
 	}
 
-	if !mount.IsMountPath(path) {
+	if !ns.mounter.isMounted(path) {
 		return nil, status.Error(codes.NotFound, "path is not a mount path")
 	}
 
@@ -430,7 +668,22 @@ func (ns *node) NodeGetVolumeStats(
 		Available: int64(sfs.Ffree),
 	})
 
-	return &csi.NodeGetVolumeStatsResponse{Usage: usage}, nil
+	resp := &csi.NodeGetVolumeStatsResponse{Usage: usage}
+
+	if ns.health != nil {
+		// pick up the volumes published before the plugin restarted
+		fi, err := os.Stat(path)
+		ns.health.track(volID, path, err == nil && fi.Mode()&os.ModeDevice != 0)
+		resp.VolumeCondition = ns.health.condition(volID)
+	}
+	if ns.fences != nil {
+		// pick up the block volumes published before the plugin restarted
+		if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeDevice != 0 {
+			ns.fences.track(volID, path)
+		}
+	}
+
+	return resp, nil
 }
 
 func (ns *node) validateNodePublishReq(
@@ -0,0 +1,308 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	openebsfake "github.com/openebs/zfs-localpv/pkg/generated/clientset/internalclientset/fake"
+	"github.com/openebs/zfs-localpv/pkg/zfs"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// fakePublish records the mount operations of publishVolume
+type fakePublish struct {
+	mounted    []string
+	unmounted  []string
+	rootGroups []string
+	fsGroups   []string
+	// targets are the mounted target paths
+	targets map[string]bool
+	// fsGroupErr is returned by applyFsGroup
+	fsGroupErr error
+	// targetErr is returned by checkTargetMount
+	targetErr error
+}
+
+// mounter returns the mounter doing the mount operations on f
+func (f *fakePublish) mounter() *mounter {
+	if f.targets == nil {
+		f.targets = map[string]bool{}
+	}
+	return &mounter{
+		failurePolicy: PublishFailureRollback,
+		missingParent: zfs.MissingParentCreate,
+		isMounted:     func(path string) bool { return f.targets[path] },
+		checkTarget:   func(*apis.ZFSVolume, string) error { return f.targetErr },
+		acquireFence:  func(*apis.ZFSVolume) error { return nil },
+		mountBlock: func(_ *apis.ZFSVolume, m *zfs.MountInfo) error {
+			f.mounted = append(f.mounted, "block "+m.MountPath)
+			f.targets[m.MountPath] = true
+			return nil
+		},
+		mountFilesystem: func(_ *apis.ZFSVolume, m *zfs.MountInfo) error {
+			f.mounted = append(f.mounted, "fs "+m.MountPath)
+			f.targets[m.MountPath] = true
+			return nil
+		},
+		unmount: func(_ *apis.ZFSVolume, path string) error {
+			f.unmounted = append(f.unmounted, path)
+			delete(f.targets, path)
+			return nil
+		},
+		rootPermissions: func(_ *apis.ZFSVolume, _ *zfs.MountInfo, group string) error {
+			f.rootGroups = append(f.rootGroups, group)
+			return nil
+		},
+		fsGroup: func(_ *apis.ZFSVolume, _ *zfs.MountInfo, _, group string) error {
+			f.fsGroups = append(f.fsGroups, group)
+			return f.fsGroupErr
+		},
+	}
+}
+
+func TestPublishVolumeRootPermissions(t *testing.T) {
+	f := &fakePublish{}
+	m := f.mounter()
+
+	vol := &apis.ZFSVolume{}
+	vol.Spec.RootUID = "1000"
+	vc := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{
+			Mount: &csi.VolumeCapability_MountVolume{VolumeMountGroup: "2000"},
+		},
+	}
+
+	err := m.publish(vol, &zfs.MountInfo{MountPath: "/mnt/fs"}, vc)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"fs /mnt/fs"}, f.mounted)
+	assert.Equal(t, []string{"2000"}, f.rootGroups)
+	assert.Equal(t, []string{"2000"}, f.fsGroups)
+}
+
+func TestPublishVolumeBlockSkipsRootPermissions(t *testing.T) {
+	f := &fakePublish{}
+	m := f.mounter()
+
+	vol := &apis.ZFSVolume{}
+	vol.Spec.RootUID = "1000"
+	vc := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+	}
+
+	err := m.publish(vol, &zfs.MountInfo{MountPath: "/mnt/block"}, vc)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"block /mnt/block"}, f.mounted)
+	assert.Empty(t, f.rootGroups)
+	assert.Empty(t, f.fsGroups)
+}
+
+func TestPublishVolumeRollback(t *testing.T) {
+	f := &fakePublish{fsGroupErr: errors.New("chown failed")}
+	m := f.mounter()
+
+	vol := &apis.ZFSVolume{}
+	vc := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{
+			Mount: &csi.VolumeCapability_MountVolume{VolumeMountGroup: "2000"},
+		},
+	}
+
+	// the mount of the failed call is rolled back
+	err := m.publish(vol, &zfs.MountInfo{MountPath: "/mnt/fs"}, vc)
+	assert.Equal(t, codes.Internal, status.Code(err))
+	assert.Contains(t, err.Error(), "chown failed")
+	assert.Equal(t, []string{"/mnt/fs"}, f.unmounted)
+	assert.False(t, f.targets["/mnt/fs"])
+
+	// the retry starts clean and succeeds
+	f.fsGroupErr = nil
+	err = m.publish(vol, &zfs.MountInfo{MountPath: "/mnt/fs"}, vc)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"fs /mnt/fs", "fs /mnt/fs"}, f.mounted)
+	assert.Equal(t, []string{"/mnt/fs"}, f.unmounted)
+	assert.True(t, f.targets["/mnt/fs"])
+}
+
+func TestPublishVolumeKeepsEarlierMount(t *testing.T) {
+	// the target was mounted by an earlier call, it is not unmounted
+	// when a later step fails
+	f := &fakePublish{
+		targets:    map[string]bool{"/mnt/fs": true},
+		fsGroupErr: errors.New("chown failed"),
+	}
+	m := f.mounter()
+
+	vc := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+	}
+	err := m.publish(&apis.ZFSVolume{}, &zfs.MountInfo{MountPath: "/mnt/fs"}, vc)
+	assert.Error(t, err)
+	assert.Empty(t, f.unmounted)
+	assert.True(t, f.targets["/mnt/fs"])
+}
+
+func TestPublishVolumeKeepPolicy(t *testing.T) {
+	f := &fakePublish{fsGroupErr: errors.New("chown failed")}
+	m := f.mounter()
+	m.failurePolicy = PublishFailureKeep
+
+	vc := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+	}
+	err := m.publish(&apis.ZFSVolume{}, &zfs.MountInfo{MountPath: "/mnt/fs"}, vc)
+	assert.Error(t, err)
+	assert.Empty(t, f.unmounted)
+	assert.True(t, f.targets["/mnt/fs"])
+
+	_, err = newMounter("retry", zfs.MissingParentCreate, zfs.FsGroupKubelet)
+	assert.Error(t, err)
+	_, err = newMounter(PublishFailureRollback, "ignore", zfs.FsGroupKubelet)
+	assert.Error(t, err)
+	_, err = newMounter(PublishFailureRollback, zfs.MissingParentCreate, "always")
+	assert.Error(t, err)
+}
+
+func TestPublishVolumeMissingParent(t *testing.T) {
+	f := &fakePublish{}
+	m := f.mounter()
+	m.missingParent = zfs.MissingParentFail
+
+	vc := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+	}
+	target := filepath.Join(t.TempDir(), "volumeDevices", "pvc-1")
+	err := m.publish(&apis.ZFSVolume{}, &zfs.MountInfo{MountPath: target}, vc)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	assert.Empty(t, f.mounted)
+
+	m.missingParent = zfs.MissingParentCreate
+	assert.NoError(t, m.publish(&apis.ZFSVolume{}, &zfs.MountInfo{MountPath: target}, vc))
+	assert.DirExists(t, filepath.Dir(target))
+	assert.Equal(t, []string{"block " + target}, f.mounted)
+}
+
+func TestPublishVolumeBlockSkipsMounted(t *testing.T) {
+	f := &fakePublish{targets: map[string]bool{"/mnt/block": true}}
+	m := f.mounter()
+
+	vc := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+	}
+	err := m.publish(&apis.ZFSVolume{}, &zfs.MountInfo{MountPath: "/mnt/block"}, vc)
+	assert.NoError(t, err)
+	assert.Empty(t, f.mounted)
+}
+
+func TestPublishVolumeTargetOfAnotherVolume(t *testing.T) {
+	// the target is mounted with another volume, it is neither mounted
+	// again nor unmounted
+	f := &fakePublish{
+		targets:   map[string]bool{"/mnt/fs": true, "/mnt/block": true},
+		targetErr: status.Error(codes.FailedPrecondition, "target is already a mount of zfspv/pvc-other"),
+	}
+	m := f.mounter()
+
+	for _, vc := range []*csi.VolumeCapability{
+		{AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}}},
+		{AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}}},
+	} {
+		target := "/mnt/fs"
+		if vc.GetBlock() != nil {
+			target = "/mnt/block"
+		}
+		err := m.publish(&apis.ZFSVolume{}, &zfs.MountInfo{MountPath: target}, vc)
+		assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	}
+	assert.Empty(t, f.mounted)
+	assert.Empty(t, f.unmounted)
+	assert.Empty(t, f.fsGroups)
+}
+
+func TestUnpublishDeletedVolume(t *testing.T) {
+	openebs := openebsfake.NewSimpleClientset()
+
+	// the target path left behind is removed, and the call can be retried
+	target := filepath.Join(t.TempDir(), "mount")
+	assert.NoError(t, os.Mkdir(target, 0750))
+	ns := &node{openebsClient: openebs}
+	req := &csi.NodeUnpublishVolumeRequest{VolumeId: "pvc-1", TargetPath: target}
+	for i := 0; i < 2; i++ {
+		_, err := ns.NodeUnpublishVolume(context.Background(), req)
+		assert.NoError(t, err)
+		_, err = os.Stat(target)
+		assert.True(t, os.IsNotExist(err))
+	}
+
+	// the volume can not be read
+	openebs.PrependReactor("get", "zfsvolumes", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("connection refused")
+	})
+	_, err := ns.NodeUnpublishVolume(context.Background(), req)
+	assert.Equal(t, codes.Internal, status.Code(err))
+}
+
+func TestCheckAccessType(t *testing.T) {
+	blockCap := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+	}
+	mountCap := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: "ext4"}},
+	}
+
+	dataset := &apis.ZFSVolume{}
+	dataset.Name = "pvc-fs"
+	dataset.Spec.VolumeType = zfs.VolTypeDataset
+	dataset.Spec.FsType = zfs.FSTypeZFS
+
+	// fs provisioned, requested as block
+	m := &mounter{checkAccessType: zfs.ValidateAccessType}
+	err := m.accessType(dataset, blockCap)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Contains(t, err.Error(), "provisioned as a filesystem")
+	assert.NoError(t, m.accessType(dataset, mountCap))
+
+	// block provisioned, requested as fs
+	m.checkAccessType = func(vol *apis.ZFSVolume, block bool) error {
+		if !block {
+			return fmt.Errorf("%w: volume %s was provisioned as a raw block device", zfs.ErrAccessTypeMismatch, vol.Name)
+		}
+		return nil
+	}
+	zvol := &apis.ZFSVolume{}
+	zvol.Name = "pvc-block"
+	zvol.Spec.VolumeType = zfs.VolTypeZVol
+
+	err = m.accessType(zvol, mountCap)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Contains(t, err.Error(), "provisioned as a raw block device")
+	assert.NoError(t, m.accessType(zvol, blockCap))
+
+	m.checkAccessType = func(*apis.ZFSVolume, bool) error { return errors.New("blkid failed") }
+	assert.Equal(t, codes.Internal, status.Code(m.accessType(zvol, mountCap)))
+}
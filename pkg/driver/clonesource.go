@@ -0,0 +1,186 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/openebs/lib-csi/pkg/common/helpers"
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"github.com/openebs/zfs-localpv/pkg/builder/snapbuilder"
+	"github.com/openebs/zfs-localpv/pkg/zfs"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+// source limit policies, what to do with a clone whose source exceeds the
+// limits
+const (
+	// SourceLimitWarn logs a warning and creates the clone, this is the
+	// default
+	SourceLimitWarn = "warn"
+
+	// SourceLimitReject fails the creation of the clone
+	SourceLimitReject = "reject"
+)
+
+// sourceLimits bounds the age of the origin snapshot of a clone and the
+// number of snapshots of the source volume. An old or long snapshot
+// history is kept as long as the clones depend on it, a full copy is then
+// a better base.
+type sourceLimits struct {
+	maxAge       time.Duration
+	maxSnapshots int
+	policy       string
+}
+
+// listSourceSnapshots returns the ZFSSnapshots of the volume, can be
+// replaced in unit tests
+var listSourceSnapshots = func(volume string) ([]apis.ZFSSnapshot, error) {
+	snaps, err := snapbuilder.NewKubeclient().
+		WithNamespace(zfs.OpenEBSNamespace).
+		List(metav1.ListOptions{LabelSelector: zfs.ZFSVolKey + "=" + volume})
+	if err != nil {
+		return nil, err
+	}
+	return snaps.Items, nil
+}
+
+// parseSourceLimits returns the limits of the clone source asked by the
+// maxsourceage, maxsourcesnapshots and sourcelimitpolicy parameters. It
+// returns nil if no limit is set.
+func parseSourceLimits(params map[string]string) (*sourceLimits, error) {
+	age := helpers.GetInsensitiveParameter(&params, "maxsourceage")
+	snaps := helpers.GetInsensitiveParameter(&params, "maxsourcesnapshots")
+	policy := helpers.GetInsensitiveParameter(&params, "sourcelimitpolicy")
+
+	l := &sourceLimits{policy: policy}
+	switch policy {
+	case "":
+		l.policy = SourceLimitWarn
+	case SourceLimitWarn, SourceLimitReject:
+	default:
+		return nil, fmt.Errorf("invalid sourcelimitpolicy %q, it should be %s or %s",
+			policy, SourceLimitWarn, SourceLimitReject)
+	}
+
+	if age != "" {
+		d, err := time.ParseDuration(age)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid maxsourceage %q, it should be a positive duration, e.g. 720h", age)
+		}
+		l.maxAge = d
+	}
+	if snaps != "" {
+		n, err := strconv.Atoi(snaps)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid maxsourcesnapshots %q, it should be a positive number", snaps)
+		}
+		l.maxSnapshots = n
+	}
+
+	if l.maxAge == 0 && l.maxSnapshots == 0 {
+		if policy != "" {
+			return nil, fmt.Errorf("sourcelimitpolicy %s needs maxsourceage or maxsourcesnapshots", policy)
+		}
+		return nil, nil
+	}
+	return l, nil
+}
+
+// exceeded returns the limits exceeded by a source of the given age
+// having the given number of snapshots
+func (l *sourceLimits) exceeded(age time.Duration, snapshots int) []string {
+	var reasons []string
+	if l.maxAge > 0 && age > l.maxAge {
+		reasons = append(reasons, fmt.Sprintf("origin snapshot is %s old, more than maxsourceage %s",
+			age.Truncate(time.Second), l.maxAge))
+	}
+	if l.maxSnapshots > 0 && snapshots > l.maxSnapshots {
+		reasons = append(reasons, fmt.Sprintf("source volume has %d snapshots, more than maxsourcesnapshots %d",
+			snapshots, l.maxSnapshots))
+	}
+	return reasons
+}
+
+// check verifies the source of the clone against the limits, the clone
+// is refused with the reject policy and only logged with the warn one
+func (l *sourceLimits) check(clone, volume string, age time.Duration) error {
+	var snapshots int
+	if l.maxSnapshots > 0 && volume != "" {
+		snaps, err := listSourceSnapshots(volume)
+		if err != nil {
+			return status.Errorf(codes.Internal,
+				"failed to list snapshots of volume %s: %s", volume, err.Error())
+		}
+		for _, snap := range snaps {
+			if snap.DeletionTimestamp == nil {
+				snapshots++
+			}
+		}
+	}
+
+	reasons := l.exceeded(age, snapshots)
+	if len(reasons) == 0 {
+		return nil
+	}
+	msg := strings.Join(reasons, ", ")
+	if l.policy == SourceLimitReject {
+		return status.Errorf(codes.FailedPrecondition,
+			"clone %s: %s, use a full copy of the volume as the source", clone, msg)
+	}
+	klog.Warningf("clone %s: %s, consider a full copy of the volume as the source", clone, msg)
+	return nil
+}
+
+// snapshotAge returns the age of the snapshot, from the creation time
+// reported by zfs if known
+func snapshotAge(snap *apis.ZFSSnapshot, now time.Time) time.Duration {
+	creation := snap.CreationTimestamp.Time
+	if snap.Status.CreationTime != nil {
+		creation = snap.Status.CreationTime.Time
+	}
+	if creation.IsZero() {
+		return 0
+	}
+	return now.Sub(creation)
+}
+
+// cloneKeySecret gives the clone of a volume with a generated encryption
+// key a copy of the key in a Secret of its own, so that it does not depend
+// on the Secret of the source volume
+func cloneKeySecret(kubeClient kubernetes.Interface, vol *apis.ZFSVolume) error {
+	if vol.Spec.KeySecret == "" {
+		return nil
+	}
+	secret, err := zfs.CopyKeySecret(kubeClient, vol.Spec.KeySecret, vol.Name)
+	if errors.Is(err, zfs.ErrKeyLost) {
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	vol.Spec.KeySecret = secret
+	return nil
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"github.com/openebs/zfs-localpv/pkg/zfs"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestParseSourceLimits(t *testing.T) {
+	l, err := parseSourceLimits(map[string]string{})
+	assert.NoError(t, err)
+	assert.Nil(t, l)
+
+	l, err = parseSourceLimits(map[string]string{"maxsourceage": "720h"})
+	assert.NoError(t, err)
+	assert.Equal(t, &sourceLimits{maxAge: 720 * time.Hour, policy: SourceLimitWarn}, l)
+
+	l, err = parseSourceLimits(map[string]string{"MaxSourceSnapshots": "10", "sourcelimitpolicy": "reject"})
+	assert.NoError(t, err)
+	assert.Equal(t, &sourceLimits{maxSnapshots: 10, policy: SourceLimitReject}, l)
+
+	for _, params := range []map[string]string{
+		{"maxsourceage": "30d"},
+		{"maxsourceage": "-1h"},
+		{"maxsourcesnapshots": "0"},
+		{"maxsourcesnapshots": "ten"},
+		{"maxsourceage": "1h", "sourcelimitpolicy": "fail"},
+		{"sourcelimitpolicy": "reject"},
+	} {
+		_, err := parseSourceLimits(params)
+		assert.Error(t, err, "parseSourceLimits(%v)", params)
+	}
+}
+
+func TestSourceLimitsExceeded(t *testing.T) {
+	l := &sourceLimits{maxAge: 24 * time.Hour, maxSnapshots: 5}
+
+	assert.Empty(t, l.exceeded(time.Hour, 5))
+	assert.Equal(t, []string{"origin snapshot is 48h0m0s old, more than maxsourceage 24h0m0s"},
+		l.exceeded(48*time.Hour, 1))
+	assert.Len(t, l.exceeded(48*time.Hour, 6), 2)
+
+	// the unset limits are not checked
+	assert.Empty(t, (&sourceLimits{maxSnapshots: 5}).exceeded(1000*time.Hour, 0))
+}
+
+func TestSourceLimitsCheck(t *testing.T) {
+	defer func(f func(string) ([]apis.ZFSSnapshot, error)) { listSourceSnapshots = f }(listSourceSnapshots)
+
+	deleting := apis.ZFSSnapshot{}
+	now := metav1.Now()
+	deleting.DeletionTimestamp = &now
+	listSourceSnapshots = func(string) ([]apis.ZFSSnapshot, error) {
+		return []apis.ZFSSnapshot{{}, {}, {}, deleting}, nil
+	}
+
+	// the snapshots being deleted are not counted
+	l := &sourceLimits{maxSnapshots: 3, policy: SourceLimitReject}
+	assert.NoError(t, l.check("pvc-clone", "pvc-1", 0))
+
+	l.maxSnapshots = 2
+	err := l.check("pvc-clone", "pvc-1", 0)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	assert.Contains(t, err.Error(), "source volume has 3 snapshots, more than maxsourcesnapshots 2")
+
+	// the warn policy creates the clone anyway
+	l.policy = SourceLimitWarn
+	assert.NoError(t, l.check("pvc-clone", "pvc-1", 0))
+
+	l = &sourceLimits{maxAge: time.Hour, policy: SourceLimitReject}
+	assert.Equal(t, codes.FailedPrecondition, status.Code(l.check("pvc-clone", "pvc-1", 2*time.Hour)))
+}
+
+func TestSnapshotAge(t *testing.T) {
+	now := time.Now()
+	snap := &apis.ZFSSnapshot{}
+	assert.Equal(t, time.Duration(0), snapshotAge(snap, now))
+
+	snap.CreationTimestamp = metav1.NewTime(now.Add(-2 * time.Hour))
+	assert.Equal(t, 2*time.Hour, snapshotAge(snap, now))
+
+	// the creation time reported by zfs is preferred
+	created := metav1.NewTime(now.Add(-3 * time.Hour))
+	snap.Status.CreationTime = &created
+	assert.Equal(t, 3*time.Hour, snapshotAge(snap, now))
+}
+
+func TestCloneKeySecret(t *testing.T) {
+	kube := fake.NewSimpleClientset()
+	vol := &apis.ZFSVolume{}
+	vol.Name = "pvc-2"
+	assert.NoError(t, cloneKeySecret(kube, vol))
+	assert.Empty(t, vol.Spec.KeySecret)
+
+	// the key of the source is copied into the secret of the clone
+	key := &corev1.Secret{}
+	key.Name = "zfs-key-pvc-1"
+	key.Namespace = zfs.OpenEBSNamespace
+	key.Data = map[string][]byte{zfs.KeySecretDataKey: []byte("00ff")}
+	_, err := kube.CoreV1().Secrets(zfs.OpenEBSNamespace).Create(context.TODO(), key, metav1.CreateOptions{})
+	assert.NoError(t, err)
+
+	vol.Spec.KeySecret = zfs.OpenEBSNamespace + "/zfs-key-pvc-1"
+	assert.NoError(t, cloneKeySecret(kube, vol))
+	assert.Equal(t, zfs.OpenEBSNamespace+"/zfs-key-pvc-2", vol.Spec.KeySecret)
+	_, err = kube.CoreV1().Secrets(zfs.OpenEBSNamespace).Get(context.TODO(), "zfs-key-pvc-2", metav1.GetOptions{})
+	assert.NoError(t, err)
+
+	// the data of the clone can not be decrypted without the key
+	vol.Spec.KeySecret = zfs.OpenEBSNamespace + "/zfs-key-gone"
+	vol.Name = "pvc-3"
+	err = cloneKeySecret(kube, vol)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
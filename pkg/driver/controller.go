@@ -2,8 +2,10 @@ package driver
 
 import (
 	"fmt"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
@@ -11,23 +13,30 @@ import (
 	k8sapi "github.com/openebs/lib-csi/pkg/client/k8s"
 	"github.com/openebs/lib-csi/pkg/common/errors"
 	"github.com/openebs/lib-csi/pkg/common/helpers"
-	schd "github.com/openebs/lib-csi/pkg/scheduler"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
 	k8serror "k8s.io/apimachinery/pkg/api/errors"
 	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
 	kubeinformers "k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/klog/v2"
 	"sigs.k8s.io/controller-runtime/pkg/manager/signals"
 
 	zfsapi "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
 	"github.com/openebs/zfs-localpv/pkg/builder/snapbuilder"
 	"github.com/openebs/zfs-localpv/pkg/builder/volbuilder"
+	"github.com/openebs/zfs-localpv/pkg/freeze"
 	clientset "github.com/openebs/zfs-localpv/pkg/generated/clientset/internalclientset"
+	openebsScheme "github.com/openebs/zfs-localpv/pkg/generated/clientset/internalclientset/scheme"
 	informers "github.com/openebs/zfs-localpv/pkg/generated/informer/externalversions"
 	csipayload "github.com/openebs/zfs-localpv/pkg/response"
 	"github.com/openebs/zfs-localpv/pkg/version"
@@ -63,6 +72,43 @@ type controller struct {
 
 	k8sNodeInformer cache.SharedIndexInformer
 	zfsNodeInformer cache.SharedIndexInformer
+
+	// propagate are the prefixes of the pvc labels and annotations
+	// mirrored on the ZFSVolume
+	propagate []string
+
+	// thinExpand is the policy of the expansion of the thin volumes
+	// beyond the size of their pool
+	thinExpand string
+
+	// maxDatasets is the number of datasets a pool can hold before the
+	// volumes are no longer placed on it, 0 does not limit them
+	maxDatasets int64
+
+	// locks serialize the mutating operations on each volume
+	locks *volumeLocks
+
+	// abandonedVolume is the policy of the volumes whose pvc is gone
+	// before they are ready
+	abandonedVolume string
+
+	// poolDegraded is the policy of the pools whose health degrades and
+	// poolHealth the health of each pool at the last check
+	poolDegraded string
+	poolHealth   map[string]string
+
+	// recorder raises the events on the ZFSNodes
+	recorder record.EventRecorder
+
+	// kubeClient and openebsClient are the clients of the api server
+	kubeClient    kubernetes.Interface
+	openebsClient clientset.Interface
+
+	// devClones are the pvcs whose dev clone request is being handled
+	devClones sync.Map
+	// cloneSnapshot creates the volume of a dev clone from its snapshot,
+	// it is CreateSnapClone
+	cloneSnapshot func(ctx context.Context, req *csi.CreateVolumeRequest, snapshot string) (string, error)
 }
 
 // NewController returns a new instance
@@ -72,6 +118,34 @@ func NewController(d *CSIDriver) csi.ControllerServer {
 		driver:       d,
 		capabilities: newControllerCapabilities(),
 	}
+	ctrl.cloneSnapshot = ctrl.CreateSnapClone
+	if err := zfs.SetNodeUnreachable(d.config.NodeUnreachableRetries,
+		d.config.NodeUnreachableRetryInterval, d.config.NodeGoneThreshold); err != nil {
+		klog.Fatalf("init controller: %v", err)
+	}
+	prefixes, err := parsePropagatePrefixes(d.config.PropagatePrefixes)
+	if err != nil {
+		klog.Fatalf("init controller: %v", err)
+	}
+	ctrl.propagate = prefixes
+	if ctrl.maxDatasets, err = parseMaxDatasetsPerPool(d.config.MaxDatasetsPerPool); err != nil {
+		klog.Fatalf("init controller: %v", err)
+	}
+	if ctrl.thinExpand, err = parseThinExpandPolicy(d.config.ThinExpandPolicy); err != nil {
+		klog.Fatalf("init controller: %v", err)
+	}
+	if err = setUnschedulableResponse(d.config.UnschedulableResponse); err != nil {
+		klog.Fatalf("init controller: %v", err)
+	}
+	if ctrl.locks, err = newVolumeLocks(d.config.VolumeLockPolicy); err != nil {
+		klog.Fatalf("init controller: %v", err)
+	}
+	if ctrl.abandonedVolume, err = parseAbandonedVolumePolicy(d.config.AbandonedVolumePolicy); err != nil {
+		klog.Fatalf("init controller: %v", err)
+	}
+	if ctrl.poolDegraded, err = setPoolDegradedPolicy(d.config.PoolDegradedPolicy); err != nil {
+		klog.Fatalf("init controller: %v", err)
+	}
 	if err := ctrl.init(); err != nil {
 		klog.Fatalf("init controller: %v", err)
 	}
@@ -95,6 +169,15 @@ func (cs *controller) init() error {
 		return errors.Wrap(err, "failed to build openebs clientset")
 	}
 
+	cs.kubeClient, cs.openebsClient = kubeClient, openebsClient
+
+	if err = openebsScheme.AddToScheme(scheme.Scheme); err != nil {
+		return errors.Wrap(err, "failed to add the openebs types to the scheme")
+	}
+	eventBroadcaster := record.NewBroadcaster()
+	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeClient.CoreV1().Events("")})
+	cs.recorder = eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "zfs-controller"})
+
 	kubeInformerFactory := kubeinformers.NewSharedInformerFactory(kubeClient, 0)
 	openebsInformerfactory := informers.NewSharedInformerFactoryWithOptions(openebsClient,
 		0, informers.WithNamespace(zfs.OpenEBSNamespace))
@@ -111,8 +194,28 @@ func (cs *controller) init() error {
 		return errors.Wrapf(err, "failed to add index on label %v", cs.indexedLabel)
 	}
 
+	// the snapshots are linked to their volume for the garbage collection
+	snapInformer := openebsInformerfactory.Zfs().V1().ZFSSnapshots().Informer()
+	snapInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    cs.addSnapshot,
+		UpdateFunc: cs.updateSnapshot,
+	})
+
 	go cs.k8sNodeInformer.Run(stopCh)
 	go cs.zfsNodeInformer.Run(stopCh)
+	go snapInformer.Run(stopCh)
+
+	synced := []cache.InformerSynced{cs.k8sNodeInformer.HasSynced, cs.zfsNodeInformer.HasSynced,
+		snapInformer.HasSynced}
+	// the pvcs are watched for the labels to propagate and the dev clone
+	// requests
+	pvcInformer := kubeInformerFactory.Core().V1().PersistentVolumeClaims().Informer()
+	pvcInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    cs.addPVC,
+		UpdateFunc: cs.updatePVC,
+	})
+	go pvcInformer.Run(stopCh)
+	synced = append(synced, pvcInformer.HasSynced)
 
 	if zfs.GoogleAnalyticsEnabled == "true" {
 		analytics.RegisterVersionGetter(version.GetVersionDetails)
@@ -123,10 +226,15 @@ func (cs *controller) init() error {
 
 	// wait for all the caches to be populated.
 	klog.Info("waiting for k8s & zfs node informer caches to be synced")
-	cache.WaitForCacheSync(stopCh,
-		cs.k8sNodeInformer.HasSynced,
-		cs.zfsNodeInformer.HasSynced)
+	cache.WaitForCacheSync(stopCh, synced...)
 	klog.Info("synced k8s & zfs node informer caches")
+
+	// the expired dev clones are deleted by the controller
+	go wait.Until(cs.collectDevClones, devCloneCollectInterval, stopCh)
+	// and the provisioning of the volumes whose pvc is gone is cancelled
+	go wait.Until(cs.collectAbandonedVolumes, abandonedCollectInterval, stopCh)
+	// and the health of the pools is followed
+	go wait.Until(cs.checkPoolHealth, poolHealthCheckInterval, stopCh)
 	return nil
 }
 
@@ -136,6 +244,9 @@ var SupportedVolumeCapabilityAccessModes = []*csi.VolumeCapability_AccessMode{
 	{
 		Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
 	},
+	{
+		Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY,
+	},
 }
 
 // sendEventOrIgnore sends anonymous local-pv provision/delete events
@@ -166,6 +277,26 @@ func getRoundedCapacity(size int64) int64 {
 	return ((size + Mi - 1) / Mi) * Mi
 }
 
+// getVolumeCapacity returns the rounded capacity of a new volume, it is
+// aligned to the recordsize, or the volblocksize for a zvol, if the
+// storageclass has aligncapacity set to yes.
+func getVolumeCapacity(req *csi.CreateVolumeRequest, defaultFsType string) (int64, error) {
+	size := getRoundedCapacity(req.GetCapacityRange().GetRequiredBytes())
+
+	originalParams := req.GetParameters()
+	parameters := helpers.GetCaseInsensitiveMap(&originalParams)
+	if parameters["aligncapacity"] != "yes" {
+		return size, nil
+	}
+
+	vtype := zfs.GetVolumeType(getFsType(req, parameters["fstype"], defaultFsType))
+	alignment, err := zfs.CapacityAlignment(vtype, parameters["recordsize"], parameters["volblocksize"])
+	if err != nil {
+		return 0, err
+	}
+	return zfs.AlignCapacity(size, alignment), nil
+}
+
 func waitForVolDestroy(volname string) error {
 	for {
 		_, err := zfs.GetZFSVolume(volname)
@@ -193,14 +324,38 @@ func waitForReadySnapshot(snapname string) error {
 		case zfs.ZFSStatusReady:
 			return nil
 		}
+		if err = snapshotError(snap, time.Now()); err != nil {
+			return err
+		}
 		time.Sleep(time.Second)
 	}
 }
 
+// getFsType returns the fstype from the storageclass, falling back to the
+// one in the volume capability and then to the driver default. Block
+// volumes are not formatted, so no default is applied for them.
+func getFsType(req *csi.CreateVolumeRequest, scFsType, defaultFsType string) string {
+	if scFsType != "" {
+		return scFsType
+	}
+	for _, volcap := range req.GetVolumeCapabilities() {
+		if volcap.GetBlock() != nil {
+			return ""
+		}
+		if fs := volcap.GetMount().GetFsType(); fs != "" {
+			return fs
+		}
+	}
+	return defaultFsType
+}
+
 // CreateZFSVolume create new zfs volume from csi volume request
-func CreateZFSVolume(ctx context.Context, req *csi.CreateVolumeRequest) (string, error) {
+func (cs *controller) CreateZFSVolume(ctx context.Context, req *csi.CreateVolumeRequest, defaultFsType string) (string, error) {
 	volName := strings.ToLower(req.GetName())
-	size := getRoundedCapacity(req.GetCapacityRange().RequiredBytes)
+	size, err := getVolumeCapacity(req, defaultFsType)
+	if err != nil {
+		return "", status.Error(codes.InvalidArgument, err.Error())
+	}
 
 	// parameter keys may be mistyped from the CRD specification when declaring
 	// the storageclass, which kubectl validation will not catch. Because ZFS
@@ -211,20 +366,135 @@ func CreateZFSVolume(ctx context.Context, req *csi.CreateVolumeRequest) (string,
 
 	rs := parameters["recordsize"]
 	bs := parameters["volblocksize"]
+	ds := parameters["dnodesize"]
+	acltype := parameters["acltype"]
+	aclmode := parameters["aclmode"]
+	xattr := parameters["xattr"]
 	compression := parameters["compression"]
 	dedup := parameters["dedup"]
+	rm := parameters["redundantmetadata"]
 	encr := parameters["encryption"]
 	kf := parameters["keyformat"]
 	kl := parameters["keylocation"]
 	pool := parameters["poolname"]
 	tp := parameters["thinprovision"]
 	schld := parameters["scheduler"]
-	fstype := parameters["fstype"]
+	fstype := getFsType(req, parameters["fstype"], defaultFsType)
 	shared := parameters["shared"]
 	quotatype := parameters["quotatype"]
+	snappolicy := parameters["snapshotpolicy"]
+	reclaimpolicy := parameters["reclaimpolicy"]
+	archivetarget := parameters["archivetarget"]
+	readonly := parameters["readonly"]
+	keymode := parameters["keymode"]
+	rootuid := parameters["rootuid"]
+	rootgid := parameters["rootgid"]
+	rootmode := parameters["rootmode"]
+	aligncapacity := parameters["aligncapacity"]
+	sharenfs := parameters["sharenfs"]
+	sharesmb := parameters["sharesmb"]
+	preallocate := parameters["preallocate"]
+	mkfsoptions := parameters["mkfsoptions"]
+	layout := parameters["layout"]
+
+	if fstype != "" {
+		if err := zfs.ValidateFsType(fstype); err != nil {
+			return "", status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+
+	if ds != "" {
+		if err := zfs.ValidateDnodeSize(ds); err != nil {
+			return "", status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+
+	if rm != "" {
+		if err := zfs.ValidateRedundantMetadata(rm); err != nil {
+			return "", status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+
+	if err := zfs.ValidateAcl(acltype, aclmode, xattr); err != nil {
+		return "", status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err := zfs.ValidateSnapshotPolicy(snappolicy); err != nil {
+		return "", status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err := zfs.ValidateReclaimPolicy(reclaimpolicy, archivetarget); err != nil {
+		return "", status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err := zfs.ValidateRootPermissions(rootuid, rootgid, rootmode); err != nil {
+		return "", status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if _, err := parsePerformanceClass(parameters); err != nil {
+		return "", status.Error(codes.InvalidArgument, err.Error())
+	}
 
 	vtype := zfs.GetVolumeType(fstype)
 
+	if err := validateShare(vtype, sharenfs, sharesmb); err != nil {
+		return "", status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	hostmountpoint, err := hostMountPoint(parameters, volName, vtype)
+	if err != nil {
+		return "", status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err := validateMkfsOptions(req, vtype, fstype, mkfsoptions); err != nil {
+		return "", status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err := zfs.ValidatePreallocate(preallocate, tp, vtype); err != nil {
+		return "", status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err := zfs.ValidateLayout(layout, vtype, quotatype, shared, hostmountpoint); err != nil {
+		return "", status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	// a new zvol can not be formatted once it is read-only
+	if readonly == "yes" && vtype == zfs.VolTypeZVol {
+		return "", status.Error(codes.InvalidArgument,
+			"readonly zvol can only be created as a clone of a volume or snapshot")
+	}
+
+	if keymode != "" {
+		if err := validateKeyMode(keymode, encr, kf, kl); err != nil {
+			return "", status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+
+	// the snapshot reserve is only used by GetCapacity, a bad value is
+	// reported here as well
+	if _, err := parseSnapshotReserve(helpers.GetInsensitiveParameter(&parameters, "snapshotreserve")); err != nil {
+		return "", status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	group, err := getVolumeGroup(cs.kubeClient, cs.openebsClient, parameters)
+	if err != nil {
+		return "", err
+	}
+	// the volumes of the group are placed one after the other
+	unlock, err := group.lock(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	// the zvols are thick unless asked otherwise while the datasets only
+	// reserve their capacity if asked for
+	thick := tp == "no" || (tp != "yes" && vtype == zfs.VolTypeZVol)
+	space, err := parseCapacityCheck(parameters["thincapacitycheck"], !thick, size)
+	if err != nil {
+		return "", status.Error(codes.InvalidArgument, err.Error())
+	}
+
 	capacity := strconv.FormatInt(int64(size), 10)
 
 	if vol, err := zfs.GetZFSVolume(volName); err == nil {
@@ -247,9 +517,15 @@ func CreateZFSVolume(ctx context.Context, req *csi.CreateVolumeRequest) (string,
 		}
 	}
 
-	nmap, err := getNodeMap(schld, pool)
+	var annotations map[string]string
+	if aligncapacity == "yes" {
+		annotations = map[string]string{zfs.AlignCapacityKey: "true"}
+	}
+
+	// the dataset name is shortened if pool/volume is too long for zfs
+	dsname, err := zfs.ShortDatasetName(pool, volName)
 	if err != nil {
-		return "", status.Errorf(codes.Internal, "get node map failed : %s", err.Error())
+		return "", status.Error(codes.InvalidArgument, err.Error())
 	}
 
 	var prfList []string
@@ -259,42 +535,90 @@ func CreateZFSVolume(ctx context.Context, req *csi.CreateVolumeRequest) (string,
 		prfList = append(prfList, node)
 	} else {
 		// run the scheduler
-		prfList = schd.Scheduler(req, nmap)
+		prfList, err = scheduleVolume(req, schld, pool, fstype, group, space, cs.requiredDatasetLimit())
+		if err != nil {
+			// the error tells why each node can not hold the volume
+			if _, ok := status.FromError(err); ok {
+				return "", err
+			}
+			return "", status.Errorf(codes.Internal, "get node map failed : %s", err.Error())
+		}
 	}
 
 	if len(prfList) == 0 {
 		return "", status.Error(codes.Internal, "scheduler failed, node list is empty for creating the PV")
 	}
 
+	kubeClient, err := k8sapi.Clientset().Get()
+	if err != nil {
+		return "", status.Error(codes.Internal, err.Error())
+	}
+
+	var keySecret string
+	if keymode == zfs.KeyModePerVolume {
+		keySecret, err = zfs.EnsureKeySecret(kubeClient, volName)
+		if err != nil {
+			return "", status.Error(codes.Internal, err.Error())
+		}
+		if encr == "" {
+			encr = "on"
+		}
+		kf, kl = "hex", "prompt"
+	}
+
 	volObj, err := volbuilder.NewBuilder().
 		WithName(volName).
+		WithAnnotations(annotations).
+		WithAnnotations(pvcAnnotations(parameters)).
+		WithLabels(group.labels()).
 		WithCapacity(capacity).
 		WithRecordSize(rs).
 		WithVolBlockSize(bs).
+		WithDnodeSize(ds).
+		WithAclType(acltype).
+		WithAclMode(aclmode).
+		WithXattr(xattr).
 		WithPoolName(pool).
+		WithDatasetName(dsname).
 		WithDedup(dedup).
+		WithRedundantMetadata(rm).
 		WithEncryption(encr).
 		WithKeyFormat(kf).
 		WithKeyLocation(kl).
+		WithKeySecret(keySecret).
 		WithThinProv(tp).
 		WithVolumeType(vtype).
 		WithVolumeStatus(zfs.ZFSStatusPending).
 		WithFsType(fstype).
 		WithQuotaType(quotatype).
 		WithShared(shared).
+		WithSnapshotPolicy(snappolicy).
+		WithReclaimPolicy(reclaimpolicy, archivetarget).
+		WithReadOnly(readonly).
+		WithShareNFS(sharenfs).
+		WithShareSMB(sharesmb).
+		WithHostMountPoint(hostmountpoint).
+		WithLayout(layout).
+		WithMkfsOptions(mkfsoptions).
+		WithPreallocate(preallocate).
+		WithRootPermissions(rootuid, rootgid, rootmode).
 		WithCompression(compression).Build()
 
 	if err != nil {
 		return "", status.Error(codes.Internal, err.Error())
 	}
+	zfs.SetRequestedCapacity(volObj, getRoundedCapacity(req.GetCapacityRange().GetRequiredBytes()), size)
 
 	klog.Infof("zfs: trying volume creation %s/%s on node %s", pool, volName, prfList)
 
-	// try volume creation sequentially on all nodes
+	// try volume creation sequentially on all nodes, skipping the nodes
+	// which are gone or whose agent is still not alive after the retries
+	var attempts nodeAttempts
 	for _, node := range prfList {
 		var nodeid string
-		nodeid, err = zfs.GetNodeID(node)
+		nodeid, err = zfs.ReachableNodeID(ctx, kubeClient, node)
 		if err != nil {
+			attempts.record(node, err)
 			continue
 		}
 
@@ -318,12 +642,77 @@ func CreateZFSVolume(ctx context.Context, req *csi.CreateVolumeRequest) (string,
 		zfs.DeleteVolume(volName) // ignore error
 	}
 
-	return "", status.Errorf(codes.Internal,
-		"not able to provision the volume, nodes %v, err : %s", prfList, err.Error())
+	return "", attempts.provisioningError(prfList, err)
+}
+
+// validateShare validates the sharenfs and sharesmb parameters, only the
+// datasets can be shared by zfs
+func validateShare(vtype, sharenfs, sharesmb string) error {
+	if err := zfs.ValidateShare("sharenfs", sharenfs); err != nil {
+		return err
+	}
+	if err := zfs.ValidateShare("sharesmb", sharesmb); err != nil {
+		return err
+	}
+	if vtype != zfs.VolTypeDataset && (sharenfs != "" || sharesmb != "") {
+		return fmt.Errorf("sharenfs and sharesmb can only be set for fstype zfs")
+	}
+	return nil
+}
+
+// validateMkfsOptions validates the mkfsoptions parameter against the
+// allowlist of the filesystem, only the zvols are formatted. The node
+// formats the zvol with the fstype of the volume capability, falling back
+// to the one of the volume, so the options are checked against the same.
+func validateMkfsOptions(req *csi.CreateVolumeRequest, vtype, fstype, options string) error {
+	if options == "" {
+		return nil
+	}
+	if vtype != zfs.VolTypeZVol {
+		return fmt.Errorf("mkfsoptions can only be set for the zvols")
+	}
+	for _, volcap := range req.GetVolumeCapabilities() {
+		if fs := volcap.GetMount().GetFsType(); fs != "" {
+			fstype = fs
+			break
+		}
+	}
+	if fstype == "" {
+		fstype = zfs.DefaultFsType
+	}
+	_, err := zfs.ParseMkfsOptions(fstype, options)
+	return err
+}
+
+// hostMountPoint returns the path on the node the dataset is mounted at as
+// per the hostmountpoint parameter, the directory the datasets are mounted
+// in. Only the datasets can be mounted by zfs.
+func hostMountPoint(params map[string]string, volName, vtype string) (string, error) {
+	dir := helpers.GetInsensitiveParameter(&params, "hostmountpoint")
+	if dir == "" {
+		return "", nil
+	}
+	if vtype != zfs.VolTypeDataset {
+		return "", fmt.Errorf("hostmountpoint can only be set for fstype zfs")
+	}
+	if err := zfs.ValidateHostMountPoint(dir); err != nil {
+		return "", err
+	}
+	return zfs.HostMountPath(dir, params["csi.storage.k8s.io/pvc/namespace"],
+		params["csi.storage.k8s.io/pvc/name"], volName), nil
+}
+
+// parseVerifySource validates the verifysource parameter of the clones
+func parseVerifySource(val string) (string, error) {
+	switch val {
+	case "", "no", "yes":
+		return val, nil
+	}
+	return "", fmt.Errorf("invalid verifysource %q, it should be yes or no", val)
 }
 
 // CreateVolClone creates the clone from a volume
-func CreateVolClone(ctx context.Context, req *csi.CreateVolumeRequest, srcVol string) (string, error) {
+func (cs *controller) CreateVolClone(ctx context.Context, req *csi.CreateVolumeRequest, srcVol string) (string, error) {
 	volName := strings.ToLower(req.GetName())
 	parameters := req.GetParameters()
 	// lower case keys, cf CreateZFSVolume()
@@ -336,16 +725,34 @@ func CreateVolClone(ctx context.Context, req *csi.CreateVolumeRequest, srcVol st
 		return "", status.Error(codes.NotFound, err.Error())
 	}
 
-	if vol.Spec.PoolName != pool {
-		return "", status.Errorf(codes.Internal,
-			"clone: different pool src pool %s dst pool %s",
-			vol.Spec.PoolName, pool)
+	// a clone must be in the zpool of its source, it is copied to the
+	// pools of the other zpools of the node
+	if zfs.NeedsPoolCopy(vol.Spec.PoolName, pool) {
+		if err := cs.checkPoolCopy(vol, pool); err != nil {
+			return "", err
+		}
 	}
 
 	if vol.Spec.Capacity != volsize {
 		return "", status.Error(codes.Internal, "clone: volume size is not matching")
 	}
 
+	// the origin snapshot is taken now, only the snapshots of the source
+	// volume can exceed the limits
+	limits, err := parseSourceLimits(parameters)
+	if err != nil {
+		return "", status.Error(codes.InvalidArgument, err.Error())
+	}
+	if limits != nil {
+		if err = limits.check(volName, vol.Name, 0); err != nil {
+			return "", err
+		}
+	}
+
+	// the clone is created on the node of its source
+	if err = cs.checkDatasetLimit(vol.Spec.OwnerNodeID, pool); err != nil {
+		return "", err
+	}
 	selected := vol.Spec.OwnerNodeID
 
 	labels := map[string]string{zfs.ZFSSrcVolKey: vol.Name}
@@ -355,14 +762,56 @@ func CreateVolClone(ctx context.Context, req *csi.CreateVolumeRequest, srcVol st
 	volObj, err := volbuilder.NewBuilder().
 		WithName(volName).
 		WithVolumeStatus(zfs.ZFSStatusPending).
+		WithAnnotations(pvcAnnotations(parameters)).
 		WithLabels(labels).Build()
 	if err != nil {
 		return "", err
 	}
 
 	volObj.Spec = vol.Spec
+	volObj.Spec.PoolName = pool
+	volObj.Spec.SourcePool = ""
+	if pool != vol.Spec.PoolName {
+		volObj.Spec.SourcePool = vol.Spec.PoolName
+	}
 	// use the snapshot name same as new volname
 	volObj.Spec.SnapName = vol.Name + "@" + volName
+	if vol.Spec.DatasetName != "" {
+		volObj.Spec.SnapName = vol.Spec.DatasetName + "@" + volName
+	}
+	if volObj.Spec.DatasetName, err = zfs.ShortDatasetName(pool, volName); err != nil {
+		return "", status.Error(codes.InvalidArgument, err.Error())
+	}
+	// the clone can be read-only even if the source volume is not
+	volObj.Spec.ReadOnly = helpers.GetInsensitiveParameter(&parameters, "readonly")
+	// the clone inherits the properties of the source unless asked otherwise
+	cloneOverrides(volObj, parameters)
+	// the root of the clone already has the permissions of the source
+	volObj.Spec.RootUID, volObj.Spec.RootGID, volObj.Spec.RootMode = "", "", ""
+	// the data of the clone must not be overwritten
+	volObj.Spec.Preallocate = ""
+	// the clone is mounted at a path of its own, if any
+	if volObj.Spec.HostMountPoint, err = hostMountPoint(parameters, volName, volObj.Spec.VolumeType); err != nil {
+		return "", status.Error(codes.InvalidArgument, err.Error())
+	}
+	// the threshold is about the clone, it is not taken from the source
+	if volObj.Spec.IndependenceThreshold, err = zfs.ParseIndependenceThreshold(
+		helpers.GetInsensitiveParameter(&parameters, "independencethreshold")); err != nil {
+		return "", status.Error(codes.InvalidArgument, err.Error())
+	}
+	// the origin snapshot is verified on the node before the clone is created
+	if volObj.Spec.VerifySource, err = parseVerifySource(
+		helpers.GetInsensitiveParameter(&parameters, "verifysource")); err != nil {
+		return "", status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	kubeClient, err := k8sapi.Clientset().Get()
+	if err != nil {
+		return "", status.Error(codes.Internal, err.Error())
+	}
+	if err = cloneKeySecret(kubeClient, volObj); err != nil {
+		return "", err
+	}
 
 	_, err = zfs.ProvisionVolume(ctx, volObj)
 	if err != nil {
@@ -374,7 +823,7 @@ func CreateVolClone(ctx context.Context, req *csi.CreateVolumeRequest, srcVol st
 }
 
 // CreateSnapClone creates the clone from a snapshot
-func CreateSnapClone(ctx context.Context, req *csi.CreateVolumeRequest, snapshot string) (string, error) {
+func (cs *controller) CreateSnapClone(ctx context.Context, req *csi.CreateVolumeRequest, snapshot string) (string, error) {
 	volName := strings.ToLower(req.GetName())
 	parameters := req.GetParameters()
 	// lower case keys, cf CreateZFSVolume()
@@ -382,43 +831,87 @@ func CreateSnapClone(ctx context.Context, req *csi.CreateVolumeRequest, snapshot
 	size := getRoundedCapacity(req.GetCapacityRange().RequiredBytes)
 	volsize := strconv.FormatInt(int64(size), 10)
 
-	snapshotID := strings.Split(snapshot, "@")
-	if len(snapshotID) != 2 {
-		return "", status.Errorf(
-			codes.NotFound,
-			"snap name is not valid %s, {%s}",
-			snapshot,
-			"invalid snapshot name",
-		)
-	}
-
-	snap, err := zfs.GetZFSSnapshot(snapshotID[1])
+	snap, err := resolveRestoreSnapshot(snapshot)
 	if err != nil {
-		return "", status.Error(codes.NotFound, err.Error())
+		return "", err
 	}
 
-	if snap.Spec.PoolName != pool {
-		return "", status.Errorf(codes.Internal,
-			"clone to a different pool src pool %s dst pool %s",
-			snap.Spec.PoolName, pool)
+	// a clone must be in the zpool of its snapshot, it is copied to the
+	// pools of the other zpools of the node
+	if zfs.NeedsPoolCopy(snap.Spec.PoolName, pool) {
+		src := &zfsapi.ZFSVolume{Spec: snap.Spec.VolumeInfo}
+		src.Name = snap.Labels[zfs.ZFSVolKey]
+		if err = cs.checkPoolCopy(src, pool); err != nil {
+			return "", err
+		}
 	}
 
 	if snap.Spec.Capacity != volsize {
 		return "", status.Error(codes.Internal, "clone volume size is not matching")
 	}
 
+	limits, err := parseSourceLimits(parameters)
+	if err != nil {
+		return "", status.Error(codes.InvalidArgument, err.Error())
+	}
+	if limits != nil {
+		if err = limits.check(volName, snap.Labels[zfs.ZFSVolKey], snapshotAge(snap, time.Now())); err != nil {
+			return "", err
+		}
+	}
+
+	if err = checkRestoreNode(req, snap); err != nil {
+		return "", err
+	}
+	if err = cs.checkDatasetLimit(snap.Spec.OwnerNodeID, pool); err != nil {
+		return "", err
+	}
 	selected := snap.Spec.OwnerNodeID
 
 	volObj, err := volbuilder.NewBuilder().
 		WithName(volName).
 		WithVolumeStatus(zfs.ZFSStatusPending).
+		WithAnnotations(pvcAnnotations(parameters)).
 		Build()
 	if err != nil {
 		return "", err
 	}
 
-	volObj.Spec = snap.Spec
-	volObj.Spec.SnapName = strings.ToLower(snapshot)
+	restoreSpec(volObj, snap, pool, parameters)
+	volObj.Spec.SnapName = snap.Labels[zfs.ZFSVolKey] + "@" + snap.Name
+	if snap.Spec.DatasetName != "" {
+		volObj.Spec.SnapName = snap.Spec.DatasetName + "@" + snap.Name
+	}
+	if volObj.Spec.DatasetName, err = zfs.ShortDatasetName(pool, volName); err != nil {
+		return "", status.Error(codes.InvalidArgument, err.Error())
+	}
+	volObj.Spec.ReadOnly = helpers.GetInsensitiveParameter(&parameters, "readonly")
+	// the root of the clone already has the permissions of the source
+	volObj.Spec.RootUID, volObj.Spec.RootGID, volObj.Spec.RootMode = "", "", ""
+	// the data of the clone must not be overwritten
+	volObj.Spec.Preallocate = ""
+	// the clone is mounted at a path of its own, if any
+	if volObj.Spec.HostMountPoint, err = hostMountPoint(parameters, volName, volObj.Spec.VolumeType); err != nil {
+		return "", status.Error(codes.InvalidArgument, err.Error())
+	}
+	// the threshold is about the clone, it is not taken from the source
+	if volObj.Spec.IndependenceThreshold, err = zfs.ParseIndependenceThreshold(
+		helpers.GetInsensitiveParameter(&parameters, "independencethreshold")); err != nil {
+		return "", status.Error(codes.InvalidArgument, err.Error())
+	}
+	// the origin snapshot is verified on the node before the clone is created
+	if volObj.Spec.VerifySource, err = parseVerifySource(
+		helpers.GetInsensitiveParameter(&parameters, "verifysource")); err != nil {
+		return "", status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	kubeClient, err := k8sapi.Clientset().Get()
+	if err != nil {
+		return "", status.Error(codes.Internal, err.Error())
+	}
+	if err = cloneKeySecret(kubeClient, volObj); err != nil {
+		return "", err
+	}
 
 	_, err = zfs.ProvisionVolume(ctx, volObj)
 	if err != nil {
@@ -453,23 +946,30 @@ func (cs *controller) CreateVolume(
 	if contentSource != nil && contentSource.GetSnapshot() != nil {
 		snapshotID := contentSource.GetSnapshot().GetSnapshotId()
 
-		selectedNodeId, err = CreateSnapClone(ctx, req, snapshotID)
+		selectedNodeId, err = cs.CreateSnapClone(ctx, req, snapshotID)
 	} else if contentSource != nil && contentSource.GetVolume() != nil {
 		srcVol := contentSource.GetVolume().GetVolumeId()
-		selectedNodeId, err = CreateVolClone(ctx, req, srcVol)
+		selectedNodeId, err = cs.CreateVolClone(ctx, req, srcVol)
 	} else {
-		selectedNodeId, err = CreateZFSVolume(ctx, req)
+		selectedNodeId, err = cs.CreateZFSVolume(ctx, req, cs.driver.config.DefaultFsType)
+		if err == nil {
+			// the capacity may have been aligned to the recordsize
+			size, err = getVolumeCapacity(req, cs.driver.config.DefaultFsType)
+		}
 	}
 
 	if err != nil {
 		return nil, err
 	}
+	if err = cs.propagateFromPVC(parameters, volName); err != nil {
+		return nil, err
+	}
 
 	klog.Infof("created the volume %s/%s on node %s", pool, volName, selectedNodeId)
 
 	sendEventOrIgnore(pvcName, volName, strconv.FormatInt(int64(size), 10), analytics.VolumeProvision)
 
-	topology := map[string]string{zfs.ZFSTopologyKey: selectedNodeId}
+	topology := volumeTopology(req, selectedNodeId, cs.driver.config.TopologyKeys)
 	cntx := map[string]string{zfs.PoolNameKey: pool, zfs.OpenEBSCasTypeKey: zfs.ZFSCasTypeName}
 
 	return csipayload.NewCreateVolumeResponseBuilder().
@@ -481,6 +981,63 @@ func (cs *controller) CreateVolume(
 		Build(), nil
 }
 
+// snapshotsToDelete returns the snapshots to be deleted along with the
+// volume as per its snapshot policy, the block policy refuses the deletion
+// while the volume has snapshots.
+func snapshotsToDelete(policy string, snaps []string) ([]string, error) {
+	switch policy {
+	case zfs.SnapshotPolicyDelete:
+		return snaps, nil
+	case zfs.SnapshotPolicyOrphan:
+		return nil, nil
+	}
+	if len(snaps) != 0 {
+		return nil, status.Errorf(codes.FailedPrecondition,
+			"volume has snapshots %v, delete them first or use snapshotpolicy %s or %s",
+			snaps, zfs.SnapshotPolicyDelete, zfs.SnapshotPolicyOrphan)
+	}
+	return nil, nil
+}
+
+// handleSnapshotPolicy applies the snapshot policy of the volume before
+// deleting it, the orphaned snapshots are released with the client
+func handleSnapshotPolicy(client clientset.Interface, vol *zfsapi.ZFSVolume) error {
+	snapList, err := snapbuilder.NewKubeclient().
+		WithNamespace(zfs.OpenEBSNamespace).
+		List(metav1.ListOptions{
+			LabelSelector: zfs.ZFSVolKey + "=" + vol.Name,
+		})
+	if err != nil {
+		return status.Errorf(codes.Internal,
+			"failed to list snapshots of volume %s: %s", vol.Name, err.Error())
+	}
+
+	var snaps []string
+	for _, snap := range snapList.Items {
+		snaps = append(snaps, snap.Name)
+	}
+	// the orphaned snapshots must not be garbage collected with the volume
+	if vol.Spec.SnapshotPolicy == zfs.SnapshotPolicyOrphan {
+		if err := releaseSnapshots(client, vol, snapList.Items); err != nil {
+			return status.Errorf(codes.Internal,
+				"failed to release the snapshots of volume %s: %s", vol.Name, err.Error())
+		}
+	}
+
+	toDelete, err := snapshotsToDelete(vol.Spec.SnapshotPolicy, snaps)
+	if err != nil {
+		return err
+	}
+	for _, snap := range toDelete {
+		klog.Infof("deleting snapshot %s of volume %s as per the snapshot policy", snap, vol.Name)
+		if err := zfs.DeleteSnapshot(snap); err != nil {
+			return status.Errorf(codes.Internal,
+				"failed to delete snapshot %s: %s", snap, err.Error())
+		}
+	}
+	return nil
+}
+
 // DeleteVolume deletes the specified volume
 func (cs *controller) DeleteVolume(
 	ctx context.Context,
@@ -494,6 +1051,12 @@ func (cs *controller) DeleteVolume(
 
 	volumeID := strings.ToLower(req.GetVolumeId())
 
+	unlock, err := cs.locks.lock(ctx, volumeID, opDelete)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
 	// verify if the volume has already been deleted
 	vol, err := zfs.GetVolume(volumeID)
 	if vol != nil && vol.DeletionTimestamp != nil {
@@ -516,6 +1079,10 @@ func (cs *controller) DeleteVolume(
 		return nil, status.Error(codes.Internal, "can not delete, volume creation is in progress")
 	}
 
+	if err = handleSnapshotPolicy(cs.openebsClient, vol); err != nil {
+		return nil, err
+	}
+
 	// Delete the corresponding ZV CR
 	err = zfs.DeleteVolume(volumeID)
 	if err != nil {
@@ -551,6 +1118,19 @@ func isValidVolumeCapabilities(volCaps []*csi.VolumeCapability) bool {
 	return foundAll
 }
 
+// hasWriteAccessMode tells whether any of the capabilities asks for write access
+func hasWriteAccessMode(volCaps []*csi.VolumeCapability) bool {
+	for _, c := range volCaps {
+		switch c.GetAccessMode().GetMode() {
+		case csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY,
+			csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY:
+		default:
+			return true
+		}
+	}
+	return false
+}
+
 // TODO Implementation will be taken up later
 
 // ValidateVolumeCapabilities validates the capabilities
@@ -569,10 +1149,17 @@ func (cs *controller) ValidateVolumeCapabilities(
 		return nil, status.Error(codes.InvalidArgument, "Volume capabilities not provided")
 	}
 
-	if _, err := zfs.GetZFSVolume(volumeID); err != nil {
+	vol, err := zfs.GetZFSVolume(volumeID)
+	if err != nil {
 		return nil, status.Errorf(codes.NotFound, "Get volume failed err %s", err.Error())
 	}
 
+	if zfs.IsReadOnly(vol) && hasWriteAccessMode(volCaps) {
+		return &csi.ValidateVolumeCapabilitiesResponse{
+			Message: "volume is read-only, write access modes are not supported",
+		}, nil
+	}
+
 	var confirmed *csi.ValidateVolumeCapabilitiesResponse_Confirmed
 	if isValidVolumeCapabilities(volCaps) {
 		confirmed = &csi.ValidateVolumeCapabilitiesResponse_Confirmed{VolumeCapabilities: volCaps}
@@ -612,6 +1199,12 @@ func (cs *controller) ControllerExpandVolume(
 		)
 	}
 
+	unlock, err := cs.locks.lock(ctx, volumeID, opExpand)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
 	/* round off the new size */
 	updatedSize := getRoundedCapacity(req.GetCapacityRange().GetRequiredBytes())
 
@@ -634,6 +1227,18 @@ func (cs *controller) ControllerExpandVolume(
 			err.Error(),
 		)
 	}
+
+	requestedSize := updatedSize
+	alignment, err := zfs.VolumeAlignment(vol)
+	if err != nil {
+		return nil, status.Errorf(
+			codes.InvalidArgument,
+			"ControllerExpandVolumeRequest: can not align the capacity of %s, {%s}",
+			volumeID,
+			err.Error(),
+		)
+	}
+	updatedSize = zfs.AlignCapacity(updatedSize, alignment)
 	/*
 	 * Controller expand volume must be idempotent. If a volume corresponding
 	 * to the specified volume ID is already larger than or equal to the target
@@ -645,6 +1250,13 @@ func (cs *controller) ControllerExpandVolume(
 			Build(), nil
 	}
 
+	if err := cs.checkThinExpand(vol, updatedSize); err != nil {
+		return nil, err
+	}
+
+	if alignment != 0 {
+		zfs.SetRequestedCapacity(vol, requestedSize, updatedSize)
+	}
 	if err := zfs.ResizeVolume(vol, updatedSize); err != nil {
 		return nil, status.Errorf(
 			codes.Internal,
@@ -703,26 +1315,44 @@ func (cs *controller) CreateSnapshot(
 	snapName := strings.ToLower(req.GetName())
 	volumeID := strings.ToLower(req.GetSourceVolumeId())
 	klog.Infof("CreateSnapshot volume %s@%s", volumeID, snapName)
-	err := verifySnapshotRequest(req)
+	originalParams := req.GetParameters()
+	parameters := helpers.GetCaseInsensitiveMap(&originalParams)
+	timeout := parameters["snapshottimeout"]
+	if _, err := zfs.ParseSnapshotTimeout(timeout); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	props, err := zfs.ParseSnapshotProperties(parameters["snapshotproperties"])
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	sync := parameters["snapshotsync"]
+	if _, err := zfs.ParseSnapshotSync(sync); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	durability := parameters["snapshotdurability"]
+	if _, err := zfs.ParseSnapshotDurability(durability); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	freezePlugin, freezeParams := freeze.Parameters(parameters)
+
+	// the snapshot copies the spec of the volume, it must not race an
+	// expand of the volume
+	unlock, err := cs.locks.lock(ctx, volumeID, opCreateSnapshot)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	err = verifySnapshotRequest(req)
 	if err != nil {
 		return nil, err
 	}
 
-	snapTimeStamp := time.Now().Unix()
-	var state string
 	if snapObj, err := zfs.GetZFSSnapshot(snapName); err == nil {
-		state = snapObj.Status.State
-		size, err := zfs.GetZFSSnapshotCapacity(snapObj)
-		if err != nil {
-			return nil, fmt.Errorf("get zfssnapshot capacity failed: %v, capacity: %v", err, snapObj.Spec.Capacity)
+		if err := snapshotError(snapObj, time.Now()); err != nil {
+			return nil, err
 		}
-		return csipayload.NewCreateSnapshotResponseBuilder().
-			WithSourceVolumeID(volumeID).
-			WithSnapshotID(volumeID+"@"+snapName).
-			WithSize(size).
-			WithCreationTime(snapTimeStamp, 0).
-			WithReadyToUse(state == zfs.ZFSStatusReady).
-			Build(), nil
+		return snapshotResponse(volumeID, snapObj)
 	}
 	vol, err := zfs.GetZFSVolume(volumeID)
 	if err != nil {
@@ -733,10 +1363,29 @@ func (cs *controller) CreateSnapshot(
 			err.Error(),
 		)
 	}
+	if freezePlugin != "" {
+		if err := freeze.Validate(freezePlugin, vol.Spec.VolumeType, freezeParams); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
 	labels := map[string]string{zfs.ZFSVolKey: vol.Name}
+	for k, v := range volumeSnapshotLabels(parameters) {
+		labels[k] = v
+	}
+	annotations := map[string]string{}
+	if timeout != "" {
+		annotations[zfs.SnapshotTimeoutKey] = timeout
+	}
+	if sync != "" {
+		annotations[zfs.SnapshotSyncKey] = sync
+	}
+	if durability != "" {
+		annotations[zfs.SnapshotDurabilityKey] = durability
+	}
 	snapObj, err := snapbuilder.NewBuilder().
 		WithName(snapName).
-		WithLabels(labels).Build()
+		WithLabels(labels).
+		WithAnnotations(annotations).Build()
 	if err != nil {
 		return nil, status.Errorf(
 			codes.Internal,
@@ -745,7 +1394,13 @@ func (cs *controller) CreateSnapshot(
 			err.Error(),
 		)
 	}
-	snapObj.Spec = vol.Spec
+	snapObj.Namespace = zfs.OpenEBSNamespace
+	linkSnapshotOwner(snapObj, vol)
+	snapObj.Spec.VolumeInfo = vol.Spec
+	snapObj.Spec.SourcePool = ""
+	snapObj.Spec.SnapshotProperties = props
+	snapObj.Spec.FreezePlugin = freezePlugin
+	snapObj.Spec.FreezeParams = freezeParams
 	snapObj.Status.State = zfs.ZFSStatusPending
 	if err := zfs.ProvisionSnapshot(snapObj); err != nil {
 		return nil, status.Errorf(
@@ -755,8 +1410,8 @@ func (cs *controller) CreateSnapshot(
 			err.Error(),
 		)
 	}
-	originalParams := req.GetParameters()
-	parameters := helpers.GetCaseInsensitiveMap(&originalParams)
+	// the node agent takes the snapshot in the background, it is reported
+	// as not ready to use until then unless asked to wait for it
 	if _, ok := parameters["wait"]; ok {
 		if err := waitForReadySnapshot(snapName); err != nil {
 			return nil, err
@@ -767,18 +1422,61 @@ func (cs *controller) CreateSnapshot(
 	if err != nil {
 		return nil, fmt.Errorf("get zfssnapshot failed, err: %v", err)
 	}
-	state = snapObj.Status.State
-	size, err := zfs.GetZFSSnapshotCapacity(snapObj)
-	if err != nil {
-		return nil, fmt.Errorf("get zfssnapshot capacity failed: %v, capacity: %v", err, snapObj.Spec.Capacity)
+
+	return snapshotResponse(volumeID, snapObj)
+}
+
+// snapshotError returns the error for a snapshot which has failed or has
+// not been taken within its timeout. The node agent owns the timeout, it
+// marks the snapshot as Failed once it is sure the snapshot has not been
+// taken, the snapshot is only reported as late here.
+func snapshotError(snap *zfsapi.ZFSSnapshot, now time.Time) error {
+	if zfs.SnapshotExpired(snap, now) {
+		return status.Errorf(codes.DeadlineExceeded, "snapshot %s: %s", snap.Name, zfs.SnapshotTimeoutError(snap))
+	}
+	if snap.Status.State == zfs.ZFSStatusFailed {
+		return status.Errorf(codes.Internal, "snapshot %s failed: %s", snap.Name, snap.Status.Error)
+	}
+	return nil
+}
+
+// snapshotResponse returns the CreateSnapshot response for the snapshot.
+// The snapshot is ready to use only once the node agent has taken the zfs
+// snapshot, its creation time and referenced size are then the ones
+// reported by zfs. Until then the size is unknown and the creation time is
+// the one of the ZFSSnapshot object.
+func snapshotResponse(volumeID string, snap *zfsapi.ZFSSnapshot) (*csi.CreateSnapshotResponse, error) {
+	ready := snap.Status.State == zfs.ZFSStatusReady
+
+	creation := snap.CreationTimestamp.Time
+	if snap.Status.CreationTime != nil {
+		creation = snap.Status.CreationTime.Time
+	}
+	if creation.IsZero() {
+		creation = time.Now()
+	}
+
+	var size int64
+	var err error
+	switch {
+	case !ready:
+	case snap.Status.Size != "":
+		if size, err = strconv.ParseInt(snap.Status.Size, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid zfssnapshot size %q: %v", snap.Status.Size, err)
+		}
+	default:
+		// snapshots taken by older versions do not report their size
+		if size, err = zfs.GetZFSSnapshotCapacity(snap); err != nil {
+			return nil, fmt.Errorf("get zfssnapshot capacity failed: %v, capacity: %v", err, snap.Spec.Capacity)
+		}
 	}
 
 	return csipayload.NewCreateSnapshotResponseBuilder().
 		WithSourceVolumeID(volumeID).
-		WithSnapshotID(volumeID+"@"+snapName).
+		WithSnapshotID(volumeID+"@"+snap.Name).
 		WithSize(size).
-		WithCreationTime(snapTimeStamp, 0).
-		WithReadyToUse(state == zfs.ZFSStatusReady).
+		WithCreationTime(creation.Unix(), int64(creation.Nanosecond())).
+		WithReadyToUse(ready).
 		Build(), nil
 }
 
@@ -803,7 +1501,15 @@ func (cs *controller) DeleteSnapshot(
 		// should succeed when an invalid snapshot id is used
 		return &csi.DeleteSnapshotResponse{}, nil
 	}
-	if err := zfs.DeleteSnapshot(snapshotID[1]); err != nil {
+
+	unlock, err := cs.locks.lock(ctx, strings.ToLower(snapshotID[0]), opDeleteSnapshot)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	// the ZFSSnapshot may already be gone, e.g. deleted as dangling
+	if err := zfs.DeleteSnapshot(snapshotID[1]); err != nil && !k8serror.IsNotFound(err) {
 		return nil, status.Errorf(
 			codes.Internal,
 			"failed to handle DeleteSnapshot for %s, {%s}",
@@ -826,6 +1532,18 @@ func (cs *controller) ListSnapshots(
 	return nil, status.Error(codes.Unimplemented, "")
 }
 
+// ControllerGetVolume returns the details of the
+// given volume
+//
+// This implements csi.ControllerServer
+func (cs *controller) ControllerGetVolume(
+	ctx context.Context,
+	req *csi.ControllerGetVolumeRequest,
+) (*csi.ControllerGetVolumeResponse, error) {
+
+	return nil, status.Error(codes.Unimplemented, "")
+}
+
 // ControllerUnpublishVolume removes a previously
 // attached volume from the given node
 //
@@ -859,6 +1577,22 @@ func (cs *controller) GetCapacity(
 	req *csi.GetCapacityRequest,
 ) (*csi.GetCapacityResponse, error) {
 
+	params := req.GetParameters()
+
+	// The "poolname" parameter can either be the name of a ZFS pool
+	// (e.g. "zpool"), or a path to a child dataset (e.g. "zpool/k8s/localpv").
+	//
+	// The capacity is the free space of the whole pool, unless the child
+	// dataset is in a tenant dataset whose quota leaves less space to it.
+	poolname := helpers.GetInsensitiveParameter(&params, "poolname")
+	if _, _, err := parsePoolParam(poolname); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	reserve, err := parseSnapshotReserve(helpers.GetInsensitiveParameter(&params, "snapshotreserve"))
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
 	var segments map[string]string
 	if topology := req.GetAccessibleTopology(); topology != nil {
 		segments = topology.Segments
@@ -870,34 +1604,7 @@ func (cs *controller) GetCapacity(
 
 	zfsNodesCache := cs.zfsNodeInformer.GetIndexer()
 
-	params := req.GetParameters()
-
-	poolParam := helpers.GetInsensitiveParameter(&params, "poolname")
-
-	// The "poolname" parameter can either be the name of a ZFS pool
-	// (e.g. "zpool"), or a path to a child dataset (e.g. "zpool/k8s/localpv").
-	//
-	// We parse the "poolname" parameter so the name of the ZFS pool and the
-	// path to the dataset is available separately.
-	//
-	// The dataset path is not used now. It could be used later to query the
-	// capacity of the child dataset, which could be smaller than the capacity
-	// of the whole pool.
-	//
-	// This is necessary because capacity calculation currently only works with
-	// ZFS pool names. This is why it always returns the capacitry of the whole
-	// pool, even if the child dataset given as the "poolname" parameter has a
-	// smaller capacity than the whole pool.
-	poolname, _ := func() (string, string) {
-		poolParamSliced := strings.SplitN(poolParam, "/", 2)
-		if len(poolParamSliced) == 2 {
-			return poolParamSliced[0], poolParamSliced[1]
-		} else {
-			return poolParamSliced[0], ""
-		}
-	}()
-
-	var availableCapacity int64
+	var zfsNodes []*zfsapi.ZFSNode
 	for _, nodeName := range nodeNames {
 		mappedNodeId, mapErr := zfs.GetNodeID(nodeName)
 		if mapErr != nil {
@@ -912,25 +1619,105 @@ func (cs *controller) GetCapacity(
 		if !exists {
 			continue
 		}
-		zfsNode := v.(*zfsapi.ZFSNode)
-		// rather than summing all free capacity, we are calculating maximum
-		// zv size that gets fit in given pool.
-		// See https://github.com/kubernetes/enhancements/tree/master/keps/sig-storage/1472-storage-capacity-tracking#available-capacity-vs-maximum-volume-size &
-		// https://github.com/container-storage-interface/spec/issues/432 for more details
+		zfsNodes = append(zfsNodes, v.(*zfsapi.ZFSNode))
+	}
+
+	return &csi.GetCapacityResponse{
+		AvailableCapacity: maxFreeCapacity(zfsNodes, poolname, reserve),
+	}, nil
+}
+
+// zpoolNameRegex matches the valid zfs pool names, they begin with a
+// letter and contain alphanumeric characters, "_", "-", "." and ":"
+var zpoolNameRegex = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_.:-]*$`)
+
+// validateKeyMode checks the "keymode" parameter, the generated keys are
+// in the hex format and passed on the stdin, so the keyformat and the
+// keylocation can not be asked for along with it
+func validateKeyMode(keymode, encr, kf, kl string) error {
+	if keymode != zfs.KeyModePerVolume {
+		return fmt.Errorf("invalid keymode %q, only %q is supported", keymode, zfs.KeyModePerVolume)
+	}
+	if encr == "off" {
+		return fmt.Errorf("keymode %s needs encryption to be enabled", keymode)
+	}
+	if (kf != "" && kf != "hex") || (kl != "" && kl != "prompt") {
+		return fmt.Errorf("keymode %s can not be used with keyformat %q and keylocation %q", keymode, kf, kl)
+	}
+	return nil
+}
+
+// parsePoolParam splits the "poolname" parameter into the name of the
+// pool and the path of the child dataset, it returns an error if the
+// pool name is not valid
+func parsePoolParam(param string) (string, string, error) {
+	pool, dataset := param, ""
+	if i := strings.Index(param, "/"); i >= 0 {
+		pool, dataset = param[:i], param[i+1:]
+	}
+	if !zpoolNameRegex.MatchString(pool) {
+		return "", "", fmt.Errorf("invalid poolname %q", param)
+	}
+	return pool, dataset, nil
+}
+
+// parseSnapshotReserve parses the snapshotreserve parameter, the percent
+// of the space used by the snapshots of the pool which is kept free for
+// them to grow
+func parseSnapshotReserve(val string) (int64, error) {
+	if val == "" {
+		return 0, nil
+	}
+	r, err := strconv.ParseInt(val, 10, 64)
+	if err != nil || r < 0 || r > 1000 {
+		return 0, fmt.Errorf("invalid snapshotreserve %q, it should be a percent between 0 and 1000", val)
+	}
+	return r, nil
+}
+
+// snapshotReserve returns the space of the pool kept free for its
+// snapshots to grow, as a percent of the space they use now. The snapshots
+// keep the blocks the volumes overwrite, so they grow as the live data
+// diverges. Nothing is kept if the node has not reported their space.
+func snapshotReserve(node *zfsapi.ZFSNode, poolname string, percent int64) int64 {
+	if percent == 0 {
+		return 0
+	}
+	for _, p := range node.Status.Pools {
+		if p.Name == poolname && p.SnapshotUsed != nil {
+			return p.SnapshotUsed.Value() * percent / 100
+		}
+	}
+	return 0
+}
+
+// maxFreeCapacity returns the free capacity of the pool on the node where
+// it is the largest. Rather than summing all free capacity, we are
+// calculating maximum zv size that gets fit in given pool. The nodes which
+// do not have the pool do not add any capacity, so the capacity is 0 if
+// none of them has it. The free capacity of each node is reduced by the
+// space kept for the snapshots of the pool to grow, and bounded by the
+// quota of the tenant dataset holding the pool if it is a child dataset.
+// See https://github.com/kubernetes/enhancements/tree/master/keps/sig-storage/1472-storage-capacity-tracking#available-capacity-vs-maximum-volume-size &
+// https://github.com/container-storage-interface/spec/issues/432 for more details
+func maxFreeCapacity(nodes []*zfsapi.ZFSNode, poolname string, reserve int64) int64 {
+	var availableCapacity int64
+	name := strings.SplitN(poolname, "/", 2)[0]
+	for _, zfsNode := range nodes {
 		for _, zpool := range zfsNode.Pools {
-			if zpool.Name != poolname {
+			if zpool.Name != name {
 				continue
 			}
-			freeCapacity := zpool.Free.Value()
+			freeCapacity := zpool.Free.Value() - snapshotReserve(zfsNode, name, reserve)
+			if avail, found := zfs.TenantAvailable(zfsNode, poolname); found && avail < freeCapacity {
+				freeCapacity = avail
+			}
 			if availableCapacity < freeCapacity {
 				availableCapacity = freeCapacity
 			}
 		}
 	}
-
-	return &csi.GetCapacityResponse{
-		AvailableCapacity: availableCapacity,
-	}, nil
+	return availableCapacity
 }
 
 func (cs *controller) filterNodesByTopology(segments map[string]string) ([]string, error) {
@@ -1092,6 +1879,19 @@ func (cs *controller) validateVolumeCreateReq(req *csi.CreateVolumeRequest) erro
 			"failed to handle create volume request: missing volume capabilities",
 		)
 	}
+
+	parameters := req.GetParameters()
+	if err := validateReadOnly(helpers.GetInsensitiveParameter(&parameters, "readonly"), volCapabilities); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return nil
+}
+
+// validateReadOnly refuses the write access modes for a read-only volume
+func validateReadOnly(readonly string, volCaps []*csi.VolumeCapability) error {
+	if readonly == "yes" && hasWriteAccessMode(volCaps) {
+		return fmt.Errorf("readonly volume only supports the read only access modes")
+	}
 	return nil
 }
 
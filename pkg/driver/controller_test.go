@@ -17,9 +17,20 @@ limitations under the License.
 package driver
 
 import (
+	"context"
+	"fmt"
 	"testing"
+	"time"
 
+	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	zfsapi "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"github.com/openebs/zfs-localpv/pkg/zfs"
 )
 
 func TestRoundOff(t *testing.T) {
@@ -43,3 +54,418 @@ func TestRoundOff(t *testing.T) {
 		})
 	}
 }
+
+func TestGetVolumeCapacity(t *testing.T) {
+	req := func(size int64, params map[string]string) *csi.CreateVolumeRequest {
+		return &csi.CreateVolumeRequest{
+			CapacityRange: &csi.CapacityRange{RequiredBytes: size},
+			Parameters:    params,
+		}
+	}
+	tests := map[string]struct {
+		req      *csi.CreateVolumeRequest
+		expected int64
+	}{
+		"not aligned":           {req: req(100*Mi+1, map[string]string{"recordsize": "16M"}), expected: 101 * Mi},
+		"default recordsize":    {req: req(100*Mi+1, map[string]string{"aligncapacity": "yes"}), expected: 101 * Mi},
+		"4M recordsize":         {req: req(101*Mi, map[string]string{"aligncapacity": "yes", "recordsize": "4M"}), expected: 104 * Mi},
+		"16M recordsize":        {req: req(100*Mi, map[string]string{"AlignCapacity": "yes", "recordsize": "16m"}), expected: 112 * Mi},
+		"16M recordsize on Gi":  {req: req(2*Gi, map[string]string{"aligncapacity": "yes", "recordsize": "16M"}), expected: 2 * Gi},
+		"zvol ignores records":  {req: req(100*Mi, map[string]string{"aligncapacity": "yes", "fstype": "ext4", "recordsize": "16M"}), expected: 100 * Mi},
+		"zvol volblocksize":     {req: req(Mi, map[string]string{"aligncapacity": "yes", "fstype": "xfs", "volblocksize": "64k"}), expected: Mi},
+		"dataset volblocksize":  {req: req(3*Mi, map[string]string{"aligncapacity": "yes", "fstype": "zfs", "volblocksize": "16M"}), expected: 3 * Mi},
+		"dataset 2M recordsize": {req: req(3*Mi, map[string]string{"aligncapacity": "yes", "fstype": "zfs", "recordsize": "2M"}), expected: 4 * Mi},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			size, err := getVolumeCapacity(test.req, "zfs")
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, size)
+		})
+	}
+
+	_, err := getVolumeCapacity(req(Mi, map[string]string{"aligncapacity": "yes", "recordsize": "100k"}), "zfs")
+	assert.Error(t, err)
+}
+
+func TestParseVerifySource(t *testing.T) {
+	for _, val := range []string{"", "yes", "no"} {
+		got, err := parseVerifySource(val)
+		assert.NoError(t, err)
+		assert.Equal(t, val, got)
+	}
+	_, err := parseVerifySource("true")
+	assert.Error(t, err)
+}
+
+func TestProvisioningError(t *testing.T) {
+	prfList := []string{"node1", "node2"}
+	gone := fmt.Errorf("zfs: node1: %w", zfs.ErrNodeGone)
+	unreachable := fmt.Errorf("zfs: node2: %w after 3 retries", zfs.ErrNodeUnreachable)
+
+	var a nodeAttempts
+	a.record("node1", gone)
+	a.record("node2", gone)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(a.provisioningError(prfList, gone)))
+
+	a = nodeAttempts{}
+	a.record("node1", gone)
+	a.record("node2", unreachable)
+	assert.Equal(t, []string{"node2"}, a.unreachable)
+	assert.Equal(t, codes.Unavailable, status.Code(a.provisioningError(prfList, unreachable)))
+
+	a = nodeAttempts{}
+	a.record("node1", gone)
+	a.record("node2", fmt.Errorf("zfs: volume creation failed"))
+	assert.Empty(t, a.unreachable)
+	assert.Equal(t, codes.Internal, status.Code(a.provisioningError(prfList, fmt.Errorf("zfs: volume creation failed"))))
+}
+
+func TestValidateShare(t *testing.T) {
+	assert.NoError(t, validateShare(zfs.VolTypeDataset, "rw=@10.0.0.0/24", "on"))
+	assert.NoError(t, validateShare(zfs.VolTypeZVol, "", ""))
+	assert.Error(t, validateShare(zfs.VolTypeZVol, "on", ""))
+	assert.Error(t, validateShare(zfs.VolTypeDataset, "rw ro", ""))
+}
+
+func TestValidateMkfsOptions(t *testing.T) {
+	req := &csi.CreateVolumeRequest{}
+	assert.NoError(t, validateMkfsOptions(req, zfs.VolTypeZVol, "ext4", "-i 65536 -E stride=16,stripe_width=64"))
+	assert.NoError(t, validateMkfsOptions(req, zfs.VolTypeZVol, "", "-E stride=16"))
+	assert.NoError(t, validateMkfsOptions(req, zfs.VolTypeZVol, "xfs", "-d su=64k,sw=4"))
+	assert.NoError(t, validateMkfsOptions(req, zfs.VolTypeDataset, "zfs", ""))
+	assert.Error(t, validateMkfsOptions(req, zfs.VolTypeDataset, "zfs", "-E stride=16"))
+	assert.Error(t, validateMkfsOptions(req, zfs.VolTypeZVol, "xfs", "-E stride=16"))
+
+	// the zvol is formatted with the fstype of the volume capability
+	req.VolumeCapabilities = []*csi.VolumeCapability{{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: "xfs"}},
+	}}
+	assert.Error(t, validateMkfsOptions(req, zfs.VolTypeZVol, "ext4", "-E stride=16"))
+	assert.NoError(t, validateMkfsOptions(req, zfs.VolTypeZVol, "ext4", "-d su=64k,sw=4"))
+}
+
+func TestHostMountPoint(t *testing.T) {
+	params := map[string]string{
+		"hostmountpoint":                   "/mnt/zfs",
+		"csi.storage.k8s.io/pvc/namespace": "app",
+		"csi.storage.k8s.io/pvc/name":      "data",
+	}
+	mp, err := hostMountPoint(params, "pvc-1", zfs.VolTypeDataset)
+	assert.NoError(t, err)
+	assert.Equal(t, "/mnt/zfs/app/data", mp)
+
+	mp, err = hostMountPoint(map[string]string{"hostmountpoint": "/mnt/zfs"}, "pvc-1", zfs.VolTypeDataset)
+	assert.NoError(t, err)
+	assert.Equal(t, "/mnt/zfs/pvc-1", mp)
+
+	mp, err = hostMountPoint(map[string]string{}, "pvc-1", zfs.VolTypeZVol)
+	assert.NoError(t, err)
+	assert.Empty(t, mp)
+
+	_, err = hostMountPoint(params, "pvc-1", zfs.VolTypeZVol)
+	assert.Error(t, err)
+	_, err = hostMountPoint(map[string]string{"hostmountpoint": "/var/lib/kubelet"}, "pvc-1", zfs.VolTypeDataset)
+	assert.Error(t, err)
+}
+
+func TestGetFsType(t *testing.T) {
+	mountCap := func(fs string) *csi.VolumeCapability {
+		return &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{
+				Mount: &csi.VolumeCapability_MountVolume{FsType: fs},
+			},
+		}
+	}
+	blockCap := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Block{
+			Block: &csi.VolumeCapability_BlockVolume{},
+		},
+	}
+
+	tests := map[string]struct {
+		caps     []*csi.VolumeCapability
+		scFsType string
+		expected string
+	}{
+		"storageclass fstype wins":     {caps: []*csi.VolumeCapability{mountCap("xfs")}, scFsType: "zfs", expected: "zfs"},
+		"capability fstype is used":    {caps: []*csi.VolumeCapability{mountCap("xfs")}, expected: "xfs"},
+		"default fstype as fallback":   {caps: []*csi.VolumeCapability{mountCap("")}, expected: "ext4"},
+		"no default for block volumes": {caps: []*csi.VolumeCapability{blockCap}, expected: ""},
+		"default without capabilities": {expected: "ext4"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := &csi.CreateVolumeRequest{VolumeCapabilities: test.caps}
+			assert.Equal(t, test.expected, getFsType(req, test.scFsType, "ext4"))
+		})
+	}
+}
+
+func TestSnapshotsToDelete(t *testing.T) {
+	snaps := []string{"snapshot-1", "snapshot-2"}
+
+	tests := map[string]struct {
+		policy   string
+		snaps    []string
+		expected []string
+		code     codes.Code
+	}{
+		"block without snapshots":   {policy: "block"},
+		"block with snapshots":      {policy: "block", snaps: snaps, code: codes.FailedPrecondition},
+		"default is block":          {snaps: snaps, code: codes.FailedPrecondition},
+		"delete removes snapshots":  {policy: "delete", snaps: snaps, expected: snaps},
+		"orphan keeps the snapshot": {policy: "orphan", snaps: snaps},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := snapshotsToDelete(test.policy, test.snaps)
+			assert.Equal(t, test.code, status.Code(err))
+			assert.Equal(t, test.expected, got)
+		})
+	}
+}
+
+func TestHasWriteAccessMode(t *testing.T) {
+	withMode := func(mode csi.VolumeCapability_AccessMode_Mode) *csi.VolumeCapability {
+		return &csi.VolumeCapability{AccessMode: &csi.VolumeCapability_AccessMode{Mode: mode}}
+	}
+	ro := withMode(csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY)
+	rox := withMode(csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY)
+	rw := withMode(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER)
+
+	tests := map[string]struct {
+		caps     []*csi.VolumeCapability
+		expected bool
+	}{
+		"reader only modes": {caps: []*csi.VolumeCapability{ro, rox}, expected: false},
+		"writer mode":       {caps: []*csi.VolumeCapability{rw}, expected: true},
+		"mixed modes":       {caps: []*csi.VolumeCapability{ro, rw}, expected: true},
+		"missing mode":      {caps: []*csi.VolumeCapability{{}}, expected: true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, test.expected, hasWriteAccessMode(test.caps))
+		})
+	}
+
+	assert.NoError(t, validateReadOnly("yes", []*csi.VolumeCapability{ro, rox}))
+	assert.NoError(t, validateReadOnly("", []*csi.VolumeCapability{rw}))
+	assert.Error(t, validateReadOnly("yes", []*csi.VolumeCapability{ro, rw}))
+}
+
+func TestSnapshotResponse(t *testing.T) {
+	cutAt := time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)
+	objAt := cutAt.Add(-time.Second)
+
+	snap := &zfsapi.ZFSSnapshot{}
+	snap.Name = "snapshot-1"
+	snap.CreationTimestamp = metav1.NewTime(objAt)
+	snap.Spec.Capacity = "4294967296"
+	snap.Status.State = zfs.ZFSStatusPending
+
+	// not ready until the node has taken the zfs snapshot
+	resp, err := snapshotResponse("pvc-1", snap)
+	assert.NoError(t, err)
+	assert.Equal(t, "pvc-1@snapshot-1", resp.Snapshot.SnapshotId)
+	assert.Equal(t, "pvc-1", resp.Snapshot.SourceVolumeId)
+	assert.False(t, resp.Snapshot.ReadyToUse)
+	assert.Equal(t, int64(0), resp.Snapshot.SizeBytes)
+	assert.Equal(t, objAt.Unix(), resp.Snapshot.CreationTime.Seconds)
+
+	// once ready, the time and the size are the ones reported by zfs
+	snap.Status.State = zfs.ZFSStatusReady
+	created := metav1.NewTime(cutAt)
+	snap.Status.CreationTime = &created
+	snap.Status.Size = "1048576"
+	resp, err = snapshotResponse("pvc-1", snap)
+	assert.NoError(t, err)
+	assert.True(t, resp.Snapshot.ReadyToUse)
+	assert.Equal(t, int64(1048576), resp.Snapshot.SizeBytes)
+	assert.Equal(t, cutAt.Unix(), resp.Snapshot.CreationTime.Seconds)
+
+	// snapshots of older versions fall back to the volume capacity
+	snap.Status.Size = ""
+	resp, err = snapshotResponse("pvc-1", snap)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4294967296), resp.Snapshot.SizeBytes)
+
+	snap.Status.Size = "bad"
+	_, err = snapshotResponse("pvc-1", snap)
+	assert.Error(t, err)
+}
+
+func TestMaxFreeCapacity(t *testing.T) {
+	zfsNode := func(name string, pools map[string]string) *zfsapi.ZFSNode {
+		n := &zfsapi.ZFSNode{}
+		n.Name = name
+		for pool, free := range pools {
+			n.Pools = append(n.Pools, zfsapi.Pool{Name: pool, Free: resource.MustParse(free)})
+		}
+		return n
+	}
+	nodes := []*zfsapi.ZFSNode{
+		zfsNode("node1", map[string]string{"zfspv": "1Gi", "other": "100Gi"}),
+		zfsNode("node2", map[string]string{"zfspv": "4Gi"}),
+		zfsNode("node3", nil),
+	}
+
+	assert.Equal(t, int64(4*Gi), maxFreeCapacity(nodes, "zfspv", 0))
+	assert.Equal(t, int64(0), maxFreeCapacity(nodes, "missing", 0))
+	assert.Equal(t, int64(0), maxFreeCapacity(nodes[2:], "zfspv", 0))
+	assert.Equal(t, int64(0), maxFreeCapacity(nil, "zfspv", 0))
+}
+
+func TestMaxFreeCapacitySnapshotReserve(t *testing.T) {
+	zfsNode := func(name, free, snapUsed string) *zfsapi.ZFSNode {
+		n := &zfsapi.ZFSNode{}
+		n.Name = name
+		n.Pools = []zfsapi.Pool{{Name: "zfspv", Free: resource.MustParse(free)}}
+		if snapUsed != "" {
+			used := resource.MustParse(snapUsed)
+			n.Status.Pools = []zfsapi.PoolSummary{{Name: "zfspv", SnapshotUsed: &used}}
+		}
+		return n
+	}
+	nodes := []*zfsapi.ZFSNode{
+		zfsNode("node1", "10Gi", "8Gi"),
+		zfsNode("node2", "6Gi", "1Gi"),
+	}
+
+	// the raw capacity ignores the snapshots
+	assert.Equal(t, int64(10*Gi), maxFreeCapacity(nodes, "zfspv", 0))
+	// node1 keeps 4Gi for its snapshots and node2 512Mi
+	assert.Equal(t, int64(6*Gi), maxFreeCapacity(nodes, "zfspv", 50))
+	assert.Equal(t, int64(5*Gi), maxFreeCapacity(nodes, "zfspv", 100))
+	// the reserve can not make the capacity negative
+	assert.Equal(t, int64(0), maxFreeCapacity(nodes, "zfspv", 1000))
+
+	// nothing is kept on the nodes which have not reported the snapshots
+	nodes = append(nodes, zfsNode("node3", "7Gi", ""))
+	assert.Equal(t, int64(7*Gi), maxFreeCapacity(nodes, "zfspv", 100))
+}
+
+func TestMaxFreeCapacityTenant(t *testing.T) {
+	zfsNode := func(name, free, tenantAvail string) *zfsapi.ZFSNode {
+		n := &zfsapi.ZFSNode{}
+		n.Name = name
+		n.Pools = []zfsapi.Pool{{Name: "zfspv", Free: resource.MustParse(free)}}
+		if tenantAvail != "" {
+			avail := resource.MustParse(tenantAvail)
+			n.Status.Tenants = []zfsapi.TenantUsage{{Name: "zfspv/tenant-a", Quota: resource.MustParse("20Gi"), Available: &avail}}
+		}
+		return n
+	}
+	nodes := []*zfsapi.ZFSNode{
+		zfsNode("node1", "10Gi", "3Gi"),
+		zfsNode("node2", "6Gi", "1Gi"),
+	}
+
+	// the tenant near its quota bounds the capacity of its datasets
+	assert.Equal(t, int64(3*Gi), maxFreeCapacity(nodes, "zfspv/tenant-a", 0))
+	assert.Equal(t, int64(3*Gi), maxFreeCapacity(nodes, "zfspv/tenant-a/k8s", 0))
+	// the other datasets of the pool are not bounded
+	assert.Equal(t, int64(10*Gi), maxFreeCapacity(nodes, "zfspv", 0))
+	assert.Equal(t, int64(10*Gi), maxFreeCapacity(nodes, "zfspv/tenant-b", 0))
+
+	// the free space of the pool bounds a tenant with a larger quota left
+	nodes = append(nodes, zfsNode("node3", "5Gi", "15Gi"))
+	assert.Equal(t, int64(5*Gi), maxFreeCapacity(nodes, "zfspv/tenant-a", 0))
+}
+
+func TestParseSnapshotReserve(t *testing.T) {
+	for val, want := range map[string]int64{"": 0, "0": 0, "50": 50, "200": 200} {
+		r, err := parseSnapshotReserve(val)
+		assert.NoError(t, err, val)
+		assert.Equal(t, want, r, val)
+	}
+	for _, val := range []string{"-1", "1001", "half", "50%"} {
+		_, err := parseSnapshotReserve(val)
+		assert.Error(t, err, val)
+	}
+}
+
+func TestParsePoolParam(t *testing.T) {
+	pool, dataset, err := parsePoolParam("zfspv/k8s/localpv")
+	assert.NoError(t, err)
+	assert.Equal(t, "zfspv", pool)
+	assert.Equal(t, "k8s/localpv", dataset)
+
+	pool, dataset, err = parsePoolParam("zfs-pv_1.a:b")
+	assert.NoError(t, err)
+	assert.Equal(t, "zfs-pv_1.a:b", pool)
+	assert.Equal(t, "", dataset)
+
+	for _, param := range []string{"", "/zfspv", "1pool", "zfs pv", "zfs@pv"} {
+		_, _, err = parsePoolParam(param)
+		assert.Error(t, err, param)
+	}
+
+	// an invalid pool name is rejected before the nodes are looked up
+	cs := &controller{}
+	_, err = cs.GetCapacity(context.Background(), &csi.GetCapacityRequest{
+		Parameters: map[string]string{"poolname": "/zfspv"},
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestValidateKeyMode(t *testing.T) {
+	assert.NoError(t, validateKeyMode("per-volume", "", "", ""))
+	assert.NoError(t, validateKeyMode("per-volume", "aes-256-gcm", "hex", "prompt"))
+
+	assert.Error(t, validateKeyMode("shared", "", "", ""))
+	assert.Error(t, validateKeyMode("per-volume", "off", "", ""))
+	assert.Error(t, validateKeyMode("per-volume", "on", "passphrase", ""))
+	assert.Error(t, validateKeyMode("per-volume", "on", "", "file:///keys/key"))
+}
+
+func TestSnapshotError(t *testing.T) {
+	createdAt := time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)
+	snap := &zfsapi.ZFSSnapshot{}
+	snap.Name = "snapshot-1"
+	snap.CreationTimestamp = metav1.NewTime(createdAt)
+	snap.Annotations = map[string]string{zfs.SnapshotTimeoutKey: "10m"}
+	snap.Spec.OwnerNodeID = "node1"
+	snap.Status.State = zfs.ZFSStatusPending
+
+	// a pending snapshot is returned as not ready within its timeout
+	assert.NoError(t, snapshotError(snap, createdAt.Add(time.Minute)))
+	resp, err := snapshotResponse("pvc-1", snap)
+	assert.NoError(t, err)
+	assert.False(t, resp.Snapshot.ReadyToUse)
+
+	// and is late after it, it is left to the node agent to fail it
+	err = snapshotError(snap, createdAt.Add(10*time.Minute))
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+	assert.Contains(t, err.Error(), "snapshot has not been taken on node node1 within 10m")
+	assert.Equal(t, zfs.ZFSStatusPending, snap.Status.State)
+
+	snap.Status.State = zfs.ZFSStatusFailed
+	snap.Status.Error = "snapshot has not been taken on node node1 within 10m"
+	err = snapshotError(snap, createdAt.Add(time.Hour))
+	assert.Equal(t, codes.Internal, status.Code(err))
+	assert.Contains(t, err.Error(), snap.Status.Error)
+
+	// a snapshot completed late by the node is not an error
+	snap.Status.State = zfs.ZFSStatusReady
+	assert.NoError(t, snapshotError(snap, createdAt.Add(time.Hour)))
+
+	cs := &controller{}
+	_, err = cs.CreateSnapshot(context.Background(), &csi.CreateSnapshotRequest{
+		Name:           "snapshot-2",
+		SourceVolumeId: "pvc-1",
+		Parameters:     map[string]string{"snapshotTimeout": "soon"},
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = cs.CreateSnapshot(context.Background(), &csi.CreateSnapshotRequest{
+		Name:           "snapshot-2",
+		SourceVolumeId: "pvc-1",
+		Parameters:     map[string]string{"snapshotProperties": "retention=gold"},
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/openebs/zfs-localpv/pkg/zfs"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+// parseMaxDatasetsPerPool validates the limit of datasets per pool, 0
+// does not limit them
+func parseMaxDatasetsPerPool(n int) (int64, error) {
+	if n < 0 {
+		return 0, fmt.Errorf("invalid max datasets per pool %d", n)
+	}
+	return int64(n), nil
+}
+
+// datasetLimit drops the pools which have reached the limit of datasets.
+// The pools whose datasets are not known, e.g. reported by an older node
+// agent, are kept.
+type datasetLimit int64
+
+func (d datasetLimit) Filter(_ *csi.CreateVolumeRequest, c Candidate) bool {
+	if zfs.DatasetLimitReached(c.Datasets, int64(d)) {
+		klog.Infof("scheduler: pool %s on node %s has %d datasets, the limit is %d", c.Pool, c.Node, c.Datasets, d)
+		return false
+	}
+	return true
+}
+
+func (datasetLimit) Score(*csi.CreateVolumeRequest, Candidate) int64 { return 0 }
+
+// unschedulable returns the error telling that the pool has reached the
+// limit of datasets on all the eligible nodes
+func (d datasetLimit) unschedulable(pool string, eligible []string) error {
+	return status.Errorf(codes.ResourceExhausted,
+		"pool %s has reached the limit of %d datasets on all the %d eligible nodes, use another pool or raise --max-datasets-per-pool",
+		pool, int64(d), len(eligible))
+}
+
+// requiredDatasetLimit returns the filter on the datasets of the pools, it
+// is nil if the datasets are not limited
+func (cs *controller) requiredDatasetLimit() *datasetLimit {
+	if cs.maxDatasets == 0 {
+		return nil
+	}
+	d := datasetLimit(cs.maxDatasets)
+	return &d
+}
+
+// checkDatasetLimit returns an error if the pool of the node has reached
+// the limit of datasets, for the clones which are created on the node of
+// their source. The check is skipped if the ZFSNode can not be read.
+func (cs *controller) checkDatasetLimit(nodeID, pool string) error {
+	if cs.maxDatasets == 0 {
+		return nil
+	}
+	node, err := ownerNode(cs, nodeID)
+	if err != nil {
+		klog.Warningf("could not check the datasets of pool %s on node %s: %v", pool, nodeID, err)
+		return nil
+	}
+	zpool := strings.SplitN(zfs.ResolvePoolAlias(node.PoolAliases, pool), "/", 2)[0]
+	for _, summary := range node.Status.Pools {
+		if summary.Name == zpool && zfs.DatasetLimitReached(summary.Datasets, cs.maxDatasets) {
+			return status.Errorf(codes.ResourceExhausted,
+				"pool %s on node %s has reached the limit of %d datasets, it has %d",
+				pool, nodeID, cs.maxDatasets, summary.Datasets)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestParseMaxDatasetsPerPool(t *testing.T) {
+	n, err := parseMaxDatasetsPerPool(0)
+	assert.NoError(t, err)
+	assert.Nil(t, (&controller{maxDatasets: n}).requiredDatasetLimit())
+	_, err = parseMaxDatasetsPerPool(-1)
+	assert.Error(t, err)
+
+	n, err = parseMaxDatasetsPerPool(10000)
+	assert.NoError(t, err)
+	if limit := (&controller{maxDatasets: n}).requiredDatasetLimit(); assert.NotNil(t, limit) {
+		assert.Equal(t, datasetLimit(10000), *limit)
+	}
+}
+
+func TestBuildCandidatesDatasets(t *testing.T) {
+	zfsnodes := []apis.ZFSNode{{}, {}}
+	zfsnodes[0].Name = "node1"
+	zfsnodes[0].Status.Pools = []apis.PoolSummary{{Name: "zfspv", Datasets: 9000}}
+	zfsnodes[1].Name = "node2"
+	zfsnodes[1].Status.Pools = []apis.PoolSummary{{Name: "zfspv"}}
+
+	// the datasets are the ones of the zpool holding the child dataset
+	cmap := buildCandidates("zfspv/k8s", nil, zfsnodes)
+	assert.Equal(t, int64(9000), cmap["node1"].Datasets)
+	assert.NotContains(t, cmap, "node2")
+}
+
+func TestDatasetLimitPlacement(t *testing.T) {
+	nodes := []string{"node1", "node2", "node3"}
+	cmap := map[string]Candidate{
+		"node1": {Node: "node1", Pool: "zfspv", Volumes: 1, Datasets: 10000},
+		"node2": {Node: "node2", Pool: "zfspv", Volumes: 2, Datasets: 9999},
+		// the datasets of node3 have not been reported
+		"node3": {Node: "node3", Pool: "zfspv", Volumes: 3},
+	}
+	req := &csi.CreateVolumeRequest{}
+
+	limit := datasetLimit(10000)
+	got := rankNodes(req, Compose(volumeWeighted{}, limit), "zfspv", nodes, cmap)
+	assert.Equal(t, []string{"node2", "node3"}, got)
+
+	// all the nodes at the limit
+	limit = datasetLimit(100)
+	got = rankNodes(req, Compose(volumeWeighted{}, limit), "zfspv", nodes[:2], cmap)
+	assert.Empty(t, got)
+	err := limit.unschedulable("zfspv", nodes[:2])
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Contains(t, err.Error(), "pool zfspv has reached the limit of 100 datasets on all the 2 eligible nodes")
+}
+
+func TestCheckDatasetLimit(t *testing.T) {
+	node := &apis.ZFSNode{}
+	node.Name = "node-1"
+	node.Status.Pools = []apis.PoolSummary{
+		{Name: "hdd", Datasets: 500},
+		{Name: "nvme", Datasets: 20},
+	}
+	cs := &controller{zfsNodeInformer: zfsNodeInformer(t, node)}
+
+	assert.NoError(t, cs.checkDatasetLimit("node-1", "hdd/volumes"))
+
+	cs.maxDatasets = 100
+	err := cs.checkDatasetLimit("node-1", "hdd/volumes")
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.NoError(t, cs.checkDatasetLimit("node-1", "nvme"))
+
+	// the check is skipped if the ZFSNode can not be read
+	assert.NoError(t, cs.checkDatasetLimit("node-2", "hdd"))
+}
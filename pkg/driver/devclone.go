@@ -0,0 +1,554 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"github.com/openebs/zfs-localpv/pkg/zfs"
+	corev1 "k8s.io/api/core/v1"
+	k8serror "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// DevCloneLabel is set on the PVC, the PV and the snapshot taken for a
+	// dev clone, its value is the name of the ZFSVolume of the clone
+	DevCloneLabel = "openebs.io/dev-clone"
+	// DevCloneSourceAnnotation is the namespace/name of the PVC the dev
+	// clone has been made of
+	DevCloneSourceAnnotation = "openebs.io/dev-clone-source"
+	// DevCloneSnapshotAnnotation is the ZFSSnapshot the dev clone has been
+	// made of
+	DevCloneSnapshotAnnotation = "openebs.io/dev-clone-snapshot"
+	// DevCloneExpiresAnnotation is the time, in RFC 3339, after which the
+	// dev clone is deleted
+	DevCloneExpiresAnnotation = "openebs.io/dev-clone-expires"
+
+	// DevCloneRequestAnnotation asks for a dev clone of the PVC it is set
+	// on, its value is the name of the PVC of the clone. It is removed by
+	// the controller once the request is handled.
+	DevCloneRequestAnnotation = "openebs.io/dev-clone-request"
+	// DevCloneTTLAnnotation is the TTL of the requested dev clone, as a
+	// duration, e.g. 8h
+	DevCloneTTLAnnotation = "openebs.io/dev-clone-ttl"
+	// DevCloneFreshSnapshotAnnotation set to "true" takes a new snapshot
+	// for the requested dev clone
+	DevCloneFreshSnapshotAnnotation = "openebs.io/dev-clone-fresh-snapshot"
+	// DevCloneErrorAnnotation is set on the PVC when its last dev clone
+	// request failed
+	DevCloneErrorAnnotation = "openebs.io/dev-clone-error"
+
+	// DefaultDevCloneTTL is the time a dev clone is kept if not set
+	DefaultDevCloneTTL = 24 * time.Hour
+
+	// provisionedByAnnotation names the provisioner deleting the PV
+	provisionedByAnnotation = "pv.kubernetes.io/provisioned-by"
+)
+
+var (
+	// devCloneCollectInterval is the interval at which the expired dev
+	// clones are deleted
+	devCloneCollectInterval = 5 * time.Minute
+	// devCloneSnapshotTimeout is the time the snapshot taken for a dev
+	// clone has to be ready within
+	devCloneSnapshotTimeout = 5 * time.Minute
+	// devClonePollInterval is the interval at which the snapshot taken
+	// for a dev clone is checked
+	devClonePollInterval = time.Second
+)
+
+// DevCloneRequest asks for a thin clone of a PVC, e.g. a copy of a
+// production volume for a test environment
+type DevCloneRequest struct {
+	// Namespace of the source PVC, the clone is created in it
+	Namespace string
+	// Source is the name of the PVC to clone
+	Source string
+	// Name is the name of the PVC of the clone
+	Name string
+	// TTL is the time after which the clone is deleted, DefaultDevCloneTTL
+	// if not set
+	TTL time.Duration
+	// FreshSnapshot takes a new snapshot of the source instead of cloning
+	// its latest ready one
+	FreshSnapshot bool
+}
+
+// DevClone is a thin clone of a PVC which is deleted once expired
+type DevClone struct {
+	// Namespace and Name of the PVC of the clone
+	Namespace string
+	Name      string
+	// Source is the namespace/name of the PVC cloned
+	Source string
+	// Volume is the ZFSVolume of the clone
+	Volume string
+	// Snapshot is the ZFSSnapshot the clone has been made of
+	Snapshot string
+	// Expires is the time after which the clone is deleted
+	Expires time.Time
+}
+
+// Expired tells whether the dev clone is to be deleted
+func (d *DevClone) Expired(now time.Time) bool {
+	return !d.Expires.IsZero() && !now.Before(d.Expires)
+}
+
+// CreateDevClone creates a thin clone of the PVC, tagged as a dev clone
+// which is deleted once its TTL is over. The clone is made of the latest
+// ready snapshot of the volume, or of a snapshot taken for it if there is
+// none or if asked to. It goes through the restore of a snapshot, so the
+// clone is on the node and the pool of the source, and it is bound to a
+// PVC of the same namespace. What has been created is deleted if the clone
+// fails.
+func (cs *controller) CreateDevClone(ctx context.Context, req DevCloneRequest) (*DevClone, error) {
+	if req.Namespace == "" || req.Source == "" || req.Name == "" {
+		return nil, fmt.Errorf("devclone: the namespace, the source and the name of the clone are required")
+	}
+	if req.TTL <= 0 {
+		req.TTL = DefaultDevCloneTTL
+	}
+	source := req.Namespace + "/" + req.Source
+
+	pvc, pv, vol, err := cs.devCloneSource(ctx, req.Namespace, req.Source)
+	if err != nil {
+		return nil, err
+	}
+
+	clone := &DevClone{
+		Namespace: req.Namespace,
+		Name:      req.Name,
+		Source:    source,
+		Volume:    "pvc-" + string(uuid.NewUUID()),
+		Expires:   time.Now().Add(req.TTL).UTC().Truncate(time.Second),
+	}
+
+	var snap *apis.ZFSSnapshot
+	if !req.FreshSnapshot {
+		if snap, err = cs.latestReadySnapshot(ctx, vol.Name); err != nil {
+			return nil, fmt.Errorf("devclone: could not list the snapshots of %s: %w", source, err)
+		}
+	}
+	taken := snap == nil
+	if taken {
+		if snap, err = cs.takeDevCloneSnapshot(ctx, vol, clone); err != nil {
+			return nil, err
+		}
+	}
+	clone.Snapshot = snap.Name
+
+	if err = cs.provisionDevClone(ctx, pvc, pv, snap, clone); err != nil {
+		if taken {
+			cs.deleteDevCloneSnapshotOrLog(ctx, snap.Name)
+		}
+		return nil, err
+	}
+	klog.Infof("devclone: cloned %s into %s/%s from snapshot %s, expires at %s",
+		source, clone.Namespace, clone.Name, clone.Snapshot, clone.Expires.Format(time.RFC3339))
+	return clone, nil
+}
+
+// devCloneRequestOf returns the dev clone asked for by the annotations of
+// the PVC
+func devCloneRequestOf(pvc *corev1.PersistentVolumeClaim) (DevCloneRequest, error) {
+	req := DevCloneRequest{
+		Namespace:     pvc.Namespace,
+		Source:        pvc.Name,
+		Name:          pvc.Annotations[DevCloneRequestAnnotation],
+		FreshSnapshot: pvc.Annotations[DevCloneFreshSnapshotAnnotation] == "true",
+	}
+	if val := pvc.Annotations[DevCloneTTLAnnotation]; val != "" {
+		ttl, err := time.ParseDuration(val)
+		if err != nil || ttl <= 0 {
+			return req, fmt.Errorf("devclone: invalid %s %q, it should be a duration like 8h", DevCloneTTLAnnotation, val)
+		}
+		req.TTL = ttl
+	}
+	return req, nil
+}
+
+// requestDevClone handles the dev clone request of the PVC, if it has one,
+// in the background as the clone waits for its snapshot. A request is
+// handled once at a time for each PVC.
+func (cs *controller) requestDevClone(pvc *corev1.PersistentVolumeClaim) {
+	if pvc.Annotations[DevCloneRequestAnnotation] == "" {
+		return
+	}
+	key := pvc.Namespace + "/" + pvc.Name
+	if _, busy := cs.devClones.LoadOrStore(key, true); busy {
+		return
+	}
+	go func() {
+		defer cs.devClones.Delete(key)
+		cs.handleDevCloneRequest(context.TODO(), pvc)
+	}()
+}
+
+// handleDevCloneRequest creates the dev clone asked for by the PVC and
+// removes the request from it, the error is recorded on the PVC if the
+// clone fails. A clone whose PVC already exists is not created again, e.g.
+// when the controller restarted before removing the request.
+func (cs *controller) handleDevCloneRequest(ctx context.Context, pvc *corev1.PersistentVolumeClaim) {
+	req, err := devCloneRequestOf(pvc)
+	if err == nil {
+		_, err = cs.kubeClient.CoreV1().PersistentVolumeClaims(req.Namespace).Get(ctx, req.Name, metav1.GetOptions{})
+		if k8serror.IsNotFound(err) {
+			_, err = cs.CreateDevClone(ctx, req)
+		}
+	}
+	var reqErr string
+	if err != nil {
+		klog.Errorf("devclone: request of pvc %s/%s failed: %v", pvc.Namespace, pvc.Name, err)
+		reqErr = err.Error()
+	}
+	if err = cs.completeDevCloneRequest(ctx, pvc.Namespace, pvc.Name, req.Name, reqErr); err != nil {
+		klog.Errorf("devclone: could not complete the request of pvc %s/%s: %v", pvc.Namespace, pvc.Name, err)
+	}
+}
+
+// completeDevCloneRequest removes the request from the PVC, and records
+// its error if it failed
+func (cs *controller) completeDevCloneRequest(ctx context.Context, ns, name, clone, reqErr string) error {
+	pvc, err := cs.kubeClient.CoreV1().PersistentVolumeClaims(ns).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	// a new request has been made in the meantime
+	if pvc.Annotations[DevCloneRequestAnnotation] != clone {
+		return nil
+	}
+	delete(pvc.Annotations, DevCloneRequestAnnotation)
+	delete(pvc.Annotations, DevCloneErrorAnnotation)
+	if reqErr != "" {
+		pvc.Annotations[DevCloneErrorAnnotation] = reqErr
+	}
+	_, err = cs.kubeClient.CoreV1().PersistentVolumeClaims(ns).Update(ctx, pvc, metav1.UpdateOptions{})
+	return err
+}
+
+// devCloneSource resolves the PVC to clone to its PV and ZFSVolume
+func (cs *controller) devCloneSource(ctx context.Context, ns, name string) (*corev1.PersistentVolumeClaim,
+	*corev1.PersistentVolume, *apis.ZFSVolume, error) {
+	source := ns + "/" + name
+	pvc, err := cs.kubeClient.CoreV1().PersistentVolumeClaims(ns).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("devclone: could not get pvc %s: %w", source, err)
+	}
+	if pvc.Spec.VolumeName == "" {
+		return nil, nil, nil, fmt.Errorf("devclone: pvc %s is not bound", source)
+	}
+	pv, err := cs.kubeClient.CoreV1().PersistentVolumes().Get(ctx, pvc.Spec.VolumeName, metav1.GetOptions{})
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("devclone: could not get pv %s of pvc %s: %w", pvc.Spec.VolumeName, source, err)
+	}
+	if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != zfs.DriverName {
+		return nil, nil, nil, fmt.Errorf("devclone: pv %s of pvc %s is not a %s volume", pv.Name, source, zfs.DriverName)
+	}
+	vol, err := cs.openebsClient.ZfsV1().ZFSVolumes(zfs.OpenEBSNamespace).
+		Get(ctx, pv.Spec.CSI.VolumeHandle, metav1.GetOptions{})
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("devclone: could not get the volume of pvc %s: %w", source, err)
+	}
+	return pvc, pv, vol, nil
+}
+
+// latestReadySnapshot returns the last snapshot taken of the volume which
+// is ready, nil if there is none. The snapshots taken for other dev clones
+// are left out, they are deleted along with their clone.
+func (cs *controller) latestReadySnapshot(ctx context.Context, volName string) (*apis.ZFSSnapshot, error) {
+	snaps, err := cs.listDevCloneSnapshots(ctx, zfs.ZFSVolKey+"="+volName)
+	if err != nil {
+		return nil, err
+	}
+	var ready []apis.ZFSSnapshot
+	for _, s := range snaps {
+		if s.Status.State == zfs.ZFSStatusReady && s.DeletionTimestamp == nil && s.Labels[DevCloneLabel] == "" {
+			ready = append(ready, s)
+		}
+	}
+	if len(ready) == 0 {
+		return nil, nil
+	}
+	sort.SliceStable(ready, func(i, j int) bool {
+		return snapshotTime(&ready[i]).After(snapshotTime(&ready[j]))
+	})
+	return &ready[0], nil
+}
+
+// snapshotTime is the time the zfs snapshot has been taken at, or the
+// creation time of the ZFSSnapshot if it is not known
+func snapshotTime(snap *apis.ZFSSnapshot) time.Time {
+	if snap.Status.CreationTime != nil {
+		return snap.Status.CreationTime.Time
+	}
+	return snap.CreationTimestamp.Time
+}
+
+// takeDevCloneSnapshot takes a snapshot of the volume for the clone and
+// waits for it to be ready. The snapshot is tagged with the clone, it is
+// deleted along with it.
+func (cs *controller) takeDevCloneSnapshot(ctx context.Context, vol *apis.ZFSVolume, clone *DevClone) (*apis.ZFSSnapshot, error) {
+	snap := &apis.ZFSSnapshot{}
+	snap.Name = "devclone-" + clone.Volume[len("pvc-"):]
+	snap.Namespace = zfs.OpenEBSNamespace
+	snap.Labels = map[string]string{
+		zfs.ZFSVolKey: vol.Name,
+		DevCloneLabel: clone.Volume,
+	}
+	snap.Annotations = map[string]string{
+		DevCloneExpiresAnnotation: clone.Expires.Format(time.RFC3339),
+	}
+	snap.Spec.VolumeInfo = vol.Spec
+	snap.Status.State = zfs.ZFSStatusPending
+	snaps := cs.openebsClient.ZfsV1().ZFSSnapshots(zfs.OpenEBSNamespace)
+	if _, err := snaps.Create(ctx, snap, metav1.CreateOptions{}); err != nil {
+		return nil, fmt.Errorf("devclone: could not create snapshot %s of %s: %w", snap.Name, clone.Source, err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, devCloneSnapshotTimeout)
+	defer cancel()
+	for {
+		s, err := snaps.Get(ctx, snap.Name, metav1.GetOptions{})
+		if err != nil {
+			klog.Warningf("devclone: failed to get snapshot %s: %v", snap.Name, err)
+		} else if s.Status.State == zfs.ZFSStatusReady {
+			return s, nil
+		} else if s.Status.State == zfs.ZFSStatusFailed {
+			cs.deleteDevCloneSnapshotOrLog(ctx, snap.Name)
+			return nil, fmt.Errorf("devclone: snapshot %s of %s failed: %s", snap.Name, clone.Source, s.Status.Error)
+		}
+		select {
+		case <-ctx.Done():
+			// the context is done, the snapshot is deleted without it
+			cs.deleteDevCloneSnapshotOrLog(context.TODO(), snap.Name)
+			return nil, fmt.Errorf("devclone: snapshot %s of %s not taken: %v", snap.Name, clone.Source, ctx.Err())
+		case <-time.After(devClonePollInterval):
+		}
+	}
+}
+
+// provisionDevClone restores the snapshot into the volume of the clone and
+// binds it to a PV and a PVC. The PV is deleted by the provisioner with
+// the PVC, which deletes the volume. The volume and the PV are deleted if
+// the PVC can not be created.
+func (cs *controller) provisionDevClone(ctx context.Context, pvc *corev1.PersistentVolumeClaim, pv *corev1.PersistentVolume,
+	snap *apis.ZFSSnapshot, clone *DevClone) error {
+	capacity, err := strconv.ParseInt(snap.Spec.Capacity, 10, 64)
+	if err != nil {
+		return fmt.Errorf("devclone: invalid capacity %q of snapshot %s", snap.Spec.Capacity, snap.Name)
+	}
+	creq := &csi.CreateVolumeRequest{
+		Name:          clone.Volume,
+		CapacityRange: &csi.CapacityRange{RequiredBytes: capacity},
+		Parameters:    map[string]string{"poolname": snap.Spec.PoolName},
+	}
+	if _, err = cs.cloneSnapshot(ctx, creq, snap.Labels[zfs.ZFSVolKey]+"@"+snap.Name); err != nil {
+		return fmt.Errorf("devclone: could not clone snapshot %s: %w", snap.Name, err)
+	}
+
+	clonePV, clonePVC := devCloneObjects(pvc, pv, snap, clone, capacity)
+	if _, err = cs.kubeClient.CoreV1().PersistentVolumes().Create(ctx, clonePV, metav1.CreateOptions{}); err == nil {
+		if _, err = cs.kubeClient.CoreV1().PersistentVolumeClaims(clonePVC.Namespace).
+			Create(ctx, clonePVC, metav1.CreateOptions{}); err != nil {
+			derr := cs.kubeClient.CoreV1().PersistentVolumes().Delete(ctx, clonePV.Name, metav1.DeleteOptions{})
+			if derr != nil && !k8serror.IsNotFound(derr) {
+				klog.Errorf("devclone: could not delete pv %s of failed clone: %v", clonePV.Name, derr)
+			}
+		}
+	}
+	if err != nil {
+		derr := cs.openebsClient.ZfsV1().ZFSVolumes(zfs.OpenEBSNamespace).Delete(ctx, clone.Volume, metav1.DeleteOptions{})
+		if derr != nil && !k8serror.IsNotFound(derr) {
+			klog.Errorf("devclone: could not delete volume %s of failed clone: %v", clone.Volume, derr)
+		}
+		return fmt.Errorf("devclone: could not bind volume %s to pvc %s/%s: %w",
+			clone.Volume, clone.Namespace, clone.Name, err)
+	}
+	return nil
+}
+
+// devCloneObjects returns the PV and the PVC of the clone. They have the
+// class, the access modes, the volume mode and the node affinity of the
+// source. The PV is annotated as provisioned by the driver so that it is
+// deleted along with its volume once the PVC is deleted.
+func devCloneObjects(pvc *corev1.PersistentVolumeClaim, pv *corev1.PersistentVolume,
+	snap *apis.ZFSSnapshot, clone *DevClone, capacity int64) (*corev1.PersistentVolume, *corev1.PersistentVolumeClaim) {
+	size := *resource.NewQuantity(capacity, resource.BinarySI)
+	labels := map[string]string{DevCloneLabel: clone.Volume}
+
+	clonePV := &corev1.PersistentVolume{}
+	clonePV.Name = clone.Volume
+	clonePV.Labels = labels
+	clonePV.Annotations = map[string]string{provisionedByAnnotation: zfs.DriverName}
+	clonePV.Spec = corev1.PersistentVolumeSpec{
+		Capacity:                      corev1.ResourceList{corev1.ResourceStorage: size},
+		AccessModes:                   pvc.Spec.AccessModes,
+		VolumeMode:                    pvc.Spec.VolumeMode,
+		StorageClassName:              pv.Spec.StorageClassName,
+		PersistentVolumeReclaimPolicy: corev1.PersistentVolumeReclaimDelete,
+		NodeAffinity:                  pv.Spec.NodeAffinity.DeepCopy(),
+		ClaimRef: &corev1.ObjectReference{
+			Kind:       "PersistentVolumeClaim",
+			APIVersion: "v1",
+			Namespace:  clone.Namespace,
+			Name:       clone.Name,
+		},
+		PersistentVolumeSource: corev1.PersistentVolumeSource{
+			CSI: &corev1.CSIPersistentVolumeSource{
+				Driver:       zfs.DriverName,
+				VolumeHandle: clone.Volume,
+				FSType:       pv.Spec.CSI.FSType,
+				VolumeAttributes: map[string]string{
+					zfs.PoolNameKey:       snap.Spec.PoolName,
+					zfs.OpenEBSCasTypeKey: zfs.ZFSCasTypeName,
+				},
+			},
+		},
+	}
+
+	clonePVC := &corev1.PersistentVolumeClaim{}
+	clonePVC.Name = clone.Name
+	clonePVC.Namespace = clone.Namespace
+	clonePVC.Labels = labels
+	clonePVC.Annotations = map[string]string{
+		DevCloneSourceAnnotation:   clone.Source,
+		DevCloneSnapshotAnnotation: clone.Snapshot,
+		DevCloneExpiresAnnotation:  clone.Expires.Format(time.RFC3339),
+	}
+	clonePVC.Spec = corev1.PersistentVolumeClaimSpec{
+		AccessModes:      pvc.Spec.AccessModes,
+		VolumeMode:       pvc.Spec.VolumeMode,
+		StorageClassName: pvc.Spec.StorageClassName,
+		VolumeName:       clone.Volume,
+		Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{corev1.ResourceStorage: size},
+		},
+	}
+	return clonePV, clonePVC
+}
+
+// devCloneOf returns the dev clone of the PVC, the expiry is left unset if
+// it can not be parsed
+func devCloneOf(pvc *corev1.PersistentVolumeClaim) DevClone {
+	clone := DevClone{
+		Namespace: pvc.Namespace,
+		Name:      pvc.Name,
+		Source:    pvc.Annotations[DevCloneSourceAnnotation],
+		Volume:    pvc.Labels[DevCloneLabel],
+		Snapshot:  pvc.Annotations[DevCloneSnapshotAnnotation],
+	}
+	if t, err := time.Parse(time.RFC3339, pvc.Annotations[DevCloneExpiresAnnotation]); err == nil {
+		clone.Expires = t
+	}
+	return clone
+}
+
+// ListDevClones returns the dev clones of the namespace, of all the
+// namespaces if empty, sorted by expiry
+func (cs *controller) ListDevClones(ctx context.Context, ns string) ([]DevClone, error) {
+	pvcs, err := cs.kubeClient.CoreV1().PersistentVolumeClaims(ns).
+		List(ctx, metav1.ListOptions{LabelSelector: DevCloneLabel})
+	if err != nil {
+		return nil, fmt.Errorf("devclone: could not list the dev clones: %w", err)
+	}
+	clones := make([]DevClone, 0, len(pvcs.Items))
+	for i := range pvcs.Items {
+		clones = append(clones, devCloneOf(&pvcs.Items[i]))
+	}
+	sort.SliceStable(clones, func(i, j int) bool { return clones[i].Expires.Before(clones[j].Expires) })
+	return clones, nil
+}
+
+// collectDevClones deletes the expired dev clones, it is run periodically
+// by the controller
+func (cs *controller) collectDevClones() {
+	cs.collectExpiredDevClones(context.TODO(), time.Now())
+}
+
+// collectExpiredDevClones deletes the PVCs of the expired dev clones, the
+// provisioner then deletes their PV and volume. The snapshots taken for
+// the dev clones are deleted once expired and their clone volume is gone,
+// a snapshot can not be destroyed while it has clones.
+func (cs *controller) collectExpiredDevClones(ctx context.Context, now time.Time) {
+	clones, err := cs.ListDevClones(ctx, "")
+	if err != nil {
+		klog.Errorf("%v", err)
+		return
+	}
+	for _, clone := range clones {
+		if clone.Expires.IsZero() {
+			klog.Warningf("devclone: pvc %s/%s has no valid %s annotation, it is not deleted",
+				clone.Namespace, clone.Name, DevCloneExpiresAnnotation)
+			continue
+		}
+		if !clone.Expired(now) {
+			continue
+		}
+		klog.Infof("devclone: deleting expired dev clone %s/%s of %s", clone.Namespace, clone.Name, clone.Source)
+		err = cs.kubeClient.CoreV1().PersistentVolumeClaims(clone.Namespace).Delete(ctx, clone.Name, metav1.DeleteOptions{})
+		if err != nil && !k8serror.IsNotFound(err) {
+			klog.Errorf("devclone: could not delete pvc %s/%s: %v", clone.Namespace, clone.Name, err)
+		}
+	}
+
+	snaps, err := cs.listDevCloneSnapshots(ctx, DevCloneLabel)
+	if err != nil {
+		klog.Errorf("devclone: could not list the snapshots of the dev clones: %v", err)
+		return
+	}
+	for _, snap := range snaps {
+		expires, err := time.Parse(time.RFC3339, snap.Annotations[DevCloneExpiresAnnotation])
+		if err != nil || now.Before(expires) || snap.DeletionTimestamp != nil {
+			continue
+		}
+		_, err = cs.openebsClient.ZfsV1().ZFSVolumes(zfs.OpenEBSNamespace).
+			Get(ctx, snap.Labels[DevCloneLabel], metav1.GetOptions{})
+		if !k8serror.IsNotFound(err) {
+			continue
+		}
+		klog.Infof("devclone: deleting snapshot %s of expired dev clone %s", snap.Name, snap.Labels[DevCloneLabel])
+		cs.deleteDevCloneSnapshotOrLog(ctx, snap.Name)
+	}
+}
+
+// listDevCloneSnapshots returns the ZFSSnapshots matching the selector
+func (cs *controller) listDevCloneSnapshots(ctx context.Context, selector string) ([]apis.ZFSSnapshot, error) {
+	list, err := cs.openebsClient.ZfsV1().ZFSSnapshots(zfs.OpenEBSNamespace).
+		List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+// deleteDevCloneSnapshotOrLog deletes the snapshot taken for a dev clone
+func (cs *controller) deleteDevCloneSnapshotOrLog(ctx context.Context, name string) {
+	err := cs.openebsClient.ZfsV1().ZFSSnapshots(zfs.OpenEBSNamespace).Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil && !k8serror.IsNotFound(err) {
+		klog.Errorf("devclone: could not delete snapshot %s: %v", name, err)
+	}
+}
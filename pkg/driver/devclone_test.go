@@ -0,0 +1,334 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	openebsfake "github.com/openebs/zfs-localpv/pkg/generated/clientset/internalclientset/fake"
+	"github.com/openebs/zfs-localpv/pkg/zfs"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// fakeDevClone is the api server of the dev clones, the source pvc dev/db
+// is bound to the volume pvc-src
+type fakeDevClone struct {
+	kube    *fake.Clientset
+	openebs *openebsfake.Clientset
+	// clonedFrom are the snapshots the volumes have been cloned from
+	clonedFrom []string
+}
+
+// newFakeDevClone returns the controller of the dev clones and its api
+// server holding the objects, the snapshots taken are ready right away
+func newFakeDevClone(t *testing.T, kubeObjs []runtime.Object, openebsObjs ...runtime.Object) (*controller, *fakeDevClone) {
+	orig := devClonePollInterval
+	t.Cleanup(func() { devClonePollInterval = orig })
+	devClonePollInterval = time.Millisecond
+
+	source := &corev1.PersistentVolumeClaim{}
+	source.Namespace, source.Name = "dev", "db"
+	source.Spec.VolumeName = "pvc-src"
+	source.Spec.AccessModes = []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce}
+	sc := "openebs-zfspv"
+	source.Spec.StorageClassName = &sc
+	pv := &corev1.PersistentVolume{}
+	pv.Name = "pvc-src"
+	pv.Spec.StorageClassName = sc
+	pv.Spec.CSI = &corev1.CSIPersistentVolumeSource{Driver: zfs.DriverName, VolumeHandle: "pvc-src", FSType: "zfs"}
+	// the source given by the test replaces the default one
+	kubeObjs = append([]runtime.Object{pv}, kubeObjs...)
+	if !hasDevCloneSource(kubeObjs) {
+		kubeObjs = append(kubeObjs, source)
+	}
+
+	f := &fakeDevClone{
+		kube:    fake.NewSimpleClientset(kubeObjs...),
+		openebs: openebsfake.NewSimpleClientset(openebsObjs...),
+	}
+	f.openebs.PrependReactor("create", "zfssnapshots", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		action.(k8stesting.CreateAction).GetObject().(*apis.ZFSSnapshot).Status.State = zfs.ZFSStatusReady
+		return false, nil, nil
+	})
+	cs := &controller{kubeClient: f.kube, openebsClient: f.openebs}
+	cs.cloneSnapshot = func(ctx context.Context, req *csi.CreateVolumeRequest, snapshot string) (string, error) {
+		f.clonedFrom = append(f.clonedFrom, snapshot)
+		_, err := f.openebs.ZfsV1().ZFSVolumes(zfs.OpenEBSNamespace).
+			Create(ctx, devCloneVolume(req.GetName()), metav1.CreateOptions{})
+		return "node-1", err
+	}
+	return cs, f
+}
+
+func hasDevCloneSource(objs []runtime.Object) bool {
+	for _, obj := range objs {
+		if pvc, ok := obj.(*corev1.PersistentVolumeClaim); ok && pvc.Namespace == "dev" && pvc.Name == "db" {
+			return true
+		}
+	}
+	return false
+}
+
+func devCloneVolume(name string) *apis.ZFSVolume {
+	vol := &apis.ZFSVolume{}
+	vol.Namespace = zfs.OpenEBSNamespace
+	vol.Name = name
+	vol.Spec.PoolName = "zfspv-pool"
+	vol.Spec.Capacity = "4294967296"
+	vol.Spec.OwnerNodeID = "node-1"
+	return vol
+}
+
+// created returns the objects created through the client
+func created[T runtime.Object](c interface{ Actions() []k8stesting.Action }, resource string) []T {
+	var objs []T
+	for _, action := range c.Actions() {
+		if create, ok := action.(k8stesting.CreateAction); ok && action.GetVerb() == "create" &&
+			action.GetResource().Resource == resource {
+			objs = append(objs, create.GetObject().(T))
+		}
+	}
+	return objs
+}
+
+func (f *fakeDevClone) createdSnaps() []*apis.ZFSSnapshot {
+	return created[*apis.ZFSSnapshot](f.openebs, "zfssnapshots")
+}
+
+func (f *fakeDevClone) createdPVs() []*corev1.PersistentVolume {
+	return created[*corev1.PersistentVolume](f.kube, "persistentvolumes")
+}
+
+func (f *fakeDevClone) createdPVCs() []*corev1.PersistentVolumeClaim {
+	return created[*corev1.PersistentVolumeClaim](f.kube, "persistentvolumeclaims")
+}
+
+// deleted returns the objects deleted, those of kubernetes first
+func (f *fakeDevClone) deleted() []string {
+	kinds := map[string]string{"persistentvolumes": "pv", "persistentvolumeclaims": "pvc",
+		"zfsvolumes": "volume", "zfssnapshots": "snapshot"}
+	var deleted []string
+	for _, action := range append(f.kube.Actions(), f.openebs.Actions()...) {
+		if del, ok := action.(k8stesting.DeleteAction); ok {
+			name := del.GetName()
+			if del.GetNamespace() != "" && kinds[del.GetResource().Resource] == "pvc" {
+				name = del.GetNamespace() + "/" + name
+			}
+			deleted = append(deleted, kinds[del.GetResource().Resource]+" "+name)
+		}
+	}
+	return deleted
+}
+
+func devCloneSnap(name, volume string, state string, taken time.Time) *apis.ZFSSnapshot {
+	s := &apis.ZFSSnapshot{}
+	s.Namespace = zfs.OpenEBSNamespace
+	s.Name = name
+	s.Labels = map[string]string{zfs.ZFSVolKey: volume}
+	s.Spec.PoolName = "zfspv-pool"
+	s.Spec.Capacity = "4294967296"
+	s.Status.State = state
+	t := metav1.NewTime(taken)
+	s.Status.CreationTime = &t
+	return s
+}
+
+func TestCreateDevCloneLatestSnapshot(t *testing.T) {
+	now := time.Now()
+	taken := devCloneSnap("snap-other-clone", "pvc-src", zfs.ZFSStatusReady, now)
+	taken.Labels[DevCloneLabel] = "pvc-other"
+	cs, f := newFakeDevClone(t, nil,
+		devCloneVolume("pvc-src"),
+		devCloneSnap("snap-old", "pvc-src", zfs.ZFSStatusReady, now.Add(-2*time.Hour)),
+		devCloneSnap("snap-new", "pvc-src", zfs.ZFSStatusReady, now.Add(-time.Hour)),
+		devCloneSnap("snap-pending", "pvc-src", zfs.ZFSStatusPending, now),
+		devCloneSnap("snap-unrelated", "pvc-other", zfs.ZFSStatusReady, now),
+		taken,
+	)
+
+	clone, err := cs.CreateDevClone(context.TODO(), DevCloneRequest{
+		Namespace: "dev", Source: "db", Name: "db-copy", TTL: time.Hour,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "snap-new", clone.Snapshot)
+	assert.Equal(t, "dev/db", clone.Source)
+	assert.True(t, strings.HasPrefix(clone.Volume, "pvc-"))
+	assert.WithinDuration(t, now.Add(time.Hour), clone.Expires, 2*time.Second)
+	assert.Empty(t, f.createdSnaps())
+	assert.Equal(t, []string{"pvc-src@snap-new"}, f.clonedFrom)
+
+	if pvs, pvcs := f.createdPVs(), f.createdPVCs(); assert.Len(t, pvs, 1) && assert.Len(t, pvcs, 1) {
+		pv, pvc := pvs[0], pvcs[0]
+		assert.Equal(t, clone.Volume, pv.Name)
+		assert.Equal(t, clone.Volume, pv.Spec.CSI.VolumeHandle)
+		assert.Equal(t, zfs.DriverName, pv.Annotations[provisionedByAnnotation])
+		assert.Equal(t, corev1.PersistentVolumeReclaimDelete, pv.Spec.PersistentVolumeReclaimPolicy)
+		assert.Equal(t, "db-copy", pv.Spec.ClaimRef.Name)
+		assert.Equal(t, "dev", pvc.Namespace)
+		assert.Equal(t, clone.Volume, pvc.Spec.VolumeName)
+		assert.Equal(t, clone.Volume, pvc.Labels[DevCloneLabel])
+		assert.Equal(t, "dev/db", pvc.Annotations[DevCloneSourceAnnotation])
+		assert.Equal(t, clone.Expires.Format(time.RFC3339), pvc.Annotations[DevCloneExpiresAnnotation])
+		size := pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+		assert.Equal(t, int64(4294967296), size.Value())
+	}
+}
+
+func TestCreateDevCloneFreshSnapshot(t *testing.T) {
+	cs, f := newFakeDevClone(t, nil,
+		devCloneVolume("pvc-src"),
+		devCloneSnap("snap-old", "pvc-src", zfs.ZFSStatusReady, time.Now().Add(-time.Hour)),
+	)
+
+	clone, err := cs.CreateDevClone(context.TODO(), DevCloneRequest{
+		Namespace: "dev", Source: "db", Name: "db-copy", FreshSnapshot: true,
+	})
+	assert.NoError(t, err)
+	if snaps := f.createdSnaps(); assert.Len(t, snaps, 1) {
+		snap := snaps[0]
+		assert.Equal(t, clone.Snapshot, snap.Name)
+		assert.Equal(t, "pvc-src", snap.Labels[zfs.ZFSVolKey])
+		assert.Equal(t, clone.Volume, snap.Labels[DevCloneLabel])
+		assert.Equal(t, clone.Expires.Format(time.RFC3339), snap.Annotations[DevCloneExpiresAnnotation])
+	}
+	assert.Equal(t, []string{"pvc-src@" + clone.Snapshot}, f.clonedFrom)
+	assert.WithinDuration(t, time.Now().Add(DefaultDevCloneTTL), clone.Expires, 2*time.Second)
+}
+
+func TestCreateDevCloneRollback(t *testing.T) {
+	// there is no snapshot of the source, one is taken for the clone, and
+	// the pvc of the clone exists
+	exists := &corev1.PersistentVolumeClaim{}
+	exists.Namespace, exists.Name = "dev", "db-copy"
+	cs, f := newFakeDevClone(t, []runtime.Object{exists}, devCloneVolume("pvc-src"))
+
+	_, err := cs.CreateDevClone(context.TODO(), DevCloneRequest{Namespace: "dev", Source: "db", Name: "db-copy"})
+	assert.ErrorContains(t, err, "already exists")
+	if snaps, pvs := f.createdSnaps(), f.createdPVs(); assert.Len(t, snaps, 1) && assert.Len(t, pvs, 1) {
+		volume := pvs[0].Name
+		assert.Equal(t, []string{
+			"pv " + volume,
+			"volume " + volume,
+			"snapshot " + snaps[0].Name,
+		}, f.deleted())
+	}
+
+	_, err = cs.CreateDevClone(context.TODO(), DevCloneRequest{Namespace: "dev", Source: "db"})
+	assert.Error(t, err)
+}
+
+func TestCollectExpiredDevClones(t *testing.T) {
+	now := time.Now()
+	pvc := func(name, volume, expires string) *corev1.PersistentVolumeClaim {
+		p := &corev1.PersistentVolumeClaim{}
+		p.Namespace, p.Name = "dev", name
+		p.Labels = map[string]string{DevCloneLabel: volume}
+		p.Annotations = map[string]string{DevCloneExpiresAnnotation: expires}
+		return p
+	}
+	snap := func(name, clone string, expires time.Time) *apis.ZFSSnapshot {
+		s := devCloneSnap(name, "pvc-src", zfs.ZFSStatusReady, now)
+		s.Labels[DevCloneLabel] = clone
+		s.Annotations = map[string]string{DevCloneExpiresAnnotation: expires.Format(time.RFC3339)}
+		return s
+	}
+	past, future := now.Add(-time.Minute), now.Add(time.Hour)
+	cs, f := newFakeDevClone(t,
+		[]runtime.Object{
+			pvc("live", "pvc-live", future.Format(time.RFC3339)),
+			pvc("expired", "pvc-deleting", past.Format(time.RFC3339)),
+			pvc("invalid", "pvc-invalid", "tomorrow"),
+		},
+		devCloneVolume("pvc-deleting"),
+		devCloneVolume("pvc-live"),
+		snap("devclone-gone", "pvc-gone", past),
+		snap("devclone-deleting", "pvc-deleting", past),
+		snap("devclone-live", "pvc-live", future),
+	)
+
+	clones, err := cs.ListDevClones(context.TODO(), "")
+	assert.NoError(t, err)
+	if assert.Len(t, clones, 3) {
+		// the clone without a valid expiry comes first
+		assert.Equal(t, []string{"invalid", "expired", "live"},
+			[]string{clones[0].Name, clones[1].Name, clones[2].Name})
+		assert.True(t, clones[1].Expired(now))
+		assert.False(t, clones[2].Expired(now))
+	}
+
+	cs.collectExpiredDevClones(context.TODO(), now)
+	// the snapshot of a clone whose volume is still there is kept
+	assert.Equal(t, []string{"pvc dev/expired", "snapshot devclone-gone"}, f.deleted())
+}
+
+func TestHandleDevCloneRequest(t *testing.T) {
+	source := &corev1.PersistentVolumeClaim{}
+	source.Namespace, source.Name = "dev", "db"
+	source.Spec.VolumeName = "pvc-src"
+	source.Annotations = map[string]string{
+		DevCloneRequestAnnotation: "db-copy",
+		DevCloneTTLAnnotation:     "8h",
+	}
+	cs, f := newFakeDevClone(t, []runtime.Object{source.DeepCopy()}, devCloneVolume("pvc-src"))
+	// request sets the annotations of the source pvc and tells whether the
+	// request has been removed once handled, with its error if any
+	request := func(annotations map[string]string) (bool, string) {
+		pvc := source.DeepCopy()
+		pvc.Annotations = annotations
+		_, err := f.kube.CoreV1().PersistentVolumeClaims("dev").Update(context.TODO(), pvc, metav1.UpdateOptions{})
+		assert.NoError(t, err)
+		cs.handleDevCloneRequest(context.TODO(), pvc)
+		pvc, err = f.kube.CoreV1().PersistentVolumeClaims("dev").Get(context.TODO(), "db", metav1.GetOptions{})
+		assert.NoError(t, err)
+		_, pending := pvc.Annotations[DevCloneRequestAnnotation]
+		return !pending, pvc.Annotations[DevCloneErrorAnnotation]
+	}
+
+	done, reqErr := request(source.Annotations)
+	assert.True(t, done)
+	assert.Empty(t, reqErr)
+	if pvcs := f.createdPVCs(); assert.Len(t, pvcs, 1) {
+		expires := pvcs[0].Annotations[DevCloneExpiresAnnotation]
+		at, err := time.Parse(time.RFC3339, expires)
+		assert.NoError(t, err)
+		assert.WithinDuration(t, time.Now().Add(8*time.Hour), at, 2*time.Second)
+	}
+
+	// the clone exists, the request is only removed
+	done, reqErr = request(source.Annotations)
+	assert.True(t, done)
+	assert.Empty(t, reqErr)
+	assert.Len(t, f.createdPVCs(), 1)
+
+	// the error of an invalid request is recorded
+	done, reqErr = request(map[string]string{
+		DevCloneRequestAnnotation: "db-copy",
+		DevCloneTTLAnnotation:     "tomorrow",
+	})
+	assert.True(t, done)
+	assert.Contains(t, reqErr, "invalid "+DevCloneTTLAnnotation)
+}
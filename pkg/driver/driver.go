@@ -18,7 +18,10 @@ package driver
 
 import (
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	k8sapi "github.com/openebs/lib-csi/pkg/client/k8s"
 	config "github.com/openebs/zfs-localpv/pkg/config"
+	"github.com/openebs/zfs-localpv/pkg/zfs"
+	"google.golang.org/grpc"
 	"k8s.io/klog/v2"
 )
 
@@ -32,6 +35,10 @@ type CSIDriver struct {
 	ids    csi.IdentityServer
 	ns     csi.NodeServer
 	cs     csi.ControllerServer
+	gcs    csi.GroupControllerServer
+
+	// health is the grpc health service of the plugin
+	health *serviceHealth
 
 	cap []*csi.VolumeCapability_AccessMode
 }
@@ -41,6 +48,7 @@ type CSIDriver struct {
 func GetVolumeCapabilityAccessModes() []*csi.VolumeCapability_AccessMode {
 	supported := []csi.VolumeCapability_AccessMode_Mode{
 		csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY,
 	}
 
 	var vcams []*csi.VolumeCapability_AccessMode
@@ -62,9 +70,23 @@ func New(config *config.Config) *CSIDriver {
 		cap:    GetVolumeCapabilityAccessModes(),
 	}
 
+	// the topology keys are derived from the driver name, and the
+	// namespace is claimed for it
+	if err := zfs.SetDriverName(config.DriverName); err != nil {
+		klog.Fatalf("init driver: %v", err)
+	}
+	cs, err := k8sapi.Clientset().Get()
+	if err != nil {
+		klog.Fatalf("init driver: %v", err)
+	}
+	if err = zfs.ClaimDriverNamespace(cs, config.DriverName); err != nil {
+		klog.Fatalf("init driver: %v", err)
+	}
+
 	switch config.PluginType {
 	case "controller":
 		driver.cs = NewController(driver)
+		driver.gcs = NewGroupController(driver)
 
 	case "agent":
 		// Start monitor goroutine to monitor the
@@ -80,6 +102,9 @@ func New(config *config.Config) *CSIDriver {
 	// share capabilities and probe the corresponding
 	// driver
 	driver.ids = NewIdentity(driver)
+
+	checks := append(readinessChecks(config.PluginType), checkSocket(config.Endpoint))
+	driver.health = newServiceHealth(checks, csiServices(config.PluginType)...)
 	return driver
 }
 
@@ -87,9 +112,21 @@ func New(config *config.Config) *CSIDriver {
 // over the given endpoint
 func (d *CSIDriver) Run() error {
 	// Initialize and start listening on grpc server
-	s := NewNonBlockingGRPCServer(d.config.Endpoint, d.ids, d.cs, d.ns)
+	services := []func(*grpc.Server){d.health.register(d.config.GRPCReflection)}
+	if d.gcs != nil {
+		services = append(services, func(server *grpc.Server) {
+			csi.RegisterGroupControllerServer(server, d.gcs)
+		})
+	}
+	s := NewNonBlockingGRPCServer(d.config.Endpoint, d.config.SocketReadyTimeout, d.ids, d.cs, d.ns,
+		services...)
 
 	s.Start()
+
+	// the services are not serving before the endpoint is ready
+	<-s.Ready()
+	go d.health.run(healthCheckInterval, nil)
+
 	s.Wait()
 
 	return nil
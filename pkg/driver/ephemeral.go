@@ -0,0 +1,181 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"github.com/openebs/zfs-localpv/pkg/builder/volbuilder"
+	"github.com/openebs/zfs-localpv/pkg/zfs"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	k8serror "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// defaultEphemeralSize is the size of an ephemeral volume asking for none
+const defaultEphemeralSize = "1Gi"
+
+// isEphemeralRequest returns true if the kubelet asks to publish an
+// ephemeral inline volume of a pod
+func isEphemeralRequest(req *csi.NodePublishVolumeRequest) bool {
+	return req.GetVolumeContext()[zfs.EphemeralContextKey] == "true"
+}
+
+// buildEphemeralVolume returns the ZFSVolume for the ephemeral inline
+// volume from the volumeAttributes of the pod. Only datasets are
+// supported, there is nothing to format and the quota is set right away.
+func buildEphemeralVolume(volumeID string, attrs map[string]string) (*apis.ZFSVolume, error) {
+	params := make(map[string]string, len(attrs))
+	for k, v := range attrs {
+		params[strings.ToLower(k)] = v
+	}
+
+	if fstype := params["fstype"]; fstype != "" && fstype != zfs.FSTypeZFS {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"ephemeral volume %s: unsupported fstype %q, only %s is supported", volumeID, fstype, zfs.FSTypeZFS)
+	}
+
+	size := params["size"]
+	if size == "" {
+		size = defaultEphemeralSize
+	}
+	qty, err := resource.ParseQuantity(size)
+	if err != nil || qty.Value() <= 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "ephemeral volume %s: invalid size %q", volumeID, size)
+	}
+	capacity := strconv.FormatInt(getRoundedCapacity(qty.Value()), 10)
+	volName := strings.ToLower(volumeID)
+	dsname, err := zfs.ShortDatasetName(params["poolname"], volName)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "ephemeral volume %s: %v", volumeID, err)
+	}
+
+	vol, err := volbuilder.NewBuilder().
+		WithName(volName).
+		WithCapacity(capacity).
+		WithPoolName(params["poolname"]).
+		WithDatasetName(dsname).
+		WithRecordSize(params["recordsize"]).
+		WithCompression(params["compression"]).
+		WithThinProv(params["thinprovision"]).
+		WithQuotaType(params["quotatype"]).
+		WithVolumeType(zfs.VolTypeDataset).
+		WithFsType(zfs.FSTypeZFS).
+		WithOwnerNodeID(zfs.NodeID).
+		WithVolumeStatus(zfs.ZFSStatusReady).
+		WithFinalizer([]string{zfs.ZFSFinalizer}).
+		WithLabels(map[string]string{zfs.EphemeralKey: "true", zfs.ZFSNodeKey: zfs.NodeID}).
+		Build()
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "ephemeral volume %s: %v", volumeID, err)
+	}
+	return vol, nil
+}
+
+// publishEphemeral creates the dataset of an ephemeral inline volume and
+// mounts it, the dataset is destroyed again if any step fails. A volume
+// which already exists, e.g. on a retry, is only mounted.
+func (ns *node) publishEphemeral(req *csi.NodePublishVolumeRequest, mountInfo *zfs.MountInfo) (*apis.ZFSVolume, error) {
+	vol, err := buildEphemeralVolume(req.GetVolumeId(), req.GetVolumeContext())
+	if err != nil {
+		return nil, err
+	}
+	if err = zfs.PrepareTargetParent(mountInfo.MountPath, ns.mounter.missingParent); err != nil {
+		return nil, err
+	}
+
+	vols := ns.openebsClient.ZfsV1().ZFSVolumes(zfs.OpenEBSNamespace)
+	if existing, err := vols.Get(context.TODO(), vol.Name, metav1.GetOptions{}); err == nil {
+		if !zfs.IsEphemeral(existing) {
+			return nil, status.Errorf(codes.AlreadyExists,
+				"ephemeral volume %s: a persistent volume with the same name exists", vol.Name)
+		}
+		if err = ns.mounter.mountFilesystem(existing, mountInfo); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		return existing, nil
+	} else if !k8serror.IsNotFound(err) {
+		return nil, status.Errorf(codes.Internal, "ephemeral volume %s: %v", vol.Name, err)
+	}
+
+	// the pool might have been filled up since the pod was scheduled
+	capacity, _ := strconv.ParseInt(vol.Spec.Capacity, 10, 64)
+	avail, err := zfs.GetPoolAvailable(vol.Spec.PoolName)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if avail < capacity {
+		return nil, status.Errorf(codes.ResourceExhausted,
+			"ephemeral volume %s: pool %s has %d bytes available, %d needed", vol.Name, vol.Spec.PoolName, avail, capacity)
+	}
+
+	// the ephemeral volumes do not have a generated key
+	if err = zfs.CreateVolume(vol, ""); err != nil {
+		return nil, status.Errorf(codes.Internal, "ephemeral volume %s: %v", vol.Name, err)
+	}
+	if _, err = vols.Create(context.TODO(), vol, metav1.CreateOptions{}); err != nil {
+		ns.destroyEphemeral(vol, false)
+		return nil, status.Errorf(codes.Internal, "ephemeral volume %s: %v", vol.Name, err)
+	}
+	if err = ns.mounter.mountFilesystem(vol, mountInfo); err != nil {
+		ns.destroyEphemeral(vol, true)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	klog.Infof("ephemeral volume %s created and mounted at %s", vol.Name, mountInfo.MountPath)
+	return vol, nil
+}
+
+// destroyEphemeral rolls back a failed publish, the ZFSVolume is deleted
+// as well if it has been created. The finalizer makes the node destroy the
+// dataset again should it fail here.
+func (ns *node) destroyEphemeral(vol *apis.ZFSVolume, saved bool) {
+	if err := zfs.DestroyVolume(vol); err != nil {
+		klog.Errorf("ephemeral volume %s: could not destroy the dataset: %v", vol.Name, err)
+	}
+	if !saved {
+		return
+	}
+	err := ns.openebsClient.ZfsV1().ZFSVolumes(zfs.OpenEBSNamespace).Delete(context.TODO(), vol.Name, metav1.DeleteOptions{})
+	if err != nil {
+		klog.Errorf("ephemeral volume %s: could not delete the ZFSVolume: %v", vol.Name, err)
+	}
+}
+
+// unpublishEphemeral unmounts the ephemeral inline volume and destroys its
+// dataset, an error is returned so that the kubelet tries again until the
+// dataset is gone
+func (ns *node) unpublishEphemeral(vol *apis.ZFSVolume, targetPath string) error {
+	if err := ns.mounter.unmount(vol, targetPath); err != nil {
+		return status.Errorf(codes.Internal, "unable to umount the volume %s err : %s", vol.Name, err.Error())
+	}
+	if err := zfs.DestroyVolume(vol); err != nil {
+		return status.Errorf(codes.Internal, "ephemeral volume %s: could not destroy the dataset: %v", vol.Name, err)
+	}
+	err := ns.openebsClient.ZfsV1().ZFSVolumes(zfs.OpenEBSNamespace).Delete(context.TODO(), vol.Name, metav1.DeleteOptions{})
+	if err != nil && !k8serror.IsNotFound(err) {
+		return status.Errorf(codes.Internal, "ephemeral volume %s: could not delete the ZFSVolume: %v", vol.Name, err)
+	}
+	klog.Infof("ephemeral volume %s destroyed", vol.Name)
+	return nil
+}
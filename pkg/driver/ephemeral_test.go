@@ -0,0 +1,178 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	openebsfake "github.com/openebs/zfs-localpv/pkg/generated/clientset/internalclientset/fake"
+	"github.com/openebs/zfs-localpv/pkg/zfs"
+	"github.com/openebs/zfs-localpv/pkg/zfs/zfstest"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// fakeNode runs the ephemeral volumes on the fake zfs holding the pool
+// zfspv, their ZFSVolumes are kept by the fake clientset
+type fakeNode struct {
+	zfs       *zfstest.FakeZFS
+	openebs   *openebsfake.Clientset
+	mounted   map[string]string
+	mountFail bool
+}
+
+// newFakeNode returns the node mounting the ephemeral volumes in f, the
+// pool has avail bytes available
+func newFakeNode(t *testing.T, avail string) (*node, *fakeNode) {
+	f := &fakeNode{
+		zfs:     zfstest.New(t, "zfspv"),
+		openebs: openebsfake.NewSimpleClientset(),
+		mounted: map[string]string{},
+	}
+	f.zfs.InPath(t)
+	f.zfs.Set(t, "zfspv", "available", avail)
+	return &node{openebsClient: f.openebs, mounter: &mounter{
+		missingParent: zfs.MissingParentCreate,
+		mountFilesystem: func(vol *apis.ZFSVolume, mnt *zfs.MountInfo) error {
+			if f.mountFail {
+				return errors.New("mount failed")
+			}
+			f.mounted[vol.Name] = mnt.MountPath
+			return nil
+		},
+		unmount: func(vol *apis.ZFSVolume, path string) error { delete(f.mounted, vol.Name); return nil },
+	}}, f
+}
+
+// volumes returns the names of the ZFSVolumes
+func (f *fakeNode) volumes(t *testing.T) []string {
+	list, err := f.openebs.ZfsV1().ZFSVolumes(zfs.OpenEBSNamespace).List(context.TODO(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	var names []string
+	for _, vol := range list.Items {
+		names = append(names, vol.Name)
+	}
+	return names
+}
+
+// ephemeralRequest returns the request publishing the volume in the pod
+// directory of the kubelet, the directory of the volumes is missing
+func ephemeralRequest(t *testing.T, attrs map[string]string) *csi.NodePublishVolumeRequest {
+	ctx := map[string]string{zfs.EphemeralContextKey: "true"}
+	for k, v := range attrs {
+		ctx[k] = v
+	}
+	return &csi.NodePublishVolumeRequest{
+		VolumeId:      "csi-0123abcd",
+		TargetPath:    filepath.Join(t.TempDir(), "pods", "uid", "volumes", "scratch"),
+		VolumeContext: ctx,
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+		},
+	}
+}
+
+func TestBuildEphemeralVolume(t *testing.T) {
+	vol, err := buildEphemeralVolume("CSI-0123abcd", map[string]string{"poolName": "zfspv", "size": "100Mi"})
+	assert.NoError(t, err)
+	assert.Equal(t, "csi-0123abcd", vol.Name)
+	assert.Equal(t, "104857600", vol.Spec.Capacity)
+	assert.Equal(t, zfs.VolTypeDataset, vol.Spec.VolumeType)
+	assert.Equal(t, "quota", vol.Spec.QuotaType)
+	assert.True(t, zfs.IsEphemeral(vol))
+
+	vol, err = buildEphemeralVolume("csi-1", map[string]string{"poolname": "zfspv"})
+	assert.NoError(t, err)
+	assert.Equal(t, "1073741824", vol.Spec.Capacity)
+
+	for _, attrs := range []map[string]string{
+		{"size": "1Gi"},
+		{"poolname": "zfspv", "size": "lots"},
+		{"poolname": "zfspv", "fstype": "ext4"},
+	} {
+		_, err = buildEphemeralVolume("csi-1", attrs)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), attrs)
+	}
+}
+
+func TestEphemeralPublishUnpublish(t *testing.T) {
+	ns, f := newFakeNode(t, "10737418240")
+	req := ephemeralRequest(t, map[string]string{"poolname": "zfspv", "size": "1Gi"})
+	assert.True(t, isEphemeralRequest(req))
+
+	// the dataset is created on publish
+	vol, err := ns.publishEphemeral(req, getMountInfo(req))
+	assert.NoError(t, err)
+	assert.True(t, f.zfs.Exists("zfspv/csi-0123abcd"))
+	assert.Equal(t, "1073741824", f.zfs.Prop("zfspv/csi-0123abcd", "quota"))
+	assert.Equal(t, []string{vol.Name}, f.volumes(t))
+	assert.Equal(t, req.TargetPath, f.mounted[vol.Name])
+
+	// a retry only mounts it again
+	_, err = ns.publishEphemeral(req, getMountInfo(req))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{vol.Name}, f.volumes(t))
+
+	// and destroyed on unpublish
+	assert.NoError(t, ns.unpublishEphemeral(vol, req.TargetPath))
+	assert.False(t, f.zfs.Exists("zfspv/csi-0123abcd"))
+	assert.Empty(t, f.volumes(t))
+	assert.Empty(t, f.mounted)
+}
+
+func TestEphemeralPublishFailure(t *testing.T) {
+	ns, f := newFakeNode(t, "536870912")
+	req := ephemeralRequest(t, map[string]string{"poolname": "zfspv", "size": "1Gi"})
+
+	// the dataset is not created if the pod directory is gone
+	ns.mounter.missingParent = zfs.MissingParentFail
+	_, err := ns.publishEphemeral(req, getMountInfo(req))
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	assert.False(t, f.zfs.Exists("zfspv/csi-0123abcd"))
+	ns.mounter.missingParent = zfs.MissingParentCreate
+
+	// the capacity is checked before creating the dataset
+	_, err = ns.publishEphemeral(req, getMountInfo(req))
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.False(t, f.zfs.Exists("zfspv/csi-0123abcd"))
+
+	// nothing is left behind when the mount fails
+	f.zfs.Set(t, "zfspv", "available", "10737418240")
+	f.mountFail = true
+	_, err = ns.publishEphemeral(req, getMountInfo(req))
+	assert.Equal(t, codes.Internal, status.Code(err))
+	assert.False(t, f.zfs.Exists("zfspv/csi-0123abcd"))
+	assert.Empty(t, f.volumes(t))
+
+	// a persistent volume with the same name is not touched
+	f.mountFail = false
+	persistent := &apis.ZFSVolume{}
+	persistent.Namespace = zfs.OpenEBSNamespace
+	persistent.Name = "csi-0123abcd"
+	_, err = f.openebs.ZfsV1().ZFSVolumes(zfs.OpenEBSNamespace).Create(context.TODO(), persistent, metav1.CreateOptions{})
+	assert.NoError(t, err)
+	_, err = ns.publishEphemeral(req, getMountInfo(req))
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+	assert.Empty(t, f.mounted)
+}
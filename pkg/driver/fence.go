@@ -0,0 +1,108 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"sync"
+	"time"
+
+	"github.com/openebs/zfs-localpv/pkg/zfs"
+	"k8s.io/klog/v2"
+)
+
+// fenceLeases keeps the leases of this node on the zvols published as raw
+// block devices fresh, whether the volume health check is enabled or not.
+type fenceLeases struct {
+	mtx     sync.Mutex
+	volumes map[string]map[string]bool
+
+	// renew takes or renews the lease on the zvol of the volume
+	renew func(volID string) error
+}
+
+func newFenceLeases() *fenceLeases {
+	return &fenceLeases{
+		volumes: map[string]map[string]bool{},
+		renew:   renewFence,
+	}
+}
+
+// track adds the block path the volume is published at
+func (f *fenceLeases) track(volID, path string) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	if f.volumes[volID] == nil {
+		f.volumes[volID] = map[string]bool{}
+	}
+	f.volumes[volID][path] = true
+}
+
+// untrack removes the path of the volume, it returns true once the volume
+// is not published at any block path anymore, so that its lease can be
+// released
+func (f *fenceLeases) untrack(volID, path string) bool {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	delete(f.volumes[volID], path)
+	if len(f.volumes[volID]) == 0 {
+		delete(f.volumes, volID)
+		return true
+	}
+	return false
+}
+
+// renewAll renews the leases of all the tracked volumes
+func (f *fenceLeases) renewAll() {
+	f.mtx.Lock()
+	volumes := make([]string, 0, len(f.volumes))
+	for volID := range f.volumes {
+		volumes = append(volumes, volID)
+	}
+	f.mtx.Unlock()
+
+	for _, volID := range volumes {
+		if err := f.renew(volID); err != nil {
+			klog.Errorf("fence: could not renew the lease on volume %s: %v", volID, err)
+		}
+	}
+}
+
+// run renews the leases at every interval until the stop channel is closed
+func (f *fenceLeases) run(interval time.Duration, stopCh <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+			f.renewAll()
+		}
+	}
+}
+
+// renewFence renews the lease of this node on the zvol of the volume
+func renewFence(volID string) error {
+	vol, err := zfs.GetZFSVolume(volID)
+	if err != nil {
+		return err
+	}
+	if _, err = zfs.ResolveVolumePool(nil, vol, false); err != nil {
+		return err
+	}
+	return zfs.AcquireFence(vol)
+}
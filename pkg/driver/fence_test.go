@@ -0,0 +1,69 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"errors"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFenceLeasesRenewAll(t *testing.T) {
+	f := newFenceLeases()
+	var renewed []string
+	f.renew = func(volID string) error {
+		renewed = append(renewed, volID)
+		if volID == "pvc-2" {
+			return errors.New("zvol is held by node node-2")
+		}
+		return nil
+	}
+
+	f.track("pvc-1", "/dev/block/pvc-1")
+	f.track("pvc-2", "/dev/block/pvc-2")
+	f.renewAll()
+	sort.Strings(renewed)
+	assert.Equal(t, []string{"pvc-1", "pvc-2"}, renewed)
+
+	// the leases are still renewed after a failure
+	renewed = nil
+	f.renewAll()
+	assert.Len(t, renewed, 2)
+}
+
+func TestFenceLeasesUntrack(t *testing.T) {
+	f := newFenceLeases()
+	f.renew = func(volID string) error { return nil }
+
+	f.track("pvc-1", "/dev/block/pod-1")
+	f.track("pvc-1", "/dev/block/pod-2")
+	assert.False(t, f.untrack("pvc-1", "/dev/block/pod-1"), "the zvol is still published at another path")
+	assert.True(t, f.untrack("pvc-1", "/dev/block/pod-2"))
+
+	// the volumes published before a restart are not tracked
+	assert.True(t, f.untrack("pvc-2", "/dev/block/pod-3"))
+
+	renewed := 0
+	f.renew = func(volID string) error {
+		renewed++
+		return nil
+	}
+	f.renewAll()
+	assert.Zero(t, renewed, "the lease of an unpublished volume has been renewed")
+}
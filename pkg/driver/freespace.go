@@ -0,0 +1,113 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// thin capacity check policies, the thick volumes are always checked
+const (
+	// ThinCapacitySkip does not check the free space for the thin
+	// volumes, this is the default
+	ThinCapacitySkip = "skip"
+
+	// ThinCapacityPrefer tries the nodes having enough free space first,
+	// the other ones are still tried
+	ThinCapacityPrefer = "prefer"
+
+	// ThinCapacityStrict only places the thin volumes on the nodes having
+	// enough free space, like the thick ones
+	ThinCapacityStrict = "strict"
+
+	// lowSpacePenalty is added to the score of the nodes without enough
+	// free space for a preferred check, it outweighs any capacity
+	lowSpacePenalty = int64(1) << 60
+)
+
+// freeSpace checks the free space of the pool against the capacity of the
+// volume, as rounded and aligned for the provisioning. The nodes whose
+// free space has not been reported are not checked.
+type freeSpace struct {
+	size     int64
+	required bool
+}
+
+func (f *freeSpace) fits(c Candidate) bool {
+	return c.Free == nil || *c.Free >= f.size
+}
+
+func (f *freeSpace) Filter(_ *csi.CreateVolumeRequest, c Candidate) bool {
+	return !f.required || f.fits(c)
+}
+
+func (f *freeSpace) Score(_ *csi.CreateVolumeRequest, c Candidate) int64 {
+	if f.required || f.fits(c) {
+		return 0
+	}
+	return lowSpacePenalty
+}
+
+// parseCapacityCheck returns the free space check of the volume. The thick
+// volumes reserve their whole capacity, they are always checked, while the
+// thin ones are checked as per the thincapacitycheck parameter. It returns
+// nil if the volume is not checked.
+func parseCapacityCheck(policy string, thin bool, size int64) (*freeSpace, error) {
+	switch policy {
+	case "", ThinCapacitySkip, ThinCapacityPrefer, ThinCapacityStrict:
+	default:
+		return nil, fmt.Errorf("invalid thincapacitycheck %q, it should be %s, %s or %s",
+			policy, ThinCapacitySkip, ThinCapacityPrefer, ThinCapacityStrict)
+	}
+
+	if !thin {
+		return &freeSpace{size: size, required: true}, nil
+	}
+	switch policy {
+	case ThinCapacityPrefer:
+		return &freeSpace{size: size}, nil
+	case ThinCapacityStrict:
+		return &freeSpace{size: size, required: true}, nil
+	}
+	return nil, nil
+}
+
+// unschedulable returns the error explaining that none of the eligible
+// nodes has enough free space, with the shortfall on the node having the
+// most of it
+func (f *freeSpace) unschedulable(pool string, nodelist []string, cmap map[string]Candidate) error {
+	var best string
+	var free int64
+	for _, node := range nodelist {
+		if c := cmap[node]; c.Free != nil && (best == "" || *c.Free > free) {
+			best, free = node, *c.Free
+		}
+	}
+	return status.Errorf(codes.ResourceExhausted,
+		"not enough free space in pool %s for %s: the largest free space is %s on node %s, %s short",
+		pool, quantity(f.size), quantity(free), best, quantity(f.size-free))
+}
+
+// quantity formats the bytes for the error messages, e.g. 10Gi
+func quantity(bytes int64) string {
+	return resource.NewQuantity(bytes, resource.BinarySI).String()
+}
@@ -0,0 +1,166 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func freeBytes(b int64) *int64 { return &b }
+
+func TestParseCapacityCheck(t *testing.T) {
+	// the thick volumes are always checked
+	for _, policy := range []string{"", ThinCapacitySkip, ThinCapacityPrefer, ThinCapacityStrict} {
+		f, err := parseCapacityCheck(policy, false, Gi)
+		assert.NoError(t, err, policy)
+		assert.Equal(t, &freeSpace{size: Gi, required: true}, f, policy)
+	}
+
+	f, err := parseCapacityCheck("", true, Gi)
+	assert.NoError(t, err)
+	assert.Nil(t, f)
+
+	f, err = parseCapacityCheck(ThinCapacityPrefer, true, Gi)
+	assert.NoError(t, err)
+	assert.Equal(t, &freeSpace{size: Gi}, f)
+
+	f, err = parseCapacityCheck(ThinCapacityStrict, true, Gi)
+	assert.NoError(t, err)
+	assert.Equal(t, &freeSpace{size: Gi, required: true}, f)
+
+	_, err = parseCapacityCheck("always", false, Gi)
+	assert.Error(t, err)
+}
+
+func TestBuildCandidatesFree(t *testing.T) {
+	zfsnodes := []apis.ZFSNode{{}, {}}
+	zfsnodes[0].Name = "node1"
+	zfsnodes[0].Pools = []apis.Pool{{Name: "zfspv", Free: resource.MustParse("4Gi")}}
+	zfsnodes[1].Name = "node2"
+	zfsnodes[1].Pools = []apis.Pool{{Name: "other", Free: resource.MustParse("4Gi")}}
+
+	// the free space is the one of the zpool holding the child dataset
+	cmap := buildCandidates("zfspv/k8s", nil, zfsnodes)
+	assert.Equal(t, freeBytes(4*Gi), cmap["node1"].Free)
+	assert.NotContains(t, cmap, "node2")
+}
+
+func TestFreeSpacePlacement(t *testing.T) {
+	nodes := []string{"node1", "node2", "node3", "node4"}
+	cmap := map[string]Candidate{
+		"node1": {Node: "node1", Pool: "zfspv", Volumes: 1, Free: freeBytes(2 * Gi)},
+		"node2": {Node: "node2", Pool: "zfspv", Volumes: 2, Free: freeBytes(20 * Gi)},
+		"node3": {Node: "node3", Pool: "zfspv", Volumes: 3, Free: freeBytes(10 * Gi)},
+		// the free space of node4 has not been reported
+		"node4": {Node: "node4", Pool: "zfspv", Volumes: 4},
+	}
+	req := &csi.CreateVolumeRequest{}
+
+	tests := map[string]struct {
+		thin     bool
+		policy   string
+		expected []string
+	}{
+		"thick": {
+			expected: []string{"node2", "node3", "node4"},
+		},
+		"thin": {
+			thin:     true,
+			expected: []string{"node1", "node2", "node3", "node4"},
+		},
+		"thin preferred": {
+			thin:     true,
+			policy:   ThinCapacityPrefer,
+			expected: []string{"node2", "node3", "node4", "node1"},
+		},
+		"thin strict": {
+			thin:     true,
+			policy:   ThinCapacityStrict,
+			expected: []string{"node2", "node3", "node4"},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			f, err := parseCapacityCheck(test.policy, test.thin, 10*Gi)
+			assert.NoError(t, err)
+			s := Scheduler(volumeWeighted{})
+			if f != nil {
+				s = Compose(s, f)
+			}
+			assert.Equal(t, test.expected, rankNodes(req, s, "zfspv", nodes, cmap))
+		})
+	}
+}
+
+func TestFreeSpaceUnschedulable(t *testing.T) {
+	cmap := map[string]Candidate{
+		"node1": {Node: "node1", Pool: "zfspv", Free: freeBytes(2 * Gi)},
+		"node2": {Node: "node2", Pool: "zfspv", Free: freeBytes(6 * Gi)},
+	}
+	f := &freeSpace{size: 10 * Gi, required: true}
+	assert.Empty(t, rankNodes(&csi.CreateVolumeRequest{}, f, "zfspv", []string{"node1", "node2"}, cmap))
+
+	err := f.unschedulable("zfspv", []string{"node1", "node2"}, cmap)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Contains(t, err.Error(),
+		"not enough free space in pool zfspv for 10Gi: the largest free space is 6Gi on node node2, 4Gi short")
+}
+
+func TestBuildCandidatesTenant(t *testing.T) {
+	tenant := func(name, avail string) apis.TenantUsage {
+		a := resource.MustParse(avail)
+		return apis.TenantUsage{Name: name, Quota: resource.MustParse("100Gi"), Available: &a}
+	}
+	zfsnodes := []apis.ZFSNode{{}, {}}
+	zfsnodes[0].Name = "node1"
+	zfsnodes[0].Pools = []apis.Pool{{Name: "zfspv", Free: resource.MustParse("50Gi")}}
+	zfsnodes[0].Status.Tenants = []apis.TenantUsage{tenant("zfspv/tenant-a", "2Gi"), tenant("zfspv/tenant-b", "80Gi")}
+	zfsnodes[1].Name = "node2"
+	zfsnodes[1].Pools = []apis.Pool{{Name: "zfspv", Free: resource.MustParse("50Gi")}}
+
+	// the tenant near its quota leaves less than the free space of the zpool
+	cmap := buildCandidates("zfspv/tenant-a/k8s", nil, zfsnodes)
+	assert.Equal(t, freeBytes(2*Gi), cmap["node1"].Free)
+	assert.Equal(t, freeBytes(50*Gi), cmap["node2"].Free)
+	// the free space of the zpool is the bound of a tenant with a larger quota
+	cmap = buildCandidates("zfspv/tenant-b", nil, zfsnodes)
+	assert.Equal(t, freeBytes(50*Gi), cmap["node1"].Free)
+	// a dataset named like a tenant is not in it
+	cmap = buildCandidates("zfspv/tenant-a2", nil, zfsnodes)
+	assert.Equal(t, freeBytes(50*Gi), cmap["node1"].Free)
+
+	// a thick volume goes to the node whose tenant can still hold it
+	cmap = buildCandidates("zfspv/tenant-a", nil, zfsnodes)
+	f := &freeSpace{size: 10 * Gi, required: true}
+	nodes := []string{"node1", "node2"}
+	assert.Equal(t, []string{"node2"}, rankNodes(&csi.CreateVolumeRequest{}, f, "zfspv/tenant-a", nodes, cmap))
+
+	zfsnodes[1].Status.Tenants = []apis.TenantUsage{tenant("zfspv/tenant-a", "1Gi")}
+	cmap = buildCandidates("zfspv/tenant-a", nil, zfsnodes)
+	assert.Empty(t, rankNodes(&csi.CreateVolumeRequest{}, f, "zfspv/tenant-a", nodes, cmap))
+	err := f.unschedulable("zfspv/tenant-a", nodes, cmap)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Contains(t, err.Error(), "the largest free space is 2Gi on node node1, 8Gi short")
+}
@@ -0,0 +1,221 @@
+/*
+Copyright © 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"strings"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"github.com/openebs/zfs-localpv/pkg/builder/snapbuilder"
+	"github.com/openebs/zfs-localpv/pkg/groupsnap"
+	"github.com/openebs/zfs-localpv/pkg/zfs"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	timestamp "google.golang.org/protobuf/types/known/timestamppb"
+	k8serror "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// groupController is the server implementation for the CSI
+// GroupControllerServer, the volume group snapshots are taken by the
+// groupsnap package and their ZFSSnapshots carry the group label
+type groupController struct {
+	driver *CSIDriver
+
+	// listSnapshots lists the ZFSSnapshots of the group
+	listSnapshots func(group string) ([]apis.ZFSSnapshot, error)
+}
+
+// NewGroupController returns a new instance of CSI GroupControllerServer
+func NewGroupController(d *CSIDriver) csi.GroupControllerServer {
+	return &groupController{
+		driver:        d,
+		listSnapshots: listGroupSnapshots,
+	}
+}
+
+func listGroupSnapshots(group string) ([]apis.ZFSSnapshot, error) {
+	snaps, err := snapbuilder.NewKubeclient().WithNamespace(zfs.OpenEBSNamespace).List(metav1.ListOptions{
+		LabelSelector: groupsnap.GroupLabel + "=" + group,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return snaps.Items, nil
+}
+
+// GroupControllerGetCapabilities fetches the group controller capabilities
+//
+// This implements csi.GroupControllerServer
+func (gc *groupController) GroupControllerGetCapabilities(
+	ctx context.Context,
+	req *csi.GroupControllerGetCapabilitiesRequest,
+) (*csi.GroupControllerGetCapabilitiesResponse, error) {
+
+	return &csi.GroupControllerGetCapabilitiesResponse{
+		Capabilities: []*csi.GroupControllerServiceCapability{{
+			Type: &csi.GroupControllerServiceCapability_Rpc{
+				Rpc: &csi.GroupControllerServiceCapability_RPC{
+					Type: csi.GroupControllerServiceCapability_RPC_CREATE_DELETE_GET_VOLUME_GROUP_SNAPSHOT,
+				},
+			},
+		}},
+	}, nil
+}
+
+// CreateVolumeGroupSnapshot creates the snapshots of all the volumes of
+// the group at once. The node agents take them in the background, the
+// group is reported as not ready to use until all of them are taken and
+// the request is retried until then.
+//
+// This implements csi.GroupControllerServer
+func (gc *groupController) CreateVolumeGroupSnapshot(
+	ctx context.Context,
+	req *csi.CreateVolumeGroupSnapshotRequest,
+) (*csi.CreateVolumeGroupSnapshotResponse, error) {
+	group := strings.ToLower(req.GetName())
+	if group == "" {
+		return nil, status.Error(codes.InvalidArgument, "CreateVolumeGroupSnapshot: empty name")
+	}
+	if len(req.GetSourceVolumeIds()) == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "CreateVolumeGroupSnapshot %s: no source volumes", group)
+	}
+	volumes := make([]string, 0, len(req.GetSourceVolumeIds()))
+	for _, id := range req.GetSourceVolumeIds() {
+		volumes = append(volumes, strings.ToLower(id))
+	}
+	klog.Infof("CreateVolumeGroupSnapshot %s of volumes %v", group, volumes)
+
+	// a group missing a snapshot is not consistent, a failed group is
+	// deleted and taken again on the retry
+	result, err := groupsnap.NewVolumeSnapshotter(group, volumes).WithDeleteOnFailure().Status(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	for _, st := range result.Snapshots {
+		if st.Phase == groupsnap.PhaseFailed {
+			return nil, status.Errorf(codes.Internal, "snapshot %s of volume %s in group %s failed: %s",
+				st.Snapshot, st.Volume, group, st.Reason)
+		}
+	}
+
+	snaps, err := gc.listSnapshots(group)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list the snapshots of group %s: %v", group, err)
+	}
+	snapshot, err := groupSnapshotResponse(group, snaps)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &csi.CreateVolumeGroupSnapshotResponse{GroupSnapshot: snapshot}, nil
+}
+
+// DeleteVolumeGroupSnapshot deletes the snapshots of the group
+//
+// This implements csi.GroupControllerServer
+func (gc *groupController) DeleteVolumeGroupSnapshot(
+	ctx context.Context,
+	req *csi.DeleteVolumeGroupSnapshotRequest,
+) (*csi.DeleteVolumeGroupSnapshotResponse, error) {
+	group := req.GetGroupSnapshotId()
+	if group == "" {
+		return nil, status.Error(codes.InvalidArgument, "DeleteVolumeGroupSnapshot: empty group snapshot id")
+	}
+	klog.Infof("DeleteVolumeGroupSnapshot %s", group)
+
+	snaps, err := gc.listSnapshots(group)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list the snapshots of group %s: %v", group, err)
+	}
+	names := map[string]bool{}
+	for _, snap := range snaps {
+		names[snap.Name] = true
+	}
+	// snapshodID is formed as <volname>@<snapname>
+	for _, id := range req.GetSnapshotIds() {
+		if parts := strings.Split(id, "@"); len(parts) == 2 {
+			names[parts[1]] = true
+		}
+	}
+	for name := range names {
+		if err := zfs.DeleteSnapshot(name); err != nil && !k8serror.IsNotFound(err) {
+			return nil, status.Errorf(codes.Internal, "failed to delete snapshot %s of group %s: %v", name, group, err)
+		}
+	}
+	return &csi.DeleteVolumeGroupSnapshotResponse{}, nil
+}
+
+// GetVolumeGroupSnapshot returns the status of the snapshots of the group
+//
+// This implements csi.GroupControllerServer
+func (gc *groupController) GetVolumeGroupSnapshot(
+	ctx context.Context,
+	req *csi.GetVolumeGroupSnapshotRequest,
+) (*csi.GetVolumeGroupSnapshotResponse, error) {
+	group := req.GetGroupSnapshotId()
+	if group == "" {
+		return nil, status.Error(codes.InvalidArgument, "GetVolumeGroupSnapshot: empty group snapshot id")
+	}
+
+	snaps, err := gc.listSnapshots(group)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list the snapshots of group %s: %v", group, err)
+	}
+	if len(snaps) == 0 {
+		return nil, status.Errorf(codes.NotFound, "group snapshot %s not found", group)
+	}
+	snapshot, err := groupSnapshotResponse(group, snaps)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &csi.GetVolumeGroupSnapshotResponse{GroupSnapshot: snapshot}, nil
+}
+
+// groupSnapshotResponse returns the group snapshot made of the snapshots,
+// it is ready to use once all of them are. Its creation time is the one of
+// its first snapshot.
+func groupSnapshotResponse(group string, snaps []apis.ZFSSnapshot) (*csi.VolumeGroupSnapshot, error) {
+	snapshot := &csi.VolumeGroupSnapshot{
+		GroupSnapshotId: group,
+		ReadyToUse:      true,
+	}
+	var creation time.Time
+	for i := range snaps {
+		resp, err := snapshotResponse(snaps[i].Labels[zfs.ZFSVolKey], &snaps[i])
+		if err != nil {
+			return nil, err
+		}
+		snap := resp.GetSnapshot()
+		snap.GroupSnapshotId = group
+		snapshot.Snapshots = append(snapshot.Snapshots, snap)
+		snapshot.ReadyToUse = snapshot.ReadyToUse && snap.ReadyToUse
+
+		taken := time.Unix(snap.CreationTime.GetSeconds(), int64(snap.CreationTime.GetNanos()))
+		if creation.IsZero() || taken.Before(creation) {
+			creation = taken
+		}
+	}
+	if creation.IsZero() {
+		creation = time.Now()
+	}
+	snapshot.CreationTime = &timestamp.Timestamp{Seconds: creation.Unix(), Nanos: int32(creation.Nanosecond())}
+	return snapshot, nil
+}
@@ -0,0 +1,88 @@
+/*
+Copyright © 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	zfsapi "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"github.com/openebs/zfs-localpv/pkg/zfs"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func groupSnap(vol string, ready bool, at time.Time) zfsapi.ZFSSnapshot {
+	snap := zfsapi.ZFSSnapshot{}
+	snap.Name = "group-1-" + vol
+	snap.Labels = map[string]string{zfs.ZFSVolKey: vol}
+	snap.Status.State = zfs.ZFSStatusPending
+	if ready {
+		created := metav1.NewTime(at)
+		snap.Status.State = zfs.ZFSStatusReady
+		snap.Status.CreationTime = &created
+		snap.Status.Size = "1048576"
+	}
+	return snap
+}
+
+func TestGroupSnapshotResponse(t *testing.T) {
+	t0 := time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)
+	snaps := []zfsapi.ZFSSnapshot{
+		groupSnap("pvc-1", true, t0.Add(time.Second)),
+		groupSnap("pvc-2", true, t0),
+	}
+
+	group, err := groupSnapshotResponse("group-1", snaps)
+	assert.NoError(t, err)
+	assert.True(t, group.ReadyToUse)
+	assert.Equal(t, t0.Unix(), group.CreationTime.Seconds, "the group is taken with its first snapshot")
+	assert.Len(t, group.Snapshots, 2)
+	assert.Equal(t, "pvc-1@group-1-pvc-1", group.Snapshots[0].SnapshotId)
+	assert.Equal(t, "pvc-1", group.Snapshots[0].SourceVolumeId)
+	assert.Equal(t, "group-1", group.Snapshots[0].GroupSnapshotId)
+
+	// not ready until all the snapshots are
+	snaps[1] = groupSnap("pvc-2", false, t0)
+	group, err = groupSnapshotResponse("group-1", snaps)
+	assert.NoError(t, err)
+	assert.False(t, group.ReadyToUse)
+	assert.True(t, group.Snapshots[0].ReadyToUse)
+}
+
+func TestGetVolumeGroupSnapshot(t *testing.T) {
+	snaps := map[string][]zfsapi.ZFSSnapshot{
+		"group-1": {groupSnap("pvc-1", true, time.Now())},
+	}
+	gc := &groupController{listSnapshots: func(group string) ([]zfsapi.ZFSSnapshot, error) {
+		return snaps[group], nil
+	}}
+
+	resp, err := gc.GetVolumeGroupSnapshot(context.Background(), &csi.GetVolumeGroupSnapshotRequest{GroupSnapshotId: "group-1"})
+	assert.NoError(t, err)
+	assert.True(t, resp.GroupSnapshot.ReadyToUse)
+
+	_, err = gc.GetVolumeGroupSnapshot(context.Background(), &csi.GetVolumeGroupSnapshotRequest{GroupSnapshotId: "group-2"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = gc.CreateVolumeGroupSnapshot(context.Background(), &csi.CreateVolumeGroupSnapshotRequest{Name: "group-3"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err), "a group needs its volumes")
+}
@@ -23,6 +23,7 @@ import (
 	"os"
 	"strings"
 	"sync"
+	"time"
 
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
@@ -86,9 +87,13 @@ func logGRPC(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, h
 
 // NonBlockingGRPCServer defines Non blocking GRPC server interfaces
 type NonBlockingGRPCServer interface {
-	// Start services at the endpoint
+	// Start services at the endpoint, it returns once the endpoint
+	// accepts the connections
 	Start()
 
+	// Ready is closed once the endpoint accepts the connections
+	Ready() <-chan struct{}
+
 	// Waits for the service to stop
 	Wait()
 
@@ -99,33 +104,62 @@ type NonBlockingGRPCServer interface {
 	ForceStop()
 }
 
-// NewNonBlockingGRPCServer returns a new instance of NonBlockingGRPCServer
-func NewNonBlockingGRPCServer(ep string, ids csi.IdentityServer, cs csi.ControllerServer, ns csi.NodeServer) NonBlockingGRPCServer {
+// NewNonBlockingGRPCServer returns a new instance of NonBlockingGRPCServer,
+// the services are registered on the grpc server along with the csi ones.
+// The endpoint is given readyTimeout to accept the connections.
+func NewNonBlockingGRPCServer(ep string, readyTimeout time.Duration, ids csi.IdentityServer, cs csi.ControllerServer, ns csi.NodeServer,
+	services ...func(*grpc.Server)) NonBlockingGRPCServer {
 	return &nonBlockingGRPCServer{
-		endpoint:    ep,
-		idntyServer: ids,
-		ctrlServer:  cs,
-		agentServer: ns}
+		endpoint:     ep,
+		readyTimeout: readyTimeout,
+		ready:        make(chan struct{}),
+		idntyServer:  ids,
+		ctrlServer:   cs,
+		agentServer:  ns,
+		services:     services}
 }
 
 // NonBlocking server
 // dont block the execution for a task to complete.
 // use wait group to wait for all the tasks dispatched.
 type nonBlockingGRPCServer struct {
-	wg          sync.WaitGroup
-	server      *grpc.Server
-	endpoint    string
-	idntyServer csi.IdentityServer
-	ctrlServer  csi.ControllerServer
-	agentServer csi.NodeServer
+	wg           sync.WaitGroup
+	server       *grpc.Server
+	endpoint     string
+	readyTimeout time.Duration
+	ready        chan struct{}
+	idntyServer  csi.IdentityServer
+	ctrlServer   csi.ControllerServer
+	agentServer  csi.NodeServer
+	services     []func(*grpc.Server)
 }
 
-// Start grpc server for serving CSI endpoints
+// Start grpc server for serving CSI endpoints, the driver exits if the
+// endpoint does not accept the connections within the ready timeout
 func (s *nonBlockingGRPCServer) Start() {
 
+	listener, err := listen(s.endpoint)
+	if err != nil {
+		klog.Fatal(err.Error())
+	}
+
+	s.server = s.newServer(s.idntyServer, s.ctrlServer, s.agentServer)
+
 	s.wg.Add(1)
 
-	go s.serve(s.endpoint, s.idntyServer, s.ctrlServer, s.agentServer)
+	go s.serve(listener)
+
+	addr := listener.Addr()
+	if err := waitSocketReady(addr.Network(), addr.String(), s.readyTimeout); err != nil {
+		klog.Fatal(err.Error())
+	}
+	klog.Infof("endpoint %s is ready", s.endpoint)
+	close(s.ready)
+}
+
+// Ready is closed once the endpoint accepts the connections
+func (s *nonBlockingGRPCServer) Ready() <-chan struct{} {
+	return s.ready
 }
 
 // Wait for the service to stop
@@ -143,31 +177,112 @@ func (s *nonBlockingGRPCServer) ForceStop() {
 	s.server.Stop()
 }
 
-// serve starts serving requests at the provided endpoint based on the type of
-// plugin. In this function all the csi related interfaces are provided by
-// container-storage-interface
-func (s *nonBlockingGRPCServer) serve(endpoint string, ids csi.IdentityServer, cs csi.ControllerServer, ns csi.NodeServer) {
+const (
+	// socketDialTimeout is the time a single connection to the
+	// endpoint is given
+	socketDialTimeout = time.Second
+
+	// socketPollInterval is the interval at which the endpoint is
+	// dialed until it is ready
+	socketPollInterval = 100 * time.Millisecond
+)
+
+// dialSocket connects to the address and closes the connection at once,
+// can be replaced in unit tests
+var dialSocket = func(network, addr string, timeout time.Duration) error {
+	conn, err := net.DialTimeout(network, addr, timeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
 
+// listen listens on the endpoint. A unix socket left by the last instance
+// is removed first, as the path is shared with the OS and will be the same
+// everytime the plugin restarts.
+func listen(endpoint string) (net.Listener, error) {
 	proto, addr, err := parseEndpoint(endpoint)
 	if err != nil {
-		klog.Fatal(err.Error())
+		return nil, err
 	}
 
-	// Clear off the addr if it is already present, this is done to remove stale
-	// entries, as this path is shared with the OS and will be the same
-	// everytime the plugin restarts, its possible that the last instance leaves
-	// a stale entry
 	if proto == "unix" {
 		addr = "/" + addr
-		if err := os.Remove(addr); err != nil && !os.IsNotExist(err) {
-			klog.Fatalf("Failed to remove %s, error: %s", addr, err.Error())
+		if err := removeStaleSocket(addr); err != nil {
+			return nil, err
 		}
 	}
 
 	listener, err := net.Listen(proto, addr)
 	if err != nil {
-		klog.Fatalf("Failed to listen: %v", err)
+		return nil, fmt.Errorf("failed to listen on %s: %v", endpoint, err)
+	}
+	return listener, nil
+}
+
+// removeStaleSocket removes the socket at the path if nothing accepts the
+// connections on it anymore. It fails if the path is not a socket or if
+// another instance is still serving on it, the socket is not taken over.
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %v", path, err)
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("failed to listen on %s, it exists and is not a socket", path)
+	}
+	if err := dialSocket("unix", path, socketDialTimeout); err == nil {
+		return fmt.Errorf("failed to listen on %s, another instance is serving on it", path)
+	}
+	klog.Infof("removing the stale socket %s", path)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove %s, error: %v", path, err)
+	}
+	return nil
+}
+
+// waitSocketReady dials the address until it accepts the connections, it
+// fails if it is not ready within the timeout
+func waitSocketReady(network, addr string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		err := dialSocket(network, addr, socketDialTimeout)
+		if err == nil {
+			return nil
+		}
+		if !time.Now().Before(deadline) {
+			return fmt.Errorf("endpoint %s://%s is not ready after %v: %v", network, addr, timeout, err)
+		}
+		time.Sleep(socketPollInterval)
 	}
+}
+
+// checkSocket returns the readiness check of the endpoint, it fails while
+// the endpoint does not accept the connections, e.g. once its socket has
+// been removed
+func checkSocket(endpoint string) readinessCheck {
+	return readinessCheck{
+		name: "socket",
+		check: func(ctx context.Context) error {
+			proto, addr, err := parseEndpoint(endpoint)
+			if err != nil {
+				return err
+			}
+			if proto == "unix" {
+				addr = "/" + addr
+			}
+			return dialSocket(proto, addr, socketDialTimeout)
+		},
+	}
+}
+
+// newServer returns the grpc server with the services of the plugin
+// registered. In this function all the csi related interfaces are provided
+// by container-storage-interface
+func (s *nonBlockingGRPCServer) newServer(ids csi.IdentityServer, cs csi.ControllerServer, ns csi.NodeServer) *grpc.Server {
 
 	opts := []grpc.ServerOption{
 		grpc.UnaryInterceptor(logGRPC),
@@ -175,7 +290,6 @@ func (s *nonBlockingGRPCServer) serve(endpoint string, ids csi.IdentityServer, c
 	// Create a new grpc server, all the request from csi client to
 	// create/delete/... will hit this server
 	server := grpc.NewServer(opts...)
-	s.server = server
 
 	if ids != nil {
 		csi.RegisterIdentityServer(server, ids)
@@ -186,12 +300,20 @@ func (s *nonBlockingGRPCServer) serve(endpoint string, ids csi.IdentityServer, c
 	if ns != nil {
 		csi.RegisterNodeServer(server, ns)
 	}
+	for _, register := range s.services {
+		register(server)
+	}
+	return server
+}
+
+// serve starts serving requests on the listener
+func (s *nonBlockingGRPCServer) serve(listener net.Listener) {
 
 	klog.Infof("Listening for connections on address: %#v", listener.Addr())
 
 	// Start serving requests on the grpc server created
-	err = server.Serve(listener)
-	if err != nil {
+	err := s.server.Serve(listener)
+	if err != nil && err != grpc.ErrServerStopped {
 		klog.Fatal(err.Error())
 	}
 }
@@ -0,0 +1,115 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+// staleSocket leaves a socket nothing listens on anymore at the path, as
+// a killed instance would
+func staleSocket(t *testing.T, path string) {
+	l, err := net.Listen("unix", path)
+	assert.NoError(t, err)
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	assert.NoError(t, l.Close())
+}
+
+func TestListenRemovesStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "csi.sock")
+	staleSocket(t, path)
+
+	l, err := listen("unix:/" + path)
+	assert.NoError(t, err)
+	defer l.Close()
+	assert.NoError(t, dialSocket("unix", path, time.Second))
+}
+
+func TestListenLiveSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "csi.sock")
+	live, err := net.Listen("unix", path)
+	assert.NoError(t, err)
+	defer live.Close()
+
+	// the socket of another instance is not taken over
+	_, err = listen("unix:/" + path)
+	assert.ErrorContains(t, err, "another instance is serving")
+	_, err = os.Stat(path)
+	assert.NoError(t, err)
+}
+
+func TestListenNotASocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "csi.sock")
+	assert.NoError(t, os.WriteFile(path, nil, 0600))
+
+	_, err := listen("unix:/" + path)
+	assert.ErrorContains(t, err, "is not a socket")
+}
+
+func TestWaitSocketReady(t *testing.T) {
+	origDial := dialSocket
+	t.Cleanup(func() { dialSocket = origDial })
+
+	// ready after a few attempts
+	attempts := 0
+	dialSocket = func(network, addr string, timeout time.Duration) error {
+		attempts++
+		if attempts < 3 {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+	assert.NoError(t, waitSocketReady("unix", "/csi/csi.sock", time.Minute))
+	assert.Equal(t, 3, attempts)
+
+	// never ready
+	dialSocket = func(network, addr string, timeout time.Duration) error {
+		return errors.New("connection refused")
+	}
+	start := time.Now()
+	err := waitSocketReady("unix", "/csi/csi.sock", 300*time.Millisecond)
+	assert.ErrorContains(t, err, "endpoint unix:///csi/csi.sock is not ready after 300ms: connection refused")
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestServerReady(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "csi.sock")
+	staleSocket(t, path)
+
+	s := NewNonBlockingGRPCServer("unix:/"+path, 5*time.Second, nil, nil, nil)
+	s.Start()
+	defer s.ForceStop()
+
+	select {
+	case <-s.Ready():
+	default:
+		t.Fatal("the server is not ready once started")
+	}
+	assert.NoError(t, checkSocket("unix:/"+path).check(context.Background()))
+
+	// the socket is gone
+	assert.NoError(t, os.Remove(path))
+	assert.Error(t, checkSocket("unix:/"+path).check(context.Background()))
+}
@@ -0,0 +1,181 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	k8sapi "github.com/openebs/lib-csi/pkg/client/k8s"
+	"github.com/openebs/zfs-localpv/pkg/zfs"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// healthCheckInterval is the interval at which the dependencies of
+	// the driver are checked for the grpc health service
+	healthCheckInterval = 10 * time.Second
+
+	// healthCheckTimeout is the time a single check is given
+	healthCheckTimeout = 5 * time.Second
+)
+
+// readinessCheck returns an error if a dependency of the driver is not
+// ready to serve the requests
+type readinessCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+// checkZFS verifies zfs can be used on the node, can be replaced in unit tests
+var checkZFS = zfs.CheckAvailable
+
+// checkAPIServer verifies the apiserver is reachable, can be replaced in
+// unit tests
+var checkAPIServer = func(ctx context.Context) error {
+	cs, err := k8sapi.Clientset().Get()
+	if err != nil {
+		return err
+	}
+	return cs.Discovery().RESTClient().Get().AbsPath("/readyz").Do(ctx).Error()
+}
+
+// readinessChecks returns the dependencies checked for the plugin type,
+// the node plugin needs zfs and the controller needs the apiserver
+func readinessChecks(pluginType string) []readinessCheck {
+	switch pluginType {
+	case "agent":
+		return []readinessCheck{{name: "zfs", check: checkZFS}}
+	case "controller":
+		return []readinessCheck{{name: "apiserver", check: checkAPIServer}}
+	}
+	return nil
+}
+
+// serviceHealth serves the standard grpc health service of the driver. The
+// overall status and the status of the csi services are SERVING only
+// while all the readiness checks pass.
+type serviceHealth struct {
+	server   *health.Server
+	checks   []readinessCheck
+	services []string
+
+	mu      sync.Mutex
+	serving *bool
+}
+
+// newServiceHealth returns the health service for the csi services, they
+// are NOT_SERVING until the checks have passed once
+func newServiceHealth(checks []readinessCheck, services ...string) *serviceHealth {
+	h := &serviceHealth{
+		server:   health.NewServer(),
+		checks:   checks,
+		services: services,
+	}
+	h.setStatus(healthpb.HealthCheckResponse_NOT_SERVING)
+	return h
+}
+
+func (h *serviceHealth) setStatus(st healthpb.HealthCheckResponse_ServingStatus) {
+	h.server.SetServingStatus("", st)
+	for _, svc := range h.services {
+		h.server.SetServingStatus(svc, st)
+	}
+}
+
+// check runs the readiness checks and updates the serving status, the
+// transitions are logged
+func (h *serviceHealth) check() {
+	var failed error
+	for _, c := range h.checks {
+		ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+		err := c.check(ctx)
+		cancel()
+		if err != nil {
+			failed = fmt.Errorf("%s: %v", c.name, err)
+			break
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	serving := failed == nil
+	if h.serving != nil && *h.serving == serving {
+		return
+	}
+	h.serving = &serving
+
+	if serving {
+		klog.Infof("health: the driver is serving")
+		h.setStatus(healthpb.HealthCheckResponse_SERVING)
+		return
+	}
+	klog.Errorf("health: the driver is not serving, %v", failed)
+	h.setStatus(healthpb.HealthCheckResponse_NOT_SERVING)
+}
+
+// run checks the dependencies right away and then at every interval
+func (h *serviceHealth) run(interval time.Duration, stopCh <-chan struct{}) {
+	h.check()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+			h.check()
+		}
+	}
+}
+
+// register adds the health service, and the reflection service if asked
+// for, to the grpc server
+func (h *serviceHealth) register(reflect bool) func(*grpc.Server) {
+	return func(server *grpc.Server) {
+		healthpb.RegisterHealthServer(server, h.server)
+		if reflect {
+			reflection.Register(server)
+		}
+	}
+}
+
+// names of the csi services in the grpc health service
+const (
+	identityService   = "csi.v1.Identity"
+	nodeService       = "csi.v1.Node"
+	controllerService = "csi.v1.Controller"
+	groupService      = "csi.v1.GroupController"
+)
+
+// csiServices returns the names of the csi services served by the plugin
+func csiServices(pluginType string) []string {
+	services := []string{identityService}
+	switch pluginType {
+	case "agent":
+		services = append(services, nodeService)
+	case "controller":
+		services = append(services, controllerService, groupService)
+	}
+	return services
+}
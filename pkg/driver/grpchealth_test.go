@@ -0,0 +1,110 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func healthStatus(t *testing.T, h *serviceHealth, service string) healthpb.HealthCheckResponse_ServingStatus {
+	resp, err := h.server.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+	assert.NoError(t, err)
+	return resp.GetStatus()
+}
+
+func TestServiceHealthTransitions(t *testing.T) {
+	var zfsErr error
+	checks := []readinessCheck{{name: "zfs", check: func(context.Context) error { return zfsErr }}}
+	h := newServiceHealth(checks, csiServices("agent")...)
+
+	// not serving until the checks have passed once
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, healthStatus(t, h, ""))
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, healthStatus(t, h, nodeService))
+
+	h.check()
+	for _, svc := range []string{"", identityService, nodeService} {
+		assert.Equal(t, healthpb.HealthCheckResponse_SERVING, healthStatus(t, h, svc), svc)
+	}
+
+	// zfs goes away
+	zfsErr = errors.New("The ZFS modules are not loaded")
+	h.check()
+	for _, svc := range []string{"", identityService, nodeService} {
+		assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, healthStatus(t, h, svc), svc)
+	}
+
+	zfsErr = nil
+	h.check()
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, healthStatus(t, h, ""))
+
+	// the controller service is not served by the node plugin
+	_, err := h.server.Check(context.Background(), &healthpb.HealthCheckRequest{Service: controllerService})
+	assert.Error(t, err)
+}
+
+func TestServiceHealthFailedCheck(t *testing.T) {
+	var calls []string
+	checks := []readinessCheck{
+		{name: "first", check: func(context.Context) error { calls = append(calls, "first"); return nil }},
+		{name: "second", check: func(context.Context) error { calls = append(calls, "second"); return errors.New("down") }},
+	}
+	h := newServiceHealth(checks, csiServices("controller")...)
+	h.check()
+	assert.Equal(t, []string{"first", "second"}, calls)
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, healthStatus(t, h, controllerService))
+}
+
+func TestReadinessChecks(t *testing.T) {
+	assert.Equal(t, "zfs", readinessChecks("agent")[0].name)
+	assert.Equal(t, "apiserver", readinessChecks("controller")[0].name)
+	assert.Empty(t, readinessChecks("other"))
+}
+
+func TestServiceHealthOverGRPC(t *testing.T) {
+	h := newServiceHealth(nil, csiServices("agent")...)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go h.run(time.Hour, stopCh)
+
+	lis := bufconn.Listen(1 << 16)
+	server := grpc.NewServer()
+	h.register(true)(server)
+	go func() { _ = server.Serve(lis) }()
+	defer server.Stop()
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	client := healthpb.NewHealthClient(conn)
+	assert.Eventually(t, func() bool {
+		resp, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: nodeService})
+		return err == nil && resp.GetStatus() == healthpb.HealthCheckResponse_SERVING
+	}, 5*time.Second, 10*time.Millisecond)
+}
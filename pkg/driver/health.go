@@ -0,0 +1,160 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/openebs/lib-csi/pkg/mount"
+	"github.com/openebs/zfs-localpv/pkg/zfs"
+	"k8s.io/klog/v2"
+)
+
+// volumePaths are the paths a volume is published at, the value tells
+// whether it is a raw block path
+type volumePaths map[string]bool
+
+// volumeHealth periodically checks the volumes published on this node
+// and caches their condition, so NodeGetVolumeStats does not have to run
+// the checks on every call.
+type volumeHealth struct {
+	mtx        sync.RWMutex
+	volumes    map[string]volumePaths
+	conditions map[string]*csi.VolumeCondition
+
+	// check returns the condition of the volume, can be replaced in unit tests
+	check func(volID string, paths volumePaths) *csi.VolumeCondition
+}
+
+func newVolumeHealth() *volumeHealth {
+	return &volumeHealth{
+		volumes:    map[string]volumePaths{},
+		conditions: map[string]*csi.VolumeCondition{},
+		check:      checkVolume,
+	}
+}
+
+// track adds the published path of the volume to the self-check
+func (h *volumeHealth) track(volID, path string, block bool) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	if h.volumes[volID] == nil {
+		h.volumes[volID] = volumePaths{}
+	}
+	h.volumes[volID][path] = block
+}
+
+// untrack removes the path of the volume, the volume is not checked
+// anymore once it is not published at any path
+func (h *volumeHealth) untrack(volID, path string) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	delete(h.volumes[volID], path)
+	if len(h.volumes[volID]) == 0 {
+		delete(h.volumes, volID)
+		delete(h.conditions, volID)
+	}
+}
+
+// condition returns the cached condition of the volume, nil if the
+// volume has not been checked yet
+func (h *volumeHealth) condition(volID string) *csi.VolumeCondition {
+	h.mtx.RLock()
+	defer h.mtx.RUnlock()
+	return h.conditions[volID]
+}
+
+// checkAll runs the check for all the tracked volumes and updates the cache
+func (h *volumeHealth) checkAll() {
+	h.mtx.RLock()
+	volumes := make(map[string]volumePaths, len(h.volumes))
+	for volID, paths := range h.volumes {
+		cp := volumePaths{}
+		for path, block := range paths {
+			cp[path] = block
+		}
+		volumes[volID] = cp
+	}
+	h.mtx.RUnlock()
+
+	for volID, paths := range volumes {
+		cond := h.check(volID, paths)
+		if cond.Abnormal {
+			klog.Warningf("health: volume %s is abnormal: %s", volID, cond.Message)
+		}
+
+		h.mtx.Lock()
+		// the volume might have been unpublished while it was being checked
+		if _, ok := h.volumes[volID]; ok {
+			h.conditions[volID] = cond
+		}
+		h.mtx.Unlock()
+	}
+}
+
+// run checks the volumes at every interval until the stop channel is closed
+func (h *volumeHealth) run(interval time.Duration, stopCh <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+			h.checkAll()
+		}
+	}
+}
+
+func abnormal(format string, args ...interface{}) *csi.VolumeCondition {
+	return &csi.VolumeCondition{Abnormal: true, Message: fmt.Sprintf(format, args...)}
+}
+
+// checkVolume verifies the volume is present, its pool is healthy and
+// the volume is still mounted at all the published filesystem paths
+func checkVolume(volID string, paths volumePaths) *csi.VolumeCondition {
+	vol, err := zfs.GetZFSVolume(volID)
+	if err != nil {
+		return abnormal("failed to get the ZFSVolume: %v", err)
+	}
+	if _, err := zfs.ResolveVolumePool(nil, vol, false); err != nil {
+		return abnormal("failed to resolve the pool of the ZFSVolume: %v", err)
+	}
+
+	if err := zfs.VolumeExists(vol); err != nil {
+		return abnormal("%v", err)
+	}
+
+	health, err := zfs.GetPoolHealth(vol.Spec.PoolName)
+	if err != nil {
+		return abnormal("%v", err)
+	}
+	if health != zfs.PoolHealthOnline {
+		return abnormal("pool of %s is %s", vol.Spec.PoolName, health)
+	}
+
+	for path, block := range paths {
+		if !block && !mount.IsMountPath(path) {
+			return abnormal("volume is not mounted at %s", path)
+		}
+	}
+
+	return &csi.VolumeCondition{Abnormal: false, Message: "volume is healthy"}
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+func TestVolumeHealthCheckAll(t *testing.T) {
+	h := newVolumeHealth()
+	broken := map[string]bool{"pvc-2": true}
+	h.check = func(volID string, paths volumePaths) *csi.VolumeCondition {
+		if broken[volID] {
+			return abnormal("pool zfspv is DEGRADED")
+		}
+		return &csi.VolumeCondition{Message: "volume is healthy"}
+	}
+
+	h.track("pvc-1", "/mnt/pvc-1", false)
+	h.track("pvc-2", "/mnt/pvc-2", true)
+	assert.Nil(t, h.condition("pvc-1"), "volume should not have a condition before the check")
+
+	h.checkAll()
+	assert.False(t, h.condition("pvc-1").Abnormal)
+	assert.True(t, h.condition("pvc-2").Abnormal)
+	assert.Equal(t, "pool zfspv is DEGRADED", h.condition("pvc-2").Message)
+
+	// the cached condition is refreshed by the next check
+	delete(broken, "pvc-2")
+	h.checkAll()
+	assert.False(t, h.condition("pvc-2").Abnormal)
+
+	// the condition is dropped once the volume is not published anymore
+	h.untrack("pvc-1", "/mnt/pvc-1")
+	assert.Nil(t, h.condition("pvc-1"))
+}
+
+func TestNodeGetVolumeStatsCondition(t *testing.T) {
+	path := t.TempDir()
+	checks := 0
+	h := newVolumeHealth()
+	h.check = func(volID string, paths volumePaths) *csi.VolumeCondition {
+		checks++
+		return abnormal("volume is not mounted at %s", path)
+	}
+	ns := &node{health: h, mounter: &mounter{isMounted: func(string) bool { return true }}}
+	req := &csi.NodeGetVolumeStatsRequest{VolumeId: "pvc-1", VolumePath: path}
+
+	resp, err := ns.NodeGetVolumeStats(context.Background(), req)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, resp.Usage)
+	assert.Nil(t, resp.VolumeCondition)
+
+	h.checkAll()
+	resp, err = ns.NodeGetVolumeStats(context.Background(), req)
+	assert.NoError(t, err)
+	assert.True(t, resp.VolumeCondition.Abnormal)
+	assert.Equal(t, "volume is not mounted at "+path, resp.VolumeCondition.Message)
+	// the stats call only reads the cached condition
+	assert.Equal(t, 1, checks)
+}
@@ -0,0 +1,64 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"errors"
+
+	"github.com/openebs/zfs-localpv/pkg/zfs"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+// nodeAttempts are the preferred nodes of a volume which could not be
+// used because they are gone or unreachable
+type nodeAttempts struct {
+	gone        []string
+	unreachable []string
+}
+
+// record records the node if err says it is gone or unreachable
+func (a *nodeAttempts) record(node string, err error) {
+	switch {
+	case errors.Is(err, zfs.ErrNodeGone):
+		a.gone = append(a.gone, node)
+	case errors.Is(err, zfs.ErrNodeUnreachable):
+		a.unreachable = append(a.unreachable, node)
+	default:
+		return
+	}
+	klog.Warningf("zfs: skipping node %s for the volume: %v", node, err)
+}
+
+// provisioningError is the error of a volume which could not be created on
+// any of the nodes, err being the last error. The external provisioner
+// reschedules the claim on ResourceExhausted, which is returned when all
+// the nodes are gone. The creation is retried on the same nodes with
+// Unavailable if some of them are only unreachable.
+func (a *nodeAttempts) provisioningError(prfList []string, err error) error {
+	switch {
+	case len(a.gone) == len(prfList):
+		return status.Errorf(codes.ResourceExhausted,
+			"not able to provision the volume, nodes %v are gone, err : %s", a.gone, err.Error())
+	case len(a.unreachable) > 0:
+		return status.Errorf(codes.Unavailable,
+			"not able to provision the volume, nodes %v are unreachable, err : %s", a.unreachable, err.Error())
+	}
+	return status.Errorf(codes.Internal,
+		"not able to provision the volume, nodes %v, err : %s", prfList, err.Error())
+}
@@ -0,0 +1,61 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"strconv"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+// checkPoolCopy returns an error if the volume can not be copied to the
+// pool of its node. The pool must be on the node of the volume and have
+// the free space for the capacity of a thick volume, the space of a thin
+// copy is the data of the source, which is checked by the node agent. The
+// checks are skipped if the ZFSNode can not be read.
+func (cs *controller) checkPoolCopy(vol *apis.ZFSVolume, pool string) error {
+	if vol.Spec.Encryption != "" && vol.Spec.Encryption != "off" {
+		return status.Errorf(codes.InvalidArgument,
+			"clone: volume %s is encrypted, it can not be copied to pool %s", vol.Name, pool)
+	}
+	node, err := ownerNode(cs, vol.Spec.OwnerNodeID)
+	if err != nil {
+		klog.Warningf("could not check pool %s for the copy of %s: %v", pool, vol.Name, err)
+		return nil
+	}
+	p := findPool(node, pool)
+	if p == nil {
+		return status.Errorf(codes.InvalidArgument,
+			"clone: pool %s is not on node %s of volume %s", pool, vol.Spec.OwnerNodeID, vol.Name)
+	}
+	if isThinVolume(vol) {
+		return nil
+	}
+	size, err := strconv.ParseInt(vol.Spec.Capacity, 10, 64)
+	if err != nil {
+		return status.Errorf(codes.Internal, "clone: invalid capacity %q of volume %s", vol.Spec.Capacity, vol.Name)
+	}
+	if free := p.Free.Value(); size > free {
+		return status.Errorf(codes.ResourceExhausted,
+			"clone: not enough free space in pool %s on node %s to copy %s: %s free, %s needed",
+			pool, vol.Spec.OwnerNodeID, vol.Name, quantity(free), quantity(size))
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"testing"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"github.com/openebs/zfs-localpv/pkg/zfs"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// copyController returns the controller whose node-1 reports an hdd pool
+// with 500Gi free and an nvme pool with 20Gi free
+func copyController(t *testing.T) *controller {
+	node := &apis.ZFSNode{}
+	node.Name = "node-1"
+	node.Pools = []apis.Pool{
+		{Name: "hdd", Free: resource.MustParse("500Gi")},
+		{Name: "nvme", Free: resource.MustParse("20Gi")},
+	}
+	return &controller{zfsNodeInformer: zfsNodeInformer(t, node)}
+}
+
+func copySource(vtype, thin, capacity string) *apis.ZFSVolume {
+	vol := &apis.ZFSVolume{}
+	vol.Name = "pvc-src"
+	vol.Spec.PoolName = "hdd/volumes"
+	vol.Spec.OwnerNodeID = "node-1"
+	vol.Spec.VolumeType = vtype
+	vol.Spec.ThinProvision = thin
+	vol.Spec.Capacity = capacity
+	return vol
+}
+
+func TestCheckPoolCopy(t *testing.T) {
+	cs := copyController(t)
+
+	// a thin copy is checked on the node against the data of the source
+	assert.NoError(t, cs.checkPoolCopy(copySource(zfs.VolTypeDataset, "", "107374182400"), "nvme"))
+	assert.NoError(t, cs.checkPoolCopy(copySource(zfs.VolTypeZVol, "no", "10737418240"), "nvme/volumes"))
+
+	err := cs.checkPoolCopy(copySource(zfs.VolTypeZVol, "", "107374182400"), "nvme")
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	err = cs.checkPoolCopy(copySource(zfs.VolTypeDataset, "", "1073741824"), "ssd")
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	encrypted := copySource(zfs.VolTypeDataset, "", "1073741824")
+	encrypted.Spec.Encryption = "on"
+	err = cs.checkPoolCopy(encrypted, "nvme")
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestCheckPoolCopyWithoutNode(t *testing.T) {
+	cs := &controller{zfsNodeInformer: zfsNodeInformer(t)}
+	assert.NoError(t, cs.checkPoolCopy(copySource(zfs.VolTypeZVol, "", "107374182400"), "nvme"))
+}
@@ -0,0 +1,159 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	clientset "github.com/openebs/zfs-localpv/pkg/generated/clientset/internalclientset"
+	"github.com/openebs/zfs-localpv/pkg/zfs"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+)
+
+// what the controller does when the health of a pool degrades, e.g. the
+// pool is DEGRADED or FAULTED, each policy does what the previous one does
+const (
+	// PoolDegradedReport only raises an event on the ZFSNode when the
+	// health of the pool changes, the health is reported in the ZFSNode by
+	// the node agent
+	PoolDegradedReport = "report"
+	// PoolDegradedMark also sets the PoolDegraded condition of the
+	// volumes of the pool
+	PoolDegradedMark = "mark"
+	// PoolDegradedCordon also stops placing the new volumes on the pool
+	PoolDegradedCordon = "cordon"
+)
+
+// poolHealthCheckInterval is the interval at which the controller checks
+// the health of the pools in the ZFSNodes
+const poolHealthCheckInterval = 30 * time.Second
+
+// cordonDegradedPools tells whether the scheduler drops the pools which
+// are degraded, it is set with the policy
+var cordonDegradedPools bool
+
+// setPoolDegradedPolicy validates the policy of the pools whose health
+// degrades and cordons them if asked
+func setPoolDegradedPolicy(policy string) (string, error) {
+	switch policy {
+	case PoolDegradedReport, PoolDegradedMark, PoolDegradedCordon:
+		cordonDegradedPools = policy == PoolDegradedCordon
+		return policy, nil
+	}
+	return "", fmt.Errorf("invalid pool degraded policy %q, it should be %s, %s or %s",
+		policy, PoolDegradedReport, PoolDegradedMark, PoolDegradedCordon)
+}
+
+// checkPoolHealth checks the health of the pools reported in the
+// ZFSNodes, it is run periodically by the controller
+func (cs *controller) checkPoolHealth() {
+	var nodes []apis.ZFSNode
+	for _, obj := range cs.zfsNodeInformer.GetIndexer().List() {
+		if node, ok := obj.(*apis.ZFSNode); ok {
+			nodes = append(nodes, *node)
+		}
+	}
+	cs.poolHealth = checkPoolHealth(cs.openebsClient, cs.recorder, cs.poolDegraded, nodes, cs.poolHealth)
+}
+
+// checkPoolHealth raises an event on the ZFSNode of the pools whose health
+// has changed since the last check and, unless the policy is report,
+// reconciles the PoolDegraded condition of all the volumes with the health
+// of their pool, a volume which could not be updated is updated on the
+// next check. It returns the health of each pool by node/pool.
+func checkPoolHealth(openebs clientset.Interface, recorder record.EventRecorder, policy string,
+	nodes []apis.ZFSNode, last map[string]string) map[string]string {
+	health := map[string]string{}
+	for i := range nodes {
+		node := &nodes[i]
+		for _, p := range node.Status.Pools {
+			key := node.Name + "/" + p.Name
+			health[key] = p.Health
+			old, known := last[key]
+			if known && old == p.Health {
+				continue
+			}
+			if zfs.PoolDegraded(p.Health) {
+				klog.Warningf("pool %s on node %s is %s, its volumes are at risk", p.Name, node.Name, p.Health)
+				recorder.Eventf(node, corev1.EventTypeWarning, zfs.ConditionPoolDegraded,
+					"pool %s is %s, its volumes are at risk", p.Name, p.Health)
+			} else if zfs.PoolDegraded(old) {
+				klog.Infof("pool %s on node %s is %s again", p.Name, node.Name, p.Health)
+				recorder.Eventf(node, corev1.EventTypeNormal, "PoolRecovered", "pool %s is %s again", p.Name, p.Health)
+			}
+		}
+	}
+	if policy == PoolDegradedReport {
+		return health
+	}
+
+	volumes := openebs.ZfsV1().ZFSVolumes(zfs.OpenEBSNamespace)
+	vols, err := volumes.List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		klog.Errorf("could not list the volumes of the degraded pools: %v", err)
+		return health
+	}
+	byName := map[string]*apis.ZFSNode{}
+	for i := range nodes {
+		byName[nodes[i].Name] = &nodes[i]
+	}
+	for i := range vols.Items {
+		vol := &vols.Items[i]
+		node, ok := byName[vol.Spec.OwnerNodeID]
+		if !ok || vol.DeletionTimestamp != nil {
+			continue
+		}
+		zpool, poolHealth := zfs.VolumePoolHealth(node, vol)
+		if !zfs.SetPoolDegradedCondition(vol, zpool, poolHealth) {
+			continue
+		}
+		if _, err = volumes.Update(context.TODO(), vol, metav1.UpdateOptions{}); err != nil {
+			klog.Errorf("could not set the %s condition of volume %s: %v", zfs.ConditionPoolDegraded, vol.Name, err)
+		}
+	}
+	return health
+}
+
+// poolHealth drops the pools which are degraded, the pools whose health is
+// not known are kept
+type poolHealth struct{}
+
+func (poolHealth) Filter(_ *csi.CreateVolumeRequest, c Candidate) bool {
+	if zfs.PoolDegraded(c.Health) {
+		klog.Infof("scheduler: pool %s on node %s is %s", c.Pool, c.Node, c.Health)
+		return false
+	}
+	return true
+}
+
+func (poolHealth) Score(*csi.CreateVolumeRequest, Candidate) int64 { return 0 }
+
+// requiredPoolHealth returns the filter on the health of the pools, it is
+// nil if the degraded pools are not cordoned
+func requiredPoolHealth() *poolHealth {
+	if !cordonDegradedPools {
+		return nil
+	}
+	return &poolHealth{}
+}
@@ -0,0 +1,182 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"github.com/openebs/zfs-localpv/pkg/generated/clientset/internalclientset/fake"
+	"github.com/openebs/zfs-localpv/pkg/zfs"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+)
+
+// degradedUpdates returns the volumes updated since the last call
+func degradedUpdates(openebs *fake.Clientset) []string {
+	var updated []string
+	for _, action := range openebs.Actions() {
+		if update, ok := action.(k8stesting.UpdateAction); ok {
+			updated = append(updated, update.GetObject().(*apis.ZFSVolume).Name)
+		}
+	}
+	openebs.ClearActions()
+	return updated
+}
+
+// degradedCondition returns the PoolDegraded condition of the volume
+func degradedCondition(t *testing.T, openebs *fake.Clientset, name string) *metav1.Condition {
+	vol, err := openebs.ZfsV1().ZFSVolumes(zfs.OpenEBSNamespace).Get(context.TODO(), name, metav1.GetOptions{})
+	assert.NoError(t, err)
+	return meta.FindStatusCondition(vol.Status.Conditions, zfs.ConditionPoolDegraded)
+}
+
+func degradedVolume(name, node, pool string) *apis.ZFSVolume {
+	vol := &apis.ZFSVolume{}
+	vol.Namespace = zfs.OpenEBSNamespace
+	vol.Name = name
+	vol.Spec.OwnerNodeID = node
+	vol.Spec.PoolName = pool
+	return vol
+}
+
+func healthNodes(health map[string]string) []apis.ZFSNode {
+	var nodes []apis.ZFSNode
+	for _, name := range []string{"node1", "node2"} {
+		node := apis.ZFSNode{}
+		node.Name = name
+		node.Status.Pools = []apis.PoolSummary{{Name: "zfspv", Health: health[name]}}
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+func TestCheckPoolHealthMark(t *testing.T) {
+	openebs := fake.NewSimpleClientset(
+		degradedVolume("pvc-1", "node1", "zfspv"),
+		degradedVolume("pvc-2", "node1", "zfspv/child"),
+		degradedVolume("pvc-3", "node2", "zfspv"),
+	)
+	recorder := record.NewFakeRecorder(10)
+
+	// the pools are healthy, nothing to mark
+	last := checkPoolHealth(openebs, recorder, PoolDegradedMark, healthNodes(map[string]string{"node1": "ONLINE", "node2": "ONLINE"}), nil)
+	assert.Empty(t, degradedUpdates(openebs))
+
+	// the pool of node1 degrades
+	degraded := healthNodes(map[string]string{"node1": "DEGRADED", "node2": "ONLINE"})
+	last = checkPoolHealth(openebs, recorder, PoolDegradedMark, degraded, last)
+	assert.Equal(t, []string{"pvc-1", "pvc-2"}, degradedUpdates(openebs))
+	cond := degradedCondition(t, openebs, "pvc-1")
+	if assert.NotNil(t, cond) {
+		assert.Equal(t, metav1.ConditionTrue, cond.Status)
+	}
+	assert.Nil(t, degradedCondition(t, openebs, "pvc-3"))
+
+	// no change of the health, a volume created on the degraded pool since
+	// the last check is marked all the same
+	_, err := openebs.ZfsV1().ZFSVolumes(zfs.OpenEBSNamespace).
+		Create(context.TODO(), degradedVolume("pvc-4", "node1", "zfspv"), metav1.CreateOptions{})
+	assert.NoError(t, err)
+	openebs.ClearActions()
+	last = checkPoolHealth(openebs, recorder, PoolDegradedMark, degraded, last)
+	assert.Equal(t, []string{"pvc-4"}, degradedUpdates(openebs))
+
+	// the pool recovers, the condition is cleared
+	checkPoolHealth(openebs, recorder, PoolDegradedMark, healthNodes(map[string]string{"node1": "ONLINE", "node2": "ONLINE"}), last)
+	assert.Equal(t, []string{"pvc-1", "pvc-2", "pvc-4"}, degradedUpdates(openebs))
+	cond = degradedCondition(t, openebs, "pvc-1")
+	if assert.NotNil(t, cond) {
+		assert.Equal(t, metav1.ConditionFalse, cond.Status)
+	}
+}
+
+func TestCheckPoolHealthRetry(t *testing.T) {
+	openebs := fake.NewSimpleClientset(degradedVolume("pvc-1", "node1", "zfspv"))
+	conflict := true
+	openebs.PrependReactor("update", "zfsvolumes", func(k8stesting.Action) (bool, runtime.Object, error) {
+		if conflict {
+			return true, nil, errors.New("conflict")
+		}
+		return false, nil, nil
+	})
+
+	recorder := record.NewFakeRecorder(10)
+	degraded := healthNodes(map[string]string{"node1": "FAULTED"})
+	last := checkPoolHealth(openebs, recorder, PoolDegradedMark, degraded, nil)
+	assert.Nil(t, degradedCondition(t, openebs, "pvc-1"))
+
+	// the volume is updated on the next check, the health is unchanged
+	conflict = false
+	checkPoolHealth(openebs, recorder, PoolDegradedMark, degraded, last)
+	cond := degradedCondition(t, openebs, "pvc-1")
+	if assert.NotNil(t, cond) {
+		assert.Equal(t, metav1.ConditionTrue, cond.Status)
+	}
+}
+
+func TestCheckPoolHealthReport(t *testing.T) {
+	openebs := fake.NewSimpleClientset(degradedVolume("pvc-1", "node1", "zfspv"))
+
+	recorder := record.NewFakeRecorder(10)
+	degraded := healthNodes(map[string]string{"node1": "DEGRADED"})
+	last := checkPoolHealth(openebs, recorder, PoolDegradedReport, degraded, nil)
+	assert.Equal(t, map[string]string{"node1/zfspv": "DEGRADED", "node2/zfspv": ""}, last)
+	// the volumes are not even listed
+	assert.Empty(t, openebs.Actions())
+	assert.Equal(t, "Warning PoolDegraded pool zfspv is DEGRADED, its volumes are at risk", <-recorder.Events)
+
+	// the event is only raised on a change of the health
+	last = checkPoolHealth(openebs, recorder, PoolDegradedReport, degraded, last)
+	assert.Empty(t, recorder.Events)
+	checkPoolHealth(openebs, recorder, PoolDegradedReport, healthNodes(map[string]string{"node1": "ONLINE"}), last)
+	assert.Equal(t, "Normal PoolRecovered pool zfspv is ONLINE again", <-recorder.Events)
+}
+
+func TestSetPoolDegradedPolicy(t *testing.T) {
+	t.Cleanup(func() { cordonDegradedPools = false })
+	for _, policy := range []string{PoolDegradedReport, PoolDegradedMark, PoolDegradedCordon} {
+		got, err := setPoolDegradedPolicy(policy)
+		assert.NoError(t, err)
+		assert.Equal(t, policy, got)
+		assert.Equal(t, policy == PoolDegradedCordon, cordonDegradedPools)
+		assert.Equal(t, policy == PoolDegradedCordon, requiredPoolHealth() != nil)
+	}
+	_, err := setPoolDegradedPolicy("evacuate")
+	assert.Error(t, err)
+}
+
+func TestPoolHealthFilter(t *testing.T) {
+	var f poolHealth
+	assert.True(t, f.Filter(nil, Candidate{Node: "node1", Pool: "zfspv", Health: "ONLINE"}))
+	// the health is not known
+	assert.True(t, f.Filter(nil, Candidate{Node: "node1", Pool: "zfspv"}))
+	c := Candidate{Node: "node1", Pool: "zfspv", Health: "DEGRADED"}
+	assert.False(t, f.Filter(nil, c))
+	assert.Equal(t, "pool zfspv is DEGRADED", f.exclusion(c))
+
+	nodes := healthNodes(map[string]string{"node1": "DEGRADED", "node2": "ONLINE"})
+	cmap := buildCandidates("zfspv/child", nil, nodes)
+	assert.Equal(t, "DEGRADED", cmap["node1"].Health)
+	assert.Equal(t, "ONLINE", cmap["node2"].Health)
+}
@@ -0,0 +1,247 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"github.com/openebs/zfs-localpv/pkg/zfs"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	k8serror "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/klog/v2"
+)
+
+// systemDomains are the domains of the label and annotation keys owned by
+// kubernetes or by the driver, the keys in them and in their subdomains
+// are never propagated
+var systemDomains = []string{"kubernetes.io", "k8s.io", "openebs.io"}
+
+// provisionerKeys are the pvc annotations naming the provisioner of the
+// volume
+var provisionerKeys = []string{
+	"volume.kubernetes.io/storage-provisioner",
+	"volume.beta.kubernetes.io/storage-provisioner",
+}
+
+// systemKey tells whether the label or annotation key is in one of the
+// system domains
+func systemKey(key string) bool {
+	i := strings.Index(key, "/")
+	if i < 0 {
+		return false
+	}
+	domain := key[:i]
+	for _, d := range systemDomains {
+		if domain == d || strings.HasSuffix(domain, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePropagatePrefixes validates the prefixes of the pvc labels and
+// annotations to be propagated to the ZFSVolume, e.g. team or
+// example.com/. The prefixes of the system domains are refused.
+func parsePropagatePrefixes(prefixes []string) ([]string, error) {
+	var out []string
+	for _, p := range prefixes {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if systemKey(p) {
+			return nil, fmt.Errorf("invalid propagate prefix %q, the keys of %s are not propagated",
+				p, strings.Join(systemDomains, ", "))
+		}
+		out = append(out, p)
+	}
+	return out, nil
+}
+
+// propagatedKey tells whether the key is to be propagated as per the
+// prefixes
+func propagatedKey(prefixes []string, key string) bool {
+	if systemKey(key) {
+		return false
+	}
+	for _, p := range prefixes {
+		if strings.HasPrefix(key, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// propagated returns the entries of the pvc labels or annotations to be
+// propagated. The keys and, for the labels, the values which are not valid
+// are skipped.
+func propagated(prefixes []string, m map[string]string, label bool) map[string]string {
+	out := map[string]string{}
+	for k, v := range m {
+		if !propagatedKey(prefixes, k) {
+			continue
+		}
+		if errs := validation.IsQualifiedName(k); len(errs) != 0 {
+			klog.Warningf("not propagating %s of the pvc: %s", k, strings.Join(errs, ", "))
+			continue
+		}
+		if label {
+			if errs := validation.IsValidLabelValue(v); len(errs) != 0 {
+				klog.Warningf("not propagating label %s=%s of the pvc: %s", k, v, strings.Join(errs, ", "))
+				continue
+			}
+		}
+		out[k] = v
+	}
+	return out
+}
+
+// syncPropagated mirrors the entries of src to be propagated in dst, the
+// entries of dst matching the prefixes and no longer in src are removed.
+// It returns the updated dst and whether it has changed.
+func syncPropagated(prefixes []string, src, dst map[string]string, label bool) (map[string]string, bool) {
+	want := propagated(prefixes, src, label)
+	changed := false
+	for k := range dst {
+		if _, ok := want[k]; !ok && propagatedKey(prefixes, k) {
+			delete(dst, k)
+			changed = true
+		}
+	}
+	for k, v := range want {
+		if old, ok := dst[k]; ok && old == v {
+			continue
+		}
+		if dst == nil {
+			dst = map[string]string{}
+		}
+		dst[k] = v
+		changed = true
+	}
+	return dst, changed
+}
+
+// propagateMetadata mirrors the labels and the annotations of the pvc
+// matching the prefixes on the volume, it returns true if the volume has
+// changed
+func propagateMetadata(prefixes []string, pvc *corev1.PersistentVolumeClaim, vol *apis.ZFSVolume) bool {
+	var lc, ac bool
+	vol.Labels, lc = syncPropagated(prefixes, pvc.Labels, vol.Labels, true)
+	vol.Annotations, ac = syncPropagated(prefixes, pvc.Annotations, vol.Annotations, false)
+	return lc || ac
+}
+
+// syncVolumeMetadata propagates the labels and the annotations of the pvc
+// to its ZFSVolume, the volumes of the other drivers are not found
+func (cs *controller) syncVolumeMetadata(pvc *corev1.PersistentVolumeClaim, volName string) error {
+	vols := cs.openebsClient.ZfsV1().ZFSVolumes(zfs.OpenEBSNamespace)
+	vol, err := vols.Get(context.TODO(), volName, metav1.GetOptions{})
+	if err != nil {
+		if k8serror.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if !propagateMetadata(cs.propagate, pvc, vol) {
+		return nil
+	}
+	_, err = vols.Update(context.TODO(), vol, metav1.UpdateOptions{})
+	return err
+}
+
+// propagateFromPVC propagates the labels and the annotations of the pvc
+// to the volume just created. The pvc is known only if the provisioner
+// passes its name, with --extra-create-metadata.
+func (cs *controller) propagateFromPVC(params map[string]string, volName string) error {
+	if len(cs.propagate) == 0 {
+		return nil
+	}
+	ns, name := params["csi.storage.k8s.io/pvc/namespace"], params["csi.storage.k8s.io/pvc/name"]
+	if ns == "" || name == "" {
+		klog.Warningf("not propagating the pvc labels to %s, enable --extra-create-metadata on the provisioner", volName)
+		return nil
+	}
+	pvc, err := cs.kubeClient.CoreV1().PersistentVolumeClaims(ns).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to get pvc %s/%s: %v", ns, name, err)
+	}
+	if err = cs.syncVolumeMetadata(pvc, volName); err != nil {
+		return status.Errorf(codes.Internal, "failed to propagate the labels of pvc %s/%s to %s: %v",
+			ns, name, volName, err)
+	}
+	return nil
+}
+
+// ownPVC tells whether the pvc is bound to a volume provisioned by this
+// driver
+func (cs *controller) ownPVC(pvc *corev1.PersistentVolumeClaim) bool {
+	if pvc.Spec.VolumeName == "" || pvc.DeletionTimestamp != nil {
+		return false
+	}
+	for _, key := range provisionerKeys {
+		if pvc.Annotations[key] == cs.driver.config.DriverName {
+			return true
+		}
+	}
+	return false
+}
+
+// addPVC is the add event handler for the pvcs, the changes made while
+// the controller was down are propagated when it starts and the pending
+// dev clone requests are handled
+func (cs *controller) addPVC(obj interface{}) {
+	pvc, ok := obj.(*corev1.PersistentVolumeClaim)
+	if !ok || !cs.ownPVC(pvc) {
+		return
+	}
+	cs.requestDevClone(pvc)
+	if len(cs.propagate) == 0 {
+		return
+	}
+	if err := cs.syncVolumeMetadata(pvc, pvc.Spec.VolumeName); err != nil {
+		klog.Errorf("could not propagate the labels of pvc %s/%s: %v", pvc.Namespace, pvc.Name, err)
+	}
+}
+
+// updatePVC is the update event handler for the pvcs, it propagates the
+// changes of their labels and annotations and handles the dev clone
+// requests
+func (cs *controller) updatePVC(oldObj, newObj interface{}) {
+	pvc, ok := newObj.(*corev1.PersistentVolumeClaim)
+	if !ok || !cs.ownPVC(pvc) {
+		return
+	}
+	cs.requestDevClone(pvc)
+	if len(cs.propagate) == 0 {
+		return
+	}
+	if old, ok := oldObj.(*corev1.PersistentVolumeClaim); ok && old.Spec.VolumeName == pvc.Spec.VolumeName &&
+		reflect.DeepEqual(old.Labels, pvc.Labels) && reflect.DeepEqual(old.Annotations, pvc.Annotations) {
+		return
+	}
+	if err := cs.syncVolumeMetadata(pvc, pvc.Spec.VolumeName); err != nil {
+		klog.Errorf("could not propagate the labels of pvc %s/%s: %v", pvc.Namespace, pvc.Name, err)
+	}
+}
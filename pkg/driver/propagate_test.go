@@ -0,0 +1,196 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"github.com/openebs/zfs-localpv/pkg/config"
+	openebsfake "github.com/openebs/zfs-localpv/pkg/generated/clientset/internalclientset/fake"
+	"github.com/openebs/zfs-localpv/pkg/zfs"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func propagatePVC(labels, annotations map[string]string) *corev1.PersistentVolumeClaim {
+	pvc := &corev1.PersistentVolumeClaim{}
+	pvc.Namespace, pvc.Name = "app", "data"
+	pvc.Labels = labels
+	pvc.Annotations = annotations
+	pvc.Spec.VolumeName = "pvc-1"
+	return pvc
+}
+
+// fakePropagation is the api server holding the ZFSVolume pvc-1 and the
+// pvcs
+type fakePropagation struct {
+	openebs *openebsfake.Clientset
+}
+
+// newFakePropagation returns the controller propagating the prefixes and
+// its api server
+func newFakePropagation(t *testing.T, vol *apis.ZFSVolume, prefixes []string, pvcs ...runtime.Object) (*controller, *fakePropagation) {
+	orig := zfs.OpenEBSNamespace
+	t.Cleanup(func() { zfs.OpenEBSNamespace = orig })
+	zfs.OpenEBSNamespace = "openebs"
+
+	vol.Namespace, vol.Name = zfs.OpenEBSNamespace, "pvc-1"
+	f := &fakePropagation{openebs: openebsfake.NewSimpleClientset(vol)}
+	cs := &controller{
+		driver:        &CSIDriver{config: &config.Config{DriverName: "zfs.csi.openebs.io"}},
+		kubeClient:    fake.NewSimpleClientset(pvcs...),
+		openebsClient: f.openebs,
+		propagate:     prefixes,
+	}
+	return cs, f
+}
+
+// volume returns the ZFSVolume as it is in the api server
+func (f *fakePropagation) volume(t *testing.T) *apis.ZFSVolume {
+	vol, err := f.openebs.ZfsV1().ZFSVolumes(zfs.OpenEBSNamespace).Get(context.TODO(), "pvc-1", metav1.GetOptions{})
+	assert.NoError(t, err)
+	return vol
+}
+
+// updates returns the number of updates of the ZFSVolumes
+func (f *fakePropagation) updates() int {
+	n := 0
+	for _, action := range f.openebs.Actions() {
+		if action.Matches("update", "zfsvolumes") {
+			n++
+		}
+	}
+	return n
+}
+
+func TestParsePropagatePrefixes(t *testing.T) {
+	prefixes, err := parsePropagatePrefixes([]string{"team", " example.com/ ", ""})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"team", "example.com/"}, prefixes)
+
+	for _, p := range []string{"kubernetes.io/", "pv.kubernetes.io/", "openebs.io/", "zfs.csi.openebs.io/x"} {
+		_, err := parsePropagatePrefixes([]string{p})
+		assert.Error(t, err, "parsePropagatePrefixes(%q)", p)
+	}
+}
+
+func TestPropagated(t *testing.T) {
+	prefixes := []string{"team", "cost-center", "example.com/"}
+	labels := map[string]string{
+		"team":                     "storage",
+		"cost-center":              "42",
+		"example.com/backup":       "daily",
+		"app":                      "db",
+		"example.com/invalid":      "not a label value",
+		"team.kubernetes.io/owner": "x",
+	}
+	assert.Equal(t, map[string]string{
+		"team":               "storage",
+		"cost-center":        "42",
+		"example.com/backup": "daily",
+	}, propagated(prefixes, labels, true))
+
+	annotations := map[string]string{
+		"example.com/notes":                        "not a label value",
+		"pv.kubernetes.io/bind-completed":          "yes",
+		"volume.kubernetes.io/storage-provisioner": "zfs.csi.openebs.io",
+		"example.com/bad key":                      "x",
+	}
+	assert.Equal(t, map[string]string{"example.com/notes": "not a label value"},
+		propagated(prefixes, annotations, false))
+
+	assert.Empty(t, propagated(nil, labels, true))
+}
+
+func TestPropagateFromPVC(t *testing.T) {
+	vol := &apis.ZFSVolume{}
+	vol.Labels = map[string]string{"kubernetes.io/nodename": "node-1"}
+	pvc := propagatePVC(map[string]string{"team": "storage", "app": "db"},
+		map[string]string{"example.com/backup-policy": "daily"})
+	cs, f := newFakePropagation(t, vol, []string{"team", "example.com/"}, pvc)
+
+	params := map[string]string{
+		"csi.storage.k8s.io/pvc/namespace": "app",
+		"csi.storage.k8s.io/pvc/name":      "data",
+	}
+	assert.NoError(t, cs.propagateFromPVC(params, "pvc-1"))
+	assert.Equal(t, map[string]string{"kubernetes.io/nodename": "node-1", "team": "storage"}, f.volume(t).Labels)
+	assert.Equal(t, map[string]string{"example.com/backup-policy": "daily"}, f.volume(t).Annotations)
+	assert.Equal(t, 1, f.updates())
+
+	// nothing to do without the pvc name or without prefixes
+	assert.NoError(t, cs.propagateFromPVC(map[string]string{}, "pvc-1"))
+	assert.NoError(t, (&controller{}).propagateFromPVC(params, "pvc-1"))
+	assert.Equal(t, 1, f.updates())
+
+	// the pvc may be gone
+	params["csi.storage.k8s.io/pvc/name"] = "gone"
+	assert.Error(t, cs.propagateFromPVC(params, "pvc-1"))
+}
+
+func TestUpdatePVC(t *testing.T) {
+	vol := &apis.ZFSVolume{}
+	vol.Labels = map[string]string{"kubernetes.io/nodename": "node-1", "team": "storage"}
+	vol.Annotations = map[string]string{"openebs.io/debug-dump": "true"}
+	cs, f := newFakePropagation(t, vol, []string{"team", "cost-center"})
+
+	provisioner := map[string]string{"volume.kubernetes.io/storage-provisioner": "zfs.csi.openebs.io"}
+	old := propagatePVC(map[string]string{"team": "storage"}, provisioner)
+
+	// a changed value is updated and a removed key is dropped
+	pvc := propagatePVC(map[string]string{"cost-center": "42", "app": "db"}, provisioner)
+	cs.updatePVC(old, pvc)
+	assert.Equal(t, 1, f.updates())
+	assert.Equal(t, map[string]string{"kubernetes.io/nodename": "node-1", "cost-center": "42"}, f.volume(t).Labels)
+	assert.Equal(t, map[string]string{"openebs.io/debug-dump": "true"}, f.volume(t).Annotations)
+
+	// the resyncs and the changes of the other keys are skipped
+	cs.updatePVC(pvc, pvc)
+	changed := pvc.DeepCopy()
+	changed.Labels["app"] = "web"
+	cs.updatePVC(pvc, changed)
+	assert.Equal(t, 1, f.updates())
+
+	// the pvcs of the other drivers and the unbound ones are skipped
+	other := propagatePVC(map[string]string{"team": "db"},
+		map[string]string{"volume.kubernetes.io/storage-provisioner": "other.csi.example.com"})
+	cs.updatePVC(old, other)
+	unbound := propagatePVC(map[string]string{"team": "db"}, provisioner)
+	unbound.Spec.VolumeName = ""
+	cs.updatePVC(old, unbound)
+	assert.Equal(t, 1, f.updates())
+
+	// the volume may have been created by another instance of the driver
+	gone := propagatePVC(map[string]string{"team": "db"}, provisioner)
+	gone.Spec.VolumeName = "pvc-2"
+	cs.addPVC(gone)
+	assert.Equal(t, 1, f.updates())
+
+	// the changes made while the controller was down
+	cs.addPVC(propagatePVC(map[string]string{"team": "db"}, provisioner))
+	assert.Equal(t, 2, f.updates())
+	assert.Equal(t, "db", f.volume(t).Labels["team"])
+	for k := range f.volume(t).Labels {
+		assert.False(t, strings.HasPrefix(k, "cost-center"), "label %s not removed", k)
+	}
+}
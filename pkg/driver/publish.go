@@ -0,0 +1,169 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/openebs/lib-csi/pkg/mount"
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"github.com/openebs/zfs-localpv/pkg/zfs"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// PublishFailureRollback unmounts the target path mounted by a publish
+	// which fails on a later step, so that the retry starts clean
+	PublishFailureRollback = "rollback"
+	// PublishFailureKeep leaves the target path mounted by a publish which
+	// fails on a later step, the retry goes on from the mount
+	PublishFailureKeep = "keep"
+)
+
+// mounter does the mount operations of the node agent on the volumes
+type mounter struct {
+	// failurePolicy is what is done with the mount of a publish which
+	// fails on a later step
+	failurePolicy string
+	// missingParent is what is done when the parent directory of the
+	// target path is missing
+	missingParent string
+	// fsGroupMode is how the fsGroup of the pods is applied
+	fsGroupMode string
+
+	isMounted       func(path string) bool
+	checkAccessType func(vol *apis.ZFSVolume, block bool) error
+	checkTarget     func(vol *apis.ZFSVolume, target string) error
+	acquireFence    func(vol *apis.ZFSVolume) error
+	mountBlock      func(vol *apis.ZFSVolume, mnt *zfs.MountInfo) error
+	mountFilesystem func(vol *apis.ZFSVolume, mnt *zfs.MountInfo) error
+	unmount         func(vol *apis.ZFSVolume, target string) error
+	rootPermissions func(vol *apis.ZFSVolume, mnt *zfs.MountInfo, group string) error
+	fsGroup         func(vol *apis.ZFSVolume, mnt *zfs.MountInfo, mode, group string) error
+}
+
+// newMounter returns the mounter doing what the failure policy says with
+// the mount of a failed publish, rollback or keep, what the missing parent
+// policy says with a target path whose parent is missing, and applying the
+// fsGroup as per the fsgroup mode
+func newMounter(failurePolicy, missingParent, fsGroupMode string) (*mounter, error) {
+	switch failurePolicy {
+	case PublishFailureRollback, PublishFailureKeep:
+	default:
+		return nil, fmt.Errorf("invalid publish failure policy %q, it should be %s or %s",
+			failurePolicy, PublishFailureRollback, PublishFailureKeep)
+	}
+	if err := zfs.ValidateMissingParentPolicy(missingParent); err != nil {
+		return nil, err
+	}
+	if err := zfs.ValidateFsGroupMode(fsGroupMode); err != nil {
+		return nil, err
+	}
+	return &mounter{
+		failurePolicy:   failurePolicy,
+		missingParent:   missingParent,
+		fsGroupMode:     fsGroupMode,
+		isMounted:       mount.IsMountPath,
+		checkAccessType: zfs.ValidateAccessType,
+		checkTarget:     zfs.CheckTargetMount,
+		acquireFence:    zfs.AcquireFence,
+		mountBlock:      zfs.MountBlock,
+		mountFilesystem: zfs.MountFilesystem,
+		unmount:         zfs.UmountVolume,
+		rootPermissions: zfs.ApplyRootPermissions,
+		fsGroup:         zfs.ApplyFsGroup,
+	}, nil
+}
+
+// accessType refuses to publish the volume as a raw block device if it
+// was provisioned as a filesystem and vice versa, before anything is done
+// on the device.
+func (m *mounter) accessType(vol *apis.ZFSVolume, vc *csi.VolumeCapability) error {
+	_, block := vc.GetAccessType().(*csi.VolumeCapability_Block)
+	if err := m.checkAccessType(vol, block); err != nil {
+		if errors.Is(err, zfs.ErrAccessTypeMismatch) {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+		return status.Error(codes.Internal, err.Error())
+	}
+	return nil
+}
+
+// publish mounts the volume on the target path as per the access type.
+// The root permissions of a filesystem are set once it is mounted, a
+// block volume has no root directory.
+func (m *mounter) publish(vol *apis.ZFSVolume, mountInfo *zfs.MountInfo, vc *csi.VolumeCapability) error {
+	var err error
+
+	// the target mounted by an earlier call is not mounted again, only
+	// the steps after the mount are run again
+	wasMounted := m.isMounted(mountInfo.MountPath)
+	// a target holding the mount of another volume is left as it is
+	if wasMounted {
+		if err = m.checkTarget(vol, mountInfo.MountPath); err != nil {
+			return err
+		}
+	} else if err = zfs.PrepareTargetParent(mountInfo.MountPath, m.missingParent); err != nil {
+		return err
+	}
+
+	switch vc.GetAccessType().(type) {
+	case *csi.VolumeCapability_Block:
+		// refuse to use the zvol if another node holds it
+		if err = m.acquireFence(vol); err != nil {
+			return status.Error(codes.FailedPrecondition, err.Error())
+		}
+		// attempt block mount operation on the requested path
+		if !wasMounted {
+			err = m.mountBlock(vol, mountInfo)
+		}
+	case *csi.VolumeCapability_Mount:
+		// attempt filesystem mount operation on the requested path, it
+		// is skipped if the target is already mounted
+		if err = m.mountFilesystem(vol, mountInfo); err == nil {
+			err = m.rootPermissions(vol, mountInfo, vc.GetMount().GetVolumeMountGroup())
+		}
+		// the fsGroup is only passed when the node plugin applies it
+		if err == nil {
+			err = m.fsGroup(vol, mountInfo, m.fsGroupMode, vc.GetMount().GetVolumeMountGroup())
+		}
+	}
+
+	if err != nil {
+		m.rollback(vol, mountInfo.MountPath, wasMounted, err)
+		return status.Error(codes.Internal, err.Error())
+	}
+	return nil
+}
+
+// rollback unmounts the target path mounted by this publish call, which
+// has failed on a later step. A target mounted before the call is left
+// alone, it belongs to an earlier publish which has succeeded or to a
+// failed one kept by the policy.
+func (m *mounter) rollback(vol *apis.ZFSVolume, target string, wasMounted bool, cause error) {
+	if wasMounted || m.failurePolicy != PublishFailureRollback || !m.isMounted(target) {
+		return
+	}
+	klog.Warningf("publish of %s at %s failed: %v, unmounting it", vol.Name, target, cause)
+	if err := m.unmount(vol, target); err != nil {
+		klog.Errorf("could not roll back the publish of %s at %s: %v", vol.Name, target, err)
+	}
+}
@@ -17,9 +17,23 @@ limitations under the License.
 package driver
 
 import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	k8sapi "github.com/openebs/lib-csi/pkg/client/k8s"
+	"github.com/openebs/lib-csi/pkg/common/helpers"
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"github.com/openebs/zfs-localpv/pkg/builder/nodebuilder"
 	"github.com/openebs/zfs-localpv/pkg/builder/volbuilder"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"strconv"
+	"k8s.io/klog/v2"
 
 	zfs "github.com/openebs/zfs-localpv/pkg/zfs"
 )
@@ -34,70 +48,520 @@ const (
 	CapacityWeighted = "CapacityWeighted"
 )
 
-// getVolumeWeightedMap goes through all the pools on all the nodes
-// and creates the node mapping of the volume for all the nodes.
-// It returns a map which has nodes as key and volumes present
-// on the nodes as corresponding value.
-func getVolumeWeightedMap(pool string) (map[string]int64, error) {
-	nmap := map[string]int64{}
+// Candidate is a node and pool entry considered for placing the volume
+type Candidate struct {
+	// Node is the name of the node
+	Node string
+	// Pool is the zfs pool the volume will be created in
+	Pool string
+	// Volumes is the number of volumes provisioned from the pool on the node
+	Volumes int64
+	// Capacity is the total capacity provisioned from the pool on the node
+	Capacity int64
+	// PerformanceClass is the performance class of the pool on the node
+	// as set in the ZFSNode, empty if none is set
+	PerformanceClass string
+	// Features are the feature flags of the pool as reported in the
+	// ZFSNode status, nil if they are not known
+	Features map[string]string
+	// Free is the free space of the pool in bytes as reported in the
+	// ZFSNode, nil if it is not known
+	Free *int64
+	// FsTypes are the filesystem tools installed on the node as reported
+	// in the ZFSNode status, nil if they are not known
+	FsTypes []apis.FsTypeTools
+	// Datasets is the number of datasets of the pool as reported in the
+	// ZFSNode status, 0 if it is not known
+	Datasets int64
+	// Health is the health of the pool as reported in the ZFSNode status,
+	// empty if it is not known
+	Health string
+}
 
-	zvlist, err := volbuilder.NewKubeclient().
-		WithNamespace(zfs.OpenEBSNamespace).
-		List(metav1.ListOptions{})
+// Scheduler is a placement strategy. Filter drops the candidates which
+// can not hold the volume and Score ranks the remaining ones, the
+// candidate with the lowest score is tried first.
+type Scheduler interface {
+	Filter(req *csi.CreateVolumeRequest, c Candidate) bool
+	Score(req *csi.CreateVolumeRequest, c Candidate) int64
+}
 
-	if err != nil {
-		return nmap, err
+// volumeWeighted prefers the node having less volumes
+type volumeWeighted struct{}
+
+func (volumeWeighted) Filter(*csi.CreateVolumeRequest, Candidate) bool { return true }
+
+func (volumeWeighted) Score(_ *csi.CreateVolumeRequest, c Candidate) int64 { return c.Volumes }
+
+// capacityWeighted prefers the node having less provisioned capacity
+type capacityWeighted struct{}
+
+func (capacityWeighted) Filter(*csi.CreateVolumeRequest, Candidate) bool { return true }
+
+func (capacityWeighted) Score(_ *csi.CreateVolumeRequest, c Candidate) int64 { return c.Capacity }
+
+// composite combines schedulers, a candidate has to pass all the
+// filters and the scores are added up.
+type composite []Scheduler
+
+func (s composite) Filter(req *csi.CreateVolumeRequest, c Candidate) bool {
+	for _, sc := range s {
+		if !sc.Filter(req, c) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s composite) Score(req *csi.CreateVolumeRequest, c Candidate) int64 {
+	var score int64
+	for _, sc := range s {
+		score += sc.Score(req, c)
+	}
+	return score
+}
+
+// Compose returns a scheduler which applies all the given schedulers
+func Compose(schedulers ...Scheduler) Scheduler {
+	return composite(schedulers)
+}
+
+// performance class policies
+const (
+	// PerformanceClassRequired only places the volume on the pools of the
+	// asked performance class
+	PerformanceClassRequired = "required"
+
+	// PerformanceClassPreferred places the volume on the pools of the
+	// asked performance class first, this is the default
+	PerformanceClassPreferred = "preferred"
+
+	// defaultClassPenalty is added to the score of the pools of another
+	// class when no weight is given, it outweighs any capacity
+	defaultClassPenalty = int64(1) << 62
+)
+
+// performanceClass prefers or requires the pools of the performance class.
+// A preferred class adds the penalty to the score of the other pools, so
+// it is weighed against the score of the main scheduler, e.g. a penalty of
+// 100Gi with CapacityWeighted treats the other pools as if 100Gi more was
+// provisioned from them.
+type performanceClass struct {
+	class    string
+	required bool
+	penalty  int64
+}
+
+func (p performanceClass) Filter(_ *csi.CreateVolumeRequest, c Candidate) bool {
+	return !p.required || c.PerformanceClass == p.class
+}
+
+func (p performanceClass) Score(_ *csi.CreateVolumeRequest, c Candidate) int64 {
+	if c.PerformanceClass == p.class {
+		return 0
+	}
+	return p.penalty
+}
+
+// parsePerformanceClass returns the performance class scheduler as per the
+// performanceclass, performanceclasspolicy and performanceclassweight
+// parameters, it is nil if no class is asked for
+func parsePerformanceClass(params map[string]string) (*performanceClass, error) {
+	class := params["performanceclass"]
+	policy := params["performanceclasspolicy"]
+	weight := params["performanceclassweight"]
+	if class == "" {
+		if policy != "" || weight != "" {
+			return nil, fmt.Errorf("performanceclasspolicy and performanceclassweight need a performanceclass")
+		}
+		return nil, nil
 	}
 
-	// create the map of the volume count
-	// for the given pool
-	for _, zv := range zvlist.Items {
-		if zv.Spec.PoolName == pool {
-			nmap[zv.Spec.OwnerNodeID]++
+	p := &performanceClass{class: class, penalty: defaultClassPenalty}
+	switch policy {
+	case "", PerformanceClassPreferred:
+	case PerformanceClassRequired:
+		p.required = true
+	default:
+		return nil, fmt.Errorf("invalid performanceclasspolicy %q, it should be %s or %s",
+			policy, PerformanceClassRequired, PerformanceClassPreferred)
+	}
+	if weight != "" {
+		qty, err := resource.ParseQuantity(weight)
+		if err != nil || qty.Sign() < 0 {
+			return nil, fmt.Errorf("invalid performanceclassweight %q", weight)
 		}
+		p.penalty = qty.Value()
 	}
+	return p, nil
+}
 
-	return nmap, nil
+// poolFeatures only keeps the pools having all the feature flags enabled.
+// The pools whose features are not known, e.g. reported by an older node
+// agent, are kept and the creation fails on the node if one is missing.
+type poolFeatures []string
+
+func (p poolFeatures) Filter(_ *csi.CreateVolumeRequest, c Candidate) bool {
+	if c.Features == nil {
+		return true
+	}
+	if missing := zfs.MissingPoolFeatures(c.Features, p); len(missing) > 0 {
+		klog.Infof("scheduler: pool %s on node %s lacks the features %v", c.Pool, c.Node, missing)
+		return false
+	}
+	return true
 }
 
-// getCapacityWeightedMap goes through all the pools on all the nodes
-// and creates the node mapping of the capacity for all the nodes.
-// It returns a map which has nodes as key and capacity provisioned
-// on the nodes as corresponding value. The scheduler will use this map
-// and picks the node which is less weighted.
-func getCapacityWeightedMap(pool string) (map[string]int64, error) {
-	nmap := map[string]int64{}
+func (poolFeatures) Score(*csi.CreateVolumeRequest, Candidate) int64 { return 0 }
+
+// requiredPoolFeatures returns the feature flags the pool needs for the
+// storageclass parameters along with the ones listed in the poolfeatures
+// parameter
+func requiredPoolFeatures(params map[string]string) poolFeatures {
+	var features poolFeatures
+	if encr := params["encryption"]; (encr != "" && encr != "off") || params["keymode"] == zfs.KeyModePerVolume {
+		features = append(features, "encryption")
+	}
+	if strings.HasPrefix(params["compression"], "zstd") {
+		features = append(features, "zstd_compress")
+	}
+	for _, f := range strings.Split(params["poolfeatures"], ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			features = append(features, f)
+		}
+	}
+	return features
+}
+
+// fsTypeTools only keeps the nodes having the mkfs of the filesystem the
+// zvol is formatted with. The nodes whose tools are not known, e.g. with an
+// older node agent, are kept and the creation fails on the node if mkfs is
+// missing.
+type fsTypeTools string
+
+func (f fsTypeTools) Filter(_ *csi.CreateVolumeRequest, c Candidate) bool {
+	if !zfs.CanFormat(c.FsTypes, string(f)) {
+		klog.Infof("scheduler: node %s lacks mkfs.%s", c.Node, f)
+		return false
+	}
+	return true
+}
+
+func (fsTypeTools) Score(*csi.CreateVolumeRequest, Candidate) int64 { return 0 }
+
+// unschedulable returns the error telling that none of the eligible nodes
+// can format the zvol
+func (f fsTypeTools) unschedulable(eligible []string) error {
+	return status.Errorf(codes.ResourceExhausted,
+		"no node supports fsType %s: mkfs.%s is not installed on any of the %d eligible nodes",
+		string(f), string(f), len(eligible))
+}
+
+// requiredFsTypeTools returns the filter on the tools of the filesystem,
+// it is nil if the volume is not formatted by the driver. A mounted zvol
+// without fstype is formatted with the default one.
+func requiredFsTypeTools(req *csi.CreateVolumeRequest, fstype string) *fsTypeTools {
+	if fstype == zfs.FSTypeZFS {
+		return nil
+	}
+	if fstype == "" {
+		for _, volcap := range req.GetVolumeCapabilities() {
+			if volcap.GetBlock() != nil {
+				return nil
+			}
+		}
+		fstype = zfs.DefaultFsType
+	}
+	f := fsTypeTools(fstype)
+	return &f
+}
+
+var (
+	schedulersMtx sync.RWMutex
+	schedulers    = map[string]Scheduler{
+		VolumeWeighted:   volumeWeighted{},
+		CapacityWeighted: capacityWeighted{},
+	}
+)
+
+// RegisterScheduler makes the scheduler available to the storageclasses
+// under the given name via the "scheduler" parameter
+func RegisterScheduler(name string, s Scheduler) {
+	schedulersMtx.Lock()
+	defer schedulersMtx.Unlock()
+	schedulers[name] = s
+}
+
+// getScheduler returns the scheduler for the given name
+func getScheduler(name string) Scheduler {
+	schedulersMtx.RLock()
+	defer schedulersMtx.RUnlock()
+	if s, ok := schedulers[name]; ok {
+		return s
+	}
+	if name != "" {
+		klog.Warningf("scheduler: unknown scheduler %s, using %s", name, CapacityWeighted)
+	}
+	// return CapacityWeighted(default) if not specified
+	return schedulers[CapacityWeighted]
+}
+
+// getCandidates goes through all the volumes and creates the candidate
+// for every node having volumes on the given pool, the performance class
+// of the pool is taken from the ZFSNodes.
+func getCandidates(pool string) (map[string]Candidate, error) {
+	cmap := map[string]Candidate{}
 
 	zvlist, err := volbuilder.NewKubeclient().
 		WithNamespace(zfs.OpenEBSNamespace).
 		List(metav1.ListOptions{})
 
 	if err != nil {
-		return nmap, err
+		return cmap, err
+	}
+
+	nodes, err := nodebuilder.NewKubeclient().
+		WithNamespace(zfs.OpenEBSNamespace).
+		List(metav1.ListOptions{})
+	if err != nil {
+		return cmap, err
+	}
+
+	return buildCandidates(pool, zvlist.Items, nodes.Items), nil
+}
+
+// buildCandidates creates the candidates for the pool from the volumes
+// and the ZFSNodes. The features, the free space and the datasets are
+// those of the zpool holding the pool, which may be a child dataset, the filesystem
+// tools those of the node. The free space of a pool in a tenant dataset is
+// at most what is left of the quota of the tenant.
+func buildCandidates(pool string, vols []apis.ZFSVolume, nodes []apis.ZFSNode) map[string]Candidate {
+	cmap := map[string]Candidate{}
+	zpool := strings.SplitN(pool, "/", 2)[0]
+
+	for _, node := range nodes {
+		c := Candidate{Node: node.Name, Pool: pool}
+		// the class is the one of the zpool holding the parent dataset
+		class, ok := node.PerformanceClasses[zpool]
+		c.PerformanceClass = class
+		for _, summary := range node.Status.Pools {
+			if summary.Name == zpool && summary.Features != nil {
+				c.Features, ok = summary.Features, true
+			}
+			if summary.Name == zpool && summary.Datasets > 0 {
+				c.Datasets, ok = summary.Datasets, true
+			}
+			if summary.Name == zpool && summary.Health != "" {
+				c.Health, ok = summary.Health, true
+			}
+		}
+		if node.Status.FsTypes != nil {
+			c.FsTypes, ok = node.Status.FsTypes, true
+		}
+		for _, p := range node.Pools {
+			if p.Name == zpool {
+				free := p.Free.Value()
+				c.Free, ok = &free, true
+			}
+		}
+		// the volumes of a tenant are bounded by the quota of its dataset
+		if avail, found := zfs.TenantAvailable(&node, pool); found {
+			if c.Free == nil || avail < *c.Free {
+				c.Free = &avail
+			}
+			ok = true
+		}
+		if ok {
+			cmap[node.Name] = c
+		}
+	}
+
+	for _, zv := range vols {
+		if zv.Spec.PoolName != pool {
+			continue
+		}
+		c := cmap[zv.Spec.OwnerNodeID]
+		c.Node, c.Pool = zv.Spec.OwnerNodeID, pool
+		c.Volumes++
+		if volsize, err := strconv.ParseInt(zv.Spec.Capacity, 10, 64); err == nil {
+			c.Capacity += volsize
+		}
+		cmap[zv.Spec.OwnerNodeID] = c
+	}
+
+	return cmap
+}
+
+// getNodeList gets the nodelist which satisfies the topology info
+func getNodeList(topo []*csi.Topology) ([]string, error) {
+	var nodelist []string
+
+	list, err := k8sapi.ListNodes(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
 	}
 
-	// create the map of the volume capacity
-	// for the given pool
-	for _, zv := range zvlist.Items {
-		if zv.Spec.PoolName == pool {
-			volsize, err := strconv.ParseInt(zv.Spec.Capacity, 10, 64)
-			if err == nil {
-				nmap[zv.Spec.OwnerNodeID] += volsize
+	for _, node := range list.Items {
+		for _, prf := range topo {
+			nodeFiltered := false
+			for key, value := range prf.Segments {
+				if node.Labels[key] != value {
+					nodeFiltered = true
+					break
+				}
+			}
+			if !nodeFiltered {
+				nodelist = append(nodelist, node.Name)
+				break
 			}
 		}
 	}
 
-	return nmap, nil
+	return nodelist, nil
 }
 
-// getNodeMap returns the node mapping for the given scheduling algorithm
-func getNodeMap(schd string, pool string) (map[string]int64, error) {
-	switch schd {
-	case VolumeWeighted:
-		return getVolumeWeightedMap(pool)
-	case CapacityWeighted:
-		return getCapacityWeightedMap(pool)
+// rankNodes filters the nodes with the scheduler and returns them in the
+// order of their score, nodes with the same score keep their order.
+func rankNodes(req *csi.CreateVolumeRequest, s Scheduler, pool string,
+	nodelist []string, cmap map[string]Candidate) []string {
+	type scored struct {
+		node  string
+		score int64
 	}
-	// return CapacityWeighted(default) if not specified
-	return getCapacityWeightedMap(pool)
+	var ranked []scored
+
+	for _, node := range nodelist {
+		c, ok := cmap[node]
+		if !ok {
+			c = Candidate{Node: node, Pool: pool}
+		}
+		if !s.Filter(req, c) {
+			continue
+		}
+		ranked = append(ranked, scored{node, s.Score(req, c)})
+	}
+
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].score < ranked[j].score
+	})
+
+	preferred := make([]string, 0, len(ranked))
+	for _, r := range ranked {
+		preferred = append(preferred, r.node)
+	}
+	return preferred
+}
+
+// scheduleVolume returns the preferred list of nodes for the volume as
+// per the topology constraints and the scheduler asked in the storageclass,
+// the volume is kept apart or together with the other volumes of its group
+// and is placed on the nodes having enough free space for it and the tools
+// of its fstype, whose pool has not reached the limit of datasets. When no
+// node is left, the error gives the reason each node has been dropped for.
+func scheduleVolume(req *csi.CreateVolumeRequest, schd string, pool string, fstype string,
+	group *volumeGroup, space *freeSpace, limit *datasetLimit) ([]string, error) {
+	areq := req.GetAccessibilityRequirements()
+	if areq == nil {
+		klog.Errorf("scheduler: Accessibility Requirements not provided")
+		return nil, nil
+	}
+
+	topo := areq.Preferred
+	if len(topo) == 0 {
+		// if preferred list is empty, use the requisite
+		topo = areq.Requisite
+	}
+
+	if len(topo) == 0 {
+		klog.Errorf("scheduler: topology information not provided")
+		return nil, nil
+	}
+
+	nodelist, err := getNodeList(topo)
+	if err != nil {
+		klog.Errorf("scheduler: can not get the nodelist err : %v", err.Error())
+		return nil, nil
+	}
+
+	cmap, err := getCandidates(pool)
+	if err != nil {
+		return nil, err
+	}
+
+	s := getScheduler(schd)
+	// the filters in the order they are applied, to tell why the nodes
+	// have been dropped
+	filters := []Scheduler{s}
+	params := req.GetParameters()
+	perf, err := parsePerformanceClass(helpers.GetCaseInsensitiveMap(&params))
+	if err != nil {
+		return nil, err
+	}
+	if perf != nil {
+		s = Compose(s, perf)
+		filters = append(filters, perf)
+	}
+	if features := requiredPoolFeatures(helpers.GetCaseInsensitiveMap(&params)); len(features) > 0 {
+		s = Compose(s, features)
+		filters = append(filters, features)
+	}
+	if health := requiredPoolHealth(); health != nil {
+		s = Compose(s, health)
+		filters = append(filters, health)
+	}
+	base := s
+	tools := requiredFsTypeTools(req, fstype)
+	if tools != nil {
+		s = Compose(s, tools)
+		filters = append(filters, tools)
+	}
+	withTools := s
+	if limit != nil {
+		s = Compose(s, limit)
+		filters = append(filters, limit)
+	}
+
+	grouped := s
+	if group != nil {
+		if err = group.loadNodes(strings.ToLower(req.GetName())); err != nil {
+			return nil, err
+		}
+		grouped = Compose(s, group)
+		filters = append(filters, group)
+	}
+	full := grouped
+	if space != nil {
+		full = Compose(grouped, space)
+		filters = append(filters, space)
+	}
+
+	preferred := rankNodes(req, full, pool, nodelist, cmap)
+	if len(preferred) != 0 {
+		return preferred, nil
+	}
+
+	// tell why the eligible nodes have been dropped, along with the
+	// reason of every node
+	var cause error
+	if space != nil {
+		if eligible := rankNodes(req, grouped, pool, nodelist, cmap); len(eligible) > 0 {
+			cause = space.unschedulable(pool, eligible, cmap)
+		}
+	}
+	if cause == nil && group != nil {
+		if eligible := rankNodes(req, s, pool, nodelist, cmap); len(eligible) > 0 {
+			cause = group.unschedulable(eligible)
+		}
+	}
+	if cause == nil && limit != nil {
+		if eligible := rankNodes(req, withTools, pool, nodelist, cmap); len(eligible) > 0 {
+			cause = limit.unschedulable(pool, eligible)
+		}
+	}
+	if cause == nil && tools != nil {
+		if eligible := rankNodes(req, base, pool, nodelist, cmap); len(eligible) > 0 {
+			cause = tools.unschedulable(eligible)
+		}
+	}
+	return nil, noSuitableNode(req, pool, filters, nodelist, cmap, cause)
 }
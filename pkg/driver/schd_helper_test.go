@@ -0,0 +1,283 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"github.com/openebs/zfs-localpv/pkg/zfs"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// excludeNode filters out a node, used to verify custom schedulers
+type excludeNode string
+
+func (e excludeNode) Filter(_ *csi.CreateVolumeRequest, c Candidate) bool { return c.Node != string(e) }
+
+func (e excludeNode) Score(*csi.CreateVolumeRequest, Candidate) int64 { return 0 }
+
+func TestRankNodes(t *testing.T) {
+	nodes := []string{"node1", "node2", "node3", "node4"}
+	cmap := map[string]Candidate{
+		"node1": {Node: "node1", Pool: "zfspv", Volumes: 1, Capacity: 100},
+		"node2": {Node: "node2", Pool: "zfspv", Volumes: 3, Capacity: 10},
+		"node3": {Node: "node3", Pool: "zfspv", Volumes: 2, Capacity: 50},
+	}
+
+	tests := map[string]struct {
+		scheduler Scheduler
+		expected  []string
+	}{
+		"volume weighted":   {scheduler: volumeWeighted{}, expected: []string{"node4", "node1", "node3", "node2"}},
+		"capacity weighted": {scheduler: capacityWeighted{}, expected: []string{"node4", "node2", "node3", "node1"}},
+		"composed with a filter": {
+			scheduler: Compose(excludeNode("node4"), volumeWeighted{}),
+			expected:  []string{"node1", "node3", "node2"},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := rankNodes(&csi.CreateVolumeRequest{}, test.scheduler, "zfspv", nodes, cmap)
+			assert.Equal(t, test.expected, got)
+		})
+	}
+}
+
+func TestGetScheduler(t *testing.T) {
+	RegisterScheduler("ExcludeNode1", excludeNode("node1"))
+
+	tests := map[string]struct {
+		param    string
+		expected Scheduler
+	}{
+		"volume weighted":              {param: VolumeWeighted, expected: volumeWeighted{}},
+		"capacity weighted":            {param: CapacityWeighted, expected: capacityWeighted{}},
+		"default is capacity weighted": {param: "", expected: capacityWeighted{}},
+		"unknown is capacity weighted": {param: "unknown", expected: capacityWeighted{}},
+		"registered scheduler":         {param: "ExcludeNode1", expected: excludeNode("node1")},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, test.expected, getScheduler(test.param))
+		})
+	}
+}
+
+// provisioned returns a volume provisioned from the pool on the node
+func provisioned(name, node, pool, capacity string) apis.ZFSVolume {
+	v := apis.ZFSVolume{}
+	v.Name = name
+	v.Spec.OwnerNodeID = node
+	v.Spec.PoolName = pool
+	v.Spec.Capacity = capacity
+	return v
+}
+
+func TestPerformanceClass(t *testing.T) {
+	nodes := []string{"node1", "node2", "node3", "node4"}
+	zfsnodes := []apis.ZFSNode{{}, {}, {}}
+	zfsnodes[0].Name, zfsnodes[0].PerformanceClasses = "node1", map[string]string{"zfspv": "hdd"}
+	zfsnodes[1].Name, zfsnodes[1].PerformanceClasses = "node2", map[string]string{"zfspv": "nvme"}
+	zfsnodes[2].Name, zfsnodes[2].PerformanceClasses = "node3", map[string]string{"other": "nvme", "zfspv": "nvme"}
+	vols := []apis.ZFSVolume{
+		provisioned("pvc-1", "node1", "zfspv", "1073741824"),
+		provisioned("pvc-2", "node2", "zfspv", "10737418240"),
+		provisioned("pvc-3", "node3", "zfspv", "5368709120"),
+	}
+	cmap := buildCandidates("zfspv", vols, zfsnodes)
+	assert.Equal(t, "nvme", cmap["node3"].PerformanceClass)
+	assert.Equal(t, int64(5368709120), cmap["node3"].Capacity)
+
+	// a parent dataset has the class of its zpool
+	assert.Equal(t, "nvme", buildCandidates("zfspv/tenant-a", vols, zfsnodes)["node2"].PerformanceClass)
+
+	tests := map[string]struct {
+		params   map[string]string
+		expected []string
+	}{
+		"no class": {
+			params:   map[string]string{},
+			expected: []string{"node4", "node1", "node3", "node2"},
+		},
+		"required": {
+			params:   map[string]string{"performanceclass": "nvme", "performanceclasspolicy": "required"},
+			expected: []string{"node3", "node2"},
+		},
+		"required unknown class": {
+			params:   map[string]string{"performanceclass": "ssd", "performanceclasspolicy": "required"},
+			expected: []string{},
+		},
+		"preferred": {
+			params:   map[string]string{"performanceclass": "nvme"},
+			expected: []string{"node3", "node2", "node4", "node1"},
+		},
+		// node2 has 10Gi provisioned, node1 1Gi plus the 20Gi penalty
+		"preferred with weight": {
+			params:   map[string]string{"performanceclass": "nvme", "performanceclassweight": "20Gi"},
+			expected: []string{"node3", "node2", "node4", "node1"},
+		},
+		// with a 2Gi penalty node1 and node4 beat the loaded nvme node2
+		"preferred with small weight": {
+			params:   map[string]string{"performanceclass": "nvme", "performanceclassweight": "2Gi"},
+			expected: []string{"node4", "node1", "node3", "node2"},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var s Scheduler = capacityWeighted{}
+			perf, err := parsePerformanceClass(test.params)
+			assert.NoError(t, err)
+			if perf != nil {
+				s = Compose(s, perf)
+			}
+			got := rankNodes(&csi.CreateVolumeRequest{}, s, "zfspv", nodes, cmap)
+			assert.Equal(t, test.expected, got)
+		})
+	}
+}
+
+func TestParsePerformanceClass(t *testing.T) {
+	perf, err := parsePerformanceClass(map[string]string{"performanceclass": "nvme"})
+	assert.NoError(t, err)
+	assert.Equal(t, &performanceClass{class: "nvme", penalty: defaultClassPenalty}, perf)
+
+	for _, params := range []map[string]string{
+		{"performanceclasspolicy": "required"},
+		{"performanceclass": "nvme", "performanceclasspolicy": "always"},
+		{"performanceclass": "nvme", "performanceclassweight": "-1Gi"},
+		{"performanceclass": "nvme", "performanceclassweight": "heavy"},
+	} {
+		_, err = parsePerformanceClass(params)
+		assert.Error(t, err, params)
+	}
+}
+
+func TestRequiredPoolFeatures(t *testing.T) {
+	assert.Empty(t, requiredPoolFeatures(map[string]string{"encryption": "off", "compression": "lz4"}))
+	assert.Equal(t, poolFeatures{"encryption", "zstd_compress", "bookmark_v2"},
+		requiredPoolFeatures(map[string]string{
+			"encryption":   "on",
+			"compression":  "zstd-3",
+			"poolfeatures": "bookmark_v2, ",
+		}))
+	assert.Equal(t, poolFeatures{"encryption"},
+		requiredPoolFeatures(map[string]string{"keymode": "per-volume"}))
+}
+
+func TestPoolFeatures(t *testing.T) {
+	nodes := []string{"node1", "node2", "node3", "node4"}
+	zfsnodes := []apis.ZFSNode{{}, {}, {}}
+	zfsnodes[0].Name = "node1"
+	zfsnodes[0].Status.Pools = []apis.PoolSummary{
+		{Name: "zfspv", Features: map[string]string{"encryption": "active", "zstd_compress": "enabled"}},
+	}
+	zfsnodes[1].Name = "node2"
+	zfsnodes[1].Status.Pools = []apis.PoolSummary{
+		{Name: "zfspv", Features: map[string]string{"encryption": "enabled", "zstd_compress": "disabled"}},
+	}
+	// the features of node3 are not known
+	zfsnodes[2].Name = "node3"
+	zfsnodes[2].Status.Pools = []apis.PoolSummary{{Name: "zfspv"}}
+
+	// the features are those of the zpool holding the child dataset
+	cmap := buildCandidates("zfspv/k8s", nil, zfsnodes)
+	assert.Equal(t, "enabled", cmap["node1"].Features["zstd_compress"])
+	assert.NotContains(t, cmap, "node3")
+
+	tests := map[string]struct {
+		params   map[string]string
+		expected []string
+	}{
+		"no features": {
+			params:   map[string]string{},
+			expected: nodes,
+		},
+		"encryption": {
+			params:   map[string]string{"encryption": "on"},
+			expected: nodes,
+		},
+		"zstd": {
+			params:   map[string]string{"compression": "zstd"},
+			expected: []string{"node1", "node3", "node4"},
+		},
+		"unknown feature": {
+			params:   map[string]string{"poolfeatures": "draid"},
+			expected: []string{"node3", "node4"},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var s Scheduler = volumeWeighted{}
+			if features := requiredPoolFeatures(test.params); len(features) > 0 {
+				s = Compose(s, features)
+			}
+			got := rankNodes(&csi.CreateVolumeRequest{}, s, "zfspv/k8s", nodes, cmap)
+			assert.Equal(t, test.expected, got)
+		})
+	}
+}
+
+func TestFsTypeTools(t *testing.T) {
+	nodes := []string{"node1", "node2", "node3"}
+	zfsnodes := []apis.ZFSNode{{}, {}, {}}
+	zfsnodes[0].Name = "node1"
+	zfsnodes[0].Status.FsTypes = []apis.FsTypeTools{{Name: "ext4", Mkfs: true}, {Name: "xfs", Mkfs: true}}
+	zfsnodes[1].Name = "node2"
+	zfsnodes[1].Status.FsTypes = []apis.FsTypeTools{{Name: "ext4", Mkfs: true}, {Name: "xfs", Fsck: true}}
+	// the tools of node3 are not known
+	zfsnodes[2].Name = "node3"
+	zfsnodes[2].Status.Pools = []apis.PoolSummary{{Name: "zfspv", Features: map[string]string{}}}
+
+	cmap := buildCandidates("zfspv", nil, zfsnodes)
+	assert.Len(t, cmap["node2"].FsTypes, 2)
+	assert.Nil(t, cmap["node3"].FsTypes)
+
+	block := &csi.CreateVolumeRequest{VolumeCapabilities: []*csi.VolumeCapability{
+		{AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}}},
+	}}
+	assert.Nil(t, requiredFsTypeTools(block, ""))
+	assert.Nil(t, requiredFsTypeTools(&csi.CreateVolumeRequest{}, "zfs"))
+	assert.Equal(t, fsTypeTools(zfs.DefaultFsType), *requiredFsTypeTools(&csi.CreateVolumeRequest{}, ""))
+
+	tests := map[string]struct {
+		fstype   string
+		expected []string
+	}{
+		"ext4":  {fstype: "ext4", expected: nodes},
+		"xfs":   {fstype: "xfs", expected: []string{"node1", "node3"}},
+		"btrfs": {fstype: "btrfs", expected: []string{"node3"}},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := Compose(volumeWeighted{}, requiredFsTypeTools(&csi.CreateVolumeRequest{}, test.fstype))
+			got := rankNodes(&csi.CreateVolumeRequest{}, s, "zfspv", nodes, cmap)
+			assert.Equal(t, test.expected, got)
+		})
+	}
+
+	err := requiredFsTypeTools(&csi.CreateVolumeRequest{}, "btrfs").unschedulable([]string{"node1", "node2"})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Contains(t, err.Error(), "no node supports fsType btrfs")
+}
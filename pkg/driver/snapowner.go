@@ -0,0 +1,177 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"reflect"
+	"strings"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	clientset "github.com/openebs/zfs-localpv/pkg/generated/clientset/internalclientset"
+	"github.com/openebs/zfs-localpv/pkg/zfs"
+	k8serror "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/klog/v2"
+)
+
+// volumeOwnerRef returns the owner reference of the snapshots to their
+// volume, a foreground deletion of the volume waits for them
+func volumeOwnerRef(vol *apis.ZFSVolume) metav1.OwnerReference {
+	block := true
+	return metav1.OwnerReference{
+		APIVersion:         apis.SchemeGroupVersion.String(),
+		Kind:               "ZFSVolume",
+		Name:               vol.Name,
+		UID:                vol.UID,
+		BlockOwnerDeletion: &block,
+	}
+}
+
+// isVolumeOwnerRef tells whether the owner reference is to a ZFSVolume
+func isVolumeOwnerRef(ref metav1.OwnerReference) bool {
+	return ref.Kind == "ZFSVolume" && strings.HasPrefix(ref.APIVersion, apis.SchemeGroupVersion.Group+"/")
+}
+
+// linkSnapshotOwner sets the volume as the owner of the snapshot, so that
+// the snapshot is garbage collected along with the volume. The owner has
+// to be in the same namespace, a volume in another namespace is linked by
+// the name and namespace labels instead. The snapshots of a volume with
+// the orphan policy outlive it and are not owned by it. It returns true if
+// the snapshot has changed.
+func linkSnapshotOwner(snap *apis.ZFSSnapshot, vol *apis.ZFSVolume) bool {
+	sameNamespace := vol.Namespace == snap.Namespace
+	want := sameNamespace && vol.Spec.SnapshotPolicy != zfs.SnapshotPolicyOrphan
+
+	changed, owned := false, false
+	var refs []metav1.OwnerReference
+	for _, ref := range snap.OwnerReferences {
+		if isVolumeOwnerRef(ref) {
+			if !want || owned || ref.Name != vol.Name || ref.UID != vol.UID ||
+				ref.BlockOwnerDeletion == nil || !*ref.BlockOwnerDeletion {
+				changed = true
+				continue
+			}
+			owned = true
+		}
+		refs = append(refs, ref)
+	}
+	if want && !owned {
+		refs = append(refs, volumeOwnerRef(vol))
+		changed = true
+	}
+	snap.OwnerReferences = refs
+
+	if !sameNamespace && (snap.Labels[zfs.ZFSVolKey] != vol.Name || snap.Labels[zfs.ZFSVolNamespaceKey] != vol.Namespace) {
+		if snap.Labels == nil {
+			snap.Labels = map[string]string{}
+		}
+		snap.Labels[zfs.ZFSVolKey] = vol.Name
+		snap.Labels[zfs.ZFSVolNamespaceKey] = vol.Namespace
+		changed = true
+	}
+	return changed
+}
+
+// volumeSnapshotLabels returns the labels linking the snapshot to its
+// VolumeSnapshot, which is in the namespace of the application and can not
+// be its owner. The VolumeSnapshot is known only if the snapshotter passes
+// its name, with --extra-create-metadata.
+func volumeSnapshotLabels(params map[string]string) map[string]string {
+	name := params["csi.storage.k8s.io/volumesnapshot/name"]
+	ns := params["csi.storage.k8s.io/volumesnapshot/namespace"]
+	if name == "" || ns == "" {
+		return nil
+	}
+	if errs := validation.IsValidLabelValue(name); len(errs) != 0 {
+		klog.Warningf("not linking the snapshot to VolumeSnapshot %s/%s: %s", ns, name, strings.Join(errs, ", "))
+		return nil
+	}
+	return map[string]string{zfs.VolumeSnapshotKey: name, zfs.VolumeSnapshotNamespaceKey: ns}
+}
+
+// syncSnapshotOwner links the snapshot to its volume if it is not yet,
+// the snapshots being deleted and those whose volume is gone or being
+// deleted are left as they are
+func syncSnapshotOwner(client clientset.Interface, snap *apis.ZFSSnapshot) error {
+	volName := snap.Labels[zfs.ZFSVolKey]
+	if snap.DeletionTimestamp != nil || volName == "" {
+		return nil
+	}
+	vol, err := client.ZfsV1().ZFSVolumes(zfs.OpenEBSNamespace).Get(context.TODO(), volName, metav1.GetOptions{})
+	if err != nil {
+		if k8serror.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if vol.DeletionTimestamp != nil {
+		return nil
+	}
+	snap = snap.DeepCopy()
+	if !linkSnapshotOwner(snap, vol) {
+		return nil
+	}
+	klog.Infof("linking snapshot %s to its volume %s", snap.Name, vol.Name)
+	_, err = client.ZfsV1().ZFSSnapshots(snap.Namespace).Update(context.TODO(), snap, metav1.UpdateOptions{})
+	return err
+}
+
+// releaseSnapshots drops the owner reference of the snapshots to the
+// volume with the orphan policy before it is deleted, e.g. if the policy
+// has been changed after they have been linked
+func releaseSnapshots(client clientset.Interface, vol *apis.ZFSVolume, snaps []apis.ZFSSnapshot) error {
+	for i := range snaps {
+		snap := snaps[i].DeepCopy()
+		if !linkSnapshotOwner(snap, vol) {
+			continue
+		}
+		klog.Infof("releasing snapshot %s of volume %s as per the snapshot policy", snap.Name, vol.Name)
+		_, err := client.ZfsV1().ZFSSnapshots(snap.Namespace).Update(context.TODO(), snap, metav1.UpdateOptions{})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addSnapshot is the add event handler for the ZFSSnapshots, the
+// snapshots created before the owner references are linked when the
+// controller starts
+func (cs *controller) addSnapshot(obj interface{}) {
+	snap, ok := obj.(*apis.ZFSSnapshot)
+	if !ok {
+		return
+	}
+	if err := syncSnapshotOwner(cs.openebsClient, snap); err != nil {
+		klog.Errorf("could not link snapshot %s to its volume: %v", snap.Name, err)
+	}
+}
+
+// updateSnapshot is the update event handler for the ZFSSnapshots, the
+// owner references or the labels changed by hand are set again while the
+// status updates are skipped
+func (cs *controller) updateSnapshot(oldObj, newObj interface{}) {
+	old, ok := oldObj.(*apis.ZFSSnapshot)
+	snap, ok2 := newObj.(*apis.ZFSSnapshot)
+	if !ok || !ok2 || (reflect.DeepEqual(old.OwnerReferences, snap.OwnerReferences) &&
+		reflect.DeepEqual(old.Labels, snap.Labels)) {
+		return
+	}
+	cs.addSnapshot(snap)
+}
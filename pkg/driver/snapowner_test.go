@@ -0,0 +1,171 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"testing"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	openebsfake "github.com/openebs/zfs-localpv/pkg/generated/clientset/internalclientset/fake"
+	"github.com/openebs/zfs-localpv/pkg/zfs"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func ownerVolume(ns string, uid types.UID) *apis.ZFSVolume {
+	vol := &apis.ZFSVolume{}
+	vol.Namespace, vol.Name, vol.UID = ns, "pvc-1", uid
+	return vol
+}
+
+func ownedSnapshot(refs ...metav1.OwnerReference) *apis.ZFSSnapshot {
+	snap := &apis.ZFSSnapshot{}
+	snap.Namespace, snap.Name = "openebs", "snapshot-1"
+	snap.Labels = map[string]string{zfs.ZFSVolKey: "pvc-1"}
+	snap.OwnerReferences = refs
+	return snap
+}
+
+func TestLinkSnapshotOwner(t *testing.T) {
+	vol := ownerVolume("openebs", "uid-1")
+	other := metav1.OwnerReference{APIVersion: "v1", Kind: "ConfigMap", Name: "backup", UID: "uid-9"}
+
+	// the owner reference is added along with the others
+	snap := ownedSnapshot(other)
+	assert.True(t, linkSnapshotOwner(snap, vol))
+	assert.Equal(t, []metav1.OwnerReference{other, volumeOwnerRef(vol)}, snap.OwnerReferences)
+	assert.True(t, *snap.OwnerReferences[1].BlockOwnerDeletion)
+	assert.False(t, linkSnapshotOwner(snap, vol))
+
+	// a reference to a volume recreated with the same name is replaced
+	stale := volumeOwnerRef(ownerVolume("openebs", "uid-0"))
+	snap = ownedSnapshot(stale)
+	assert.True(t, linkSnapshotOwner(snap, vol))
+	assert.Equal(t, []metav1.OwnerReference{volumeOwnerRef(vol)}, snap.OwnerReferences)
+
+	// the orphaned snapshots outlive the volume
+	orphan := vol.DeepCopy()
+	orphan.Spec.SnapshotPolicy = zfs.SnapshotPolicyOrphan
+	snap = ownedSnapshot(other, volumeOwnerRef(vol))
+	assert.True(t, linkSnapshotOwner(snap, orphan))
+	assert.Equal(t, []metav1.OwnerReference{other}, snap.OwnerReferences)
+	assert.False(t, linkSnapshotOwner(snap, orphan))
+}
+
+func TestLinkSnapshotOwnerCrossNamespace(t *testing.T) {
+	vol := ownerVolume("storage", "uid-1")
+	snap := ownedSnapshot(volumeOwnerRef(vol))
+	assert.True(t, linkSnapshotOwner(snap, vol))
+	assert.Empty(t, snap.OwnerReferences)
+	assert.Equal(t, map[string]string{
+		zfs.ZFSVolKey:          "pvc-1",
+		zfs.ZFSVolNamespaceKey: "storage",
+	}, snap.Labels)
+	assert.False(t, linkSnapshotOwner(snap, vol))
+}
+
+func TestVolumeSnapshotLabels(t *testing.T) {
+	assert.Nil(t, volumeSnapshotLabels(map[string]string{}))
+	assert.Equal(t, map[string]string{
+		zfs.VolumeSnapshotKey:          "nightly",
+		zfs.VolumeSnapshotNamespaceKey: "app",
+	}, volumeSnapshotLabels(map[string]string{
+		"csi.storage.k8s.io/volumesnapshot/name":      "nightly",
+		"csi.storage.k8s.io/volumesnapshot/namespace": "app",
+	}))
+
+	long := make([]byte, 64)
+	for i := range long {
+		long[i] = 'a'
+	}
+	assert.Nil(t, volumeSnapshotLabels(map[string]string{
+		"csi.storage.k8s.io/volumesnapshot/name":      string(long),
+		"csi.storage.k8s.io/volumesnapshot/namespace": "app",
+	}))
+}
+
+// snapshotOwnerClient returns the clientset holding the objects in the
+// openebs namespace, with the snapshots updated
+func snapshotOwnerClient(t *testing.T, objs ...runtime.Object) (*openebsfake.Clientset, *[]*apis.ZFSSnapshot) {
+	orig := zfs.OpenEBSNamespace
+	t.Cleanup(func() { zfs.OpenEBSNamespace = orig })
+	zfs.OpenEBSNamespace = "openebs"
+
+	client := openebsfake.NewSimpleClientset(objs...)
+	var updated []*apis.ZFSSnapshot
+	client.PrependReactor("update", "zfssnapshots", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		updated = append(updated, action.(k8stesting.UpdateAction).GetObject().(*apis.ZFSSnapshot))
+		return false, nil, nil
+	})
+	return client, &updated
+}
+
+func TestSyncSnapshotOwner(t *testing.T) {
+	vol := ownerVolume("openebs", "uid-1")
+	client, updated := snapshotOwnerClient(t, vol, ownedSnapshot())
+
+	// the object of the informer cache is not modified
+	snap := ownedSnapshot()
+	assert.NoError(t, syncSnapshotOwner(client, snap))
+	assert.Empty(t, snap.OwnerReferences)
+	assert.Len(t, *updated, 1)
+	assert.Equal(t, []metav1.OwnerReference{volumeOwnerRef(vol)}, (*updated)[0].OwnerReferences)
+
+	// nothing to do once linked
+	assert.NoError(t, syncSnapshotOwner(client, (*updated)[0]))
+	assert.Len(t, *updated, 1)
+
+	// the snapshots being deleted and those of a volume gone or being
+	// deleted are skipped
+	deleting := ownedSnapshot()
+	deleting.DeletionTimestamp = &metav1.Time{}
+	assert.NoError(t, syncSnapshotOwner(client, deleting))
+	vol.DeletionTimestamp = &metav1.Time{}
+	_, err := client.ZfsV1().ZFSVolumes("openebs").Update(context.TODO(), vol, metav1.UpdateOptions{})
+	assert.NoError(t, err)
+	assert.NoError(t, syncSnapshotOwner(client, ownedSnapshot()))
+	assert.NoError(t, client.ZfsV1().ZFSVolumes("openebs").Delete(context.TODO(), vol.Name, metav1.DeleteOptions{}))
+	assert.NoError(t, syncSnapshotOwner(client, ownedSnapshot()))
+	assert.Len(t, *updated, 1)
+
+	// the status updates are skipped by the update handler
+	vol = ownerVolume("openebs", "uid-1")
+	client, updated = snapshotOwnerClient(t, vol, ownedSnapshot())
+	cs := &controller{openebsClient: client}
+	ready := ownedSnapshot()
+	ready.Status.State = zfs.ZFSStatusReady
+	cs.updateSnapshot(ownedSnapshot(), ready)
+	assert.Empty(t, *updated)
+	cs.updateSnapshot(ownedSnapshot(volumeOwnerRef(vol)), ownedSnapshot())
+	assert.Len(t, *updated, 1)
+}
+
+func TestReleaseSnapshots(t *testing.T) {
+	vol := ownerVolume("openebs", "uid-1")
+	snaps := []apis.ZFSSnapshot{*ownedSnapshot(volumeOwnerRef(vol)), *ownedSnapshot()}
+	client, updated := snapshotOwnerClient(t, &snaps[0])
+
+	vol.Spec.SnapshotPolicy = zfs.SnapshotPolicyOrphan
+	assert.NoError(t, releaseSnapshots(client, vol, snaps))
+	assert.Len(t, *updated, 1)
+	assert.Empty(t, (*updated)[0].OwnerReferences)
+	assert.NotEmpty(t, snaps[0].OwnerReferences)
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"path"
+	"strings"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/openebs/lib-csi/pkg/common/helpers"
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"github.com/openebs/zfs-localpv/pkg/zfs"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	k8serror "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/klog/v2"
+)
+
+// getRestoreSnapshot fetches the ZFSSnapshot a volume is restored from,
+// can be replaced in unit tests
+var getRestoreSnapshot = zfs.GetZFSSnapshot
+
+// resolveRestoreSnapshot returns the ZFSSnapshot behind the snapshot id of
+// the VolumeSnapshotContent, <volume>@<snapshot> as returned by
+// CreateSnapshot. The volume may be prefixed with its pool, e.g. in the
+// handle of a pre-provisioned VolumeSnapshotContent. The ZFSSnapshot must
+// have been taken of the volume and be ready.
+func resolveRestoreSnapshot(snapshotID string) (*apis.ZFSSnapshot, error) {
+	i := strings.LastIndex(snapshotID, "@")
+	if i <= 0 || i == len(snapshotID)-1 {
+		return nil, status.Errorf(codes.NotFound,
+			"snap name is not valid %s, {%s}", snapshotID, "invalid snapshot name")
+	}
+	volName := strings.ToLower(path.Base(snapshotID[:i]))
+	snapName := strings.ToLower(snapshotID[i+1:])
+
+	snap, err := getRestoreSnapshot(snapName)
+	if err != nil {
+		if k8serror.IsNotFound(err) {
+			return nil, status.Errorf(codes.NotFound, "snapshot %s not found: %s", snapshotID, err.Error())
+		}
+		return nil, status.Errorf(codes.Internal, "could not get snapshot %s: %s", snapshotID, err.Error())
+	}
+	if snap.Labels[zfs.ZFSVolKey] != volName {
+		return nil, status.Errorf(codes.NotFound,
+			"snapshot %s is a snapshot of volume %s, not of %s", snapName, snap.Labels[zfs.ZFSVolKey], volName)
+	}
+	if err = snapshotError(snap, time.Now()); err != nil {
+		return nil, err
+	}
+	if snap.Status.State != zfs.ZFSStatusReady {
+		return nil, status.Errorf(codes.Unavailable, "snapshot %s is not ready yet", snapshotID)
+	}
+	return snap, nil
+}
+
+// restoreSpec sets up the spec of the volume restored from the snapshot.
+// The snapshot has the spec of its volume when it was taken, so the
+// restored volume gets the properties of the source, e.g. compression and
+// recordsize, unless the StorageClass overrides them. The fields about the
+// snapshot itself are not carried over. The volume is restored on pool,
+// the snapshot stays on the pool of the volume it has been taken of.
+func restoreSpec(volObj *apis.ZFSVolume, snap *apis.ZFSSnapshot, pool string, parameters map[string]string) {
+	volObj.Spec = snap.Spec.VolumeInfo
+	volObj.Spec.PoolName = pool
+	volObj.Spec.SourcePool = ""
+	if pool != snap.Spec.PoolName {
+		volObj.Spec.SourcePool = snap.Spec.PoolName
+	}
+	volObj.Spec.FreezePlugin = ""
+	volObj.Spec.FreezeParams = nil
+	cloneOverrides(volObj, parameters)
+}
+
+// cloneOverrides sets the properties of the StorageClass which override
+// the ones of the source on a clone, be it of a volume or of a snapshot
+func cloneOverrides(volObj *apis.ZFSVolume, parameters map[string]string) {
+	if c := helpers.GetInsensitiveParameter(&parameters, "compression"); c != "" {
+		volObj.Spec.Compression = c
+	}
+	// the volblocksize of a zvol is the one of its origin, only the
+	// recordsize of a dataset can be changed
+	if rs := helpers.GetInsensitiveParameter(&parameters, "recordsize"); rs != "" {
+		volObj.Spec.RecordSize = rs
+	}
+}
+
+// checkRestoreNode returns an error if the volume can not be restored on
+// the node of the snapshot, a zfs clone has to be on the node of its
+// origin and the snapshots are not copied across the nodes. The node must
+// be allowed by the requisite topology of the request, if any.
+func checkRestoreNode(req *csi.CreateVolumeRequest, snap *apis.ZFSSnapshot) error {
+	node := snap.Spec.OwnerNodeID
+	areq := req.GetAccessibilityRequirements()
+	if len(areq.GetRequisite()) == 0 {
+		return nil
+	}
+	for _, topo := range areq.GetRequisite() {
+		if topo.GetSegments()[zfs.ZFSTopologyKey] == node {
+			if p := areq.GetPreferred(); len(p) != 0 && p[0].GetSegments()[zfs.ZFSTopologyKey] != node {
+				klog.Infof("restoring %s on node %s of the snapshot instead of the preferred node %s",
+					req.GetName(), node, p[0].GetSegments()[zfs.ZFSTopologyKey])
+			}
+			return nil
+		}
+	}
+	return status.Errorf(codes.ResourceExhausted,
+		"snapshot %s is on node %s which is not allowed for volume %s, it can only be restored on its node",
+		snap.Name, node, req.GetName())
+}
@@ -0,0 +1,179 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"github.com/openebs/zfs-localpv/pkg/zfs"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	k8serror "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// restoreSnapshot is a ready snapshot of pvc-src on node-1
+func restoreSnapshot() *apis.ZFSSnapshot {
+	snap := &apis.ZFSSnapshot{}
+	snap.Name = "snapshot-1"
+	snap.Labels = map[string]string{zfs.ZFSVolKey: "pvc-src"}
+	snap.Spec.PoolName = "zfspv-pool"
+	snap.Spec.OwnerNodeID = "node-1"
+	snap.Spec.VolumeType = zfs.VolTypeDataset
+	snap.Spec.Capacity = "4294967296"
+	snap.Spec.Compression = "zstd"
+	snap.Spec.RecordSize = "16k"
+	snap.Spec.SnapshotProperties = map[string]string{"org.example:app": "db"}
+	snap.Spec.FreezePlugin = "fsfreeze"
+	snap.Spec.FreezeParams = map[string]string{"freeze.timeout": "30s"}
+	snap.Status.State = zfs.ZFSStatusReady
+	return snap
+}
+
+func stubRestoreSnapshot(t *testing.T, snap *apis.ZFSSnapshot, err error) *string {
+	orig := getRestoreSnapshot
+	t.Cleanup(func() { getRestoreSnapshot = orig })
+	var asked string
+	getRestoreSnapshot = func(name string) (*apis.ZFSSnapshot, error) {
+		asked = name
+		return snap, err
+	}
+	return &asked
+}
+
+func TestResolveRestoreSnapshot(t *testing.T) {
+	asked := stubRestoreSnapshot(t, restoreSnapshot(), nil)
+	for _, id := range []string{"pvc-src@snapshot-1", "zfspv-pool/pvc-src@snapshot-1", "PVC-SRC@Snapshot-1"} {
+		snap, err := resolveRestoreSnapshot(id)
+		if assert.NoError(t, err, id) {
+			assert.Equal(t, "snapshot-1", snap.Name)
+			assert.Equal(t, "snapshot-1", *asked)
+		}
+	}
+
+	for _, id := range []string{"snapshot-1", "@snapshot-1", "pvc-src@"} {
+		_, err := resolveRestoreSnapshot(id)
+		assert.Equal(t, codes.NotFound, status.Code(err), id)
+	}
+
+	// the snapshot must have been taken of the volume of the id
+	_, err := resolveRestoreSnapshot("pvc-other@snapshot-1")
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestResolveRestoreSnapshotErrors(t *testing.T) {
+	notFound := k8serror.NewNotFound(schema.GroupResource{Resource: "zfssnapshots"}, "snapshot-1")
+	stubRestoreSnapshot(t, nil, notFound)
+	_, err := resolveRestoreSnapshot("pvc-src@snapshot-1")
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	stubRestoreSnapshot(t, nil, errors.New("connection refused"))
+	_, err = resolveRestoreSnapshot("pvc-src@snapshot-1")
+	assert.Equal(t, codes.Internal, status.Code(err))
+
+	pending := restoreSnapshot()
+	pending.Status.State = zfs.ZFSStatusPending
+	stubRestoreSnapshot(t, pending, nil)
+	_, err = resolveRestoreSnapshot("pvc-src@snapshot-1")
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+
+	failed := restoreSnapshot()
+	failed.Status.State = zfs.ZFSStatusFailed
+	failed.Status.Error = "dataset is busy"
+	stubRestoreSnapshot(t, failed, nil)
+	_, err = resolveRestoreSnapshot("pvc-src@snapshot-1")
+	assert.Equal(t, codes.Internal, status.Code(err))
+}
+
+func TestRestoreSpec(t *testing.T) {
+	snap := restoreSnapshot()
+
+	// the restored volume has the properties of the source
+	vol := &apis.ZFSVolume{}
+	restoreSpec(vol, snap, snap.Spec.PoolName, map[string]string{})
+	assert.Equal(t, "zstd", vol.Spec.Compression)
+	assert.Equal(t, "16k", vol.Spec.RecordSize)
+	assert.Equal(t, "4294967296", vol.Spec.Capacity)
+	assert.Equal(t, "node-1", vol.Spec.OwnerNodeID)
+	assert.Empty(t, vol.Spec.FreezePlugin)
+	assert.Nil(t, vol.Spec.FreezeParams)
+	assert.Equal(t, snap.Spec.PoolName, vol.Spec.PoolName)
+	assert.Empty(t, vol.Spec.SourcePool)
+
+	// unless the StorageClass overrides them
+	vol = &apis.ZFSVolume{}
+	restoreSpec(vol, snap, snap.Spec.PoolName, map[string]string{"Compression": "lz4", "recordsize": "128k"})
+	assert.Equal(t, "lz4", vol.Spec.Compression)
+	assert.Equal(t, "128k", vol.Spec.RecordSize)
+
+	// the volume is restored on another pool, the snapshot is copied from
+	// the pool of its volume
+	vol = &apis.ZFSVolume{}
+	restoreSpec(vol, snap, "nvme", map[string]string{})
+	assert.Equal(t, "nvme", vol.Spec.PoolName)
+	assert.Equal(t, snap.Spec.PoolName, vol.Spec.SourcePool)
+	assert.True(t, zfs.NeedsPoolCopy(vol.Spec.SourcePool, vol.Spec.PoolName))
+
+	// the snapshot is left alone
+	assert.Equal(t, "zstd", snap.Spec.Compression)
+	assert.NotNil(t, snap.Spec.SnapshotProperties)
+}
+
+func TestCloneOverrides(t *testing.T) {
+	// a volume clone keeps the recordsize of its source
+	vol := &apis.ZFSVolume{}
+	vol.Spec.RecordSize = "16k"
+	cloneOverrides(vol, map[string]string{})
+	assert.Equal(t, "16k", vol.Spec.RecordSize)
+
+	cloneOverrides(vol, map[string]string{"RecordSize": "1M"})
+	assert.Equal(t, "1M", vol.Spec.RecordSize)
+}
+
+func topologyOf(nodes ...string) []*csi.Topology {
+	var topo []*csi.Topology
+	for _, n := range nodes {
+		topo = append(topo, &csi.Topology{Segments: map[string]string{zfs.ZFSTopologyKey: n}})
+	}
+	return topo
+}
+
+func TestCheckRestoreNode(t *testing.T) {
+	snap := restoreSnapshot()
+
+	req := &csi.CreateVolumeRequest{Name: "pvc-restore"}
+	assert.NoError(t, checkRestoreNode(req, snap), "no topology requirement")
+
+	// the snapshot node is allowed but not the preferred one, e.g. the
+	// pod has been scheduled on another node
+	req.AccessibilityRequirements = &csi.TopologyRequirement{
+		Requisite: topologyOf("node-2", "node-1"),
+		Preferred: topologyOf("node-2", "node-1"),
+	}
+	assert.NoError(t, checkRestoreNode(req, snap))
+
+	// with a strict topology only the node of the pod is allowed
+	req.AccessibilityRequirements = &csi.TopologyRequirement{
+		Requisite: topologyOf("node-2"),
+		Preferred: topologyOf("node-2"),
+	}
+	assert.Equal(t, codes.ResourceExhausted, status.Code(checkRestoreNode(req, snap)))
+}
@@ -0,0 +1,289 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	clientset "github.com/openebs/zfs-localpv/pkg/generated/clientset/internalclientset"
+	"github.com/openebs/zfs-localpv/pkg/zfs"
+	"golang.org/x/net/context"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+	utilmount "k8s.io/utils/mount"
+)
+
+const (
+	// StaleMountOff does not look for stale mounts at startup
+	StaleMountOff = "off"
+	// StaleMountReport logs the stale mounts found at startup
+	StaleMountReport = "report"
+	// StaleMountClean unmounts and removes the stale mounts found at startup
+	StaleMountClean = "clean"
+)
+
+// staleMount is a mount of the driver which is not expected anymore
+type staleMount struct {
+	path   string
+	volume string
+	podUID string
+	reason string
+}
+
+// driverMount is a mount published by kubelet for a volume of the driver
+type driverMount struct {
+	path   string
+	volume string
+	podUID string
+}
+
+// volData is the part of the vol_data.json written by kubelet next to the
+// publish path which is needed to find the driver of the mount
+type volData struct {
+	DriverName   string `json:"driverName"`
+	VolumeHandle string `json:"volumeHandle"`
+}
+
+// mountCleaner looks for the stale mounts of the driver on this node, the
+// mount table, the vol_data.json files and the api server are read through
+// its funcs so that they can be replaced in unit tests
+type mountCleaner struct {
+	mode       string
+	kubeletDir string
+	driverName string
+	nodeID     string
+
+	listMounts  func() ([]utilmount.MountPoint, error)
+	readVolData func(path string) ([]byte, error)
+	listVolumes func() (map[string]*apis.ZFSVolume, error)
+	listPods    func() (map[string]bool, error)
+	cleanup     func(path string) error
+}
+
+// newMountCleaner returns the cleaner of the stale mounts of the driver on
+// this node, doing what the mode says with them. The ZFSVolumes and the pods
+// of the node are listed with the given clients.
+func newMountCleaner(mode, kubeletDir, driverName string,
+	kubeClient kubernetes.Interface, openebsClient clientset.Interface) *mountCleaner {
+	return &mountCleaner{
+		mode:       mode,
+		kubeletDir: kubeletDir,
+		driverName: driverName,
+		nodeID:     zfs.NodeID,
+		listMounts: func() ([]utilmount.MountPoint, error) {
+			return utilmount.New("").List()
+		},
+		readVolData: os.ReadFile,
+		listVolumes: func() (map[string]*apis.ZFSVolume, error) {
+			vols, err := openebsClient.ZfsV1().ZFSVolumes(zfs.OpenEBSNamespace).
+				List(context.TODO(), metav1.ListOptions{})
+			if err != nil {
+				return nil, err
+			}
+			m := make(map[string]*apis.ZFSVolume, len(vols.Items))
+			for i := range vols.Items {
+				m[vols.Items[i].Name] = &vols.Items[i]
+			}
+			return m, nil
+		},
+		listPods: func() (map[string]bool, error) {
+			pods, err := kubeClient.CoreV1().Pods(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{
+				FieldSelector: fields.OneTermEqualSelector("spec.nodeName", zfs.NodeID).String(),
+			})
+			if err != nil {
+				return nil, err
+			}
+			m := make(map[string]bool, len(pods.Items))
+			for _, pod := range pods.Items {
+				m[string(pod.UID)] = true
+			}
+			return m, nil
+		},
+		cleanup: zfs.CleanupMountPoint,
+	}
+}
+
+// validateStaleMountMode returns an error if the mode is not known
+func validateStaleMountMode(mode string) error {
+	switch mode {
+	case StaleMountOff, StaleMountReport, StaleMountClean:
+		return nil
+	}
+	return fmt.Errorf("invalid stale mount cleanup mode %q, it should be one of %s, %s or %s",
+		mode, StaleMountOff, StaleMountReport, StaleMountClean)
+}
+
+// parsePublishPath returns the pod uid, the pv name and the path of the
+// vol_data.json of a path published by kubelet. Filesystem volumes are
+// published at <kubelet>/pods/<uid>/volumes/kubernetes.io~csi/<pv>/mount and
+// block volumes at <kubelet>/plugins/kubernetes.io/csi/volumeDevices/publish/<pv>/<uid>.
+func parsePublishPath(kubeletDir, path string) (string, string, string, bool) {
+	rel, err := filepath.Rel(kubeletDir, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return "", "", "", false
+	}
+	parts := strings.Split(rel, string(filepath.Separator))
+
+	if len(parts) == 6 && parts[0] == "pods" && parts[2] == "volumes" &&
+		parts[3] == "kubernetes.io~csi" && parts[5] == "mount" {
+		uid, pv := parts[1], parts[4]
+		return uid, pv, filepath.Join(kubeletDir, "pods", uid, "volumes", "kubernetes.io~csi", pv, "vol_data.json"), true
+	}
+
+	if len(parts) == 7 && parts[0] == "plugins" && parts[1] == "kubernetes.io" &&
+		parts[2] == "csi" && parts[3] == "volumeDevices" && parts[4] == "publish" {
+		pv, uid := parts[5], parts[6]
+		return uid, pv, filepath.Join(kubeletDir, "plugins", "kubernetes.io", "csi", "volumeDevices", pv, "data", "vol_data.json"), true
+	}
+
+	return "", "", "", false
+}
+
+// driverMounts returns the mounts of the table which kubelet published for
+// the driver. The driver of a mount is taken from the vol_data.json written
+// by kubelet, the mounts of other drivers are never returned.
+func (c *mountCleaner) driverMounts(mps []utilmount.MountPoint) []driverMount {
+	seen := map[string]bool{}
+	var mounts []driverMount
+
+	for _, mp := range mps {
+		path := filepath.Clean(mp.Path)
+		if seen[path] {
+			continue
+		}
+		uid, pv, dataFile, ok := parsePublishPath(c.kubeletDir, path)
+		if !ok {
+			continue
+		}
+
+		buf, err := c.readVolData(dataFile)
+		if err != nil {
+			klog.V(4).Infof("stale mounts: skipping %s, can not read %s: %v", path, dataFile, err)
+			continue
+		}
+		var data volData
+		if err := json.Unmarshal(buf, &data); err != nil {
+			klog.V(4).Infof("stale mounts: skipping %s, invalid %s: %v", path, dataFile, err)
+			continue
+		}
+		if data.DriverName != c.driverName {
+			continue
+		}
+
+		volume := pv
+		if data.VolumeHandle != "" {
+			volume = strings.ToLower(data.VolumeHandle)
+		}
+		seen[path] = true
+		mounts = append(mounts, driverMount{path: path, volume: volume, podUID: uid})
+	}
+
+	sort.Slice(mounts, func(i, j int) bool {
+		return mounts[i].path < mounts[j].path
+	})
+	return mounts
+}
+
+// classifyMounts returns the mounts which are not expected anymore, either
+// the ZFSVolume is gone, it is owned by another node or the pod using the
+// mount is not on this node anymore.
+func classifyMounts(mounts []driverMount, vols map[string]*apis.ZFSVolume, pods map[string]bool, nodeID string) []staleMount {
+	var stale []staleMount
+	for _, m := range mounts {
+		reason := ""
+		vol, ok := vols[m.volume]
+		switch {
+		case !ok:
+			reason = "ZFSVolume not found"
+		case vol.Spec.OwnerNodeID != nodeID:
+			reason = fmt.Sprintf("volume is owned by node %s", vol.Spec.OwnerNodeID)
+		case !pods[m.podUID]:
+			reason = "pod is not on this node"
+		default:
+			continue
+		}
+		stale = append(stale, staleMount{path: m.path, volume: m.volume, podUID: m.podUID, reason: reason})
+	}
+	return stale
+}
+
+// inventoryMounts returns the number of mounts of the driver on this node
+// and the stale ones among them
+func (c *mountCleaner) inventoryMounts() (int, []staleMount, error) {
+	mps, err := c.listMounts()
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to list the mounts: %v", err)
+	}
+	mounts := c.driverMounts(mps)
+	if len(mounts) == 0 {
+		return 0, nil, nil
+	}
+
+	vols, err := c.listVolumes()
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to list the volumes: %v", err)
+	}
+	// the pods are listed after the mount table, a pod mounting a volume
+	// in between is already known to the api server
+	pods, err := c.listPods()
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to list the pods on node %s: %v", c.nodeID, err)
+	}
+
+	return len(mounts), classifyMounts(mounts, vols, pods, c.nodeID), nil
+}
+
+// run inventories the mounts of the driver on this node at startup and, as
+// per the mode, reports or cleans up the stale ones
+func (c *mountCleaner) run() error {
+	if c.mode == StaleMountOff {
+		return nil
+	}
+
+	total, stale, err := c.inventoryMounts()
+	if err != nil {
+		return err
+	}
+	klog.Infof("stale mounts: found %d mounts of %s on node %s, %d stale", total, c.driverName, c.nodeID, len(stale))
+
+	var failed []string
+	for _, m := range stale {
+		if c.mode != StaleMountClean {
+			klog.Warningf("stale mounts: %s of volume %s for pod %s is stale: %s", m.path, m.volume, m.podUID, m.reason)
+			continue
+		}
+		if err := c.cleanup(m.path); err != nil {
+			klog.Errorf("stale mounts: failed to clean up %s of volume %s: %v", m.path, m.volume, err)
+			failed = append(failed, m.path)
+			continue
+		}
+		klog.Infof("stale mounts: cleaned up %s of volume %s for pod %s: %s", m.path, m.volume, m.podUID, m.reason)
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to clean up %d stale mounts: %s", len(failed), strings.Join(failed, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	utilmount "k8s.io/utils/mount"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+)
+
+const (
+	testKubeletDir = "/var/lib/kubelet"
+	testDriver     = "zfs.csi.openebs.io"
+)
+
+func fsMount(uid, pv string) string {
+	return testKubeletDir + "/pods/" + uid + "/volumes/kubernetes.io~csi/" + pv + "/mount"
+}
+
+func fsVolData(uid, pv string) string {
+	return testKubeletDir + "/pods/" + uid + "/volumes/kubernetes.io~csi/" + pv + "/vol_data.json"
+}
+
+func fakeMountCleaner(mode string, mps []utilmount.MountPoint, data map[string]string,
+	vols map[string]*apis.ZFSVolume, pods map[string]bool) (*mountCleaner, *[]string) {
+	var cleaned []string
+	c := &mountCleaner{
+		mode:       mode,
+		kubeletDir: testKubeletDir,
+		driverName: testDriver,
+		nodeID:     "node-1",
+		listMounts: func() ([]utilmount.MountPoint, error) { return mps, nil },
+		readVolData: func(path string) ([]byte, error) {
+			if d, ok := data[path]; ok {
+				return []byte(d), nil
+			}
+			return nil, os.ErrNotExist
+		},
+		listVolumes: func() (map[string]*apis.ZFSVolume, error) { return vols, nil },
+		listPods:    func() (map[string]bool, error) { return pods, nil },
+		cleanup: func(path string) error {
+			cleaned = append(cleaned, path)
+			return nil
+		},
+	}
+	return c, &cleaned
+}
+
+func ownedVolume(name, node string) *apis.ZFSVolume {
+	vol := &apis.ZFSVolume{}
+	vol.Name = name
+	vol.Spec.OwnerNodeID = node
+	return vol
+}
+
+func TestParsePublishPath(t *testing.T) {
+	uid, pv, data, ok := parsePublishPath(testKubeletDir, fsMount("uid-1", "pvc-a"))
+	assert.True(t, ok)
+	assert.Equal(t, "uid-1", uid)
+	assert.Equal(t, "pvc-a", pv)
+	assert.Equal(t, fsVolData("uid-1", "pvc-a"), data)
+
+	uid, pv, data, ok = parsePublishPath(testKubeletDir+"/",
+		testKubeletDir+"/plugins/kubernetes.io/csi/volumeDevices/publish/pvc-b/uid-2")
+	assert.True(t, ok)
+	assert.Equal(t, "uid-2", uid)
+	assert.Equal(t, "pvc-b", pv)
+	assert.Equal(t, testKubeletDir+"/plugins/kubernetes.io/csi/volumeDevices/pvc-b/data/vol_data.json", data)
+
+	for _, path := range []string{
+		"/mnt/data",
+		testKubeletDir + "/pods/uid-1/volumes/kubernetes.io~empty-dir/cache",
+		testKubeletDir + "/pods/uid-1/volumes/kubernetes.io~csi/pvc-a/mount/sub",
+		testKubeletDir + "/plugins/kubernetes.io/csi/volumeDevices/staging/pvc-b",
+	} {
+		_, _, _, ok = parsePublishPath(testKubeletDir, path)
+		assert.False(t, ok, path)
+	}
+}
+
+func TestInventoryMountsScope(t *testing.T) {
+	mps := []utilmount.MountPoint{
+		{Device: "zfspv/pvc-a", Path: fsMount("uid-1", "pvc-a")},
+		// bind mounts show up more than once
+		{Device: "zfspv/pvc-a", Path: fsMount("uid-1", "pvc-a")},
+		// mount of another csi driver
+		{Device: "/dev/sdb", Path: fsMount("uid-1", "pvc-other")},
+		// no vol_data.json
+		{Device: "zfspv/pvc-c", Path: fsMount("uid-1", "pvc-c")},
+		// not below kubelet
+		{Device: "zfspv/pvc-d", Path: "/mnt/pvc-d"},
+	}
+	data := map[string]string{
+		fsVolData("uid-1", "pvc-a"):     `{"driverName":"zfs.csi.openebs.io","volumeHandle":"pvc-a"}`,
+		fsVolData("uid-1", "pvc-other"): `{"driverName":"other.csi.io","volumeHandle":"pvc-other"}`,
+	}
+	c, _ := fakeMountCleaner(StaleMountReport, mps, data, nil, nil)
+
+	total, stale, err := c.inventoryMounts()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, total)
+	assert.Len(t, stale, 1)
+	assert.Equal(t, "pvc-a", stale[0].volume)
+	assert.Equal(t, "ZFSVolume not found", stale[0].reason)
+}
+
+func TestCleanupStaleMounts(t *testing.T) {
+	mps := []utilmount.MountPoint{
+		{Path: fsMount("uid-1", "pvc-a")},
+		{Path: fsMount("uid-1", "pvc-b")},
+		{Path: fsMount("uid-2", "pvc-c")},
+		{Path: fsMount("uid-1", "pvc-d")},
+		{Path: fsMount("uid-1", "pvc-other")},
+	}
+	data := map[string]string{}
+	for _, pv := range []string{"pvc-a", "pvc-b", "pvc-d"} {
+		data[fsVolData("uid-1", pv)] = `{"driverName":"zfs.csi.openebs.io","volumeHandle":"` + pv + `"}`
+	}
+	data[fsVolData("uid-2", "pvc-c")] = `{"driverName":"zfs.csi.openebs.io","volumeHandle":"pvc-c"}`
+	data[fsVolData("uid-1", "pvc-other")] = `{"driverName":"other.csi.io"}`
+
+	vols := map[string]*apis.ZFSVolume{
+		"pvc-a": ownedVolume("pvc-a", "node-1"),
+		"pvc-b": ownedVolume("pvc-b", "node-2"),
+		"pvc-c": ownedVolume("pvc-c", "node-1"),
+	}
+	pods := map[string]bool{"uid-1": true}
+
+	c, cleaned := fakeMountCleaner(StaleMountReport, mps, data, vols, pods)
+
+	_, stale, err := c.inventoryMounts()
+	assert.NoError(t, err)
+	assert.Len(t, stale, 3)
+	assert.Equal(t, "volume is owned by node node-2", stale[0].reason)
+	assert.Equal(t, "ZFSVolume not found", stale[1].reason)
+	assert.Equal(t, "pod is not on this node", stale[2].reason)
+
+	// report only logs the stale mounts
+	assert.NoError(t, c.run())
+	assert.Empty(t, *cleaned)
+
+	c.mode = StaleMountClean
+	assert.NoError(t, c.run())
+	assert.Equal(t, []string{
+		fsMount("uid-1", "pvc-b"),
+		fsMount("uid-1", "pvc-d"),
+		fsMount("uid-2", "pvc-c"),
+	}, *cleaned)
+
+	c.cleanup = func(path string) error { return errors.New("device busy") }
+	assert.Error(t, c.run())
+}
+
+func TestCleanupStaleMountsOff(t *testing.T) {
+	c, _ := fakeMountCleaner(StaleMountOff, nil, nil, nil, nil)
+	c.listMounts = func() ([]utilmount.MountPoint, error) {
+		t.Fatal("mounts should not be listed")
+		return nil, nil
+	}
+	assert.NoError(t, c.run())
+	assert.Error(t, validateStaleMountMode("auto"))
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"github.com/openebs/zfs-localpv/pkg/zfs"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+// policies of the expansion of the thin volumes beyond the size of their
+// pool, the over-subscription of the free space is always allowed
+const (
+	// ThinExpandAllow expands the thin volumes to any size, this is the
+	// default
+	ThinExpandAllow = "allow"
+
+	// ThinExpandWarn expands the thin volumes to any size and logs a
+	// warning when the new size exceeds the size of the pool
+	ThinExpandWarn = "warn"
+
+	// ThinExpandReject fails the expansion of the thin volumes beyond the
+	// size of the pool
+	ThinExpandReject = "reject"
+)
+
+// parseThinExpandPolicy parses the policy of the expansion of the thin
+// volumes, it defaults to allow
+func parseThinExpandPolicy(val string) (string, error) {
+	switch val {
+	case "":
+		return ThinExpandAllow, nil
+	case ThinExpandAllow, ThinExpandWarn, ThinExpandReject:
+		return val, nil
+	}
+	return "", fmt.Errorf("invalid thin expand policy %q, it should be %s, %s or %s",
+		val, ThinExpandAllow, ThinExpandWarn, ThinExpandReject)
+}
+
+// isThinVolume tells whether the volume does not reserve its capacity, the
+// zvols are thick unless asked otherwise while the datasets are thin
+func isThinVolume(vol *apis.ZFSVolume) bool {
+	if vol.Spec.VolumeType == zfs.VolTypeDataset {
+		return vol.Spec.ThinProvision != "no"
+	}
+	return vol.Spec.ThinProvision == "yes"
+}
+
+// checkThinExpand applies the policy to the expansion of a thin volume to
+// size. The thick volumes are checked against the free space by zfs, and
+// the check is skipped if the size of the pool is not known.
+func (cs *controller) checkThinExpand(vol *apis.ZFSVolume, size int64) error {
+	if cs.thinExpand == "" || cs.thinExpand == ThinExpandAllow || !isThinVolume(vol) {
+		return nil
+	}
+	node, err := ownerNode(cs, vol.Spec.OwnerNodeID)
+	if err != nil {
+		klog.Warningf("could not check the expansion of %s against the size of pool %s: %v",
+			vol.Name, vol.Spec.PoolName, err)
+		return nil
+	}
+	p := findPool(node, vol.Spec.PoolName)
+	if p == nil {
+		return nil
+	}
+	total := p.Used.Value() + p.Free.Value()
+	if size <= total {
+		return nil
+	}
+
+	msg := fmt.Sprintf("thin volume %s expanded to %s exceeds the size %s of pool %s on node %s",
+		vol.Name, quantity(size), quantity(total), vol.Spec.PoolName, vol.Spec.OwnerNodeID)
+	if cs.thinExpand == ThinExpandReject {
+		return status.Error(codes.OutOfRange, msg)
+	}
+	klog.Warning(msg)
+	return nil
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"testing"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"github.com/openebs/zfs-localpv/pkg/zfs"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func expandVolume(vtype, thin string) *apis.ZFSVolume {
+	vol := &apis.ZFSVolume{}
+	vol.Name = "pvc-1"
+	vol.Spec.PoolName = "zfspv/volumes"
+	vol.Spec.OwnerNodeID = "node-1"
+	vol.Spec.VolumeType = vtype
+	vol.Spec.ThinProvision = thin
+	return vol
+}
+
+// expandController returns the controller with the policy, node-1 reports
+// a pool of 100Gi, 60Gi of which are used
+func expandController(t *testing.T, policy string) *controller {
+	node := &apis.ZFSNode{}
+	node.Name = "node-1"
+	node.Pools = []apis.Pool{{Name: "zfspv", Used: resource.MustParse("60Gi"), Free: resource.MustParse("40Gi")}}
+	node.PoolAliases = map[string]string{"old-zfspv": "zfspv"}
+	return &controller{thinExpand: policy, zfsNodeInformer: zfsNodeInformer(t, node)}
+}
+
+func TestParseThinExpandPolicy(t *testing.T) {
+	for val, want := range map[string]string{"": ThinExpandAllow, "allow": ThinExpandAllow,
+		"warn": ThinExpandWarn, "reject": ThinExpandReject} {
+		got, err := parseThinExpandPolicy(val)
+		assert.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+	_, err := parseThinExpandPolicy("deny")
+	assert.Error(t, err)
+}
+
+func TestIsThinVolume(t *testing.T) {
+	assert.True(t, isThinVolume(expandVolume(zfs.VolTypeDataset, "")))
+	assert.False(t, isThinVolume(expandVolume(zfs.VolTypeDataset, "no")))
+	assert.True(t, isThinVolume(expandVolume(zfs.VolTypeZVol, "yes")))
+	assert.False(t, isThinVolume(expandVolume(zfs.VolTypeZVol, "")))
+}
+
+func TestCheckThinExpand(t *testing.T) {
+	thin := expandVolume(zfs.VolTypeDataset, "yes")
+	beyond := int64(150 << 30)
+
+	// over-subscribing the free space is fine with every policy
+	for _, policy := range []string{ThinExpandAllow, ThinExpandWarn, ThinExpandReject} {
+		cs := expandController(t, policy)
+		assert.NoError(t, cs.checkThinExpand(thin, 80<<30), policy)
+	}
+
+	assert.NoError(t, expandController(t, ThinExpandAllow).checkThinExpand(thin, beyond))
+	assert.NoError(t, expandController(t, ThinExpandWarn).checkThinExpand(thin, beyond))
+
+	err := expandController(t, ThinExpandReject).checkThinExpand(thin, beyond)
+	assert.Equal(t, codes.OutOfRange, status.Code(err))
+	assert.Contains(t, err.Error(), "exceeds the size 100Gi of pool zfspv/volumes")
+
+	// the thick volumes are checked by zfs against the free space
+	thick := expandVolume(zfs.VolTypeZVol, "no")
+	assert.NoError(t, expandController(t, ThinExpandReject).checkThinExpand(thick, beyond))
+
+	// the pool has been renamed
+	renamed := expandVolume(zfs.VolTypeDataset, "yes")
+	renamed.Spec.PoolName = "old-zfspv/volumes"
+	err = expandController(t, ThinExpandReject).checkThinExpand(renamed, beyond)
+	assert.Equal(t, codes.OutOfRange, status.Code(err))
+
+	// the pool is not reported on the node
+	other := expandVolume(zfs.VolTypeDataset, "")
+	other.Spec.PoolName = "backup"
+	assert.NoError(t, expandController(t, ThinExpandReject).checkThinExpand(other, beyond))
+}
+
+func TestCheckThinExpandUnknownNode(t *testing.T) {
+	cs := &controller{thinExpand: ThinExpandReject, zfsNodeInformer: zfsNodeInformer(t)}
+	assert.NoError(t, cs.checkThinExpand(expandVolume(zfs.VolTypeDataset, ""), 150<<30))
+}
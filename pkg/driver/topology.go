@@ -0,0 +1,93 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"k8s.io/klog/v2"
+
+	zfs "github.com/openebs/zfs-localpv/pkg/zfs"
+)
+
+// nodeTopology returns the topology advertised by the node plugin, it has
+// the node labels allowed by ALLOWED_TOPOLOGIES ("all" allows all of them)
+// and the configured topology keys, along with the driver's node keys.
+func nodeTopology(labels map[string]string, allowed string, keys []string, nodename string) map[string]string {
+	topology := map[string]string{}
+
+	allowed = strings.Trim(allowed, " ")
+	if strings.ToLower(allowed) == "all" {
+		for key, value := range labels {
+			topology[key] = value
+		}
+	} else {
+		for _, key := range strings.Split(allowed, ",") {
+			if key != "" {
+				if value, ok := labels[key]; ok {
+					topology[key] = value
+				} else {
+					klog.Warningf("failed to get value for topology key: %s", key)
+				}
+			}
+		}
+	}
+
+	for _, key := range keys {
+		if value, ok := labels[key]; ok {
+			topology[key] = value
+		} else {
+			klog.Warningf("node %s is not labelled with the topology key %s", nodename, key)
+		}
+	}
+
+	// add driver's topology key if not labelled already
+	if _, ok := topology[zfs.ZFSTopologyKey]; !ok {
+		topology[zfs.ZFSTopologyKey] = nodename
+	}
+	// add old topology key to support backward compatibility for velero
+	topology[zfs.ZFSTopoNodenameKey] = nodename
+
+	return topology
+}
+
+// volumeTopology returns the accessible topology of the volume created on
+// the node. The values of the configured topology keys, e.g. the zone, are
+// taken from the requested topology segment of the node, which the
+// provisioner fills from the topology advertised by the node plugin.
+func volumeTopology(req *csi.CreateVolumeRequest, nodeid string, keys []string) map[string]string {
+	topology := map[string]string{zfs.ZFSTopologyKey: nodeid}
+	if len(keys) == 0 {
+		return topology
+	}
+
+	areq := req.GetAccessibilityRequirements()
+	for _, topo := range append(areq.GetPreferred(), areq.GetRequisite()...) {
+		if topo.GetSegments()[zfs.ZFSTopologyKey] != nodeid {
+			continue
+		}
+		for _, key := range keys {
+			if value, ok := topo.GetSegments()[key]; ok {
+				topology[key] = value
+			}
+		}
+		break
+	}
+
+	return topology
+}
@@ -0,0 +1,143 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/openebs/zfs-localpv/pkg/config"
+	"github.com/openebs/zfs-localpv/pkg/zfs"
+)
+
+const (
+	zoneKey   = "topology.kubernetes.io/zone"
+	regionKey = "topology.kubernetes.io/region"
+)
+
+var nodeLabels = map[string]string{
+	"kubernetes.io/hostname": "node-1",
+	zoneKey:                  "zone-a",
+	regionKey:                "region-1",
+}
+
+func TestNodeTopology(t *testing.T) {
+	topo := nodeTopology(nodeLabels, "kubernetes.io/hostname,", []string{zoneKey, regionKey, "missing"}, "node-1")
+	assert.Equal(t, map[string]string{
+		"kubernetes.io/hostname": "node-1",
+		zoneKey:                  "zone-a",
+		regionKey:                "region-1",
+		zfs.ZFSTopologyKey:       "node-1",
+		zfs.ZFSTopoNodenameKey:   "node-1",
+	}, topo)
+
+	// the node labels are not modified when all of them are allowed
+	topo = nodeTopology(nodeLabels, "All", nil, "node-1")
+	assert.Equal(t, "zone-a", topo[zoneKey])
+	assert.NotContains(t, nodeLabels, zfs.ZFSTopologyKey)
+}
+
+func TestNodeGetInfoTopologyKeys(t *testing.T) {
+	orig := getNode
+	defer func() { getNode = orig }()
+	getNode = func(name string) (*corev1.Node, error) {
+		n := &corev1.Node{}
+		n.Name = name
+		n.Labels = nodeLabels
+		return n, nil
+	}
+	t.Setenv("ALLOWED_TOPOLOGIES", "")
+
+	ns := &node{driver: &CSIDriver{config: &config.Config{
+		Nodename:     "node-1",
+		TopologyKeys: []string{zoneKey, regionKey},
+	}}}
+	resp, err := ns.NodeGetInfo(context.Background(), &csi.NodeGetInfoRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, "node-1", resp.NodeId)
+	assert.Equal(t, map[string]string{
+		zoneKey:                "zone-a",
+		regionKey:              "region-1",
+		zfs.ZFSTopologyKey:     "node-1",
+		zfs.ZFSTopoNodenameKey: "node-1",
+	}, resp.AccessibleTopology.Segments)
+}
+
+func TestDriverNamePropagation(t *testing.T) {
+	const name = "fast.zfs.csi.openebs.io"
+	orig := getNode
+	defer func() { getNode = orig }()
+	getNode = func(name string) (*corev1.Node, error) {
+		n := &corev1.Node{}
+		n.Name = name
+		return n, nil
+	}
+	t.Setenv("ALLOWED_TOPOLOGIES", "")
+	assert.NoError(t, zfs.SetDriverName(name))
+	defer func() { assert.NoError(t, zfs.SetDriverName(zfs.DefaultDriverName)) }()
+
+	d := &CSIDriver{config: &config.Config{DriverName: name, Version: "2.7.0", Nodename: "node-1"}}
+	info, err := NewIdentity(d).GetPluginInfo(context.Background(), &csi.GetPluginInfoRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, name, info.Name)
+
+	ns := &node{driver: d}
+	resp, err := ns.NodeGetInfo(context.Background(), &csi.NodeGetInfoRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		name + "/nodeid":       "node-1",
+		zfs.ZFSTopoNodenameKey: "node-1",
+	}, resp.AccessibleTopology.Segments)
+
+	assert.Equal(t, map[string]string{name + "/nodeid": "node-1"},
+		volumeTopology(&csi.CreateVolumeRequest{}, "node-1", nil))
+}
+
+func TestVolumeTopology(t *testing.T) {
+	req := &csi.CreateVolumeRequest{
+		AccessibilityRequirements: &csi.TopologyRequirement{
+			Requisite: []*csi.Topology{
+				{Segments: map[string]string{zfs.ZFSTopologyKey: "node-1", zoneKey: "zone-a", regionKey: "region-1"}},
+			},
+			Preferred: []*csi.Topology{
+				{Segments: map[string]string{zfs.ZFSTopologyKey: "node-2", zoneKey: "zone-b", regionKey: "region-1"}},
+			},
+		},
+	}
+
+	assert.Equal(t, map[string]string{zfs.ZFSTopologyKey: "node-1"},
+		volumeTopology(req, "node-1", nil))
+
+	assert.Equal(t, map[string]string{
+		zfs.ZFSTopologyKey: "node-1",
+		zoneKey:            "zone-a",
+		regionKey:          "region-1",
+	}, volumeTopology(req, "node-1", []string{zoneKey, regionKey}))
+
+	assert.Equal(t, map[string]string{
+		zfs.ZFSTopologyKey: "node-2",
+		zoneKey:            "zone-b",
+	}, volumeTopology(req, "node-2", []string{zoneKey}))
+
+	// a node without a segment only gets the node key
+	assert.Equal(t, map[string]string{zfs.ZFSTopologyKey: "node-3"},
+		volumeTopology(req, "node-3", []string{zoneKey}))
+}
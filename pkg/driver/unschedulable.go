@@ -0,0 +1,168 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/openebs/zfs-localpv/pkg/zfs"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// responses of the controller when no node can hold the volume
+const (
+	// UnschedulableReschedule fails the creation with ResourceExhausted,
+	// the provisioner then asks for another node for a WaitForFirstConsumer
+	// claim, this is the default
+	UnschedulableReschedule = "reschedule"
+	// UnschedulableRetry fails the creation with Unavailable, the
+	// provisioner retries it on the same node
+	UnschedulableRetry = "retry"
+
+	// maxExcludedNodes is the number of nodes named for each exclusion
+	// reason, the other ones are counted
+	maxExcludedNodes = 5
+)
+
+// unschedulableCode is the code of the error returned when no node can
+// hold the volume
+var unschedulableCode = codes.ResourceExhausted
+
+// setUnschedulableResponse sets the error returned when no node can hold
+// the volume, reschedule or retry
+func setUnschedulableResponse(response string) error {
+	switch response {
+	case UnschedulableReschedule:
+		unschedulableCode = codes.ResourceExhausted
+	case UnschedulableRetry:
+		unschedulableCode = codes.Unavailable
+	default:
+		return fmt.Errorf("invalid unschedulable response %q, it should be %s or %s",
+			response, UnschedulableReschedule, UnschedulableRetry)
+	}
+	return nil
+}
+
+// excluder is a filter which tells why it drops a candidate
+type excluder interface {
+	exclusion(c Candidate) string
+}
+
+func (p performanceClass) exclusion(c Candidate) string {
+	return fmt.Sprintf("pool %s is not of performance class %s", c.Pool, p.class)
+}
+
+func (p poolFeatures) exclusion(c Candidate) string {
+	return fmt.Sprintf("pool %s lacks the features %v", c.Pool, zfs.MissingPoolFeatures(c.Features, p))
+}
+
+func (f fsTypeTools) exclusion(Candidate) string {
+	return fmt.Sprintf("mkfs.%s is not installed", string(f))
+}
+
+func (d datasetLimit) exclusion(c Candidate) string {
+	return fmt.Sprintf("pool %s has reached the limit of %d datasets", c.Pool, int64(d))
+}
+
+func (poolHealth) exclusion(c Candidate) string {
+	return fmt.Sprintf("pool %s is %s", c.Pool, c.Health)
+}
+
+func (g *volumeGroup) exclusion(Candidate) string {
+	if g.policy == VolumeGroupColocate {
+		return fmt.Sprintf("volume group %s is on another node", g.name)
+	}
+	return fmt.Sprintf("the node already holds a volume of group %s", g.name)
+}
+
+func (f *freeSpace) exclusion(c Candidate) string {
+	return fmt.Sprintf("pool %s has less than %s free", c.Pool, quantity(f.size))
+}
+
+// exclusionReasons returns the nodes dropped by each filter, a node is
+// only counted for the first filter dropping it
+func exclusionReasons(req *csi.CreateVolumeRequest, filters []Scheduler, pool string,
+	nodelist []string, cmap map[string]Candidate) map[string][]string {
+	reasons := map[string][]string{}
+	for _, node := range nodelist {
+		c, ok := cmap[node]
+		if !ok {
+			c = Candidate{Node: node, Pool: pool}
+		}
+		for _, f := range filters {
+			if f.Filter(req, c) {
+				continue
+			}
+			reason := "rejected by the scheduler"
+			if e, ok := f.(excluder); ok {
+				reason = e.exclusion(c)
+			}
+			reasons[reason] = append(reasons[reason], node)
+			break
+		}
+	}
+	return reasons
+}
+
+// summarizeExclusions tells how many nodes are available and why the
+// other ones have been dropped, the most common reason first, e.g.
+// 0/3 nodes are available: pool zfspv has less than 10Gi free on node2,
+// node3; mkfs.btrfs is not installed on node1
+func summarizeExclusions(total int, reasons map[string][]string) string {
+	keys := make([]string, 0, len(reasons))
+	for reason := range reasons {
+		keys = append(keys, reason)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if len(reasons[keys[i]]) != len(reasons[keys[j]]) {
+			return len(reasons[keys[i]]) > len(reasons[keys[j]])
+		}
+		return keys[i] < keys[j]
+	})
+
+	parts := make([]string, 0, len(keys))
+	for _, reason := range keys {
+		nodes := reasons[reason]
+		named := strings.Join(nodes, ", ")
+		if len(nodes) > maxExcludedNodes {
+			named = fmt.Sprintf("%s and %d more nodes", strings.Join(nodes[:maxExcludedNodes], ", "),
+				len(nodes)-maxExcludedNodes)
+		}
+		parts = append(parts, reason+" on "+named)
+	}
+	return fmt.Sprintf("0/%d nodes are available: %s", total, strings.Join(parts, "; "))
+}
+
+// noSuitableNode returns the error telling why none of the nodes can hold
+// the volume. The cause, if any, is the error of the filter which dropped
+// the last eligible nodes, it is followed by the reasons of every node.
+func noSuitableNode(req *csi.CreateVolumeRequest, pool string, filters []Scheduler,
+	nodelist []string, cmap map[string]Candidate, cause error) error {
+	msg := fmt.Sprintf("no suitable node for volume %s in pool %s", req.GetName(), pool)
+	if cause != nil {
+		msg = status.Convert(cause).Message()
+	}
+	if len(nodelist) == 0 {
+		return status.Errorf(unschedulableCode, "%s: no node matches the topology of the request", msg)
+	}
+	reasons := exclusionReasons(req, filters, pool, nodelist, cmap)
+	return status.Errorf(unschedulableCode, "%s; %s", msg, summarizeExclusions(len(nodelist), reasons))
+}
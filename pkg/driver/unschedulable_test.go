@@ -0,0 +1,127 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestNoSuitableNode(t *testing.T) {
+	req := &csi.CreateVolumeRequest{Name: "pvc-1"}
+	nodes := []string{"node1", "node2", "node3", "node4"}
+	encrypted := map[string]string{"encryption": "enabled"}
+	plain := map[string]string{"encryption": "disabled"}
+	noTools := []apis.FsTypeTools{{Name: "ext4", Mkfs: true}}
+
+	tests := map[string]struct {
+		filters  []Scheduler
+		cmap     map[string]Candidate
+		cause    error
+		expected string
+	}{
+		"features and free space": {
+			filters: []Scheduler{volumeWeighted{}, poolFeatures{"encryption"}, &freeSpace{size: 10 * Gi, required: true}},
+			cmap: map[string]Candidate{
+				"node1": {Node: "node1", Pool: "zfspv", Features: plain},
+				"node2": {Node: "node2", Pool: "zfspv", Features: plain},
+				"node3": {Node: "node3", Pool: "zfspv", Features: encrypted, Free: freeBytes(2 * Gi)},
+				"node4": {Node: "node4", Pool: "zfspv", Features: plain},
+			},
+			cause: (&freeSpace{size: 10 * Gi}).unschedulable("zfspv", []string{"node3"},
+				map[string]Candidate{"node3": {Free: freeBytes(2 * Gi)}}),
+			expected: "not enough free space in pool zfspv for 10Gi: the largest free space is 2Gi on node node3, 8Gi short; " +
+				"0/4 nodes are available: pool zfspv lacks the features [encryption] on node1, node2, node4; " +
+				"pool zfspv has less than 10Gi free on node3",
+		},
+		"performance class, tools and dataset limit": {
+			filters: []Scheduler{
+				volumeWeighted{},
+				performanceClass{class: "fast", required: true},
+				requiredFsTypeTools(&csi.CreateVolumeRequest{}, "xfs"),
+				datasetLimit(100),
+			},
+			cmap: map[string]Candidate{
+				"node1": {Node: "node1", Pool: "zfspv", PerformanceClass: "slow"},
+				"node2": {Node: "node2", Pool: "zfspv", PerformanceClass: "fast", Datasets: 100},
+				"node3": {Node: "node3", Pool: "zfspv", PerformanceClass: "fast", Datasets: 100},
+				"node4": {Node: "node4", Pool: "zfspv", PerformanceClass: "fast", FsTypes: noTools, Datasets: 10},
+			},
+			expected: "no suitable node for volume pvc-1 in pool zfspv; " +
+				"0/4 nodes are available: pool zfspv has reached the limit of 100 datasets on node2, node3; " +
+				"mkfs.xfs is not installed on node4; pool zfspv is not of performance class fast on node1",
+		},
+		"volume group": {
+			filters: []Scheduler{volumeWeighted{},
+				&volumeGroup{name: "db", policy: VolumeGroupSpread, nodes: map[string]int{"node1": 1, "node2": 1, "node3": 1}},
+				&freeSpace{size: Gi, required: true}},
+			cmap: map[string]Candidate{
+				"node4": {Node: "node4", Pool: "zfspv", Free: freeBytes(0)},
+			},
+			expected: "0/4 nodes are available: the node already holds a volume of group db on node1, node2, node3; " +
+				"pool zfspv has less than 1Gi free on node4",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Empty(t, rankNodes(req, Compose(test.filters...), "zfspv", nodes, test.cmap))
+			err := noSuitableNode(req, "zfspv", test.filters, nodes, test.cmap, test.cause)
+			assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+			assert.Contains(t, err.Error(), test.expected)
+		})
+	}
+}
+
+func TestNoSuitableNodeTopology(t *testing.T) {
+	err := noSuitableNode(&csi.CreateVolumeRequest{Name: "pvc-1"}, "zfspv", nil, nil, nil, nil)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Contains(t, err.Error(), "no suitable node for volume pvc-1 in pool zfspv: no node matches the topology of the request")
+}
+
+func TestNoSuitableNodeManyNodes(t *testing.T) {
+	var nodes []string
+	for i := 1; i <= 8; i++ {
+		nodes = append(nodes, fmt.Sprintf("node%d", i))
+	}
+	cmap := map[string]Candidate{}
+	for _, node := range nodes {
+		cmap[node] = Candidate{Node: node, Pool: "zfspv", FsTypes: []apis.FsTypeTools{{Name: "ext4", Mkfs: true}}}
+	}
+	f := requiredFsTypeTools(&csi.CreateVolumeRequest{}, "btrfs")
+	err := noSuitableNode(&csi.CreateVolumeRequest{Name: "pvc-1"}, "zfspv", []Scheduler{f}, nodes, cmap, nil)
+	assert.Contains(t, err.Error(),
+		"0/8 nodes are available: mkfs.btrfs is not installed on node1, node2, node3, node4, node5 and 3 more nodes")
+}
+
+func TestUnschedulableResponse(t *testing.T) {
+	t.Cleanup(func() { unschedulableCode = codes.ResourceExhausted })
+
+	assert.NoError(t, setUnschedulableResponse(UnschedulableRetry))
+	err := noSuitableNode(&csi.CreateVolumeRequest{Name: "pvc-1"}, "zfspv", nil, nil, nil, nil)
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+
+	assert.NoError(t, setUnschedulableResponse(UnschedulableReschedule))
+	assert.Equal(t, codes.ResourceExhausted, unschedulableCode)
+	assert.Error(t, setUnschedulableResponse("fail"))
+}
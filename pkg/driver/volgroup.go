@@ -0,0 +1,216 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	clientset "github.com/openebs/zfs-localpv/pkg/generated/clientset/internalclientset"
+	"github.com/openebs/zfs-localpv/pkg/zfs"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// VolumeGroupKey is the pvc annotation naming the group of the volume,
+	// the ZFSVolumes of the group are labelled with it. The volumegroup
+	// storageclass parameter sets the group of all its volumes.
+	VolumeGroupKey = "openebs.io/volume-group"
+
+	// VolumeGroupPolicyKey is the pvc annotation setting the placement
+	// policy of the group, the volumegrouppolicy storageclass parameter
+	// sets it for all the volumes of the storageclass
+	VolumeGroupPolicyKey = "openebs.io/volume-group-policy"
+)
+
+// volume group placement policies
+const (
+	// VolumeGroupSpread places each volume of the group on a distinct
+	// node, this is the default
+	VolumeGroupSpread = "spread"
+
+	// VolumeGroupSpreadPools places each volume of the group on a distinct
+	// zpool, a node can hold several volumes of the group on its zpools
+	VolumeGroupSpreadPools = "spread-pools"
+
+	// VolumeGroupColocate places all the volumes of the group on the node
+	// holding the first one
+	VolumeGroupColocate = "colocate"
+)
+
+// groupLocks serialize the placement of the volumes of each group, from
+// the load of the nodes of the group until the ZFSVolume placed is
+// created, so that two volumes of a group created at the same time see
+// each other
+var groupLocks = &volumeLocks{locks: map[string]*volumeLock{}, policy: VolumeLockWait}
+
+// volumeGroup keeps the volumes of a group apart or together as per its
+// policy. It only filters the nodes, the ones left are ranked by the
+// scheduler of the storageclass.
+type volumeGroup struct {
+	name   string
+	policy string
+	// nodes maps the nodes to the number of volumes of the group on them
+	// and pools the node/zpool pairs to the number of volumes on them
+	nodes map[string]int
+	pools map[string]int
+	// client lists the ZFSVolumes of the group
+	client clientset.Interface
+}
+
+// poolKey is the key of the zpool of the node in the pools of the group
+func poolKey(node, pool string) string {
+	return node + "/" + strings.SplitN(pool, "/", 2)[0]
+}
+
+func (g *volumeGroup) Filter(_ *csi.CreateVolumeRequest, c Candidate) bool {
+	switch g.policy {
+	case VolumeGroupColocate:
+		return len(g.nodes) == 0 || g.nodes[c.Node] > 0
+	case VolumeGroupSpreadPools:
+		return g.pools[poolKey(c.Node, c.Pool)] == 0
+	}
+	return g.nodes[c.Node] == 0
+}
+
+func (*volumeGroup) Score(*csi.CreateVolumeRequest, Candidate) int64 { return 0 }
+
+// labels returns the labels of the ZFSVolumes of the group
+func (g *volumeGroup) labels() map[string]string {
+	if g == nil {
+		return nil
+	}
+	return map[string]string{VolumeGroupKey: g.name}
+}
+
+// parseVolumeGroup returns the group of the volume from the pvc
+// annotations, which take precedence over the storageclass parameters.
+// It returns nil if the volume is not part of a group.
+func parseVolumeGroup(params, annotations map[string]string) (*volumeGroup, error) {
+	g := &volumeGroup{name: params["volumegroup"], policy: params["volumegrouppolicy"]}
+	if name, ok := annotations[VolumeGroupKey]; ok {
+		g.name = name
+	}
+	if policy, ok := annotations[VolumeGroupPolicyKey]; ok {
+		g.policy = policy
+	}
+
+	if g.name == "" {
+		if g.policy != "" {
+			return nil, fmt.Errorf("volume group policy %s needs a volume group", g.policy)
+		}
+		return nil, nil
+	}
+	if errs := validation.IsValidLabelValue(g.name); len(errs) != 0 {
+		return nil, fmt.Errorf("invalid volume group %q: %s", g.name, strings.Join(errs, ", "))
+	}
+	switch g.policy {
+	case "":
+		g.policy = VolumeGroupSpread
+	case VolumeGroupSpread, VolumeGroupSpreadPools, VolumeGroupColocate:
+	default:
+		return nil, fmt.Errorf("invalid volume group policy %q, it should be %s, %s or %s",
+			g.policy, VolumeGroupSpread, VolumeGroupSpreadPools, VolumeGroupColocate)
+	}
+	return g, nil
+}
+
+// getVolumeGroup returns the group of the volume asked by the storageclass
+// parameters or the annotations of the pvc. The pvc is known only if the
+// provisioner passes its name, with --extra-create-metadata. The volumes
+// of the group are listed with the client.
+func getVolumeGroup(kubeClient kubernetes.Interface, client clientset.Interface,
+	params map[string]string) (*volumeGroup, error) {
+	var annotations map[string]string
+	ns, name := params["csi.storage.k8s.io/pvc/namespace"], params["csi.storage.k8s.io/pvc/name"]
+	if ns != "" && name != "" {
+		pvc, err := kubeClient.CoreV1().PersistentVolumeClaims(ns).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to get pvc %s/%s: %v", ns, name, err)
+		}
+		annotations = pvc.Annotations
+	}
+
+	g, err := parseVolumeGroup(params, annotations)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if g != nil {
+		g.client = client
+	}
+	return g, nil
+}
+
+// loadNodes finds the nodes holding the volumes of the group. The volume
+// being created, which may be there from a previous attempt, and the
+// volumes being deleted are not counted.
+func (g *volumeGroup) loadNodes(volName string) error {
+	vols, err := g.client.ZfsV1().ZFSVolumes(zfs.OpenEBSNamespace).
+		List(context.TODO(), metav1.ListOptions{LabelSelector: VolumeGroupKey + "=" + g.name})
+	if err != nil {
+		return err
+	}
+	g.nodes, g.pools = map[string]int{}, map[string]int{}
+	for _, vol := range vols.Items {
+		if vol.Name == volName || vol.DeletionTimestamp != nil || vol.Spec.OwnerNodeID == "" {
+			continue
+		}
+		g.nodes[vol.Spec.OwnerNodeID]++
+		g.pools[poolKey(vol.Spec.OwnerNodeID, vol.Spec.PoolName)]++
+	}
+	return nil
+}
+
+// lock takes the placement lock of the group, the function returned
+// releases it
+func (g *volumeGroup) lock(ctx context.Context) (func(), error) {
+	if g == nil {
+		return func() {}, nil
+	}
+	return groupLocks.lock(ctx, g.name, "CreateVolume")
+}
+
+// unschedulable returns the error explaining why none of the nodes can
+// hold the volume of the group
+func (g *volumeGroup) unschedulable(nodelist []string) error {
+	var nodes []string
+	for node := range g.nodes {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+
+	if g.policy == VolumeGroupColocate {
+		return status.Errorf(codes.ResourceExhausted,
+			"volume group %s is co-located on node %s, which can not hold the volume",
+			g.name, strings.Join(nodes, ","))
+	}
+	if g.policy == VolumeGroupSpreadPools {
+		return status.Errorf(codes.ResourceExhausted,
+			"volume group %s needs a distinct zpool for each volume, not enough pools: the zpools of the %d eligible nodes already hold a volume of the group",
+			g.name, len(nodelist))
+	}
+	return status.Errorf(codes.ResourceExhausted,
+		"volume group %s needs a distinct node for each volume, not enough nodes: the %d eligible nodes already hold a volume of the group",
+		g.name, len(nodelist))
+}
@@ -0,0 +1,226 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	openebsfake "github.com/openebs/zfs-localpv/pkg/generated/clientset/internalclientset/fake"
+	"github.com/openebs/zfs-localpv/pkg/zfs"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func groupVolume(name, node string) *apis.ZFSVolume {
+	v := &apis.ZFSVolume{}
+	v.Name = name
+	v.Namespace = "openebs"
+	v.Labels = map[string]string{VolumeGroupKey: "db"}
+	v.Spec.OwnerNodeID = node
+	return v
+}
+
+// groupClient returns the clientset holding the volumes of the groups in
+// the openebs namespace
+func groupClient(t *testing.T, vols ...runtime.Object) *openebsfake.Clientset {
+	orig := zfs.OpenEBSNamespace
+	t.Cleanup(func() { zfs.OpenEBSNamespace = orig })
+	zfs.OpenEBSNamespace = "openebs"
+	return openebsfake.NewSimpleClientset(vols...)
+}
+
+func TestParseVolumeGroup(t *testing.T) {
+	g, err := parseVolumeGroup(map[string]string{}, nil)
+	assert.NoError(t, err)
+	assert.Nil(t, g)
+
+	g, err = parseVolumeGroup(map[string]string{"volumegroup": "db"}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, &volumeGroup{name: "db", policy: VolumeGroupSpread}, g)
+
+	// the pvc annotations take precedence over the storageclass
+	g, err = parseVolumeGroup(map[string]string{"volumegroup": "db", "volumegrouppolicy": "spread"},
+		map[string]string{VolumeGroupKey: "db-0", VolumeGroupPolicyKey: "colocate"})
+	assert.NoError(t, err)
+	assert.Equal(t, &volumeGroup{name: "db-0", policy: VolumeGroupColocate}, g)
+
+	for _, c := range []struct {
+		params, annotations map[string]string
+	}{
+		{params: map[string]string{"volumegroup": "db", "volumegrouppolicy": "pack"}},
+		{params: map[string]string{"volumegroup": "db", "volumegrouppolicy": "spread-nodes"}},
+		{params: map[string]string{"volumegrouppolicy": "spread"}},
+		{params: map[string]string{}, annotations: map[string]string{VolumeGroupKey: "not a label"}},
+	} {
+		_, err := parseVolumeGroup(c.params, c.annotations)
+		assert.Error(t, err, "parseVolumeGroup(%v, %v)", c.params, c.annotations)
+	}
+}
+
+func TestGetVolumeGroup(t *testing.T) {
+	pvc := &corev1.PersistentVolumeClaim{}
+	pvc.Namespace, pvc.Name = "app", "data-0"
+	pvc.Annotations = map[string]string{VolumeGroupKey: "app-data-0"}
+	kubeClient := fake.NewSimpleClientset(pvc)
+	client := groupClient(t)
+
+	// the pvc is not looked up without its name
+	g, err := getVolumeGroup(kubeClient, client, map[string]string{"volumegroup": "db"})
+	assert.NoError(t, err)
+	assert.Equal(t, "db", g.name)
+	assert.Equal(t, client, g.client)
+
+	g, err = getVolumeGroup(kubeClient, client, map[string]string{
+		"csi.storage.k8s.io/pvc/namespace": "app",
+		"csi.storage.k8s.io/pvc/name":      "data-0",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "app-data-0", g.name)
+
+	_, err = getVolumeGroup(kubeClient, client, map[string]string{
+		"csi.storage.k8s.io/pvc/namespace": "app",
+		"csi.storage.k8s.io/pvc/name":      "missing",
+	})
+	assert.Equal(t, codes.Internal, status.Code(err))
+
+	_, err = getVolumeGroup(kubeClient, client, map[string]string{"volumegroup": "db", "volumegrouppolicy": "pack"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestVolumeGroupPlacement(t *testing.T) {
+	deleting := groupVolume("pvc-d", "node3")
+	now := metav1.Now()
+	deleting.DeletionTimestamp = &now
+	other := groupVolume("pvc-o", "node4")
+	other.Labels[VolumeGroupKey] = "web"
+	client := groupClient(t,
+		groupVolume("pvc-a", "node1"),
+		// the volume being created, from a previous attempt
+		groupVolume("pvc-new", "node2"),
+		deleting,
+		other,
+	)
+
+	nodes := []string{"node1", "node2", "node3", "node4"}
+	cmap := map[string]Candidate{
+		"node1": {Node: "node1", Pool: "zfspv", Volumes: 1},
+		"node2": {Node: "node2", Pool: "zfspv", Volumes: 3},
+		"node3": {Node: "node3", Pool: "zfspv", Volumes: 2},
+	}
+	req := &csi.CreateVolumeRequest{Name: "pvc-new"}
+
+	tests := map[string]struct {
+		policy   string
+		nodes    []string
+		expected []string
+	}{
+		"spread": {
+			policy:   VolumeGroupSpread,
+			nodes:    nodes,
+			expected: []string{"node4", "node3", "node2"},
+		},
+		"co-located": {
+			policy:   VolumeGroupColocate,
+			nodes:    nodes,
+			expected: []string{"node1"},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			g := &volumeGroup{name: "db", policy: test.policy, client: client}
+			assert.NoError(t, g.loadNodes(req.Name))
+			got := rankNodes(req, Compose(volumeWeighted{}, g), "zfspv", test.nodes, cmap)
+			assert.Equal(t, test.expected, got)
+		})
+	}
+
+	// the first volume of a co-located group may go anywhere
+	g := &volumeGroup{name: "db", policy: VolumeGroupColocate, client: groupClient(t)}
+	assert.NoError(t, g.loadNodes(req.Name))
+	assert.Equal(t, []string{"node4", "node1", "node3", "node2"},
+		rankNodes(req, Compose(volumeWeighted{}, g), "zfspv", nodes, cmap))
+}
+
+func TestVolumeGroupSpreadPools(t *testing.T) {
+	onPool := groupVolume("pvc-a", "node1")
+	onPool.Spec.PoolName = "fast/tenant-a"
+
+	req := &csi.CreateVolumeRequest{Name: "pvc-new"}
+	g := &volumeGroup{name: "db", policy: VolumeGroupSpreadPools, client: groupClient(t, onPool)}
+	assert.NoError(t, g.loadNodes(req.Name))
+
+	// node1 holds the group on its fast zpool, not on its slow one
+	assert.Equal(t, []string{"node2"}, rankNodes(req, g, "fast/tenant-b", []string{"node1", "node2"}, nil))
+	assert.Equal(t, []string{"node1", "node2"}, rankNodes(req, g, "slow", []string{"node1", "node2"}, nil))
+
+	err := g.unschedulable([]string{"node1"})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Contains(t, err.Error(), "needs a distinct zpool for each volume")
+}
+
+func TestVolumeGroupLock(t *testing.T) {
+	g := &volumeGroup{name: "db", policy: VolumeGroupSpread}
+	unlock, err := g.lock(context.Background())
+	assert.NoError(t, err)
+
+	// a second volume of the group waits for the placement of the first
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = g.lock(ctx)
+	assert.Equal(t, codes.Aborted, status.Code(err))
+
+	// the other groups are not held up
+	other, err := (&volumeGroup{name: "web"}).lock(context.Background())
+	assert.NoError(t, err)
+	other()
+
+	unlock()
+	unlock, err = g.lock(context.Background())
+	assert.NoError(t, err)
+	unlock()
+
+	// a volume out of a group takes no lock
+	var none *volumeGroup
+	unlock, err = none.lock(context.Background())
+	assert.NoError(t, err)
+	unlock()
+}
+
+func TestVolumeGroupUnschedulable(t *testing.T) {
+	g := &volumeGroup{name: "db", policy: VolumeGroupSpread, nodes: map[string]int{"node1": 1, "node2": 1}}
+	assert.Empty(t, rankNodes(&csi.CreateVolumeRequest{}, g, "zfspv", []string{"node1", "node2"}, nil))
+
+	err := g.unschedulable([]string{"node1", "node2"})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Contains(t, err.Error(), "not enough nodes: the 2 eligible nodes already hold a volume of the group")
+
+	g.policy = VolumeGroupColocate
+	err = g.unschedulable([]string{"node3"})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Contains(t, err.Error(), "co-located on node node1,node2")
+	assert.Equal(t, map[string]string{VolumeGroupKey: "db"}, g.labels())
+}
@@ -0,0 +1,134 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+// what the controller does with a mutating operation on a volume which
+// already has one in progress, e.g. an expand racing a snapshot
+const (
+	// VolumeLockWait runs the operations one after the other in the order
+	// they came in, this is the default
+	VolumeLockWait = "wait"
+	// VolumeLockAbort fails the operation with Aborted, the sidecar
+	// retries it once the one in progress is over
+	VolumeLockAbort = "abort"
+)
+
+// the mutating operations of the controller on a volume
+const (
+	opExpand         = "ControllerExpandVolume"
+	opCreateSnapshot = "CreateSnapshot"
+	opDeleteSnapshot = "DeleteSnapshot"
+	opDelete         = "DeleteVolume"
+)
+
+// volumeLock serializes the operations on a volume, the slot is held by
+// the operation in progress and the waiters queue on it
+type volumeLock struct {
+	slot chan struct{}
+	// op is the operation holding the slot
+	op string
+	// refs is the number of operations holding or waiting for the slot,
+	// the lock is dropped once it is 0
+	refs int
+}
+
+// volumeLocks are the locks of the volumes with an operation in progress.
+// Only the mutating operations take them, the reads stay concurrent.
+type volumeLocks struct {
+	mu     sync.Mutex
+	locks  map[string]*volumeLock
+	policy string
+}
+
+// newVolumeLocks returns the locks of the volumes with the policy, wait or
+// abort
+func newVolumeLocks(policy string) (*volumeLocks, error) {
+	switch policy {
+	case VolumeLockWait, VolumeLockAbort:
+	default:
+		return nil, fmt.Errorf("invalid volume lock policy %q, it should be %s or %s",
+			policy, VolumeLockWait, VolumeLockAbort)
+	}
+	return &volumeLocks{locks: map[string]*volumeLock{}, policy: policy}, nil
+}
+
+// lock takes the lock of the volume for the operation and returns the
+// function releasing it, which the caller defers so that it is released on
+// all the paths. With the wait policy, it waits for the operations taken
+// before it, until the context of the call is done.
+func (l *volumeLocks) lock(ctx context.Context, volumeID, op string) (func(), error) {
+	l.mu.Lock()
+	vl, ok := l.locks[volumeID]
+	if !ok {
+		vl = &volumeLock{slot: make(chan struct{}, 1)}
+		l.locks[volumeID] = vl
+	}
+	if l.policy == VolumeLockAbort && vl.refs > 0 {
+		holder := vl.op
+		l.mu.Unlock()
+		return nil, status.Errorf(codes.Aborted,
+			"%s: %s is already in progress on volume %s", op, holder, volumeID)
+	}
+	vl.refs++
+	waiting := vl.refs > 1
+	holder := vl.op
+	if !waiting {
+		// the slot is free, it is taken right below
+		vl.op = op
+	}
+	l.mu.Unlock()
+
+	if waiting {
+		klog.Infof("%s: waiting for %s in progress on volume %s", op, holder, volumeID)
+	}
+	select {
+	case vl.slot <- struct{}{}:
+	case <-ctx.Done():
+		l.release(volumeID, vl)
+		return nil, status.Errorf(codes.Aborted,
+			"%s: gave up waiting for the operations in progress on volume %s: %v", op, volumeID, ctx.Err())
+	}
+	l.mu.Lock()
+	vl.op = op
+	l.mu.Unlock()
+
+	return func() {
+		<-vl.slot
+		l.release(volumeID, vl)
+	}, nil
+}
+
+// release drops a reference to the lock of the volume, the lock is
+// removed once no operation holds or waits for it
+func (l *volumeLocks) release(volumeID string, vl *volumeLock) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	vl.refs--
+	if vl.refs == 0 {
+		delete(l.locks, volumeID)
+	}
+}
@@ -0,0 +1,180 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestVolumeLocksExpandAndSnapshot(t *testing.T) {
+	locks, err := newVolumeLocks(VolumeLockWait)
+	assert.NoError(t, err)
+
+	// the capacity of the volume, which the expand changes and the
+	// snapshot copies
+	var mu sync.Mutex
+	capacity := int64(1 << 30)
+	var order []string
+
+	expanding := make(chan struct{})
+	resume := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		unlock, err := locks.lock(context.Background(), "pvc-1", opExpand)
+		assert.NoError(t, err)
+		defer unlock()
+		close(expanding)
+		<-resume
+		mu.Lock()
+		capacity = 2 << 30
+		order = append(order, opExpand)
+		mu.Unlock()
+	}()
+
+	var snapCapacity int64
+	<-expanding
+	go func() {
+		defer wg.Done()
+		unlock, err := locks.lock(context.Background(), "pvc-1", opCreateSnapshot)
+		assert.NoError(t, err)
+		defer unlock()
+		mu.Lock()
+		snapCapacity = capacity
+		order = append(order, opCreateSnapshot)
+		mu.Unlock()
+	}()
+
+	// the snapshot waits for the expand in progress
+	assert.Eventually(t, func() bool {
+		locks.mu.Lock()
+		defer locks.mu.Unlock()
+		return locks.locks["pvc-1"] != nil && locks.locks["pvc-1"].refs == 2
+	}, time.Second, time.Millisecond)
+	mu.Lock()
+	assert.Empty(t, order)
+	mu.Unlock()
+
+	// another volume is not held back
+	unlock, err := locks.lock(context.Background(), "pvc-2", opCreateSnapshot)
+	assert.NoError(t, err)
+	unlock()
+
+	close(resume)
+	wg.Wait()
+	assert.Equal(t, []string{opExpand, opCreateSnapshot}, order)
+	assert.Equal(t, int64(2<<30), snapCapacity)
+
+	// the lock is dropped once released
+	assert.Empty(t, locks.locks)
+}
+
+func TestVolumeLocksWaitOrder(t *testing.T) {
+	locks, err := newVolumeLocks(VolumeLockWait)
+	assert.NoError(t, err)
+
+	unlock, err := locks.lock(context.Background(), "pvc-1", opExpand)
+	assert.NoError(t, err)
+
+	// the waiters take the lock in the order they came in
+	var mu sync.Mutex
+	var order []string
+	var wg sync.WaitGroup
+	for i, op := range []string{opCreateSnapshot, opExpand, opDelete} {
+		wg.Add(1)
+		go func(op string) {
+			defer wg.Done()
+			unlock, err := locks.lock(context.Background(), "pvc-1", op)
+			assert.NoError(t, err)
+			mu.Lock()
+			order = append(order, op)
+			mu.Unlock()
+			unlock()
+		}(op)
+		assert.Eventually(t, func() bool {
+			locks.mu.Lock()
+			defer locks.mu.Unlock()
+			return locks.locks["pvc-1"].refs == i+2
+		}, time.Second, time.Millisecond)
+	}
+	unlock()
+	wg.Wait()
+	assert.Equal(t, []string{opCreateSnapshot, opExpand, opDelete}, order)
+	assert.Empty(t, locks.locks)
+}
+
+func TestVolumeLocksCanceled(t *testing.T) {
+	locks, err := newVolumeLocks(VolumeLockWait)
+	assert.NoError(t, err)
+
+	unlock, err := locks.lock(context.Background(), "pvc-1", opExpand)
+	assert.NoError(t, err)
+
+	// the waiter gives up with its call
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = locks.lock(ctx, "pvc-1", opCreateSnapshot)
+	assert.Equal(t, codes.Aborted, status.Code(err))
+
+	unlock()
+	assert.Empty(t, locks.locks)
+}
+
+func TestVolumeLocksAbort(t *testing.T) {
+	locks, err := newVolumeLocks(VolumeLockAbort)
+	assert.NoError(t, err)
+
+	unlock, err := locks.lock(context.Background(), "pvc-1", opExpand)
+	assert.NoError(t, err)
+
+	_, err = locks.lock(context.Background(), "pvc-1", opCreateSnapshot)
+	assert.Equal(t, codes.Aborted, status.Code(err))
+	assert.Contains(t, err.Error(), opExpand)
+
+	unlock()
+	unlock, err = locks.lock(context.Background(), "pvc-1", opCreateSnapshot)
+	assert.NoError(t, err)
+	unlock()
+	assert.Empty(t, locks.locks)
+
+	_, err = newVolumeLocks("queue")
+	assert.Error(t, err)
+}
+
+func TestDeleteSnapshotLock(t *testing.T) {
+	locks, err := newVolumeLocks(VolumeLockAbort)
+	assert.NoError(t, err)
+	cs := &controller{locks: locks}
+
+	// the snapshot is not deleted while its volume is being expanded
+	unlock, err := locks.lock(context.Background(), "pvc-1", opExpand)
+	assert.NoError(t, err)
+	defer unlock()
+
+	_, err = cs.DeleteSnapshot(context.Background(), &csi.DeleteSnapshotRequest{SnapshotId: "PVC-1@snap-1"})
+	assert.Equal(t, codes.Aborted, status.Code(err))
+	assert.Contains(t, err.Error(), opDeleteSnapshot)
+}
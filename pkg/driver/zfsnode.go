@@ -0,0 +1,56 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"strings"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"github.com/openebs/zfs-localpv/pkg/zfs"
+)
+
+// ownerNode returns the ZFSNode of the node owning a volume, as cached by
+// the informer of the controller
+func ownerNode(cs *controller, nodeID string) (*apis.ZFSNode, error) {
+	obj, exists, err := cs.zfsNodeInformer.GetIndexer().GetByKey(zfs.OpenEBSNamespace + "/" + nodeID)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("zfsnode %s not found", nodeID)
+	}
+	node, ok := obj.(*apis.ZFSNode)
+	if !ok {
+		return nil, fmt.Errorf("unexpected object %T for zfsnode %s", obj, nodeID)
+	}
+	return node, nil
+}
+
+// findPool returns the zpool holding the pool as reported in the ZFSNode,
+// nil if the node does not have it. The zpool may have been renamed since
+// the pool has been given to a volume.
+func findPool(node *apis.ZFSNode, pool string) *apis.Pool {
+	pool = zfs.ResolvePoolAlias(node.PoolAliases, pool)
+	zpool := strings.SplitN(pool, "/", 2)[0]
+	for i := range node.Pools {
+		if node.Pools[i].Name == zpool {
+			return &node.Pools[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"testing"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"github.com/openebs/zfs-localpv/pkg/zfs"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/tools/cache"
+)
+
+// zfsNodeInformer returns an informer which has cached the ZFSNodes in
+// the openebs namespace
+func zfsNodeInformer(t *testing.T, nodes ...*apis.ZFSNode) cache.SharedIndexInformer {
+	orig := zfs.OpenEBSNamespace
+	t.Cleanup(func() { zfs.OpenEBSNamespace = orig })
+	zfs.OpenEBSNamespace = "openebs"
+
+	informer := cache.NewSharedIndexInformer(&cache.ListWatch{}, &apis.ZFSNode{}, 0, cache.Indexers{})
+	for _, node := range nodes {
+		node.Namespace = zfs.OpenEBSNamespace
+		assert.NoError(t, informer.GetIndexer().Add(node))
+	}
+	return informer
+}
+
+func TestOwnerNode(t *testing.T) {
+	node := &apis.ZFSNode{}
+	node.Name = "node-1"
+	cs := &controller{zfsNodeInformer: zfsNodeInformer(t, node)}
+
+	got, err := ownerNode(cs, "node-1")
+	assert.NoError(t, err)
+	assert.Equal(t, node, got)
+
+	_, err = ownerNode(cs, "node-2")
+	assert.Error(t, err)
+}
+
+func TestFindPool(t *testing.T) {
+	node := &apis.ZFSNode{}
+	node.Pools = []apis.Pool{{Name: "hdd"}, {Name: "nvme"}}
+	node.PoolAliases = map[string]string{"old-hdd": "hdd"}
+
+	assert.Equal(t, &node.Pools[1], findPool(node, "nvme"))
+	assert.Equal(t, &node.Pools[0], findPool(node, "hdd/volumes"))
+	assert.Equal(t, &node.Pools[0], findPool(node, "old-hdd/volumes"))
+	assert.Nil(t, findPool(node, "ssd"))
+}
@@ -0,0 +1,240 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package freeze makes the applications consistent for their snapshots. A
+// ZFSSnapshot selects a plugin by its name, the node agent freezes the
+// application with it, takes the zfs snapshot and thaws the application,
+// whether the snapshot has been taken or not. The plugins register
+// themselves by name, mysql and fsfreeze are shipped with the driver.
+package freeze
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"github.com/openebs/zfs-localpv/pkg/zfs"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// PluginParam is the parameter of the VolumeSnapshotClass selecting
+	// the plugin of the snapshots
+	PluginParam = "freezeplugin"
+
+	// ParamPrefix prefixes the parameters of the VolumeSnapshotClass
+	// passed to the plugin, e.g. freeze.pod
+	ParamPrefix = "freeze."
+
+	// TimeoutParam is the parameter bounding the time the application is
+	// given to freeze, it is handled for all the plugins
+	TimeoutParam = "timeout"
+
+	// DefaultTimeout is used when the timeout is not set
+	DefaultTimeout = 30 * time.Second
+
+	// thawTimeout is the time the application is given to thaw, the
+	// context of the freeze may have expired by then
+	thawTimeout = 30 * time.Second
+)
+
+// Target is the volume of a snapshot to make consistent
+type Target struct {
+	// Snapshot is the name of the ZFSSnapshot
+	Snapshot string
+	// Volume is the name of the ZFSVolume
+	Volume string
+	// VolumeType is DATASET or ZVOL
+	VolumeType string
+	// DevicePath is the device of a zvol, it is empty for a dataset
+	DevicePath string
+	// Params are the parameters of the plugin
+	Params map[string]string
+}
+
+// Plugin freezes an application writing to a volume so that its snapshot
+// is consistent, e.g. by flushing the tables of a database and locking
+// them, and thaws it once the snapshot has been taken
+type Plugin interface {
+	// Name is the name the ZFSSnapshots select the plugin by
+	Name() string
+
+	// Validate checks the parameters of the plugin for the type of the
+	// volume when the snapshot is requested
+	Validate(volType string, params map[string]string) error
+
+	// Freeze quiesces the application until Thaw is called for the same
+	// snapshot
+	Freeze(ctx context.Context, target Target) error
+
+	// Thaw resumes the application. It is called whenever Freeze has been
+	// called, even if Freeze or the snapshot failed, so it has to undo a
+	// partial freeze and do nothing if there is nothing to undo.
+	Thaw(ctx context.Context, target Target) error
+}
+
+var (
+	mu      sync.RWMutex
+	plugins = map[string]Plugin{}
+)
+
+// Register makes a plugin available to the ZFSSnapshots, it panics if a
+// plugin has already been registered with the name. It is meant to be
+// called from the init function of the package of the plugin.
+func Register(p Plugin) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, dup := plugins[p.Name()]; dup {
+		panic("freeze: plugin " + p.Name() + " registered twice")
+	}
+	plugins[p.Name()] = p
+}
+
+// Get returns the plugin registered with the name
+func Get(name string) (Plugin, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	p, ok := plugins[name]
+	return p, ok
+}
+
+// Names returns the names of the registered plugins, sorted
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(plugins))
+	for name := range plugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Parameters returns the plugin and its parameters from the parameters of
+// the VolumeSnapshotClass, the prefix of the parameters is trimmed
+func Parameters(params map[string]string) (string, map[string]string) {
+	var out map[string]string
+	for k, v := range params {
+		if key := strings.TrimPrefix(k, ParamPrefix); key != k && key != "" {
+			if out == nil {
+				out = map[string]string{}
+			}
+			out[key] = v
+		}
+	}
+	return params[PluginParam], out
+}
+
+// parseTimeout parses the timeout of the freeze, it defaults to
+// DefaultTimeout
+func parseTimeout(val string) (time.Duration, error) {
+	if val == "" {
+		return DefaultTimeout, nil
+	}
+	d, err := time.ParseDuration(val)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid freeze timeout %q, it should be a positive duration", val)
+	}
+	return d, nil
+}
+
+// Validate checks that the plugin is registered and that its parameters
+// are valid for the type of the volume
+func Validate(name, volType string, params map[string]string) error {
+	p, ok := Get(name)
+	if !ok {
+		return fmt.Errorf("unknown freeze plugin %q, the plugins are %s", name, strings.Join(Names(), ", "))
+	}
+	if _, err := parseTimeout(params[TimeoutParam]); err != nil {
+		return err
+	}
+	return p.Validate(volType, params)
+}
+
+// ThawError is returned by Run when the snapshot has been taken but the
+// application could not be thawed
+type ThawError struct {
+	Plugin string
+	Err    error
+}
+
+func (e *ThawError) Error() string {
+	return fmt.Sprintf("freeze: plugin %s could not thaw the application: %v", e.Plugin, e.Err)
+}
+
+func (e *ThawError) Unwrap() error {
+	return e.Err
+}
+
+// newTarget returns the volume of the snapshot
+func newTarget(snap *apis.ZFSSnapshot) Target {
+	target := Target{
+		Snapshot:   snap.Name,
+		Volume:     snap.Labels[zfs.ZFSVolKey],
+		VolumeType: snap.Spec.VolumeType,
+		Params:     snap.Spec.FreezeParams,
+	}
+	if snap.Spec.VolumeType != zfs.VolTypeDataset {
+		dataset := strings.SplitN(zfs.SnapshotDataset(snap), "@", 2)[0]
+		target.DevicePath = zfs.ZFSDevPath + dataset
+	}
+	return target
+}
+
+// Run takes the snapshot with take, between the freeze and the thaw of the
+// application by the plugin of the snapshot. The snapshot is taken as it
+// is if it has no plugin. The application is thawed whatever happens once
+// the freeze has started, an error of the thaw alone is a *ThawError.
+func Run(ctx context.Context, snap *apis.ZFSSnapshot, take func() error) (err error) {
+	name := snap.Spec.FreezePlugin
+	if name == "" {
+		return take()
+	}
+	p, ok := Get(name)
+	if !ok {
+		return fmt.Errorf("freeze: unknown plugin %q", name)
+	}
+	timeout, err := parseTimeout(snap.Spec.FreezeParams[TimeoutParam])
+	if err != nil {
+		return err
+	}
+	target := newTarget(snap)
+
+	defer func() {
+		tctx, cancel := context.WithTimeout(context.Background(), thawTimeout)
+		defer cancel()
+		if terr := p.Thaw(tctx, target); terr != nil {
+			klog.Errorf("freeze: plugin %s could not thaw snapshot %s: %v", name, snap.Name, terr)
+			if err == nil {
+				err = &ThawError{Plugin: name, Err: terr}
+			}
+			return
+		}
+		klog.Infof("freeze: plugin %s thawed snapshot %s", name, snap.Name)
+	}()
+
+	fctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if err := p.Freeze(fctx, target); err != nil {
+		return fmt.Errorf("freeze: plugin %s could not freeze snapshot %s: %w", name, snap.Name, err)
+	}
+	klog.Infof("freeze: plugin %s froze snapshot %s", name, snap.Name)
+	return take()
+}
@@ -0,0 +1,491 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package freeze
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"github.com/openebs/zfs-localpv/pkg/zfs"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/mount"
+)
+
+// fakePlugin records the calls of the freeze and of the thaw
+type fakePlugin struct {
+	name      string
+	calls     []string
+	target    Target
+	deadline  bool
+	freezeErr error
+	thawErr   error
+}
+
+func (f *fakePlugin) Name() string {
+	return f.name
+}
+
+func (f *fakePlugin) Validate(volType string, params map[string]string) error {
+	if params["invalid"] != "" {
+		return errors.New("invalid parameter")
+	}
+	return nil
+}
+
+func (f *fakePlugin) Freeze(ctx context.Context, target Target) error {
+	f.calls = append(f.calls, "freeze")
+	f.target = target
+	_, f.deadline = ctx.Deadline()
+	return f.freezeErr
+}
+
+func (f *fakePlugin) Thaw(ctx context.Context, target Target) error {
+	f.calls = append(f.calls, "thaw")
+	return f.thawErr
+}
+
+// registerFake registers a fake plugin for the test
+func registerFake(t *testing.T, name string) *fakePlugin {
+	p := &fakePlugin{name: name}
+	Register(p)
+	t.Cleanup(func() {
+		mu.Lock()
+		delete(plugins, name)
+		mu.Unlock()
+	})
+	return p
+}
+
+func testSnapshot(plugin string, params map[string]string) *apis.ZFSSnapshot {
+	return &apis.ZFSSnapshot{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "snapshot-1",
+			Labels: map[string]string{zfs.ZFSVolKey: "pvc-1"},
+		},
+		Spec: apis.SnapshotSpec{
+			VolumeInfo: apis.VolumeInfo{
+				PoolName:     "zfspv-pool",
+				VolumeType:   zfs.VolTypeZVol,
+				FreezePlugin: plugin,
+				FreezeParams: params,
+			},
+		},
+	}
+}
+
+// take returns a snapshot function recording its call in the plugin
+func take(p *fakePlugin, err error) func() error {
+	return func() error {
+		p.calls = append(p.calls, "snapshot")
+		return err
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	registerFake(t, "fake-b")
+	registerFake(t, "fake-a")
+	if p, ok := Get("fake-a"); !ok || p.Name() != "fake-a" {
+		t.Errorf("Get(fake-a) = %v, %v", p, ok)
+	}
+	if _, ok := Get("missing"); ok {
+		t.Errorf("Get() returned an unregistered plugin")
+	}
+	names := strings.Join(Names(), ",")
+	if !strings.Contains(names, "fake-a,fake-b") || !strings.Contains(names, "mysql") || !strings.Contains(names, "fsfreeze") {
+		t.Errorf("Names() = %s", names)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Register() accepted a duplicate plugin")
+		}
+	}()
+	Register(&fakePlugin{name: "fake-a"})
+}
+
+func TestParameters(t *testing.T) {
+	plugin, params := Parameters(map[string]string{
+		"freezeplugin":  "mysql",
+		"freeze.pod":    "db/mysql-0",
+		"freeze.":       "ignored",
+		"poolname":      "zfspv-pool",
+		"freezetimeout": "ignored",
+	})
+	if plugin != "mysql" || !reflect.DeepEqual(params, map[string]string{"pod": "db/mysql-0"}) {
+		t.Errorf("Parameters() = %q, %v", plugin, params)
+	}
+	if plugin, params := Parameters(map[string]string{"poolname": "zfspv-pool"}); plugin != "" || params != nil {
+		t.Errorf("Parameters() without a plugin = %q, %v", plugin, params)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	registerFake(t, "fake")
+	if err := Validate("fake", zfs.VolTypeZVol, map[string]string{"timeout": "10s"}); err != nil {
+		t.Errorf("Validate() = %v", err)
+	}
+	for name, params := range map[string]map[string]string{
+		"missing": nil,
+		"fake":    {"invalid": "yes"},
+	} {
+		if err := Validate(name, zfs.VolTypeZVol, params); err == nil {
+			t.Errorf("Validate(%s, %v) accepted an invalid plugin", name, params)
+		}
+	}
+	for _, timeout := range []string{"0s", "-1s", "soon"} {
+		if err := Validate("fake", zfs.VolTypeZVol, map[string]string{"timeout": timeout}); err == nil {
+			t.Errorf("Validate() accepted timeout %q", timeout)
+		}
+	}
+}
+
+func TestRunWithoutPlugin(t *testing.T) {
+	taken := false
+	err := Run(context.Background(), testSnapshot("", nil), func() error {
+		taken = true
+		return nil
+	})
+	if err != nil || !taken {
+		t.Errorf("Run() = %v, taken %v", err, taken)
+	}
+}
+
+func TestRunUnknownPlugin(t *testing.T) {
+	taken := false
+	err := Run(context.Background(), testSnapshot("missing", nil), func() error {
+		taken = true
+		return nil
+	})
+	if err == nil || taken {
+		t.Errorf("Run() with an unknown plugin = %v, taken %v", err, taken)
+	}
+}
+
+func TestRunDispatch(t *testing.T) {
+	registerFake(t, "other")
+	p := registerFake(t, "fake")
+	params := map[string]string{"key": "value"}
+	if err := Run(context.Background(), testSnapshot("fake", params), take(p, nil)); err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+	if want := []string{"freeze", "snapshot", "thaw"}; !reflect.DeepEqual(p.calls, want) {
+		t.Errorf("calls = %v, want %v", p.calls, want)
+	}
+	want := Target{
+		Snapshot:   "snapshot-1",
+		Volume:     "pvc-1",
+		VolumeType: zfs.VolTypeZVol,
+		DevicePath: zfs.ZFSDevPath + "zfspv-pool/pvc-1",
+		Params:     params,
+	}
+	if !reflect.DeepEqual(p.target, want) {
+		t.Errorf("target = %+v, want %+v", p.target, want)
+	}
+	if !p.deadline {
+		t.Errorf("the freeze has no timeout")
+	}
+}
+
+func TestRunThawsOnFreezeError(t *testing.T) {
+	p := registerFake(t, "fake")
+	p.freezeErr = errors.New("locked out")
+	err := Run(context.Background(), testSnapshot("fake", nil), take(p, nil))
+	if err == nil || !errors.Is(err, p.freezeErr) {
+		t.Errorf("Run() = %v, want the freeze error", err)
+	}
+	var thawErr *ThawError
+	if errors.As(err, &thawErr) {
+		t.Errorf("Run() returned a thaw error for a failed freeze")
+	}
+	if want := []string{"freeze", "thaw"}; !reflect.DeepEqual(p.calls, want) {
+		t.Errorf("calls = %v, want %v", p.calls, want)
+	}
+}
+
+func TestRunThawsOnSnapshotError(t *testing.T) {
+	p := registerFake(t, "fake")
+	p.thawErr = errors.New("thaw failed")
+	snapErr := errors.New("out of space")
+	err := Run(context.Background(), testSnapshot("fake", nil), take(p, snapErr))
+	if err != snapErr {
+		t.Errorf("Run() = %v, want the snapshot error", err)
+	}
+	if want := []string{"freeze", "snapshot", "thaw"}; !reflect.DeepEqual(p.calls, want) {
+		t.Errorf("calls = %v, want %v", p.calls, want)
+	}
+}
+
+func TestRunThawsOnPanic(t *testing.T) {
+	p := registerFake(t, "fake")
+	defer func() {
+		if recover() == nil {
+			t.Errorf("the panic of the snapshot has been swallowed")
+		}
+		if want := []string{"freeze", "thaw"}; !reflect.DeepEqual(p.calls, want) {
+			t.Errorf("calls = %v, want %v", p.calls, want)
+		}
+	}()
+	_ = Run(context.Background(), testSnapshot("fake", nil), func() error {
+		panic("snapshot")
+	})
+}
+
+func TestRunThawError(t *testing.T) {
+	p := registerFake(t, "fake")
+	p.thawErr = errors.New("thaw failed")
+	err := Run(context.Background(), testSnapshot("fake", nil), take(p, nil))
+	var thawErr *ThawError
+	if !errors.As(err, &thawErr) || thawErr.Plugin != "fake" || !errors.Is(err, p.thawErr) {
+		t.Errorf("Run() = %v, want a thaw error", err)
+	}
+}
+
+func TestRunThawsAfterCancel(t *testing.T) {
+	p := registerFake(t, "fake")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	p.freezeErr = context.Canceled
+	_ = Run(ctx, testSnapshot("fake", nil), take(p, nil))
+	if want := []string{"freeze", "thaw"}; !reflect.DeepEqual(p.calls, want) {
+		t.Errorf("calls = %v, want %v", p.calls, want)
+	}
+}
+
+// fakeSession records the lines sent to the session
+type fakeSession struct {
+	sent    []string
+	waitErr error
+	closed  bool
+}
+
+func (s *fakeSession) Send(line string) error {
+	s.sent = append(s.sent, line)
+	return nil
+}
+
+func (s *fakeSession) WaitFor(ctx context.Context, line string) error {
+	return s.waitErr
+}
+
+func (s *fakeSession) Close(ctx context.Context) error {
+	s.closed = true
+	return nil
+}
+
+func fakeStartSession(t *testing.T, s *fakeSession) *[]string {
+	orig := startSession
+	t.Cleanup(func() { startSession = orig })
+	var exec []string
+	startSession = func(namespace, pod, container string, command []string) (Session, error) {
+		exec = append([]string{namespace, pod, container}, command...)
+		return s, nil
+	}
+	return &exec
+}
+
+// fakeMySQLPod serves the pod db/mysql-0 to the mysql plugin, with the
+// labels given and mounting the claim data bound to the volume given
+func fakeMySQLPod(t *testing.T, labels map[string]string, volume string) {
+	p, _ := Get("mysql")
+	m := p.(*mysql)
+	orig := m.clientset
+	t.Cleanup(func() { m.clientset = orig })
+	cs := fake.NewSimpleClientset(
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "mysql-0", Labels: labels},
+			Spec: corev1.PodSpec{Volumes: []corev1.Volume{{
+				Name: "data",
+				VolumeSource: corev1.VolumeSource{
+					PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "data"},
+				},
+			}}},
+		},
+		&corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "data"},
+			Spec:       corev1.PersistentVolumeClaimSpec{VolumeName: volume},
+		},
+	)
+	m.clientset = func() (kubernetes.Interface, error) { return cs, nil }
+}
+
+func TestMySQLValidate(t *testing.T) {
+	p, _ := Get("mysql")
+	if err := p.Validate(zfs.VolTypeDataset, map[string]string{"pod": "db/mysql-0", "container": "mysql"}); err != nil {
+		t.Errorf("Validate() = %v", err)
+	}
+	for _, params := range []map[string]string{
+		nil,
+		{"pod": "mysql-0"},
+		{"pod": "db/mysql-0", "container": "my sql"},
+		{"pod": "db/mysql-0", "user": "root; rm -rf /"},
+		{"pod": "db/mysql-0", "passwordenv": "$(id)"},
+	} {
+		if err := p.Validate(zfs.VolTypeDataset, params); err == nil {
+			t.Errorf("Validate(%v) accepted invalid parameters", params)
+		}
+	}
+}
+
+func TestMySQLFreezeThaw(t *testing.T) {
+	s := &fakeSession{}
+	exec := fakeStartSession(t, s)
+	fakeMySQLPod(t, map[string]string{ExecLabel: "true"}, "pvc-1")
+	snap := testSnapshot("mysql", map[string]string{"pod": "db/mysql-0", "user": "backup"})
+	snap.Spec.VolumeType = zfs.VolTypeDataset
+	taken := false
+	err := Run(context.Background(), snap, func() error {
+		taken = true
+		if len(s.sent) != 2 || s.closed {
+			t.Errorf("the tables are not locked during the snapshot: %v", s.sent)
+		}
+		return nil
+	})
+	if err != nil || !taken {
+		t.Fatalf("Run() = %v, taken %v", err, taken)
+	}
+	wantExec := []string{"db", "mysql-0", "", "sh", "-c",
+		`exec mysql --batch --skip-column-names --user=backup --password="$MYSQL_ROOT_PASSWORD"`}
+	if !reflect.DeepEqual(*exec, wantExec) {
+		t.Errorf("exec = %q, want %q", *exec, wantExec)
+	}
+	wantSent := []string{"FLUSH TABLES WITH READ LOCK;", "SELECT 'openebs-frozen';", "UNLOCK TABLES;"}
+	if !reflect.DeepEqual(s.sent, wantSent) || !s.closed {
+		t.Errorf("sent %v, closed %v, want %v", s.sent, s.closed, wantSent)
+	}
+}
+
+func TestMySQLClosesOnFreezeError(t *testing.T) {
+	s := &fakeSession{waitErr: context.DeadlineExceeded}
+	fakeStartSession(t, s)
+	fakeMySQLPod(t, map[string]string{ExecLabel: "true"}, "pvc-1")
+	snap := testSnapshot("mysql", map[string]string{"pod": "db/mysql-0", "timeout": "1ms"})
+	taken := false
+	err := Run(context.Background(), snap, func() error {
+		taken = true
+		return nil
+	})
+	if err == nil || taken {
+		t.Errorf("Run() = %v, taken %v", err, taken)
+	}
+	if !s.closed {
+		t.Errorf("the session has not been closed")
+	}
+}
+
+func TestMySQLChecksPod(t *testing.T) {
+	for name, tc := range map[string]struct {
+		labels map[string]string
+		volume string
+	}{
+		"not labelled":   {labels: nil, volume: "pvc-1"},
+		"label not true": {labels: map[string]string{ExecLabel: "false"}, volume: "pvc-1"},
+		"other volume":   {labels: map[string]string{ExecLabel: "true"}, volume: "pvc-2"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			s := &fakeSession{}
+			exec := fakeStartSession(t, s)
+			fakeMySQLPod(t, tc.labels, tc.volume)
+			snap := testSnapshot("mysql", map[string]string{"pod": "db/mysql-0"})
+			taken := false
+			err := Run(context.Background(), snap, func() error {
+				taken = true
+				return nil
+			})
+			if err == nil || taken || len(*exec) != 0 {
+				t.Errorf("Run() = %v, taken %v, exec %q", err, taken, *exec)
+			}
+		})
+	}
+}
+
+func fakeFsfreeze(t *testing.T, mounts []mount.MountPoint, err error) *[]string {
+	origList, origRun := listMounts, runFsfreeze
+	t.Cleanup(func() { listMounts, runFsfreeze = origList, origRun })
+	var calls []string
+	listMounts = func() ([]mount.MountPoint, error) { return mounts, nil }
+	runFsfreeze = func(ctx context.Context, args ...string) error {
+		calls = append(calls, strings.Join(args, " "))
+		return err
+	}
+	return &calls
+}
+
+func TestFsfreeze(t *testing.T) {
+	calls := fakeFsfreeze(t, []mount.MountPoint{
+		{Device: "/dev/sda1", Path: "/"},
+		{Device: zfs.ZFSDevPath + "zfspv-pool/pvc-1", Path: "/var/lib/kubelet/pods/uid/volumes/pvc-1/mount"},
+	}, nil)
+	if err := Run(context.Background(), testSnapshot("fsfreeze", nil), func() error { return nil }); err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+	want := []string{
+		"--freeze /var/lib/kubelet/pods/uid/volumes/pvc-1/mount",
+		"--unfreeze /var/lib/kubelet/pods/uid/volumes/pvc-1/mount",
+	}
+	if !reflect.DeepEqual(*calls, want) {
+		t.Errorf("calls = %v, want %v", *calls, want)
+	}
+}
+
+func TestFsfreezeNotMounted(t *testing.T) {
+	calls := fakeFsfreeze(t, []mount.MountPoint{{Device: "/dev/sda1", Path: "/"}}, nil)
+	if err := Run(context.Background(), testSnapshot("fsfreeze", nil), func() error { return nil }); err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+	if len(*calls) != 0 {
+		t.Errorf("fsfreeze ran for a volume not mounted: %v", *calls)
+	}
+}
+
+func TestFsfreezeFailedFreeze(t *testing.T) {
+	calls := fakeFsfreeze(t, []mount.MountPoint{
+		{Device: zfs.ZFSDevPath + "zfspv-pool/pvc-1", Path: "/mnt/pvc-1"},
+	}, errors.New("device busy"))
+	if err := Run(context.Background(), testSnapshot("fsfreeze", nil), func() error { return nil }); err == nil {
+		t.Errorf("Run() ignored the failed freeze")
+	}
+	// nothing has been frozen, so there is nothing to thaw
+	if want := []string{"--freeze /mnt/pvc-1"}; !reflect.DeepEqual(*calls, want) {
+		t.Errorf("calls = %v, want %v", *calls, want)
+	}
+}
+
+func TestFsfreezeValidate(t *testing.T) {
+	p, _ := Get("fsfreeze")
+	if err := p.Validate(zfs.VolTypeDataset, nil); err == nil {
+		t.Errorf("Validate() accepted a dataset")
+	}
+	if err := p.Validate(zfs.VolTypeZVol, nil); err != nil {
+		t.Errorf("Validate() = %v", err)
+	}
+}
+
+func TestParseTimeout(t *testing.T) {
+	if d, err := parseTimeout(""); err != nil || d != DefaultTimeout {
+		t.Errorf("parseTimeout(\"\") = %v, %v", d, err)
+	}
+	if d, err := parseTimeout("2m"); err != nil || d != 2*time.Minute {
+		t.Errorf("parseTimeout(2m) = %v, %v", d, err)
+	}
+}
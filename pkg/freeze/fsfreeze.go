@@ -0,0 +1,124 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package freeze
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/openebs/zfs-localpv/pkg/zfs"
+	"k8s.io/utils/mount"
+)
+
+// listMounts lists the mounts of the node, can be replaced in unit tests
+var listMounts = func() ([]mount.MountPoint, error) {
+	return mount.New("").List()
+}
+
+// runFsfreeze runs fsfreeze, can be replaced in unit tests
+var runFsfreeze = func(ctx context.Context, args ...string) error {
+	out, err := exec.CommandContext(ctx, "fsfreeze", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("fsfreeze %s failed: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// fsfreeze freezes the filesystem of a zvol mounted on the node, so that
+// its journal is flushed and the snapshot does not need a recovery when it
+// is mounted. The datasets do not need it, zfs snapshots them as they are
+// on disk.
+type fsfreeze struct {
+	mu     sync.Mutex
+	frozen map[string]string
+}
+
+func init() {
+	Register(&fsfreeze{frozen: map[string]string{}})
+}
+
+func (f *fsfreeze) Name() string {
+	return "fsfreeze"
+}
+
+func (f *fsfreeze) Validate(volType string, params map[string]string) error {
+	if volType == zfs.VolTypeDataset {
+		return fmt.Errorf("the fsfreeze freeze plugin only applies to the zvols")
+	}
+	return nil
+}
+
+// resolveDevice returns the device the path links to, the path itself if
+// it can not be resolved
+func resolveDevice(path string) string {
+	if dev, err := filepath.EvalSymlinks(path); err == nil {
+		return dev
+	}
+	return path
+}
+
+// mountPath returns a path the zvol is mounted at on the node, the pods
+// using it have bind mounts of the same filesystem, so any of them will do
+func mountPath(devicePath string) (string, error) {
+	mounts, err := listMounts()
+	if err != nil {
+		return "", err
+	}
+	dev := resolveDevice(devicePath)
+	for _, mp := range mounts {
+		if mp.Device == devicePath || resolveDevice(mp.Device) == dev {
+			return mp.Path, nil
+		}
+	}
+	return "", nil
+}
+
+// Freeze freezes the filesystem of the zvol, there is nothing to freeze if
+// the zvol is not mounted
+func (f *fsfreeze) Freeze(ctx context.Context, target Target) error {
+	path, err := mountPath(target.DevicePath)
+	if err != nil || path == "" {
+		return err
+	}
+	if err := runFsfreeze(ctx, "--freeze", path); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	f.frozen[target.Snapshot] = path
+	f.mu.Unlock()
+	return nil
+}
+
+func (f *fsfreeze) Thaw(ctx context.Context, target Target) error {
+	f.mu.Lock()
+	path, ok := f.frozen[target.Snapshot]
+	f.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	if err := runFsfreeze(ctx, "--unfreeze", path); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	delete(f.frozen, target.Snapshot)
+	f.mu.Unlock()
+	return nil
+}
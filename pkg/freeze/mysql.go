@@ -0,0 +1,173 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package freeze
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"k8s.io/client-go/kubernetes"
+)
+
+// the parameters of the plugins running in the application pod
+const (
+	// PodParam is the namespace/name of the pod running the application
+	PodParam = "pod"
+	// ContainerParam is the container of the pod, the default one if unset
+	ContainerParam = "container"
+)
+
+// the parameters of the mysql plugin
+const (
+	// MySQLUserParam is the user of the session, root if unset
+	MySQLUserParam = "user"
+	// MySQLPasswordEnvParam is the env of the container holding the password
+	// of the user, MYSQL_ROOT_PASSWORD if unset
+	MySQLPasswordEnvParam = "passwordenv"
+)
+
+// mysqlFrozen is printed by the session once the tables are locked
+const mysqlFrozen = "openebs-frozen"
+
+var (
+	mysqlUser    = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,32}$`)
+	envName      = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	k8sName      = regexp.MustCompile(`^[a-z0-9]([-a-z0-9.]*[a-z0-9])?$`)
+	k8sContainer = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+)
+
+// mysql flushes the tables of a MySQL or MariaDB server and holds a read
+// lock on them while the snapshot is taken. The lock belongs to the
+// session of the mysql client run in the application pod, which is kept
+// open until the thaw.
+type mysql struct {
+	mu       sync.Mutex
+	sessions map[string]Session
+
+	// clientset returns the client used to check the pod
+	clientset func() (kubernetes.Interface, error)
+}
+
+func init() {
+	Register(&mysql{sessions: map[string]Session{}, clientset: clientset})
+}
+
+func (m *mysql) Name() string {
+	return "mysql"
+}
+
+// podParams returns the namespace, the name and the container of the pod
+// of the application
+func podParams(params map[string]string) (string, string, string, error) {
+	ns, name, ok := strings.Cut(params[PodParam], "/")
+	if !ok || !k8sName.MatchString(ns) || !k8sName.MatchString(name) {
+		return "", "", "", fmt.Errorf("invalid freeze parameter %s %q, it should be namespace/name", PodParam, params[PodParam])
+	}
+	container := params[ContainerParam]
+	if container != "" && !k8sContainer.MatchString(container) {
+		return "", "", "", fmt.Errorf("invalid freeze parameter %s %q", ContainerParam, container)
+	}
+	return ns, name, container, nil
+}
+
+// mysqlCommand returns the command running the client in the container,
+// the parameters are checked as they are part of a shell command
+func mysqlCommand(params map[string]string) ([]string, error) {
+	user := params[MySQLUserParam]
+	if user == "" {
+		user = "root"
+	}
+	if !mysqlUser.MatchString(user) {
+		return nil, fmt.Errorf("invalid freeze parameter %s %q", MySQLUserParam, user)
+	}
+	env := params[MySQLPasswordEnvParam]
+	if env == "" {
+		env = "MYSQL_ROOT_PASSWORD"
+	}
+	if !envName.MatchString(env) {
+		return nil, fmt.Errorf("invalid freeze parameter %s %q", MySQLPasswordEnvParam, env)
+	}
+	return []string{"sh", "-c",
+		fmt.Sprintf(`exec mysql --batch --skip-column-names --user=%s --password="$%s"`, user, env)}, nil
+}
+
+func (m *mysql) Validate(volType string, params map[string]string) error {
+	if _, _, _, err := podParams(params); err != nil {
+		return err
+	}
+	_, err := mysqlCommand(params)
+	return err
+}
+
+// Freeze opens a session and locks the tables, it returns once the lock is
+// held
+func (m *mysql) Freeze(ctx context.Context, target Target) error {
+	ns, pod, container, err := podParams(target.Params)
+	if err != nil {
+		return err
+	}
+	command, err := mysqlCommand(target.Params)
+	if err != nil {
+		return err
+	}
+	cs, err := m.clientset()
+	if err != nil {
+		return err
+	}
+	if err := checkPod(ctx, cs, ns, pod, target.Volume); err != nil {
+		return err
+	}
+	s, err := startSession(ns, pod, container, command)
+	if err != nil {
+		return fmt.Errorf("could not exec mysql in pod %s/%s: %v", ns, pod, err)
+	}
+	// the session is closed by the thaw, even if the lock is not held
+	m.mu.Lock()
+	m.sessions[target.Snapshot] = s
+	m.mu.Unlock()
+
+	if err := s.Send("FLUSH TABLES WITH READ LOCK;"); err != nil {
+		return err
+	}
+	if err := s.Send("SELECT '" + mysqlFrozen + "';"); err != nil {
+		return err
+	}
+	if err := s.WaitFor(ctx, mysqlFrozen); err != nil {
+		return fmt.Errorf("could not lock the tables in pod %s/%s: %v", ns, pod, err)
+	}
+	return nil
+}
+
+// Thaw unlocks the tables and closes the session, the lock is released
+// with the session if the unlock can not be sent
+func (m *mysql) Thaw(ctx context.Context, target Target) error {
+	m.mu.Lock()
+	s, ok := m.sessions[target.Snapshot]
+	delete(m.sessions, target.Snapshot)
+	m.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	serr := s.Send("UNLOCK TABLES;")
+	if err := s.Close(ctx); err != nil {
+		return err
+	}
+	return serr
+}
@@ -0,0 +1,208 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package freeze
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	k8sapi "github.com/openebs/lib-csi/pkg/client/k8s"
+	corev1 "k8s.io/api/core/v1"
+	k8serror "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// ExecLabel has to be set to "true" on the pods the node agent may exec
+// into to freeze the application, e.g. with the mysql plugin
+const ExecLabel = "openebs.io/freeze-exec"
+
+// clientset returns the client of the cluster
+func clientset() (kubernetes.Interface, error) {
+	return k8sapi.Clientset().Get()
+}
+
+// checkPod checks that the node agent may exec into the pod to freeze the
+// application writing to the volume: the pod has to carry ExecLabel and to
+// mount the volume through a claim, a pvc or a generic ephemeral volume.
+func checkPod(ctx context.Context, cs kubernetes.Interface, namespace, name, volume string) error {
+	pod, err := cs.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("could not get pod %s/%s: %v", namespace, name, err)
+	}
+	if pod.Labels[ExecLabel] != "true" {
+		return fmt.Errorf("pod %s/%s is not labelled with %s=true", namespace, name, ExecLabel)
+	}
+	for _, v := range pod.Spec.Volumes {
+		var claim string
+		switch {
+		case v.PersistentVolumeClaim != nil:
+			claim = v.PersistentVolumeClaim.ClaimName
+		case v.Ephemeral != nil:
+			claim = pod.Name + "-" + v.Name
+		default:
+			continue
+		}
+		pvc, err := cs.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, claim, metav1.GetOptions{})
+		if err != nil {
+			if k8serror.IsNotFound(err) {
+				continue
+			}
+			return fmt.Errorf("could not get pvc %s/%s of pod %s: %v", namespace, claim, name, err)
+		}
+		if pvc.Spec.VolumeName == volume {
+			return nil
+		}
+	}
+	return fmt.Errorf("pod %s/%s does not use volume %s", namespace, name, volume)
+}
+
+// Session is a command running in a container of the application pod,
+// e.g. the client of a database, kept open from the freeze to the thaw as
+// the locks of the application are released with the session
+type Session interface {
+	// Send writes a line to the input of the command
+	Send(line string) error
+
+	// WaitFor reads the output of the command until the line
+	WaitFor(ctx context.Context, line string) error
+
+	// Close closes the input of the command and waits for it to exit
+	Close(ctx context.Context) error
+}
+
+// startSession starts the command in the container of the pod, the
+// container is the default one of the pod if it is empty. Can be replaced
+// in unit tests.
+var startSession = func(namespace, pod, container string, command []string) (Session, error) {
+	config, err := k8sapi.Config().Get()
+	if err != nil {
+		return nil, err
+	}
+	cs, err := k8sapi.Clientset().Get()
+	if err != nil {
+		return nil, err
+	}
+	req := cs.CoreV1().RESTClient().Post().
+		Resource("pods").Namespace(namespace).Name(pod).SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: container,
+			Command:   command,
+			Stdin:     true,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+	executor, err := remotecommand.NewSPDYExecutor(config, "POST", req.URL())
+	if err != nil {
+		return nil, err
+	}
+
+	// the session outlives the context of the freeze, it ends with Close
+	ctx, cancel := context.WithCancel(context.Background())
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	s := &execSession{
+		stdin:  inW,
+		lines:  make(chan string, 16),
+		done:   make(chan struct{}),
+		cancel: cancel,
+	}
+	go func() {
+		defer cancel()
+		s.err = executor.StreamWithContext(ctx, remotecommand.StreamOptions{
+			Stdin:  inR,
+			Stdout: outW,
+			Stderr: &s.stderr,
+		})
+		outW.Close()
+		inR.Close()
+		close(s.done)
+	}()
+	go s.read(outR)
+	return s, nil
+}
+
+// execSession is a command run by the exec subresource of a pod
+type execSession struct {
+	stdin  *io.PipeWriter
+	lines  chan string
+	done   chan struct{}
+	cancel context.CancelFunc
+
+	// err and stderr are set once done is closed
+	err    error
+	stderr strings.Builder
+}
+
+func (s *execSession) read(out io.Reader) {
+	scanner := bufio.NewScanner(out)
+	for scanner.Scan() {
+		s.lines <- scanner.Text()
+	}
+	close(s.lines)
+}
+
+// exitError returns the reason the command has exited
+func (s *execSession) exitError() error {
+	<-s.done
+	if s.err != nil {
+		return fmt.Errorf("%v: %s", s.err, strings.TrimSpace(s.stderr.String()))
+	}
+	return fmt.Errorf("the command has exited: %s", strings.TrimSpace(s.stderr.String()))
+}
+
+func (s *execSession) Send(line string) error {
+	_, err := io.WriteString(s.stdin, line+"\n")
+	return err
+}
+
+func (s *execSession) WaitFor(ctx context.Context, line string) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case l, ok := <-s.lines:
+			if !ok {
+				return s.exitError()
+			}
+			if strings.TrimSpace(l) == line {
+				return nil
+			}
+		}
+	}
+}
+
+func (s *execSession) Close(ctx context.Context) error {
+	s.stdin.Close()
+	// drain the output so that the command is not blocked writing it
+	go func() {
+		for range s.lines {
+		}
+	}()
+	select {
+	case <-s.done:
+		return s.err
+	case <-ctx.Done():
+		s.cancel()
+		return ctx.Err()
+	}
+}
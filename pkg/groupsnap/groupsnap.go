@@ -0,0 +1,376 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package groupsnap snapshots all the volumes of a group of claims, e.g.
+// the PVCs of a StatefulSet, close together. The claims are resolved to
+// their ZFSVolumes, the workload can be quiesced by a hook and a
+// ZFSSnapshot is created for every volume at once, so that the node agents
+// owning the volumes take them in parallel. The result reports every
+// snapshot along with the skew between the first and the last one.
+package groupsnap
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"github.com/openebs/zfs-localpv/pkg/zfs"
+	k8serror "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+)
+
+// GroupLabel is set on the ZFSSnapshots of a group, its value is the
+// name of the group
+const GroupLabel = "openebs.io/snapshot-group"
+
+// Phase is the phase of a snapshot of the group
+type Phase string
+
+const (
+	// PhasePending means the snapshot has not been taken yet
+	PhasePending Phase = "Pending"
+	// PhaseReady means the snapshot has been taken
+	PhaseReady Phase = "Ready"
+	// PhaseFailed means the snapshot could not be taken, Reason has the details
+	PhaseFailed Phase = "Failed"
+)
+
+// Claim is a PVC of the group along with its ZFSVolume
+type Claim struct {
+	// Name is the namespace/name of the PVC
+	Name string
+	// Volume is the ZFSVolume bound to the PVC
+	Volume *apis.ZFSVolume
+}
+
+// SnapshotStatus is the status of the snapshot of a single volume
+type SnapshotStatus struct {
+	// Claim is the namespace/name of the PVC
+	Claim string
+	// Volume is the name of the ZFSVolume
+	Volume string
+	// Node is the node id owning the volume, which takes the snapshot
+	Node string
+	// Snapshot is the name of the ZFSSnapshot
+	Snapshot string
+	// Phase is the current phase of the snapshot
+	Phase Phase
+	// CreationTime is the time the zfs snapshot has been taken at
+	CreationTime time.Time
+	// Reason explains why the snapshot failed
+	Reason string
+}
+
+// Result is the result of snapshotting a group
+type Result struct {
+	// Group is the name of the group
+	Group string
+	// Snapshots has the status of the snapshot of every volume
+	Snapshots []SnapshotStatus
+	// Skew is the time between the first and the last snapshot taken
+	Skew time.Duration
+}
+
+// Complete returns true if the snapshots of all the volumes have been taken
+func (r *Result) Complete() bool {
+	for _, s := range r.Snapshots {
+		if s.Phase != PhaseReady {
+			return false
+		}
+	}
+	return true
+}
+
+// Failures returns the snapshots which could not be taken
+func (r *Result) Failures() []SnapshotStatus {
+	var failed []SnapshotStatus
+	for _, s := range r.Snapshots {
+		if s.Phase != PhaseReady {
+			failed = append(failed, s)
+		}
+	}
+	return failed
+}
+
+// aggregate builds the result of the group from the snapshot statuses, the
+// skew only counts the snapshots which have been taken
+func aggregate(group string, snaps []SnapshotStatus) *Result {
+	r := &Result{Group: group, Snapshots: snaps}
+	var first, last time.Time
+	for _, s := range snaps {
+		if s.Phase != PhaseReady || s.CreationTime.IsZero() {
+			continue
+		}
+		if first.IsZero() || s.CreationTime.Before(first) {
+			first = s.CreationTime
+		}
+		if s.CreationTime.After(last) {
+			last = s.CreationTime
+		}
+	}
+	r.Skew = last.Sub(first)
+	return r
+}
+
+// Hook is run before or after the snapshots, e.g. to freeze and thaw the
+// filesystems of the workload
+type Hook func(ctx context.Context) error
+
+// Snapshotter snapshots all the volumes of a group of claims
+type Snapshotter struct {
+	// Name of the group, the snapshots are named <name>-<volume>
+	Name string
+
+	resolve        func(ctx context.Context) ([]Claim, error)
+	createSnapshot func(snap *apis.ZFSSnapshot) error
+	getSnapshot    func(name string) (*apis.ZFSSnapshot, error)
+	deleteSnapshot func(name string) error
+
+	pre, post       Hook
+	timeout         time.Duration
+	pollInterval    time.Duration
+	deleteOnFailure bool
+}
+
+func newSnapshotter(name string, resolve func(ctx context.Context) ([]Claim, error)) *Snapshotter {
+	return &Snapshotter{
+		Name:           name,
+		resolve:        resolve,
+		createSnapshot: zfs.ProvisionSnapshot,
+		getSnapshot:    zfs.GetZFSSnapshot,
+		deleteSnapshot: zfs.DeleteSnapshot,
+		timeout:        5 * time.Minute,
+		pollInterval:   time.Second,
+	}
+}
+
+// NewStatefulSetSnapshotter returns a snapshotter for the PVCs created
+// from the volumeClaimTemplates of the StatefulSet
+func NewStatefulSetSnapshotter(name, namespace, statefulSet string) *Snapshotter {
+	return newSnapshotter(name, func(ctx context.Context) ([]Claim, error) {
+		return resolveStatefulSet(ctx, namespace, statefulSet)
+	})
+}
+
+// NewSelectorSnapshotter returns a snapshotter for the PVCs of the
+// namespace matching the label selector
+func NewSelectorSnapshotter(name, namespace string, selector labels.Selector) *Snapshotter {
+	return newSnapshotter(name, func(ctx context.Context) ([]Claim, error) {
+		return resolveSelector(ctx, namespace, selector)
+	})
+}
+
+// NewVolumeSnapshotter returns a snapshotter for the given ZFSVolumes,
+// e.g. the volumes of a VolumeGroupSnapshot
+func NewVolumeSnapshotter(name string, volumes []string) *Snapshotter {
+	return newSnapshotter(name, func(ctx context.Context) ([]Claim, error) {
+		return resolveVolumes(volumes)
+	})
+}
+
+// WithHooks sets the hooks run before the snapshots are created and once
+// they have all been taken or have failed. The post hook is run whenever
+// the pre hook has succeeded.
+func (s *Snapshotter) WithHooks(pre, post Hook) *Snapshotter {
+	s.pre, s.post = pre, post
+	return s
+}
+
+// WithTimeout sets the time the snapshots have to be taken within
+func (s *Snapshotter) WithTimeout(timeout time.Duration) *Snapshotter {
+	s.timeout = timeout
+	return s
+}
+
+// WithDeleteOnFailure deletes the snapshots of the group unless all of
+// them have been taken
+func (s *Snapshotter) WithDeleteOnFailure() *Snapshotter {
+	s.deleteOnFailure = true
+	return s
+}
+
+// Snapshot snapshots all the volumes of the group. The error is returned
+// if the group could not be resolved or a hook failed, the snapshots which
+// could not be taken are reported in the result.
+func (s *Snapshotter) Snapshot(ctx context.Context) (*Result, error) {
+	claims, err := s.resolve(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("groupsnap: failed to resolve group %s: %w", s.Name, err)
+	}
+	if len(claims) == 0 {
+		return nil, fmt.Errorf("groupsnap: group %s has no claims", s.Name)
+	}
+
+	if s.pre != nil {
+		if err = s.pre(ctx); err != nil {
+			return nil, fmt.Errorf("groupsnap: pre hook of group %s failed: %w", s.Name, err)
+		}
+	}
+
+	snaps := s.create(claims)
+	s.wait(ctx, snaps)
+
+	var hookErr error
+	if s.post != nil {
+		if hookErr = s.post(ctx); hookErr != nil {
+			hookErr = fmt.Errorf("groupsnap: post hook of group %s failed: %w", s.Name, hookErr)
+		}
+	}
+
+	result := aggregate(s.Name, snaps)
+	if result.Complete() {
+		klog.Infof("groupsnap: group %s snapshotted %d volumes with a skew of %s", s.Name, len(snaps), result.Skew)
+	} else {
+		klog.Errorf("groupsnap: group %s failed to snapshot %d of %d volumes", s.Name, len(result.Failures()), len(snaps))
+		if s.deleteOnFailure {
+			s.cleanup(snaps)
+		}
+	}
+	return result, hookErr
+}
+
+// create creates the ZFSSnapshots of all the volumes at once, the node
+// agent owning each volume takes its snapshot
+func (s *Snapshotter) create(claims []Claim) []SnapshotStatus {
+	snaps := make([]SnapshotStatus, 0, len(claims))
+	for _, c := range claims {
+		st := SnapshotStatus{
+			Claim:    c.Name,
+			Volume:   c.Volume.Name,
+			Node:     c.Volume.Spec.OwnerNodeID,
+			Snapshot: s.Name + "-" + c.Volume.Name,
+			Phase:    PhasePending,
+		}
+
+		snap := &apis.ZFSSnapshot{}
+		snap.Name = st.Snapshot
+		snap.Namespace = zfs.OpenEBSNamespace
+		snap.Labels = map[string]string{
+			zfs.ZFSVolKey: c.Volume.Name,
+			GroupLabel:    s.Name,
+		}
+		snap.Spec.VolumeInfo = c.Volume.Spec
+		snap.Status.State = zfs.ZFSStatusPending
+		err := s.createSnapshot(snap)
+		if k8serror.IsAlreadyExists(err) {
+			// the group is being taken again, the snapshot is polled
+			err = nil
+		}
+		if err != nil {
+			st.Phase, st.Reason = PhaseFailed, err.Error()
+			klog.Errorf("groupsnap: failed to create snapshot %s of claim %s: %v", st.Snapshot, c.Name, err)
+		}
+		snaps = append(snaps, st)
+	}
+	return snaps
+}
+
+// poll gets the pending snapshots once, it returns the number of snapshots
+// still pending
+func (s *Snapshotter) poll(snaps []SnapshotStatus) int {
+	pending := 0
+	for i := range snaps {
+		st := &snaps[i]
+		if st.Phase != PhasePending {
+			continue
+		}
+		snap, err := s.getSnapshot(st.Snapshot)
+		if err != nil {
+			klog.Warningf("groupsnap: failed to get snapshot %s: %v", st.Snapshot, err)
+			pending++
+			continue
+		}
+		switch snap.Status.State {
+		case zfs.ZFSStatusReady:
+			st.Phase = PhaseReady
+			if snap.Status.CreationTime != nil {
+				st.CreationTime = snap.Status.CreationTime.Time
+			}
+		case zfs.ZFSStatusFailed:
+			st.Phase, st.Reason = PhaseFailed, snap.Status.Error
+		default:
+			pending++
+		}
+	}
+	return pending
+}
+
+// wait polls the snapshots until they have all been taken or have failed,
+// the ones still pending at the timeout are failed
+func (s *Snapshotter) wait(ctx context.Context, snaps []SnapshotStatus) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	for {
+		if s.poll(snaps) == 0 {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			for i := range snaps {
+				if snaps[i].Phase == PhasePending {
+					snaps[i].Phase = PhaseFailed
+					snaps[i].Reason = fmt.Sprintf("not taken on node %s: %v", snaps[i].Node, ctx.Err())
+				}
+			}
+			return
+		case <-time.After(s.pollInterval):
+		}
+	}
+}
+
+// Status creates the snapshots of the group which do not exist yet and
+// returns their status without waiting for them, the hooks are not run.
+// It is called again until the result is complete, e.g. by the
+// csi-snapshotter for a VolumeGroupSnapshot. A failed snapshot fails the
+// group, its snapshots are then deleted if asked to.
+func (s *Snapshotter) Status(ctx context.Context) (*Result, error) {
+	claims, err := s.resolve(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("groupsnap: failed to resolve group %s: %w", s.Name, err)
+	}
+	if len(claims) == 0 {
+		return nil, fmt.Errorf("groupsnap: group %s has no claims", s.Name)
+	}
+
+	snaps := s.create(claims)
+	s.poll(snaps)
+
+	result := aggregate(s.Name, snaps)
+	for _, st := range snaps {
+		if st.Phase == PhaseFailed {
+			if s.deleteOnFailure {
+				s.cleanup(snaps)
+			}
+			break
+		}
+	}
+	return result, nil
+}
+
+// cleanup deletes the snapshots created for the group, a snapshot which
+// could not be created is not found and skipped by the delete
+func (s *Snapshotter) cleanup(snaps []SnapshotStatus) {
+	for _, st := range snaps {
+		klog.Infof("groupsnap: deleting snapshot %s of failed group %s", st.Snapshot, s.Name)
+		if err := s.deleteSnapshot(st.Snapshot); err != nil && !k8serror.IsNotFound(err) {
+			klog.Errorf("groupsnap: failed to delete snapshot %s: %v", st.Snapshot, err)
+		}
+	}
+}
@@ -0,0 +1,332 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupsnap
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"github.com/openebs/zfs-localpv/pkg/zfs"
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serror "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func vol(name, node string) *apis.ZFSVolume {
+	v := &apis.ZFSVolume{}
+	v.Name = name
+	v.Spec.OwnerNodeID = node
+	v.Spec.PoolName = "zfspv"
+	return v
+}
+
+func pvc(name, pv string) corev1.PersistentVolumeClaim {
+	c := corev1.PersistentVolumeClaim{}
+	c.Namespace, c.Name = "db", name
+	c.Spec.VolumeName = pv
+	return c
+}
+
+func TestStatefulSetClaims(t *testing.T) {
+	replicas := int32(2)
+	sts := &appsv1.StatefulSet{}
+	sts.Name = "mysql"
+	sts.Spec.Replicas = &replicas
+	sts.Spec.VolumeClaimTemplates = []corev1.PersistentVolumeClaim{{}, {}}
+	sts.Spec.VolumeClaimTemplates[0].Name = "data"
+	sts.Spec.VolumeClaimTemplates[1].Name = "logs"
+
+	assert.Equal(t, []string{"data-mysql-0", "logs-mysql-0", "data-mysql-1", "logs-mysql-1"},
+		statefulSetClaims(sts))
+
+	// one replica by default
+	sts.Spec.Replicas = nil
+	assert.Equal(t, []string{"data-mysql-0", "logs-mysql-0"}, statefulSetClaims(sts))
+}
+
+func TestClaimVolumes(t *testing.T) {
+	orig := getVolume
+	defer func() { getVolume = orig }()
+	getVolume = func(name string) (*apis.ZFSVolume, error) {
+		if name == "pvc-lvm" {
+			return nil, errors.New("not found")
+		}
+		return vol(name, "node1"), nil
+	}
+	pvs := map[string]*corev1.PersistentVolume{}
+	for _, name := range []string{"pvc-1", "pvc-2", "pvc-lvm"} {
+		pv := &corev1.PersistentVolume{}
+		pv.Name = name
+		pv.Spec.CSI = &corev1.CSIPersistentVolumeSource{VolumeHandle: name}
+		pvs[name] = pv
+	}
+	pvs["pv-nfs"] = &corev1.PersistentVolume{}
+	getPV := func(_ context.Context, name string) (*corev1.PersistentVolume, error) {
+		if pv, ok := pvs[name]; ok {
+			return pv, nil
+		}
+		return nil, errors.New("not found")
+	}
+
+	claims, err := claimVolumes(context.Background(),
+		[]corev1.PersistentVolumeClaim{pvc("data-0", "pvc-1"), pvc("data-1", "pvc-2")}, getPV)
+	assert.NoError(t, err)
+	assert.Len(t, claims, 2)
+	assert.Equal(t, "db/data-1", claims[1].Name)
+	assert.Equal(t, "pvc-2", claims[1].Volume.Name)
+
+	for _, c := range []corev1.PersistentVolumeClaim{
+		pvc("unbound", ""),
+		pvc("missing", "pv-missing"),
+		pvc("nfs", "pv-nfs"),
+		pvc("lvm", "pvc-lvm"),
+	} {
+		_, err = claimVolumes(context.Background(), []corev1.PersistentVolumeClaim{pvc("data-0", "pvc-1"), c}, getPV)
+		assert.Error(t, err, c.Name)
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	t0 := time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)
+	r := aggregate("g", []SnapshotStatus{
+		{Volume: "pvc-1", Phase: PhaseReady, CreationTime: t0.Add(2 * time.Second)},
+		{Volume: "pvc-2", Phase: PhaseReady, CreationTime: t0},
+		{Volume: "pvc-3", Phase: PhaseReady, CreationTime: t0.Add(500 * time.Millisecond)},
+	})
+	assert.True(t, r.Complete())
+	assert.Empty(t, r.Failures())
+	assert.Equal(t, 2*time.Second, r.Skew)
+
+	r = aggregate("g", []SnapshotStatus{
+		{Volume: "pvc-1", Phase: PhaseReady, CreationTime: t0},
+		{Volume: "pvc-2", Phase: PhaseFailed, Reason: "timeout"},
+	})
+	assert.False(t, r.Complete())
+	assert.Len(t, r.Failures(), 1)
+	assert.Equal(t, time.Duration(0), r.Skew)
+}
+
+// fakeAgents plays the node agents, the snapshots of the volumes on the
+// failing nodes fail and the others are taken one second apart
+type fakeAgents struct {
+	snaps   map[string]*apis.ZFSSnapshot
+	failing map[string]bool
+	deleted []string
+	taken   int
+}
+
+func (f *fakeAgents) create(snap *apis.ZFSSnapshot) error {
+	f.snaps[snap.Name] = snap
+	return nil
+}
+
+func (f *fakeAgents) get(name string) (*apis.ZFSSnapshot, error) {
+	snap := f.snaps[name]
+	if snap.Status.State == zfs.ZFSStatusPending {
+		if f.failing[snap.Spec.OwnerNodeID] {
+			snap.Status.State, snap.Status.Error = zfs.ZFSStatusFailed, "pool is suspended"
+		} else {
+			t := metav1.NewTime(time.Date(2023, 5, 1, 10, 0, f.taken, 0, time.UTC))
+			snap.Status.State, snap.Status.CreationTime = zfs.ZFSStatusReady, &t
+			f.taken++
+		}
+	}
+	return snap, nil
+}
+
+func (f *fakeAgents) delete(name string) error {
+	f.deleted = append(f.deleted, name)
+	return nil
+}
+
+func fakeSnapshotter(claims []Claim, agents *fakeAgents) *Snapshotter {
+	s := newSnapshotter("backup-1", func(context.Context) ([]Claim, error) { return claims, nil })
+	s.createSnapshot, s.getSnapshot, s.deleteSnapshot = agents.create, agents.get, agents.delete
+	s.pollInterval = time.Millisecond
+	return s
+}
+
+func TestSnapshot(t *testing.T) {
+	claims := []Claim{
+		{Name: "db/data-0", Volume: vol("pvc-1", "node1")},
+		{Name: "db/data-1", Volume: vol("pvc-2", "node2")},
+		{Name: "db/data-2", Volume: vol("pvc-3", "node3")},
+	}
+	agents := &fakeAgents{snaps: map[string]*apis.ZFSSnapshot{}}
+	var calls []string
+	s := fakeSnapshotter(claims, agents).WithHooks(
+		func(context.Context) error {
+			calls = append(calls, "pre")
+			assert.Empty(t, agents.snaps, "pre hook runs before the snapshots")
+			return nil
+		},
+		func(context.Context) error {
+			calls = append(calls, "post")
+			assert.Equal(t, 3, agents.taken, "post hook runs once the snapshots are taken")
+			return nil
+		})
+
+	r, err := s.Snapshot(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"pre", "post"}, calls)
+	assert.True(t, r.Complete())
+	assert.Equal(t, 2*time.Second, r.Skew)
+	assert.Equal(t, "node2", r.Snapshots[1].Node)
+
+	snap := agents.snaps["backup-1-pvc-2"]
+	assert.Equal(t, "node2", snap.Spec.OwnerNodeID)
+	assert.Equal(t, "pvc-2", snap.Labels[zfs.ZFSVolKey])
+	assert.Equal(t, "backup-1", snap.Labels[GroupLabel])
+}
+
+func TestSnapshotPartialFailure(t *testing.T) {
+	claims := []Claim{
+		{Name: "db/data-0", Volume: vol("pvc-1", "node1")},
+		{Name: "db/data-1", Volume: vol("pvc-2", "node2")},
+	}
+	agents := &fakeAgents{snaps: map[string]*apis.ZFSSnapshot{}, failing: map[string]bool{"node2": true}}
+	postRan := false
+	s := fakeSnapshotter(claims, agents).
+		WithDeleteOnFailure().
+		WithHooks(nil, func(context.Context) error { postRan = true; return nil })
+
+	r, err := s.Snapshot(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, postRan)
+	assert.False(t, r.Complete())
+	failures := r.Failures()
+	assert.Len(t, failures, 1)
+	assert.Equal(t, "pvc-2", failures[0].Volume)
+	assert.Equal(t, "pool is suspended", failures[0].Reason)
+	assert.Equal(t, []string{"backup-1-pvc-1", "backup-1-pvc-2"}, agents.deleted)
+}
+
+func TestSnapshotTimeout(t *testing.T) {
+	claims := []Claim{{Name: "db/data-0", Volume: vol("pvc-1", "node1")}}
+	s := fakeSnapshotter(claims, &fakeAgents{snaps: map[string]*apis.ZFSSnapshot{}}).
+		WithTimeout(10 * time.Millisecond)
+	// the node agent never picks up the snapshot
+	s.getSnapshot = func(name string) (*apis.ZFSSnapshot, error) {
+		snap := &apis.ZFSSnapshot{}
+		snap.Status.State = zfs.ZFSStatusPending
+		return snap, nil
+	}
+
+	r, err := s.Snapshot(context.Background())
+	assert.NoError(t, err)
+	assert.False(t, r.Complete())
+	assert.Contains(t, r.Snapshots[0].Reason, "not taken on node node1")
+}
+
+func TestSnapshotHookErrors(t *testing.T) {
+	claims := []Claim{{Name: "db/data-0", Volume: vol("pvc-1", "node1")}}
+	agents := &fakeAgents{snaps: map[string]*apis.ZFSSnapshot{}}
+
+	_, err := fakeSnapshotter(claims, agents).
+		WithHooks(func(context.Context) error { return errors.New("freeze failed") }, nil).
+		Snapshot(context.Background())
+	assert.ErrorContains(t, err, "freeze failed")
+	assert.Empty(t, agents.snaps)
+
+	r, err := fakeSnapshotter(claims, agents).
+		WithHooks(nil, func(context.Context) error { return errors.New("thaw failed") }).
+		Snapshot(context.Background())
+	assert.ErrorContains(t, err, "thaw failed")
+	assert.True(t, r.Complete())
+
+	_, err = fakeSnapshotter(nil, agents).Snapshot(context.Background())
+	assert.Error(t, err)
+}
+
+func TestResolveVolumes(t *testing.T) {
+	orig := getVolume
+	defer func() { getVolume = orig }()
+	getVolume = func(name string) (*apis.ZFSVolume, error) {
+		if name == "pvc-missing" {
+			return nil, errors.New("not found")
+		}
+		v := vol(name, "node1")
+		if name == "pvc-1" {
+			v.Annotations = map[string]string{zfs.PVCNamespaceKey: "db", zfs.PVCNameKey: "data-0"}
+		}
+		return v, nil
+	}
+
+	claims, err := resolveVolumes([]string{"pvc-1", "pvc-2"})
+	assert.NoError(t, err)
+	assert.Equal(t, "db/data-0", claims[0].Name)
+	assert.Equal(t, "pvc-2", claims[1].Name, "a volume without its pvc is named after itself")
+
+	_, err = resolveVolumes([]string{"pvc-1", "pvc-missing"})
+	assert.Error(t, err)
+}
+
+func TestStatus(t *testing.T) {
+	claims := []Claim{
+		{Name: "db/data-0", Volume: vol("pvc-1", "node1")},
+		{Name: "db/data-1", Volume: vol("pvc-2", "node2")},
+	}
+	agents := &fakeAgents{snaps: map[string]*apis.ZFSSnapshot{}}
+	create := agents.create
+	s := fakeSnapshotter(claims, agents)
+	// the agents take the snapshots on the second get, the snapshots
+	// created by the first call already exist on the second one
+	pending := map[string]bool{}
+	s.createSnapshot = func(snap *apis.ZFSSnapshot) error {
+		if _, ok := agents.snaps[snap.Name]; ok {
+			return k8serror.NewAlreadyExists(schema.GroupResource{Resource: "zfssnapshots"}, snap.Name)
+		}
+		pending[snap.Name] = true
+		return create(snap)
+	}
+	s.getSnapshot = func(name string) (*apis.ZFSSnapshot, error) {
+		if pending[name] {
+			pending[name] = false
+			return agents.snaps[name], nil
+		}
+		return agents.get(name)
+	}
+
+	r, err := s.Status(context.Background())
+	assert.NoError(t, err)
+	assert.False(t, r.Complete(), "the snapshots are not waited for")
+	assert.Equal(t, PhasePending, r.Snapshots[0].Phase)
+
+	r, err = s.Status(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, r.Complete())
+	assert.Len(t, agents.snaps, 2)
+}
+
+func TestStatusFailure(t *testing.T) {
+	claims := []Claim{
+		{Name: "db/data-0", Volume: vol("pvc-1", "node1")},
+		{Name: "db/data-1", Volume: vol("pvc-2", "node2")},
+	}
+	agents := &fakeAgents{snaps: map[string]*apis.ZFSSnapshot{}, failing: map[string]bool{"node2": true}}
+
+	r, err := fakeSnapshotter(claims, agents).WithDeleteOnFailure().Status(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, PhaseFailed, r.Snapshots[1].Phase)
+	assert.Equal(t, []string{"backup-1-pvc-1", "backup-1-pvc-2"}, agents.deleted)
+}
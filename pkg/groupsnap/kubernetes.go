@@ -0,0 +1,135 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupsnap
+
+import (
+	"context"
+	"fmt"
+
+	k8sapi "github.com/openebs/lib-csi/pkg/client/k8s"
+	"github.com/openebs/zfs-localpv/pkg/zfs"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// statefulSetClaims returns the names of the PVCs created for the pods of
+// the StatefulSet, <template>-<statefulset>-<ordinal>
+func statefulSetClaims(sts *appsv1.StatefulSet) []string {
+	replicas := int32(1)
+	if sts.Spec.Replicas != nil {
+		replicas = *sts.Spec.Replicas
+	}
+	var names []string
+	for ordinal := int32(0); ordinal < replicas; ordinal++ {
+		for _, tmpl := range sts.Spec.VolumeClaimTemplates {
+			names = append(names, fmt.Sprintf("%s-%s-%d", tmpl.Name, sts.Name, ordinal))
+		}
+	}
+	return names
+}
+
+func resolveStatefulSet(ctx context.Context, namespace, name string) ([]Claim, error) {
+	cs, err := k8sapi.Clientset().Get()
+	if err != nil {
+		return nil, err
+	}
+	sts, err := cs.AppsV1().StatefulSets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get statefulset %s/%s: %w", namespace, name, err)
+	}
+
+	var pvcs []corev1.PersistentVolumeClaim
+	for _, claim := range statefulSetClaims(sts) {
+		pvc, err := cs.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, claim, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get pvc %s/%s of statefulset %s: %w", namespace, claim, name, err)
+		}
+		pvcs = append(pvcs, *pvc)
+	}
+	return claimVolumes(ctx, pvcs, getPV)
+}
+
+func resolveSelector(ctx context.Context, namespace string, selector labels.Selector) ([]Claim, error) {
+	cs, err := k8sapi.Clientset().Get()
+	if err != nil {
+		return nil, err
+	}
+	list, err := cs.CoreV1().PersistentVolumeClaims(namespace).List(ctx,
+		metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pvcs in %s: %w", namespace, err)
+	}
+	return claimVolumes(ctx, list.Items, getPV)
+}
+
+func getPV(ctx context.Context, name string) (*corev1.PersistentVolume, error) {
+	cs, err := k8sapi.Clientset().Get()
+	if err != nil {
+		return nil, err
+	}
+	return cs.CoreV1().PersistentVolumes().Get(ctx, name, metav1.GetOptions{})
+}
+
+// getVolume fetches the ZFSVolume, can be replaced in unit tests
+var getVolume = zfs.GetZFSVolume
+
+// claimVolumes resolves the bound PVCs to their ZFSVolumes. All the claims
+// of the group have to be zfs volumes, the group is not consistent
+// otherwise.
+func claimVolumes(ctx context.Context, pvcs []corev1.PersistentVolumeClaim,
+	getPV func(context.Context, string) (*corev1.PersistentVolume, error)) ([]Claim, error) {
+	claims := make([]Claim, 0, len(pvcs))
+	for _, pvc := range pvcs {
+		name := pvc.Namespace + "/" + pvc.Name
+		if pvc.Spec.VolumeName == "" {
+			return nil, fmt.Errorf("pvc %s is not bound", name)
+		}
+		pv, err := getPV(ctx, pvc.Spec.VolumeName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get pv %s of pvc %s: %w", pvc.Spec.VolumeName, name, err)
+		}
+		if pv.Spec.CSI == nil {
+			return nil, fmt.Errorf("pv %s of pvc %s is not a csi volume", pv.Name, name)
+		}
+		vol, err := getVolume(pv.Spec.CSI.VolumeHandle)
+		if err != nil {
+			return nil, fmt.Errorf("pv %s of pvc %s is not a zfs volume: %w", pv.Name, name, err)
+		}
+		claims = append(claims, Claim{Name: name, Volume: vol})
+	}
+	return claims, nil
+}
+
+// resolveVolumes returns the claims of the given ZFSVolumes, named after
+// the PVC recorded on the volume or after the volume for the older ones
+func resolveVolumes(volumes []string) ([]Claim, error) {
+	claims := make([]Claim, 0, len(volumes))
+	for _, name := range volumes {
+		vol, err := getVolume(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get volume %s: %w", name, err)
+		}
+		claim := vol.Name
+		if ns, pvc := vol.Annotations[zfs.PVCNamespaceKey], vol.Annotations[zfs.PVCNameKey]; ns != "" && pvc != "" {
+			claim = ns + "/" + pvc
+		}
+		claims = append(claims, Claim{Name: claim, Volume: vol})
+	}
+	return claims, nil
+}
@@ -17,12 +17,15 @@ limitations under the License.
 package backup
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"k8s.io/klog/v2"
 	"time"
 
 	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
 	zfs "github.com/openebs/zfs-localpv/pkg/zfs"
+	corev1 "k8s.io/api/core/v1"
 	k8serror "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
@@ -77,6 +80,10 @@ func (c *BkpController) syncBkp(bkp *apis.ZFSBackup) error {
 	var err error = nil
 	// ZFSBackup should be deleted. Check if deletion timestamp is set
 	if c.isDeletionCandidate(bkp) {
+		// the send has been killed, destroy the snapshot once it is over
+		if c.isRunning(bkp) {
+			return fmt.Errorf("backup %s is still being sent", bkp.Name)
+		}
 		// reconcile for the Destroy error
 		err = zfs.DestoryBackup(bkp)
 		if err == nil {
@@ -85,19 +92,114 @@ func (c *BkpController) syncBkp(bkp *apis.ZFSBackup) error {
 	} else {
 		// if status is init then it means we are creating the zfs backup.
 		if bkp.Status == apis.BKPZFSStatusInit {
-			err = zfs.CreateBackup(bkp)
-			if err == nil {
-				klog.Infof("backup %s done %s@%s prevsnap [%s]", bkp.Name, bkp.Spec.VolumeName, bkp.Spec.SnapName, bkp.Spec.PrevSnapName)
-				err = zfs.UpdateBkpInfo(bkp, apis.BKPZFSStatusDone)
-			} else {
-				klog.Errorf("backup %s failed %s@%s err %v", bkp.Name, bkp.Spec.VolumeName, bkp.Spec.SnapName, err)
-				err = zfs.UpdateBkpInfo(bkp, apis.BKPZFSStatusFailed)
+			if bkp.Spec.Cancel {
+				if c.isRunning(bkp) {
+					// the backup is cancelled once the send is killed
+					return nil
+				}
+				return c.cancelledBkp(bkp)
 			}
+			c.startBkp(bkp)
 		}
 	}
 	return err
 }
 
+// isRunning returns true if the backup is being sent or waits for a send
+// slot
+func (c *BkpController) isRunning(bkp *apis.ZFSBackup) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.running[bkp.Name]
+	return ok
+}
+
+// startBkp sends the backup in the background, so that a long send, or a
+// backup waiting for a send slot, does not hold a worker. The workers stay
+// free to cancel and delete the other backups meanwhile. The backup is
+// synced again if its status could not be updated.
+func (c *BkpController) startBkp(bkp *apis.ZFSBackup) {
+	ctx, cancel := context.WithCancel(context.Background())
+	c.mu.Lock()
+	if _, ok := c.running[bkp.Name]; ok {
+		c.mu.Unlock()
+		cancel()
+		return
+	}
+	c.running[bkp.Name] = cancel
+	c.mu.Unlock()
+
+	go func() {
+		err := c.finishBkp(bkp, c.runBkp(ctx, cancel, bkp))
+		c.mu.Lock()
+		delete(c.running, bkp.Name)
+		c.mu.Unlock()
+		cancel()
+		if err != nil {
+			runtime.HandleError(fmt.Errorf("error syncing '%s': %s, requeuing", bkp.Name, err.Error()))
+			if key, kerr := cache.MetaNamespaceKeyFunc(bkp); kerr == nil {
+				c.workqueue.AddRateLimited(key)
+			}
+		}
+	}()
+}
+
+// finishBkp updates the status of the backup as per the result of its send
+func (c *BkpController) finishBkp(bkp *apis.ZFSBackup, err error) error {
+	if errors.Is(err, zfs.ErrBackupCancelled) {
+		klog.Infof("backup %s cancelled %s@%s", bkp.Name, bkp.Spec.VolumeName, bkp.Spec.SnapName)
+		latest, gerr := zfs.GetZFSBackup(bkp.Name)
+		if gerr != nil {
+			return gerr
+		}
+		if c.isDeletionCandidate(latest) {
+			// the deletion is taken care of by the next sync
+			return nil
+		}
+		return c.cancelledBkp(latest)
+	} else if err == nil {
+		klog.Infof("backup %s done %s@%s prevsnap [%s]", bkp.Name, bkp.Spec.VolumeName, bkp.Spec.SnapName, bkp.Spec.PrevSnapName)
+		return c.updateBkpInfo(bkp, apis.BKPZFSStatusDone)
+	}
+	klog.Errorf("backup %s failed %s@%s err %v", bkp.Name, bkp.Spec.VolumeName, bkp.Spec.SnapName, err)
+	return c.updateBkpInfo(bkp, apis.BKPZFSStatusFailed)
+}
+
+// runBkp sends the backup, it can be cancelled by cancelBkp while running.
+// The progress updates also pick up a cancel request which came in before
+// the backup was registered as running.
+func (c *BkpController) runBkp(ctx context.Context, cancel context.CancelFunc, bkp *apis.ZFSBackup) error {
+	return c.createBackup(ctx, bkp, func(progress apis.ZFSBackupProgress) {
+		if err := zfs.UpdateBkpProgress(bkp, progress); err != nil {
+			klog.Warningf("backup %s: could not update the progress %v", bkp.Name, err)
+			return
+		}
+		if bkp.Spec.Cancel || c.isDeletionCandidate(bkp) {
+			cancel()
+		}
+	})
+}
+
+// cancelBkp kills the send of the backup if it is running
+func (c *BkpController) cancelBkp(bkp *apis.ZFSBackup) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cancel, ok := c.running[bkp.Name]; ok {
+		klog.Infof("cancelling backup %s snap %s@%s", bkp.Name, bkp.Spec.VolumeName, bkp.Spec.SnapName)
+		cancel()
+	}
+}
+
+// cancelledBkp cleans up after the cancelled backup and marks it Cancelled
+func (c *BkpController) cancelledBkp(bkp *apis.ZFSBackup) error {
+	if err := zfs.CleanupCancelledBackup(bkp); err != nil {
+		return err
+	}
+	c.recorder.Eventf(bkp, corev1.EventTypeNormal, "Cancelled",
+		"backup cancelled after %d bytes", bkp.Progress.BytesSent)
+	return c.updateBkpInfo(bkp, apis.BKPZFSStatusCancelled)
+}
+
 // addBkp is the add event handler for ZFSBackup
 func (c *BkpController) addBkp(obj interface{}) {
 	bkp, ok := obj.(*apis.ZFSBackup)
@@ -126,7 +228,13 @@ func (c *BkpController) updateBkp(oldObj, newObj interface{}) {
 		return
 	}
 
-	if c.isDeletionCandidate(newBkp) {
+	if c.isDeletionCandidate(newBkp) || newBkp.Spec.Cancel {
+		// the worker is busy sending the backup, kill the send right away
+		c.cancelBkp(newBkp)
+	}
+
+	if c.isDeletionCandidate(newBkp) ||
+		(newBkp.Spec.Cancel && newBkp.Status == apis.BKPZFSStatusInit) {
 		klog.Infof("Got update event for Bkp %s snap %s@%s", newBkp.Name, newBkp.Spec.VolumeName, newBkp.Spec.SnapName)
 		c.enqueueBkp(newBkp)
 	}
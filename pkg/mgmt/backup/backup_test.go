@@ -0,0 +1,182 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backup
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"github.com/openebs/zfs-localpv/pkg/zfs"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// fakeBackups sends the backups until they are released or cancelled
+type fakeBackups struct {
+	release chan struct{}
+
+	mu       sync.Mutex
+	statuses map[string]apis.ZFSBackupStatus
+	failNext bool
+}
+
+func (f *fakeBackups) create(ctx context.Context, bkp *apis.ZFSBackup, progress zfs.BackupProgressFunc) error {
+	select {
+	case <-f.release:
+		return nil
+	case <-ctx.Done():
+		return zfs.ErrBackupCancelled
+	}
+}
+
+func (f *fakeBackups) update(bkp *apis.ZFSBackup, status apis.ZFSBackupStatus) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failNext {
+		f.failNext = false
+		return errors.New("conflict")
+	}
+	f.statuses[bkp.Name] = status
+	return nil
+}
+
+func (f *fakeBackups) status(name string) apis.ZFSBackupStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.statuses[name]
+}
+
+func newTestController() (*BkpController, *fakeBackups) {
+	f := &fakeBackups{release: make(chan struct{}), statuses: map[string]apis.ZFSBackupStatus{}}
+	c := NewBkpControllerBuilder().BkpController
+	c.workqueue = workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "Bkp")
+	c.recorder = record.NewFakeRecorder(10)
+	c.createBackup = f.create
+	c.updateBkpInfo = f.update
+	return c, f
+}
+
+func initBkp(name string) *apis.ZFSBackup {
+	bkp := &apis.ZFSBackup{}
+	bkp.Name = name
+	bkp.Namespace = "openebs"
+	bkp.Spec.VolumeName = "pvc-1"
+	bkp.Spec.SnapName = name
+	bkp.Status = apis.BKPZFSStatusInit
+	return bkp
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSendDoesNotBlockControl(t *testing.T) {
+	c, f := newTestController()
+	defer c.workqueue.ShutDown()
+
+	// both sends hold on, as if they were waiting for a send slot
+	for _, name := range []string{"bkp-a", "bkp-b"} {
+		if err := c.syncBkp(initBkp(name)); err != nil {
+			t.Fatalf("syncBkp(%s) = %v", name, err)
+		}
+		if !c.isRunning(initBkp(name)) {
+			t.Fatalf("backup %s is not running", name)
+		}
+	}
+
+	// a cancel of another backup is handled right away
+	cancelled := initBkp("bkp-c")
+	cancelled.Spec.Cancel = true
+	cancelled.Spec.KeepForResume = true
+	if err := c.syncBkp(cancelled); err != nil {
+		t.Fatalf("syncBkp(cancelled) = %v", err)
+	}
+	if st := f.status("bkp-c"); st != apis.BKPZFSStatusCancelled {
+		t.Errorf("status of the cancelled backup = %q, want Cancelled", st)
+	}
+
+	// the deletion of a running backup waits for its send to be over
+	deleting := initBkp("bkp-a")
+	now := metav1.Now()
+	deleting.DeletionTimestamp = &now
+	if err := c.syncBkp(deleting); err == nil {
+		t.Error("syncBkp() of a deleted backup being sent did not fail")
+	}
+
+	close(f.release)
+	waitFor(t, "the sends to be done", func() bool {
+		return f.status("bkp-a") == apis.BKPZFSStatusDone && f.status("bkp-b") == apis.BKPZFSStatusDone
+	})
+	waitFor(t, "the sends to be unregistered", func() bool {
+		return !c.isRunning(initBkp("bkp-a")) && !c.isRunning(initBkp("bkp-b"))
+	})
+}
+
+func TestSendStartedOnce(t *testing.T) {
+	c, f := newTestController()
+	defer c.workqueue.ShutDown()
+
+	var mu sync.Mutex
+	started := 0
+	c.createBackup = func(ctx context.Context, bkp *apis.ZFSBackup, progress zfs.BackupProgressFunc) error {
+		mu.Lock()
+		started++
+		mu.Unlock()
+		return f.create(ctx, bkp, progress)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := c.syncBkp(initBkp("bkp-a")); err != nil {
+			t.Fatalf("syncBkp() = %v", err)
+		}
+	}
+	close(f.release)
+	waitFor(t, "the send to be done", func() bool { return f.status("bkp-a") == apis.BKPZFSStatusDone })
+
+	mu.Lock()
+	defer mu.Unlock()
+	if started != 1 {
+		t.Errorf("backup sent %d times, want once", started)
+	}
+}
+
+func TestSendStatusRequeued(t *testing.T) {
+	c, f := newTestController()
+	defer c.workqueue.ShutDown()
+	f.failNext = true
+	close(f.release)
+
+	if err := c.syncBkp(initBkp("bkp-a")); err != nil {
+		t.Fatalf("syncBkp() = %v", err)
+	}
+	waitFor(t, "the backup to be requeued", func() bool { return c.workqueue.Len() == 1 })
+	if key, _ := c.workqueue.Get(); key != "openebs/bkp-a" {
+		t.Errorf("requeued %v, want openebs/bkp-a", key)
+	}
+}
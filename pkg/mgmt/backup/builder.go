@@ -17,12 +17,17 @@ limitations under the License.
 package backup
 
 import (
+	"context"
+	"sync"
+
 	"k8s.io/klog/v2"
 
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
 	clientset "github.com/openebs/zfs-localpv/pkg/generated/clientset/internalclientset"
 	openebsScheme "github.com/openebs/zfs-localpv/pkg/generated/clientset/internalclientset/scheme"
 	informers "github.com/openebs/zfs-localpv/pkg/generated/informer/externalversions"
 	listers "github.com/openebs/zfs-localpv/pkg/generated/lister/zfs/v1"
+	"github.com/openebs/zfs-localpv/pkg/zfs"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
@@ -57,6 +62,15 @@ type BkpController struct {
 	// recorder is an event recorder for recording Event resources to the
 	// Kubernetes API.
 	recorder record.EventRecorder
+
+	// running holds the cancel func of the backups being sent
+	mu      sync.Mutex
+	running map[string]context.CancelFunc
+
+	// createBackup sends the backup, updateBkpInfo records its status
+	// once the send is over
+	createBackup  func(ctx context.Context, bkp *apis.ZFSBackup, progress zfs.BackupProgressFunc) error
+	updateBkpInfo func(bkp *apis.ZFSBackup, status apis.ZFSBackupStatus) error
 }
 
 // BkpControllerBuilder is the builder object for controller.
@@ -67,7 +81,11 @@ type BkpControllerBuilder struct {
 // NewBkpControllerBuilder returns an empty instance of controller builder.
 func NewBkpControllerBuilder() *BkpControllerBuilder {
 	return &BkpControllerBuilder{
-		BkpController: &BkpController{},
+		BkpController: &BkpController{
+			running:       map[string]context.CancelFunc{},
+			createBackup:  zfs.CreateBackup,
+			updateBkpInfo: zfs.UpdateBkpInfo,
+		},
 	}
 }
 
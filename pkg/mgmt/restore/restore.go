@@ -24,6 +24,7 @@ import (
 
 	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
 	zfs "github.com/openebs/zfs-localpv/pkg/zfs"
+	corev1 "k8s.io/api/core/v1"
 	k8serror "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
@@ -86,13 +87,36 @@ func (c *RstrController) syncRestore(rstr *apis.ZFSRestore) error {
 				err = zfs.UpdateRestoreInfo(rstr, apis.RSTZFSStatusDone)
 			} else {
 				klog.Errorf("restore %s failed %s err %v", rstr.Name, rstr.Spec.VolumeName, err)
-				err = zfs.UpdateRestoreInfo(rstr, apis.RSTZFSStatusFailed)
+				err = c.retryRestore(rstr, err)
 			}
 		}
 	}
 	return err
 }
 
+// retryRestore prepares the dataset of the failed restore for another
+// attempt if it has retries left, otherwise the restore is marked Failed.
+// The error returned for a retry requeues the restore.
+func (c *RstrController) retryRestore(rstr *apis.ZFSRestore, rerr error) error {
+	rstr.Retry.LastError = rerr.Error()
+	if zfs.CanRetryRestore(rstr) {
+		recovery, err := zfs.RecoverRestore(rstr)
+		if err != nil {
+			klog.Errorf("restore %s: could not recover the dataset of the failed receive: %v", rstr.Name, err)
+		} else if recovery != zfs.RecvPreserve {
+			rstr.Retry.Attempts++
+			if err = zfs.UpdateRestoreInfo(rstr, apis.RSTZFSStatusInit); err != nil {
+				return err
+			}
+			msg := fmt.Sprintf("zfs receive failed, attempt %d of %d will %s the receive: %v",
+				rstr.Retry.Attempts, rstr.Spec.MaxRetries, recovery, rerr)
+			c.recorder.Event(rstr, corev1.EventTypeWarning, "RetryingRestore", msg)
+			return fmt.Errorf("restore %s: %s", rstr.Name, msg)
+		}
+	}
+	return zfs.UpdateRestoreInfo(rstr, apis.RSTZFSStatusFailed)
+}
+
 // addRestore is the add event handler for ZFSRestore
 func (c *RstrController) addRestore(obj interface{}) {
 	rstr, ok := obj.(*apis.ZFSRestore)
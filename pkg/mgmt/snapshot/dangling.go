@@ -0,0 +1,72 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package snapshot
+
+import (
+	"time"
+
+	zfs "github.com/openebs/zfs-localpv/pkg/zfs"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+)
+
+// danglingCheckInterval is the interval at which the ready snapshots are
+// checked to still exist on the node
+const danglingCheckInterval = 5 * time.Minute
+
+// checkDangling goes through the ready snapshots of this node and marks or
+// deletes the ones whose zfs snapshot is gone as per zfs.DanglingSnapshotPolicy
+func (c *SnapController) checkDangling() {
+	snaps, err := c.snapLister.ZFSSnapshots(zfs.OpenEBSNamespace).List(labels.Everything())
+	if err != nil {
+		klog.Errorf("snapshot: failed to list the snapshots for dangling check: %v", err)
+		return
+	}
+	for _, s := range snaps {
+		if s.Spec.OwnerNodeID != zfs.NodeID ||
+			s.Status.State != zfs.ZFSStatusReady ||
+			c.isDeletionCandidate(s) {
+			continue
+		}
+		snap := s.DeepCopy()
+		changed, remove, err := zfs.ReconcileDanglingSnapshot(snap, zfs.DanglingSnapshotPolicy)
+		if err != nil {
+			klog.Errorf("snapshot: could not check if %s still exists: %v", snap.Name, err)
+			continue
+		}
+		if remove {
+			klog.Infof("snapshot: deleting dangling snapshot %s", snap.Name)
+			if err = zfs.DeleteSnapshot(snap.Name); err != nil {
+				klog.Errorf("snapshot: could not delete dangling snapshot %s: %v", snap.Name, err)
+			}
+			continue
+		}
+		if !changed {
+			continue
+		}
+		if err = zfs.UpdateSnapStatus(snap); err != nil {
+			klog.Errorf("snapshot: could not update the dangling status of %s: %v", snap.Name, err)
+			continue
+		}
+		if meta.IsStatusConditionTrue(snap.Status.Conditions, zfs.ConditionSnapshotMissing) {
+			cond := meta.FindStatusCondition(snap.Status.Conditions, zfs.ConditionSnapshotMissing)
+			c.recorder.Event(snap, corev1.EventTypeWarning, cond.Reason, cond.Message)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package snapshot
+
+import (
+	"errors"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	zfs "github.com/openebs/zfs-localpv/pkg/zfs"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// destroyPaused reports the destroy held by the destroy guard in the
+// error of the snapshot and in a warning event. The snapshot is synced
+// again once the confirmation annotation is added.
+func (c *SnapController) destroyPaused(snap *apis.ZFSSnapshot, err error) error {
+	var paused *zfs.DestroyPausedError
+	if !errors.As(err, &paused) {
+		return err
+	}
+	klog.Warningf("snapshot %s: %v", snap.Name, paused)
+	changed, err := zfs.PauseSnapshotDestroy(snap, paused)
+	if changed && err == nil {
+		c.recorder.Event(snap, corev1.EventTypeWarning, "DestroyPaused", paused.Error())
+	}
+	return err
+}
@@ -0,0 +1,45 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package snapshot
+
+import (
+	"errors"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	zfs "github.com/openebs/zfs-localpv/pkg/zfs"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// destroyRefused reports the clones holding the snapshot in its error and
+// in a warning event. The error is returned so that the snapshot is
+// retried with a backoff until its clones have been deleted.
+func (c *SnapController) destroyRefused(snap *apis.ZFSSnapshot, err error) error {
+	var clones *zfs.SnapshotClonesError
+	if !errors.As(err, &clones) {
+		return err
+	}
+	klog.Warningf("snapshot %s: %v", snap.Name, clones)
+	changed, err := zfs.RefuseSnapshotDestroy(snap, clones)
+	if err != nil {
+		return err
+	}
+	if changed {
+		c.recorder.Event(snap, corev1.EventTypeWarning, "DependentClones", clones.Error())
+	}
+	return clones
+}
@@ -17,11 +17,15 @@ limitations under the License.
 package snapshot
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"time"
 
 	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"github.com/openebs/zfs-localpv/pkg/freeze"
 	zfs "github.com/openebs/zfs-localpv/pkg/zfs"
+	corev1 "k8s.io/api/core/v1"
 	k8serror "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
@@ -54,6 +58,9 @@ func (c *SnapController) syncHandler(key string) error {
 		return err
 	}
 	snapCopy := snap.DeepCopy()
+	if _, err = zfs.ResolveSnapshotPool(c.clientset, snapCopy, zfs.PoolAliasUpdate); err != nil {
+		return err
+	}
 	err = c.syncSnap(snapCopy)
 	return err
 }
@@ -80,6 +87,17 @@ func (c *SnapController) syncSnap(snap *apis.ZFSSnapshot) error {
 		userFin := zfs.GetUserFinalizers(snap.Finalizers)
 		if len(userFin) == 0 {
 			// destroy only if other finalizers have been removed
+			if err = zfs.CheckSnapshotDestroy(snap); err != nil {
+				return c.destroyPaused(snap, err)
+			}
+			promoted, err := zfs.ReleaseSnapshotClones(snap)
+			if err != nil {
+				return c.destroyRefused(snap, err)
+			}
+			if promoted != "" {
+				c.recorder.Eventf(snap, corev1.EventTypeNormal, "ClonePromoted",
+					"clone %s has been promoted and has taken the snapshot over", promoted)
+			}
 			err = zfs.DestroySnapshot(snap)
 			if err == nil {
 				err = zfs.RemoveSnapFinalizer(snap)
@@ -89,11 +107,32 @@ func (c *SnapController) syncSnap(snap *apis.ZFSSnapshot) error {
 		}
 	} else {
 		// if status is not Ready then it means we are creating
-		// the zfs snapshot.
-		if snap.Status.State != zfs.ZFSStatusReady {
-			err = zfs.CreateSnapshot(snap)
+		// the zfs snapshot. A Failed snapshot is not attempted again.
+		switch {
+		case snap.Status.State == zfs.ZFSStatusReady || snap.Status.State == zfs.ZFSStatusFailed:
+		case zfs.SnapshotExpired(snap, time.Now()):
+			err = zfs.FailExpiredSnapshot(snap)
+		default:
+			// the properties are not going to get valid on a retry
+			if perr := zfs.ValidateSnapshotProperties(snap.Spec.SnapshotProperties); perr != nil {
+				err = zfs.FailSnapshot(snap, perr.Error())
+				break
+			}
+			// the application of the volume is thawed whether the
+			// snapshot has been taken or not
+			err = freeze.Run(context.Background(), snap, func() error {
+				return zfs.CreateSnapshot(snap)
+			})
+			var thawErr *freeze.ThawError
+			if errors.As(err, &thawErr) {
+				c.recorder.Event(snap, corev1.EventTypeWarning, "ThawFailed", thawErr.Error())
+				err = nil
+			}
 			if err == nil {
 				err = zfs.UpdateSnapInfo(snap)
+			} else if zfs.SnapshotExpired(snap, time.Now()) {
+				// zfs snapshot has been killed on the timeout
+				err = zfs.FailExpiredSnapshot(snap)
 			}
 		}
 	}
@@ -182,6 +221,14 @@ func (c *SnapController) Run(threadiness int, stopCh <-chan struct{}) error {
 		go wait.Until(c.runWorker, time.Second, stopCh)
 	}
 
+	// keep the data written in between the snapshots up to date
+	go wait.Until(c.refreshWritten, writtenRefreshInterval, stopCh)
+
+	// catch the snapshots destroyed behind the driver
+	if zfs.DanglingSnapshotPolicy != zfs.DanglingPolicyOff {
+		go wait.Until(c.checkDangling, danglingCheckInterval, stopCh)
+	}
+
 	klog.Info("Started Snap workers")
 	<-stopCh
 	klog.Info("Shutting down Snap workers")
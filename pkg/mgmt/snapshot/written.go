@@ -0,0 +1,74 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package snapshot
+
+import (
+	"time"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	zfs "github.com/openebs/zfs-localpv/pkg/zfs"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+)
+
+// writtenRefreshInterval is the interval at which the written status of
+// the snapshots is refreshed
+const writtenRefreshInterval = 5 * time.Minute
+
+// refreshWritten updates the data written in between the snapshots for
+// all the ready snapshots of this node
+func (c *SnapController) refreshWritten() {
+	snaps, err := c.snapLister.ZFSSnapshots(zfs.OpenEBSNamespace).List(labels.Everything())
+	if err != nil {
+		klog.Errorf("snapshot: failed to list the snapshots: %v", err)
+		return
+	}
+
+	// group the snapshots by the volume
+	byVol := map[string][]*apis.ZFSSnapshot{}
+	for _, snap := range snaps {
+		if snap.Spec.OwnerNodeID != zfs.NodeID ||
+			snap.Status.State != zfs.ZFSStatusReady ||
+			c.isDeletionCandidate(snap) {
+			continue
+		}
+		vol := snap.Labels[zfs.ZFSVolKey]
+		byVol[vol] = append(byVol[vol], snap)
+	}
+
+	for _, volSnaps := range byVol {
+		written, err := zfs.GetSnapshotsWritten(volSnaps[0])
+		if err != nil {
+			klog.Errorf("snapshot: %v", err)
+			continue
+		}
+		byName := map[string]zfs.SnapshotWritten{}
+		for _, w := range written {
+			byName[w.Name] = w
+		}
+
+		for _, snap := range volSnaps {
+			w, ok := byName[snap.Name]
+			if !ok {
+				continue
+			}
+			if err := zfs.UpdateSnapWritten(snap, w); err != nil {
+				klog.Errorf("snapshot: failed to update written of %s: %v", snap.Name, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"context"
+	"fmt"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	clientset "github.com/openebs/zfs-localpv/pkg/generated/clientset/internalclientset"
+	zfs "github.com/openebs/zfs-localpv/pkg/zfs"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// archiver archives the volumes with the ArchiveThenDelete reclaim policy
+type archiver struct {
+	// send sends the final snapshot of the volume to its archive target
+	send func(zv *apis.ZFSVolume) (*apis.VolumeArchive, error)
+	// recordVolume and recordNode record the archive in the status of the
+	// volume and in the one of the ZFSNode, which outlives the volume
+	recordVolume func(zv *apis.ZFSVolume, archive *apis.VolumeArchive) error
+	recordNode   func(zv *apis.ZFSVolume, archive *apis.VolumeArchive) error
+}
+
+func newArchiver(client clientset.Interface) *archiver {
+	return &archiver{
+		send: func(zv *apis.ZFSVolume) (*apis.VolumeArchive, error) {
+			return zfs.ArchiveVolume(context.Background(), zv)
+		},
+		recordVolume: func(zv *apis.ZFSVolume, archive *apis.VolumeArchive) error {
+			return zfs.RecordVolumeArchive(client, zv, archive)
+		},
+		recordNode: func(zv *apis.ZFSVolume, archive *apis.VolumeArchive) error {
+			return zfs.RecordNodeArchive(client, zv, archive)
+		},
+	}
+}
+
+// archiveZV archives the volume with the ArchiveThenDelete reclaim policy
+// before it is destroyed. A failed archive pauses the destroy, it is
+// reported in the status and in a warning event of the volume and retried
+// with the backoff of the destroy queue. The location of a completed
+// archive is kept in the status of the ZFSNode, for the volume to be
+// restored from it once destroyed, and in the status and an event of the
+// volume. The volume is not destroyed until the ZFSNode has it.
+func (c *ZVController) archiveZV(zv *apis.ZFSVolume) error {
+	archive, err := c.archiver.send(zv)
+	if err != nil {
+		klog.Errorf("volume %s: archive to %s failed: %v", zv.Name, zv.Spec.ArchiveTarget, err)
+		failed := &apis.VolumeArchive{
+			Phase:   apis.ArchiveFailed,
+			Target:  zv.Spec.ArchiveTarget,
+			Message: err.Error(),
+			Time:    metav1.Now(),
+		}
+		if rerr := c.archiver.recordVolume(zv, failed); rerr != nil {
+			klog.Errorf("volume %s: could not record the failed archive: %v", zv.Name, rerr)
+		}
+		c.recorder.Eventf(zv, corev1.EventTypeWarning, "ArchiveFailed",
+			"archive to %s failed, the volume is not destroyed until it succeeds: %v", zv.Spec.ArchiveTarget, err)
+		return fmt.Errorf("volume: destroy of %s paused until it is archived: %v", zv.Name, err)
+	}
+	if archive == nil {
+		return nil
+	}
+	if err := c.archiver.recordNode(zv, archive); err != nil {
+		return fmt.Errorf("volume: destroy of %s paused until its archive %s on %s is recorded: %v",
+			zv.Name, archive.Snapshot, archive.Target, err)
+	}
+	c.recorder.Eventf(zv, corev1.EventTypeNormal, "Archived",
+		"volume archived to %s as %s, %d bytes", archive.Target, archive.Snapshot, archive.Bytes)
+	// the archive is not sent again if its record fails, the event and the
+	// log of the node agent still tell where it is
+	if err := c.archiver.recordVolume(zv, archive); err != nil {
+		klog.Errorf("volume %s: could not record the archive %s on %s: %v",
+			zv.Name, archive.Snapshot, archive.Target, err)
+	}
+	return nil
+}
@@ -17,6 +17,9 @@ limitations under the License.
 package volume
 
 import (
+	"time"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
 	clientset "github.com/openebs/zfs-localpv/pkg/generated/clientset/internalclientset"
 	openebsScheme "github.com/openebs/zfs-localpv/pkg/generated/clientset/internalclientset/scheme"
 	informers "github.com/openebs/zfs-localpv/pkg/generated/informer/externalversions"
@@ -29,6 +32,8 @@ import (
 	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog/v2"
+
+	zfs "github.com/openebs/zfs-localpv/pkg/zfs"
 )
 
 const controllerAgentName = "zfsvolume-controller"
@@ -53,6 +58,28 @@ type ZVController struct {
 	// simultaneously in two different workers.
 	workqueue workqueue.RateLimitingInterface
 
+	// destroyQueue holds the volumes to destroy, they are processed by
+	// their own workers so that slow destroys do not hold up the other
+	// volumes. A failed destroy is retried with a per volume backoff.
+	destroyQueue workqueue.RateLimitingInterface
+
+	// destroyConcurrency is the number of volumes destroyed at the same
+	// time.
+	destroyConcurrency int
+
+	// destroyVolume destroys the volume and removes its finalizer, can be
+	// replaced in unit tests.
+	destroyVolume func(zv *apis.ZFSVolume) error
+
+	// archiver archives the volumes before they are destroyed.
+	archiver *archiver
+
+	// prealloc runs the preallocation of the zvols.
+	prealloc *preallocator
+
+	// datacheck runs the checks of the data of the volumes.
+	datacheck *dataChecker
+
 	// recorder is an event recorder for recording Event resources to the
 	// Kubernetes API.
 	recorder record.EventRecorder
@@ -66,7 +93,12 @@ type ZVControllerBuilder struct {
 // NewZVControllerBuilder returns an empty instance of controller builder.
 func NewZVControllerBuilder() *ZVControllerBuilder {
 	return &ZVControllerBuilder{
-		ZVController: &ZVController{},
+		ZVController: &ZVController{
+			destroyConcurrency: zfs.DefaultDestroyConcurrency,
+			destroyVolume:      destroyVolume,
+			prealloc:           newPreallocator(),
+			datacheck:          newDataChecker(),
+		},
 	}
 }
 
@@ -79,6 +111,7 @@ func (cb *ZVControllerBuilder) withKubeClient(ks kubernetes.Interface) *ZVContro
 // withOpenEBSClient fills openebs client to controller object.
 func (cb *ZVControllerBuilder) withOpenEBSClient(cs clientset.Interface) *ZVControllerBuilder {
 	cb.ZVController.clientset = cs
+	cb.ZVController.archiver = newArchiver(cs)
 	return cb
 }
 
@@ -99,6 +132,14 @@ func (cb *ZVControllerBuilder) withZVSynced(sl informers.SharedInformerFactory)
 // withWorkqueue adds workqueue to controller object.
 func (cb *ZVControllerBuilder) withWorkqueueRateLimiting() *ZVControllerBuilder {
 	cb.ZVController.workqueue = workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "ZV")
+	cb.ZVController.destroyQueue = workqueue.NewNamedRateLimitingQueue(
+		workqueue.NewItemExponentialFailureRateLimiter(time.Second, 5*time.Minute), "ZVDestroy")
+	return cb
+}
+
+// withDestroyConcurrency sets the number of volumes destroyed at the same time.
+func (cb *ZVControllerBuilder) withDestroyConcurrency(n int) *ZVControllerBuilder {
+	cb.ZVController.destroyConcurrency = n
 	return cb
 }
 
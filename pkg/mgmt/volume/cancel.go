@@ -0,0 +1,82 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"context"
+	"fmt"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"github.com/openebs/zfs-localpv/pkg/collector"
+	zfs "github.com/openebs/zfs-localpv/pkg/zfs"
+	corev1 "k8s.io/api/core/v1"
+	k8serror "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// provisioningVanished tells whether the request of the volume being
+// provisioned is gone: the ZFSVolume has been deleted, or deleted and
+// created again, or the controller has cancelled it as its pvc is gone.
+// A ZFSVolume is deleted right away while it is not ready, it has no
+// finalizer yet.
+func (c *ZVController) provisioningVanished(zv *apis.ZFSVolume) (bool, error) {
+	latest, err := c.getRequest(zv.Name)
+	if k8serror.IsNotFound(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return latest.UID != zv.UID || zfs.ProvisioningCancelled(latest), nil
+}
+
+// cancelProvisioning destroys the dataset created for the volume whose
+// request has vanished and deletes its ZFSVolume if it is still there. A
+// ZFSVolume created again with the same name is left to be provisioned.
+func (c *ZVController) cancelProvisioning(zv *apis.ZFSVolume) error {
+	destroyed, err := zfs.CancelProvisioning(zv)
+	if err != nil {
+		return err
+	}
+	latest, err := c.getRequest(zv.Name)
+	switch {
+	case k8serror.IsNotFound(err):
+	case err != nil:
+		return err
+	case latest.UID == zv.UID:
+		err = c.clientset.ZfsV1().ZFSVolumes(zfs.OpenEBSNamespace).Delete(context.TODO(), zv.Name, metav1.DeleteOptions{})
+		if err != nil && !k8serror.IsNotFound(err) {
+			return err
+		}
+	}
+	collector.Pending.Done(collector.OperationProvision, zv.Name)
+
+	msg := "the request of the volume is gone, no dataset had been created for it"
+	if destroyed {
+		msg = fmt.Sprintf("the request of the volume is gone, dataset %s has been destroyed", zfs.VolumeDataset(zv))
+	}
+	klog.Infof("volume: cancelled the provisioning of %s: %s", zv.Name, msg)
+	c.recorder.Event(zv, corev1.EventTypeNormal, "ProvisioningCancelled", msg)
+	return nil
+}
+
+// getRequest fetches the latest version of the ZFSVolume from the api
+// server, the lister may not have seen its deletion yet
+func (c *ZVController) getRequest(name string) (*apis.ZFSVolume, error) {
+	return c.clientset.ZfsV1().ZFSVolumes(zfs.OpenEBSNamespace).Get(context.TODO(), name, metav1.GetOptions{})
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"github.com/openebs/zfs-localpv/pkg/generated/clientset/internalclientset/fake"
+	zfs "github.com/openebs/zfs-localpv/pkg/zfs"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+)
+
+// cancelController returns the controller with the fake api server
+// holding latest, if set
+func cancelController(latest *apis.ZFSVolume) (*ZVController, *fake.Clientset) {
+	cs := fake.NewSimpleClientset()
+	if latest != nil {
+		latest = latest.DeepCopy()
+		latest.Namespace = zfs.OpenEBSNamespace
+		cs = fake.NewSimpleClientset(latest)
+	}
+	return &ZVController{clientset: cs, recorder: record.NewFakeRecorder(10)}, cs
+}
+
+func provisioningVol(uid string) *apis.ZFSVolume {
+	zv := &apis.ZFSVolume{}
+	zv.Name = "pvc-1"
+	zv.Namespace = zfs.OpenEBSNamespace
+	zv.UID = types.UID(uid)
+	zv.Spec.PoolName = "zfspv"
+	zv.Status.State = zfs.ZFSStatusPending
+	return zv
+}
+
+func TestProvisioningVanished(t *testing.T) {
+	cancelled := provisioningVol("uid-1")
+	cancelled.Annotations = map[string]string{zfs.ProvisioningCancelKey: "true"}
+	tests := map[string]struct {
+		latest *apis.ZFSVolume
+		want   bool
+	}{
+		"deleted":   {latest: nil, want: true},
+		"recreated": {latest: provisioningVol("uid-2"), want: true},
+		"cancelled": {latest: cancelled, want: true},
+		"present":   {latest: provisioningVol("uid-1"), want: false},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			c, _ := cancelController(tt.latest)
+			got, err := c.provisioningVanished(provisioningVol("uid-1"))
+			if err != nil || got != tt.want {
+				t.Errorf("provisioningVanished() = %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCancelProvisioning(t *testing.T) {
+	// the pool of the volumes does not exist, no dataset carries the
+	// marker of the request
+	tests := map[string]struct {
+		latest   *apis.ZFSVolume
+		wantKept bool
+	}{
+		"same request":      {latest: provisioningVol("uid-1")},
+		"already deleted":   {latest: nil},
+		"created once more": {latest: provisioningVol("uid-2"), wantKept: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			c, cs := cancelController(tt.latest)
+
+			if err := c.cancelProvisioning(provisioningVol("uid-1")); err != nil {
+				t.Fatalf("cancelProvisioning() = %v", err)
+			}
+			_, err := cs.ZfsV1().ZFSVolumes(zfs.OpenEBSNamespace).Get(context.TODO(), "pvc-1", metav1.GetOptions{})
+			if kept := err == nil; kept != tt.wantKept {
+				t.Errorf("ZFSVolume kept = %v (%v), want %v", kept, err, tt.wantKept)
+			}
+			select {
+			case ev := <-c.recorder.(*record.FakeRecorder).Events:
+				if !strings.Contains(ev, "ProvisioningCancelled") || !strings.Contains(ev, "no dataset had been created") {
+					t.Errorf("event %q, want the provisioning cancelled", ev)
+				}
+			default:
+				t.Errorf("no event recorded")
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	zfs "github.com/openebs/zfs-localpv/pkg/zfs"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// dataCheckRun is a check of the data of a volume running in the
+// background
+type dataCheckRun struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// dataChecker keeps track of the volumes whose data is being checked on
+// this node, the check of a volume runs in its own goroutine as it reads
+// the whole volume.
+type dataChecker struct {
+	mu      sync.Mutex
+	running map[string]*dataCheckRun
+
+	// check reads the volume
+	check func(ctx context.Context, vol *apis.ZFSVolume, progress func(int64)) (int64, []string, error)
+	// update records the status
+	update func(name string, c apis.DataCheck) error
+	// clear removes the annotations
+	clear func(name string) error
+}
+
+func newDataChecker() *dataChecker {
+	return &dataChecker{
+		running: map[string]*dataCheckRun{},
+		check:   zfs.CheckVolumeData,
+		update:  zfs.UpdateDataCheck,
+		clear:   zfs.ClearDataCheck,
+	}
+}
+
+// start checks the data of the volume in the background unless it is
+// already running
+func (d *dataChecker) start(zv *apis.ZFSVolume, finished func(apis.DataCheck)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.running[zv.Name]; ok {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	run := &dataCheckRun{cancel: cancel, done: make(chan struct{})}
+	d.running[zv.Name] = run
+
+	status := *zfs.NewDataCheck()
+	if err := d.update(zv.Name, status); err != nil {
+		klog.Errorf("volume: could not update the data check of %s: %v", zv.Name, err)
+	}
+	go func() {
+		defer close(run.done)
+		read, errs, err := d.check(ctx, zv, func(read int64) {
+			status.BytesRead = read
+			if err := d.update(zv.Name, status); err != nil {
+				klog.Errorf("volume: could not update the data check of %s: %v", zv.Name, err)
+			}
+		})
+		zfs.FinishDataCheck(&status, read, errs, err)
+
+		d.mu.Lock()
+		delete(d.running, zv.Name)
+		d.mu.Unlock()
+		finished(status)
+	}()
+}
+
+// stop cancels the check of the data of the volume and waits for it to be
+// over, it returns false if the volume was not being checked
+func (d *dataChecker) stop(name string) bool {
+	d.mu.Lock()
+	run, ok := d.running[name]
+	d.mu.Unlock()
+	if !ok {
+		return false
+	}
+	run.cancel()
+	<-run.done
+	return true
+}
+
+// syncDataCheck starts or cancels the check of the data of a ready volume
+func (c *ZVController) syncDataCheck(zv *apis.ZFSVolume) error {
+	if !zfs.DataCheckPending(zv) {
+		if zfs.DataCheckCancelRequested(zv) {
+			// nothing left to cancel
+			return c.datacheck.clear(zv.Name)
+		}
+		return nil
+	}
+	if zfs.DataCheckCancelRequested(zv) {
+		if c.datacheck.stop(zv.Name) {
+			return nil
+		}
+		// e.g. the node agent has restarted in the meantime
+		status := zfs.NewDataCheck()
+		if zv.Status.DataCheck != nil {
+			status = zv.Status.DataCheck.DeepCopy()
+		}
+		zfs.FinishDataCheck(status, status.BytesRead, nil, context.Canceled)
+		if err := c.datacheck.update(zv.Name, *status); err != nil {
+			return err
+		}
+		return c.datacheck.clear(zv.Name)
+	}
+	c.datacheck.start(zv, func(status apis.DataCheck) {
+		c.dataCheckFinished(zv, status)
+	})
+	return nil
+}
+
+// dataCheckFinished records the end of the check of the data of the volume
+// and removes the annotations asking for it
+func (c *ZVController) dataCheckFinished(zv *apis.ZFSVolume, status apis.DataCheck) {
+	if err := c.datacheck.update(zv.Name, status); err != nil {
+		// the volume may have been deleted in the meantime
+		klog.Errorf("volume: could not update the data check of %s: %v", zv.Name, err)
+		return
+	}
+	if err := c.datacheck.clear(zv.Name); err != nil {
+		klog.Errorf("volume: could not clear the data check of %s: %v", zv.Name, err)
+	}
+	switch status.Phase {
+	case apis.DataCheckPassed:
+		klog.Infof("volume: checked the data of %s, %d bytes read", zv.Name, status.BytesRead)
+		c.recorder.Event(zv, corev1.EventTypeNormal, "DataChecked",
+			fmt.Sprintf("%d bytes read without error", status.BytesRead))
+	case apis.DataCheckFailed:
+		klog.Errorf("volume: data check of %s failed: %s", zv.Name, status.Message)
+		c.recorder.Event(zv, corev1.EventTypeWarning, "DataCheckFailed", status.Message)
+	}
+}
@@ -0,0 +1,219 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	zfs "github.com/openebs/zfs-localpv/pkg/zfs"
+	"k8s.io/client-go/tools/record"
+)
+
+// fakeDataCheck blocks the check until it is released or cancelled and
+// records the status updates
+type fakeDataCheck struct {
+	mu      sync.Mutex
+	starts  int
+	clears  int
+	release chan error
+	errs    []string
+	updates []apis.DataCheck
+	updated chan struct{}
+	cleared chan struct{}
+}
+
+func dataCheckController() (*ZVController, *fakeDataCheck) {
+	f := &fakeDataCheck{release: make(chan error), updated: make(chan struct{}, 10), cleared: make(chan struct{}, 10)}
+	d := newDataChecker()
+	d.check = func(ctx context.Context, vol *apis.ZFSVolume, progress func(int64)) (int64, []string, error) {
+		f.mu.Lock()
+		f.starts++
+		f.mu.Unlock()
+		progress(1024)
+		select {
+		case <-ctx.Done():
+			return 2048, nil, ctx.Err()
+		case err := <-f.release:
+			return 4096, f.errs, err
+		}
+	}
+	d.update = func(name string, status apis.DataCheck) error {
+		f.mu.Lock()
+		f.updates = append(f.updates, status)
+		f.mu.Unlock()
+		f.updated <- struct{}{}
+		return nil
+	}
+	d.clear = func(name string) error {
+		f.mu.Lock()
+		f.clears++
+		f.mu.Unlock()
+		f.cleared <- struct{}{}
+		return nil
+	}
+	return &ZVController{datacheck: d, recorder: record.NewFakeRecorder(10)}, f
+}
+
+func (f *fakeDataCheck) last() apis.DataCheck {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.updates[len(f.updates)-1]
+}
+
+// waitPhase waits for the status to reach the phase
+func (f *fakeDataCheck) waitPhase(t *testing.T, phase apis.DataCheckPhase) apis.DataCheck {
+	for {
+		select {
+		case <-f.updated:
+			if c := f.last(); c.Phase == phase {
+				return c
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for the data check to be %s", phase)
+		}
+	}
+}
+
+// waitRead waits for the progress of the check to be reported
+func (f *fakeDataCheck) waitRead(t *testing.T) {
+	for {
+		select {
+		case <-f.updated:
+			if f.last().BytesRead > 0 {
+				return
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for the progress of the data check")
+		}
+	}
+}
+
+// waitCleared waits for the annotations to be removed
+func (f *fakeDataCheck) waitCleared(t *testing.T) {
+	select {
+	case <-f.cleared:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the annotations to be removed")
+	}
+}
+
+func dataCheckZV() *apis.ZFSVolume {
+	zv := &apis.ZFSVolume{}
+	zv.Name = "pvc-1"
+	zv.Spec.PoolName = "zfspv-pool"
+	zv.Status.State = zfs.ZFSStatusReady
+	zv.Annotations = map[string]string{zfs.DataCheckKey: "true"}
+	return zv
+}
+
+func TestSyncDataCheckPassed(t *testing.T) {
+	c, f := dataCheckController()
+	zv := dataCheckZV()
+
+	if err := c.syncDataCheck(zv); err != nil {
+		t.Fatal(err)
+	}
+	f.waitRead(t)
+	if s := f.last(); s.Phase != apis.DataCheckInProgress || s.BytesRead != 1024 {
+		t.Errorf("progress = %+v, want 1024 bytes in progress", s)
+	}
+
+	// a resync does not start it again
+	if err := c.syncDataCheck(zv); err != nil {
+		t.Fatal(err)
+	}
+	f.release <- nil
+	s := f.waitPhase(t, apis.DataCheckPassed)
+	f.waitCleared(t)
+	if s.BytesRead != 4096 || s.ChecksumErrors != 0 || s.CompletionTime.IsZero() {
+		t.Errorf("status = %+v, want passed after 4096 bytes", s)
+	}
+	if f.starts != 1 || f.clears != 1 {
+		t.Errorf("check started %d times and cleared %d times, want once", f.starts, f.clears)
+	}
+
+	// once the annotation is removed, the volume is not checked again
+	zv.Annotations = nil
+	zv.Status.DataCheck = &s
+	if err := c.syncDataCheck(zv); err != nil || f.starts != 1 {
+		t.Errorf("checked volume: error %v, started %d times", err, f.starts)
+	}
+}
+
+func TestSyncDataCheckErrors(t *testing.T) {
+	c, f := dataCheckController()
+	f.errs = []string{"zfspv-pool/pvc-1@openebs-datacheck:<0x1>", "zfspv-pool/pvc-1:/db/base.1"}
+
+	if err := c.syncDataCheck(dataCheckZV()); err != nil {
+		t.Fatal(err)
+	}
+	f.release <- errors.New("exit status 1 cannot send: I/O error")
+	s := f.waitPhase(t, apis.DataCheckFailed)
+	if s.ChecksumErrors != 2 || len(s.Errors) != 2 || s.Message == "" {
+		t.Errorf("status = %+v, want failed with 2 files with errors", s)
+	}
+}
+
+func TestSyncDataCheckCancel(t *testing.T) {
+	c, f := dataCheckController()
+	zv := dataCheckZV()
+
+	if err := c.syncDataCheck(zv); err != nil {
+		t.Fatal(err)
+	}
+	f.waitRead(t)
+
+	zv.Annotations[zfs.DataCheckCancelKey] = "true"
+	if err := c.syncDataCheck(zv); err != nil {
+		t.Fatal(err)
+	}
+	s := f.waitPhase(t, apis.DataCheckCancelled)
+	f.waitCleared(t)
+	if s.BytesRead != 2048 {
+		t.Errorf("status = %+v, want cancelled after 2048 bytes", s)
+	}
+	if f.clears != 1 {
+		t.Errorf("annotations cleared %d times, want once", f.clears)
+	}
+	if c.datacheck.stop(zv.Name) {
+		t.Error("data check is still running after the cancel")
+	}
+}
+
+func TestSyncDataCheckCancelNotRunning(t *testing.T) {
+	c, f := dataCheckController()
+	// the node agent has restarted in the middle of the check
+	zv := dataCheckZV()
+	zv.Annotations[zfs.DataCheckCancelKey] = "true"
+	zv.Status.DataCheck = zfs.NewDataCheck()
+	zv.Status.DataCheck.BytesRead = 512
+
+	if err := c.syncDataCheck(zv); err != nil {
+		t.Fatal(err)
+	}
+	if s := f.last(); s.Phase != apis.DataCheckCancelled || s.BytesRead != 512 || f.starts != 0 {
+		t.Errorf("status = %+v, started %d times, want cancelled", s, f.starts)
+	}
+	if f.clears != 1 {
+		t.Errorf("annotations cleared %d times, want once", f.clears)
+	}
+}
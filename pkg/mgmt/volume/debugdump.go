@@ -0,0 +1,40 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	zfs "github.com/openebs/zfs-localpv/pkg/zfs"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// debugDump captures the zfs properties and the snapshots of the volume
+// into its debug dump ConfigMap and removes the annotation asking for it
+func (c *ZVController) debugDump(zv *apis.ZFSVolume) error {
+	data := zfs.CaptureDebugDump(zv)
+	if err := zfs.SaveDebugDump(c.kubeclientset, zv, data); err != nil {
+		return err
+	}
+	if err := zfs.ClearDebugDump(zv); err != nil {
+		return err
+	}
+	name := zfs.OpenEBSNamespace + "/" + zfs.DebugDumpName(zv.Name)
+	klog.Infof("volume: saved the debug dump of %s in configmap %s", zv.Name, name)
+	c.recorder.Event(zv, corev1.EventTypeNormal, "DebugDump", "debug dump saved in configmap "+name)
+	return nil
+}
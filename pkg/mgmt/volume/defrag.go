@@ -0,0 +1,90 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	zfs "github.com/openebs/zfs-localpv/pkg/zfs"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// defragment rewrites the volume into a new dataset and removes the
+// annotation asking for it. The progress is recorded in the status, which
+// also keeps the volume from being published in the meantime.
+func (c *ZVController) defragment(zv *apis.ZFSVolume) error {
+	// the lister may still hold the volume from before the last run
+	zv, err := zfs.GetZFSVolume(zv.Name)
+	if err != nil || !zfs.DefragmentationPending(zv) {
+		return err
+	}
+
+	status := apis.Defragmentation{Phase: apis.DefragmentationInProgress, StartTime: metav1.Now()}
+	if d := zv.Status.Defragmentation; d != nil && d.Phase == apis.DefragmentationInProgress {
+		// resumed after a restart of the node agent
+		status.StartTime = d.StartTime
+	}
+	derr := zfs.Defragment(zv, func(step string) {
+		status.Step = step
+		if err := zfs.UpdateDefragmentation(zv.Name, status); err != nil {
+			klog.Errorf("volume: could not update the defragmentation of %s: %v", zv.Name, err)
+		}
+	})
+
+	status.Step = ""
+	status.CompletionTime = metav1.Now()
+	if derr != nil {
+		status.Phase = apis.DefragmentationFailed
+		status.Message = derr.Error()
+	} else {
+		status.Phase = apis.DefragmentationDone
+	}
+	if err = c.defragmented(zv.Name, status); err != nil {
+		return err
+	}
+	if err = zfs.ClearDefragment(zv); err != nil {
+		return err
+	}
+
+	if derr != nil {
+		klog.Errorf("volume: could not defragment %s: %v", zv.Name, derr)
+		c.recorder.Event(zv, corev1.EventTypeWarning, "DefragmentationFailed", status.Message)
+		return nil
+	}
+	klog.Infof("volume: defragmented %s", zv.Name)
+	c.recorder.Event(zv, corev1.EventTypeNormal, "Defragmented", "the volume has been rewritten into a new dataset")
+	return nil
+}
+
+// defragmented records the end of the defragmentation on the latest
+// version of the volume. The copy does not depend on the origin snapshot
+// of a clone, the volume is then recorded as independent.
+func (c *ZVController) defragmented(name string, status apis.Defragmentation) error {
+	zv, err := zfs.GetZFSVolume(name)
+	if err != nil {
+		return err
+	}
+	zv.Status.Defragmentation = &status
+	if status.Phase == apis.DefragmentationDone {
+		zfs.SetCloneOrigin(zv, "", "")
+		if _, _, err = zfs.ReconcileDivergence(zv); err != nil {
+			klog.Errorf("volume: could not check the divergence of %s: %v", zv.Name, err)
+		}
+	}
+	return zfs.UpdateVolumeStatus(zv)
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"fmt"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	zfs "github.com/openebs/zfs-localpv/pkg/zfs"
+	k8serror "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/cache"
+)
+
+// destroyVolume destroys the dataset or zvol of the volume and removes
+// its finalizer
+func destroyVolume(zv *apis.ZFSVolume) error {
+	if err := zfs.DestroyVolume(zv); err != nil {
+		return err
+	}
+	return zfs.RemoveVolumeFinalizer(zv)
+}
+
+// enqueueDestroy puts the volume onto the destroy queue
+func (c *ZVController) enqueueDestroy(zv *apis.ZFSVolume) {
+	key, err := cache.MetaNamespaceKeyFunc(zv)
+	if err != nil {
+		runtime.HandleError(err)
+		return
+	}
+	c.destroyQueue.Add(key)
+}
+
+// runDestroyWorker processes the destroy queue until it is shut down
+func (c *ZVController) runDestroyWorker() {
+	for c.processNext(c.destroyQueue, c.destroyHandler) {
+	}
+}
+
+// destroyHandler destroys the volume of the key if it is still to be
+// deleted
+func (c *ZVController) destroyHandler(key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		runtime.HandleError(fmt.Errorf("invalid resource key: %s", key))
+		return nil
+	}
+	zv, err := c.zvLister.ZFSVolumes(namespace).Get(name)
+	if k8serror.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !c.isDeletionCandidate(zv) {
+		return nil
+	}
+	zvCopy := zv.DeepCopy()
+	if _, err = zfs.ResolveVolumePool(c.clientset, zvCopy, zfs.PoolAliasUpdate); err != nil {
+		return err
+	}
+	return c.destroyZV(zvCopy)
+}
+
+// destroyZV destroys the volume once the other finalizers have been
+// removed. The clones of its snapshots which are being deleted too are
+// destroyed first, the volume is retried with a backoff meanwhile. A
+// volume with the ArchiveThenDelete reclaim policy is archived first.
+func (c *ZVController) destroyZV(zv *apis.ZFSVolume) error {
+	if userFin := zfs.GetUserFinalizers(zv.Finalizers); len(userFin) != 0 {
+		return fmt.Errorf("volume: can not destroy, waiting for finalizers to be removed %v", userFin)
+	}
+	if err := zfs.CheckVolumeDestroy(zv); err != nil {
+		return c.destroyPaused(zv, err)
+	}
+	// the zvol can not be destroyed while it is written to, nor the
+	// volume while its snapshot is read
+	c.prealloc.stop(zv.Name)
+	c.datacheck.stop(zv.Name)
+
+	vols, err := c.zvLister.ZFSVolumes(zv.Namespace).List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	var deleting []*apis.ZFSVolume
+	for _, v := range vols {
+		if v.Spec.OwnerNodeID == zv.Spec.OwnerNodeID && c.isDeletionCandidate(v) {
+			deleting = append(deleting, v)
+		}
+	}
+	if clones := zfs.DependentClones(zv, deleting); len(clones) != 0 {
+		return fmt.Errorf("volume: waiting for the clones %v to be destroyed first", clones)
+	}
+
+	if zfs.ArchiveRequired(zv) {
+		if err := c.archiveZV(zv); err != nil {
+			return err
+		}
+	}
+	// the generated key goes away with the volume
+	if err := zfs.DeleteKeySecret(c.kubeclientset, zv); err != nil {
+		return err
+	}
+	return c.destroyVolume(zv)
+}
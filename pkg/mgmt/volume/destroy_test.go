@@ -0,0 +1,343 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	listers "github.com/openebs/zfs-localpv/pkg/generated/lister/zfs/v1"
+	zfs "github.com/openebs/zfs-localpv/pkg/zfs"
+	corev1 "k8s.io/api/core/v1"
+	k8serror "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+)
+
+func deletingVol(name, snapName string) *apis.ZFSVolume {
+	now := metav1.Now()
+	zv := &apis.ZFSVolume{}
+	zv.Name = name
+	zv.Namespace = "openebs"
+	zv.DeletionTimestamp = &now
+	zv.Finalizers = []string{zfs.ZFSFinalizer}
+	zv.Spec.PoolName = "zfspv"
+	zv.Spec.OwnerNodeID = "node1"
+	zv.Spec.SnapName = snapName
+	return zv
+}
+
+// destroyController returns a controller for the volumes whose destroy
+// removes the volume from the lister
+func destroyController(t *testing.T, destroy func(zv *apis.ZFSVolume) error, vols ...*apis.ZFSVolume) *ZVController {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, zv := range vols {
+		if err := indexer.Add(zv); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := &ZVController{
+		zvLister: listers.NewZFSVolumeLister(indexer),
+		destroyQueue: workqueue.NewRateLimitingQueue(
+			workqueue.NewItemExponentialFailureRateLimiter(time.Millisecond, 10*time.Millisecond)),
+		destroyConcurrency: 2,
+		prealloc:           newPreallocator(),
+		datacheck:          newDataChecker(),
+	}
+	c.destroyVolume = func(zv *apis.ZFSVolume) error {
+		if err := destroy(zv); err != nil {
+			return err
+		}
+		return indexer.Delete(zv)
+	}
+	return c
+}
+
+func TestDestroyClonesFirst(t *testing.T) {
+	var destroyed []string
+	origin := deletingVol("pvc-origin", "")
+	clone := deletingVol("pvc-clone", "pvc-origin@snap-1")
+	c := destroyController(t, func(zv *apis.ZFSVolume) error {
+		destroyed = append(destroyed, zv.Name)
+		return nil
+	}, origin, clone)
+
+	if err := c.destroyZV(origin.DeepCopy()); err == nil {
+		t.Fatalf("origin destroyed before its clone")
+	}
+	if err := c.destroyZV(clone.DeepCopy()); err != nil {
+		t.Fatalf("destroy of the clone failed: %v", err)
+	}
+	if err := c.destroyZV(origin.DeepCopy()); err != nil {
+		t.Fatalf("destroy of the origin failed: %v", err)
+	}
+	if len(destroyed) != 2 || destroyed[0] != "pvc-clone" || destroyed[1] != "pvc-origin" {
+		t.Errorf("destroyed %v, want the clone first", destroyed)
+	}
+}
+
+func TestDestroyKeptCloneDoesNotBlock(t *testing.T) {
+	origin := deletingVol("pvc-origin", "")
+	kept := deletingVol("pvc-kept", "pvc-origin@snap-1")
+	kept.DeletionTimestamp = nil
+	attempted := false
+	c := destroyController(t, func(zv *apis.ZFSVolume) error {
+		attempted = true
+		return nil
+	}, origin, kept)
+
+	// zfs decides whether the origin can go, as before
+	if err := c.destroyZV(origin.DeepCopy()); err != nil || !attempted {
+		t.Errorf("destroyZV() = %v, attempted %v", err, attempted)
+	}
+}
+
+func TestDestroyConcurrent(t *testing.T) {
+	entered := make(chan string, 2)
+	release := make(chan struct{})
+	c := destroyController(t, func(zv *apis.ZFSVolume) error {
+		entered <- zv.Name
+		<-release
+		return nil
+	}, deletingVol("pvc-a", ""), deletingVol("pvc-b", ""))
+	vols, _ := c.zvLister.List(labels.Everything())
+
+	var wg sync.WaitGroup
+	for i := 0; i < c.destroyConcurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.runDestroyWorker()
+		}()
+	}
+	for _, zv := range vols {
+		c.enqueueDestroy(zv)
+	}
+
+	// both destroys have to be in flight at the same time
+	for i := 0; i < 2; i++ {
+		select {
+		case <-entered:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("independent volumes are not destroyed concurrently")
+		}
+	}
+	close(release)
+	c.destroyQueue.ShutDownWithDrain()
+	wg.Wait()
+}
+
+func TestDestroyRetry(t *testing.T) {
+	var mu sync.Mutex
+	attempts := map[string]int{}
+	done := make(chan string, 4)
+	busy, free := deletingVol("pvc-busy", ""), deletingVol("pvc-free", "")
+	c := destroyController(t, func(zv *apis.ZFSVolume) error {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts[zv.Name]++
+		if zv.Name == "pvc-busy" && attempts[zv.Name] < 3 {
+			return errors.New("dataset is busy")
+		}
+		done <- zv.Name
+		return nil
+	}, busy, free)
+	c.destroyConcurrency = 1
+
+	go c.runDestroyWorker()
+	defer c.destroyQueue.ShutDown()
+	c.enqueueDestroy(busy)
+	c.enqueueDestroy(free)
+
+	var order []string
+	for len(order) < 2 {
+		select {
+		case name := <-done:
+			order = append(order, name)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("destroyed %v, want both volumes", order)
+		}
+	}
+	// the failing volume does not hold up the other one
+	if order[0] != "pvc-free" || order[1] != "pvc-busy" {
+		t.Errorf("destroyed %v, want pvc-free first", order)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if attempts["pvc-busy"] != 3 {
+		t.Errorf("pvc-busy attempted %d times, want 3", attempts["pvc-busy"])
+	}
+}
+
+// fakeArchives records the volumes destroyed and the archives, the ones
+// of the ZFSNode fail with nodeErr
+type fakeArchives struct {
+	destroyed []string
+	recorded  []*apis.VolumeArchive
+	node      []string
+	nodeErr   error
+}
+
+// archiveController returns a controller for the volume whose archive
+// fails with archiveErr
+func archiveController(t *testing.T, zv *apis.ZFSVolume, archiveErr error) (*ZVController, *fakeArchives) {
+	f := &fakeArchives{}
+	c := destroyController(t, func(zv *apis.ZFSVolume) error {
+		f.destroyed = append(f.destroyed, zv.Name)
+		return nil
+	}, zv)
+	c.recorder = record.NewFakeRecorder(10)
+	c.archiver = &archiver{
+		send: func(zv *apis.ZFSVolume) (*apis.VolumeArchive, error) {
+			if archiveErr != nil {
+				return nil, archiveErr
+			}
+			return &apis.VolumeArchive{Phase: apis.ArchiveCompleted, Snapshot: "zfspv/" + zv.Name + "@openebs-archive",
+				Target: zv.Spec.ArchiveTarget, Bytes: 1024, Time: metav1.Now()}, nil
+		},
+		recordNode: func(zv *apis.ZFSVolume, archive *apis.VolumeArchive) error {
+			if f.nodeErr != nil {
+				return f.nodeErr
+			}
+			f.node = append(f.node, zv.Name+" "+archive.Snapshot)
+			return nil
+		},
+		recordVolume: func(zv *apis.ZFSVolume, archive *apis.VolumeArchive) error {
+			zv.Status.Archive = archive
+			f.recorded = append(f.recorded, archive)
+			return nil
+		},
+	}
+	return c, f
+}
+
+func archivingVol() *apis.ZFSVolume {
+	zv := deletingVol("pvc-archive", "")
+	zv.Spec.ReclaimPolicy = zfs.ReclaimPolicyArchiveThenDelete
+	zv.Spec.ArchiveTarget = "archive.example.com:9010"
+	return zv
+}
+
+func TestDestroyArchiveThenDelete(t *testing.T) {
+	c, f := archiveController(t, archivingVol(), nil)
+
+	if err := c.destroyZV(archivingVol()); err != nil {
+		t.Fatalf("destroyZV() unexpected error %v", err)
+	}
+	if len(f.recorded) != 1 || f.recorded[0].Phase != apis.ArchiveCompleted {
+		t.Errorf("destroyZV() recorded archives %+v, want a completed one", f.recorded)
+	}
+	if len(f.destroyed) != 1 {
+		t.Errorf("destroyZV() destroyed %v, want the archived volume", f.destroyed)
+	}
+	if want := []string{"pvc-archive zfspv/pvc-archive@openebs-archive"}; !reflect.DeepEqual(f.node, want) {
+		t.Errorf("destroyZV() recorded node archives %v, want %v", f.node, want)
+	}
+	event := <-c.recorder.(*record.FakeRecorder).Events
+	if !strings.Contains(event, "Archived") || !strings.Contains(event, "zfspv/pvc-archive@openebs-archive") {
+		t.Errorf("destroyZV() event %q, want the location of the archive", event)
+	}
+
+	// an archived volume is not sent again if its destroy is retried
+	zv := archivingVol()
+	zv.Status.Archive = &apis.VolumeArchive{Phase: apis.ArchiveCompleted}
+	c.archiver.send = func(*apis.ZFSVolume) (*apis.VolumeArchive, error) {
+		t.Errorf("destroyZV() archived the volume again")
+		return nil, nil
+	}
+	if err := c.destroyZV(zv); err != nil || len(f.destroyed) != 2 {
+		t.Errorf("destroyZV() of an archived volume = %v, destroyed %v", err, f.destroyed)
+	}
+}
+
+func TestDestroyArchiveFailurePauses(t *testing.T) {
+	c, f := archiveController(t, archivingVol(), errors.New("connection refused"))
+
+	if err := c.destroyZV(archivingVol()); err == nil {
+		t.Fatalf("destroyZV() destroyed the volume whose archive failed")
+	}
+	if len(f.destroyed) != 0 {
+		t.Errorf("destroyZV() destroyed %v before the archive", f.destroyed)
+	}
+	if len(f.recorded) != 1 || f.recorded[0].Phase != apis.ArchiveFailed ||
+		f.recorded[0].Message != "connection refused" {
+		t.Errorf("destroyZV() recorded archives %+v, want the failure", f.recorded)
+	}
+	event := <-c.recorder.(*record.FakeRecorder).Events
+	if !strings.Contains(event, "Warning ArchiveFailed") {
+		t.Errorf("destroyZV() event %q, want ArchiveFailed", event)
+	}
+
+	// the destroy goes on once the archive succeeds on a retry
+	c.archiver.send = func(zv *apis.ZFSVolume) (*apis.VolumeArchive, error) {
+		return &apis.VolumeArchive{Phase: apis.ArchiveCompleted, Target: zv.Spec.ArchiveTarget}, nil
+	}
+	if err := c.destroyZV(archivingVol()); err != nil || len(f.destroyed) != 1 {
+		t.Errorf("destroyZV() after the archive = %v, destroyed %v", err, f.destroyed)
+	}
+}
+
+func TestDestroyArchiveRecordPauses(t *testing.T) {
+	c, f := archiveController(t, archivingVol(), nil)
+	f.nodeErr = errors.New("conflict")
+
+	// the volume is kept until the ZFSNode has the location of its archive
+	if err := c.destroyZV(archivingVol()); err == nil {
+		t.Fatalf("destroyZV() destroyed the volume whose archive is not recorded")
+	}
+	if len(f.destroyed) != 0 || len(f.recorded) != 0 {
+		t.Errorf("destroyZV() destroyed %v and recorded %+v without the node record", f.destroyed, f.recorded)
+	}
+
+	f.nodeErr = nil
+	if err := c.destroyZV(archivingVol()); err != nil || len(f.destroyed) != 1 {
+		t.Errorf("destroyZV() once recorded = %v, destroyed %v", err, f.destroyed)
+	}
+}
+
+func TestDestroyKeySecret(t *testing.T) {
+	zv := deletingVol("pvc-1", "")
+	zv.Spec.KeySecret = "openebs/zfs-key-pvc-1"
+	destroyed := false
+	c := destroyController(t, func(zv *apis.ZFSVolume) error {
+		destroyed = true
+		return nil
+	}, zv)
+	key := &corev1.Secret{}
+	key.Name = "zfs-key-pvc-1"
+	key.Namespace = "openebs"
+	c.kubeclientset = fake.NewSimpleClientset(key)
+
+	// the generated key goes away with the volume
+	if err := c.destroyZV(zv.DeepCopy()); err != nil || !destroyed {
+		t.Fatalf("destroyZV() = %v, destroyed %v", err, destroyed)
+	}
+	_, err := c.kubeclientset.CoreV1().Secrets("openebs").Get(context.TODO(), "zfs-key-pvc-1", metav1.GetOptions{})
+	if !k8serror.IsNotFound(err) {
+		t.Errorf("destroyZV() left the key secret, get = %v", err)
+	}
+}
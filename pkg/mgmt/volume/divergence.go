@@ -0,0 +1,98 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"errors"
+	"time"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	zfs "github.com/openebs/zfs-localpv/pkg/zfs"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+)
+
+// cloneDivergenceInterval is the interval at which the divergence of the
+// clones from their origin snapshot is checked
+const cloneDivergenceInterval = 5 * time.Minute
+
+// checkCloneDivergence goes through the ready clones of this node, records
+// how far they have diverged from their origin snapshot and makes them
+// independent once they exceed their IndependenceThreshold
+func (c *ZVController) checkCloneDivergence() {
+	vols, err := c.zvLister.ZFSVolumes(zfs.OpenEBSNamespace).List(labels.Everything())
+	if err != nil {
+		klog.Errorf("volume: could not list the volumes for clone divergence: %v", err)
+		return
+	}
+	for _, v := range vols {
+		if v.Spec.OwnerNodeID != zfs.NodeID || c.isDeletionCandidate(v) || !zfs.IsVolumeReady(v) ||
+			(len(v.Spec.SnapName) == 0 && v.Status.CloneDivergence == nil) ||
+			zfs.DefragmentationPending(v) {
+			continue
+		}
+		zv := v.DeepCopy()
+		changed, independ, err := zfs.ReconcileDivergence(zv)
+		if err != nil {
+			klog.Errorf("volume: could not check the divergence of %s: %v", zv.Name, err)
+			continue
+		}
+		if independ {
+			cond := meta.FindStatusCondition(zv.Status.Conditions, zfs.ConditionCloneDiverged)
+			if err = zfs.IndependClone(zv); err != nil {
+				reason := "IndependenceFailed"
+				if errors.Is(err, zfs.ErrCloneInUse) {
+					reason = "IndependenceDeferred"
+				}
+				c.recorder.Event(zv, corev1.EventTypeWarning, reason, err.Error())
+			} else {
+				c.recorder.Event(zv, corev1.EventTypeNormal, "CloneIndependent", cond.Message)
+				// the copy has no origin, which clears the divergence
+				if _, _, err = zfs.ReconcileDivergence(zv); err != nil {
+					klog.Errorf("volume: could not check the divergence of %s: %v", zv.Name, err)
+				}
+				changed = true
+			}
+		}
+		// a promoted clone has lost its origin too
+		origin := ""
+		if zv.Status.CloneDivergence != nil {
+			origin = zv.Status.CloneDivergence.Origin
+		}
+		if zfs.SetCloneOrigin(zv, origin, zfs.OriginVolume(origin, vols)) {
+			changed = true
+		}
+		if !changed {
+			continue
+		}
+		if err = zfs.UpdateVolumeStatus(zv); err != nil {
+			klog.Errorf("volume: could not update the clone divergence of %s: %v", zv.Name, err)
+		}
+	}
+}
+
+// setCloneOrigin records the origin snapshot of the clone, along with the
+// volume it belongs to, in the status
+func (c *ZVController) setCloneOrigin(zv *apis.ZFSVolume, origin string) {
+	vols, err := c.zvLister.ZFSVolumes(zfs.OpenEBSNamespace).List(labels.Everything())
+	if err != nil {
+		klog.Errorf("volume: could not list the volumes for the origin of %s: %v", zv.Name, err)
+	}
+	zfs.SetCloneOrigin(zv, origin, zfs.OriginVolume(origin, vols))
+}
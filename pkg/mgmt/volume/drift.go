@@ -0,0 +1,63 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"time"
+
+	zfs "github.com/openebs/zfs-localpv/pkg/zfs"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+)
+
+// capacityDriftInterval is the interval at which the live capacity of the
+// volumes is compared with their spec
+const capacityDriftInterval = time.Minute
+
+// checkCapacityDrift goes through the ready volumes of this node and
+// reconciles their live capacity as per zfs.CapacityDriftMode
+func (c *ZVController) checkCapacityDrift() {
+	vols, err := c.zvLister.ZFSVolumes(zfs.OpenEBSNamespace).List(labels.Everything())
+	if err != nil {
+		klog.Errorf("volume: could not list the volumes for capacity drift: %v", err)
+		return
+	}
+	for _, v := range vols {
+		if v.Spec.OwnerNodeID != zfs.NodeID || c.isDeletionCandidate(v) || !zfs.IsVolumeReady(v) {
+			continue
+		}
+		zv := v.DeepCopy()
+		changed, err := zfs.ReconcileCapacity(zv, zfs.CapacityDriftMode)
+		if err != nil {
+			klog.Errorf("volume: could not check the capacity of %s: %v", zv.Name, err)
+			continue
+		}
+		if !changed {
+			continue
+		}
+		if err = zfs.UpdateVolumeStatus(zv); err != nil {
+			klog.Errorf("volume: could not update the capacity drift of %s: %v", zv.Name, err)
+			continue
+		}
+		if meta.IsStatusConditionTrue(zv.Status.Conditions, zfs.ConditionCapacityDrift) {
+			cond := meta.FindStatusCondition(zv.Status.Conditions, zfs.ConditionCapacityDrift)
+			c.recorder.Event(zv, corev1.EventTypeWarning, cond.Reason, cond.Message)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	zfs "github.com/openebs/zfs-localpv/pkg/zfs"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// preallocRun is a preallocation running in the background
+type preallocRun struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// preallocator keeps track of the zvols being preallocated on this node,
+// the preallocation of a zvol runs in its own goroutine as it can take a
+// long time.
+type preallocator struct {
+	mu      sync.Mutex
+	running map[string]*preallocRun
+
+	// preallocate writes the zvol, can be replaced in unit tests
+	preallocate func(ctx context.Context, vol *apis.ZFSVolume, offset int64, progress func(int64)) (int64, error)
+	// update records the status, can be replaced in unit tests
+	update func(name string, p apis.Preallocation) error
+}
+
+func newPreallocator() *preallocator {
+	return &preallocator{
+		running:     map[string]*preallocRun{},
+		preallocate: zfs.Preallocate,
+		update:      zfs.UpdatePreallocation,
+	}
+}
+
+// start preallocates the zvol in the background unless it is already
+// running, it resumes from the bytes already written
+func (p *preallocator) start(zv *apis.ZFSVolume, finished func(apis.Preallocation)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.running[zv.Name]; ok {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	run := &preallocRun{cancel: cancel, done: make(chan struct{})}
+	p.running[zv.Name] = run
+
+	status := *zfs.NewPreallocation(zv)
+	if zv.Status.Preallocation != nil {
+		status.Written = zv.Status.Preallocation.Written
+	}
+	go func() {
+		defer close(run.done)
+		written, err := p.preallocate(ctx, zv, status.Written, func(written int64) {
+			status.Written = written
+			if err := p.update(zv.Name, status); err != nil {
+				klog.Errorf("volume: could not update the preallocation of %s: %v", zv.Name, err)
+			}
+		})
+		status.Written = written
+		switch {
+		case err == nil:
+			status.Phase = apis.PreallocationDone
+		case errors.Is(err, context.Canceled):
+			status.Phase = apis.PreallocationCancelled
+			status.Message = "cancelled with the " + zfs.PreallocCancelKey + " annotation"
+		default:
+			status.Phase = apis.PreallocationFailed
+			status.Message = err.Error()
+		}
+
+		p.mu.Lock()
+		delete(p.running, zv.Name)
+		p.mu.Unlock()
+		finished(status)
+	}()
+}
+
+// stop cancels the preallocation of the zvol and waits for it to be over,
+// it returns false if the zvol was not being preallocated
+func (p *preallocator) stop(name string) bool {
+	p.mu.Lock()
+	run, ok := p.running[name]
+	p.mu.Unlock()
+	if !ok {
+		return false
+	}
+	run.cancel()
+	<-run.done
+	return true
+}
+
+// syncPreallocation starts, resumes or cancels the preallocation of a
+// ready zvol
+func (c *ZVController) syncPreallocation(zv *apis.ZFSVolume) error {
+	if !zfs.PreallocationPending(zv) {
+		return nil
+	}
+	if zfs.PreallocCancelRequested(zv) {
+		if c.prealloc.stop(zv.Name) {
+			return nil
+		}
+		// e.g. the node agent has restarted in the meantime
+		status := *zfs.NewPreallocation(zv)
+		if zv.Status.Preallocation != nil {
+			status.Written = zv.Status.Preallocation.Written
+		}
+		status.Phase = apis.PreallocationCancelled
+		status.Message = "cancelled with the " + zfs.PreallocCancelKey + " annotation"
+		return c.prealloc.update(zv.Name, status)
+	}
+	c.prealloc.start(zv, func(status apis.Preallocation) {
+		c.preallocFinished(zv, status)
+	})
+	return nil
+}
+
+// preallocFinished records the end of the preallocation of the zvol
+func (c *ZVController) preallocFinished(zv *apis.ZFSVolume, status apis.Preallocation) {
+	if err := c.prealloc.update(zv.Name, status); err != nil {
+		// the volume may have been deleted in the meantime
+		klog.Errorf("volume: could not update the preallocation of %s: %v", zv.Name, err)
+		return
+	}
+	switch status.Phase {
+	case apis.PreallocationDone:
+		klog.Infof("volume: preallocated %s", zv.Name)
+		c.recorder.Event(zv, corev1.EventTypeNormal, "Preallocated", "the whole zvol has been written")
+	case apis.PreallocationFailed:
+		c.recorder.Event(zv, corev1.EventTypeWarning, "PreallocationFailed", status.Message)
+	}
+}
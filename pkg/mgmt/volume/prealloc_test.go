@@ -0,0 +1,167 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	zfs "github.com/openebs/zfs-localpv/pkg/zfs"
+	"k8s.io/client-go/tools/record"
+)
+
+// fakePrealloc blocks the preallocation until it is released or cancelled
+// and records the status updates
+type fakePrealloc struct {
+	mu      sync.Mutex
+	starts  int
+	release chan struct{}
+	updates []apis.Preallocation
+	updated chan struct{}
+}
+
+func preallocController() (*ZVController, *fakePrealloc) {
+	f := &fakePrealloc{release: make(chan struct{}), updated: make(chan struct{}, 10)}
+	p := newPreallocator()
+	p.preallocate = func(ctx context.Context, vol *apis.ZFSVolume, offset int64, progress func(int64)) (int64, error) {
+		f.mu.Lock()
+		f.starts++
+		f.mu.Unlock()
+		progress(offset + 1024)
+		select {
+		case <-ctx.Done():
+			return offset + 1024, ctx.Err()
+		case <-f.release:
+			return 4096, nil
+		}
+	}
+	p.update = func(name string, status apis.Preallocation) error {
+		f.mu.Lock()
+		f.updates = append(f.updates, status)
+		f.mu.Unlock()
+		f.updated <- struct{}{}
+		return nil
+	}
+	return &ZVController{prealloc: p, recorder: record.NewFakeRecorder(10)}, f
+}
+
+func (f *fakePrealloc) last() apis.Preallocation {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.updates[len(f.updates)-1]
+}
+
+func (f *fakePrealloc) wait(t *testing.T) {
+	select {
+	case <-f.updated:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the preallocation status")
+	}
+}
+
+func preallocZV() *apis.ZFSVolume {
+	zv := &apis.ZFSVolume{}
+	zv.Name = "pvc-1"
+	zv.Spec.Capacity = "4096"
+	zv.Spec.VolumeType = zfs.VolTypeZVol
+	zv.Spec.Preallocate = "yes"
+	zv.Status.State = zfs.ZFSStatusReady
+	zv.Status.Preallocation = zfs.NewPreallocation(zv)
+	return zv
+}
+
+func TestSyncPreallocationDone(t *testing.T) {
+	c, f := preallocController()
+	zv := preallocZV()
+
+	if err := c.syncPreallocation(zv); err != nil {
+		t.Fatal(err)
+	}
+	f.wait(t)
+	if p := f.last(); p.Phase != apis.PreallocationInProgress || p.Written != 1024 {
+		t.Errorf("progress = %+v, want 1024 bytes in progress", p)
+	}
+
+	// a resync does not start it again
+	if err := c.syncPreallocation(zv); err != nil {
+		t.Fatal(err)
+	}
+	close(f.release)
+	f.wait(t)
+	if p := f.last(); p.Phase != apis.PreallocationDone || p.Written != 4096 || p.Total != 4096 {
+		t.Errorf("status = %+v, want done", p)
+	}
+	if f.starts != 1 {
+		t.Errorf("preallocation started %d times, want once", f.starts)
+	}
+
+	zv.Status.Preallocation.Phase = apis.PreallocationDone
+	if err := c.syncPreallocation(zv); err != nil || f.starts != 1 {
+		t.Errorf("preallocated volume: error %v, started %d times", err, f.starts)
+	}
+}
+
+func TestSyncPreallocationCancel(t *testing.T) {
+	c, f := preallocController()
+	zv := preallocZV()
+	zv.Status.Preallocation.Written = 2048
+
+	if err := c.syncPreallocation(zv); err != nil {
+		t.Fatal(err)
+	}
+	f.wait(t)
+
+	zv.Annotations = map[string]string{zfs.PreallocCancelKey: "true"}
+	if err := c.syncPreallocation(zv); err != nil {
+		t.Fatal(err)
+	}
+	f.wait(t)
+	if p := f.last(); p.Phase != apis.PreallocationCancelled || p.Written != 3072 {
+		t.Errorf("status = %+v, want cancelled after 3072 bytes", p)
+	}
+}
+
+func TestSyncPreallocationCancelNotRunning(t *testing.T) {
+	c, f := preallocController()
+	zv := preallocZV()
+	zv.Annotations = map[string]string{zfs.PreallocCancelKey: "true"}
+
+	if err := c.syncPreallocation(zv); err != nil {
+		t.Fatal(err)
+	}
+	if p := f.last(); p.Phase != apis.PreallocationCancelled || f.starts != 0 {
+		t.Errorf("status = %+v, started %d times, want cancelled", p, f.starts)
+	}
+}
+
+func TestPreallocationStoppedOnDestroy(t *testing.T) {
+	c, f := preallocController()
+	if err := c.syncPreallocation(preallocZV()); err != nil {
+		t.Fatal(err)
+	}
+	f.wait(t)
+
+	if !c.prealloc.stop("pvc-1") {
+		t.Fatal("preallocation was not running")
+	}
+	if c.prealloc.stop("pvc-1") {
+		t.Errorf("preallocation is still running after stop")
+	}
+}
@@ -0,0 +1,38 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	zfs "github.com/openebs/zfs-localpv/pkg/zfs"
+	"k8s.io/klog/v2"
+)
+
+// syncProperties refreshes the requested and the effective properties in
+// the status of a ready volume. It is only a report, the reconcile goes on
+// if the properties can not be read.
+func (c *ZVController) syncProperties(zv *apis.ZFSVolume) error {
+	changed, err := zfs.ReconcileProperties(zv)
+	if err != nil {
+		klog.Errorf("volume: %v", err)
+		return nil
+	}
+	if !changed {
+		return nil
+	}
+	return zfs.UpdateVolumeProperties(zv.Name, zv.Status.Properties)
+}
@@ -0,0 +1,51 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	zfs "github.com/openebs/zfs-localpv/pkg/zfs"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// syncRedundantMetadata records the redundant_metadata in effect once it
+// has been edited on the volume
+func (c *ZVController) syncRedundantMetadata(zv *apis.ZFSVolume) error {
+	if zv.Spec.RedundantMetadata == "" {
+		return nil
+	}
+	rm, err := zfs.GetVolumeProperty(zv, "redundant_metadata")
+	if err != nil {
+		return err
+	}
+	if rm == zv.Status.RedundantMetadata {
+		return nil
+	}
+	c.warnRedundantMetadata(zv)
+	zv.Status.RedundantMetadata = rm
+	return zfs.UpdateVolumeStatus(zv)
+}
+
+// warnRedundantMetadata records a warning event if the volume reduces
+// the metadata redundancy on a non redundant pool
+func (c *ZVController) warnRedundantMetadata(zv *apis.ZFSVolume) {
+	if msg := zfs.RedundantMetadataWarning(zv); msg != "" {
+		klog.Warningf("volume %s: %s", zv.Name, msg)
+		c.recorder.Event(zv, corev1.EventTypeWarning, "ReducedRedundancy", msg)
+	}
+}
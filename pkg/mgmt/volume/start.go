@@ -25,6 +25,7 @@ import (
 
 	clientset "github.com/openebs/zfs-localpv/pkg/generated/clientset/internalclientset"
 	informers "github.com/openebs/zfs-localpv/pkg/generated/informer/externalversions"
+	zfs "github.com/openebs/zfs-localpv/pkg/zfs"
 	kubeinformers "k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
@@ -73,6 +74,7 @@ func Start(controllerMtx *sync.RWMutex, stopCh <-chan struct{}) error {
 		withZVLister(zvInformerFactory).
 		withRecorder(kubeClient).
 		withEventHandler(zvInformerFactory).
+		withDestroyConcurrency(zfs.DestroyConcurrency).
 		withWorkqueueRateLimiting().Build()
 
 	// blocking call, can't use defer to release the lock
@@ -21,11 +21,13 @@ import (
 	"time"
 
 	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"github.com/openebs/zfs-localpv/pkg/collector"
 	zfs "github.com/openebs/zfs-localpv/pkg/zfs"
 	k8serror "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog/v2"
 )
 
@@ -54,6 +56,9 @@ func (c *ZVController) syncHandler(key string) error {
 		return err
 	}
 	zvCopy := zv.DeepCopy()
+	if _, err = zfs.ResolveVolumePool(c.clientset, zvCopy, zfs.PoolAliasUpdate); err != nil {
+		return err
+	}
 	err = c.syncZV(zvCopy)
 	return err
 }
@@ -64,10 +69,20 @@ func (c *ZVController) syncHandler(key string) error {
 func (c *ZVController) enqueueZV(obj interface{}) {
 	var key string
 	var err error
+	zv, ok := obj.(*apis.ZFSVolume)
+	if ok && c.isDeletionCandidate(zv) {
+		collector.Pending.Done(collector.OperationProvision, zv.Name)
+		c.enqueueDestroy(zv)
+		return
+	}
 	if key, err = cache.MetaNamespaceKeyFunc(obj); err != nil {
 		runtime.HandleError(err)
 		return
 	}
+	// the volume is pending since the ZFSVolume has been created
+	if ok && zv.Status.State == zfs.ZFSStatusPending {
+		collector.Pending.Start(collector.OperationProvision, zv.Name, zv.CreationTimestamp.Time)
+	}
 	c.workqueue.Add(key)
 
 }
@@ -76,34 +91,112 @@ func (c *ZVController) enqueueZV(obj interface{}) {
 // ZFSVolume
 func (c *ZVController) syncZV(zv *apis.ZFSVolume) error {
 	var err error
-	// ZFS Volume should be deleted. Check if deletion timestamp is set
+	// ZFS Volume should be deleted. Check if deletion timestamp is set,
+	// the destroy workers take care of it
 	if c.isDeletionCandidate(zv) {
-		userFin := zfs.GetUserFinalizers(zv.Finalizers)
-		if len(userFin) == 0 {
-			// destroy only if other finalizers have been removed
-			err = zfs.DestroyVolume(zv)
-			if err == nil {
-				err = zfs.RemoveVolumeFinalizer(zv)
-			}
-		} else {
-			return fmt.Errorf("volume: can not destroy, waiting for finalizers to be removed %v", userFin)
-		}
+		c.enqueueDestroy(zv)
 	} else {
 		// if volume has already been created and its state is Ready
 		// then this event is for property change only.
 		if zfs.IsVolumeReady(zv) {
 			err = zfs.SetVolumeProp(zv)
+			if err == nil {
+				err = c.syncRedundantMetadata(zv)
+			}
+			if err == nil {
+				err = zfs.ReconcileShare(zv)
+			}
+			if err == nil {
+				err = c.syncPreallocation(zv)
+			}
+			if err == nil {
+				err = c.syncDataCheck(zv)
+			}
+			if err == nil {
+				err = c.syncProperties(zv)
+			}
 		} else {
+			// the pvc has been deleted in the meantime
+			if zfs.ProvisioningCancelled(zv) {
+				return c.cancelProvisioning(zv)
+			}
 			if len(zv.Spec.SnapName) > 0 {
 				err = zfs.CreateClone(zv)
 			} else {
-				err = zfs.CreateVolume(zv)
+				var key string
+				if key, err = zfs.VolumeKey(c.kubeclientset, zv); err == nil {
+					err = zfs.CreateVolume(zv, key)
+				}
 			}
 			if err == nil {
+				if zv.Spec.VolumeType == zfs.VolTypeDataset {
+					// report the dnodesize in effect for the dataset
+					if ds, err := zfs.GetVolumeProperty(zv, "dnodesize"); err == nil {
+						zv.Status.DnodeSize = ds
+					}
+					// and the acl properties
+					if at, err := zfs.GetVolumeProperty(zv, "acltype"); err == nil {
+						zv.Status.AclType = at
+					}
+					if am, err := zfs.GetVolumeProperty(zv, "aclmode"); err == nil {
+						zv.Status.AclMode = am
+					}
+					if xa, err := zfs.GetVolumeProperty(zv, "xattr"); err == nil {
+						zv.Status.Xattr = xa
+					}
+				}
+				if rm, err := zfs.GetVolumeProperty(zv, "redundant_metadata"); err == nil {
+					zv.Status.RedundantMetadata = rm
+				}
+				// and the requested properties along with the effective ones
+				if _, err := zfs.ReconcileProperties(zv); err != nil {
+					klog.Errorf("volume: %v", err)
+				}
+				// and the snapshot the clone depends on, a copy from
+				// another zpool is independent from the start
+				if zfs.IsPoolCopy(zv) {
+					zv.Status.Origin = &apis.CloneOrigin{Volume: zv.Labels[zfs.ZFSSrcVolKey], Independent: true}
+				} else if len(zv.Spec.SnapName) > 0 {
+					if origin, err := zfs.GetCloneOrigin(zv); err == nil {
+						c.setCloneOrigin(zv, origin)
+					} else {
+						klog.Errorf("volume: could not get the origin of the clone %s: %v", zv.Name, err)
+					}
+				}
+				c.warnRedundantMetadata(zv)
+				// it is published once the preallocation is done
+				if zfs.PreallocationPending(zv) {
+					zv.Status.Preallocation = zfs.NewPreallocation(zv)
+				}
+				// the request may have vanished while the volume was
+				// created, the marker tells the dataset to destroy
+				if vanished, verr := c.provisioningVanished(zv); verr != nil {
+					klog.Errorf("volume: could not check the request of %s: %v", zv.Name, verr)
+				} else if vanished {
+					return c.cancelProvisioning(zv)
+				}
+				// the volume is complete, it must not be rolled back anymore
+				if err = zfs.ClearProvisioningMarker(zv); err != nil {
+					return err
+				}
 				err = zfs.UpdateZvolInfo(zv, zfs.ZFSStatusReady)
+				if err == nil {
+					// the ready volume is not enqueued again, the
+					// preallocation has to be started now
+					err = c.syncPreallocation(zv)
+				}
 			} else {
 				err = zfs.UpdateZvolInfo(zv, zfs.ZFSStatusFailed)
 			}
+			if err == nil {
+				collector.Pending.Done(collector.OperationProvision, zv.Name)
+			}
+		}
+		if err == nil && zfs.DebugDumpRequested(zv) {
+			err = c.debugDump(zv)
+		}
+		if err == nil && zfs.IsVolumeReady(zv) && zfs.DefragmentationPending(zv) {
+			err = c.defragment(zv)
 		}
 	}
 	return err
@@ -140,6 +233,11 @@ func (c *ZVController) updateZV(oldObj, newObj interface{}) {
 	oldZV, _ := oldObj.(*apis.ZFSVolume)
 	if zfs.PropertyChanged(oldZV, newZV) ||
 		c.isDeletionCandidate(newZV) ||
+		zfs.DebugDumpRequested(newZV) ||
+		zfs.DefragmentRequested(newZV) ||
+		(zfs.PreallocCancelRequested(newZV) && zfs.PreallocationPending(newZV)) ||
+		zfs.DataCheckRequested(newZV) || zfs.DataCheckCancelRequested(newZV) ||
+		(zfs.ProvisioningCancelled(newZV) && !zfs.IsVolumeReady(newZV)) ||
 		newZV.Status.State == zfs.ZFSStatusPending {
 		klog.Infof("Got update event for ZV %s/%s", newZV.Spec.PoolName, newZV.Name)
 		c.enqueueZV(newZV)
@@ -177,6 +275,7 @@ func (c *ZVController) deleteZV(obj interface{}) {
 func (c *ZVController) Run(threadiness int, stopCh <-chan struct{}) error {
 	defer runtime.HandleCrash()
 	defer c.workqueue.ShutDown()
+	defer c.destroyQueue.ShutDown()
 
 	// Start the informer factories to begin populating the informer caches
 	klog.Info("Starting ZV controller")
@@ -192,6 +291,14 @@ func (c *ZVController) Run(threadiness int, stopCh <-chan struct{}) error {
 	for i := 0; i < threadiness; i++ {
 		go wait.Until(c.runWorker, time.Second, stopCh)
 	}
+	for i := 0; i < c.destroyConcurrency; i++ {
+		go wait.Until(c.runDestroyWorker, time.Second, stopCh)
+	}
+
+	if zfs.CapacityDriftMode != zfs.DriftModeOff {
+		go wait.Until(c.checkCapacityDrift, capacityDriftInterval, stopCh)
+	}
+	go wait.Until(c.checkCloneDivergence, cloneDivergenceInterval, stopCh)
 
 	klog.Info("Started ZV workers")
 	<-stopCh
@@ -211,13 +318,19 @@ func (c *ZVController) runWorker() {
 // processNextWorkItem will read a single work item off the workqueue and
 // attempt to process it, by calling the syncHandler.
 func (c *ZVController) processNextWorkItem() bool {
-	obj, shutdown := c.workqueue.Get()
+	return c.processNext(c.workqueue, c.syncHandler)
+}
+
+// processNext reads a single work item off the queue and processes it
+// with the handler.
+func (c *ZVController) processNext(queue workqueue.RateLimitingInterface, handler func(string) error) bool {
+	obj, shutdown := queue.Get()
 
 	if shutdown {
 		return false
 	}
 
-	// We wrap this block in a func so we can defer c.workqueue.Done.
+	// We wrap this block in a func so we can defer queue.Done.
 	err := func(obj interface{}) error {
 		// We call Done here so the workqueue knows we have finished
 		// processing this item. We also must remember to call Forget if we
@@ -225,7 +338,7 @@ func (c *ZVController) processNextWorkItem() bool {
 		// not call Forget if a transient error occurs, instead the item is
 		// put back on the workqueue and attempted again after a back-off
 		// period.
-		defer c.workqueue.Done(obj)
+		defer queue.Done(obj)
 		var key string
 		var ok bool
 		// We expect strings to come off the workqueue. These are of the
@@ -237,20 +350,20 @@ func (c *ZVController) processNextWorkItem() bool {
 			// As the item in the workqueue is actually invalid, we call
 			// Forget here else we'd go into a loop of attempting to
 			// process a work item that is invalid.
-			c.workqueue.Forget(obj)
+			queue.Forget(obj)
 			runtime.HandleError(fmt.Errorf("expected string in workqueue but got %#v", obj))
 			return nil
 		}
-		// Run the syncHandler, passing it the namespace/name string of the
+		// Run the handler, passing it the namespace/name string of the
 		// ZV resource to be synced.
-		if err := c.syncHandler(key); err != nil {
+		if err := handler(key); err != nil {
 			// Put the item back on the workqueue to handle any transient errors.
-			c.workqueue.AddRateLimited(key)
+			queue.AddRateLimited(key)
 			return fmt.Errorf("error syncing '%s': %s, requeuing", key, err.Error())
 		}
 		// Finally, if no error occurs we Forget this item so it does not
 		// get queued again until another change happens.
-		c.workqueue.Forget(obj)
+		queue.Forget(obj)
 		klog.Infof("Successfully synced '%s'", key)
 		return nil
 	}(obj)
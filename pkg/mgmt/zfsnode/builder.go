@@ -19,11 +19,13 @@ package zfsnode
 import (
 	"time"
 
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
 	clientset "github.com/openebs/zfs-localpv/pkg/generated/clientset/internalclientset"
 	openebsScheme "github.com/openebs/zfs-localpv/pkg/generated/clientset/internalclientset/scheme"
 	informers "github.com/openebs/zfs-localpv/pkg/generated/informer/externalversions"
 	listers "github.com/openebs/zfs-localpv/pkg/generated/lister/zfs/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
@@ -32,6 +34,8 @@ import (
 	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog/v2"
+
+	"github.com/openebs/zfs-localpv/pkg/zfs"
 )
 
 const controllerAgentName = "zfsnode-controller"
@@ -65,6 +69,60 @@ type NodeController struct {
 
 	// ownerRef is used to set the owner reference to zfsnode objects.
 	ownerRef metav1.OwnerReference
+
+	// summaryInterval controls how often the capacity summary of the
+	// pools is refreshed, 0 disables the summary.
+	summaryInterval time.Duration
+
+	// summary is the last capacity summary of the pools and summaryAt
+	// the time it has been listed at.
+	summary   []apis.PoolSummary
+	summaryAt time.Time
+
+	// listPoolSummary lists the capacity summary of the pools, can be
+	// replaced in unit tests.
+	listPoolSummary func() ([]apis.PoolSummary, error)
+
+	// autoImport enables the import of the expected pools which are not
+	// imported.
+	autoImport bool
+
+	// importPool imports an exported pool, can be replaced in unit tests.
+	importPool func(name string) apis.PoolImport
+
+	// listFsTypes checks the tools of the filesystems installed on the
+	// node, can be replaced in unit tests.
+	listFsTypes func() []apis.FsTypeTools
+
+	// zfsVersion returns the zfs version detected at startup, can be
+	// replaced in unit tests.
+	zfsVersion func() *apis.ZFSVersionStatus
+
+	// applyTenantQuotas keeps the quotas of the tenant datasets and
+	// returns their usage, can be replaced in unit tests.
+	applyTenantQuotas func(map[string]resource.Quantity, []apis.Pool) []apis.TenantUsage
+
+	// poolCleanup is what is done to reclaim the space of the full pools
+	// and cleanupAt the time of the summary they were last cleaned up at.
+	poolCleanup string
+	cleanupAt   time.Time
+
+	// listSnapshotRefs and cleanupSnapshots find and destroy the
+	// unreferenced snapshots of a full pool, can be replaced in unit tests.
+	listSnapshotRefs func() (*zfs.SnapshotReferences, error)
+	cleanupSnapshots func(pool string, need int64, refs *zfs.SnapshotReferences) ([]string, error)
+
+	// listVolumeUsage lists the space used by the volumes of the node
+	// along with the summary and updateSpaceUsage sets the breakdown in
+	// the status of a volume, can be replaced in unit tests.
+	listVolumeUsage  func() (map[string]zfs.VolumeUsage, error)
+	updateSpaceUsage func(name string, usage *apis.VolumeSpaceUsage) error
+
+	// listPoolLatency lists the latency histograms of the pools along
+	// with the summary, can be replaced in unit tests. latency is the last
+	// histogram of each pool, the quantiles are those of the IOs since.
+	listPoolLatency func() (map[string]zfs.LatencyHistogram, error)
+	latency         map[string]zfs.LatencyHistogram
 }
 
 // NodeControllerBuilder is the builder object for controller.
@@ -75,7 +133,18 @@ type NodeControllerBuilder struct {
 // NewNodeControllerBuilder returns an empty instance of controller builder.
 func NewNodeControllerBuilder() *NodeControllerBuilder {
 	return &NodeControllerBuilder{
-		NodeController: &NodeController{},
+		NodeController: &NodeController{
+			listPoolSummary:   zfs.ListPoolSummary,
+			importPool:        zfs.ImportPool,
+			listFsTypes:       zfs.ListFsTypeTools,
+			zfsVersion:        zfs.NodeZFSVersion,
+			applyTenantQuotas: zfs.ApplyTenantQuotas,
+			listSnapshotRefs:  zfs.ListSnapshotReferences,
+			cleanupSnapshots:  zfs.CleanupPoolSnapshots,
+			listVolumeUsage:   zfs.ListVolumeUsage,
+			updateSpaceUsage:  zfs.UpdateVolumeSpaceUsage,
+			listPoolLatency:   zfs.ListPoolLatency,
+		},
 	}
 }
 
@@ -142,6 +211,21 @@ func (cb *NodeControllerBuilder) withPollInterval(interval time.Duration) *NodeC
 	return cb
 }
 
+func (cb *NodeControllerBuilder) withSummaryInterval(interval time.Duration) *NodeControllerBuilder {
+	cb.NodeController.summaryInterval = interval
+	return cb
+}
+
+func (cb *NodeControllerBuilder) withAutoImport(enabled bool) *NodeControllerBuilder {
+	cb.NodeController.autoImport = enabled
+	return cb
+}
+
+func (cb *NodeControllerBuilder) withPoolCleanup(policy string) *NodeControllerBuilder {
+	cb.NodeController.poolCleanup = policy
+	return cb
+}
+
 func (cb *NodeControllerBuilder) withOwnerReference(ownerRef metav1.OwnerReference) *NodeControllerBuilder {
 	cb.NodeController.ownerRef = ownerRef
 	return cb
@@ -0,0 +1,112 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfsnode
+
+import (
+	"fmt"
+	"strings"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"github.com/openebs/zfs-localpv/pkg/collector"
+	"github.com/openebs/zfs-localpv/pkg/zfs"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/klog/v2"
+)
+
+// reportPoolFill exports the fill of the pools as metrics, the pools are
+// left out if the detection is off
+func reportPoolFill(summary []apis.PoolSummary) {
+	pools := map[string]collector.FillState{}
+	for i, p := range summary {
+		if meta.FindStatusCondition(p.Conditions, zfs.PoolConditionFull) != nil {
+			pools[p.Name] = collector.FillState{Percent: zfs.PoolFillPercent(&summary[i]), Full: zfs.PoolFull(&summary[i])}
+		}
+	}
+	collector.Fill.Reset(pools)
+}
+
+// alertPoolFull raises an event on the node for each pool which has become
+// full or is not anymore since the last summary written in its status
+func (c *NodeController) alertPoolFull(node *apis.ZFSNode, last, summary []apis.PoolSummary) {
+	for i := range summary {
+		cond := meta.FindStatusCondition(summary[i].Conditions, zfs.PoolConditionFull)
+		if cond == nil {
+			continue
+		}
+		wasFull := false
+		for j := range last {
+			if last[j].Name == summary[i].Name {
+				wasFull = zfs.PoolFull(&last[j])
+			}
+		}
+		full := cond.Status == "True"
+		switch {
+		case full && !wasFull:
+			c.recorder.Event(node, corev1.EventTypeWarning, "PoolFull",
+				fmt.Sprintf("pool %s: %s", summary[i].Name, cond.Message))
+		case !full && wasFull:
+			c.recorder.Event(node, corev1.EventTypeNormal, "PoolNotFull",
+				fmt.Sprintf("pool %s: %s", summary[i].Name, cond.Message))
+		}
+	}
+}
+
+// cleanupFullPools destroys the oldest unreferenced snapshots of the full
+// pools to bring them back below the threshold. The space is freed by zfs
+// in the background, so it runs only once per refreshed summary and the
+// snapshots are not destroyed again until the summary shows what has been
+// reclaimed.
+func (c *NodeController) cleanupFullPools(node *apis.ZFSNode, summary []apis.PoolSummary) {
+	if c.poolCleanup != zfs.PoolCleanupSnapshots || !c.summaryAt.After(c.cleanupAt) {
+		return
+	}
+	c.cleanupAt = c.summaryAt
+
+	var refs *zfs.SnapshotReferences
+	for i := range summary {
+		pool := &summary[i]
+		if !zfs.PoolFull(pool) {
+			continue
+		}
+		need := zfs.PoolReclaimBytes(pool, zfs.PoolFullThreshold)
+		if need == 0 {
+			continue
+		}
+		if refs == nil {
+			var err error
+			if refs, err = c.listSnapshotRefs(); err != nil {
+				klog.Errorf("zfs node controller: could not clean up the full pools: %v", err)
+				return
+			}
+		}
+
+		destroyed, err := c.cleanupSnapshots(pool.Name, need, refs)
+		if len(destroyed) > 0 {
+			c.recorder.Event(node, corev1.EventTypeNormal, "SnapshotsCleanedUp",
+				fmt.Sprintf("destroyed %d unreferenced snapshots of full pool %s: %s",
+					len(destroyed), pool.Name, strings.Join(destroyed, ", ")))
+		}
+		if err != nil {
+			klog.Errorf("zfs node controller: %v", err)
+			c.recorder.Event(node, corev1.EventTypeWarning, "SnapshotCleanupFailed",
+				fmt.Sprintf("could not clean up full pool %s: %v", pool.Name, err))
+		} else if len(destroyed) == 0 {
+			klog.Warningf("zfs node controller: full pool %s has no unreferenced snapshot to clean up", pool.Name)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+/*
+ Copyright © 2021 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package zfsnode
+
+import (
+	"time"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"github.com/openebs/zfs-localpv/pkg/zfs"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// importRetryInterval is the time after which the import of a pool which
+// has been refused or has failed is attempted again
+const importRetryInterval = 5 * time.Minute
+
+// importPools imports the expected pools of the node which are not
+// imported. A pool is attempted at most once per importRetryInterval. The
+// import attempts are recorded in the status of the node, it returns true
+// if the status has changed and whether a pool has been imported.
+func (c *NodeController) importPools(node *apis.ZFSNode, pools []apis.Pool, now time.Time) (bool, bool) {
+	if !c.autoImport {
+		return false, false
+	}
+	changed, imported := false, false
+	for _, name := range zfs.MissingPools(node.ExpectedPools, pools) {
+		idx := findImport(node.Status.Imports, name)
+		if idx >= 0 && now.Sub(node.Status.Imports[idx].LastAttempt.Time) < importRetryInterval {
+			continue
+		}
+
+		res := c.importPool(name)
+		switch res.Result {
+		case zfs.PoolImported:
+			imported = true
+			c.recorder.Event(node, corev1.EventTypeNormal, "PoolImported", res.Message)
+		case zfs.PoolImportRefused:
+			c.recorder.Event(node, corev1.EventTypeWarning, "PoolImportRefused", res.Message)
+		default:
+			c.recorder.Event(node, corev1.EventTypeWarning, "PoolImportFailed", res.Message)
+		}
+
+		if idx >= 0 {
+			node.Status.Imports[idx] = res
+		} else {
+			node.Status.Imports = append(node.Status.Imports, res)
+		}
+		changed = true
+	}
+	return changed, imported
+}
+
+// findImport returns the index of the import of the pool, -1 if the pool
+// has not been imported yet
+func findImport(imports []apis.PoolImport, name string) int {
+	for i := range imports {
+		if imports[i].Name == name {
+			return i
+		}
+	}
+	return -1
+}
@@ -127,6 +127,9 @@ func Start(controllerMtx *sync.RWMutex, stopCh <-chan struct{}) error {
 		withRecorder(kubeClient).
 		withEventHandler(nodeInformerFactory).
 		withPollInterval(60 * time.Second).
+		withSummaryInterval(zfs.PoolSummaryInterval).
+		withAutoImport(zfs.PoolAutoImport).
+		withPoolCleanup(zfs.PoolFullCleanup).
 		withOwnerReference(ownerRef).
 		withWorkqueueRateLimiting().Build()
 
@@ -137,6 +140,9 @@ func Start(controllerMtx *sync.RWMutex, stopCh <-chan struct{}) error {
 		return errors.Wrapf(err, "error building controller instance")
 	}
 
+	// the pool aliases are read from the ZFSNode of the node
+	zfs.SetPoolAliasLister(controller.NodeLister, controller.NodeSynced)
+
 	nodeInformerFactory.Start(stopCh)
 
 	// Threadiness defines the number of workers to be launched in Run function
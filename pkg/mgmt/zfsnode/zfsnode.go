@@ -17,15 +17,18 @@
 package zfsnode
 
 import (
+	"errors"
 	"fmt"
 	"reflect"
+	"strconv"
 	"time"
 
 	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
 	"github.com/openebs/zfs-localpv/pkg/builder/nodebuilder"
-	"github.com/openebs/zfs-localpv/pkg/equality"
+	"github.com/openebs/zfs-localpv/pkg/collector"
 	"github.com/openebs/zfs-localpv/pkg/zfs"
 	k8serror "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
@@ -34,7 +37,214 @@ import (
 )
 
 func (c *NodeController) listZFSPool() ([]apis.Pool, error) {
-	return zfs.ListZFSPool()
+	pools, err := zfs.ListZFSPool()
+	if err != nil {
+		return nil, err
+	}
+
+	// the reserved space reduces the available space of the pools,
+	// list them again if the reservation has been changed
+	changed, err := zfs.ReconcileReservedSpace(pools)
+	if err != nil {
+		klog.Errorf("zfs node controller: %v", err)
+	}
+	if changed {
+		return zfs.ListZFSPool()
+	}
+	return pools, nil
+}
+
+// poolSummary returns the capacity summary of the pools, it is listed
+// again only once the summary interval has passed. The last summary is
+// kept if the pools can not be listed.
+func (c *NodeController) poolSummary(now time.Time) []apis.PoolSummary {
+	if c.summaryInterval <= 0 {
+		return nil
+	}
+	if !c.summaryAt.IsZero() && now.Sub(c.summaryAt) < c.summaryInterval {
+		return c.summary
+	}
+
+	summary, err := c.listPoolSummary()
+	if err != nil {
+		klog.Errorf("zfs node controller: %v", err)
+		return c.summary
+	}
+	keepTransitionTimes(c.summary, summary)
+	c.summary, c.summaryAt = summary, now
+	reportSnapshotSpace(summary)
+	reportDatasets(summary)
+	reportDeadman(summary)
+	reportScans(summary)
+	reportPoolFill(summary)
+	c.reportVolumeUsage()
+	c.reportLatency()
+	return summary
+}
+
+// keepTransitionTimes keeps the transition time of the conditions of the
+// pools which have not changed since the last summary, as the summary is
+// built anew each time
+func keepTransitionTimes(last, summary []apis.PoolSummary) {
+	for i := range summary {
+		for j := range last {
+			if last[j].Name != summary[i].Name {
+				continue
+			}
+			for k := range summary[i].Conditions {
+				cond := &summary[i].Conditions[k]
+				if old := meta.FindStatusCondition(last[j].Conditions, cond.Type); old != nil && old.Status == cond.Status {
+					cond.LastTransitionTime = old.LastTransitionTime
+				}
+			}
+		}
+	}
+}
+
+// reportDeadman exports the hung IO detection of the pools as metrics, the
+// pools whose events could not be read are left out
+func reportDeadman(summary []apis.PoolSummary) {
+	pools := map[string]collector.DeadmanState{}
+	for i, p := range summary {
+		if meta.FindStatusCondition(p.Conditions, zfs.PoolConditionIOHung) != nil {
+			pools[p.Name] = collector.DeadmanState{Events: p.DeadmanEvents, Hung: zfs.PoolIOHung(&summary[i])}
+		}
+	}
+	collector.Deadman.Reset(pools)
+}
+
+// reportScans exports the progress of the scrubs and the resilvers of the
+// pools as metrics, the pools which have never been scanned are left out
+func reportScans(summary []apis.PoolSummary) {
+	pools := map[string]collector.ScanProgress{}
+	for _, p := range summary {
+		if p.Scan == nil {
+			continue
+		}
+		scan := collector.ScanProgress{
+			Function:  p.Scan.Function,
+			Active:    p.Scan.State == apis.PoolScanInProgress,
+			Issued:    p.Scan.Issued,
+			Total:     p.Scan.Total,
+			Processed: p.Scan.Processed,
+			Remaining: -1,
+		}
+		if p.Scan.State == apis.PoolScanFinished {
+			scan.Percent = 100
+		} else if percent, err := strconv.ParseFloat(p.Scan.Percent, 64); err == nil {
+			scan.Percent = percent
+		}
+		if p.Scan.Remaining != nil {
+			scan.Remaining = p.Scan.Remaining.Seconds()
+		}
+		pools[p.Name] = scan
+	}
+	collector.Scans.Reset(pools)
+}
+
+// reportSnapshotSpace exports the space used by the snapshots of the pools
+// as metrics, the pools whose snapshots could not be listed are left out
+func reportSnapshotSpace(summary []apis.PoolSummary) {
+	pools := map[string]collector.SnapshotUsage{}
+	for _, p := range summary {
+		if p.SnapshotUsed != nil {
+			pools[p.Name] = collector.SnapshotUsage{Used: p.SnapshotUsed.Value(), Count: p.Snapshots}
+		}
+	}
+	collector.SnapshotSpace.Reset(pools)
+}
+
+// reportDatasets exports the number of datasets of the pools as metrics,
+// the pools whose datasets could not be listed are left out
+func reportDatasets(summary []apis.PoolSummary) {
+	pools := map[string]int64{}
+	for _, p := range summary {
+		if p.Datasets > 0 {
+			pools[p.Name] = p.Datasets
+		}
+	}
+	collector.Datasets.Reset(pools)
+}
+
+// reportVolumeUsage exports the fill of the volumes of the node and the
+// breakdown of their space as metrics, the volumes without a size have no
+// fill. The last ones are kept if the volumes can not be listed. The
+// breakdown is also set in the status of the ready volumes, only the ones
+// whose breakdown has changed are updated.
+func (c *NodeController) reportVolumeUsage() {
+	if c.listVolumeUsage == nil {
+		return
+	}
+	usage, err := c.listVolumeUsage()
+	if err != nil {
+		klog.Warningf("zfs node controller: %v", err)
+		return
+	}
+	volumes := map[string]collector.VolumeFill{}
+	breakdown := map[string]collector.VolumeBreakdown{}
+	for name, u := range usage {
+		if u.Size > 0 {
+			volumes[name] = collector.VolumeFill{Pool: u.Pool, Percent: u.UsedPercent()}
+		}
+		breakdown[name] = collector.VolumeBreakdown{
+			Pool:           u.Pool,
+			Snapshots:      u.UsedBy.UsedBySnapshots,
+			Dataset:        u.UsedBy.UsedByDataset,
+			Children:       u.UsedBy.UsedByChildren,
+			Refreservation: u.UsedBy.UsedByRefreservation,
+		}
+		if u.Ready && (u.Reported == nil || *u.Reported != u.UsedBy) && c.updateSpaceUsage != nil {
+			usedBy := u.UsedBy
+			if err := c.updateSpaceUsage(name, &usedBy); err != nil {
+				klog.Warningf("zfs node controller: could not update the space usage of volume %s: %v", name, err)
+			}
+		}
+	}
+	collector.Volumes.Reset(volumes)
+	collector.Breakdown.Reset(breakdown)
+}
+
+// reportLatency exports the latency quantiles of the IOs of the pools
+// since the last summary as metrics, the pools and the operations without
+// IOs in the meantime are left out. The latency is not checked anymore if
+// zfs does not know the histograms.
+func (c *NodeController) reportLatency() {
+	if c.listPoolLatency == nil {
+		return
+	}
+	hists, err := c.listPoolLatency()
+	if errors.Is(err, zfs.ErrLatencyUnsupported) {
+		klog.Warningf("zfs node controller: %v, the latency metrics are not reported", err)
+		c.listPoolLatency = nil
+		collector.Latency.Reset(nil)
+		return
+	}
+	if err != nil {
+		klog.Warningf("zfs node controller: %v", err)
+		return
+	}
+	pools := map[string][]collector.LatencyQuantile{}
+	for pool, h := range hists {
+		delta := h.Since(c.latency[pool])
+		for _, op := range []struct {
+			operation, wait string
+			counts          []uint64
+		}{
+			{"read", "total", delta.TotalRead},
+			{"write", "total", delta.TotalWrite},
+			{"read", "disk", delta.DiskRead},
+			{"write", "disk", delta.DiskWrite},
+		} {
+			for _, q := range zfs.LatencyQuantiles {
+				if seconds, ok := zfs.LatencyQuantile(delta.Bounds, op.counts, q); ok {
+					pools[pool] = append(pools[pool], collector.LatencyQuantile{
+						Operation: op.operation, Wait: op.wait, Quantile: q, Seconds: seconds})
+				}
+			}
+		}
+	}
+	c.latency = hists
+	collector.Latency.Reset(pools)
 }
 
 // syncHandler compares the actual state with the desired, and attempts to
@@ -67,11 +277,17 @@ func (c *NodeController) syncNode(namespace string, name string) error {
 	if err != nil {
 		return err
 	}
+	summary := c.poolSummary(time.Now())
+	fsTypes := c.listFsTypes()
+	version := c.zfsVersion()
 
 	if node == nil { // if it doesn't exists, create zfs node object
 		if node, err = nodebuilder.NewBuilder().
 			WithNamespace(namespace).WithName(name).
 			WithPools(pools).
+			WithPoolSummary(summary).
+			WithFsTypes(fsTypes).
+			WithZFSVersion(version).
 			WithOwnerReferences(c.ownerRef).
 			Build(); err != nil {
 			return err
@@ -82,11 +298,23 @@ func (c *NodeController) syncNode(namespace string, name string) error {
 			return fmt.Errorf("create zfs node %s/%s: %v", namespace, name, err)
 		}
 		klog.Infof("zfs node controller: created node object %s/%s", namespace, name)
+		c.alertPoolFull(node, nil, summary)
+		c.cleanupFullPools(node, summary)
 		return nil
 	}
 
 	// zfs node already exists check if we need to update it.
 	var updateRequired bool
+
+	// import the expected pools which have been exported
+	if changed, imported := c.importPools(node, pools, time.Now()); changed {
+		updateRequired = true
+		if imported {
+			if pools, err = c.listZFSPool(); err != nil {
+				return err
+			}
+		}
+	}
 	// validate if owner reference updated.
 	if ownerRefs, req := c.isOwnerRefsUpdateRequired(node.OwnerReferences); req {
 		klog.Infof("zfs node controller: node owner references updated current=%+v, required=%+v",
@@ -95,14 +323,63 @@ func (c *NodeController) syncNode(namespace string, name string) error {
 		updateRequired = true
 	}
 
-	// validate if node pools are upto date.
-	if !equality.Semantic.DeepEqual(node.Pools, pools) {
+	// validate if node pools are upto date. The free space changes all the
+	// time, so it is written at most once per interval, while added or
+	// removed pools are written right away.
+	key := "zfsnode/" + name
+	if zfs.StatusUpdates.ShouldWrite(key, node.Pools, pools, !samePools(node.Pools, pools)) {
 		klog.Infof("zfs node controller: node pools updated current=%+v, required=%+v",
 			node.Pools, pools)
 		node.Pools = pools
 		updateRequired = true
 	}
 
+	// the pools which have become full are reported before the summary
+	// is overwritten, and cleaned up as per the policy
+	c.alertPoolFull(node, node.Status.Pools, summary)
+	c.cleanupFullPools(node, summary)
+
+	// the capacity summary is debounced the same way, a changed pool
+	// health is written right away.
+	summaryKey := key + "/summary"
+	writeSummary := zfs.StatusUpdates.ShouldWrite(summaryKey, node.Status.Pools, summary,
+		!sameHealth(node.Status.Pools, summary))
+	if writeSummary {
+		node.Status.Pools = summary
+		updateRequired = true
+	}
+
+	// the tools of the filesystems seldom change, e.g. when a package is
+	// installed, and are written right away so that the controller stops
+	// or starts placing the zvols of the filesystem on the node
+	if !reflect.DeepEqual(node.Status.FsTypes, fsTypes) {
+		klog.Infof("zfs node controller: node filesystem tools updated current=%+v, required=%+v",
+			node.Status.FsTypes, fsTypes)
+		node.Status.FsTypes = fsTypes
+		updateRequired = true
+	}
+
+	// the zfs version is only detected at startup, a node agent which
+	// has not detected it leaves the version of the ZFSNode alone
+	if version != nil && !reflect.DeepEqual(node.Status.ZFSVersion, version) {
+		klog.Infof("zfs node controller: node zfs version updated current=%+v, required=%+v",
+			node.Status.ZFSVersion, version)
+		node.Status.ZFSVersion = version
+		updateRequired = true
+	}
+
+	// the quotas of the tenants are applied as set by the operators, the
+	// used space changes all the time and is debounced as the summary, a
+	// changed quota or failure is written right away
+	tenants := c.applyTenantQuotas(node.TenantQuotas, pools)
+	tenantsKey := key + "/tenants"
+	writeTenants := zfs.StatusUpdates.ShouldWrite(tenantsKey, node.Status.Tenants, tenants,
+		!sameTenants(node.Status.Tenants, tenants))
+	if writeTenants {
+		node.Status.Tenants = tenants
+		updateRequired = true
+	}
+
 	if !updateRequired {
 		return nil
 	}
@@ -111,11 +388,84 @@ func (c *NodeController) syncNode(namespace string, name string) error {
 	if _, err = nodebuilder.NewKubeclient().WithNamespace(namespace).Update(node); err != nil {
 		return fmt.Errorf("update zfs node %s/%s: %v", namespace, name, err)
 	}
+	zfs.StatusUpdates.Written(key)
+	if writeSummary {
+		zfs.StatusUpdates.Written(summaryKey)
+	}
+	if writeTenants {
+		zfs.StatusUpdates.Written(tenantsKey)
+	}
 	klog.Infof("zfs node controller: updated node object %s/%s", namespace, name)
 
 	return nil
 }
 
+// sameTenants tells whether both lists have the same tenants with the same
+// quotas and failures, ignoring their usage
+func sameTenants(a, b []apis.TenantUsage) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Name != b[i].Name || a[i].Quota.Cmp(b[i].Quota) != 0 || a[i].Message != b[i].Message {
+			return false
+		}
+	}
+	return true
+}
+
+// samePools tells whether both lists have the same pools, ignoring their
+// capacity
+func samePools(a, b []apis.Pool) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Name != b[i].Name || a[i].UUID != b[i].UUID {
+			return false
+		}
+	}
+	return true
+}
+
+// sameHealth tells whether both summaries have the same pools with the
+// same health, hung IO detection, fill above the threshold, scan activity
+// and devices, ignoring their capacity and the progress of their scans
+func sameHealth(a, b []apis.PoolSummary) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Name != b[i].Name || a[i].Health != b[i].Health ||
+			zfs.PoolIOHung(&a[i]) != zfs.PoolIOHung(&b[i]) ||
+			zfs.PoolFull(&a[i]) != zfs.PoolFull(&b[i]) ||
+			zfs.PoolScanActive(&a[i]) != zfs.PoolScanActive(&b[i]) ||
+			!sameDevices(a[i].Vdevs, b[i].Vdevs) {
+			return false
+		}
+	}
+	return true
+}
+
+// sameDevices tells whether both vdevs have the same devices in the same
+// state, so that a faulted or a replaced device is reported right away
+func sameDevices(a, b []apis.PoolVdev) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].State != b[i].State || len(a[i].Devices) != len(b[i].Devices) {
+			return false
+		}
+		for j, dev := range a[i].Devices {
+			if dev.Path != b[i].Devices[j].Path || dev.State != b[i].Devices[j].State {
+				return false
+			}
+		}
+	}
+	return true
+}
+
 // addNode is the add event handler for ZFSNode
 func (c *NodeController) addNode(obj interface{}) {
 	node, ok := obj.(*apis.ZFSNode)
@@ -0,0 +1,448 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfsnode
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"github.com/openebs/zfs-localpv/pkg/collector"
+	"github.com/openebs/zfs-localpv/pkg/zfs"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestPoolSummary(t *testing.T) {
+	calls := 0
+	health := "ONLINE"
+	var listErr error
+	c := &NodeController{
+		summaryInterval: time.Minute,
+		listPoolSummary: func() ([]apis.PoolSummary, error) {
+			calls++
+			return []apis.PoolSummary{{Name: "zfspv", Health: health}}, listErr
+		},
+	}
+
+	now := time.Now()
+	if s := c.poolSummary(now); len(s) != 1 || calls != 1 {
+		t.Fatalf("poolSummary() = %+v after %d calls", s, calls)
+	}
+
+	// listed only once per interval
+	health = "DEGRADED"
+	if s := c.poolSummary(now.Add(30 * time.Second)); calls != 1 || s[0].Health != "ONLINE" {
+		t.Errorf("poolSummary() refreshed within the interval")
+	}
+	if s := c.poolSummary(now.Add(time.Minute)); calls != 2 || s[0].Health != "DEGRADED" {
+		t.Errorf("poolSummary() not refreshed after the interval")
+	}
+
+	// the last summary is kept on failure
+	listErr = errors.New("zpool timed out")
+	if s := c.poolSummary(now.Add(3 * time.Minute)); calls != 3 || len(s) != 1 {
+		t.Errorf("poolSummary() = %+v, want the last summary", s)
+	}
+
+	c.summaryInterval = 0
+	if s := c.poolSummary(now.Add(time.Hour)); s != nil || calls != 3 {
+		t.Errorf("poolSummary() listed the pools while disabled")
+	}
+}
+
+func TestSameHealth(t *testing.T) {
+	a := []apis.PoolSummary{{Name: "zfspv", Health: "ONLINE"}}
+	if !sameHealth(a, []apis.PoolSummary{{Name: "zfspv", Health: "ONLINE", Fragmentation: "3%"}}) {
+		t.Errorf("sameHealth() capacity change treated as critical")
+	}
+	if sameHealth(a, []apis.PoolSummary{{Name: "zfspv", Health: "DEGRADED"}}) {
+		t.Errorf("sameHealth() health change not detected")
+	}
+	if sameHealth(a, nil) {
+		t.Errorf("sameHealth() removed pool not detected")
+	}
+	hung := []apis.PoolSummary{{Name: "zfspv", Health: "ONLINE", Conditions: []metav1.Condition{
+		{Type: zfs.PoolConditionIOHung, Status: metav1.ConditionTrue}}}}
+	if sameHealth(a, hung) {
+		t.Errorf("sameHealth() hung IO not detected")
+	}
+	scrub := []apis.PoolSummary{{Name: "zfspv", Health: "ONLINE", Scan: &apis.PoolScan{
+		Function: "scrub", State: apis.PoolScanInProgress, Percent: "10.00"}}}
+	if sameHealth(a, scrub) {
+		t.Errorf("sameHealth() scrub start not detected")
+	}
+	progress := []apis.PoolSummary{{Name: "zfspv", Health: "ONLINE", Scan: &apis.PoolScan{
+		Function: "scrub", State: apis.PoolScanInProgress, Percent: "20.00"}}}
+	if !sameHealth(scrub, progress) {
+		t.Errorf("sameHealth() scan progress treated as critical")
+	}
+	full := []apis.PoolSummary{{Name: "zfspv", Health: "ONLINE", Conditions: []metav1.Condition{
+		{Type: zfs.PoolConditionFull, Status: metav1.ConditionTrue}}}}
+	if sameHealth(a, full) {
+		t.Errorf("sameHealth() full pool not detected")
+	}
+	mirror := func(state string) []apis.PoolSummary {
+		return []apis.PoolSummary{{Name: "zfspv", Health: "ONLINE", Vdevs: []apis.PoolVdev{{
+			Name: "mirror-0", State: "ONLINE", Devices: []apis.PoolDevice{
+				{Path: "/dev/sdb1", State: "ONLINE"}, {Path: "/dev/sdc1", State: state, Serial: "ZA1"}}}}}}
+	}
+	if sameHealth(mirror("ONLINE"), mirror("FAULTED")) {
+		t.Errorf("sameHealth() faulted device not detected")
+	}
+	serial := mirror("ONLINE")
+	serial[0].Vdevs[0].Devices[1].Serial = "ZA2"
+	if !sameHealth(mirror("ONLINE"), serial) {
+		t.Errorf("sameHealth() serial change treated as critical")
+	}
+}
+
+func TestSameTenants(t *testing.T) {
+	used := resource.MustParse("1Gi")
+	a := []apis.TenantUsage{{Name: "zfspv/tenant-a", Quota: resource.MustParse("10Gi")}}
+	if !sameTenants(a, []apis.TenantUsage{{Name: "zfspv/tenant-a", Quota: resource.MustParse("10240Mi"), Used: &used}}) {
+		t.Errorf("sameTenants() usage change treated as critical")
+	}
+	if sameTenants(a, []apis.TenantUsage{{Name: "zfspv/tenant-a", Quota: resource.MustParse("20Gi")}}) {
+		t.Errorf("sameTenants() quota change not detected")
+	}
+	if sameTenants(a, []apis.TenantUsage{{Name: "zfspv/tenant-a", Quota: resource.MustParse("10Gi"), Message: "failed"}}) {
+		t.Errorf("sameTenants() failure not detected")
+	}
+	if sameTenants(a, nil) {
+		t.Errorf("sameTenants() removed tenant not detected")
+	}
+}
+
+func TestKeepTransitionTimes(t *testing.T) {
+	then := metav1.NewTime(time.Now().Add(-time.Hour))
+	last := []apis.PoolSummary{
+		{Name: "zfspv", Conditions: []metav1.Condition{
+			{Type: zfs.PoolConditionIOHung, Status: metav1.ConditionFalse, LastTransitionTime: then}}},
+		{Name: "backup", Conditions: []metav1.Condition{
+			{Type: zfs.PoolConditionIOHung, Status: metav1.ConditionFalse, LastTransitionTime: then}}},
+	}
+	now := metav1.Now()
+	summary := []apis.PoolSummary{
+		{Name: "backup", Conditions: []metav1.Condition{
+			{Type: zfs.PoolConditionIOHung, Status: metav1.ConditionTrue, LastTransitionTime: now}}},
+		{Name: "zfspv", Conditions: []metav1.Condition{
+			{Type: zfs.PoolConditionIOHung, Status: metav1.ConditionFalse, LastTransitionTime: now}}},
+	}
+	keepTransitionTimes(last, summary)
+	if got := summary[0].Conditions[0].LastTransitionTime; !got.Equal(&now) {
+		t.Errorf("keepTransitionTimes() kept the time of a changed condition: %v", got)
+	}
+	if got := summary[1].Conditions[0].LastTransitionTime; !got.Equal(&then) {
+		t.Errorf("keepTransitionTimes() did not keep the time of an unchanged condition: %v", got)
+	}
+}
+
+func TestReportDeadman(t *testing.T) {
+	reportDeadman([]apis.PoolSummary{
+		{Name: "zfspv", DeadmanEvents: 2, Conditions: []metav1.Condition{
+			{Type: zfs.PoolConditionIOHung, Status: metav1.ConditionTrue}}},
+		// the events of this pool could not be read
+		{Name: "backup"},
+	})
+	if n := testutil.CollectAndCount(collector.Deadman, "zfs_pool_io_hung"); n != 1 {
+		t.Errorf("reportDeadman() exported %d pools, want 1", n)
+	}
+
+	reportDeadman(nil)
+	if n := testutil.CollectAndCount(collector.Deadman); n != 0 {
+		t.Errorf("reportDeadman() kept %d metrics of the pools gone", n)
+	}
+}
+
+func TestReportScans(t *testing.T) {
+	reportScans([]apis.PoolSummary{
+		{Name: "zfspv", Scan: &apis.PoolScan{Function: "resilver", State: apis.PoolScanInProgress,
+			Percent: "25.00", Issued: 1 << 30, Total: 4 << 30, Remaining: &metav1.Duration{Duration: time.Minute}}},
+		{Name: "backup", Scan: &apis.PoolScan{Function: "scrub", State: apis.PoolScanFinished}},
+		// never scanned
+		{Name: "spare"},
+	})
+	if n := testutil.CollectAndCount(collector.Scans, "zfs_pool_scan_active"); n != 2 {
+		t.Errorf("reportScans() exported %d pools, want 2", n)
+	}
+	if n := testutil.CollectAndCount(collector.Scans, "zfs_pool_scan_remaining_seconds"); n != 1 {
+		t.Errorf("reportScans() exported the time to go of %d pools, want 1", n)
+	}
+
+	reportScans(nil)
+	if n := testutil.CollectAndCount(collector.Scans); n != 0 {
+		t.Errorf("reportScans() kept %d metrics of the pools gone", n)
+	}
+}
+
+func fullPool(name string, full bool) apis.PoolSummary {
+	status := metav1.ConditionFalse
+	if full {
+		status = metav1.ConditionTrue
+	}
+	return apis.PoolSummary{Name: name, Health: "ONLINE",
+		Size: resource.MustParse("100Gi"), Allocated: resource.MustParse("95Gi"),
+		Conditions: []metav1.Condition{{Type: zfs.PoolConditionFull, Status: status, Message: "95% full"}}}
+}
+
+func TestReportPoolFill(t *testing.T) {
+	reportPoolFill([]apis.PoolSummary{fullPool("zfspv", true), {Name: "backup"}})
+	if n := testutil.CollectAndCount(collector.Fill, "zfs_pool_full"); n != 1 {
+		t.Errorf("reportPoolFill() exported %d pools, want 1", n)
+	}
+
+	reportPoolFill(nil)
+	if n := testutil.CollectAndCount(collector.Fill); n != 0 {
+		t.Errorf("reportPoolFill() kept %d metrics of the pools gone", n)
+	}
+}
+
+func TestReportVolumeUsage(t *testing.T) {
+	var listErr error
+	updated := map[string]apis.VolumeSpaceUsage{}
+	c := &NodeController{
+		listVolumeUsage: func() (map[string]zfs.VolumeUsage, error) {
+			return map[string]zfs.VolumeUsage{
+				"pvc-1": {Pool: "zfspv", Used: 3, Size: 4, UsedBy: apis.VolumeSpaceUsage{UsedByDataset: 3},
+					Ready: true, Reported: &apis.VolumeSpaceUsage{UsedByDataset: 3}},
+				"pvc-2": {Pool: "zfspv", Used: 5, UsedBy: apis.VolumeSpaceUsage{UsedBySnapshots: 2, UsedByDataset: 3},
+					Ready: true, Reported: &apis.VolumeSpaceUsage{UsedByDataset: 3}},
+				"pvc-3": {Pool: "zfspv", Used: 1, UsedBy: apis.VolumeSpaceUsage{UsedByDataset: 1}, Ready: true},
+				"pvc-4": {Pool: "zfspv", Used: 1, UsedBy: apis.VolumeSpaceUsage{UsedByDataset: 1}},
+			}, listErr
+		},
+		updateSpaceUsage: func(name string, usage *apis.VolumeSpaceUsage) error {
+			updated[name] = *usage
+			return nil
+		},
+	}
+	c.reportVolumeUsage()
+	// only the ready volumes whose breakdown has changed are updated
+	want := map[string]apis.VolumeSpaceUsage{
+		"pvc-2": {UsedBySnapshots: 2, UsedByDataset: 3},
+		"pvc-3": {UsedByDataset: 1},
+	}
+	if !reflect.DeepEqual(updated, want) {
+		t.Errorf("reportVolumeUsage() updated %+v, want %+v", updated, want)
+	}
+	// the volume without a size has no fill
+	if n := testutil.CollectAndCount(collector.Volumes, "zfs_volume_used_percent"); n != 1 {
+		t.Errorf("reportVolumeUsage() exported %d volumes, want 1", n)
+	}
+	if n := testutil.CollectAndCount(collector.Breakdown, "zfs_volume_used_by_bytes"); n != 16 {
+		t.Errorf("reportVolumeUsage() exported %d breakdown metrics, want 16", n)
+	}
+
+	// the last fill is kept on failure
+	listErr = errors.New("zfs list failed")
+	c.reportVolumeUsage()
+	if n := testutil.CollectAndCount(collector.Volumes); n != 1 {
+		t.Errorf("reportVolumeUsage() dropped the fill on failure")
+	}
+	if n := testutil.CollectAndCount(collector.Breakdown); n != 16 {
+		t.Errorf("reportVolumeUsage() dropped the breakdown on failure")
+	}
+	collector.Volumes.Reset(nil)
+	collector.Breakdown.Reset(nil)
+}
+
+func TestAlertPoolFull(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	c := &NodeController{recorder: recorder}
+	node := &apis.ZFSNode{}
+
+	c.alertPoolFull(node, []apis.PoolSummary{fullPool("zfspv", false), fullPool("backup", true)},
+		[]apis.PoolSummary{fullPool("zfspv", true), fullPool("backup", true)})
+	if got := <-recorder.Events; !strings.HasPrefix(got, "Warning PoolFull") {
+		t.Errorf("alertPoolFull() event = %q, want a PoolFull warning", got)
+	}
+
+	c.alertPoolFull(node, []apis.PoolSummary{fullPool("zfspv", true)}, []apis.PoolSummary{fullPool("zfspv", false)})
+	if got := <-recorder.Events; !strings.HasPrefix(got, "Normal PoolNotFull") {
+		t.Errorf("alertPoolFull() event = %q, want PoolNotFull", got)
+	}
+	if n := len(recorder.Events); n != 0 {
+		t.Errorf("alertPoolFull() raised %d events for the unchanged pools", n)
+	}
+}
+
+func TestCleanupFullPools(t *testing.T) {
+	var cleaned []string
+	var need int64
+	recorder := record.NewFakeRecorder(10)
+	c := &NodeController{
+		recorder: recorder,
+		listSnapshotRefs: func() (*zfs.SnapshotReferences, error) {
+			return &zfs.SnapshotReferences{}, nil
+		},
+		cleanupSnapshots: func(pool string, n int64, refs *zfs.SnapshotReferences) ([]string, error) {
+			cleaned = append(cleaned, pool)
+			need = n
+			return []string{pool + "/pvc-1@daily"}, nil
+		},
+	}
+	node := &apis.ZFSNode{}
+	summary := []apis.PoolSummary{fullPool("zfspv", true), fullPool("backup", false)}
+	c.summaryAt = time.Now()
+
+	// nothing is destroyed unless the policy is on
+	c.cleanupFullPools(node, summary)
+	if len(cleaned) != 0 {
+		t.Fatalf("cleanupFullPools() cleaned up %v with the cleanup off", cleaned)
+	}
+
+	c.poolCleanup = zfs.PoolCleanupSnapshots
+	c.cleanupFullPools(node, summary)
+	if len(cleaned) != 1 || cleaned[0] != "zfspv" {
+		t.Fatalf("cleanupFullPools() cleaned up %v, want the full pool", cleaned)
+	}
+	// 95Gi allocated of 100Gi with a threshold of 90%
+	if want := int64(5 << 30); need != want {
+		t.Errorf("cleanupFullPools() need = %d, want %d", need, want)
+	}
+	if n := len(recorder.Events); n != 1 {
+		t.Errorf("cleanupFullPools() raised %d events, want 1", n)
+	}
+
+	// once per summary, until the space reclaimed shows up
+	c.cleanupFullPools(node, summary)
+	if len(cleaned) != 1 {
+		t.Errorf("cleanupFullPools() ran again on the same summary")
+	}
+	c.summaryAt = c.summaryAt.Add(time.Minute)
+	c.cleanupFullPools(node, summary)
+	if len(cleaned) != 2 {
+		t.Errorf("cleanupFullPools() did not run on a new summary")
+	}
+}
+
+func importController(results map[string]string, attempts *[]string) *NodeController {
+	return &NodeController{
+		autoImport: true,
+		recorder:   record.NewFakeRecorder(10),
+		importPool: func(name string) apis.PoolImport {
+			*attempts = append(*attempts, name)
+			return apis.PoolImport{Name: name, Result: results[name], Message: name + " " + results[name],
+				LastAttempt: metav1.Now()}
+		},
+	}
+}
+
+func TestImportPools(t *testing.T) {
+	var attempts []string
+	c := importController(map[string]string{"data": zfs.PoolImported, "shared": zfs.PoolImportRefused}, &attempts)
+	node := &apis.ZFSNode{ExpectedPools: []string{"zfspv", "data", "shared"}}
+	pools := []apis.Pool{{Name: "zfspv"}}
+
+	now := time.Now()
+	changed, imported := c.importPools(node, pools, now)
+	if !changed || !imported || len(attempts) != 2 {
+		t.Fatalf("importPools() = %v, %v after attempts %v", changed, imported, attempts)
+	}
+	if len(node.Status.Imports) != 2 || node.Status.Imports[1].Result != zfs.PoolImportRefused {
+		t.Errorf("imports in the status = %+v", node.Status.Imports)
+	}
+	if events := len(c.recorder.(*record.FakeRecorder).Events); events != 2 {
+		t.Errorf("%d events recorded, want 2", events)
+	}
+
+	// the refused pool is not attempted again right away
+	pools = append(pools, apis.Pool{Name: "data"})
+	if changed, _ = c.importPools(node, pools, now.Add(time.Minute)); changed || len(attempts) != 2 {
+		t.Errorf("importPools() retried within the interval: %v", attempts)
+	}
+	if changed, _ = c.importPools(node, pools, now.Add(importRetryInterval+time.Minute)); !changed || len(attempts) != 3 {
+		t.Errorf("importPools() did not retry after the interval: %v", attempts)
+	}
+	if len(node.Status.Imports) != 2 {
+		t.Errorf("the retry is not recorded in place: %+v", node.Status.Imports)
+	}
+}
+
+func TestImportPoolsAlreadyImported(t *testing.T) {
+	var attempts []string
+	c := importController(nil, &attempts)
+	node := &apis.ZFSNode{ExpectedPools: []string{"zfspv"}}
+
+	if changed, imported := c.importPools(node, []apis.Pool{{Name: "zfspv"}}, time.Now()); changed || imported {
+		t.Errorf("importPools() of imported pools = %v, %v", changed, imported)
+	}
+	if len(attempts) != 0 || node.Status.Imports != nil {
+		t.Errorf("importPools() attempted %v", attempts)
+	}
+
+	// disabled by default
+	c.autoImport = false
+	if changed, _ := c.importPools(node, nil, time.Now()); changed || len(attempts) != 0 {
+		t.Errorf("importPools() imported while disabled: %v", attempts)
+	}
+}
+
+func TestReportSnapshotSpace(t *testing.T) {
+	used := resource.MustParse("1Gi")
+	reportSnapshotSpace([]apis.PoolSummary{
+		{Name: "zfspv", SnapshotUsed: &used, Snapshots: 3},
+		// the snapshots of this pool could not be listed
+		{Name: "backup"},
+	})
+	if n := testutil.CollectAndCount(collector.SnapshotSpace, "zfs_pool_snapshot_used_bytes"); n != 1 {
+		t.Errorf("reportSnapshotSpace() exported %d pools, want 1", n)
+	}
+
+	reportSnapshotSpace(nil)
+	if n := testutil.CollectAndCount(collector.SnapshotSpace); n != 0 {
+		t.Errorf("reportSnapshotSpace() kept %d metrics of the pools gone", n)
+	}
+}
+
+func TestReportLatency(t *testing.T) {
+	hist := zfs.LatencyHistogram{
+		Bounds:    []float64{0.001, 0.002},
+		TotalRead: []uint64{8, 2}, TotalWrite: []uint64{0, 0},
+		DiskRead: []uint64{10, 0}, DiskWrite: []uint64{0, 0},
+	}
+	var listErr error
+	c := &NodeController{
+		listPoolLatency: func() (map[string]zfs.LatencyHistogram, error) {
+			return map[string]zfs.LatencyHistogram{"zfspv": hist}, listErr
+		},
+	}
+	c.reportLatency()
+	// the pool has no writes
+	if n := testutil.CollectAndCount(collector.Latency); n != 6 {
+		t.Errorf("reportLatency() exported %d quantiles, want 6", n)
+	}
+
+	// no IO since the last summary
+	c.reportLatency()
+	if n := testutil.CollectAndCount(collector.Latency); n != 0 {
+		t.Errorf("reportLatency() exported %d quantiles without IO, want 0", n)
+	}
+
+	// zfs does not know the histograms, they are not listed anymore
+	listErr = zfs.ErrLatencyUnsupported
+	c.reportLatency()
+	if c.listPoolLatency != nil {
+		t.Errorf("reportLatency() still lists the latency once unsupported")
+	}
+}
@@ -0,0 +1,62 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"errors"
+	"fmt"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	utilexec "k8s.io/utils/exec"
+	"k8s.io/utils/mount"
+)
+
+// ErrAccessTypeMismatch is returned when a volume is requested with an
+// access type (block or filesystem) other than the one it was provisioned for
+var ErrAccessTypeMismatch = errors.New("access type mismatch")
+
+// diskFormat returns the filesystem found on the device, it is empty if
+// the device is not formatted, can be replaced in unit tests
+var diskFormat = func(devicePath string) (string, error) {
+	mounter := &mount.SafeFormatAndMount{Interface: mount.New(""), Exec: utilexec.New()}
+	return mounter.GetDiskFormat(devicePath)
+}
+
+// ValidateAccessType verifies the volume can be published with the requested
+// access type. A dataset is always a filesystem and can not be used as a raw
+// block device. A zvol provisioned without fstype was requested as a raw
+// block device, mounting it as a filesystem would format it.
+//
+// The zvols provisioned before the fstype got recorded have no fstype either,
+// those are accepted as a filesystem once they have been formatted.
+func ValidateAccessType(vol *apis.ZFSVolume, block bool) error {
+	switch {
+	case block && vol.Spec.VolumeType == VolTypeDataset:
+		return fmt.Errorf("%w: volume %s was provisioned as a filesystem (zfs dataset), it can not be published as a raw block device",
+			ErrAccessTypeMismatch, vol.Name)
+	case !block && vol.Spec.VolumeType == VolTypeZVol && vol.Spec.FsType == "":
+		format, err := diskFormat(ZFSDevPath + VolumeDataset(vol))
+		if err != nil {
+			return fmt.Errorf("zfs: failed to probe the filesystem of volume %s: %v", vol.Name, err)
+		}
+		if format == "" {
+			return fmt.Errorf("%w: volume %s was provisioned as a raw block device (zvol without fstype), it can not be mounted as a filesystem",
+				ErrAccessTypeMismatch, vol.Name)
+		}
+	}
+	return nil
+}
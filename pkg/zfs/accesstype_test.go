@@ -0,0 +1,81 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"errors"
+	"testing"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+)
+
+func TestValidateAccessType(t *testing.T) {
+	defer func(fn func(string) (string, error)) { diskFormat = fn }(diskFormat)
+
+	tests := map[string]struct {
+		vtype    string
+		fstype   string
+		block    bool
+		format   string
+		mismatch bool
+	}{
+		"fs provisioned requested block":      {VolTypeDataset, FSTypeZFS, true, "", true},
+		"block provisioned requested fs":      {VolTypeZVol, "", false, "", true},
+		"dataset requested fs":                {VolTypeDataset, FSTypeZFS, false, "", false},
+		"zvol with fstype requested fs":       {VolTypeZVol, "ext4", false, "", false},
+		"zvol with fstype requested block":    {VolTypeZVol, "ext4", true, "", false},
+		"zvol without fstype requested block": {VolTypeZVol, "", true, "", false},
+		"formatted zvol without fstype":       {VolTypeZVol, "", false, "ext4", false},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			diskFormat = func(string) (string, error) { return tt.format, nil }
+			vol := &apis.ZFSVolume{}
+			vol.Name = "pvc-1"
+			vol.Spec.PoolName = "zfspv"
+			vol.Spec.VolumeType = tt.vtype
+			vol.Spec.FsType = tt.fstype
+
+			err := ValidateAccessType(vol, tt.block)
+			if got := errors.Is(err, ErrAccessTypeMismatch); got != tt.mismatch {
+				t.Errorf("ValidateAccessType() error = %v, want mismatch %v", err, tt.mismatch)
+			}
+		})
+	}
+}
+
+func TestValidateAccessTypeProbeFailure(t *testing.T) {
+	defer func(fn func(string) (string, error)) { diskFormat = fn }(diskFormat)
+	var probed string
+	diskFormat = func(dev string) (string, error) {
+		probed = dev
+		return "", errors.New("blkid failed")
+	}
+
+	vol := &apis.ZFSVolume{}
+	vol.Name = "pvc-1"
+	vol.Spec.PoolName = "zfspv"
+	vol.Spec.VolumeType = VolTypeZVol
+
+	err := ValidateAccessType(vol, false)
+	if err == nil || errors.Is(err, ErrAccessTypeMismatch) {
+		t.Errorf("ValidateAccessType() error = %v, want a probe error", err)
+	}
+	if probed != ZFSDevPath+"zfspv/pvc-1" {
+		t.Errorf("probed %s, want %szfspv/pvc-1", probed, ZFSDevPath)
+	}
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+)
+
+// AlignCapacityKey is the annotation of the ZFSVolume which aligns its
+// capacity to the recordsize, or the volblocksize for a zvol, it has to
+// be "true". The capacity of the later expansions is aligned the same way.
+const AlignCapacityKey = "openebs.io/align-capacity"
+
+const (
+	// defaultRecordSize is the zfs default recordsize of a dataset
+	defaultRecordSize = 128 * 1024
+	// defaultVolBlockSize is the zfs default volblocksize of a zvol
+	defaultVolBlockSize = 16 * 1024
+
+	minBlockSize = 512
+	maxBlockSize = 16 * 1024 * 1024
+)
+
+// ParseBlockSize parses a recordsize or a volblocksize as given to zfs,
+// e.g. 131072, 128k or 1M. It must be a power of 2 from 512 to 16M.
+func ParseBlockSize(s string) (int64, error) {
+	val := strings.TrimSuffix(strings.ToUpper(s), "B")
+	mult := int64(1)
+	switch {
+	case strings.HasSuffix(val, "K"):
+		mult, val = 1024, strings.TrimSuffix(val, "K")
+	case strings.HasSuffix(val, "M"):
+		mult, val = 1024*1024, strings.TrimSuffix(val, "M")
+	}
+	n, err := strconv.ParseInt(val, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("zfs: invalid block size %q", s)
+	}
+	size := n * mult
+	if size < minBlockSize || size > maxBlockSize || size&(size-1) != 0 {
+		return 0, fmt.Errorf("zfs: block size %q must be a power of 2 from 512 to 16M", s)
+	}
+	return size, nil
+}
+
+// CapacityAlignment returns the size the capacity of a volume of the type
+// is aligned to, the zfs default is used if the block size is not set.
+func CapacityAlignment(vtype, recordsize, volblocksize string) (int64, error) {
+	bs, def := recordsize, int64(defaultRecordSize)
+	if vtype == VolTypeZVol {
+		bs, def = volblocksize, defaultVolBlockSize
+	}
+	if bs == "" {
+		return def, nil
+	}
+	return ParseBlockSize(bs)
+}
+
+// VolumeAlignment returns the size the capacity of the volume is aligned
+// to, it is 0 if the volume does not have the AlignCapacityKey annotation.
+func VolumeAlignment(vol *apis.ZFSVolume) (int64, error) {
+	if vol.Annotations[AlignCapacityKey] != "true" {
+		return 0, nil
+	}
+	return CapacityAlignment(vol.Spec.VolumeType, vol.Spec.RecordSize, vol.Spec.VolBlockSize)
+}
+
+// AlignCapacity rounds the size up to a multiple of the alignment, the
+// size is returned as is if the alignment is 0.
+func AlignCapacity(size, alignment int64) int64 {
+	if alignment <= 0 {
+		return size
+	}
+	return ((size + alignment - 1) / alignment) * alignment
+}
+
+// SetRequestedCapacity records the capacity the volume would have had
+// without the alignment, it is cleared when the alignment did not change it.
+func SetRequestedCapacity(vol *apis.ZFSVolume, requested, aligned int64) {
+	vol.Status.RequestedCapacity = ""
+	if requested != aligned {
+		vol.Status.RequestedCapacity = strconv.FormatInt(requested, 10)
+	}
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"testing"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+)
+
+func TestParseBlockSize(t *testing.T) {
+	for val, want := range map[string]int64{
+		"512": 512, "4k": 4096, "8K": 8192, "128k": 131072, "128KB": 131072,
+		"1M": 1 << 20, "4m": 4 << 20, "16M": 16 << 20, "1048576": 1 << 20,
+	} {
+		if got, err := ParseBlockSize(val); err != nil || got != want {
+			t.Errorf("ParseBlockSize(%q) = %d, %v, want %d", val, got, err, want)
+		}
+	}
+	for _, val := range []string{"", "0", "256", "100k", "32M", "-4k", "1G", "big"} {
+		if _, err := ParseBlockSize(val); err == nil {
+			t.Errorf("ParseBlockSize(%q) did not fail", val)
+		}
+	}
+}
+
+func TestAlignCapacity(t *testing.T) {
+	const mi = 1024 * 1024
+	tests := []struct {
+		size, alignment, want int64
+	}{
+		{size: 10 * mi, alignment: 0, want: 10 * mi},
+		{size: 10 * mi, alignment: 128 * 1024, want: 10 * mi},
+		{size: 10*mi + 1, alignment: 128 * 1024, want: 10*mi + 128*1024},
+		{size: 10 * mi, alignment: 4 * mi, want: 12 * mi},
+		{size: 10 * mi, alignment: 16 * mi, want: 16 * mi},
+		{size: 17 * mi, alignment: 16 * mi, want: 32 * mi},
+		{size: 5 * 1024, alignment: 8 * 1024, want: 8 * 1024},
+	}
+	for _, test := range tests {
+		if got := AlignCapacity(test.size, test.alignment); got != test.want {
+			t.Errorf("AlignCapacity(%d, %d) = %d, want %d", test.size, test.alignment, got, test.want)
+		}
+	}
+}
+
+func TestVolumeAlignment(t *testing.T) {
+	vol := &apis.ZFSVolume{}
+	vol.Spec.VolumeType = VolTypeDataset
+	vol.Spec.RecordSize = "1M"
+	vol.Spec.VolBlockSize = "8k"
+
+	if got, err := VolumeAlignment(vol); err != nil || got != 0 {
+		t.Errorf("VolumeAlignment() without the annotation = %d, %v, want 0", got, err)
+	}
+
+	vol.Annotations = map[string]string{AlignCapacityKey: "true"}
+	if got, err := VolumeAlignment(vol); err != nil || got != 1<<20 {
+		t.Errorf("VolumeAlignment() of a dataset = %d, %v, want the recordsize", got, err)
+	}
+
+	vol.Spec.VolumeType = VolTypeZVol
+	if got, err := VolumeAlignment(vol); err != nil || got != 8192 {
+		t.Errorf("VolumeAlignment() of a zvol = %d, %v, want the volblocksize", got, err)
+	}
+
+	vol.Spec.VolBlockSize = ""
+	if got, err := VolumeAlignment(vol); err != nil || got != defaultVolBlockSize {
+		t.Errorf("VolumeAlignment() of a zvol without volblocksize = %d, %v, want the default", got, err)
+	}
+}
+
+func TestSetRequestedCapacity(t *testing.T) {
+	vol := &apis.ZFSVolume{}
+	SetRequestedCapacity(vol, 100<<20, 112<<20)
+	if vol.Status.RequestedCapacity != "104857600" {
+		t.Errorf("RequestedCapacity = %q, want 104857600", vol.Status.RequestedCapacity)
+	}
+	SetRequestedCapacity(vol, 112<<20, 112<<20)
+	if vol.Status.RequestedCapacity != "" {
+		t.Errorf("RequestedCapacity = %q, want it cleared", vol.Status.RequestedCapacity)
+	}
+}
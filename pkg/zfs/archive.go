@@ -0,0 +1,247 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	clientset "github.com/openebs/zfs-localpv/pkg/generated/clientset/internalclientset"
+	k8serror "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// reclaim policies on volume deletion
+const (
+	// ReclaimPolicyDelete destroys the volume, it is the default
+	ReclaimPolicyDelete = "Delete"
+	// ReclaimPolicyArchiveThenDelete sends a final snapshot of the volume
+	// to its archive target before destroying it
+	ReclaimPolicyArchiveThenDelete = "ArchiveThenDelete"
+
+	// ArchiveSnapName is the name of the final snapshot of an archived
+	// volume, it is destroyed along with the volume
+	ArchiveSnapName = "openebs-archive"
+
+	archiveFailedReason = "ArchiveFailed"
+
+	// ArchiveAck is the line the archive receiver replies with once it has
+	// stored the whole stream, any other reply is the reason it has not
+	ArchiveAck = "OK"
+)
+
+// archiveAckTimeout is the time the receiver has to acknowledge the
+// archive once the whole stream has been sent
+var archiveAckTimeout = 5 * time.Minute
+
+// sendArchive sends the zfs send stream to the archive receiver at target,
+// w gets a copy of the stream. Once the stream is sent, the connection is
+// closed for writing and the reply of the receiver is returned.
+func sendArchive(ctx context.Context, args []string, target string, w io.Writer) (string, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", target)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	// the connection is closed if the send is cancelled
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	var sendErr bytes.Buffer
+	send := zfsCommandContext(ctx, args...)
+	send.Stdout = io.MultiWriter(conn, w)
+	send.Stderr = &sendErr
+	if err = send.Run(); err != nil {
+		return "", fmt.Errorf("zfs %s failed, %v %s", strings.Join(args, " "), err, sendErr.String())
+	}
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		if err = tcp.CloseWrite(); err != nil {
+			return "", err
+		}
+	}
+	if err = conn.SetReadDeadline(time.Now().Add(archiveAckTimeout)); err != nil {
+		return "", err
+	}
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil && (err != io.EOF || reply == "") {
+		return "", fmt.Errorf("no acknowledgement from the receiver: %v", err)
+	}
+	return strings.TrimSpace(reply), nil
+}
+
+// ValidateReclaimPolicy returns an error if the policy is not supported or
+// if the archive target of ArchiveThenDelete is not a host:port
+func ValidateReclaimPolicy(policy, target string) error {
+	switch policy {
+	case "", ReclaimPolicyDelete:
+		return nil
+	case ReclaimPolicyArchiveThenDelete:
+		if _, port, err := net.SplitHostPort(target); err != nil || port == "" {
+			return fmt.Errorf("zfs: invalid archivetarget %q, reclaim policy %s needs the host:port of the archive receiver",
+				target, ReclaimPolicyArchiveThenDelete)
+		}
+		return nil
+	}
+	return fmt.Errorf("zfs: invalid reclaim policy %q, valid values are %s, %s",
+		policy, ReclaimPolicyDelete, ReclaimPolicyArchiveThenDelete)
+}
+
+// ArchiveRequired tells whether the volume has to be archived before it is
+// destroyed, i.e. it has the ArchiveThenDelete policy and has not been
+// archived yet
+func ArchiveRequired(vol *apis.ZFSVolume) bool {
+	if vol.Spec.ReclaimPolicy != ReclaimPolicyArchiveThenDelete {
+		return false
+	}
+	a := vol.Status.Archive
+	return a == nil || a.Phase != apis.ArchiveCompleted
+}
+
+// archiveSnapshot returns the final snapshot of the volume
+func archiveSnapshot(vol *apis.ZFSVolume) *apis.ZFSSnapshot {
+	snap := &apis.ZFSSnapshot{}
+	snap.Name = ArchiveSnapName
+	snap.Spec.PoolName = vol.Spec.PoolName
+	snap.Spec.DatasetName = vol.Spec.DatasetName
+	snap.Spec.Layout = vol.Spec.Layout
+	snap.Labels = map[string]string{ZFSVolKey: vol.Name}
+	return snap
+}
+
+// ArchiveVolume takes the final snapshot of the volume and sends it in
+// full to its archive target. The archive has succeeded once zfs send has
+// sent the whole stream and the receiver has acknowledged it with
+// ArchiveAck. The snapshot is taken again on a retry if it is missing, so
+// that a failed send is resumed from the same data. It returns nil if the
+// volume has already been destroyed, there is nothing to archive then.
+func ArchiveVolume(ctx context.Context, vol *apis.ZFSVolume) (*apis.VolumeArchive, error) {
+	volume := VolumeDataset(vol)
+	if !datasetExists(volume) {
+		klog.Warningf("zfs: volume %s is not present, there is nothing to archive", volume)
+		return nil, nil
+	}
+	if _, _, err := net.SplitHostPort(vol.Spec.ArchiveTarget); err != nil {
+		return nil, fmt.Errorf("invalid archive target %q: %v", vol.Spec.ArchiveTarget, err)
+	}
+
+	snap := archiveSnapshot(vol)
+	if err := CreateSnapshot(snap); err != nil {
+		return nil, fmt.Errorf("could not take the final snapshot: %v", err)
+	}
+	snapshot := volume + "@" + ArchiveSnapName
+
+	release, err := acquireSendSlot(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("queued for a send slot: %v", err)
+	}
+	defer release()
+
+	counter := &byteCounter{}
+	klog.Infof("zfs: archiving %s to %s", snapshot, vol.Spec.ArchiveTarget)
+	args := append(append([]string{ZFSSendArg}, layoutSendArgs(vol)...), snapshot)
+	reply, err := sendArchive(ctx, args, vol.Spec.ArchiveTarget, counter)
+	if err != nil {
+		return nil, fmt.Errorf("could not send %s to %s after %d bytes: %v",
+			snapshot, vol.Spec.ArchiveTarget, counter.count(), err)
+	}
+	if reply != ArchiveAck {
+		return nil, fmt.Errorf("receiver %s has not stored %s: %q", vol.Spec.ArchiveTarget, snapshot, reply)
+	}
+	klog.Infof("zfs: archived %s to %s, %d bytes", snapshot, vol.Spec.ArchiveTarget, counter.count())
+	return &apis.VolumeArchive{
+		Phase:    apis.ArchiveCompleted,
+		Snapshot: snapshot,
+		Target:   vol.Spec.ArchiveTarget,
+		Bytes:    counter.count(),
+		Time:     metav1.Now(),
+	}, nil
+}
+
+// RecordNodeArchive keeps the completed archive of the volume in the status
+// of the ZFSNode, which outlives the volume, so that the volume can be
+// restored from its archive once destroyed. It replaces an earlier archive
+// of the same volume. The update is retried on a conflict, as the node
+// controller updates the ZFSNode too.
+func RecordNodeArchive(client clientset.Interface, vol *apis.ZFSVolume, archive *apis.VolumeArchive) error {
+	record := *archive
+	record.Volume = vol.Name
+	var err error
+	for i := 0; i < 3; i++ {
+		var node *apis.ZFSNode
+		node, err = client.ZfsV1().ZFSNodes(OpenEBSNamespace).Get(context.TODO(), NodeID, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		archives := []apis.VolumeArchive{record}
+		for _, a := range node.Status.Archives {
+			if a.Volume != vol.Name {
+				archives = append(archives, a)
+			}
+		}
+		node.Status.Archives = archives
+		_, err = client.ZfsV1().ZFSNodes(OpenEBSNamespace).Update(context.TODO(), node, metav1.UpdateOptions{})
+		if !k8serror.IsConflict(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// RecordVolumeArchive sets the archive in the status of the volume. A
+// failed archive pauses the destroy of the volume, which is reported in
+// its DestroyPaused condition until the archive completes. The volume is
+// refreshed with the update so that it can be destroyed right after.
+func RecordVolumeArchive(client clientset.Interface, vol *apis.ZFSVolume, archive *apis.VolumeArchive) error {
+	vol.Status.Archive = archive
+	if archive.Phase == apis.ArchiveFailed {
+		meta.SetStatusCondition(&vol.Status.Conditions, metav1.Condition{
+			Type:    ConditionDestroyPaused,
+			Status:  metav1.ConditionTrue,
+			Reason:  archiveFailedReason,
+			Message: "archive to " + archive.Target + " failed: " + archive.Message,
+		})
+	} else if cond := meta.FindStatusCondition(vol.Status.Conditions, ConditionDestroyPaused); cond != nil &&
+		cond.Reason == archiveFailedReason {
+		meta.RemoveStatusCondition(&vol.Status.Conditions, ConditionDestroyPaused)
+	}
+	updated, err := client.ZfsV1().ZFSVolumes(OpenEBSNamespace).
+		Update(context.TODO(), apiVolume(vol), metav1.UpdateOptions{})
+	if err != nil {
+		return err
+	}
+	// the pool stays resolved in memory
+	updated.Spec.PoolName = vol.Spec.PoolName
+	*vol = *updated
+	return nil
+}
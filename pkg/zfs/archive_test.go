@@ -0,0 +1,279 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	openebsfake "github.com/openebs/zfs-localpv/pkg/generated/clientset/internalclientset/fake"
+	"github.com/openebs/zfs-localpv/pkg/zfs/zfstest"
+	k8serror "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func archiveVolume() *apis.ZFSVolume {
+	vol := &apis.ZFSVolume{}
+	vol.Name = "pvc-1"
+	vol.ResourceVersion = "1"
+	vol.Spec.PoolName = "zfspv"
+	vol.Spec.ReclaimPolicy = ReclaimPolicyArchiveThenDelete
+	vol.Spec.ArchiveTarget = "archive.example.com:9010"
+	return vol
+}
+
+// fakeArchive runs an archive receiver on the volume target, which reads
+// the whole stream and then replies with reply, and a fake zfs holding the
+// volume if it exists, whose send writes data. The streams received are
+// recorded.
+func fakeArchive(t *testing.T, vol *apis.ZFSVolume, exists bool, data string, reply string) (*zfstest.FakeZFS, *[]string) {
+	f := newFakeZFS(t)
+	if exists {
+		f.Create(t, VolumeDataset(vol))
+	}
+	f.Stream(t, data)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	vol.Spec.ArchiveTarget = ln.Addr().String()
+	var received []string
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			stream, _ := io.ReadAll(conn)
+			received = append(received, string(stream))
+			if reply != "" {
+				io.WriteString(conn, reply+"\n")
+			}
+			conn.Close()
+		}
+	}()
+	return f, &received
+}
+
+func TestValidateReclaimPolicy(t *testing.T) {
+	for _, c := range []struct{ policy, target string }{
+		{"", ""}, {ReclaimPolicyDelete, ""}, {ReclaimPolicyArchiveThenDelete, "10.0.0.1:9010"},
+		{ReclaimPolicyArchiveThenDelete, "[fd00::1]:9010"},
+	} {
+		if err := ValidateReclaimPolicy(c.policy, c.target); err != nil {
+			t.Errorf("ValidateReclaimPolicy(%q, %q) = %v", c.policy, c.target, err)
+		}
+	}
+	for _, c := range []struct{ policy, target string }{
+		{"Retain", ""}, {ReclaimPolicyArchiveThenDelete, ""}, {ReclaimPolicyArchiveThenDelete, "archive"},
+		{ReclaimPolicyArchiveThenDelete, "archive:"},
+	} {
+		if err := ValidateReclaimPolicy(c.policy, c.target); err == nil {
+			t.Errorf("ValidateReclaimPolicy(%q, %q) expected error", c.policy, c.target)
+		}
+	}
+}
+
+func TestArchiveRequired(t *testing.T) {
+	vol := archiveVolume()
+	if !ArchiveRequired(vol) {
+		t.Errorf("ArchiveRequired() = false for a volume not archived yet")
+	}
+	vol.Status.Archive = &apis.VolumeArchive{Phase: apis.ArchiveFailed}
+	if !ArchiveRequired(vol) {
+		t.Errorf("ArchiveRequired() = false after a failed archive")
+	}
+	vol.Status.Archive = &apis.VolumeArchive{Phase: apis.ArchiveCompleted}
+	if ArchiveRequired(vol) {
+		t.Errorf("ArchiveRequired() = true for an archived volume")
+	}
+	vol.Spec.ReclaimPolicy = ""
+	vol.Status.Archive = nil
+	if ArchiveRequired(vol) {
+		t.Errorf("ArchiveRequired() = true for the Delete policy")
+	}
+}
+
+// lastRun returns the last zfs command run
+func lastRun(f *zfstest.FakeZFS) string {
+	ran := f.Ran()
+	if len(ran) == 0 {
+		return ""
+	}
+	return ran[len(ran)-1]
+}
+
+func TestArchiveVolume(t *testing.T) {
+	vol := archiveVolume()
+	f, received := fakeArchive(t, vol, true, "stream", ArchiveAck)
+	archive, err := ArchiveVolume(context.Background(), vol)
+	if err != nil {
+		t.Fatalf("ArchiveVolume() unexpected error %v", err)
+	}
+	if archive.Phase != apis.ArchiveCompleted || archive.Snapshot != "zfspv/pvc-1@openebs-archive" ||
+		archive.Target != vol.Spec.ArchiveTarget || archive.Bytes != 6 || archive.Time.IsZero() {
+		t.Errorf("ArchiveVolume() = %+v", archive)
+	}
+	if !f.Exists("zfspv/pvc-1@openebs-archive") {
+		t.Errorf("ArchiveVolume() did not take the final snapshot")
+	}
+	if want := "send zfspv/pvc-1@openebs-archive"; lastRun(f) != want {
+		t.Errorf("ArchiveVolume() ran %q, want %q", lastRun(f), want)
+	}
+	if want := []string{"stream"}; !reflect.DeepEqual(*received, want) {
+		t.Errorf("ArchiveVolume() receiver got %v, want %v", *received, want)
+	}
+
+	// a retry sends the final snapshot taken the first time
+	if _, err = ArchiveVolume(context.Background(), vol); err != nil {
+		t.Errorf("ArchiveVolume() retry unexpected error %v", err)
+	}
+
+	// the receiver has to acknowledge the stream
+	for _, reply := range []string{"", "ERROR no space left"} {
+		vol = archiveVolume()
+		fakeArchive(t, vol, true, "stream", reply)
+		if archive, err = ArchiveVolume(context.Background(), vol); err == nil || archive != nil {
+			t.Errorf("ArchiveVolume() = %+v, %v, want error for the reply %q", archive, err, reply)
+		}
+	}
+	vol = archiveVolume()
+	f, _ = fakeArchive(t, vol, true, "str", ArchiveAck)
+	f.Fail(t, ZFSSendArg, "cannot send: I/O error")
+	if archive, err = ArchiveVolume(context.Background(), vol); err == nil || archive != nil {
+		t.Errorf("ArchiveVolume() = %+v, %v, want error for a failed send", archive, err)
+	}
+
+	// the children of a layout are sent along with the volume
+	vol = archiveVolume()
+	vol.Spec.Layout = "postgres"
+	f, _ = fakeArchive(t, vol, true, "stream", ArchiveAck)
+	if _, err = ArchiveVolume(context.Background(), vol); err != nil {
+		t.Fatalf("ArchiveVolume() unexpected error %v", err)
+	}
+	if want := "send -R zfspv/pvc-1@openebs-archive"; lastRun(f) != want {
+		t.Errorf("ArchiveVolume() ran %q, want %q", lastRun(f), want)
+	}
+
+	// a volume already destroyed has nothing to archive
+	vol = archiveVolume()
+	_, received = fakeArchive(t, vol, false, "stream", ArchiveAck)
+	if archive, err = ArchiveVolume(context.Background(), vol); err != nil || archive != nil || len(*received) != 0 {
+		t.Errorf("ArchiveVolume() of a missing volume = %+v, %v, received %v", archive, err, *received)
+	}
+}
+
+// archiveNode sets the ZFSNode of the node and returns the clientset
+// holding it and the objects
+func archiveNode(t *testing.T, node *apis.ZFSNode, objs ...runtime.Object) *openebsfake.Clientset {
+	origNode, origNamespace := NodeID, OpenEBSNamespace
+	t.Cleanup(func() { NodeID, OpenEBSNamespace = origNode, origNamespace })
+	NodeID, OpenEBSNamespace = "node-1", "openebs"
+	node.Name, node.Namespace = NodeID, OpenEBSNamespace
+	return openebsfake.NewSimpleClientset(append(objs, node)...)
+}
+
+func TestRecordNodeArchive(t *testing.T) {
+	node := &apis.ZFSNode{}
+	node.Status.Archives = []apis.VolumeArchive{
+		{Phase: apis.ArchiveCompleted, Volume: "pvc-1", Snapshot: "zfspv/pvc-1@old"},
+		{Phase: apis.ArchiveCompleted, Volume: "pvc-2", Snapshot: "zfspv/pvc-2@openebs-archive"},
+	}
+	client := archiveNode(t, node)
+	conflicts := 1
+	client.PrependReactor("update", "zfsnodes", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if conflicts == 0 {
+			return false, nil, nil
+		}
+		conflicts--
+		return true, nil, k8serror.NewConflict(schema.GroupResource{Resource: "zfsnodes"}, NodeID, errors.New("modified"))
+	})
+
+	// the archive of the volume replaces its earlier one, after a conflict
+	archive := &apis.VolumeArchive{Phase: apis.ArchiveCompleted, Snapshot: "zfspv/pvc-1@openebs-archive"}
+	if err := RecordNodeArchive(client, archiveVolume(), archive); err != nil {
+		t.Fatalf("RecordNodeArchive() unexpected error %v", err)
+	}
+	stored, err := client.ZfsV1().ZFSNodes(OpenEBSNamespace).Get(context.TODO(), NodeID, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, a := range stored.Status.Archives {
+		got = append(got, a.Volume+" "+a.Snapshot)
+	}
+	want := []string{"pvc-1 zfspv/pvc-1@openebs-archive", "pvc-2 zfspv/pvc-2@openebs-archive"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("RecordNodeArchive() archives %v, want %v", got, want)
+	}
+
+	client.PrependReactor("update", "zfsnodes", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("forbidden")
+	})
+	if err := RecordNodeArchive(client, archiveVolume(), archive); err == nil {
+		t.Errorf("RecordNodeArchive() expected error for a failed update")
+	}
+}
+
+func TestRecordVolumeArchive(t *testing.T) {
+	vol := archiveVolume()
+	vol.Namespace = "openebs"
+	client := archiveNode(t, &apis.ZFSNode{}, vol.DeepCopy())
+	client.PrependReactor("update", "zfsvolumes", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		updated := action.(k8stesting.UpdateAction).GetObject().(*apis.ZFSVolume).DeepCopy()
+		updated.ResourceVersion = "2"
+		return true, updated, nil
+	})
+
+	failed := &apis.VolumeArchive{Phase: apis.ArchiveFailed, Target: vol.Spec.ArchiveTarget, Message: "connection refused"}
+	if err := RecordVolumeArchive(client, vol, failed); err != nil {
+		t.Fatalf("RecordVolumeArchive() unexpected error %v", err)
+	}
+	cond := meta.FindStatusCondition(vol.Status.Conditions, ConditionDestroyPaused)
+	if cond == nil || cond.Reason != archiveFailedReason || !reflect.DeepEqual(vol.Status.Archive, failed) {
+		t.Errorf("RecordVolumeArchive() failed archive = %+v, %+v", vol.Status.Archive, cond)
+	}
+	if vol.ResourceVersion != "2" {
+		t.Errorf("RecordVolumeArchive() did not refresh the volume, version %s", vol.ResourceVersion)
+	}
+
+	completed := &apis.VolumeArchive{Phase: apis.ArchiveCompleted, Snapshot: "zfspv/pvc-1@openebs-archive"}
+	if err := RecordVolumeArchive(client, vol, completed); err != nil {
+		t.Fatalf("RecordVolumeArchive() unexpected error %v", err)
+	}
+	if meta.FindStatusCondition(vol.Status.Conditions, ConditionDestroyPaused) != nil {
+		t.Errorf("RecordVolumeArchive() kept the paused destroy after the archive completed")
+	}
+
+	client.PrependReactor("update", "zfsvolumes", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("conflict")
+	})
+	if err := RecordVolumeArchive(client, vol, completed); err == nil {
+		t.Errorf("RecordVolumeArchive() expected error for a failed update")
+	}
+}
@@ -0,0 +1,203 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"k8s.io/klog/v2"
+)
+
+// ErrBackupCancelled is returned when the backup has been cancelled while
+// the snapshot was being sent
+var ErrBackupCancelled = errors.New("backup cancelled")
+
+// BackupProgressFunc is called with the progress of the running backup
+type BackupProgressFunc func(apis.ZFSBackupProgress)
+
+// backupProgressInterval is the interval at which the progress of the
+// backup is reported
+var backupProgressInterval = 10 * time.Second
+
+// seams for the unit tests
+var (
+	estimateSend = func(args []string) (string, error) {
+		out, err := zfsCommand(args...).CombinedOutput()
+		if err != nil {
+			return "", fmt.Errorf("zfs %s failed, %s", strings.Join(args, " "), string(out))
+		}
+		return string(out), nil
+	}
+	// sendStream pipes the zfs send into nc connected to the destination,
+	// w gets a copy of the stream
+	sendStream = func(ctx context.Context, args []string, host, port string, w io.Writer) error {
+		var sendErr, ncErr bytes.Buffer
+		nc := exec.CommandContext(ctx, "nc", "-w", "3", host, port)
+		nc.Stderr = &ncErr
+		stdin, err := nc.StdinPipe()
+		if err != nil {
+			return err
+		}
+		send := zfsCommandContext(ctx, args...)
+		send.Stdout = io.MultiWriter(stdin, w)
+		send.Stderr = &sendErr
+
+		if err = nc.Start(); err != nil {
+			return fmt.Errorf("could not start nc: %v", err)
+		}
+		if err = send.Start(); err != nil {
+			stdin.Close()
+			nc.Wait()
+			return fmt.Errorf("could not start zfs send: %v", err)
+		}
+		err = send.Wait()
+		stdin.Close()
+		if werr := nc.Wait(); werr != nil && err == nil {
+			return fmt.Errorf("nc %s:%s failed, %v %s", host, port, werr, ncErr.String())
+		}
+		if err != nil {
+			return fmt.Errorf("zfs %s failed, %v %s", strings.Join(args, " "), err, sendErr.String())
+		}
+		return nil
+	}
+)
+
+// buildSendArgs returns the zfs send command of the backup, a dry run only
+// estimates the size of the stream
+func buildSendArgs(bkp *apis.ZFSBackup, vol *apis.ZFSVolume, dryRun bool) []string {
+	args := []string{ZFSSendArg}
+	if dryRun {
+		args = append(args, "-nP")
+	}
+	if bkp.Spec.ResumeToken != "" {
+		return append(args, "-t", bkp.Spec.ResumeToken)
+	}
+	args = append(args, layoutSendArgs(vol)...)
+	if len(bkp.Spec.PrevSnapName) > 0 {
+		// do incremental send
+		args = append(args, "-i", VolumeDataset(vol)+"@"+bkp.Spec.PrevSnapName)
+	}
+	return append(args, VolumeDataset(vol)+"@"+bkp.Spec.SnapName)
+}
+
+// parseSendEstimate returns the size of the stream from the output of
+// zfs send -nP, which ends with the "size" line
+func parseSendEstimate(out string) (int64, error) {
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == "size" {
+			return strconv.ParseInt(fields[1], 10, 64)
+		}
+	}
+	return 0, fmt.Errorf("no size in %q", strings.TrimSpace(out))
+}
+
+// byteCounter counts the bytes written to it
+type byteCounter struct {
+	n int64
+}
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	atomic.AddInt64(&c.n, int64(len(p)))
+	return len(p), nil
+}
+
+func (c *byteCounter) count() int64 {
+	return atomic.LoadInt64(&c.n)
+}
+
+// sendBackup sends the snapshot of the backup to its destination and
+// reports the progress until the send is over
+func sendBackup(ctx context.Context, bkp *apis.ZFSBackup, vol *apis.ZFSVolume, progress BackupProgressFunc) error {
+	bkpAddr := strings.Split(bkp.Spec.BackupDest, ":")
+	if len(bkpAddr) != 2 {
+		return fmt.Errorf("zfs: invalid backup server address %s", bkp.Spec.BackupDest)
+	}
+
+	var estimated int64
+	if out, err := estimateSend(buildSendArgs(bkp, vol, true)); err != nil {
+		klog.Warningf("zfs: could not estimate the size of backup %s: %v", bkp.Name, err)
+	} else if estimated, err = parseSendEstimate(out); err != nil {
+		klog.Warningf("zfs: could not estimate the size of backup %s: %v", bkp.Name, err)
+	}
+
+	counter := &byteCounter{}
+	report := func() {
+		progress(apis.ZFSBackupProgress{BytesSent: counter.count(), EstimatedBytes: estimated})
+	}
+	report()
+
+	// the backup stays queued while the node runs as many sends as allowed
+	release, err := acquireSendSlot(ctx)
+	if err != nil {
+		return fmt.Errorf("zfs: backup %s queued for a send slot: %w", bkp.Name, ErrBackupCancelled)
+	}
+	defer release()
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(backupProgressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				report()
+			}
+		}
+	}()
+
+	err = sendStream(ctx, buildSendArgs(bkp, vol, false), bkpAddr[0], bkpAddr[1], counter)
+	close(done)
+	wg.Wait()
+	report()
+
+	if ctx.Err() != nil {
+		return fmt.Errorf("zfs: backup %s after %d bytes: %w", bkp.Name, counter.count(), ErrBackupCancelled)
+	}
+	return err
+}
+
+// CleanupCancelledBackup destroys the snapshot of the cancelled backup,
+// unless it is kept to resume the send later. Killing the send closes the
+// stream, so a receiver which does not keep a resume token discards the
+// partially received data on its own.
+func CleanupCancelledBackup(bkp *apis.ZFSBackup) error {
+	if bkp.Spec.KeepForResume {
+		klog.Infof("zfs: keeping snapshot %s@%s of the cancelled backup %s to resume it",
+			bkp.Spec.VolumeName, bkp.Spec.SnapName, bkp.Name)
+		return nil
+	}
+	return DestoryBackup(bkp)
+}
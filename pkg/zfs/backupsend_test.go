@@ -0,0 +1,172 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+)
+
+func backupFixture() (*apis.ZFSBackup, *apis.ZFSVolume) {
+	bkp := &apis.ZFSBackup{}
+	bkp.Name = "bkp1"
+	bkp.Spec.SnapName = "snap2"
+	bkp.Spec.BackupDest = "10.0.0.1:9010"
+
+	vol := &apis.ZFSVolume{}
+	vol.Name = "pvc-1"
+	vol.Spec.PoolName = "zfspv"
+	return bkp, vol
+}
+
+func fakeBackupSend(t *testing.T, estimate string, send func(ctx context.Context, w io.Writer) error) {
+	origEstimate, origSend, origInterval := estimateSend, sendStream, backupProgressInterval
+	t.Cleanup(func() {
+		estimateSend, sendStream, backupProgressInterval = origEstimate, origSend, origInterval
+	})
+	estimateSend = func(args []string) (string, error) { return estimate, nil }
+	sendStream = func(ctx context.Context, args []string, host, port string, w io.Writer) error {
+		return send(ctx, w)
+	}
+	backupProgressInterval = time.Millisecond
+}
+
+func TestBuildSendArgs(t *testing.T) {
+	bkp, vol := backupFixture()
+
+	got := buildSendArgs(bkp, vol, false)
+	if want := []string{"send", "zfspv/pvc-1@snap2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("full send: got %v want %v", got, want)
+	}
+
+	bkp.Spec.PrevSnapName = "snap1"
+	got = buildSendArgs(bkp, vol, true)
+	if want := []string{"send", "-nP", "-i", "zfspv/pvc-1@snap1", "zfspv/pvc-1@snap2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("incremental dry run: got %v want %v", got, want)
+	}
+
+	// the children of a layout are sent along with the volume
+	vol.Spec.Layout = "postgres"
+	got = buildSendArgs(bkp, vol, false)
+	if want := []string{"send", "-R", "-i", "zfspv/pvc-1@snap1", "zfspv/pvc-1@snap2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("layout send: got %v want %v", got, want)
+	}
+
+	bkp.Spec.ResumeToken = "1-abc"
+	got = buildSendArgs(bkp, vol, false)
+	if want := []string{"send", "-t", "1-abc"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("resume: got %v want %v", got, want)
+	}
+}
+
+func TestParseSendEstimate(t *testing.T) {
+	out := "full\tzfspv/pvc-1@snap2\t1048576\nsize\t1048576\n"
+	if got, err := parseSendEstimate(out); err != nil || got != 1048576 {
+		t.Errorf("got %d %v want 1048576", got, err)
+	}
+	if _, err := parseSendEstimate("cannot open 'zfspv/pvc-1@snap2'\n"); err == nil {
+		t.Errorf("expected error without size line")
+	}
+}
+
+func TestSendBackupProgress(t *testing.T) {
+	bkp, vol := backupFixture()
+	fakeBackupSend(t, "size\t300\n", func(ctx context.Context, w io.Writer) error {
+		for i := 0; i < 3; i++ {
+			w.Write(make([]byte, 100))
+			time.Sleep(5 * time.Millisecond)
+		}
+		return nil
+	})
+
+	var mu sync.Mutex
+	var reports []apis.ZFSBackupProgress
+	err := sendBackup(context.Background(), bkp, vol, func(p apis.ZFSBackupProgress) {
+		mu.Lock()
+		defer mu.Unlock()
+		reports = append(reports, p)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if len(reports) < 3 {
+		t.Fatalf("expected initial, periodic and final reports, got %v", reports)
+	}
+	if first := reports[0]; first.BytesSent != 0 || first.EstimatedBytes != 300 {
+		t.Errorf("initial report %+v", first)
+	}
+	if last := reports[len(reports)-1]; last.BytesSent != 300 || last.EstimatedBytes != 300 {
+		t.Errorf("final report %+v", last)
+	}
+	for i := 1; i < len(reports); i++ {
+		if reports[i].BytesSent < reports[i-1].BytesSent {
+			t.Errorf("progress went back %v", reports)
+		}
+	}
+}
+
+func TestSendBackupCancel(t *testing.T) {
+	bkp, vol := backupFixture()
+	sent := make(chan struct{})
+	fakeBackupSend(t, "size\t1000\n", func(ctx context.Context, w io.Writer) error {
+		w.Write(make([]byte, 100))
+		close(sent)
+		// the killed zfs send fails once the context is done
+		<-ctx.Done()
+		return errors.New("signal: killed")
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-sent
+		cancel()
+	}()
+
+	var last apis.ZFSBackupProgress
+	err := sendBackup(ctx, bkp, vol, func(p apis.ZFSBackupProgress) { last = p })
+	if !errors.Is(err, ErrBackupCancelled) {
+		t.Fatalf("expected ErrBackupCancelled, got %v", err)
+	}
+	if last.BytesSent != 100 {
+		t.Errorf("expected 100 bytes sent at cancel, got %+v", last)
+	}
+}
+
+func TestSendBackupInvalidDest(t *testing.T) {
+	bkp, vol := backupFixture()
+	bkp.Spec.BackupDest = "10.0.0.1"
+	if err := sendBackup(context.Background(), bkp, vol, func(apis.ZFSBackupProgress) {}); err == nil {
+		t.Errorf("expected error for the invalid destination")
+	}
+}
+
+func TestCleanupCancelledBackupKeepForResume(t *testing.T) {
+	bkp, _ := backupFixture()
+	bkp.Spec.KeepForResume = true
+	// the snapshot is kept, nothing is looked up
+	if err := CleanupCancelledBackup(bkp); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
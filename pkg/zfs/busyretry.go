@@ -0,0 +1,118 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// ErrPoolBusy is the class of the errors returned by zfs when the pool is
+// transiently busy, e.g. during a scrub or a resilver
+var ErrPoolBusy = errors.New("pool is busy")
+
+// busyMessages are the messages of zfs and of the kernel (EBUSY) which
+// classify a failed command as ErrPoolBusy
+var busyMessages = []string{
+	"pool is busy",
+	"pool or dataset is busy",
+	"device or resource busy",
+}
+
+// CommandError is the error of a zfs command which failed, it wraps its
+// class so that it can be checked with errors.Is
+type CommandError struct {
+	Args   []string
+	Output string
+	class  error
+}
+
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("zfs %s failed, %s", strings.Join(e.Args, " "), e.Output)
+}
+
+// Unwrap returns the class of the error, nil if it is not classified
+func (e *CommandError) Unwrap() error { return e.class }
+
+// classifyOutput returns the class of the error for the output of a
+// failed zfs command
+func classifyOutput(out string) error {
+	out = strings.ToLower(out)
+	for _, msg := range busyMessages {
+		if strings.Contains(out, msg) {
+			return ErrPoolBusy
+		}
+	}
+	return nil
+}
+
+// BusyRetry is how a zfs create or zfs set is retried when it fails
+// because the pool is busy, the other errors are not retried
+type BusyRetry struct {
+	// Attempts is the number of retries, the command is not retried if 0
+	Attempts int
+	// Interval is the wait before the first retry, it is doubled for
+	// each retry up to MaxInterval
+	Interval    time.Duration
+	MaxInterval time.Duration
+}
+
+// busyRetry is set by SetBusyRetry
+var busyRetry = BusyRetry{Attempts: 5, Interval: time.Second, MaxInterval: 30 * time.Second}
+
+// sleep waits between the retries, can be replaced in unit tests
+var sleep = time.Sleep
+
+// SetBusyRetry validates and sets the retry of the commands failing on a
+// busy pool
+func SetBusyRetry(attempts int, interval, maxInterval time.Duration) error {
+	if attempts < 0 {
+		return fmt.Errorf("invalid pool busy retries %d", attempts)
+	}
+	if attempts > 0 && (interval <= 0 || maxInterval < interval) {
+		return fmt.Errorf("invalid pool busy retry interval %v, max %v", interval, maxInterval)
+	}
+	busyRetry = BusyRetry{Attempts: attempts, Interval: interval, MaxInterval: maxInterval}
+	return nil
+}
+
+// runRetryBusy runs the zfs command with the arguments by calling run, it
+// is run again with a backoff as long as it fails because the pool is
+// busy. run has to build the command for each attempt, an exec.Cmd can
+// only be run once.
+func runRetryBusy(args []string, run func() ([]byte, error)) ([]byte, error) {
+	wait := busyRetry.Interval
+	for attempt := 0; ; attempt++ {
+		out, err := run()
+		if err == nil {
+			return out, nil
+		}
+		cerr := &CommandError{Args: args, Output: strings.TrimSpace(string(out)), class: classifyOutput(string(out))}
+		if !errors.Is(cerr, ErrPoolBusy) || attempt >= busyRetry.Attempts {
+			return out, cerr
+		}
+		klog.Warningf("zfs: %v, retry %d/%d in %v", cerr, attempt+1, busyRetry.Attempts, wait)
+		sleep(wait)
+		if wait *= 2; wait > busyRetry.MaxInterval {
+			wait = busyRetry.MaxInterval
+		}
+	}
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func withBusyRetry(t *testing.T, attempts int) *[]time.Duration {
+	origRetry, origSleep := busyRetry, sleep
+	t.Cleanup(func() { busyRetry, sleep = origRetry, origSleep })
+
+	if err := SetBusyRetry(attempts, time.Second, 3*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	var waits []time.Duration
+	sleep = func(d time.Duration) { waits = append(waits, d) }
+	return &waits
+}
+
+func TestClassifyOutput(t *testing.T) {
+	for _, out := range []string{
+		"cannot create 'zfspv/pvc-1': pool is busy",
+		"cannot set property for 'zfspv/pvc-1': pool or dataset is busy",
+		"cannot create 'zfspv/pvc-1': Device or resource busy",
+	} {
+		if err := classifyOutput(out); err != ErrPoolBusy {
+			t.Errorf("classifyOutput(%q) = %v, want ErrPoolBusy", out, err)
+		}
+	}
+	for _, out := range []string{
+		"cannot create 'zfspv/pvc-1': dataset already exists",
+		"cannot create 'zfspv/pvc-1': out of space",
+		"",
+	} {
+		if err := classifyOutput(out); err != nil {
+			t.Errorf("classifyOutput(%q) = %v, want no class", out, err)
+		}
+	}
+}
+
+func TestRunRetryBusy(t *testing.T) {
+	waits := withBusyRetry(t, 5)
+	calls := 0
+	out, err := runRetryBusy([]string{"create", "zfspv/pvc-1"}, func() ([]byte, error) {
+		calls++
+		if calls < 4 {
+			return []byte("cannot create 'zfspv/pvc-1': pool is busy\n"), errors.New("exit status 1")
+		}
+		return []byte("done"), nil
+	})
+	if err != nil || string(out) != "done" {
+		t.Fatalf("runRetryBusy() = %q, %v, want the output of the last attempt", out, err)
+	}
+	if calls != 4 {
+		t.Errorf("command run %d times, want 4", calls)
+	}
+	if want := []time.Duration{time.Second, 2 * time.Second, 3 * time.Second}; !reflect.DeepEqual(*waits, want) {
+		t.Errorf("waits = %v, want %v", *waits, want)
+	}
+}
+
+func TestRunRetryBusyGivesUp(t *testing.T) {
+	waits := withBusyRetry(t, 2)
+	calls := 0
+	_, err := runRetryBusy([]string{"set", "compression=on", "zfspv/pvc-1"}, func() ([]byte, error) {
+		calls++
+		return []byte("cannot set property for 'zfspv/pvc-1': pool or dataset is busy"), errors.New("exit status 1")
+	})
+	if !errors.Is(err, ErrPoolBusy) {
+		t.Fatalf("runRetryBusy() = %v, want ErrPoolBusy", err)
+	}
+	if calls != 3 || len(*waits) != 2 {
+		t.Errorf("command run %d times with %d waits, want 3 and 2", calls, len(*waits))
+	}
+	want := "zfs set compression=on zfspv/pvc-1 failed, cannot set property for 'zfspv/pvc-1': pool or dataset is busy"
+	if err.Error() != want {
+		t.Errorf("error = %q, want %q", err.Error(), want)
+	}
+}
+
+func TestRunRetryBusyFailsFast(t *testing.T) {
+	waits := withBusyRetry(t, 5)
+	calls := 0
+	_, err := runRetryBusy([]string{"create", "zfspv/pvc-1"}, func() ([]byte, error) {
+		calls++
+		return []byte("cannot create 'zfspv/pvc-1': out of space"), errors.New("exit status 1")
+	})
+	var cerr *CommandError
+	if !errors.As(err, &cerr) || errors.Is(err, ErrPoolBusy) {
+		t.Fatalf("runRetryBusy() = %v, want a CommandError which is not ErrPoolBusy", err)
+	}
+	if calls != 1 || len(*waits) != 0 {
+		t.Errorf("command run %d times with %d waits, want it to fail fast", calls, len(*waits))
+	}
+}
+
+func TestRunRetryBusyDisabled(t *testing.T) {
+	withBusyRetry(t, 0)
+	calls := 0
+	_, err := runRetryBusy([]string{"create", "zfspv/pvc-1"}, func() ([]byte, error) {
+		calls++
+		return []byte("pool is busy"), errors.New("exit status 1")
+	})
+	if !errors.Is(err, ErrPoolBusy) || calls != 1 {
+		t.Errorf("runRetryBusy() = %v after %d runs, want a single run", err, calls)
+	}
+}
+
+func TestSetBusyRetry(t *testing.T) {
+	withBusyRetry(t, 1)
+	if err := SetBusyRetry(0, 0, 0); err != nil {
+		t.Errorf("SetBusyRetry() disabling the retry failed: %v", err)
+	}
+	for _, r := range []BusyRetry{
+		{Attempts: -1, Interval: time.Second, MaxInterval: time.Second},
+		{Attempts: 3, Interval: 0, MaxInterval: time.Second},
+		{Attempts: 3, Interval: time.Minute, MaxInterval: time.Second},
+	} {
+		if err := SetBusyRetry(r.Attempts, r.Interval, r.MaxInterval); err == nil {
+			t.Errorf("SetBusyRetry(%+v) did not fail", r)
+		}
+	}
+}
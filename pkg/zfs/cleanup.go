@@ -0,0 +1,190 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// ProvisioningProp is the user property marking the dataset created by
+	// the driver, it holds the uid of the ZFSVolume and is cleared once the
+	// volume is ready
+	ProvisioningProp string = "openebs.io:provisioning"
+
+	// PVCNamespaceKey and PVCNameKey are the ZFSVolume annotations naming
+	// the pvc the volume is provisioned for
+	PVCNamespaceKey = "openebs.io/pvc-namespace"
+	PVCNameKey      = "openebs.io/pvc-name"
+
+	// ProvisioningCancelKey is the ZFSVolume annotation set by the
+	// controller when the pvc of a volume being provisioned is gone
+	ProvisioningCancelKey = "openebs.io/provisioning-cancelled"
+)
+
+// FailedCleanupGrace is the time a partially created volume is kept for
+// inspection before it is destroyed
+var FailedCleanupGrace time.Duration
+
+// pendingRollbacks holds the partially created volumes kept for the grace
+// period before being destroyed
+var pendingRollbacks = struct {
+	sync.Mutex
+	volumes map[string]bool
+}{volumes: map[string]bool{}}
+
+// destroyDataset destroys the dataset along with its children
+func destroyDataset(volume string) error {
+	out, err := zfsCommand(ZFSDestroyArg, "-r", volume).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("zfs destroy %s failed, %s", volume, string(out))
+	}
+	return nil
+}
+
+// SetFailedCleanupGrace validates and sets the time a partially created
+// volume is kept before it is destroyed
+func SetFailedCleanupGrace(d time.Duration) error {
+	if d < 0 {
+		return fmt.Errorf("invalid cleanup grace period %v, it should be a positive duration", d)
+	}
+	FailedCleanupGrace = d
+	return nil
+}
+
+// provisioningMarker returns the create option marking the dataset as
+// created by the driver for this volume
+func provisioningMarker(vol *apis.ZFSVolume) []string {
+	if len(vol.UID) == 0 {
+		return nil
+	}
+	return []string{"-o", ProvisioningProp + "=" + string(vol.UID)}
+}
+
+// createdByAttempt tells whether the dataset carries the marker of the
+// volume. Pre-existing or adopted datasets do not have it.
+func createdByAttempt(vol *apis.ZFSVolume) bool {
+	if len(vol.UID) == 0 {
+		return false
+	}
+	marker, err := GetVolumeProperty(vol, ProvisioningProp)
+	return err == nil && marker == string(vol.UID)
+}
+
+// ClearProvisioningMarker removes the marker once the volume is ready so
+// that the dataset is never rolled back afterwards
+func ClearProvisioningMarker(vol *apis.ZFSVolume) error {
+	if len(vol.UID) == 0 {
+		return nil
+	}
+	volume := VolumeDataset(vol)
+	out, err := zfsCommand("inherit", ProvisioningProp, volume).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("zfs inherit %s failed, %s", ProvisioningProp, string(out))
+	}
+	return nil
+}
+
+// rollbackPartialVolume destroys the dataset left behind by a failed
+// create, after the grace period, so that the retry starts clean. It only
+// destroys the dataset if it has been created for this volume.
+func rollbackPartialVolume(vol *apis.ZFSVolume) {
+	volume := VolumeDataset(vol)
+	if !createdByAttempt(vol) {
+		klog.Infof("zfs: %s was not created for this volume, skipping the cleanup", volume)
+		return
+	}
+
+	rollback := func() {
+		pendingRollbacks.Lock()
+		delete(pendingRollbacks.volumes, volume)
+		pendingRollbacks.Unlock()
+		// the volume might have been completed in the meantime
+		if !createdByAttempt(vol) {
+			return
+		}
+		if err := destroyDataset(volume); err != nil {
+			klog.Errorf("zfs: could not cleanup the partially created volume %s: %v", volume, err)
+			return
+		}
+		klog.Infof("zfs: destroyed the partially created volume %s", volume)
+	}
+
+	if FailedCleanupGrace > 0 {
+		klog.Infof("zfs: partially created volume %s will be destroyed in %v", volume, FailedCleanupGrace)
+		pendingRollbacks.Lock()
+		pendingRollbacks.volumes[volume] = true
+		pendingRollbacks.Unlock()
+		time.AfterFunc(FailedCleanupGrace, rollback)
+		return
+	}
+	rollback()
+}
+
+// discardPartialVolume destroys the existing dataset of the volume if it
+// has been left by a failed attempt to create it, so that the retry
+// creates it again instead of adopting a volume which may lack some of its
+// properties. The retry fails while the dataset is kept for inspection. It
+// returns true if the dataset has been destroyed.
+func discardPartialVolume(vol *apis.ZFSVolume) (bool, error) {
+	volume := VolumeDataset(vol)
+	// the zfs commands are run without the lock, it is shared by all the
+	// volumes
+	pendingRollbacks.Lock()
+	pending := pendingRollbacks.volumes[volume]
+	pendingRollbacks.Unlock()
+	if !createdByAttempt(vol) {
+		return false, nil
+	}
+	if pending {
+		return false, fmt.Errorf("partially created volume %s is kept for %v before being created again", volume, FailedCleanupGrace)
+	}
+	if err := destroyDataset(volume); err != nil {
+		return false, fmt.Errorf("could not destroy the partially created volume %s: %v", volume, err)
+	}
+	klog.Infof("zfs: destroyed the partially created volume %s, creating it again", volume)
+	return true, nil
+}
+
+// ProvisioningCancelled tells whether the provisioning of the volume has
+// been cancelled as its pvc is gone
+func ProvisioningCancelled(vol *apis.ZFSVolume) bool {
+	return vol.Annotations[ProvisioningCancelKey] == "true"
+}
+
+// CancelProvisioning destroys the dataset of a volume whose request has
+// vanished in the middle of its provisioning, right away as nobody will
+// retry it. Only the dataset carrying the marker of this volume is
+// destroyed, a dataset of the same name which has not been created for it
+// is left as it is. It returns true if the dataset has been destroyed.
+func CancelProvisioning(vol *apis.ZFSVolume) (bool, error) {
+	volume := VolumeDataset(vol)
+	if !createdByAttempt(vol) {
+		klog.Infof("zfs: %s was not created for the cancelled volume %s, it is left as it is", volume, vol.Name)
+		return false, nil
+	}
+	if err := destroyDataset(volume); err != nil {
+		return false, fmt.Errorf("zfs: could not destroy %s of the cancelled volume %s: %v", volume, vol.Name, err)
+	}
+	klog.Infof("zfs: destroyed %s of the cancelled volume %s", volume, vol.Name)
+	return true, nil
+}
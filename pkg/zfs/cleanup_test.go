@@ -0,0 +1,185 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"github.com/openebs/zfs-localpv/pkg/zfs/zfstest"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// partialDatasets returns the fake zfs with the datasets carrying the
+// provisioning marker, "-" for a dataset without it
+func partialDatasets(t *testing.T, markers map[string]string) *zfstest.FakeZFS {
+	orig := FailedCleanupGrace
+	t.Cleanup(func() { FailedCleanupGrace = orig })
+	f := newFakeZFS(t)
+	for ds, marker := range markers {
+		f.Create(t, ds)
+		if marker != "-" {
+			f.Set(t, ds, ProvisioningProp, marker)
+		}
+	}
+	return f
+}
+
+// waitDestroyed waits for the dataset to be destroyed by the rollback
+func waitDestroyed(t *testing.T, f *zfstest.FakeZFS, dataset string) {
+	t.Helper()
+	for i := 0; i < 500 && f.Exists(dataset); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if f.Exists(dataset) {
+		t.Fatalf("%s has not been destroyed", dataset)
+	}
+}
+
+func partialVol(name, uid string) *apis.ZFSVolume {
+	vol := &apis.ZFSVolume{}
+	vol.Name = name
+	vol.UID = types.UID("uid-" + uid)
+	vol.Spec.PoolName = "zfspv"
+	return vol
+}
+
+func TestSetFailedCleanupGrace(t *testing.T) {
+	defer func(d time.Duration) { FailedCleanupGrace = d }(FailedCleanupGrace)
+
+	if err := SetFailedCleanupGrace(10 * time.Minute); err != nil || FailedCleanupGrace != 10*time.Minute {
+		t.Errorf("SetFailedCleanupGrace(10m) = %v, grace %v", err, FailedCleanupGrace)
+	}
+	if err := SetFailedCleanupGrace(-time.Second); err == nil {
+		t.Errorf("SetFailedCleanupGrace(-1s) expected an error")
+	}
+}
+
+func TestProvisioningMarker(t *testing.T) {
+	vol := partialVol("pvc-1", "1")
+	vol.Spec.ThinProvision = "yes"
+	want := []string{"create", "-o", "openebs.io:provisioning=uid-1", "-o", "mountpoint=legacy", "zfspv/pvc-1"}
+	if got := buildDatasetCreateArgs(vol); !reflect.DeepEqual(got, want) {
+		t.Errorf("buildDatasetCreateArgs() = %v, want %v", got, want)
+	}
+	want = []string{"create", "-s", "-o", "openebs.io:provisioning=uid-1", "zfspv/pvc-1"}
+	if got := buildZvolCreateArgs(vol); !reflect.DeepEqual(got, want) {
+		t.Errorf("buildZvolCreateArgs() = %v, want %v", got, want)
+	}
+}
+
+func TestRollbackPartialVolume(t *testing.T) {
+	f := partialDatasets(t, map[string]string{
+		"zfspv/pvc-partial": "uid-1",
+		"zfspv/pvc-adopted": "-",
+		"zfspv/pvc-other":   "uid-old",
+	})
+
+	rollbackPartialVolume(partialVol("pvc-partial", "1"))
+	// pre-existing or adopted datasets are never touched
+	rollbackPartialVolume(partialVol("pvc-adopted", "2"))
+	rollbackPartialVolume(partialVol("pvc-other", "3"))
+	rollbackPartialVolume(partialVol("pvc-missing", "4"))
+
+	if f.Exists("zfspv/pvc-partial") {
+		t.Errorf("the partially created volume has not been destroyed")
+	}
+	if !f.Exists("zfspv/pvc-adopted") || !f.Exists("zfspv/pvc-other") {
+		t.Errorf("a volume not created by the attempt has been destroyed")
+	}
+}
+
+func TestRollbackPartialVolumeGrace(t *testing.T) {
+	f := partialDatasets(t, map[string]string{"zfspv/pvc-1": "uid-1", "zfspv/pvc-2": "uid-2"})
+	FailedCleanupGrace = 100 * time.Millisecond
+
+	rollbackPartialVolume(partialVol("pvc-1", "1"))
+	rollbackPartialVolume(partialVol("pvc-2", "2"))
+	if !f.Exists("zfspv/pvc-1") || !f.Exists("zfspv/pvc-2") {
+		t.Fatalf("expected the rollback to wait for the grace period")
+	}
+
+	// pvc-2 got completed by a retry during the grace period
+	if err := ClearProvisioningMarker(partialVol("pvc-2", "2")); err != nil {
+		t.Fatal(err)
+	}
+	waitDestroyed(t, f, "zfspv/pvc-1")
+	time.Sleep(50 * time.Millisecond)
+	if !f.Exists("zfspv/pvc-2") {
+		t.Errorf("the completed volume has been destroyed")
+	}
+}
+
+func TestDiscardPartialVolume(t *testing.T) {
+	f := partialDatasets(t, map[string]string{
+		"zfspv/pvc-partial": "uid-1",
+		"zfspv/pvc-adopted": "-",
+		"zfspv/pvc-kept":    "uid-3",
+	})
+
+	// the retry creates again the dataset left by a failed attempt
+	if ok, err := discardPartialVolume(partialVol("pvc-partial", "1")); err != nil || !ok || f.Exists("zfspv/pvc-partial") {
+		t.Errorf("discardPartialVolume() = %v, %v, want the dataset destroyed", ok, err)
+	}
+	// and adopts the others
+	if ok, err := discardPartialVolume(partialVol("pvc-adopted", "2")); err != nil || ok || !f.Exists("zfspv/pvc-adopted") {
+		t.Errorf("discardPartialVolume() = %v, %v, want the dataset adopted", ok, err)
+	}
+
+	// the retry fails while the dataset is kept for inspection
+	FailedCleanupGrace = 100 * time.Millisecond
+	vol := partialVol("pvc-kept", "3")
+	rollbackPartialVolume(vol)
+	if ok, err := discardPartialVolume(vol); err == nil || ok {
+		t.Errorf("discardPartialVolume() = %v, %v, want an error during the grace period", ok, err)
+	}
+	waitDestroyed(t, f, "zfspv/pvc-kept")
+	if ok, err := discardPartialVolume(vol); err != nil || ok {
+		t.Errorf("discardPartialVolume() = %v, %v after the rollback", ok, err)
+	}
+}
+
+func TestCancelProvisioning(t *testing.T) {
+	f := partialDatasets(t, map[string]string{
+		"zfspv/pvc-partial": "uid-1",
+		"zfspv/pvc-adopted": "-",
+		"zfspv/pvc-busy":    "uid-4",
+	})
+
+	// the dataset created for the volume is destroyed right away, even
+	// with a grace period
+	FailedCleanupGrace = time.Hour
+	if ok, err := CancelProvisioning(partialVol("pvc-partial", "1")); err != nil || !ok || f.Exists("zfspv/pvc-partial") {
+		t.Errorf("CancelProvisioning() = %v, %v, want the dataset destroyed", ok, err)
+	}
+	// the datasets not created for the volume are never touched
+	for _, vol := range []*apis.ZFSVolume{partialVol("pvc-adopted", "2"), partialVol("pvc-missing", "3")} {
+		if ok, err := CancelProvisioning(vol); err != nil || ok {
+			t.Errorf("CancelProvisioning(%s) = %v, %v, want the dataset left", vol.Name, ok, err)
+		}
+	}
+	if !f.Exists("zfspv/pvc-adopted") {
+		t.Errorf("the adopted dataset has been destroyed")
+	}
+
+	f.Fail(t, "destroy", "dataset is busy")
+	if ok, err := CancelProvisioning(partialVol("pvc-busy", "4")); err == nil || ok {
+		t.Errorf("CancelProvisioning() = %v, %v, want the error of the destroy", ok, err)
+	}
+}
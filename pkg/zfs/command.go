@@ -0,0 +1,138 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// Commands configures how the node agent invokes the zfs and zpool
+// binaries, e.g. at a non standard path or wrapped in sudo on the nodes
+// where the driver does not run as root.
+type Commands struct {
+	// ZFS is the name or the path of the zfs binary
+	ZFS string
+
+	// ZPool is the name or the path of the zpool binary
+	ZPool string
+
+	// Prefix is the command, along with its arguments, every zfs and
+	// zpool invocation is wrapped in, e.g. sudo -n
+	Prefix []string
+}
+
+// commands is used by the zfs command executor, set by SetCommands
+var commands = Commands{ZFS: ZFSVolCmd, ZPool: ZPoolCmd}
+
+// shellSafe matches the words which do not need to be quoted for bash
+var shellSafe = regexp.MustCompile(`^[A-Za-z0-9_/.:=@%+,-]+$`)
+
+// NewCommands returns the commands for the given binaries and prefix, the
+// default binaries are looked up in the PATH if none is given. It returns
+// an error if any of the binaries is not an executable.
+func NewCommands(zfsPath, zpoolPath, prefix string) (Commands, error) {
+	c := Commands{ZFS: zfsPath, ZPool: zpoolPath, Prefix: strings.Fields(prefix)}
+	if c.ZFS == "" {
+		c.ZFS = ZFSVolCmd
+	}
+	if c.ZPool == "" {
+		c.ZPool = ZPoolCmd
+	}
+
+	bins := []string{c.ZFS, c.ZPool}
+	if len(c.Prefix) > 0 {
+		bins = append(bins, c.Prefix[0])
+	}
+	for _, bin := range bins {
+		if _, err := lookPath(bin); err != nil {
+			return c, fmt.Errorf("zfs: %s is not an executable: %v", bin, err)
+		}
+	}
+	return c, nil
+}
+
+// SetCommands validates and sets the binaries and the prefix used to run
+// the zfs and zpool commands
+func SetCommands(zfsPath, zpoolPath, prefix string) error {
+	c, err := NewCommands(zfsPath, zpoolPath, prefix)
+	if err != nil {
+		return err
+	}
+	commands = c
+	return nil
+}
+
+// argv returns the command line running the binary with the arguments
+func (c Commands) argv(bin string, args []string) []string {
+	argv := make([]string, 0, len(c.Prefix)+1+len(args))
+	argv = append(argv, c.Prefix...)
+	argv = append(argv, bin)
+	return append(argv, args...)
+}
+
+// shell returns the binary along with the prefix to be used in a bash
+// command line
+func (c Commands) shell(bin string) string {
+	words := c.argv(bin, nil)
+	for i, w := range words {
+		if !shellSafe.MatchString(w) {
+			words[i] = "'" + strings.ReplaceAll(w, "'", `'\''`) + "'"
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+func (c Commands) command(ctx context.Context, bin string, args []string) *exec.Cmd {
+	argv := c.argv(bin, args)
+	return exec.CommandContext(ctx, argv[0], argv[1:]...)
+}
+
+// CheckAvailable returns an error if zfs can not be used on this node,
+// e.g. the kernel module is not loaded or /dev/zfs is not accessible
+func CheckAvailable(ctx context.Context) error {
+	out, err := zpoolCommand(ctx, "list", "-H", "-o", "name").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("zfs is not available: %v, %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// zfsCommand returns the zfs command with the arguments
+func zfsCommand(args ...string) *exec.Cmd {
+	return commands.command(context.Background(), commands.ZFS, args)
+}
+
+// zfsCommandContext returns the zfs command with the arguments, it is
+// killed once the context is done
+func zfsCommandContext(ctx context.Context, args ...string) *exec.Cmd {
+	return commands.command(ctx, commands.ZFS, args)
+}
+
+// zpoolCommand returns the zpool command with the arguments, it is killed
+// once the context is done
+func zpoolCommand(ctx context.Context, args ...string) *exec.Cmd {
+	return commands.command(ctx, commands.ZPool, args)
+}
+
+// zfsShell returns the zfs binary to be used in a bash command line
+func zfsShell() string {
+	return commands.shell(commands.ZFS)
+}
@@ -0,0 +1,147 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"github.com/openebs/zfs-localpv/pkg/zfs/zfstest"
+)
+
+func TestNewCommands(t *testing.T) {
+	defer func(f func(string) (string, error)) { lookPath = f }(lookPath)
+	missing := map[string]bool{}
+	lookPath = func(bin string) (string, error) {
+		if missing[bin] {
+			return "", errors.New("executable file not found")
+		}
+		return bin, nil
+	}
+
+	c, err := NewCommands("", "", "")
+	if err != nil {
+		t.Fatalf("NewCommands() unexpected error %v", err)
+	}
+	if c.ZFS != ZFSVolCmd || c.ZPool != ZPoolCmd || len(c.Prefix) != 0 {
+		t.Errorf("NewCommands() defaults = %+v", c)
+	}
+
+	c, err = NewCommands("/usr/local/sbin/zfs", "/usr/local/sbin/zpool", " sudo  -n ")
+	if err != nil {
+		t.Fatalf("NewCommands() unexpected error %v", err)
+	}
+	if !reflect.DeepEqual(c.Prefix, []string{"sudo", "-n"}) {
+		t.Errorf("NewCommands() prefix = %q", c.Prefix)
+	}
+
+	for _, bin := range []string{"/opt/zfs", "/opt/zpool", "doas"} {
+		missing[bin] = true
+		_, err = NewCommands("/opt/zfs", "/opt/zpool", "doas")
+		if err == nil || !strings.Contains(err.Error(), bin) {
+			t.Errorf("NewCommands() error = %v, want %s not found", err, bin)
+		}
+		missing[bin] = false
+	}
+}
+
+func TestCommandLine(t *testing.T) {
+	defer func(c Commands) { commands = c }(commands)
+
+	commands = Commands{ZFS: ZFSVolCmd, ZPool: ZPoolCmd}
+	if got := zfsCommand("list", "-H").Args; !reflect.DeepEqual(got, []string{"zfs", "list", "-H"}) {
+		t.Errorf("zfsCommand() = %q", got)
+	}
+	if got := zfsShell(); got != "zfs" {
+		t.Errorf("zfsShell() = %q", got)
+	}
+
+	commands = Commands{ZFS: "/usr/local/sbin/zfs", ZPool: "/usr/local/sbin/zpool", Prefix: []string{"sudo", "-n"}}
+	want := []string{"sudo", "-n", "/usr/local/sbin/zfs", "destroy", "-r", "zfspv/pvc-1"}
+	if got := zfsCommand("destroy", "-r", "zfspv/pvc-1").Args; !reflect.DeepEqual(got, want) {
+		t.Errorf("zfsCommand() = %q, want %q", got, want)
+	}
+	want = []string{"sudo", "-n", "/usr/local/sbin/zpool", "list", "-H"}
+	if got := zpoolCommand(context.Background(), "list", "-H").Args; !reflect.DeepEqual(got, want) {
+		t.Errorf("zpoolCommand() = %q, want %q", got, want)
+	}
+
+	args := buildOrphanArgs("zfspv", "pvc-1", "snap-1")
+	wantCmd := "sudo -n /usr/local/sbin/zfs send -R zfspv/pvc-1@snap-1 | sudo -n /usr/local/sbin/zfs recv -u " +
+		orphanDataset("zfspv", "pvc-1")
+	if args[1] != wantCmd {
+		t.Errorf("buildOrphanArgs() = %q, want %q", args[1], wantCmd)
+	}
+
+	commands = Commands{ZFS: "/opt/my zfs/zfs", Prefix: []string{"env", "A=it's"}}
+	if got := zfsShell(); got != `env 'A=it'\''s' '/opt/my zfs/zfs'` {
+		t.Errorf("zfsShell() = %q", got)
+	}
+}
+
+// newFakeZFS returns the fake zfs with the datasets, it is replaced by
+// the original commands when the test ends
+func newFakeZFS(t *testing.T, datasets ...string) *zfstest.FakeZFS {
+	f := zfstest.New(t, datasets...)
+	orig := commands
+	t.Cleanup(func() { commands = orig })
+	commands = Commands{ZFS: f.Path, ZPool: f.ZPool}
+	return f
+}
+
+// zfsWrites returns the zfs commands run which change the datasets, the
+// lookups are left out
+func zfsWrites(f *zfstest.FakeZFS) []string {
+	var ran []string
+	for _, cmd := range f.Ran() {
+		if !strings.HasPrefix(cmd, ZFSListArg+" ") && !strings.HasPrefix(cmd, ZFSGetArg+" ") {
+			ran = append(ran, cmd)
+		}
+	}
+	return ran
+}
+
+func TestFakeZFS(t *testing.T) {
+	f := newFakeZFS(t, "zfspv")
+	vol := &apis.ZFSVolume{}
+	vol.Name = "pvc-1"
+	vol.Spec.PoolName = "zfspv"
+
+	if err := getVolume("zfspv/pvc-1"); err == nil {
+		t.Errorf("getVolume() found a missing dataset")
+	}
+	if _, err := zfsCommand("create", "-o", "com.example:tag=a", "zfspv/pvc-1").CombinedOutput(); err != nil {
+		t.Fatal(err)
+	}
+	if val, err := GetVolumeProperty(vol, "com.example:tag"); err != nil || val != "a" {
+		t.Errorf("GetVolumeProperty() = %q, %v, want a", val, err)
+	}
+	if val, err := GetVolumeProperty(vol, "com.example:other"); err != nil || val != "-" {
+		t.Errorf("GetVolumeProperty() = %q, %v, want -", val, err)
+	}
+	if _, err := zfsCommand("destroy", "-r", "zfspv/pvc-1").CombinedOutput(); err != nil || f.Exists("zfspv/pvc-1") {
+		t.Errorf("zfs destroy left the dataset, %v", err)
+	}
+	f.Fail(t, "get", "pool is suspended")
+	if _, err := GetVolumeProperty(vol, "com.example:tag"); err == nil || !strings.Contains(err.Error(), "suspended") {
+		t.Errorf("GetVolumeProperty() = %v, want the failure", err)
+	}
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// DanglingPolicyOff does not check if the snapshots still exist
+	DanglingPolicyOff = "off"
+	// DanglingPolicyMark sets the SnapshotMissing condition on the
+	// ZFSSnapshot
+	DanglingPolicyMark = "mark"
+	// DanglingPolicyDelete deletes the ZFSSnapshot once it has been marked
+	DanglingPolicyDelete = "delete"
+
+	// ConditionSnapshotMissing is the condition type reporting that the
+	// zfs snapshot of the ZFSSnapshot is gone
+	ConditionSnapshotMissing = "SnapshotMissing"
+
+	// reasons of the SnapshotMissing condition
+	missingReasonNotFound = "NotFound"
+	missingReasonFound    = "Found"
+
+	// datasetNotFound is the error of zfs for a dataset which does not exist
+	datasetNotFound = "dataset does not exist"
+)
+
+// DanglingSnapshotPolicy is what is done with a dangling ZFSSnapshot, one
+// whose zfs snapshot has been destroyed on the node behind the driver
+var DanglingSnapshotPolicy = DanglingPolicyMark
+
+// errDatasetNotFound is returned by lookupDataset for a missing dataset
+var errDatasetNotFound = errors.New(datasetNotFound)
+
+// lookupDataset returns errDatasetNotFound if the dataset does not exist,
+// any other error means its existence is not known, can be replaced in
+// unit tests
+var lookupDataset = func(dataset string) error {
+	out, err := zfsCommand(ZFSListArg, "-H", "-o", "name", dataset).CombinedOutput()
+	if err == nil {
+		return nil
+	}
+	if strings.Contains(string(out), datasetNotFound) {
+		return errDatasetNotFound
+	}
+	return fmt.Errorf("zfs list %s failed, %s", dataset, strings.TrimSpace(string(out)))
+}
+
+// SetDanglingSnapshotPolicy validates and sets the dangling snapshot policy
+func SetDanglingSnapshotPolicy(policy string) error {
+	switch policy {
+	case DanglingPolicyOff, DanglingPolicyMark, DanglingPolicyDelete:
+		DanglingSnapshotPolicy = policy
+		return nil
+	}
+	return fmt.Errorf("invalid dangling snapshot policy %q, it should be one of %s, %s or %s",
+		policy, DanglingPolicyOff, DanglingPolicyMark, DanglingPolicyDelete)
+}
+
+// snapshotMissing returns true if the zfs snapshot is gone, either from the
+// volume or from the orphan dataset. The pool is looked up first: a pool
+// which is not imported, e.g. while the node is coming up, or a zfs command
+// which fails for another reason, returns an error instead, the snapshot
+// may still be there.
+func snapshotMissing(snap *apis.ZFSSnapshot) (bool, error) {
+	if err := lookupDataset(snap.Spec.PoolName); err != nil {
+		return false, fmt.Errorf("zfs: pool %s of snapshot %s is not available: %v", snap.Spec.PoolName, snap.Name, err)
+	}
+	volume := snapshotVolume(snap)
+	for _, dataset := range []string{
+		SnapshotDataset(snap),
+		orphanDataset(snap.Spec.PoolName, volume) + "@" + snap.Name,
+	} {
+		err := lookupDataset(dataset)
+		if err == nil {
+			return false, nil
+		}
+		if !errors.Is(err, errDatasetNotFound) {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// ReconcileDanglingSnapshot checks that the zfs snapshot of a Ready
+// ZFSSnapshot still exists and updates its SnapshotMissing condition in
+// place as per the policy. It returns true if the status has changed and
+// the ZFSSnapshot has to be updated, and whether the ZFSSnapshot has to be
+// deleted.
+//
+// A ZFSSnapshot is only deleted if it was already marked by a previous
+// check, so a single failed lookup never deletes it.
+func ReconcileDanglingSnapshot(snap *apis.ZFSSnapshot, policy string) (bool, bool, error) {
+	if policy == DanglingPolicyOff {
+		return false, false, nil
+	}
+	missing, err := snapshotMissing(snap)
+	if err != nil {
+		return false, false, err
+	}
+
+	cond := meta.FindStatusCondition(snap.Status.Conditions, ConditionSnapshotMissing)
+	marked := cond != nil && cond.Status == metav1.ConditionTrue
+	if !missing {
+		if !marked {
+			return false, false, nil
+		}
+		meta.SetStatusCondition(&snap.Status.Conditions, metav1.Condition{
+			Type:    ConditionSnapshotMissing,
+			Status:  metav1.ConditionFalse,
+			Reason:  missingReasonFound,
+			Message: fmt.Sprintf("snapshot %s exists on node %s", SnapshotDataset(snap), NodeID),
+		})
+		return true, false, nil
+	}
+
+	if marked {
+		return false, policy == DanglingPolicyDelete, nil
+	}
+	klog.Warningf("zfs: snapshot %s of ZFSSnapshot %s does not exist anymore", SnapshotDataset(snap), snap.Name)
+	meta.SetStatusCondition(&snap.Status.Conditions, metav1.Condition{
+		Type:    ConditionSnapshotMissing,
+		Status:  metav1.ConditionTrue,
+		Reason:  missingReasonNotFound,
+		Message: fmt.Sprintf("snapshot %s does not exist on node %s", SnapshotDataset(snap), NodeID),
+	})
+	return true, false, nil
+}
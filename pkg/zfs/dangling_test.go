@@ -0,0 +1,186 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"errors"
+	"testing"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+)
+
+// fakeLookup answers the dataset lookups from an in memory list, the
+// lookups of the datasets in broken fail as if zfs could not be run
+type fakeLookup struct {
+	present map[string]bool
+	broken  map[string]bool
+}
+
+func (f *fakeLookup) install(t *testing.T) {
+	orig := lookupDataset
+	t.Cleanup(func() { lookupDataset = orig })
+	lookupDataset = func(dataset string) error {
+		switch {
+		case f.broken[dataset]:
+			return errors.New("zfs list failed, permission denied")
+		case f.present[dataset]:
+			return nil
+		}
+		return errDatasetNotFound
+	}
+}
+
+func danglingSnap() *apis.ZFSSnapshot {
+	snap := &apis.ZFSSnapshot{}
+	snap.Name = "snapshot-1"
+	snap.Labels = map[string]string{ZFSVolKey: "pvc-1"}
+	snap.Spec.PoolName = "zfspv"
+	snap.Status.State = ZFSStatusReady
+	return snap
+}
+
+func TestSetDanglingSnapshotPolicy(t *testing.T) {
+	defer func(policy string) { DanglingSnapshotPolicy = policy }(DanglingSnapshotPolicy)
+
+	tests := map[string]struct {
+		policy  string
+		wantErr bool
+	}{
+		"off":     {"off", false},
+		"mark":    {"mark", false},
+		"delete":  {"delete", false},
+		"empty":   {"", true},
+		"invalid": {"purge", true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := SetDanglingSnapshotPolicy(tt.policy)
+			if (err != nil) != tt.wantErr || (err == nil && DanglingSnapshotPolicy != tt.policy) {
+				t.Errorf("SetDanglingSnapshotPolicy(%q) = %v, policy %q, wantErr %v", tt.policy, err, DanglingSnapshotPolicy, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSnapshotMissing(t *testing.T) {
+	tests := map[string]struct {
+		present map[string]bool
+		broken  map[string]bool
+		missing bool
+		wantErr bool
+	}{
+		"present": {
+			present: map[string]bool{"zfspv": true, "zfspv/pvc-1@snapshot-1": true},
+		},
+		"orphaned": {
+			present: map[string]bool{"zfspv": true, orphanDataset("zfspv", "pvc-1") + "@snapshot-1": true},
+		},
+		"gone": {
+			present: map[string]bool{"zfspv": true},
+			missing: true,
+		},
+		"pool not imported": {
+			wantErr: true,
+		},
+		"zfs failure": {
+			present: map[string]bool{"zfspv": true},
+			broken:  map[string]bool{"zfspv/pvc-1@snapshot-1": true},
+			wantErr: true,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			f := &fakeLookup{present: tt.present, broken: tt.broken}
+			f.install(t)
+			missing, err := snapshotMissing(danglingSnap())
+			if (err != nil) != tt.wantErr || missing != tt.missing {
+				t.Errorf("snapshotMissing() = %v, %v, want %v, wantErr %v", missing, err, tt.missing, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReconcileDanglingSnapshot(t *testing.T) {
+	f := &fakeLookup{present: map[string]bool{"zfspv": true}}
+	f.install(t)
+
+	for _, policy := range []string{DanglingPolicyMark, DanglingPolicyDelete} {
+		t.Run(policy, func(t *testing.T) {
+			snap := danglingSnap()
+
+			// the first check only marks the snapshot
+			changed, remove, err := ReconcileDanglingSnapshot(snap, policy)
+			if err != nil || !changed || remove {
+				t.Fatalf("first check = %v, %v, %v, want changed", changed, remove, err)
+			}
+			cond := meta.FindStatusCondition(snap.Status.Conditions, ConditionSnapshotMissing)
+			if cond == nil || cond.Reason != missingReasonNotFound ||
+				!meta.IsStatusConditionTrue(snap.Status.Conditions, ConditionSnapshotMissing) {
+				t.Fatalf("SnapshotMissing condition = %+v, want true", cond)
+			}
+
+			// a marked snapshot is only deleted with the delete policy
+			changed, remove, err = ReconcileDanglingSnapshot(snap, policy)
+			if err != nil || changed || remove != (policy == DanglingPolicyDelete) {
+				t.Errorf("second check = %v, %v, %v, want remove %v", changed, remove, err, policy == DanglingPolicyDelete)
+			}
+		})
+	}
+}
+
+func TestReconcileDanglingSnapshotUnavailable(t *testing.T) {
+	f := &fakeLookup{}
+	f.install(t)
+
+	snap := danglingSnap()
+	changed, remove, err := ReconcileDanglingSnapshot(snap, DanglingPolicyDelete)
+	if err == nil || changed || remove {
+		t.Errorf("ReconcileDanglingSnapshot() = %v, %v, %v, want an error", changed, remove, err)
+	}
+	if len(snap.Status.Conditions) != 0 {
+		t.Errorf("conditions = %+v, want none", snap.Status.Conditions)
+	}
+}
+
+func TestReconcileDanglingSnapshotFound(t *testing.T) {
+	f := &fakeLookup{present: map[string]bool{"zfspv": true}}
+	f.install(t)
+
+	snap := danglingSnap()
+	if _, _, err := ReconcileDanglingSnapshot(snap, DanglingPolicyMark); err != nil {
+		t.Fatal(err)
+	}
+
+	// e.g. the snapshot has been received back on the node
+	f.present["zfspv/pvc-1@snapshot-1"] = true
+	changed, remove, err := ReconcileDanglingSnapshot(snap, DanglingPolicyDelete)
+	if err != nil || !changed || remove {
+		t.Errorf("ReconcileDanglingSnapshot() = %v, %v, %v, want changed", changed, remove, err)
+	}
+	if meta.IsStatusConditionTrue(snap.Status.Conditions, ConditionSnapshotMissing) {
+		t.Errorf("SnapshotMissing condition is still true")
+	}
+	if changed, _, _ = ReconcileDanglingSnapshot(snap, DanglingPolicyMark); changed {
+		t.Errorf("status changed again for a present snapshot")
+	}
+
+	changed, _, _ = ReconcileDanglingSnapshot(danglingSnap(), DanglingPolicyOff)
+	if changed {
+		t.Errorf("status changed with the off policy")
+	}
+}
@@ -0,0 +1,325 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// DataCheckKey is the ZFSVolume annotation asking the node agent to
+	// read all the data of the volume, zfs verifies the checksums of the
+	// blocks it reads
+	DataCheckKey = "openebs.io/check-data"
+	// DataCheckCancelKey is the ZFSVolume annotation cancelling the check
+	// of the data of the volume
+	DataCheckCancelKey = "openebs.io/cancel-data-check"
+
+	// DefaultDataCheckRate is the bytes read per second by a check when
+	// it is not set
+	DefaultDataCheckRate = "100Mi"
+
+	// dataCheckSnap is the snapshot of the volume read by the check, the
+	// volume stays in use meanwhile
+	dataCheckSnap = "openebs-datacheck"
+	// maxDataCheckErrors is the number of the files with errors recorded
+	// in the status, the others are only counted
+	maxDataCheckErrors = 10
+)
+
+// dataCheckRate is set by SetDataCheckRate, the reads are not bounded if
+// it is 0
+var dataCheckRate int64 = 100 << 20
+
+// dataCheckProgressInterval is the interval at which the bytes read are
+// reported
+var dataCheckProgressInterval = 30 * time.Second
+
+// SetDataCheckRate validates and sets the bytes read per second by a
+// check of the data of a volume, e.g. 100Mi, they are not bounded if it
+// is 0
+func SetDataCheckRate(rate string) error {
+	qty, err := resource.ParseQuantity(rate)
+	if err != nil || qty.Sign() < 0 {
+		return fmt.Errorf("invalid data check rate %q, it should be a quantity of bytes, e.g. 100Mi", rate)
+	}
+	dataCheckRate = qty.Value()
+	return nil
+}
+
+// DataCheckRequested returns true if the volume asks for its data to be
+// checked
+func DataCheckRequested(vol *apis.ZFSVolume) bool {
+	return vol.Annotations[DataCheckKey] == "true"
+}
+
+// DataCheckCancelRequested tells whether the check of the data of the
+// volume has been cancelled with the annotation
+func DataCheckCancelRequested(vol *apis.ZFSVolume) bool {
+	return vol.Annotations[DataCheckCancelKey] == "true"
+}
+
+// DataCheckPending tells whether the data of the volume is to be checked,
+// either as it asks for it or as the node agent has been restarted in the
+// middle of the check, which then starts over
+func DataCheckPending(vol *apis.ZFSVolume) bool {
+	c := vol.Status.DataCheck
+	return DataCheckRequested(vol) || (c != nil && c.Phase == apis.DataCheckInProgress)
+}
+
+// NewDataCheck returns the status of a check which is about to start
+func NewDataCheck() *apis.DataCheck {
+	return &apis.DataCheck{Phase: apis.DataCheckInProgress, StartTime: metav1.Now()}
+}
+
+// throttledWriter discards the bytes written to it, it counts them and
+// slows the writes down to rate bytes per second if rate is set
+type throttledWriter struct {
+	ctx   context.Context
+	rate  int64
+	start time.Time
+	n     int64
+}
+
+func (w *throttledWriter) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n := atomic.AddInt64(&w.n, int64(len(p)))
+	if w.rate > 0 {
+		due := time.Duration(float64(n) / float64(w.rate) * float64(time.Second))
+		if wait := due - time.Since(w.start); wait > 0 {
+			time.Sleep(wait)
+		}
+	}
+	return len(p), nil
+}
+
+func (w *throttledWriter) count() int64 {
+	return atomic.LoadInt64(&w.n)
+}
+
+// buildDataCheckSnapArgs returns the zfs command creating or destroying
+// the snapshot of the check, along with the children of a layout
+func buildDataCheckSnapArgs(vol *apis.ZFSVolume, op string) []string {
+	args := []string{op}
+	if vol.Spec.Layout != "" {
+		args = append(args, "-r")
+	}
+	return append(args, VolumeDataset(vol)+"@"+dataCheckSnap)
+}
+
+// readVolumeData sends the snapshot of the check to w, as it is stored on
+// the disk (-Lec) so that the blocks are not even decompressed
+func readVolumeData(ctx context.Context, vol *apis.ZFSVolume, w io.Writer) error {
+	args := []string{ZFSSendArg, "-Lec"}
+	if vol.Spec.Layout != "" {
+		args = append(args, "-R")
+	}
+	var stderr bytes.Buffer
+	cmd := zfsCommandContext(ctx, append(args, VolumeDataset(vol)+"@"+dataCheckSnap)...)
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%v %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// CheckVolumeData reads all the data of the volume at the data check rate,
+// zfs verifies the checksums of the blocks while reading them. A snapshot
+// of the volume is read, so the volume can stay in use, and the check
+// takes a send slot. The bytes read so far are reported at regular
+// intervals. It returns the bytes read along with the files of the volume
+// zfs has found errors in, and ctx.Err() once ctx is cancelled.
+func CheckVolumeData(ctx context.Context, vol *apis.ZFSVolume, progress func(read int64)) (int64, []string, error) {
+	release, err := acquireSendSlot(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer release()
+
+	dataset := VolumeDataset(vol)
+	snap := dataset + "@" + dataCheckSnap
+	// left by a node agent restarted in the middle of a check
+	if datasetExists(snap) {
+		if out, err := zfsCommand(buildDataCheckSnapArgs(vol, ZFSDestroyArg)...).CombinedOutput(); err != nil {
+			return 0, nil, fmt.Errorf("zfs: could not destroy %s: %v: %s", snap, err, strings.TrimSpace(string(out)))
+		}
+	}
+	if out, err := zfsCommand(buildDataCheckSnapArgs(vol, ZFSSnapshotArg)...).CombinedOutput(); err != nil {
+		return 0, nil, fmt.Errorf("zfs: could not snapshot %s: %v: %s", dataset, err, strings.TrimSpace(string(out)))
+	}
+	defer func() {
+		if out, err := zfsCommand(buildDataCheckSnapArgs(vol, ZFSDestroyArg)...).CombinedOutput(); err != nil {
+			klog.Errorf("zfs: could not destroy %s: %v: %s", snap, err, strings.TrimSpace(string(out)))
+		}
+	}()
+
+	w := &throttledWriter{ctx: ctx, rate: dataCheckRate, start: time.Now()}
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(dataCheckProgressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				progress(w.count())
+			}
+		}
+	}()
+
+	klog.Infof("zfs: checking the data of volume %s", vol.Name)
+	err = readVolumeData(ctx, vol, w)
+	close(done)
+	wg.Wait()
+	if ctx.Err() != nil {
+		return w.count(), nil, ctx.Err()
+	}
+
+	mounts, merr := volumeMounts(vol)
+	if merr != nil {
+		klog.Warningf("zfs: could not get the mounts of %s: %v", vol.Name, merr)
+	}
+	pool := strings.SplitN(dataset, "/", 2)[0]
+	out, perr := zpoolCommand(ctx, "status", "-v", pool).CombinedOutput()
+	if perr != nil {
+		klog.Errorf("zfs: could not list the errors of pool %s: %v: %s", pool, perr, strings.TrimSpace(string(out)))
+		if err == nil {
+			err = fmt.Errorf("zfs: could not list the errors of pool %s: %v", pool, perr)
+		}
+	}
+	return w.count(), parseVolumeDataErrors(out, dataset, mounts), err
+}
+
+// parseVolumeDataErrors returns the files of the volume listed with
+// errors by `zpool status -v`. The files of a dataset are listed by their
+// path while it is mounted and by the dataset otherwise, e.g.
+//
+//	errors: Permanent errors have been detected in the following files:
+//
+//	        zfspv-pool/pvc-1@openebs-datacheck:<0x1>
+//	        /var/lib/kubelet/pods/.../mount/db/base.1
+//	        zfspv-pool/pvc-2:/file
+func parseVolumeDataErrors(raw []byte, dataset string, mounts []string) []string {
+	var errs []string
+	inErrors := false
+	scanner := bufio.NewScanner(bytes.NewReader(raw))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "errors:") {
+			inErrors = true
+			continue
+		}
+		if !inErrors || line == "" {
+			continue
+		}
+		if volumeDataError(line, dataset, mounts) {
+			errs = append(errs, line)
+		}
+	}
+	return errs
+}
+
+// volumeDataError tells whether the file listed with errors belongs to
+// the volume or to its children
+func volumeDataError(file, dataset string, mounts []string) bool {
+	for _, sep := range []string{"@", ":", "/"} {
+		if strings.HasPrefix(file, dataset+sep) {
+			return true
+		}
+	}
+	for _, mp := range mounts {
+		if strings.HasPrefix(file, strings.TrimSuffix(mp, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// FinishDataCheck records the end of the check in its status, only the
+// first files with errors are kept
+func FinishDataCheck(check *apis.DataCheck, read int64, errs []string, err error) {
+	check.BytesRead = read
+	check.ChecksumErrors = int64(len(errs))
+	check.Errors = errs
+	if len(errs) > maxDataCheckErrors {
+		check.Errors = errs[:maxDataCheckErrors]
+	}
+	check.CompletionTime = metav1.Now()
+	switch {
+	case errors.Is(err, context.Canceled):
+		check.Phase = apis.DataCheckCancelled
+		check.Message = "cancelled with the " + DataCheckCancelKey + " annotation"
+	case err != nil:
+		check.Phase = apis.DataCheckFailed
+		check.Message = err.Error()
+	case len(errs) > 0:
+		check.Phase = apis.DataCheckFailed
+		check.Message = fmt.Sprintf("zfs has found errors in %d files of the volume", len(errs))
+	default:
+		check.Phase = apis.DataCheckPassed
+		check.Message = ""
+	}
+}
+
+// UpdateDataCheck records the status of the check on the latest version
+// of the ZFSVolume
+func UpdateDataCheck(name string, c apis.DataCheck) error {
+	vol, err := GetZFSVolume(name)
+	if err != nil {
+		return err
+	}
+	vol.Status.DataCheck = &c
+	return UpdateVolumeStatus(vol)
+}
+
+// ClearDataCheck removes the annotations asking for the check of the data
+// of the volume and cancelling it
+func ClearDataCheck(name string) error {
+	vol, err := GetZFSVolume(name)
+	if err != nil {
+		return err
+	}
+	_, check := vol.Annotations[DataCheckKey]
+	_, cancel := vol.Annotations[DataCheckCancelKey]
+	if !check && !cancel {
+		return nil
+	}
+	delete(vol.Annotations, DataCheckKey)
+	delete(vol.Annotations, DataCheckCancelKey)
+	return UpdateVolumeStatus(vol)
+}
@@ -0,0 +1,240 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"github.com/openebs/zfs-localpv/pkg/zfs/zfstest"
+)
+
+// zpoolErrors is the output of `zpool status -v` with errors in two
+// volumes
+const zpoolErrors = `  pool: zfspv-pool
+ state: ONLINE
+status: One or more devices has experienced an error resulting in data
+	corruption.  Applications may be affected.
+  scan: scrub repaired 0B in 00:01:02 with 2 errors on Sun Oct 15 10:01:03 2026
+config:
+
+	NAME        STATE     READ WRITE CKSUM
+	zfspv-pool  ONLINE       0     0     0
+	  sdb       ONLINE       0     0     4
+
+errors: Permanent errors have been detected in the following files:
+
+        zfspv-pool/pvc-1@openebs-datacheck:<0x1>
+        /var/lib/kubelet/pods/uid/volumes/kubernetes.io~csi/pvc-1/mount/db/base.1
+        zfspv-pool/pvc-10:/file
+        zfspv-pool/pvc-2:/file
+`
+
+// dataCheckZFS returns the fake zfs holding the volume of the check, zfs
+// send writes data bytes and zpool status lists the errors of status. The
+// reads are not slowed down.
+func dataCheckZFS(t *testing.T, data int, status string) *zfstest.FakeZFS {
+	f := newFakeZFS(t, "zfspv-pool/pvc-1")
+	f.Stream(t, strings.Repeat("x", data))
+	f.Pool(t, "status", status)
+	orig := dataCheckRate
+	t.Cleanup(func() { dataCheckRate = orig })
+	dataCheckRate = 0
+	return f
+}
+
+func dataCheckVolume() *apis.ZFSVolume {
+	vol := &apis.ZFSVolume{}
+	vol.Name = "pvc-1"
+	vol.Spec.PoolName = "zfspv-pool"
+	return vol
+}
+
+func TestCheckVolumeData(t *testing.T) {
+	// a snapshot left by a restart of the node agent is destroyed first
+	f := dataCheckZFS(t, 4096, "errors: No known data errors\n")
+	f.Create(t, "zfspv-pool/pvc-1@openebs-datacheck")
+
+	read, errs, err := CheckVolumeData(context.Background(), dataCheckVolume(), func(int64) {})
+	if err != nil || read != 4096 || len(errs) != 0 {
+		t.Fatalf("CheckVolumeData() = %d, %v, %v, want 4096 bytes read without error", read, errs, err)
+	}
+	if f.Exists("zfspv-pool/pvc-1@openebs-datacheck") {
+		t.Errorf("CheckVolumeData() ran %v, want the snapshot destroyed", f.Ran())
+	}
+	want := []string{
+		"destroy zfspv-pool/pvc-1@openebs-datacheck",
+		"snapshot zfspv-pool/pvc-1@openebs-datacheck",
+		"send -Lec zfspv-pool/pvc-1@openebs-datacheck",
+		"zpool status -v zfspv-pool",
+		"destroy zfspv-pool/pvc-1@openebs-datacheck",
+	}
+	if got := dataCheckRan(f); !reflect.DeepEqual(got, want) {
+		t.Errorf("CheckVolumeData() ran %v, want %v", got, want)
+	}
+
+	// the snapshot of a layout holds its children
+	f = dataCheckZFS(t, 4096, "errors: No known data errors\n")
+	vol := dataCheckVolume()
+	vol.Spec.Layout = "postgres"
+	if _, _, err = CheckVolumeData(context.Background(), vol, func(int64) {}); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{
+		"snapshot -r zfspv-pool/pvc-1@openebs-datacheck",
+		"send -Lec -R zfspv-pool/pvc-1@openebs-datacheck",
+		"zpool status -v zfspv-pool",
+		"destroy -r zfspv-pool/pvc-1@openebs-datacheck",
+	}
+	if got := dataCheckRan(f); !reflect.DeepEqual(got, want) {
+		t.Errorf("CheckVolumeData() ran %v, want %v", got, want)
+	}
+}
+
+// dataCheckRan returns the commands run by the check, without the lookups
+// of the snapshot
+func dataCheckRan(f *zfstest.FakeZFS) []string {
+	var ran []string
+	for _, cmd := range f.Ran() {
+		if !strings.HasPrefix(cmd, "list ") {
+			ran = append(ran, cmd)
+		}
+	}
+	return ran
+}
+
+func TestCheckVolumeDataErrors(t *testing.T) {
+	f := dataCheckZFS(t, 2048, zpoolErrors)
+	f.Fail(t, "send", "cannot send: I/O error")
+
+	read, errs, err := CheckVolumeData(context.Background(), dataCheckVolume(), func(int64) {})
+	if err == nil || !strings.Contains(err.Error(), "I/O error") || read != 0 {
+		t.Errorf("CheckVolumeData() = %d, %v, want the error of the send", read, err)
+	}
+	// the files of the other volumes are left out
+	if want := []string{"zfspv-pool/pvc-1@openebs-datacheck:<0x1>"}; !reflect.DeepEqual(errs, want) {
+		t.Errorf("CheckVolumeData() errors = %v, want %v", errs, want)
+	}
+	if f.Exists("zfspv-pool/pvc-1@openebs-datacheck") {
+		t.Errorf("CheckVolumeData() ran %v, want the snapshot destroyed", f.Ran())
+	}
+
+	// the files are listed by their path while the volume is mounted
+	mounts := []string{"/var/lib/kubelet/pods/uid/volumes/kubernetes.io~csi/pvc-1/mount"}
+	errs = parseVolumeDataErrors([]byte(zpoolErrors), "zfspv-pool/pvc-1", mounts)
+	want := []string{
+		"zfspv-pool/pvc-1@openebs-datacheck:<0x1>",
+		"/var/lib/kubelet/pods/uid/volumes/kubernetes.io~csi/pvc-1/mount/db/base.1",
+	}
+	if !reflect.DeepEqual(errs, want) {
+		t.Errorf("parseVolumeDataErrors() = %v, want %v", errs, want)
+	}
+
+	check := NewDataCheck()
+	FinishDataCheck(check, read, errs, err)
+	if check.Phase != apis.DataCheckFailed || check.ChecksumErrors != 2 || len(check.Errors) != 2 {
+		t.Errorf("FinishDataCheck() = %+v, want failed with 2 errors", check)
+	}
+
+	// the errors alone fail the check, only the first ones are kept
+	many := make([]string, 15)
+	FinishDataCheck(check, read, many, nil)
+	if check.Phase != apis.DataCheckFailed || check.ChecksumErrors != 15 || len(check.Errors) != maxDataCheckErrors {
+		t.Errorf("FinishDataCheck() = %+v, want failed with 15 errors", check)
+	}
+	FinishDataCheck(check, read, nil, nil)
+	if check.Phase != apis.DataCheckPassed || check.Message != "" || check.Errors != nil {
+		t.Errorf("FinishDataCheck() = %+v, want passed", check)
+	}
+}
+
+func TestCheckVolumeDataCancel(t *testing.T) {
+	f := dataCheckZFS(t, 1024, "")
+	f.Hang(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+	}()
+	read, _, err := CheckVolumeData(ctx, dataCheckVolume(), func(int64) {})
+	if !errors.Is(err, context.Canceled) || read != 1024 {
+		t.Errorf("CheckVolumeData() = %d, %v, want cancelled after 1024 bytes", read, err)
+	}
+	if f.Exists("zfspv-pool/pvc-1@openebs-datacheck") {
+		t.Error("CheckVolumeData() left its snapshot after the cancel")
+	}
+
+	check := NewDataCheck()
+	FinishDataCheck(check, read, nil, err)
+	if check.Phase != apis.DataCheckCancelled {
+		t.Errorf("FinishDataCheck() = %+v, want cancelled", check)
+	}
+}
+
+func TestThrottledWriter(t *testing.T) {
+	// 4MiB per second, the second write waits for a quarter of a second
+	w := &throttledWriter{ctx: context.Background(), rate: 4 << 20, start: time.Now()}
+	if _, err := w.Write(make([]byte, 512<<10)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(make([]byte, 512<<10)); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(w.start); elapsed < 250*time.Millisecond || w.count() != 1<<20 {
+		t.Errorf("throttledWriter took %v for %d bytes, want at least 250ms", elapsed, w.count())
+	}
+
+	// the writes are not slowed down without a rate, and fail once the
+	// context is done
+	ctx, cancel := context.WithCancel(context.Background())
+	w = &throttledWriter{ctx: ctx, start: time.Now()}
+	if _, err := w.Write(make([]byte, 64<<20)); err != nil || time.Since(w.start) > 100*time.Millisecond {
+		t.Errorf("throttledWriter = %v, took %v without a rate", err, time.Since(w.start))
+	}
+	cancel()
+	if _, err := w.Write(make([]byte, 1)); err == nil {
+		t.Error("throttledWriter accepted a write after the cancel")
+	}
+}
+
+func TestSetDataCheckRate(t *testing.T) {
+	defer func(r int64) { dataCheckRate = r }(dataCheckRate)
+
+	tests := []struct {
+		rate  string
+		want  int64
+		valid bool
+	}{
+		{"100Mi", 100 << 20, true},
+		{"0", 0, true},
+		{"1G", 1000000000, true},
+		{"-1Mi", 0, false},
+		{"fast", 0, false},
+	}
+	for _, tt := range tests {
+		err := SetDataCheckRate(tt.rate)
+		if (err == nil) != tt.valid || (tt.valid && dataCheckRate != tt.want) {
+			t.Errorf("SetDataCheckRate(%q) = %v, rate %d, want %d", tt.rate, err, dataCheckRate, tt.want)
+		}
+	}
+}
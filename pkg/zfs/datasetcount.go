@@ -0,0 +1,101 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+)
+
+// listDatasetNames runs `zfs list` for all the filesystems and the zvols
+// on the node and hands its output to parse as it is produced, can be
+// replaced in unit tests. The output is not buffered as a pool may have
+// tens of thousands of datasets.
+var listDatasetNames = func(ctx context.Context, parse func(io.Reader) error) error {
+	cmd := zfsCommandContext(ctx, "list", "-H", "-o", "name", "-t", "filesystem,volume")
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err = cmd.Start(); err != nil {
+		return err
+	}
+
+	perr := parse(stdout)
+	if perr != nil {
+		// drain the output so that zfs does not block on a full pipe
+		_, _ = io.Copy(io.Discard, stdout)
+	}
+	if err = cmd.Wait(); err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return perr
+}
+
+// ListDatasetCounts returns the number of datasets, the filesystems and
+// the zvols, of each pool, all the datasets are listed with a single
+// `zfs list` call
+func ListDatasetCounts(ctx context.Context) (map[string]int64, error) {
+	var counts map[string]int64
+	err := listDatasetNames(ctx, func(r io.Reader) error {
+		var err error
+		counts, err = countDatasets(r)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("zfs: could not list the datasets: %v", err)
+	}
+	return counts, nil
+}
+
+// countDatasets counts the datasets of each pool from the output of
+// `zfs list -H -o name -t filesystem,volume`, e.g.
+// zfspv-pool/pvc-1
+// The root dataset of the pool is counted as well.
+func countDatasets(r io.Reader) (map[string]int64, error) {
+	counts := map[string]int64{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		name := strings.TrimSpace(scanner.Text())
+		if name == "" {
+			continue
+		}
+		counts[strings.SplitN(name, "/", 2)[0]]++
+	}
+	return counts, scanner.Err()
+}
+
+// setDatasetCounts sets the number of datasets of each pool in the summary
+func setDatasetCounts(summary []apis.PoolSummary, counts map[string]int64) {
+	for i := range summary {
+		summary[i].Datasets = counts[summary[i].Name]
+	}
+}
+
+// DatasetLimitReached tells whether the pool has reached the limit of
+// datasets, it never has if the limit is 0 or the number of its datasets
+// is not known
+func DatasetLimitReached(datasets, limit int64) bool {
+	return limit > 0 && datasets > 0 && datasets >= limit
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+)
+
+func TestCountDatasets(t *testing.T) {
+	out := `zfspv-pool
+zfspv-pool/pvc-1
+zfspv-pool/k8s
+zfspv-pool/k8s/pvc-2
+backup
+
+`
+	counts, err := countDatasets(strings.NewReader(out))
+	if err != nil {
+		t.Fatalf("countDatasets: %v", err)
+	}
+	want := map[string]int64{"zfspv-pool": 4, "backup": 1}
+	if !reflect.DeepEqual(counts, want) {
+		t.Errorf("expected %v, got %v", want, counts)
+	}
+}
+
+func TestListDatasetCounts(t *testing.T) {
+	orig := listDatasetNames
+	t.Cleanup(func() { listDatasetNames = orig })
+
+	listDatasetNames = func(_ context.Context, parse func(io.Reader) error) error {
+		return parse(strings.NewReader("zfspv-pool\nzfspv-pool/pvc-1\n"))
+	}
+	counts, err := ListDatasetCounts(context.Background())
+	if err != nil || counts["zfspv-pool"] != 2 {
+		t.Errorf("expected 2 datasets, got %v %v", counts, err)
+	}
+
+	summary := []apis.PoolSummary{{Name: "zfspv-pool"}, {Name: "other"}}
+	setDatasetCounts(summary, counts)
+	if summary[0].Datasets != 2 || summary[1].Datasets != 0 {
+		t.Errorf("unexpected datasets %d %d", summary[0].Datasets, summary[1].Datasets)
+	}
+
+	listDatasetNames = func(context.Context, func(io.Reader) error) error {
+		return errors.New("zfs list failed")
+	}
+	if _, err := ListDatasetCounts(context.Background()); err == nil {
+		t.Errorf("expected the failed list to be reported")
+	}
+}
+
+func TestDatasetLimitReached(t *testing.T) {
+	tests := []struct {
+		datasets, limit int64
+		want            bool
+	}{
+		{100, 0, false},
+		{0, 100, false},
+		{99, 100, false},
+		{100, 100, true},
+		{150, 100, true},
+	}
+	for _, tt := range tests {
+		if got := DatasetLimitReached(tt.datasets, tt.limit); got != tt.want {
+			t.Errorf("DatasetLimitReached(%d, %d) = %t, want %t", tt.datasets, tt.limit, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+)
+
+const (
+	// MaxDatasetNameLen is the maximum length of a zfs dataset or snapshot
+	// name, ZFS_MAX_DATASET_NAME_LEN less the terminating NUL
+	MaxDatasetNameLen = 255
+
+	// snapshotNameReserve is kept free in the dataset name of a volume for
+	// the "@" and the name of its snapshots, e.g. @snapshot-<uuid>
+	snapshotNameReserve = 64
+
+	// datasetHashLen is the number of hex digits of the volume name hash
+	// ending a shortened dataset name
+	datasetHashLen = 16
+)
+
+// ShortDatasetName returns the name of the dataset for the volume when
+// pool/volume would be too long for zfs, it is empty if the volume name
+// fits. The name is shortened deterministically, the volume name is cut
+// and ended with a hash of the full name, so the same volume always maps
+// to the same dataset.
+func ShortDatasetName(pool, volName string) (string, error) {
+	budget := MaxDatasetNameLen - snapshotNameReserve - len(pool) - 1
+	if len(volName) <= budget {
+		return "", nil
+	}
+	if budget < datasetHashLen {
+		return "", fmt.Errorf("zfs: pool name %s is too long, a dataset name can have at most %d characters",
+			pool, MaxDatasetNameLen)
+	}
+
+	sum := sha256.Sum256([]byte(volName))
+	hash := hex.EncodeToString(sum[:])[:datasetHashLen]
+	if keep := budget - datasetHashLen - 1; keep > 0 {
+		return volName[:keep] + "-" + hash, nil
+	}
+	return hash, nil
+}
+
+// datasetName returns the name of the dataset in the pool for the volume
+// with the given spec
+func datasetName(spec *apis.VolumeInfo, volName string) string {
+	if spec.DatasetName != "" {
+		return spec.DatasetName
+	}
+	return volName
+}
+
+// VolumeDataset returns the full name of the dataset of the volume
+func VolumeDataset(vol *apis.ZFSVolume) string {
+	return vol.Spec.PoolName + "/" + datasetName(&vol.Spec, vol.Name)
+}
+
+// snapshotVolume returns the name of the dataset in the pool the snapshot
+// has been taken of, the snapshot carries the spec of its volume
+func snapshotVolume(snap *apis.ZFSSnapshot) string {
+	return datasetName(&snap.Spec.VolumeInfo, snap.Labels[ZFSVolKey])
+}
+
+// snapshotVolumeDataset returns the full name of the dataset the snapshot
+// has been taken of
+func snapshotVolumeDataset(snap *apis.ZFSSnapshot) string {
+	return snap.Spec.PoolName + "/" + snapshotVolume(snap)
+}
+
+// SnapshotDataset returns the full name of the zfs snapshot
+func SnapshotDataset(snap *apis.ZFSSnapshot) string {
+	return snapshotVolumeDataset(snap) + "@" + snap.Name
+}
+
+// restoreDataset returns the full name of the dataset being restored
+func restoreDataset(rstr *apis.ZFSRestore) string {
+	return rstr.VolSpec.PoolName + "/" + datasetName(&rstr.VolSpec, rstr.Spec.VolumeName)
+}
+
+// FindVolumeByDataset returns the volume owning the dataset, it is nil if
+// none of the volumes does
+func FindVolumeByDataset(vols []apis.ZFSVolume, dataset string) *apis.ZFSVolume {
+	for i := range vols {
+		if VolumeDataset(&vols[i]) == dataset {
+			return &vols[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"strings"
+	"testing"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+)
+
+func TestShortDatasetName(t *testing.T) {
+	longName := "pvc-" + strings.Repeat("a", 200)
+
+	tests := map[string]struct {
+		pool    string
+		volName string
+		short   bool
+		wantErr bool
+	}{
+		"fits":             {pool: "zfspv", volName: "pvc-34133838-0d0d-11ea-96e3-42010a800114"},
+		"long volume":      {pool: "zfspv", volName: longName, short: true},
+		"long pool":        {pool: strings.Repeat("p", 160), volName: "pvc-34133838-0d0d-11ea-96e3-42010a800114", short: true},
+		"pool leaves hash": {pool: strings.Repeat("p", 174), volName: longName, short: true},
+		"pool too long":    {pool: strings.Repeat("p", 180), volName: longName, wantErr: true},
+	}
+	for name, tt := range tests {
+		got, err := ShortDatasetName(tt.pool, tt.volName)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: ShortDatasetName() error = %v, wantErr %v", name, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if (got != "") != tt.short {
+			t.Errorf("%s: ShortDatasetName() = %q, shortened %v", name, got, tt.short)
+			continue
+		}
+		if !tt.short {
+			continue
+		}
+		if l := len(tt.pool) + 1 + len(got); l > MaxDatasetNameLen-snapshotNameReserve {
+			t.Errorf("%s: dataset name is %d characters long", name, l)
+		}
+		again, _ := ShortDatasetName(tt.pool, tt.volName)
+		if again != got {
+			t.Errorf("%s: expected the same name, got %q and %q", name, got, again)
+		}
+	}
+
+	// the names keep the start of the volume name and differ by the hash
+	a, _ := ShortDatasetName("zfspv", longName+"-a")
+	b, _ := ShortDatasetName("zfspv", longName+"-b")
+	if a == b {
+		t.Errorf("expected different names for different volumes, got %q", a)
+	}
+	if !strings.HasPrefix(a, "pvc-aaaa") {
+		t.Errorf("expected the name to keep the volume name prefix, got %q", a)
+	}
+}
+
+func TestDatasetRoundTrip(t *testing.T) {
+	longName := "pvc-" + strings.Repeat("b", 220)
+	short, err := ShortDatasetName("zfspv", longName)
+	if err != nil || short == "" {
+		t.Fatalf("expected the name to be shortened, got %q, %v", short, err)
+	}
+
+	vols := make([]apis.ZFSVolume, 2)
+	vols[0].Name = "pvc-1"
+	vols[0].Spec.PoolName = "zfspv"
+	vols[1].Name = longName
+	vols[1].Spec.PoolName = "zfspv"
+	vols[1].Spec.DatasetName = short
+	vol := &vols[1]
+
+	if got := VolumeDataset(&vols[0]); got != "zfspv/pvc-1" {
+		t.Errorf("VolumeDataset() = %q, want zfspv/pvc-1", got)
+	}
+	if got := VolumeDataset(vol); got != "zfspv/"+short {
+		t.Errorf("VolumeDataset() = %q, want zfspv/%s", got, short)
+	}
+	if got := FindVolumeByDataset(vols, "zfspv/"+short); got != vol {
+		t.Errorf("FindVolumeByDataset() = %v, want %s", got, longName)
+	}
+	if got := FindVolumeByDataset(vols, "zfspv/"+longName); got != nil {
+		t.Errorf("FindVolumeByDataset() found %s for the unshortened name", got.Name)
+	}
+
+	// the snapshots carry the spec of the volume
+	snap := &apis.ZFSSnapshot{}
+	snap.Name = "snapshot-1"
+	snap.Spec.VolumeInfo = vol.Spec
+	snap.Labels = map[string]string{ZFSVolKey: vol.Name}
+	want := "zfspv/" + short + "@snapshot-1"
+	if got := SnapshotDataset(snap); got != want {
+		t.Errorf("SnapshotDataset() = %q, want %q", got, want)
+	}
+	if got := buildZFSSnapCreateArgs(snap); got[1] != want {
+		t.Errorf("buildZFSSnapCreateArgs() = %q, want %q", got, want)
+	}
+	if got := buildZFSSnapDestroyArgs(snap); got[1] != want {
+		t.Errorf("buildZFSSnapDestroyArgs() = %q, want %q", got, want)
+	}
+
+	rstr := &apis.ZFSRestore{}
+	rstr.Spec.VolumeName = vol.Name
+	rstr.VolSpec = vol.Spec
+	if got := restoreDataset(rstr); got != "zfspv/"+short {
+		t.Errorf("restoreDataset() = %q, want zfspv/%s", got, short)
+	}
+}
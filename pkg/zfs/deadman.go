@@ -0,0 +1,229 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// DefaultDeadmanWindow is used when the window is not set
+	DefaultDeadmanWindow = 10 * time.Minute
+
+	// DefaultDeadmanThreshold is used when the threshold is not set
+	DefaultDeadmanThreshold = 1
+
+	// PoolConditionIOHung is the condition type of the pool summary
+	// reporting that zfs has detected IO stuck on the pool
+	PoolConditionIOHung = "IOHung"
+
+	// reasons of the IOHung condition
+	hungReasonDeadman   = "DeadmanEvents"
+	hungReasonNoDeadman = "NoDeadmanEvents"
+
+	// deadmanClass is the class of the zfs events posted when an IO or a
+	// txg sync has not completed in time
+	deadmanClass = "ereport.fs.zfs.deadman"
+)
+
+var (
+	// DeadmanWindow is how long the deadman events of a pool are counted,
+	// the IOHung condition is kept until the window passes without new
+	// events
+	DeadmanWindow = DefaultDeadmanWindow
+
+	// DeadmanThreshold is the number of deadman events in the window which
+	// mark the IO of a pool as hung, 0 disables the detection
+	DeadmanThreshold = DefaultDeadmanThreshold
+)
+
+// DeadmanEvent is a deadman event posted by zfs for a pool
+type DeadmanEvent struct {
+	// Time the event was posted
+	Time time.Time
+	// Vdev is the path of the device with the stuck IO, it is empty when
+	// the whole pool is stuck
+	Vdev string
+}
+
+// zpoolEvents runs `zpool events` for all the pools, can be replaced in
+// unit tests
+var zpoolEvents = func(ctx context.Context) ([]byte, error) {
+	return zpoolCommand(ctx, "events", "-H", "-v").CombinedOutput()
+}
+
+// SetDeadman validates and sets the window in which the deadman events of
+// a pool are counted and the number of events marking its IO as hung
+func SetDeadman(window time.Duration, threshold int) error {
+	if window <= 0 {
+		return fmt.Errorf("invalid deadman window %v, it should be a positive duration", window)
+	}
+	if threshold < 0 {
+		return fmt.Errorf("invalid deadman threshold %d, it should be a positive number", threshold)
+	}
+	DeadmanWindow, DeadmanThreshold = window, threshold
+	return nil
+}
+
+// ListDeadmanEvents returns the deadman events of each pool still in the
+// event queue of zfs, all the pools are read with a single `zpool events`
+// call
+func ListDeadmanEvents(ctx context.Context) (map[string][]DeadmanEvent, error) {
+	out, err := zpoolEvents(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("zfs: could not list the pool events: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return parseDeadmanEvents(out)
+}
+
+// parseDeadmanEvents returns the deadman events of each pool from the
+// output of `zpool events -H -v`. Each event starts with its time and
+// class, followed by its indented members, e.g.
+//
+//	Oct 15 2026 10:00:01.123456789 ereport.fs.zfs.deadman
+//	        class = "ereport.fs.zfs.deadman"
+//	        pool = "zfspv-pool"
+//	        vdev_path = "/dev/sdb1"
+//	        time = 0x652bb8a1 0x75bcd15
+//
+// The other events are skipped.
+func parseDeadmanEvents(raw []byte) (map[string][]DeadmanEvent, error) {
+	events := map[string][]DeadmanEvent{}
+
+	var deadman bool
+	var pool string
+	var event DeadmanEvent
+	flush := func() {
+		if deadman && pool != "" {
+			events[pool] = append(events[pool], event)
+		}
+		deadman, pool, event = false, "", DeadmanEvent{}
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(string(raw)))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if line[0] != ' ' && line[0] != '\t' {
+			flush()
+			fields := strings.Fields(line)
+			deadman = fields[len(fields)-1] == deadmanClass
+			continue
+		}
+		if !deadman {
+			continue
+		}
+
+		// the nested nvlists, like the detector, have their own pool and
+		// time members, they are not quoted strings
+		items := strings.SplitN(strings.TrimSpace(line), " = ", 2)
+		if len(items) != 2 {
+			continue
+		}
+		switch items[0] {
+		case "pool":
+			if v, err := strconv.Unquote(items[1]); err == nil {
+				pool = v
+			}
+		case "vdev_path":
+			if v, err := strconv.Unquote(items[1]); err == nil {
+				event.Vdev = v
+			}
+		case "time":
+			t := strings.Fields(items[1])
+			if len(t) != 2 {
+				return nil, fmt.Errorf("zfs: invalid event time %q", items[1])
+			}
+			sec, err := strconv.ParseInt(t[0], 0, 64)
+			if err != nil {
+				return nil, fmt.Errorf("zfs: invalid event time %q: %v", items[1], err)
+			}
+			nsec, err := strconv.ParseInt(t[1], 0, 64)
+			if err != nil {
+				return nil, fmt.Errorf("zfs: invalid event time %q: %v", items[1], err)
+			}
+			event.Time = time.Unix(sec, nsec)
+		}
+	}
+	flush()
+	return events, scanner.Err()
+}
+
+// deadmanCondition returns the IOHung condition of a pool having the given
+// deadman events. The IO is hung once the events posted within the window
+// reach the threshold, and is not anymore only after the window has passed
+// without new ones, so a flapping device does not flip the condition.
+func deadmanCondition(events []DeadmanEvent, now time.Time, window time.Duration, threshold int) (metav1.Condition, int64) {
+	var count int64
+	var last DeadmanEvent
+	for _, e := range events {
+		if now.Sub(e.Time) > window {
+			continue
+		}
+		count++
+		if e.Time.After(last.Time) {
+			last = e
+		}
+	}
+
+	if count == 0 || count < int64(threshold) {
+		return metav1.Condition{
+			Type:    PoolConditionIOHung,
+			Status:  metav1.ConditionFalse,
+			Reason:  hungReasonNoDeadman,
+			Message: fmt.Sprintf("%d deadman events in the last %s", count, window),
+		}, count
+	}
+
+	msg := fmt.Sprintf("%d deadman events in the last %s, the last at %s", count, window,
+		last.Time.UTC().Format(time.RFC3339))
+	if last.Vdev != "" {
+		msg += " on " + last.Vdev
+	}
+	return metav1.Condition{
+		Type:    PoolConditionIOHung,
+		Status:  metav1.ConditionTrue,
+		Reason:  hungReasonDeadman,
+		Message: msg,
+	}, count
+}
+
+// setDeadmanConditions sets the IOHung condition and the number of recent
+// deadman events of each pool in the summary
+func setDeadmanConditions(summary []apis.PoolSummary, events map[string][]DeadmanEvent, now time.Time) {
+	for i := range summary {
+		cond, count := deadmanCondition(events[summary[i].Name], now, DeadmanWindow, DeadmanThreshold)
+		meta.SetStatusCondition(&summary[i].Conditions, cond)
+		summary[i].DeadmanEvents = count
+	}
+}
+
+// PoolIOHung tells whether the pool summary reports hung IO
+func PoolIOHung(pool *apis.PoolSummary) bool {
+	return meta.IsStatusConditionTrue(pool.Conditions, PoolConditionIOHung)
+}
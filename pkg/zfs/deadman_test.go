@@ -0,0 +1,145 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const zpoolEventsOutput = `Oct 15 2026 10:00:01.123456789 sysevent.fs.zfs.history_event
+        class = "sysevent.fs.zfs.history_event"
+        pool = "zfspv-pool"
+        time = 0x6a0f7a21 0x75bcd15
+
+Oct 15 2026 10:10:01.000000500 ereport.fs.zfs.deadman
+        class = "ereport.fs.zfs.deadman"
+        ena = 0x1f0dd3b2b8b00801
+        detector = (embedded nvlist)
+                version = 0x0
+                scheme = "zfs"
+                pool = 0x3e8a7a3c0e5e7d8d
+                vdev = 0x9b2d6e1c51c6f7a2
+        (end detector)
+        pool = "zfspv-pool"
+        pool_guid = 0x3e8a7a3c0e5e7d8d
+        pool_failmode = "wait"
+        vdev_type = "disk"
+        vdev_path = "/dev/sdb1"
+        time = 0x6a0f7c79 0x1f4
+        eid = 0x2a
+
+Oct 15 2026 10:11:01.000000000 ereport.fs.zfs.deadman
+        class = "ereport.fs.zfs.deadman"
+        pool = "backup"
+        pool_failmode = "wait"
+        time = 0x6a0f7cb5 0x0
+        eid = 0x2b
+`
+
+func TestParseDeadmanEvents(t *testing.T) {
+	events, err := parseDeadmanEvents([]byte(zpoolEventsOutput))
+	if err != nil {
+		t.Fatalf("parseDeadmanEvents() unexpected error %v", err)
+	}
+	want := map[string][]DeadmanEvent{
+		"zfspv-pool": {{Time: time.Unix(0x6a0f7c79, 500), Vdev: "/dev/sdb1"}},
+		"backup":     {{Time: time.Unix(0x6a0f7cb5, 0)}},
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("parseDeadmanEvents() = %+v, want %+v", events, want)
+	}
+
+	if events, err = parseDeadmanEvents(nil); err != nil || len(events) != 0 {
+		t.Errorf("parseDeadmanEvents(nil) = %v, %v", events, err)
+	}
+
+	bad := strings.Replace(zpoolEventsOutput, "0x6a0f7cb5 0x0", "0x6a0f7cb5", 1)
+	if _, err = parseDeadmanEvents([]byte(bad)); err == nil {
+		t.Errorf("parseDeadmanEvents() expected error for invalid time")
+	}
+}
+
+func TestDeadmanCondition(t *testing.T) {
+	now := time.Now()
+	events := []DeadmanEvent{
+		{Time: now.Add(-time.Hour), Vdev: "/dev/sda1"},
+		{Time: now.Add(-5 * time.Minute), Vdev: "/dev/sdb1"},
+		{Time: now.Add(-2 * time.Minute), Vdev: "/dev/sdc1"},
+	}
+
+	cond, count := deadmanCondition(events, now, 10*time.Minute, 1)
+	if cond.Status != metav1.ConditionTrue || count != 2 {
+		t.Errorf("deadmanCondition() = %+v, %d, want hung with 2 events", cond, count)
+	}
+	if !strings.Contains(cond.Message, "/dev/sdc1") {
+		t.Errorf("deadmanCondition() message %q does not report the last event", cond.Message)
+	}
+
+	// the events below the threshold are not enough
+	if cond, count = deadmanCondition(events, now, 10*time.Minute, 3); cond.Status != metav1.ConditionFalse || count != 2 {
+		t.Errorf("deadmanCondition() = %+v, %d, want not hung below the threshold", cond, count)
+	}
+
+	// the condition is kept until the window passes without new events
+	if cond, _ = deadmanCondition(events, now.Add(7*time.Minute), 10*time.Minute, 1); cond.Status != metav1.ConditionTrue {
+		t.Errorf("deadmanCondition() = %+v, want hung within the window", cond)
+	}
+	if cond, count = deadmanCondition(events, now.Add(15*time.Minute), 10*time.Minute, 1); cond.Status != metav1.ConditionFalse || count != 0 {
+		t.Errorf("deadmanCondition() = %+v, %d, want cleared after the window", cond, count)
+	}
+
+	if cond, _ = deadmanCondition(nil, now, 10*time.Minute, 1); cond.Reason != hungReasonNoDeadman {
+		t.Errorf("deadmanCondition(nil) = %+v", cond)
+	}
+}
+
+func TestSetDeadmanConditions(t *testing.T) {
+	now := time.Now()
+	summary := []apis.PoolSummary{{Name: "zfspv-pool"}, {Name: "backup"}}
+	setDeadmanConditions(summary, map[string][]DeadmanEvent{
+		"zfspv-pool": {{Time: now.Add(-time.Minute), Vdev: "/dev/sdb1"}},
+	}, now)
+
+	if !PoolIOHung(&summary[0]) || summary[0].DeadmanEvents != 1 {
+		t.Errorf("setDeadmanConditions() = %+v", summary[0])
+	}
+	if cond := meta.FindStatusCondition(summary[1].Conditions, PoolConditionIOHung); cond == nil ||
+		cond.Status != metav1.ConditionFalse || summary[1].DeadmanEvents != 0 {
+		t.Errorf("setDeadmanConditions() = %+v", summary[1])
+	}
+}
+
+func TestSetDeadman(t *testing.T) {
+	defer func(d time.Duration, n int) { DeadmanWindow, DeadmanThreshold = d, n }(DeadmanWindow, DeadmanThreshold)
+
+	if err := SetDeadman(time.Minute, 0); err != nil || DeadmanWindow != time.Minute || DeadmanThreshold != 0 {
+		t.Errorf("SetDeadman(1m, 0) = %v, window %v, threshold %d", err, DeadmanWindow, DeadmanThreshold)
+	}
+	if err := SetDeadman(0, 1); err == nil {
+		t.Errorf("SetDeadman(0s, 1) expected error")
+	}
+	if err := SetDeadman(time.Minute, -1); err == nil {
+		t.Errorf("SetDeadman(1m, -1) expected error")
+	}
+}
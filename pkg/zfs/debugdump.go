@@ -0,0 +1,202 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serror "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// DebugDumpKey is the ZFSVolume annotation asking the node agent to
+	// dump the zfs properties and the snapshots of the volume into a
+	// ConfigMap, the annotation is removed once the dump is taken
+	DebugDumpKey = "openebs.io/debug-dump"
+
+	// debugDumpPrefix is prepended to the volume name to name its ConfigMap
+	debugDumpPrefix = "zfs-debug-"
+
+	// debugDumpLimit is the maximum size of each output in the ConfigMap,
+	// it keeps the ConfigMap well under the 1MiB object limit
+	debugDumpLimit = 256 * 1024
+
+	// redactedValue replaces the value of the sensitive properties
+	redactedValue = "<redacted>"
+)
+
+// the keys of the debug dump ConfigMap data
+const (
+	DebugDumpGetAll = "zfs-get-all"
+	DebugDumpList   = "zfs-list"
+	DebugDumpError  = "error"
+	DebugDumpTime   = "captured-at"
+)
+
+// DebugDumpRequested returns true if the volume asks for a debug dump
+func DebugDumpRequested(vol *apis.ZFSVolume) bool {
+	_, ok := vol.Annotations[DebugDumpKey]
+	return ok
+}
+
+// DebugDumpName returns the name of the ConfigMap holding the debug dump
+// of the volume
+func DebugDumpName(volName string) string {
+	return debugDumpPrefix + volName
+}
+
+// isSensitiveProperty returns true if the value of the property must not
+// leave the node, the key properties and the user properties naming a key
+// or a secret
+func isSensitiveProperty(prop string) bool {
+	if prop == "keylocation" {
+		return true
+	}
+	if !strings.Contains(prop, ":") {
+		return false
+	}
+	prop = strings.ToLower(prop)
+	for _, word := range []string{"key", "secret", "password", "token"} {
+		if strings.Contains(prop, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactProperties replaces the value of the sensitive properties in the
+// output of zfs get -H, which is name, property, value and source
+// separated by tabs
+func redactProperties(out string) string {
+	lines := strings.Split(out, "\n")
+	for i, line := range lines {
+		fields := strings.Split(line, "\t")
+		if len(fields) < 3 || !isSensitiveProperty(fields[1]) {
+			continue
+		}
+		fields[2] = redactedValue
+		lines[i] = strings.Join(fields, "\t")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// boundOutput truncates the output to the limit at a line boundary and
+// notes how much was left out
+func boundOutput(out string, limit int) string {
+	if len(out) <= limit {
+		return out
+	}
+	cut := strings.LastIndex(out[:limit], "\n") + 1
+	if cut == 0 {
+		cut = limit
+	}
+	return fmt.Sprintf("%s... truncated %d bytes\n", out[:cut], len(out)-cut)
+}
+
+// zfsOutput runs the zfs command and returns its output
+func zfsOutput(args ...string) (string, error) {
+	out, err := zfsCommand(args...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("zfs %s failed, %s", strings.Join(args, " "), string(out))
+	}
+	return string(out), nil
+}
+
+// CaptureDebugDump returns the zfs properties and the list of the datasets
+// and snapshots of the volume, a failed command is reported in the dump
+// instead of failing it
+func CaptureDebugDump(vol *apis.ZFSVolume) map[string]string {
+	volume := VolumeDataset(vol)
+	data := map[string]string{DebugDumpTime: time.Now().UTC().Format(time.RFC3339)}
+	var errs []string
+
+	if out, err := zfsOutput(ZFSGetArg, "-H", "all", volume); err != nil {
+		errs = append(errs, err.Error())
+	} else {
+		data[DebugDumpGetAll] = boundOutput(redactProperties(out), debugDumpLimit)
+	}
+	if out, err := zfsOutput(ZFSListArg, "-t", "all", "-r", volume); err != nil {
+		errs = append(errs, err.Error())
+	} else {
+		data[DebugDumpList] = boundOutput(out, debugDumpLimit)
+	}
+	if len(errs) > 0 {
+		data[DebugDumpError] = boundOutput(strings.Join(errs, "\n"), debugDumpLimit)
+	}
+	return data
+}
+
+// SaveDebugDump writes the dump of the volume into its ConfigMap in the
+// OpenEBS namespace, the ConfigMap is owned by the ZFSVolume so it is
+// deleted along with the volume
+func SaveDebugDump(kubeClient kubernetes.Interface, vol *apis.ZFSVolume, data map[string]string) error {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      DebugDumpName(vol.Name),
+			Namespace: OpenEBSNamespace,
+			Labels:    map[string]string{ZFSVolKey: vol.Name},
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: apis.SchemeGroupVersion.String(),
+				Kind:       "ZFSVolume",
+				Name:       vol.Name,
+				UID:        vol.UID,
+			}},
+		},
+		Data: data,
+	}
+	if err := writeConfigMap(kubeClient, cm); err != nil {
+		return fmt.Errorf("zfs: could not save the debug dump of %s: %v", vol.Name, err)
+	}
+	return nil
+}
+
+// writeConfigMap creates the ConfigMap or replaces the labels, the owners
+// and the data of the existing one
+func writeConfigMap(kubeClient kubernetes.Interface, cm *corev1.ConfigMap) error {
+	cms := kubeClient.CoreV1().ConfigMaps(cm.Namespace)
+	old, err := cms.Get(context.TODO(), cm.Name, metav1.GetOptions{})
+	if k8serror.IsNotFound(err) {
+		_, err = cms.Create(context.TODO(), cm, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	old.Labels, old.OwnerReferences, old.Data = cm.Labels, cm.OwnerReferences, cm.Data
+	_, err = cms.Update(context.TODO(), old, metav1.UpdateOptions{})
+	return err
+}
+
+// ClearDebugDump removes the debug dump annotation from the volume
+func ClearDebugDump(vol *apis.ZFSVolume) error {
+	vol, err := GetZFSVolume(vol.Name)
+	if err != nil {
+		return err
+	}
+	if !DebugDumpRequested(vol) {
+		return nil
+	}
+	delete(vol.Annotations, DebugDumpKey)
+	return UpdateVolumeStatus(vol)
+}
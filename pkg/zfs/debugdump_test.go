@@ -0,0 +1,170 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"github.com/openebs/zfs-localpv/pkg/zfs/zfstest"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const getAllOutput = "zfspv/pvc-1\tcompression\tlz4\tlocal\n" +
+	"zfspv/pvc-1\tkeylocation\tfile:///etc/zfs/pvc-1.key\tlocal\n" +
+	"zfspv/pvc-1\tkeystatus\tavailable\t-\n" +
+	"zfspv/pvc-1\tcom.example:apikey\ts3cr3t\tlocal\n" +
+	"zfspv/pvc-1\topenebs.io:owner\tnode1\tlocal\n"
+
+// debugDumpZFS returns the fake zfs holding the volume, zfs get all
+// prints its property file all
+func debugDumpZFS(t *testing.T) *zfstest.FakeZFS {
+	f := newFakeZFS(t, "zfspv/pvc-1")
+	f.Set(t, "zfspv/pvc-1", "all", strings.TrimSuffix(getAllOutput, "\n"))
+	return f
+}
+
+func TestCaptureDebugDump(t *testing.T) {
+	vol := &apis.ZFSVolume{}
+	vol.Name = "pvc-1"
+	vol.Spec.PoolName = "zfspv"
+
+	f := debugDumpZFS(t)
+
+	data := CaptureDebugDump(vol)
+	want := []string{"get -H all zfspv/pvc-1", "list -t all -r zfspv/pvc-1"}
+	if got := f.Ran(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("expected commands %q, got %q", want, got)
+	}
+
+	get := data[DebugDumpGetAll]
+	for _, secret := range []string{"pvc-1.key", "s3cr3t"} {
+		if strings.Contains(get, secret) {
+			t.Errorf("expected %q to be redacted in %q", secret, get)
+		}
+	}
+	for _, kept := range []string{"compression\tlz4", "keystatus\tavailable", "openebs.io:owner\tnode1"} {
+		if !strings.Contains(get, kept) {
+			t.Errorf("expected %q to be kept in %q", kept, get)
+		}
+	}
+	if !strings.Contains(get, "keylocation\t"+redactedValue+"\tlocal") {
+		t.Errorf("expected the keylocation to be redacted in %q", get)
+	}
+	if data[DebugDumpList] != "zfspv/pvc-1\n" {
+		t.Errorf("unexpected list output %q", data[DebugDumpList])
+	}
+	if _, ok := data[DebugDumpError]; ok {
+		t.Errorf("unexpected error %q", data[DebugDumpError])
+	}
+	if data[DebugDumpTime] == "" {
+		t.Errorf("expected the capture time")
+	}
+}
+
+func TestCaptureDebugDumpError(t *testing.T) {
+	vol := &apis.ZFSVolume{}
+	vol.Name = "pvc-1"
+	vol.Spec.PoolName = "zfspv"
+
+	f := debugDumpZFS(t)
+	f.Fail(t, ZFSListArg, "cannot open 'zfspv/pvc-1': dataset does not exist")
+
+	data := CaptureDebugDump(vol)
+	if _, ok := data[DebugDumpList]; ok {
+		t.Errorf("expected no list output")
+	}
+	if !strings.Contains(data[DebugDumpError], "dataset does not exist") {
+		t.Errorf("expected the list error, got %q", data[DebugDumpError])
+	}
+	if data[DebugDumpGetAll] == "" {
+		t.Errorf("expected the properties despite the list error")
+	}
+}
+
+func TestBoundOutput(t *testing.T) {
+	tests := map[string]struct {
+		out   string
+		limit int
+		want  string
+	}{
+		"under the limit": {out: "a\nb\n", limit: 10, want: "a\nb\n"},
+		"at the limit":    {out: "a\nb\n", limit: 4, want: "a\nb\n"},
+		"line boundary":   {out: "aaa\nbbb\nccc\n", limit: 9, want: "aaa\nbbb\n... truncated 4 bytes\n"},
+		"no newline":      {out: "aaaaaaaa", limit: 3, want: "aaa... truncated 5 bytes\n"},
+	}
+	for name, tt := range tests {
+		if got := boundOutput(tt.out, tt.limit); got != tt.want {
+			t.Errorf("%s: boundOutput() = %q, want %q", name, got, tt.want)
+		}
+	}
+
+	big := strings.Repeat("zfspv/pvc-1@snap\n", debugDumpLimit)
+	if got := boundOutput(big, debugDumpLimit); len(got) > debugDumpLimit+64 {
+		t.Errorf("expected the output to be bounded, got %d bytes", len(got))
+	}
+}
+
+func TestSaveDebugDump(t *testing.T) {
+	vol := &apis.ZFSVolume{}
+	vol.Name = "pvc-1"
+	vol.UID = "uid-1"
+
+	// an existing configmap is replaced
+	old := &corev1.ConfigMap{}
+	old.Name, old.Namespace = "zfs-debug-pvc-1", OpenEBSNamespace
+	old.Data = map[string]string{DebugDumpError: "zfs get failed"}
+	kubeClient := fake.NewSimpleClientset(old)
+
+	if err := SaveDebugDump(kubeClient, vol, map[string]string{DebugDumpList: "zfspv/pvc-1\n"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	saved, err := kubeClient.CoreV1().ConfigMaps(OpenEBSNamespace).Get(context.TODO(), "zfs-debug-pvc-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected the configmap, got %v", err)
+	}
+	if len(saved.Data) != 1 || saved.Data[DebugDumpList] != "zfspv/pvc-1\n" {
+		t.Errorf("unexpected configmap data %v", saved.Data)
+	}
+	if len(saved.OwnerReferences) != 1 || saved.OwnerReferences[0].UID != "uid-1" ||
+		saved.OwnerReferences[0].Kind != "ZFSVolume" {
+		t.Errorf("expected the configmap to be owned by the volume, got %v", saved.OwnerReferences)
+	}
+
+	// a new configmap is created
+	kubeClient = fake.NewSimpleClientset()
+	if err := SaveDebugDump(kubeClient, vol, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err = kubeClient.CoreV1().ConfigMaps(OpenEBSNamespace).Get(context.TODO(), "zfs-debug-pvc-1", metav1.GetOptions{}); err != nil {
+		t.Errorf("expected the configmap to be created, got %v", err)
+	}
+
+	kubeClient.PrependReactor("get", "configmaps", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("forbidden")
+	})
+	if err := SaveDebugDump(kubeClient, vol, nil); err == nil {
+		t.Errorf("expected the write error")
+	}
+}
@@ -0,0 +1,384 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	mnt "github.com/openebs/lib-csi/pkg/mount"
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// DefragmentKey is the ZFSVolume annotation asking the node agent to
+	// rewrite the volume into a new dataset, the volume must not be
+	// published meanwhile
+	DefragmentKey = "openebs.io/defragment"
+
+	// steps of a defragmentation in progress
+	DefragmentStepChecking = "Checking"
+	DefragmentStepCopying  = "Copying"
+	DefragmentStepSwapping = "Swapping"
+
+	// defragSnap is the snapshot of the volume copied into the new dataset
+	defragSnap = "openebs-defrag"
+	// defragSuffix is appended to the dataset name of the volume for the
+	// copy until it replaces the volume
+	defragSuffix = "-defrag"
+	// defragOldSuffix is appended to the dataset name of the volume while
+	// the copy takes its place
+	defragOldSuffix = "-defrag-old"
+)
+
+var (
+	// ErrVolumeAttached is returned when a volume can not be defragmented
+	// as it is mounted or written to on the node
+	ErrVolumeAttached = errors.New("volume is attached")
+
+	// ErrDefragmenting is returned when a volume is published while it is
+	// being defragmented
+	ErrDefragmenting = errors.New("volume is being defragmented")
+)
+
+// volumeMounts returns the paths the volume is mounted at on the node, can
+// be replaced in unit tests
+var volumeMounts = func(vol *apis.ZFSVolume) ([]string, error) {
+	dev, err := GetVolumeDevPath(vol)
+	if err != nil {
+		return nil, err
+	}
+	return mnt.GetMounts(dev)
+}
+
+// sysBlockDir is the sysfs directory of the block devices of the node
+var sysBlockDir = "/sys/class/block"
+
+// blockDeviceUsers returns the devices using the block device dev: the
+// devices stacked on it, e.g. device mapper or md, and the loop devices
+// backed by it, the kubelet sets one up for a raw block volume published
+// to a pod.
+func blockDeviceUsers(dev string) ([]string, error) {
+	holders, err := os.ReadDir(filepath.Join(sysBlockDir, filepath.Base(dev), "holders"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	var users []string
+	for _, h := range holders {
+		users = append(users, h.Name())
+	}
+	devInfo, err := os.Stat(dev)
+	if err != nil {
+		return nil, err
+	}
+	loops, err := filepath.Glob(filepath.Join(sysBlockDir, "loop*", "loop", "backing_file"))
+	if err != nil {
+		return nil, err
+	}
+	for _, loop := range loops {
+		backing, err := os.ReadFile(loop)
+		if err != nil {
+			continue
+		}
+		info, err := os.Stat(strings.TrimSpace(string(backing)))
+		if err != nil {
+			continue
+		}
+		if os.SameFile(info, devInfo) || sameDevice(info, devInfo) {
+			users = append(users, filepath.Base(filepath.Dir(filepath.Dir(loop))))
+		}
+	}
+	return users, nil
+}
+
+// sameDevice tells whether both files are nodes of the same device
+func sameDevice(a, b os.FileInfo) bool {
+	if a.Mode()&os.ModeDevice == 0 || b.Mode()&os.ModeDevice == 0 {
+		return false
+	}
+	sa, ok := a.Sys().(*syscall.Stat_t)
+	if !ok {
+		return false
+	}
+	sb, ok := b.Sys().(*syscall.Stat_t)
+	return ok && sa.Rdev == sb.Rdev
+}
+
+// checkZvolUnused returns ErrVolumeAttached if the zvol is in use on the
+// node besides the mounts. An exclusive open fails while the zvol is
+// claimed, e.g. mounted or held by another device, the other openers are
+// found through the devices stacked on it.
+func checkZvolUnused(vol *apis.ZFSVolume, dataset string) error {
+	dev, err := GetVolumeDevPath(vol)
+	if err != nil {
+		return err
+	}
+	users, err := blockDeviceUsers(dev)
+	if err != nil {
+		return err
+	}
+	if len(users) > 0 {
+		return fmt.Errorf("zfs: %s is used by %s: %w", dataset, strings.Join(users, ", "), ErrVolumeAttached)
+	}
+	f, err := os.OpenFile(dev, os.O_RDONLY|syscall.O_EXCL, 0)
+	if errors.Is(err, syscall.EBUSY) {
+		return fmt.Errorf("zfs: %s is open: %w", dataset, ErrVolumeAttached)
+	}
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// DefragmentRequested returns true if the volume asks to be defragmented
+func DefragmentRequested(vol *apis.ZFSVolume) bool {
+	return vol.Annotations[DefragmentKey] == "true"
+}
+
+// DefragmentationPending tells whether the volume is to be defragmented,
+// either as it asks for it or as the node agent has been restarted in the
+// middle of the defragmentation
+func DefragmentationPending(vol *apis.ZFSVolume) bool {
+	d := vol.Status.Defragmentation
+	return DefragmentRequested(vol) || (d != nil && d.Phase == apis.DefragmentationInProgress)
+}
+
+// CheckDefragmentation returns ErrDefragmenting while the volume is being
+// defragmented, it must not be used in the meantime
+func CheckDefragmentation(vol *apis.ZFSVolume) error {
+	d := vol.Status.Defragmentation
+	if d == nil || d.Phase != apis.DefragmentationInProgress {
+		return nil
+	}
+	return fmt.Errorf("%w: volume %s is at step %s", ErrDefragmenting, vol.Name, d.Step)
+}
+
+// UpdateDefragmentation records the defragmentation status on the latest
+// version of the ZFSVolume
+func UpdateDefragmentation(name string, d apis.Defragmentation) error {
+	vol, err := GetZFSVolume(name)
+	if err != nil {
+		return err
+	}
+	vol.Status.Defragmentation = &d
+	return UpdateVolumeStatus(vol)
+}
+
+// ClearDefragment removes the defragment annotation from the volume
+func ClearDefragment(vol *apis.ZFSVolume) error {
+	vol, err := GetZFSVolume(vol.Name)
+	if err != nil {
+		return err
+	}
+	if _, ok := vol.Annotations[DefragmentKey]; !ok {
+		return nil
+	}
+	delete(vol.Annotations, DefragmentKey)
+	return UpdateVolumeStatus(vol)
+}
+
+// checkDetached returns ErrVolumeAttached if the volume is mounted on the
+// node, or for a zvol if it is in use, e.g. as a raw block device. A
+// process which has the zvol open without claiming it is not seen here,
+// its writes are caught by the written check.
+func checkDetached(vol *apis.ZFSVolume, dataset string) error {
+	mounts, err := volumeMounts(vol)
+	if err != nil {
+		return err
+	}
+	if len(mounts) > 0 {
+		return fmt.Errorf("zfs: %s is mounted at %s: %w", dataset, strings.Join(mounts, ", "), ErrVolumeAttached)
+	}
+	if vol.Spec.VolumeType != VolTypeDataset {
+		return checkZvolUnused(vol, dataset)
+	}
+	mounted, err := GetVolumeProperty(vol, "mounted")
+	if err != nil {
+		return err
+	}
+	if mounted == "yes" {
+		return fmt.Errorf("zfs: %s is mounted: %w", dataset, ErrVolumeAttached)
+	}
+	return nil
+}
+
+// checkUnused returns ErrVolumeAttached if the volume is attached or has
+// been written since the snapshot of the copy was taken
+func checkUnused(vol *apis.ZFSVolume, dataset string) error {
+	if err := checkDetached(vol, dataset); err != nil {
+		return err
+	}
+	written, err := GetVolumeProperty(vol, "written@"+defragSnap)
+	if err != nil {
+		return err
+	}
+	if written != "0" {
+		return fmt.Errorf("zfs: %s has been written during the copy: %w", dataset, ErrVolumeAttached)
+	}
+	return nil
+}
+
+// checkDefragment returns an error if the volume can not be copied into a
+// new dataset now. The snapshots would be lost with the old dataset, and
+// an encrypted volume would be received without its encryption.
+func checkDefragment(vol *apis.ZFSVolume, dataset string) error {
+	if vol.Spec.Encryption != "" && vol.Spec.Encryption != "off" {
+		return fmt.Errorf("zfs: %s is encrypted, it can not be defragmented", vol.Name)
+	}
+	if err := checkDetached(vol, dataset); err != nil {
+		return err
+	}
+	snaps, err := listSnapshots(dataset)
+	if err != nil {
+		return err
+	}
+	for _, s := range snaps {
+		if s != defragSnap {
+			return fmt.Errorf("zfs: %s has snapshots, it can not be defragmented", dataset)
+		}
+	}
+	return nil
+}
+
+// Defragment rewrites the volume into a new dataset with send/receive and
+// swaps the two, the volume then no longer depends on the snapshot it may
+// have been cloned from. The step reached is passed to progress.
+//
+// The volume must be detached, it is checked once more after the copy
+// that nothing has been written to it meanwhile, and again once it has
+// been renamed away for the swap, when it can no longer be mounted or
+// opened by its name, otherwise the copy is dropped and ErrVolumeAttached
+// is returned. The volume is left as it was if the swap fails, and a swap
+// interrupted by a restart of the driver is completed or rolled back by
+// the next call.
+func Defragment(vol *apis.ZFSVolume, progress func(step string)) error {
+	dataset := VolumeDataset(vol)
+	copied := dataset + defragSuffix
+	old := dataset + defragOldSuffix
+	snapshot := dataset + "@" + defragSnap
+
+	progress(DefragmentStepChecking)
+	if datasetExists(old) {
+		done, err := recoverDefragment(dataset, copied, old)
+		if err != nil || done {
+			return err
+		}
+	}
+	if err := checkDefragment(vol, dataset); err != nil {
+		return err
+	}
+	if datasetExists(copied) {
+		// left over from an earlier attempt
+		if err := runIndependence(ZFSDestroyArg, "-r", copied); err != nil {
+			return err
+		}
+	}
+	if datasetExists(snapshot) {
+		if err := runIndependence(ZFSDestroyArg, snapshot); err != nil {
+			return err
+		}
+	}
+	if err := runIndependence(ZFSSnapshotArg, snapshot); err != nil {
+		return err
+	}
+
+	progress(DefragmentStepCopying)
+	if err := copyDataset(snapshot, copied); err != nil {
+		dropIndependence(snapshot, copied)
+		return err
+	}
+	if err := checkUnused(vol, dataset); err != nil {
+		dropIndependence(snapshot, copied)
+		return err
+	}
+
+	progress(DefragmentStepSwapping)
+	// the volume is checked again under its old name
+	oldVol := vol.DeepCopy()
+	oldVol.Spec.DatasetName = datasetName(&vol.Spec, vol.Name) + defragOldSuffix
+	check := func() error { return checkUnused(oldVol, old) }
+	if err := swapDefragment(dataset, copied, old, check); err != nil {
+		// the copy is only dropped once the volume is back in place
+		if datasetExists(dataset) && !datasetExists(old) {
+			dropIndependence(snapshot, copied)
+		}
+		return err
+	}
+	klog.Infof("zfs: volume %s has been defragmented", dataset)
+	return nil
+}
+
+// swapDefragment puts the copy in place of the volume and destroys the
+// volume. check is run once the volume has been renamed away, the volume
+// is renamed back if it fails or if the copy can not take its place.
+func swapDefragment(dataset, copied, old string, check func() error) error {
+	if err := runIndependence("rename", dataset, old); err != nil {
+		return err
+	}
+	err := check()
+	if err == nil {
+		err = runIndependence("rename", copied, dataset)
+	}
+	if err != nil {
+		if rerr := runIndependence("rename", old, dataset); rerr != nil {
+			return fmt.Errorf("zfs: could not swap %s with its copy: %v, nor rename %s back: %v",
+				dataset, err, old, rerr)
+		}
+		return err
+	}
+	return finishDefragment(dataset, old)
+}
+
+// finishDefragment destroys the old volume, which has been replaced by
+// its copy, and the snapshot received with the copy
+func finishDefragment(dataset, old string) error {
+	if err := runIndependence(ZFSDestroyArg, "-r", old); err != nil {
+		return err
+	}
+	if snapshot := dataset + "@" + defragSnap; datasetExists(snapshot) {
+		if err := runIndependence(ZFSDestroyArg, snapshot); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recoverDefragment completes or rolls back a swap which has been
+// interrupted, it returns true if the copy has replaced the volume
+func recoverDefragment(dataset, copied, old string) (bool, error) {
+	switch {
+	case datasetExists(dataset):
+		// the copy is in place, the old volume is still to be destroyed
+		klog.Infof("zfs: completing the defragmentation of %s", dataset)
+		return true, finishDefragment(dataset, old)
+	case datasetExists(copied):
+		// the copy is only received once it is complete
+		klog.Infof("zfs: completing the defragmentation of %s", dataset)
+		if err := runIndependence("rename", copied, dataset); err != nil {
+			return false, err
+		}
+		return true, finishDefragment(dataset, old)
+	default:
+		klog.Warningf("zfs: rolling back the defragmentation of %s", dataset)
+		return false, runIndependence("rename", old, dataset)
+	}
+}
@@ -0,0 +1,356 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"github.com/openebs/zfs-localpv/pkg/zfs/zfstest"
+)
+
+func defragVol() *apis.ZFSVolume {
+	vol := &apis.ZFSVolume{}
+	vol.Name = "pvc-1"
+	vol.Spec.PoolName = "zfspv"
+	vol.Spec.VolumeType = VolTypeDataset
+	return vol
+}
+
+// fakeDefrag holds the datasets of the volume in the fake zfs, the
+// volumes are not mounted
+type fakeDefrag struct {
+	zfs *zfstest.FakeZFS
+	// mounts are the mounts of the volume on the node
+	mounts []string
+	// onCheck is called before the nth check of the mounts, the volume is
+	// checked before the copy, once copied and once renamed for the swap
+	onCheck func(n int)
+	checks  int
+}
+
+func newFakeDefrag(t *testing.T, datasets ...string) *fakeDefrag {
+	if len(datasets) == 0 {
+		datasets = []string{"zfspv/pvc-1"}
+	}
+	f := &fakeDefrag{zfs: newFakeZFS(t, datasets...)}
+	for _, ds := range datasets {
+		if !strings.Contains(ds, "@") {
+			f.zfs.Set(t, ds, "mounted", "no")
+			f.zfs.Set(t, ds, "written@"+defragSnap, "0")
+		}
+	}
+
+	orig := volumeMounts
+	t.Cleanup(func() { volumeMounts = orig })
+	volumeMounts = func(*apis.ZFSVolume) ([]string, error) {
+		f.checks++
+		if f.onCheck != nil {
+			f.onCheck(f.checks)
+		}
+		return f.mounts, nil
+	}
+	return f
+}
+
+func (f *fakeDefrag) defragment(vol *apis.ZFSVolume) ([]string, error) {
+	var steps []string
+	err := Defragment(vol, func(step string) { steps = append(steps, step) })
+	return steps, err
+}
+
+func TestDefragmentSwap(t *testing.T) {
+	f := newFakeDefrag(t)
+
+	steps, err := f.defragment(defragVol())
+	if err != nil {
+		t.Fatalf("Defragment() failed: %v", err)
+	}
+	wantCmds := []string{
+		"snapshot zfspv/pvc-1@openebs-defrag",
+		"send -p zfspv/pvc-1@openebs-defrag",
+		"recv -u zfspv/pvc-1-defrag",
+		"rename zfspv/pvc-1 zfspv/pvc-1-defrag-old",
+		"rename zfspv/pvc-1-defrag zfspv/pvc-1",
+		"destroy -r zfspv/pvc-1-defrag-old",
+		"destroy zfspv/pvc-1@openebs-defrag",
+	}
+	if got := zfsWrites(f.zfs); !reflect.DeepEqual(got, wantCmds) {
+		t.Errorf("Defragment() ran %q, want %q", got, wantCmds)
+	}
+	wantSteps := []string{DefragmentStepChecking, DefragmentStepCopying, DefragmentStepSwapping}
+	if !reflect.DeepEqual(steps, wantSteps) {
+		t.Errorf("Defragment() reported steps %q, want %q", steps, wantSteps)
+	}
+	if got, want := f.zfs.Datasets(), []string{"zfspv/pvc-1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Defragment() left datasets %v, want %v", got, want)
+	}
+}
+
+func TestDefragmentDetached(t *testing.T) {
+	tests := map[string]func(t *testing.T, f *fakeDefrag){
+		"mounted on the node": func(t *testing.T, f *fakeDefrag) { f.mounts = []string{"/var/lib/kubelet/pods/1/mount"} },
+		"mounted dataset":     func(t *testing.T, f *fakeDefrag) { f.zfs.Set(t, "zfspv/pvc-1", "mounted", "yes") },
+	}
+	for name, setup := range tests {
+		t.Run(name, func(t *testing.T) {
+			f := newFakeDefrag(t)
+			setup(t, f)
+
+			if _, err := f.defragment(defragVol()); !errors.Is(err, ErrVolumeAttached) {
+				t.Errorf("Defragment() = %v, want ErrVolumeAttached", err)
+			}
+			if got := zfsWrites(f.zfs); len(got) != 0 {
+				t.Errorf("Defragment() of an attached volume ran %q", got)
+			}
+		})
+	}
+}
+
+func TestDefragmentRefused(t *testing.T) {
+	f := newFakeDefrag(t, "zfspv/pvc-1", "zfspv/pvc-1@snap-1")
+	if _, err := f.defragment(defragVol()); err == nil || len(zfsWrites(f.zfs)) != 0 {
+		t.Errorf("Defragment() of a volume with snapshots = %v, ran %q", err, zfsWrites(f.zfs))
+	}
+
+	f = newFakeDefrag(t)
+	vol := defragVol()
+	vol.Spec.Encryption = "on"
+	if _, err := f.defragment(vol); err == nil || len(zfsWrites(f.zfs)) != 0 {
+		t.Errorf("Defragment() of an encrypted volume = %v, ran %q", err, zfsWrites(f.zfs))
+	}
+}
+
+func TestDefragmentUsedDuringCopy(t *testing.T) {
+	tests := map[string]func(t *testing.T, f *fakeDefrag){
+		"mounted": func(t *testing.T, f *fakeDefrag) { f.mounts = []string{"/var/lib/kubelet/pods/1/mount"} },
+		"written": func(t *testing.T, f *fakeDefrag) { f.zfs.Set(t, "zfspv/pvc-1", "written@"+defragSnap, "4096") },
+	}
+	for name, use := range tests {
+		t.Run(name, func(t *testing.T) {
+			f := newFakeDefrag(t)
+			f.onCheck = func(n int) {
+				if n == 2 {
+					use(t, f)
+				}
+			}
+
+			if _, err := f.defragment(defragVol()); !errors.Is(err, ErrVolumeAttached) {
+				t.Errorf("Defragment() = %v, want ErrVolumeAttached", err)
+			}
+			if got, want := f.zfs.Datasets(), []string{"zfspv/pvc-1"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("Defragment() left datasets %v, want %v", got, want)
+			}
+			for _, cmd := range zfsWrites(f.zfs) {
+				if strings.HasPrefix(cmd, "rename") {
+					t.Errorf("Defragment() swapped a volume used during the copy: %q", zfsWrites(f.zfs))
+				}
+			}
+		})
+	}
+}
+
+func TestDefragmentUsedDuringSwap(t *testing.T) {
+	f := newFakeDefrag(t)
+	f.onCheck = func(n int) {
+		if n == 3 {
+			f.zfs.Set(t, "zfspv/pvc-1"+defragOldSuffix, "written@"+defragSnap, "4096")
+		}
+	}
+
+	if _, err := f.defragment(defragVol()); !errors.Is(err, ErrVolumeAttached) {
+		t.Errorf("Defragment() = %v, want ErrVolumeAttached", err)
+	}
+	wantCmds := []string{
+		"snapshot zfspv/pvc-1@openebs-defrag",
+		"send -p zfspv/pvc-1@openebs-defrag",
+		"recv -u zfspv/pvc-1-defrag",
+		"rename zfspv/pvc-1 zfspv/pvc-1-defrag-old",
+		"rename zfspv/pvc-1-defrag-old zfspv/pvc-1",
+		"destroy -r zfspv/pvc-1-defrag",
+		"destroy zfspv/pvc-1@openebs-defrag",
+	}
+	if got := zfsWrites(f.zfs); !reflect.DeepEqual(got, wantCmds) {
+		t.Errorf("Defragment() ran %q, want %q", got, wantCmds)
+	}
+	if got, want := f.zfs.Datasets(), []string{"zfspv/pvc-1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Defragment() left datasets %v, want %v", got, want)
+	}
+}
+
+func TestBlockDeviceUsers(t *testing.T) {
+	defer func(dir string) { sysBlockDir = dir }(sysBlockDir)
+	sysBlockDir = t.TempDir()
+	dev := filepath.Join(t.TempDir(), "zd0")
+	other := filepath.Join(t.TempDir(), "zd16")
+	for _, f := range []string{dev, other} {
+		if err := os.WriteFile(f, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sysfs := map[string]string{
+		"zd0/holders/dm-0":         "",
+		"loop0/loop/backing_file":  dev + "\n",
+		"loop1/loop/backing_file":  other + "\n",
+		"zd16/holders/placeholder": "",
+	}
+	for name, content := range sysfs {
+		path := filepath.Join(sysBlockDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	users, err := blockDeviceUsers(dev)
+	if err != nil {
+		t.Fatalf("blockDeviceUsers() failed: %v", err)
+	}
+	if want := []string{"dm-0", "loop0"}; !reflect.DeepEqual(users, want) {
+		t.Errorf("blockDeviceUsers() = %q, want %q", users, want)
+	}
+
+	// a zvol without holders nor loop device is not used
+	unused := filepath.Join(t.TempDir(), "zd32")
+	if err := os.WriteFile(unused, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if users, err = blockDeviceUsers(unused); err != nil || len(users) != 0 {
+		t.Errorf("blockDeviceUsers() of an unused zvol = %q, %v", users, err)
+	}
+}
+
+func TestDefragmentRollback(t *testing.T) {
+	tests := map[string]string{
+		"copy":         ZFSRecvArg,
+		"first rename": "rename zfspv/pvc-1 ",
+		"swap":         "rename zfspv/pvc-1-defrag ",
+	}
+	for name, fail := range tests {
+		t.Run(name, func(t *testing.T) {
+			f := newFakeDefrag(t)
+			f.zfs.Fail(t, fail, "cannot "+fail)
+
+			if _, err := f.defragment(defragVol()); err == nil {
+				t.Fatalf("Defragment() did not fail")
+			}
+			// the volume is back in place and the copy is dropped
+			if got, want := f.zfs.Datasets(), []string{"zfspv/pvc-1"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("Defragment() left datasets %v, want %v, ran %q", got, want, zfsWrites(f.zfs))
+			}
+		})
+	}
+}
+
+func TestDefragmentRollbackFailed(t *testing.T) {
+	f := newFakeDefrag(t)
+	f.zfs.Fail(t, "rename zfspv/pvc-1-defrag", "cannot rename")
+
+	if _, err := f.defragment(defragVol()); err == nil {
+		t.Fatalf("Defragment() did not fail")
+	}
+	// neither the old volume nor its copy is destroyed
+	for _, ds := range []string{"zfspv/pvc-1-defrag-old", "zfspv/pvc-1-defrag"} {
+		if !f.zfs.Exists(ds) {
+			t.Errorf("Defragment() dropped %s, left %v", ds, f.zfs.Datasets())
+		}
+	}
+}
+
+func TestDefragmentRecover(t *testing.T) {
+	tests := map[string]struct {
+		datasets []string
+		wantCmds []string
+	}{
+		"copy in place": {
+			datasets: []string{"zfspv/pvc-1", "zfspv/pvc-1@openebs-defrag", "zfspv/pvc-1-defrag-old"},
+			wantCmds: []string{
+				"destroy -r zfspv/pvc-1-defrag-old",
+				"destroy zfspv/pvc-1@openebs-defrag",
+			},
+		},
+		"copy not renamed": {
+			datasets: []string{"zfspv/pvc-1-defrag", "zfspv/pvc-1-defrag@openebs-defrag", "zfspv/pvc-1-defrag-old"},
+			wantCmds: []string{
+				"rename zfspv/pvc-1-defrag zfspv/pvc-1",
+				"destroy -r zfspv/pvc-1-defrag-old",
+				"destroy zfspv/pvc-1@openebs-defrag",
+			},
+		},
+		"copy lost": {
+			datasets: []string{"zfspv/pvc-1-defrag-old", "zfspv/pvc-1-defrag-old@openebs-defrag"},
+			wantCmds: []string{
+				"rename zfspv/pvc-1-defrag-old zfspv/pvc-1",
+				"destroy zfspv/pvc-1@openebs-defrag",
+				"snapshot zfspv/pvc-1@openebs-defrag",
+				"send -p zfspv/pvc-1@openebs-defrag",
+				"recv -u zfspv/pvc-1-defrag",
+				"rename zfspv/pvc-1 zfspv/pvc-1-defrag-old",
+				"rename zfspv/pvc-1-defrag zfspv/pvc-1",
+				"destroy -r zfspv/pvc-1-defrag-old",
+				"destroy zfspv/pvc-1@openebs-defrag",
+			},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			f := newFakeDefrag(t, tt.datasets...)
+
+			if _, err := f.defragment(defragVol()); err != nil {
+				t.Fatalf("Defragment() failed: %v", err)
+			}
+			if got := zfsWrites(f.zfs); !reflect.DeepEqual(got, tt.wantCmds) {
+				t.Errorf("Defragment() ran %q, want %q", got, tt.wantCmds)
+			}
+			if got, want := f.zfs.Datasets(), []string{"zfspv/pvc-1"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("Defragment() left datasets %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestCheckDefragmentation(t *testing.T) {
+	vol := defragVol()
+	if err := CheckDefragmentation(vol); err != nil {
+		t.Errorf("CheckDefragmentation() of a volume never defragmented = %v", err)
+	}
+	vol.Status.Defragmentation = &apis.Defragmentation{Phase: apis.DefragmentationInProgress, Step: DefragmentStepCopying}
+	if err := CheckDefragmentation(vol); !errors.Is(err, ErrDefragmenting) {
+		t.Errorf("CheckDefragmentation() while in progress = %v, want ErrDefragmenting", err)
+	}
+	if !DefragmentationPending(vol) {
+		t.Errorf("DefragmentationPending() of an interrupted defragmentation = false")
+	}
+	vol.Status.Defragmentation.Phase = apis.DefragmentationFailed
+	if err := CheckDefragmentation(vol); err != nil {
+		t.Errorf("CheckDefragmentation() once failed = %v", err)
+	}
+	if DefragmentationPending(vol) {
+		t.Errorf("DefragmentationPending() without the annotation = true")
+	}
+	vol.Annotations = map[string]string{DefragmentKey: "true"}
+	if !DefragmentationPending(vol) {
+		t.Errorf("DefragmentationPending() with the annotation = false")
+	}
+}
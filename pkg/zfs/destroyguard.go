@@ -0,0 +1,170 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"fmt"
+	"strconv"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"github.com/openebs/zfs-localpv/pkg/builder/snapbuilder"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// DestroyConfirmKey is the annotation which confirms the destroy of a
+	// volume or a snapshot held by the destroy guard, it has to be "true"
+	DestroyConfirmKey = "openebs.io/confirm-destroy"
+
+	// ConditionDestroyPaused is the condition type of a volume whose
+	// destroy is held by the destroy guard
+	ConditionDestroyPaused = "DestroyPaused"
+
+	destroyPausedReason = "ConfirmationRequired"
+)
+
+// DestroyGuardThreshold is the size in bytes above which a volume or a
+// snapshot is only destroyed with the confirmation annotation, the guard
+// is off if it is 0
+var DestroyGuardThreshold int64
+
+// snapshotSize returns the referenced size of the snapshot, can be
+// replaced in unit tests
+var snapshotSize = func(snap *apis.ZFSSnapshot) (int64, error) {
+	_, size, err := GetSnapshotInfo(snap)
+	return size, err
+}
+
+// SetDestroyGuard enables the destroy guard with the threshold, e.g. 100Gi
+func SetDestroyGuard(enabled bool, threshold string) error {
+	if !enabled {
+		DestroyGuardThreshold = 0
+		return nil
+	}
+	qty, err := resource.ParseQuantity(threshold)
+	if err != nil || qty.Sign() <= 0 {
+		return fmt.Errorf("invalid destroy guard threshold %q", threshold)
+	}
+	DestroyGuardThreshold = qty.Value()
+	klog.Infof("zfs: destroy guard is on, datasets above %s need the %s annotation", qty.String(), DestroyConfirmKey)
+	return nil
+}
+
+// DestroyPausedError is returned when the destroy is held by the guard
+type DestroyPausedError struct {
+	Dataset   string
+	Size      int64
+	Threshold int64
+}
+
+func (e *DestroyPausedError) Error() string {
+	return fmt.Sprintf("destroy of %s paused, its size %d is above the threshold %d, annotate it with %s=true to confirm",
+		e.Dataset, e.Size, e.Threshold, DestroyConfirmKey)
+}
+
+// destroyConfirmed returns true if the object carries the confirmation
+func destroyConfirmed(annotations map[string]string) bool {
+	return annotations[DestroyConfirmKey] == "true"
+}
+
+// checkDestroyGuard holds the destroy of a dataset above the threshold
+func checkDestroyGuard(dataset string, size int64, annotations map[string]string) error {
+	if DestroyGuardThreshold == 0 || size <= DestroyGuardThreshold {
+		return nil
+	}
+	if destroyConfirmed(annotations) {
+		klog.Warningf("zfs: destroying %s of size %d, confirmed by %s", dataset, size, DestroyConfirmKey)
+		return nil
+	}
+	return &DestroyPausedError{Dataset: dataset, Size: size, Threshold: DestroyGuardThreshold}
+}
+
+// CheckVolumeDestroy returns a DestroyPausedError if the space used by the
+// volume, including its snapshots, is above the threshold and the destroy
+// has not been confirmed. A volume whose size is not known, e.g. it has
+// already been destroyed, is left to DestroyVolume.
+func CheckVolumeDestroy(vol *apis.ZFSVolume) error {
+	if DestroyGuardThreshold == 0 {
+		return nil
+	}
+	val, err := GetVolumeProperty(vol, "used")
+	if err != nil {
+		return nil
+	}
+	used, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		return nil
+	}
+	return checkDestroyGuard(VolumeDataset(vol), used, vol.Annotations)
+}
+
+// CheckSnapshotDestroy returns a DestroyPausedError if the data referenced
+// by the snapshot is above the threshold and the destroy has not been
+// confirmed
+func CheckSnapshotDestroy(snap *apis.ZFSSnapshot) error {
+	if DestroyGuardThreshold == 0 {
+		return nil
+	}
+	size, err := snapshotSize(snap)
+	if err != nil {
+		return nil
+	}
+	return checkDestroyGuard(SnapshotDataset(snap), size, snap.Annotations)
+}
+
+// PauseVolumeDestroy sets the DestroyPaused condition of the volume, it
+// returns false if the condition was already set
+func PauseVolumeDestroy(vol *apis.ZFSVolume, paused *DestroyPausedError) (bool, error) {
+	cond := meta.FindStatusCondition(vol.Status.Conditions, ConditionDestroyPaused)
+	if cond != nil && cond.Status == metav1.ConditionTrue && cond.Message == paused.Error() {
+		return false, nil
+	}
+	meta.SetStatusCondition(&vol.Status.Conditions, metav1.Condition{
+		Type:    ConditionDestroyPaused,
+		Status:  metav1.ConditionTrue,
+		Reason:  destroyPausedReason,
+		Message: paused.Error(),
+	})
+	return true, UpdateVolumeStatus(vol)
+}
+
+// PauseSnapshotDestroy records the paused destroy in the error of the
+// snapshot, it returns false if it was already recorded
+func PauseSnapshotDestroy(snap *apis.ZFSSnapshot, paused *DestroyPausedError) (bool, error) {
+	return recordSnapshotError(snap, paused)
+}
+
+// RefuseSnapshotDestroy records the clones holding the snapshot in its
+// error, it returns false if they were already recorded
+func RefuseSnapshotDestroy(snap *apis.ZFSSnapshot, clones *SnapshotClonesError) (bool, error) {
+	return recordSnapshotError(snap, clones)
+}
+
+// recordSnapshotError sets the error of the snapshot whose destroy is
+// held, it returns false if it was already set
+func recordSnapshotError(snap *apis.ZFSSnapshot, held error) (bool, error) {
+	if snap.Status.Error == held.Error() {
+		return false, nil
+	}
+	newSnap := snap.DeepCopy()
+	newSnap.Status.Error = held.Error()
+	_, err := snapbuilder.NewKubeclient().WithNamespace(OpenEBSNamespace).Update(apiSnapshot(newSnap))
+	return true, err
+}
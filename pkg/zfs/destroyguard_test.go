@@ -0,0 +1,106 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"errors"
+	"testing"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+)
+
+func withDestroyGuard(t *testing.T, threshold string, used string, snapSize int64) {
+	origThreshold, origSize := DestroyGuardThreshold, snapshotSize
+	t.Cleanup(func() { DestroyGuardThreshold, snapshotSize = origThreshold, origSize })
+	if err := SetDestroyGuard(threshold != "", threshold); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	newFakeZFS(t).Set(t, "zfspv/pvc-1", "used", used)
+	snapshotSize = func(*apis.ZFSSnapshot) (int64, error) { return snapSize, nil }
+}
+
+func TestSetDestroyGuard(t *testing.T) {
+	orig := DestroyGuardThreshold
+	defer func() { DestroyGuardThreshold = orig }()
+
+	if err := SetDestroyGuard(true, "1Gi"); err != nil || DestroyGuardThreshold != 1<<30 {
+		t.Errorf("got %d %v", DestroyGuardThreshold, err)
+	}
+	if err := SetDestroyGuard(false, "bad"); err != nil || DestroyGuardThreshold != 0 {
+		t.Errorf("disabled guard: got %d %v", DestroyGuardThreshold, err)
+	}
+	for _, v := range []string{"bad", "0", "-1Gi"} {
+		if err := SetDestroyGuard(true, v); err == nil {
+			t.Errorf("expected error for threshold %q", v)
+		}
+	}
+}
+
+func TestCheckVolumeDestroy(t *testing.T) {
+	vol := &apis.ZFSVolume{}
+	vol.Name = "pvc-1"
+	vol.Spec.PoolName = "zfspv"
+
+	// the guard is off by default
+	withDestroyGuard(t, "", "2147483648", 0)
+	if err := CheckVolumeDestroy(vol); err != nil {
+		t.Errorf("guard off: unexpected error %v", err)
+	}
+
+	withDestroyGuard(t, "1Gi", "1073741824", 0)
+	if err := CheckVolumeDestroy(vol); err != nil {
+		t.Errorf("at the threshold: unexpected error %v", err)
+	}
+
+	withDestroyGuard(t, "1Gi", "2147483648", 0)
+	err := CheckVolumeDestroy(vol)
+	var paused *DestroyPausedError
+	if !errors.As(err, &paused) {
+		t.Fatalf("above the threshold: expected DestroyPausedError, got %v", err)
+	}
+	if paused.Dataset != "zfspv/pvc-1" || paused.Size != 2147483648 || paused.Threshold != 1<<30 {
+		t.Errorf("unexpected paused error %+v", paused)
+	}
+
+	vol.Annotations = map[string]string{DestroyConfirmKey: "yes"}
+	if err := CheckVolumeDestroy(vol); err == nil {
+		t.Errorf("only true confirms the destroy")
+	}
+	vol.Annotations[DestroyConfirmKey] = "true"
+	if err := CheckVolumeDestroy(vol); err != nil {
+		t.Errorf("confirmed: unexpected error %v", err)
+	}
+}
+
+func TestCheckSnapshotDestroy(t *testing.T) {
+	snap := &apis.ZFSSnapshot{}
+	snap.Name = "snap-1"
+	snap.Spec.PoolName = "zfspv"
+	snap.Labels = map[string]string{ZFSVolKey: "pvc-1"}
+
+	withDestroyGuard(t, "1Gi", "0", 1<<31)
+	err := CheckSnapshotDestroy(snap)
+	var paused *DestroyPausedError
+	if !errors.As(err, &paused) || paused.Dataset != "zfspv/pvc-1@snap-1" {
+		t.Fatalf("expected DestroyPausedError for the snapshot, got %v", err)
+	}
+
+	snap.Annotations = map[string]string{DestroyConfirmKey: "true"}
+	if err := CheckSnapshotDestroy(snap); err != nil {
+		t.Errorf("confirmed: unexpected error %v", err)
+	}
+}
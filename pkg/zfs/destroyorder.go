@@ -0,0 +1,75 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+)
+
+const (
+	// DefaultDestroyConcurrency is used when the concurrency is not set
+	DefaultDestroyConcurrency = 2
+
+	// maxDestroyConcurrency bounds the concurrency, the destroys compete
+	// for the same pools
+	maxDestroyConcurrency = 32
+)
+
+// DestroyConcurrency is the number of volumes destroyed at the same time
+var DestroyConcurrency = DefaultDestroyConcurrency
+
+// SetDestroyConcurrency validates and sets how many volumes the node agent
+// destroys at the same time
+func SetDestroyConcurrency(n int) error {
+	if n < 1 || n > maxDestroyConcurrency {
+		return fmt.Errorf("invalid destroy concurrency %d, it should be a number between 1 and %d",
+			n, maxDestroyConcurrency)
+	}
+	DestroyConcurrency = n
+	return nil
+}
+
+// cloneOrigin returns the dataset the clone has been created from, empty
+// if the volume is not a clone. A copy from another zpool does not depend
+// on its source, nor does a clone which has been promoted or replaced by
+// a full copy.
+func cloneOrigin(vol *apis.ZFSVolume) string {
+	if len(vol.Spec.SnapName) == 0 || IsPoolCopy(vol) ||
+		(vol.Status.Origin != nil && vol.Status.Origin.Independent) {
+		return ""
+	}
+	return sourcePool(vol) + "/" + strings.SplitN(vol.Spec.SnapName, "@", 2)[0]
+}
+
+// DependentClones returns the names of the volumes among vols which are
+// clones of a snapshot of vol, sorted. The volume can not be destroyed
+// before them, zfs refuses to destroy a snapshot which has clones.
+func DependentClones(vol *apis.ZFSVolume, vols []*apis.ZFSVolume) []string {
+	dataset := VolumeDataset(vol)
+	var clones []string
+	for _, v := range vols {
+		if v.Name != vol.Name && cloneOrigin(v) == dataset {
+			clones = append(clones, v.Name)
+		}
+	}
+	sort.Strings(clones)
+	return clones
+}
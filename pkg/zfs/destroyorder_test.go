@@ -0,0 +1,76 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"reflect"
+	"testing"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+)
+
+func TestSetDestroyConcurrency(t *testing.T) {
+	defer func(n int) { DestroyConcurrency = n }(DestroyConcurrency)
+
+	for _, n := range []int{1, 8, maxDestroyConcurrency} {
+		if err := SetDestroyConcurrency(n); err != nil || DestroyConcurrency != n {
+			t.Errorf("SetDestroyConcurrency(%d) = %v, concurrency %d", n, err, DestroyConcurrency)
+		}
+	}
+	for _, n := range []int{0, -2, maxDestroyConcurrency + 1} {
+		if err := SetDestroyConcurrency(n); err == nil {
+			t.Errorf("SetDestroyConcurrency(%d) did not fail", n)
+		}
+	}
+}
+
+func TestDependentClones(t *testing.T) {
+	vol := func(name, dataset, snapName string) *apis.ZFSVolume {
+		v := &apis.ZFSVolume{}
+		v.Name = name
+		v.Spec.PoolName = "zfspv"
+		v.Spec.DatasetName = dataset
+		v.Spec.SnapName = snapName
+		return v
+	}
+	origin := vol("pvc-origin", "", "")
+	short := vol("pvc-long", "k8s/pvc-short", "")
+	vols := []*apis.ZFSVolume{
+		origin,
+		vol("pvc-b", "", "pvc-origin@snap-1"),
+		vol("pvc-a", "", "pvc-origin@pvc-a"),
+		vol("pvc-c", "", "pvc-other@snap-1"),
+		vol("pvc-d", "", "k8s/pvc-short@snap-1"),
+		vol("pvc-e", "", ""),
+	}
+
+	if got := DependentClones(origin, vols); !reflect.DeepEqual(got, []string{"pvc-a", "pvc-b"}) {
+		t.Errorf("DependentClones() = %v, want [pvc-a pvc-b]", got)
+	}
+	if got := DependentClones(short, vols); !reflect.DeepEqual(got, []string{"pvc-d"}) {
+		t.Errorf("DependentClones() of a shortened dataset = %v, want [pvc-d]", got)
+	}
+	if got := DependentClones(vols[5], vols); len(got) != 0 {
+		t.Errorf("DependentClones() of a volume without clones = %v", got)
+	}
+
+	// a promoted clone does not depend on the volume anymore
+	vols[1].Status.Origin = &apis.CloneOrigin{Volume: "pvc-origin", Independent: true}
+	if got := DependentClones(origin, vols); !reflect.DeepEqual(got, []string{"pvc-a"}) {
+		t.Errorf("DependentClones() with a promoted clone = %v, want [pvc-a]", got)
+	}
+}
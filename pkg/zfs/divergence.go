@@ -0,0 +1,340 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// ConditionCloneDiverged is the condition type reporting that a clone
+	// has diverged from its origin snapshot beyond the independence
+	// threshold
+	ConditionCloneDiverged = "CloneDiverged"
+
+	// reasons of the CloneDiverged condition
+	divergenceReasonExceeded    = "ThresholdExceeded"
+	divergenceReasonBelow       = "BelowThreshold"
+	divergenceReasonIndependent = "Independent"
+
+	// independenceSnap is the snapshot of the clone copied into the
+	// independent dataset
+	independenceSnap = "openebs-independence"
+	// independentSuffix is appended to the dataset name of the clone for
+	// the independent copy until it replaces the clone
+	independentSuffix = "-independent"
+)
+
+// ErrCloneInUse is returned when a clone can not be made independent as
+// it is mounted or written to on the node
+var ErrCloneInUse = errors.New("clone is in use")
+
+// ParseIndependenceThreshold parses the independence threshold of the
+// storageclass, which is a percent between 0 and 100
+func ParseIndependenceThreshold(val string) (int32, error) {
+	if val == "" {
+		return 0, nil
+	}
+	t, err := strconv.ParseInt(val, 10, 32)
+	if err != nil || t < 0 || t > 100 {
+		return 0, fmt.Errorf("zfs: invalid independence threshold %q, it should be a percent between 0 and 100", val)
+	}
+	return int32(t), nil
+}
+
+// parseCloneUsage parses the origin and referenced values of the zfs get
+// output, the origin is empty if the dataset is not a clone
+func parseCloneUsage(out []byte) (string, int64, error) {
+	fields := strings.Fields(string(out))
+	if len(fields) != 2 {
+		return "", 0, fmt.Errorf("zfs: invalid origin output %q", string(out))
+	}
+	referenced, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return "", 0, fmt.Errorf("zfs: invalid referenced %q", fields[1])
+	}
+	if fields[0] == "-" {
+		return "", referenced, nil
+	}
+	return fields[0], referenced, nil
+}
+
+// getCloneUsage returns the origin of the clone, the bytes written since
+// and the bytes referenced by the clone, the origin is empty if the dataset
+// is not a clone
+func getCloneUsage(dataset string) (string, int64, int64, error) {
+	out, err := zfsCommand(ZFSGetArg, "-pH", "-o", "value", "origin,referenced", dataset).CombinedOutput()
+	if err != nil {
+		return "", 0, 0, fmt.Errorf("zfs get origin of %s failed, %s", dataset, string(out))
+	}
+	origin, referenced, err := parseCloneUsage(out)
+	if err != nil || origin == "" {
+		return "", 0, 0, err
+	}
+	out, err = zfsCommand(ZFSGetArg, "-pH", "-o", "value", "written@"+origin, dataset).CombinedOutput()
+	if err != nil {
+		return "", 0, 0, fmt.Errorf("zfs get written@%s of %s failed, %s", origin, dataset, string(out))
+	}
+	written, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		return "", 0, 0, fmt.Errorf("zfs: invalid written %q of %s", strings.TrimSpace(string(out)), dataset)
+	}
+	return origin, written, referenced, nil
+}
+
+// datasetExists returns true if the dataset or the snapshot exists
+func datasetExists(dataset string) bool {
+	return getVolume(dataset) == nil
+}
+
+// runIndependence runs the zfs command changing the datasets of a copy
+func runIndependence(args ...string) error {
+	out, err := zfsCommand(args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("zfs %s failed, %s", args[0], string(out))
+	}
+	return nil
+}
+
+// copyDataset receives a full copy of the snapshot into the dataset, the
+// copy is a full send, it is bounded like the backups
+func copyDataset(snapshot, dataset string) error {
+	release, err := acquireSendSlot(context.TODO())
+	if err != nil {
+		return err
+	}
+	defer release()
+	cmd := zfsShell() + " " + ZFSSendArg + " -p " + snapshot + " | " +
+		zfsShell() + " " + ZFSRecvArg + " -u " + dataset
+	out, err := exec.Command("bash", "-c", cmd).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("zfs: could not copy %s to %s: %s", snapshot, dataset, string(out))
+	}
+	return nil
+}
+
+// divergencePercent returns the written bytes compared to the referenced
+// ones. Overwritten data is no longer referenced, so written can only
+// exceed referenced once the data has been deleted again.
+func divergencePercent(written, referenced int64) int32 {
+	if written <= 0 || referenced <= 0 {
+		return 0
+	}
+	if written >= referenced {
+		return 100
+	}
+	return int32(written * 100 / referenced)
+}
+
+// ReconcileDivergence updates the CloneDivergence and the CloneDiverged
+// condition of the clone in place. It returns true if the status has
+// changed and the ZFSVolume has to be updated, and whether the clone has
+// to be made independent as per its IndependenceThreshold.
+func ReconcileDivergence(vol *apis.ZFSVolume) (bool, bool, error) {
+	origin, written, referenced, err := getCloneUsage(VolumeDataset(vol))
+	if err != nil {
+		return false, false, err
+	}
+	if origin == "" {
+		return setIndependentStatus(vol), false, nil
+	}
+
+	div := &apis.CloneDivergence{
+		Origin:     origin,
+		Written:    written,
+		Referenced: referenced,
+		Percent:    divergencePercent(written, referenced),
+	}
+	changed := vol.Status.CloneDivergence == nil || *vol.Status.CloneDivergence != *div
+	vol.Status.CloneDivergence = div
+
+	threshold := vol.Spec.IndependenceThreshold
+	if threshold == 0 {
+		return changed, false, nil
+	}
+	exceeded := div.Percent >= threshold
+	cond := metav1.Condition{
+		Type:    ConditionCloneDiverged,
+		Status:  metav1.ConditionFalse,
+		Reason:  divergenceReasonBelow,
+		Message: fmt.Sprintf("%d%% written since %s, the threshold is %d%%", div.Percent, origin, threshold),
+	}
+	if exceeded {
+		cond.Status = metav1.ConditionTrue
+		cond.Reason = divergenceReasonExceeded
+	}
+	if old := meta.FindStatusCondition(vol.Status.Conditions, ConditionCloneDiverged); old == nil ||
+		old.Status != cond.Status || old.Message != cond.Message {
+		meta.SetStatusCondition(&vol.Status.Conditions, cond)
+		changed = true
+	}
+	return changed, exceeded && independenceSupported(vol) == nil, nil
+}
+
+// setIndependentStatus clears the divergence of a volume which is not, or
+// no longer, a clone and returns true if the status has changed
+func setIndependentStatus(vol *apis.ZFSVolume) bool {
+	if vol.Status.CloneDivergence == nil {
+		return false
+	}
+	origin := vol.Status.CloneDivergence.Origin
+	vol.Status.CloneDivergence = nil
+	meta.SetStatusCondition(&vol.Status.Conditions, metav1.Condition{
+		Type:    ConditionCloneDiverged,
+		Status:  metav1.ConditionFalse,
+		Reason:  divergenceReasonIndependent,
+		Message: fmt.Sprintf("the volume no longer depends on %s", origin),
+	})
+	return true
+}
+
+// independenceSupported returns an error if the kind of the clone can not
+// be made independent. A zvol may be used as a raw block device, which can
+// not be seen on the node, and an encrypted dataset would be received
+// without its encryption.
+func independenceSupported(vol *apis.ZFSVolume) error {
+	if vol.Spec.VolumeType != VolTypeDataset {
+		return fmt.Errorf("zfs: %s is a zvol, only datasets are made independent", vol.Name)
+	}
+	if vol.Spec.Encryption != "" && vol.Spec.Encryption != "off" {
+		return fmt.Errorf("zfs: %s is encrypted, it can not be made independent", vol.Name)
+	}
+	return nil
+}
+
+// checkIndependence returns an error if the clone can not be copied into
+// an independent dataset now
+func checkIndependence(vol *apis.ZFSVolume, dataset string) error {
+	if err := independenceSupported(vol); err != nil {
+		return err
+	}
+	mounted, err := GetVolumeProperty(vol, "mounted")
+	if err != nil {
+		return err
+	}
+	if mounted == "yes" {
+		return fmt.Errorf("zfs: %s is mounted: %w", dataset, ErrCloneInUse)
+	}
+	snaps, err := listSnapshots(dataset)
+	if err != nil {
+		return err
+	}
+	for _, s := range snaps {
+		if s != independenceSnap {
+			return fmt.Errorf("zfs: %s has snapshots, it can not be made independent", dataset)
+		}
+	}
+	return nil
+}
+
+// IndependClone replaces the clone with a full copy of it, which does not
+// depend on the origin snapshot any more. The copy is received next to the
+// clone, then the clone is destroyed and the copy is renamed to it.
+//
+// The clone must not be mounted. It is checked once more after the copy
+// that nothing has been written to the clone meanwhile, otherwise the copy
+// is dropped and ErrCloneInUse is returned. The destroy fails anyway if the
+// clone has got busy in between. If the driver restarts after the clone
+// has been destroyed, the next call renames the copy.
+func IndependClone(vol *apis.ZFSVolume) error {
+	dataset := VolumeDataset(vol)
+	copied := dataset + independentSuffix
+	snapshot := dataset + "@" + independenceSnap
+
+	if !datasetExists(dataset) && datasetExists(copied) {
+		return finishIndependence(copied, dataset)
+	}
+	if err := checkIndependence(vol, dataset); err != nil {
+		return err
+	}
+	if datasetExists(copied) {
+		// left over from an earlier attempt
+		if err := runIndependence(ZFSDestroyArg, "-r", copied); err != nil {
+			return err
+		}
+	}
+	if datasetExists(snapshot) {
+		if err := runIndependence(ZFSDestroyArg, snapshot); err != nil {
+			return err
+		}
+	}
+	if err := runIndependence(ZFSSnapshotArg, snapshot); err != nil {
+		return err
+	}
+	if err := copyDataset(snapshot, copied); err != nil {
+		dropIndependence(snapshot, copied)
+		return err
+	}
+
+	mounted, err := GetVolumeProperty(vol, "mounted")
+	if err != nil {
+		dropIndependence(snapshot, copied)
+		return err
+	}
+	written, err := GetVolumeProperty(vol, "written@"+independenceSnap)
+	if err != nil {
+		dropIndependence(snapshot, copied)
+		return err
+	}
+	if mounted == "yes" || written != "0" {
+		dropIndependence(snapshot, copied)
+		return fmt.Errorf("zfs: %s has been used during the copy: %w", dataset, ErrCloneInUse)
+	}
+
+	if err := runIndependence(ZFSDestroyArg, "-r", dataset); err != nil {
+		dropIndependence(snapshot, copied)
+		return err
+	}
+	return finishIndependence(copied, dataset)
+}
+
+// finishIndependence renames the independent copy to the clone dataset
+func finishIndependence(copied, dataset string) error {
+	if err := runIndependence("rename", copied, dataset); err != nil {
+		return err
+	}
+	// the received snapshot is not needed by the copy
+	if snapshot := dataset + "@" + independenceSnap; datasetExists(snapshot) {
+		if err := runIndependence(ZFSDestroyArg, snapshot); err != nil {
+			return err
+		}
+	}
+	klog.Infof("zfs: clone %s is now independent of its origin", dataset)
+	return nil
+}
+
+// dropIndependence removes the partial copy and the snapshot of the clone
+func dropIndependence(snapshot, copied string) {
+	if datasetExists(copied) {
+		if err := runIndependence(ZFSDestroyArg, "-r", copied); err != nil {
+			klog.Errorf("zfs: could not destroy %s: %v", copied, err)
+		}
+	}
+	if err := runIndependence(ZFSDestroyArg, snapshot); err != nil {
+		klog.Errorf("zfs: could not destroy %s: %v", snapshot, err)
+	}
+}
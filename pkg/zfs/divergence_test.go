@@ -0,0 +1,270 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"github.com/openebs/zfs-localpv/pkg/zfs/zfstest"
+	"k8s.io/apimachinery/pkg/api/meta"
+)
+
+func cloneVol(threshold int32) *apis.ZFSVolume {
+	vol := &apis.ZFSVolume{}
+	vol.Name = "pvc-clone"
+	vol.Spec.PoolName = "zfspv"
+	vol.Spec.VolumeType = VolTypeDataset
+	vol.Spec.SnapName = "pvc-golden@snap-1"
+	vol.Spec.IndependenceThreshold = threshold
+	return vol
+}
+
+const (
+	cloneDataset = "zfspv/pvc-clone"
+	goldenSnap   = "zfspv/pvc-golden@snap-1"
+)
+
+// cloneZFS returns the fake zfs holding the clone of the golden snapshot,
+// which is not mounted
+func cloneZFS(t *testing.T, written, referenced int64) *zfstest.FakeZFS {
+	f := newFakeZFS(t, goldenSnap)
+	f.Set(t, cloneDataset, "origin", goldenSnap)
+	f.Set(t, cloneDataset, "mounted", "no")
+	f.Set(t, cloneDataset, "written@"+independenceSnap, "0")
+	setCloneUsage(t, f, written, referenced)
+	return f
+}
+
+// setCloneUsage sets the bytes written to the clone since its origin and
+// the bytes it references
+func setCloneUsage(t *testing.T, f *zfstest.FakeZFS, written, referenced int64) {
+	f.Set(t, cloneDataset, "written@"+goldenSnap, strconv.FormatInt(written, 10))
+	f.Set(t, cloneDataset, "referenced", strconv.FormatInt(referenced, 10))
+}
+
+func TestParseIndependenceThreshold(t *testing.T) {
+	for val, want := range map[string]int32{"": 0, "0": 0, "50": 50, "100": 100} {
+		got, err := ParseIndependenceThreshold(val)
+		if err != nil || got != want {
+			t.Errorf("ParseIndependenceThreshold(%q) = %d, %v, want %d", val, got, err, want)
+		}
+	}
+	for _, val := range []string{"-1", "101", "50%", "half"} {
+		if _, err := ParseIndependenceThreshold(val); err == nil {
+			t.Errorf("ParseIndependenceThreshold(%q) did not fail", val)
+		}
+	}
+}
+
+func TestParseCloneUsage(t *testing.T) {
+	origin, referenced, err := parseCloneUsage([]byte("zfspv/pvc-golden@snap-1\n1048576\n"))
+	if err != nil || origin != "zfspv/pvc-golden@snap-1" || referenced != 1048576 {
+		t.Errorf("parseCloneUsage() = %q, %d, %v", origin, referenced, err)
+	}
+	origin, _, err = parseCloneUsage([]byte("-\n1048576\n"))
+	if err != nil || origin != "" {
+		t.Errorf("parseCloneUsage() of a volume which is not a clone = %q, %v", origin, err)
+	}
+	if _, _, err = parseCloneUsage([]byte("-\n")); err == nil {
+		t.Errorf("parseCloneUsage() of a truncated output did not fail")
+	}
+}
+
+func TestDivergencePercent(t *testing.T) {
+	tests := []struct {
+		written, referenced int64
+		want                int32
+	}{
+		{0, 1000, 0},
+		{250, 1000, 25},
+		{999, 1000, 99},
+		{1500, 1000, 100},
+		{10, 0, 0},
+	}
+	for _, tt := range tests {
+		if got := divergencePercent(tt.written, tt.referenced); got != tt.want {
+			t.Errorf("divergencePercent(%d, %d) = %d, want %d", tt.written, tt.referenced, got, tt.want)
+		}
+	}
+}
+
+func TestReconcileDivergence(t *testing.T) {
+	f := cloneZFS(t, 100, 1000)
+	vol := cloneVol(50)
+
+	changed, independ, err := ReconcileDivergence(vol)
+	if err != nil || !changed || independ {
+		t.Fatalf("ReconcileDivergence() = %v, %v, %v, want true, false", changed, independ, err)
+	}
+	want := apis.CloneDivergence{Origin: goldenSnap, Written: 100, Referenced: 1000, Percent: 10}
+	if !reflect.DeepEqual(*vol.Status.CloneDivergence, want) {
+		t.Errorf("CloneDivergence = %+v, want %+v", *vol.Status.CloneDivergence, want)
+	}
+	if meta.IsStatusConditionTrue(vol.Status.Conditions, ConditionCloneDiverged) {
+		t.Errorf("CloneDiverged condition is true below the threshold")
+	}
+
+	// nothing changed on the node
+	if changed, _, _ = ReconcileDivergence(vol); changed {
+		t.Errorf("ReconcileDivergence() reported a change for the same usage")
+	}
+
+	setCloneUsage(t, f, 600, 1000)
+	changed, independ, err = ReconcileDivergence(vol)
+	if err != nil || !changed || !independ {
+		t.Fatalf("ReconcileDivergence() = %v, %v, %v, want true, true", changed, independ, err)
+	}
+	if !meta.IsStatusConditionTrue(vol.Status.Conditions, ConditionCloneDiverged) {
+		t.Errorf("CloneDiverged condition is not true above the threshold")
+	}
+}
+
+func TestReconcileDivergenceZVol(t *testing.T) {
+	cloneZFS(t, 900, 1000)
+	vol := cloneVol(50)
+	vol.Spec.VolumeType = VolTypeZVol
+
+	_, independ, err := ReconcileDivergence(vol)
+	if err != nil || independ {
+		t.Errorf("ReconcileDivergence() of a zvol = %v, %v, want false", independ, err)
+	}
+	if !meta.IsStatusConditionTrue(vol.Status.Conditions, ConditionCloneDiverged) {
+		t.Errorf("CloneDiverged condition of the zvol is not true above the threshold")
+	}
+}
+
+func TestReconcileDivergenceNoThreshold(t *testing.T) {
+	cloneZFS(t, 900, 1000)
+	vol := cloneVol(0)
+
+	changed, independ, err := ReconcileDivergence(vol)
+	if err != nil || !changed || independ {
+		t.Fatalf("ReconcileDivergence() = %v, %v, %v, want true, false", changed, independ, err)
+	}
+	if vol.Status.CloneDivergence.Percent != 90 {
+		t.Errorf("Percent = %d, want 90", vol.Status.CloneDivergence.Percent)
+	}
+	if len(vol.Status.Conditions) != 0 {
+		t.Errorf("condition set without a threshold: %v", vol.Status.Conditions)
+	}
+}
+
+func TestIndependClone(t *testing.T) {
+	f := cloneZFS(t, 600, 1000)
+	vol := cloneVol(50)
+
+	if _, independ, _ := ReconcileDivergence(vol); !independ {
+		t.Fatalf("clone above the threshold is not made independent")
+	}
+	if err := IndependClone(vol); err != nil {
+		t.Fatalf("IndependClone() failed: %v", err)
+	}
+	want := []string{
+		"snapshot zfspv/pvc-clone@openebs-independence",
+		"send -p zfspv/pvc-clone@openebs-independence",
+		"recv -u zfspv/pvc-clone-independent",
+		"destroy -r zfspv/pvc-clone",
+		"rename zfspv/pvc-clone-independent zfspv/pvc-clone",
+		"destroy zfspv/pvc-clone@openebs-independence",
+	}
+	if got := zfsWrites(f); !reflect.DeepEqual(got, want) {
+		t.Errorf("IndependClone() ran %q, want %q", got, want)
+	}
+	if got, want := f.Datasets(), []string{cloneDataset, goldenSnap}; !reflect.DeepEqual(got, want) {
+		t.Errorf("datasets after IndependClone() = %v, want %v", got, want)
+	}
+
+	// the copy references the data of the clone, without an origin
+	f.Set(t, cloneDataset, "referenced", "1000")
+
+	changed, independ, err := ReconcileDivergence(vol)
+	if err != nil || !changed || independ {
+		t.Fatalf("ReconcileDivergence() after independence = %v, %v, %v", changed, independ, err)
+	}
+	if vol.Status.CloneDivergence != nil {
+		t.Errorf("CloneDivergence is still set after independence")
+	}
+	cond := meta.FindStatusCondition(vol.Status.Conditions, ConditionCloneDiverged)
+	if cond == nil || cond.Reason != divergenceReasonIndependent {
+		t.Errorf("CloneDiverged condition after independence = %v", cond)
+	}
+}
+
+func TestIndependCloneInUse(t *testing.T) {
+	f := cloneZFS(t, 600, 1000)
+	f.Set(t, cloneDataset, "mounted", "yes")
+
+	if err := IndependClone(cloneVol(50)); !errors.Is(err, ErrCloneInUse) {
+		t.Errorf("IndependClone() of a mounted clone = %v, want ErrCloneInUse", err)
+	}
+	if got := zfsWrites(f); len(got) != 0 {
+		t.Errorf("IndependClone() of a mounted clone ran %q", got)
+	}
+
+	// written to while being copied, the written bytes are only read
+	// after the copy
+	f.Set(t, cloneDataset, "mounted", "no")
+	f.Set(t, cloneDataset, "written@"+independenceSnap, "4096")
+	if err := IndependClone(cloneVol(50)); !errors.Is(err, ErrCloneInUse) {
+		t.Errorf("IndependClone() of a clone written during the copy = %v, want ErrCloneInUse", err)
+	}
+	if got, want := f.Datasets(), []string{cloneDataset, goldenSnap}; !reflect.DeepEqual(got, want) {
+		t.Errorf("the copy is not dropped: %v", got)
+	}
+}
+
+func TestIndependCloneRefused(t *testing.T) {
+	f := cloneZFS(t, 600, 1000)
+
+	zvol := cloneVol(50)
+	zvol.Spec.VolumeType = VolTypeZVol
+	encrypted := cloneVol(50)
+	encrypted.Spec.Encryption = "on"
+	for name, vol := range map[string]*apis.ZFSVolume{"zvol": zvol, "encrypted": encrypted} {
+		if err := IndependClone(vol); err == nil {
+			t.Errorf("IndependClone() of the %s did not fail", name)
+		}
+	}
+
+	f.Create(t, cloneDataset+"@daily-1")
+	if err := IndependClone(cloneVol(50)); err == nil {
+		t.Errorf("IndependClone() of a clone with snapshots did not fail")
+	}
+	if got := zfsWrites(f); len(got) != 0 {
+		t.Errorf("refused IndependClone() ran %q", got)
+	}
+}
+
+func TestIndependCloneResume(t *testing.T) {
+	// the driver restarted after the clone has been destroyed
+	f := newFakeZFS(t, "zfspv/pvc-clone-independent", "zfspv/pvc-clone-independent@"+independenceSnap)
+
+	if err := IndependClone(cloneVol(50)); err != nil {
+		t.Fatalf("IndependClone() failed: %v", err)
+	}
+	want := []string{
+		"rename zfspv/pvc-clone-independent zfspv/pvc-clone",
+		"destroy zfspv/pvc-clone@openebs-independence",
+	}
+	if got := zfsWrites(f); !reflect.DeepEqual(got, want) {
+		t.Errorf("IndependClone() ran %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,173 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"fmt"
+	"strconv"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// DriftModeOff does not check the live capacity of the volumes
+	DriftModeOff = "off"
+	// DriftModeReport records the live capacity in the status of the
+	// ZFSVolume along with the CapacityDrift condition
+	DriftModeReport = "report"
+	// DriftModeEnforce sets the capacity of the spec on the volume again
+	DriftModeEnforce = "enforce"
+
+	// ConditionCapacityDrift is the condition type reporting the drift
+	ConditionCapacityDrift = "CapacityDrift"
+
+	// reasons of the CapacityDrift condition
+	driftReasonGrown    = "LiveCapacityLarger"
+	driftReasonShrunk   = "LiveCapacitySmaller"
+	driftReasonInSync   = "InSync"
+	driftReasonUnsafe   = "ShrinkRefused"
+	driftReasonEnforced = "Enforced"
+)
+
+// CapacityDriftMode is what is done when the quota or volsize of a volume
+// has been changed on the node behind the driver
+var CapacityDriftMode = DriftModeReport
+
+// SetCapacityDriftMode validates and sets the capacity drift mode
+func SetCapacityDriftMode(mode string) error {
+	switch mode {
+	case DriftModeOff, DriftModeReport, DriftModeEnforce:
+		CapacityDriftMode = mode
+		return nil
+	}
+	return fmt.Errorf("invalid capacity drift mode %q, it should be one of %s, %s or %s",
+		mode, DriftModeOff, DriftModeReport, DriftModeEnforce)
+}
+
+// capacityProperty returns the property holding the capacity of the volume
+func capacityProperty(vol *apis.ZFSVolume) string {
+	if vol.Spec.VolumeType != VolTypeDataset {
+		return "volsize"
+	}
+	if vol.Spec.QuotaType == "" {
+		return "quota"
+	}
+	return vol.Spec.QuotaType
+}
+
+// driftAction is what the reconcile does for a volume
+type driftAction int
+
+const (
+	driftNone driftAction = iota
+	driftReport
+	driftEnforce
+)
+
+// decideDrift returns what to do for the spec and the live capacity in
+// the given mode along with the reason of the condition. A live quota of
+// 0 means there is no quota, which is larger than any spec.
+//
+// A zvol is never shrunk back to the spec, it would cut off the end of
+// the filesystem on it, the drift is only reported. Note the live size
+// is never written to the spec, a larger spec would be taken for an
+// expansion request.
+func decideDrift(mode, volType string, spec, live int64) (driftAction, string) {
+	larger := live == 0 || live > spec
+	switch {
+	case mode == DriftModeOff || live == spec:
+		return driftNone, driftReasonInSync
+	case mode == DriftModeEnforce && larger && volType != VolTypeDataset:
+		return driftReport, driftReasonUnsafe
+	case mode == DriftModeEnforce:
+		return driftEnforce, driftReasonEnforced
+	case larger:
+		return driftReport, driftReasonGrown
+	}
+	return driftReport, driftReasonShrunk
+}
+
+// ReconcileCapacity compares the live quota or volsize of the volume with
+// the spec and acts as per CapacityDriftMode. The status of vol is
+// updated in place, it returns true if it has changed and the ZFSVolume
+// has to be updated.
+func ReconcileCapacity(vol *apis.ZFSVolume, mode string) (bool, error) {
+	if mode == DriftModeOff {
+		return false, nil
+	}
+	spec, err := strconv.ParseInt(vol.Spec.Capacity, 10, 64)
+	if err != nil {
+		return false, fmt.Errorf("zfs: invalid capacity %q of volume %s", vol.Spec.Capacity, vol.Name)
+	}
+	prop := capacityProperty(vol)
+	val, err := GetVolumeProperty(vol, prop)
+	if err != nil {
+		return false, err
+	}
+	live, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		return false, fmt.Errorf("zfs: invalid %s %q of volume %s", prop, val, vol.Name)
+	}
+
+	action, reason := decideDrift(mode, vol.Spec.VolumeType, spec, live)
+	if action == driftEnforce {
+		klog.Warningf("zfs: %s of volume %s is %d, setting it back to %d", prop, vol.Name, live, spec)
+		if err = setVolumeProperty(vol, prop, vol.Spec.Capacity); err != nil {
+			return false, err
+		}
+		live = spec
+	}
+	return setDriftStatus(vol, prop, reason, spec, live), nil
+}
+
+// setDriftStatus updates the LiveCapacity and the CapacityDrift condition
+// of the volume and returns true if they have changed. The condition is
+// only added once a drift is seen.
+func setDriftStatus(vol *apis.ZFSVolume, prop, reason string, spec, live int64) bool {
+	cond := meta.FindStatusCondition(vol.Status.Conditions, ConditionCapacityDrift)
+	if live == spec {
+		if cond == nil || cond.Status == metav1.ConditionFalse {
+			return false
+		}
+		vol.Status.LiveCapacity = ""
+		meta.SetStatusCondition(&vol.Status.Conditions, metav1.Condition{
+			Type:    ConditionCapacityDrift,
+			Status:  metav1.ConditionFalse,
+			Reason:  reason,
+			Message: fmt.Sprintf("%s matches the capacity %d", prop, spec),
+		})
+		return true
+	}
+
+	liveCapacity := strconv.FormatInt(live, 10)
+	if cond != nil && cond.Status == metav1.ConditionTrue &&
+		cond.Reason == reason && vol.Status.LiveCapacity == liveCapacity {
+		return false
+	}
+	klog.Warningf("zfs: %s of volume %s is %d, the capacity is %d", prop, vol.Name, live, spec)
+	vol.Status.LiveCapacity = liveCapacity
+	meta.SetStatusCondition(&vol.Status.Conditions, metav1.Condition{
+		Type:    ConditionCapacityDrift,
+		Status:  metav1.ConditionTrue,
+		Reason:  reason,
+		Message: fmt.Sprintf("%s is %d on node %s, the capacity is %d", prop, live, NodeID, spec),
+	})
+	return true
+}
@@ -0,0 +1,182 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"strings"
+	"testing"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"github.com/openebs/zfs-localpv/pkg/zfs/zfstest"
+	"k8s.io/apimachinery/pkg/api/meta"
+)
+
+// capacityVolume returns the fake zfs with pvc-1 holding the capacity
+// property
+func capacityVolume(t *testing.T, prop, value string) *zfstest.FakeZFS {
+	f := newFakeZFS(t)
+	f.Set(t, "zfspv/pvc-1", prop, value)
+	return f
+}
+
+// zfsSets counts the zfs set commands run
+func zfsSets(f *zfstest.FakeZFS) int {
+	n := 0
+	for _, cmd := range f.Ran() {
+		if strings.HasPrefix(cmd, ZFSSetArg+" ") {
+			n++
+		}
+	}
+	return n
+}
+
+func driftVol(volType string) *apis.ZFSVolume {
+	vol := &apis.ZFSVolume{}
+	vol.Name = "pvc-1"
+	vol.Spec.PoolName = "zfspv"
+	vol.Spec.VolumeType = volType
+	vol.Spec.Capacity = "1073741824"
+	return vol
+}
+
+func TestDecideDrift(t *testing.T) {
+	const gi = 1073741824
+	tests := map[string]struct {
+		mode, volType string
+		live          int64
+		action        driftAction
+		reason        string
+	}{
+		"in sync":                 {DriftModeEnforce, VolTypeDataset, gi, driftNone, driftReasonInSync},
+		"off":                     {DriftModeOff, VolTypeDataset, 2 * gi, driftNone, driftReasonInSync},
+		"report larger":           {DriftModeReport, VolTypeDataset, 2 * gi, driftReport, driftReasonGrown},
+		"report smaller":          {DriftModeReport, VolTypeZVol, gi / 2, driftReport, driftReasonShrunk},
+		"report no quota":         {DriftModeReport, VolTypeDataset, 0, driftReport, driftReasonGrown},
+		"enforce larger dataset":  {DriftModeEnforce, VolTypeDataset, 2 * gi, driftEnforce, driftReasonEnforced},
+		"enforce smaller dataset": {DriftModeEnforce, VolTypeDataset, gi / 2, driftEnforce, driftReasonEnforced},
+		"enforce smaller zvol":    {DriftModeEnforce, VolTypeZVol, gi / 2, driftEnforce, driftReasonEnforced},
+		"enforce larger zvol":     {DriftModeEnforce, VolTypeZVol, 2 * gi, driftReport, driftReasonUnsafe},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			action, reason := decideDrift(tt.mode, tt.volType, gi, tt.live)
+			if action != tt.action || reason != tt.reason {
+				t.Errorf("decideDrift() = %v, %s, want %v, %s", action, reason, tt.action, tt.reason)
+			}
+		})
+	}
+}
+
+func TestReconcileCapacityReport(t *testing.T) {
+	for name, live := range map[string]string{"larger": "2147483648", "smaller": "536870912"} {
+		t.Run(name, func(t *testing.T) {
+			f := capacityVolume(t, "quota", live)
+			vol := driftVol(VolTypeDataset)
+
+			changed, err := ReconcileCapacity(vol, DriftModeReport)
+			if err != nil || !changed {
+				t.Fatalf("ReconcileCapacity() = %v, %v, want a status change", changed, err)
+			}
+			if vol.Status.LiveCapacity != live {
+				t.Errorf("LiveCapacity = %s, want %s", vol.Status.LiveCapacity, live)
+			}
+			if !meta.IsStatusConditionTrue(vol.Status.Conditions, ConditionCapacityDrift) {
+				t.Errorf("CapacityDrift condition is not true")
+			}
+			// the spec must never follow the live size
+			if vol.Spec.Capacity != "1073741824" || zfsSets(f) != 0 {
+				t.Errorf("report mode changed the capacity, spec %s, %d sets", vol.Spec.Capacity, zfsSets(f))
+			}
+
+			// nothing changes on the next check
+			if changed, _ = ReconcileCapacity(vol, DriftModeReport); changed {
+				t.Errorf("ReconcileCapacity() reported the same drift again")
+			}
+
+			// the drift is cleared once the admin sets it back
+			f.Set(t, "zfspv/pvc-1", "quota", vol.Spec.Capacity)
+			if changed, _ = ReconcileCapacity(vol, DriftModeReport); !changed {
+				t.Errorf("ReconcileCapacity() did not clear the drift")
+			}
+			if vol.Status.LiveCapacity != "" ||
+				!meta.IsStatusConditionFalse(vol.Status.Conditions, ConditionCapacityDrift) {
+				t.Errorf("drift not cleared, status %+v", vol.Status)
+			}
+		})
+	}
+}
+
+func TestReconcileCapacityEnforce(t *testing.T) {
+	for name, live := range map[string]string{"larger": "2147483648", "smaller": "536870912"} {
+		t.Run(name, func(t *testing.T) {
+			f := capacityVolume(t, "refquota", live)
+			vol := driftVol(VolTypeDataset)
+			vol.Spec.QuotaType = "refquota"
+
+			changed, err := ReconcileCapacity(vol, DriftModeEnforce)
+			if err != nil {
+				t.Fatalf("ReconcileCapacity() unexpected error %v", err)
+			}
+			if got := f.Prop("zfspv/pvc-1", "refquota"); got != vol.Spec.Capacity || zfsSets(f) != 1 {
+				t.Errorf("refquota = %s after %d sets, want %s", got, zfsSets(f), vol.Spec.Capacity)
+			}
+			// no condition is added for a drift which has been repaired
+			if changed || len(vol.Status.Conditions) != 0 {
+				t.Errorf("ReconcileCapacity() = %v, conditions %v", changed, vol.Status.Conditions)
+			}
+		})
+	}
+}
+
+func TestReconcileCapacityEnforceZvol(t *testing.T) {
+	// a smaller zvol is grown back to the spec
+	f := capacityVolume(t, "volsize", "536870912")
+	vol := driftVol(VolTypeZVol)
+	if _, err := ReconcileCapacity(vol, DriftModeEnforce); err != nil {
+		t.Fatalf("ReconcileCapacity() unexpected error %v", err)
+	}
+	if got := f.Prop("zfspv/pvc-1", "volsize"); got != vol.Spec.Capacity {
+		t.Errorf("volsize = %s, want %s", got, vol.Spec.Capacity)
+	}
+
+	// a larger zvol is not shrunk, the drift is reported
+	f = capacityVolume(t, "volsize", "2147483648")
+	changed, err := ReconcileCapacity(vol, DriftModeEnforce)
+	if err != nil || !changed {
+		t.Fatalf("ReconcileCapacity() = %v, %v, want a status change", changed, err)
+	}
+	if got := f.Prop("zfspv/pvc-1", "volsize"); zfsSets(f) != 0 || got != "2147483648" {
+		t.Errorf("enforce mode shrunk the zvol to %s", got)
+	}
+	cond := meta.FindStatusCondition(vol.Status.Conditions, ConditionCapacityDrift)
+	if cond == nil || cond.Reason != driftReasonUnsafe {
+		t.Errorf("CapacityDrift condition = %+v, want reason %s", cond, driftReasonUnsafe)
+	}
+}
+
+func TestSetCapacityDriftMode(t *testing.T) {
+	defer func(mode string) { CapacityDriftMode = mode }(CapacityDriftMode)
+
+	if err := SetCapacityDriftMode(DriftModeEnforce); err != nil || CapacityDriftMode != DriftModeEnforce {
+		t.Errorf("SetCapacityDriftMode(enforce) = %v, mode %s", err, CapacityDriftMode)
+	}
+	for _, mode := range []string{"", "repair"} {
+		if err := SetCapacityDriftMode(mode); err == nil {
+			t.Errorf("SetCapacityDriftMode(%q) expected an error", mode)
+		}
+	}
+}
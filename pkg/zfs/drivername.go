@@ -0,0 +1,125 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serror "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// DefaultDriverName is the CSI driver name used when none is configured
+	DefaultDriverName = "zfs.csi.openebs.io"
+	// DefaultTopologyKey is the topology key of the default driver name
+	DefaultTopologyKey = "openebs.io/nodeid"
+	// DefaultTopoNodenameKey is the node name topology key of the default
+	// driver name
+	DefaultTopoNodenameKey = "openebs.io/nodename"
+
+	// DriverConfigMap is the ConfigMap recording the driver name of the
+	// instance in its namespace
+	DriverConfigMap = "zfs-localpv-driver"
+	// driverNameKey is the key of the driver name in the ConfigMap
+	driverNameKey = "driverName"
+)
+
+// DriverName is the CSI driver name of this instance, it is set by
+// SetDriverName
+var DriverName = DefaultDriverName
+
+// TopologyKey returns the node topology key of the driver name. The key of
+// the default name is kept as it is for the existing volumes, the other
+// names get their own key so that the volumes of another instance are not
+// placed on the nodes of this one.
+func TopologyKey(driverName string) string {
+	if driverName == DefaultDriverName {
+		return DefaultTopologyKey
+	}
+	return driverName + "/nodeid"
+}
+
+// TopoNodenameKey returns the node name topology key of the driver name,
+// it is namespaced like the node id key.
+func TopoNodenameKey(driverName string) string {
+	if driverName == DefaultDriverName {
+		return DefaultTopoNodenameKey
+	}
+	return driverName + "/nodename"
+}
+
+// SetDriverName validates and sets the CSI driver name along with the
+// topology keys derived from it. The node id of the node plugin is looked
+// up again as it is labelled with the topology key.
+func SetDriverName(name string) error {
+	if errs := validation.IsDNS1123Subdomain(name); len(errs) != 0 {
+		return fmt.Errorf("invalid driver name %q: %s", name, strings.Join(errs, ", "))
+	}
+	key := TopologyKey(name)
+	DriverName = name
+	ZFSTopoNodenameKey = TopoNodenameKey(name)
+	if key == ZFSTopologyKey {
+		return nil
+	}
+	ZFSTopologyKey = key
+
+	nodename := os.Getenv("OPENEBS_NODE_NAME")
+	if NodeID == "" || nodename == "" {
+		return nil
+	}
+	nodeid, err := GetNodeID(nodename)
+	if err != nil {
+		return err
+	}
+	NodeID = nodeid
+	return nil
+}
+
+// ClaimDriverNamespace records the driver name in the namespace of the
+// instance, or checks the one recorded. The ZFSVolume, ZFSSnapshot and
+// ZFSNode objects are not labelled with the driver name, so the instances
+// of the driver running side by side must each have their own namespace,
+// an instance started in the namespace of another one is refused.
+func ClaimDriverNamespace(cs kubernetes.Interface, name string) error {
+	cms := cs.CoreV1().ConfigMaps(OpenEBSNamespace)
+	cm, err := cms.Get(context.TODO(), DriverConfigMap, metav1.GetOptions{})
+	if k8serror.IsNotFound(err) {
+		cm = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: DriverConfigMap, Namespace: OpenEBSNamespace},
+			Data:       map[string]string{driverNameKey: name},
+		}
+		cm, err = cms.Create(context.TODO(), cm, metav1.CreateOptions{})
+		if k8serror.IsAlreadyExists(err) {
+			cm, err = cms.Get(context.TODO(), DriverConfigMap, metav1.GetOptions{})
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("could not get the driver name of namespace %s: %v", OpenEBSNamespace, err)
+	}
+	if claimed := cm.Data[driverNameKey]; claimed != name {
+		return fmt.Errorf("namespace %s is used by driver %s, each instance of the driver needs its own namespace",
+			OpenEBSNamespace, claimed)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"testing"
+
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestTopologyKey(t *testing.T) {
+	if key := TopologyKey(DefaultDriverName); key != "openebs.io/nodeid" {
+		t.Errorf("TopologyKey() of the default name = %q", key)
+	}
+	if key := TopologyKey("fast.zfs.csi.openebs.io"); key != "fast.zfs.csi.openebs.io/nodeid" {
+		t.Errorf("TopologyKey() = %q, want fast.zfs.csi.openebs.io/nodeid", key)
+	}
+	if key := TopoNodenameKey(DefaultDriverName); key != "openebs.io/nodename" {
+		t.Errorf("TopoNodenameKey() of the default name = %q", key)
+	}
+	if key := TopoNodenameKey("fast.zfs.csi.openebs.io"); key != "fast.zfs.csi.openebs.io/nodename" {
+		t.Errorf("TopoNodenameKey() = %q, want fast.zfs.csi.openebs.io/nodename", key)
+	}
+}
+
+func TestSetDriverName(t *testing.T) {
+	origName, origKey, origNodename := DriverName, ZFSTopologyKey, ZFSTopoNodenameKey
+	t.Cleanup(func() { DriverName, ZFSTopologyKey, ZFSTopoNodenameKey = origName, origKey, origNodename })
+
+	if err := SetDriverName("Fast_ZFS"); err == nil {
+		t.Error("SetDriverName() accepted an invalid name")
+	}
+	if err := SetDriverName("fast.zfs.csi.openebs.io"); err != nil {
+		t.Fatalf("SetDriverName() = %v", err)
+	}
+	if DriverName != "fast.zfs.csi.openebs.io" || ZFSTopologyKey != "fast.zfs.csi.openebs.io/nodeid" ||
+		ZFSTopoNodenameKey != "fast.zfs.csi.openebs.io/nodename" {
+		t.Errorf("driver name %q, topology keys %q %q", DriverName, ZFSTopologyKey, ZFSTopoNodenameKey)
+	}
+	if err := SetDriverName(DefaultDriverName); err != nil || ZFSTopologyKey != DefaultTopologyKey ||
+		ZFSTopoNodenameKey != DefaultTopoNodenameKey {
+		t.Errorf("SetDriverName() of the default name = %v, topology keys %q %q", err, ZFSTopologyKey, ZFSTopoNodenameKey)
+	}
+}
+
+func TestClaimDriverNamespace(t *testing.T) {
+	cs := fake.NewSimpleClientset()
+	if err := ClaimDriverNamespace(cs, DefaultDriverName); err != nil {
+		t.Fatalf("ClaimDriverNamespace() = %v", err)
+	}
+	// the controller and the node plugins of the instance share it
+	if err := ClaimDriverNamespace(cs, DefaultDriverName); err != nil {
+		t.Errorf("ClaimDriverNamespace() of the same driver = %v", err)
+	}
+	if err := ClaimDriverNamespace(cs, "fast.zfs.csi.openebs.io"); err == nil {
+		t.Error("ClaimDriverNamespace() accepted a second driver in the namespace")
+	}
+}
@@ -0,0 +1,209 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serror "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// KeyModePerVolume is the "keymode" storageclass parameter to generate
+	// a unique encryption key for every volume
+	KeyModePerVolume = "per-volume"
+
+	// KeySecretDataKey is the key of the encryption key in the Secret data
+	KeySecretDataKey = "key"
+
+	// keySecretPrefix is prepended to the volume name to name its Secret
+	keySecretPrefix = "zfs-key-"
+
+	// keyLength is the length in bytes of the generated keys
+	keyLength = 32
+)
+
+// ErrKeyLost is returned when the Secret holding the encryption key of a
+// volume is gone, the data of the volume can not be decrypted anymore
+var ErrKeyLost = errors.New("encryption key lost")
+
+// loadKey loads the key of the encryption root, it is passed on stdin
+func loadKey(dataset, key string) error {
+	cmd := zfsCommand("load-key", dataset)
+	cmd.Stdin = strings.NewReader(key)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("zfs load-key %s failed, %s", dataset, string(out))
+	}
+	return nil
+}
+
+// GenerateKey returns a new random key in the hex keyformat
+func GenerateKey() (string, error) {
+	buf := make([]byte, keyLength)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("zfs: could not generate the encryption key: %v", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// EnsureKeySecret returns the Secret holding the encryption key of the
+// volume as namespace/name, the Secret is created in the openebs namespace
+// along with a new key if it does not exist yet. The node agent deletes it
+// once the volume is destroyed.
+func EnsureKeySecret(cs kubernetes.Interface, volName string) (string, error) {
+	return ensureKeySecret(cs, volName, GenerateKey)
+}
+
+// CopyKeySecret returns the Secret holding the encryption key of the clone
+// of a volume with a generated key, as namespace/name. A clone shares the
+// encryption root of its origin, so the key of the source, read from the
+// Secret srcRef, is copied into the Secret of the clone, which keeps
+// working once the source volume and its Secret are gone.
+func CopyKeySecret(cs kubernetes.Interface, srcRef, volName string) (string, error) {
+	return ensureKeySecret(cs, volName, func() (string, error) {
+		return secretKey(cs, srcRef, volName)
+	})
+}
+
+// ensureKeySecret returns the Secret holding the encryption key of the
+// volume, creating it with the key returned by newKey if it does not exist
+func ensureKeySecret(cs kubernetes.Interface, volName string, newKey func() (string, error)) (string, error) {
+	name := keySecretPrefix + volName
+	ref := OpenEBSNamespace + "/" + name
+	secrets := cs.CoreV1().Secrets(OpenEBSNamespace)
+
+	if _, err := secrets.Get(context.TODO(), name, metav1.GetOptions{}); err == nil {
+		return ref, nil
+	} else if !k8serror.IsNotFound(err) {
+		return "", fmt.Errorf("zfs: could not get the key secret %s: %v", ref, err)
+	}
+
+	key, err := newKey()
+	if err != nil {
+		return "", err
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: OpenEBSNamespace,
+			Labels:    map[string]string{ZFSVolKey: volName},
+		},
+		Type:       corev1.SecretTypeOpaque,
+		StringData: map[string]string{KeySecretDataKey: key},
+	}
+	if _, err = secrets.Create(context.TODO(), secret, metav1.CreateOptions{}); err != nil && !k8serror.IsAlreadyExists(err) {
+		return "", fmt.Errorf("zfs: could not create the key secret %s: %v", ref, err)
+	}
+	klog.Infof("zfs: created the encryption key secret %s for volume %s", ref, volName)
+	return ref, nil
+}
+
+// DeleteKeySecret deletes the Secret holding the generated encryption key
+// of the volume, the volume being destroyed
+func DeleteKeySecret(cs kubernetes.Interface, vol *apis.ZFSVolume) error {
+	if vol.Spec.KeySecret == "" {
+		return nil
+	}
+	ns, name, err := splitKeySecret(vol.Spec.KeySecret, vol.Name)
+	if err != nil {
+		return err
+	}
+	err = cs.CoreV1().Secrets(ns).Delete(context.TODO(), name, metav1.DeleteOptions{})
+	if err != nil && !k8serror.IsNotFound(err) {
+		return fmt.Errorf("zfs: could not delete the key secret %s: %v", vol.Spec.KeySecret, err)
+	}
+	return nil
+}
+
+// VolumeKey reads the generated encryption key of the volume from its
+// Secret, to be passed on the stdin of zfs. It is empty if the volume does
+// not have a generated key, ErrKeyLost is returned if the Secret or the key
+// in it is gone.
+func VolumeKey(cs kubernetes.Interface, vol *apis.ZFSVolume) (string, error) {
+	if vol.Spec.KeySecret == "" {
+		return "", nil
+	}
+	return secretKey(cs, vol.Spec.KeySecret, vol.Name)
+}
+
+// splitKeySecret returns the namespace and the name of the Secret ref
+func splitKeySecret(ref, volName string) (string, string, error) {
+	parts := strings.SplitN(ref, "/", 2)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("zfs: invalid key secret %q of volume %s", ref, volName)
+	}
+	return parts[0], parts[1], nil
+}
+
+// secretKey reads the encryption key used by the volume from the Secret
+// ref, it returns ErrKeyLost if the Secret or the key in it is gone
+func secretKey(cs kubernetes.Interface, ref, volName string) (string, error) {
+	ns, name, err := splitKeySecret(ref, volName)
+	if err != nil {
+		return "", err
+	}
+	secret, err := cs.CoreV1().Secrets(ns).Get(context.TODO(), name, metav1.GetOptions{})
+	if k8serror.IsNotFound(err) {
+		return "", fmt.Errorf("zfs: secret %s of volume %s is deleted, the volume can not be decrypted: %w",
+			ref, volName, ErrKeyLost)
+	}
+	if err != nil {
+		return "", fmt.Errorf("zfs: could not get the key secret %s: %v", ref, err)
+	}
+	key := secret.Data[KeySecretDataKey]
+	if len(key) == 0 {
+		return "", fmt.Errorf("zfs: secret %s of volume %s has no %q, the volume can not be decrypted: %w",
+			ref, volName, KeySecretDataKey, ErrKeyLost)
+	}
+	return string(key), nil
+}
+
+// LoadVolumeKey loads the generated encryption key of the volume if it is
+// not loaded yet, e.g. after the node has rebooted. The key is loaded for
+// the encryption root, which is the origin for a clone.
+func LoadVolumeKey(cs kubernetes.Interface, vol *apis.ZFSVolume) error {
+	if vol.Spec.KeySecret == "" {
+		return nil
+	}
+	status, err := GetVolumeProperty(vol, "keystatus")
+	if err != nil {
+		return err
+	}
+	if status == "available" {
+		return nil
+	}
+	key, err := VolumeKey(cs, vol)
+	if err != nil {
+		return err
+	}
+	root, err := GetVolumeProperty(vol, "encryptionroot")
+	if err != nil {
+		return err
+	}
+	return loadKey(root, key)
+}
@@ -0,0 +1,207 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// keySecrets returns a fake clientset which, as the api server, converts
+// the StringData of the secrets into Data on create
+func keySecrets() *fake.Clientset {
+	cs := fake.NewSimpleClientset()
+	cs.PrependReactor("create", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		s := action.(k8stesting.CreateAction).GetObject().(*corev1.Secret)
+		s.Data = map[string][]byte{}
+		for k, v := range s.StringData {
+			s.Data[k] = []byte(v)
+		}
+		s.StringData = nil
+		return false, nil, nil
+	})
+	return cs
+}
+
+// keySecret returns the secret of the ref, nil if it does not exist
+func keySecret(cs *fake.Clientset, ref string) *corev1.Secret {
+	ns, name, _ := splitKeySecret(ref, "")
+	s, err := cs.CoreV1().Secrets(ns).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return nil
+	}
+	return s
+}
+
+func deleteKeySecret(t *testing.T, cs *fake.Clientset, ref string) {
+	ns, name, _ := splitKeySecret(ref, "")
+	if err := cs.CoreV1().Secrets(ns).Delete(context.TODO(), name, metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGenerateKey(t *testing.T) {
+	k1, err := GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey() unexpected error %v", err)
+	}
+	k2, _ := GenerateKey()
+	if len(k1) != 2*keyLength {
+		t.Errorf("GenerateKey() length = %d, want %d", len(k1), 2*keyLength)
+	}
+	if k1 == k2 {
+		t.Errorf("GenerateKey() returned the same key twice")
+	}
+}
+
+func TestEnsureKeySecret(t *testing.T) {
+	cs := keySecrets()
+
+	ref, err := EnsureKeySecret(cs, "pvc-1")
+	if err != nil {
+		t.Fatalf("EnsureKeySecret() unexpected error %v", err)
+	}
+	if want := OpenEBSNamespace + "/zfs-key-pvc-1"; ref != want {
+		t.Fatalf("EnsureKeySecret() = %s, want %s", ref, want)
+	}
+	secret := keySecret(cs, ref)
+	if secret == nil || secret.Labels[ZFSVolKey] != "pvc-1" {
+		t.Fatalf("EnsureKeySecret() created %v, want the secret of pvc-1", secret)
+	}
+
+	// the key must not change once generated
+	key := string(secret.Data[KeySecretDataKey])
+	if _, err = EnsureKeySecret(cs, "pvc-1"); err != nil {
+		t.Fatalf("EnsureKeySecret() unexpected error %v", err)
+	}
+
+	vol := &apis.ZFSVolume{}
+	vol.Name = "pvc-1"
+	vol.Spec.KeySecret = ref
+	got, err := VolumeKey(cs, vol)
+	if err != nil {
+		t.Fatalf("VolumeKey() unexpected error %v", err)
+	}
+	if got != key {
+		t.Errorf("VolumeKey() did not return the generated key")
+	}
+
+	// the secret goes away with the volume
+	if err = DeleteKeySecret(cs, vol); err != nil || keySecret(cs, ref) != nil {
+		t.Errorf("DeleteKeySecret() = %v, want the secret deleted", err)
+	}
+	if err = DeleteKeySecret(cs, vol); err != nil {
+		t.Errorf("DeleteKeySecret() of a deleted secret = %v", err)
+	}
+
+	vol.Spec.KeySecret = ""
+	if got, _ = VolumeKey(cs, vol); got != "" {
+		t.Errorf("VolumeKey() without a key secret should be empty")
+	}
+}
+
+func TestCopyKeySecret(t *testing.T) {
+	cs := keySecrets()
+	src, _ := EnsureKeySecret(cs, "pvc-1")
+
+	ref, err := CopyKeySecret(cs, src, "pvc-2")
+	if err != nil {
+		t.Fatalf("CopyKeySecret() unexpected error %v", err)
+	}
+	if want := OpenEBSNamespace + "/zfs-key-pvc-2"; ref != want {
+		t.Fatalf("CopyKeySecret() = %s, want %s", ref, want)
+	}
+	// the clone shares the encryption root of its origin
+	if string(keySecret(cs, ref).Data[KeySecretDataKey]) != string(keySecret(cs, src).Data[KeySecretDataKey]) {
+		t.Errorf("CopyKeySecret() did not copy the key of the source")
+	}
+
+	// the clone keeps its key once the source volume is gone
+	deleteKeySecret(t, cs, src)
+	vol := &apis.ZFSVolume{}
+	vol.Name = "pvc-2"
+	vol.Spec.KeySecret = ref
+	if _, err = VolumeKey(cs, vol); err != nil {
+		t.Errorf("VolumeKey() of the clone = %v", err)
+	}
+
+	if _, err = CopyKeySecret(cs, src, "pvc-3"); !errors.Is(err, ErrKeyLost) {
+		t.Errorf("CopyKeySecret() without the source secret = %v, want ErrKeyLost", err)
+	}
+}
+
+func TestLoadVolumeKey(t *testing.T) {
+	cs := keySecrets()
+	ref, _ := EnsureKeySecret(cs, "pvc-1")
+
+	z := newFakeZFS(t)
+	z.Set(t, "zfspv/pvc-1", "keystatus", "unavailable")
+	z.Set(t, "zfspv/pvc-1", "encryptionroot", "zfspv/pvc-1")
+	loaded := func() bool {
+		for _, cmd := range z.Ran() {
+			if cmd == "load-key zfspv/pvc-1" {
+				return true
+			}
+		}
+		return false
+	}
+
+	vol := &apis.ZFSVolume{}
+	vol.Name = "pvc-1"
+	vol.Spec.PoolName = "zfspv"
+	vol.Spec.KeySecret = ref
+
+	if err := LoadVolumeKey(cs, vol); err != nil {
+		t.Fatalf("LoadVolumeKey() unexpected error %v", err)
+	}
+	if !loaded() {
+		t.Errorf("LoadVolumeKey() did not load the key of zfspv/pvc-1, ran %q", z.Ran())
+	}
+
+	// nothing to do once the key is available
+	z = newFakeZFS(t)
+	z.Set(t, "zfspv/pvc-1", "keystatus", "available")
+	if err := LoadVolumeKey(cs, vol); err != nil || loaded() {
+		t.Errorf("LoadVolumeKey() with available key = %v, ran %q", err, z.Ran())
+	}
+
+	// the secret has lost its key
+	z.Set(t, "zfspv/pvc-1", "keystatus", "unavailable")
+	z.Set(t, "zfspv/pvc-1", "encryptionroot", "zfspv/pvc-1")
+	secret := keySecret(cs, ref)
+	secret.Data = nil
+	if _, err := cs.CoreV1().Secrets(OpenEBSNamespace).Update(context.TODO(), secret, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadVolumeKey(cs, vol); !errors.Is(err, ErrKeyLost) {
+		t.Errorf("LoadVolumeKey() without key = %v, want ErrKeyLost", err)
+	}
+
+	// the secret is deleted
+	deleteKeySecret(t, cs, ref)
+	if err := LoadVolumeKey(cs, vol); !errors.Is(err, ErrKeyLost) {
+		t.Errorf("LoadVolumeKey() without secret = %v, want ErrKeyLost", err)
+	}
+}
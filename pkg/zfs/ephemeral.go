@@ -0,0 +1,49 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+)
+
+const (
+	// EphemeralKey is the label on the ZFSVolume of an ephemeral inline
+	// volume, the dataset is destroyed along with the pod
+	EphemeralKey string = "openebs.io/ephemeral"
+
+	// EphemeralContextKey is set by the kubelet in the volume context of
+	// NodePublishVolume for an ephemeral inline volume
+	EphemeralContextKey string = "csi.storage.k8s.io/ephemeral"
+)
+
+// IsEphemeral returns true if the volume is an ephemeral inline volume
+func IsEphemeral(vol *apis.ZFSVolume) bool {
+	return vol.Labels[EphemeralKey] == "true"
+}
+
+// GetPoolAvailable returns the space in bytes available in the pool
+func GetPoolAvailable(pool string) (int64, error) {
+	out, err := zfsCommand(ZFSGetArg, "-Hp", "-o", "value", "available", pool).CombinedOutput()
+	if err != nil {
+		return 0, fmt.Errorf("zfs: could not get available space of pool %s: %s", pool, strings.TrimSpace(string(out)))
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// FenceProp is the user property of a zvol recording the node which
+	// holds it, along with the time the lease has been renewed at, as
+	// <node>@<unix seconds>
+	FenceProp string = "openebs.io:fence"
+
+	// FenceOverrideKey is the annotation on the ZFSVolume to take over a
+	// zvol even if another node holds a fresh lease on it
+	FenceOverrideKey string = "openebs.io/fence-override"
+
+	// DefaultFenceLease is used when the lease duration is not set
+	DefaultFenceLease = 5 * time.Minute
+)
+
+// FenceLease is the time a lease on a zvol stays fresh once it has been
+// renewed, 0 disables the fencing
+var FenceLease = DefaultFenceLease
+
+// fenceNow is the clock the leases are stamped with
+var fenceNow = time.Now
+
+// setVolumeProperty sets a single property on the volume dataset
+func setVolumeProperty(vol *apis.ZFSVolume, prop, value string) error {
+	volume := VolumeDataset(vol)
+	out, err := zfsCommand(ZFSSetArg, prop+"="+value, volume).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("zfs set %s failed, %s", prop, string(out))
+	}
+	return nil
+}
+
+// clearVolumeProperty makes the volume inherit the property again
+func clearVolumeProperty(vol *apis.ZFSVolume, prop string) error {
+	volume := VolumeDataset(vol)
+	out, err := zfsCommand("inherit", prop, volume).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("zfs inherit %s failed, %s", prop, string(out))
+	}
+	return nil
+}
+
+// SetFenceLease validates and sets the time a lease on a zvol stays fresh
+func SetFenceLease(d time.Duration) error {
+	if d < 0 {
+		return fmt.Errorf("invalid fence lease duration %v, it should be a positive duration", d)
+	}
+	FenceLease = d
+	return nil
+}
+
+// fenceMarker returns the value of the fence property for the node
+func fenceMarker(node string, at time.Time) string {
+	return node + "@" + strconv.FormatInt(at.Unix(), 10)
+}
+
+// parseFenceMarker returns the node holding the zvol and the time the
+// lease has been renewed at, ok is false if the zvol is not held
+func parseFenceMarker(val string) (node string, at time.Time, ok bool) {
+	i := strings.LastIndex(val, "@")
+	if i <= 0 {
+		return "", time.Time{}, false
+	}
+	sec, err := strconv.ParseInt(val[i+1:], 10, 64)
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	return val[:i], time.Unix(sec, 0), true
+}
+
+// checkFence returns an error if another node holds a fresh lease on
+// the zvol and the take over has not been forced
+func checkFence(marker, node string, now time.Time, lease time.Duration, force bool) error {
+	holder, at, ok := parseFenceMarker(marker)
+	if !ok || holder == node || now.Sub(at) >= lease {
+		return nil
+	}
+	if force {
+		klog.Warningf("zfs: taking over the zvol from node %s, its lease is fresh but %s is set", holder, FenceOverrideKey)
+		return nil
+	}
+	return fmt.Errorf("zvol is held by node %s since %s, set the %s annotation on the ZFSVolume to take it over",
+		holder, at.UTC().Format(time.RFC3339), FenceOverrideKey)
+}
+
+// AcquireFence takes or renews the lease of this node on the zvol of the
+// volume. It fails if another node holds a fresh lease, unless the
+// ZFSVolume has the fence override annotation set to true.
+func AcquireFence(vol *apis.ZFSVolume) error {
+	if FenceLease <= 0 || vol.Spec.VolumeType == VolTypeDataset {
+		return nil
+	}
+	marker, err := GetVolumeProperty(vol, FenceProp)
+	if err != nil {
+		return err
+	}
+	force := vol.Annotations[FenceOverrideKey] == "true"
+	now := fenceNow()
+	if err = checkFence(marker, NodeID, now, FenceLease, force); err != nil {
+		return fmt.Errorf("zfs: can not use volume %s: %v", vol.Name, err)
+	}
+	return setVolumeProperty(vol, FenceProp, fenceMarker(NodeID, now))
+}
+
+// ReleaseFence drops the lease of this node on the zvol of the volume, a
+// lease held by another node is left as it is
+func ReleaseFence(vol *apis.ZFSVolume) error {
+	if FenceLease <= 0 || vol.Spec.VolumeType == VolTypeDataset {
+		return nil
+	}
+	marker, err := GetVolumeProperty(vol, FenceProp)
+	if err != nil {
+		return err
+	}
+	if holder, _, ok := parseFenceMarker(marker); !ok || holder != NodeID {
+		return nil
+	}
+	return clearVolumeProperty(vol, FenceProp)
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"testing"
+	"time"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"github.com/openebs/zfs-localpv/pkg/zfs/zfstest"
+)
+
+// fakeFence is the fake zfs holding the zvol pvc-1, fenced with the
+// marker unless it is -
+type fakeFence struct {
+	zfs *zfstest.FakeZFS
+}
+
+func newFakeFence(t *testing.T, marker string, now time.Time) *fakeFence {
+	f := &fakeFence{zfs: newFakeZFS(t, "zfspv/pvc-1")}
+	if marker != "-" {
+		f.zfs.Set(t, "zfspv/pvc-1", FenceProp, marker)
+	}
+	origNow := fenceNow
+	t.Cleanup(func() { fenceNow = origNow })
+	fenceNow = func() time.Time { return now }
+	return f
+}
+
+// marker returns the fence property of the zvol
+func (f *fakeFence) marker() string {
+	return f.zfs.Prop("zfspv/pvc-1", FenceProp)
+}
+
+func fencedVol() *apis.ZFSVolume {
+	vol := &apis.ZFSVolume{}
+	vol.Name = "pvc-1"
+	vol.Spec.PoolName = "zfspv"
+	vol.Spec.VolumeType = VolTypeZVol
+	return vol
+}
+
+func TestAcquireFence(t *testing.T) {
+	defer func(id string) { NodeID = id }(NodeID)
+	now := time.Unix(1682935200, 0)
+	f := newFakeFence(t, "-", now)
+	vol := fencedVol()
+
+	NodeID = "node1"
+	if err := AcquireFence(vol); err != nil {
+		t.Fatalf("AcquireFence() unexpected error %v", err)
+	}
+	if f.marker() != "node1@1682935200" {
+		t.Fatalf("AcquireFence() marker = %q", f.marker())
+	}
+
+	// the second node is fenced off while the lease is fresh
+	NodeID = "node2"
+	fenceNow = func() time.Time { return now.Add(FenceLease - time.Second) }
+	if err := AcquireFence(vol); err == nil {
+		t.Errorf("AcquireFence() expected error, node1 holds a fresh lease")
+	}
+	if f.marker() != "node1@1682935200" {
+		t.Errorf("AcquireFence() changed the marker of node1 to %q", f.marker())
+	}
+
+	// the release by another node keeps the lease
+	if err := ReleaseFence(vol); err != nil || f.marker() != "node1@1682935200" {
+		t.Errorf("ReleaseFence() = %v, marker %q", err, f.marker())
+	}
+
+	// the override takes over the fresh lease
+	vol.Annotations = map[string]string{FenceOverrideKey: "true"}
+	if err := AcquireFence(vol); err != nil {
+		t.Errorf("AcquireFence() unexpected error with override %v", err)
+	}
+	if f.marker() != fenceMarker("node2", fenceNow()) {
+		t.Errorf("AcquireFence() marker = %q, want held by node2", f.marker())
+	}
+	vol.Annotations = nil
+
+	// a stale lease is taken over
+	NodeID = "node1"
+	fenceNow = func() time.Time { return now.Add(2 * FenceLease) }
+	if err := AcquireFence(vol); err != nil {
+		t.Errorf("AcquireFence() unexpected error for a stale lease %v", err)
+	}
+
+	if err := ReleaseFence(vol); err != nil || f.marker() != "-" {
+		t.Errorf("ReleaseFence() = %v, marker %q", err, f.marker())
+	}
+}
+
+func TestAcquireFenceSkipped(t *testing.T) {
+	f := newFakeFence(t, "other@1", time.Unix(2, 0))
+
+	vol := fencedVol()
+	vol.Spec.VolumeType = VolTypeDataset
+	if err := AcquireFence(vol); err != nil || f.marker() != "other@1" {
+		t.Errorf("AcquireFence() = %v, datasets are not fenced", err)
+	}
+
+	defer func(d time.Duration) { FenceLease = d }(FenceLease)
+	FenceLease = 0
+	if err := AcquireFence(fencedVol()); err != nil || f.marker() != "other@1" {
+		t.Errorf("AcquireFence() = %v, fencing is disabled", err)
+	}
+}
+
+func TestParseFenceMarker(t *testing.T) {
+	if node, at, ok := parseFenceMarker("node@a@1682935200"); !ok || node != "node@a" || at.Unix() != 1682935200 {
+		t.Errorf("parseFenceMarker() = %q, %v, %v", node, at, ok)
+	}
+	for _, val := range []string{"-", "", "node1", "@123", "node1@now"} {
+		if _, _, ok := parseFenceMarker(val); ok {
+			t.Errorf("parseFenceMarker(%q) expected no holder", val)
+		}
+	}
+	if err := SetFenceLease(-time.Minute); err == nil {
+		t.Errorf("SetFenceLease(-1m) expected error")
+	}
+}
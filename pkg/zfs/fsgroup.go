@@ -0,0 +1,157 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"k8s.io/klog/v2"
+)
+
+// the ways the fsGroup of the pods is applied to the filesystem volumes
+const (
+	// FsGroupKubelet leaves the fsGroup to the kubelet, which changes the
+	// group of the volume as per the fsGroupPolicy of the CSIDriver and
+	// the fsGroupChangePolicy of the pod
+	FsGroupKubelet = "kubelet"
+	// FsGroupOnRootMismatch changes the group of all the files of the
+	// volume only if its root does not have the fsGroup already
+	FsGroupOnRootMismatch = "onrootmismatch"
+	// FsGroupRecursive changes the group of all the files of the volume
+	// each time it is published, as the kubelet does by default
+	FsGroupRecursive = "recursive"
+	// FsGroupRootOnly only changes the group of the root of the volume,
+	// the application takes care of the permissions of its files
+	FsGroupRootOnly = "rootonly"
+)
+
+// the group permissions set by the fsGroup, as done by the kubelet: the
+// files are readable and writable by the group, the directories are also
+// searchable and their new files get the group
+const (
+	fsGroupFileMode = 0660
+	fsGroupDirMode  = 0770 | os.ModeSetgid
+)
+
+// ValidateFsGroupMode validates how the fsGroup of the pods is applied to
+// the filesystem volumes
+func ValidateFsGroupMode(mode string) error {
+	switch mode {
+	case FsGroupKubelet, FsGroupOnRootMismatch, FsGroupRecursive, FsGroupRootOnly:
+		return nil
+	}
+	return fmt.Errorf("invalid fsgroup mode %q, it should be one of %s, %s, %s or %s",
+		mode, FsGroupKubelet, FsGroupOnRootMismatch, FsGroupRecursive, FsGroupRootOnly)
+}
+
+// FsGroupDelegated tells whether the node plugin applies the fsGroup
+// itself with the mode. It then has the VOLUME_MOUNT_GROUP capability, the kubelet
+// passes the fsGroup as the volume mount group and does not change the
+// group of the volume anymore.
+func FsGroupDelegated(mode string) bool {
+	return mode != FsGroupKubelet
+}
+
+// fsGroupPerm returns the mode of the file with the group permissions of
+// the fsGroup
+func fsGroupPerm(info os.FileInfo) os.FileMode {
+	mode := info.Mode() & (os.ModePerm | os.ModeSetgid | os.ModeSetuid | os.ModeSticky)
+	if info.IsDir() {
+		return mode | fsGroupDirMode
+	}
+	return mode | fsGroupFileMode
+}
+
+// hasFsGroup tells whether the file already has the group and its
+// permissions
+func hasFsGroup(info os.FileInfo, gid int) bool {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || int(st.Gid) != gid {
+		return false
+	}
+	return info.Mode()&(os.ModePerm|os.ModeSetgid) == fsGroupPerm(info)&(os.ModePerm|os.ModeSetgid)
+}
+
+// setFsGroup changes the group of the file and adds the group permissions,
+// the symlinks are not followed and keep their mode
+func setFsGroup(path string, info os.FileInfo, gid int) error {
+	if hasFsGroup(info, gid) {
+		return nil
+	}
+	if err := os.Lchown(path, -1, gid); err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		return nil
+	}
+	return os.Chmod(path, fsGroupPerm(info))
+}
+
+// ApplyFsGroup applies the fsGroup of the pod, passed by the kubelet as
+// the volume mount group, to the filesystem volume mounted as per mnt as
+// per the fsgroup mode. With onrootmismatch, only the root is checked when it
+// already has the group, so that a dataset with many files is not walked
+// each time it is published. Nothing is done for a read-only volume, one
+// mounted read-only or when the kubelet applies the fsGroup.
+func ApplyFsGroup(vol *apis.ZFSVolume, mnt *MountInfo, mode, mountGroup string) error {
+	if mountGroup == "" || !FsGroupDelegated(mode) || readOnlyMount(vol, mnt) {
+		return nil
+	}
+	path := mnt.MountPath
+	gid, err := parseRootID("fsGroup", mountGroup)
+	if err != nil {
+		return err
+	}
+
+	root, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("zfs: could not stat %s: %v", path, err)
+	}
+	switch mode {
+	case FsGroupRootOnly:
+		if err = setFsGroup(path, root, gid); err != nil {
+			return fmt.Errorf("zfs: could not set the group of %s to %d: %v", path, gid, err)
+		}
+		return nil
+	case FsGroupOnRootMismatch:
+		if hasFsGroup(root, gid) {
+			klog.V(4).Infof("zfs: root of volume %s already has group %d, skipping the fsGroup change", vol.Name, gid)
+			return nil
+		}
+	}
+
+	// the root is changed last, an interrupted change is then done again
+	// on the next publish with onrootmismatch
+	klog.Infof("zfs: changing the group of the files of volume %s to %d", vol.Name, gid)
+	err = filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil || p == path {
+			return err
+		}
+		return setFsGroup(p, info, gid)
+	})
+	if err == nil {
+		err = setFsGroup(path, root, gid)
+	}
+	if err != nil {
+		return fmt.Errorf("zfs: could not change the group of the files of %s to %d: %v", path, gid, err)
+	}
+	return nil
+}
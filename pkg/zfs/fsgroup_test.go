@@ -0,0 +1,153 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+)
+
+// fsGroupVolume returns a volume with a directory and a file below its
+// root, all with the group of the test. The root and the file have lost
+// the group permissions, the directory has them.
+func fsGroupVolume(t *testing.T) (string, string) {
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "data"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "data", "db"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	for p, perm := range map[string]os.FileMode{
+		root:                              0700,
+		filepath.Join(root, "data"):       0770 | os.ModeSetgid,
+		filepath.Join(root, "data", "db"): 0600,
+	} {
+		if err := os.Chmod(p, perm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root, strconv.Itoa(os.Getegid())
+}
+
+// checkPerm fails the test if the file does not have the permissions
+func checkPerm(t *testing.T, path string, want os.FileMode) {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := info.Mode() & (os.ModePerm | os.ModeSetgid); got != want {
+		t.Errorf("%s has mode %v, want %v", path, got, want)
+	}
+}
+
+func TestValidateFsGroupMode(t *testing.T) {
+	for _, mode := range []string{FsGroupOnRootMismatch, FsGroupRecursive, FsGroupRootOnly} {
+		if err := ValidateFsGroupMode(mode); err != nil || !FsGroupDelegated(mode) {
+			t.Errorf("expected mode %s to delegate the fsGroup, got %v", mode, err)
+		}
+	}
+	if err := ValidateFsGroupMode(FsGroupKubelet); err != nil || FsGroupDelegated(FsGroupKubelet) {
+		t.Errorf("expected mode kubelet not to delegate the fsGroup, got %v", err)
+	}
+	if err := ValidateFsGroupMode("always"); err == nil {
+		t.Errorf("expected an unknown mode to be refused")
+	}
+}
+
+func TestApplyFsGroupOnRootMismatch(t *testing.T) {
+	root, gid := fsGroupVolume(t)
+	db := filepath.Join(root, "data", "db")
+
+	// the root misses the group permissions, all the files are changed
+	if err := ApplyFsGroup(&apis.ZFSVolume{}, &MountInfo{MountPath: root}, FsGroupOnRootMismatch, gid); err != nil {
+		t.Fatalf("ApplyFsGroup: %v", err)
+	}
+	checkPerm(t, root, 0770|os.ModeSetgid)
+	checkPerm(t, db, 0660)
+
+	// the root has them now, the files are not walked again even if one
+	// of them has lost them
+	if err := os.Chmod(db, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ApplyFsGroup(&apis.ZFSVolume{}, &MountInfo{MountPath: root}, FsGroupOnRootMismatch, gid); err != nil {
+		t.Fatalf("ApplyFsGroup: %v", err)
+	}
+	checkPerm(t, db, 0600)
+}
+
+func TestApplyFsGroupRecursive(t *testing.T) {
+	root, gid := fsGroupVolume(t)
+	db := filepath.Join(root, "data", "db")
+
+	// the files are changed on each publish, even once the root has the
+	// group permissions
+	for i := 0; i < 2; i++ {
+		if err := ApplyFsGroup(&apis.ZFSVolume{}, &MountInfo{MountPath: root}, FsGroupRecursive, gid); err != nil {
+			t.Fatalf("ApplyFsGroup: %v", err)
+		}
+		checkPerm(t, root, 0770|os.ModeSetgid)
+		checkPerm(t, db, 0660)
+		if err := os.Chmod(db, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestApplyFsGroupRootOnly(t *testing.T) {
+	root, gid := fsGroupVolume(t)
+
+	// the files are never walked
+	if err := ApplyFsGroup(&apis.ZFSVolume{}, &MountInfo{MountPath: root}, FsGroupRootOnly, gid); err != nil {
+		t.Fatalf("ApplyFsGroup: %v", err)
+	}
+	checkPerm(t, root, 0770|os.ModeSetgid)
+	checkPerm(t, filepath.Join(root, "data", "db"), 0600)
+}
+
+func TestApplyFsGroupSkipped(t *testing.T) {
+	root, gid := fsGroupVolume(t)
+	if err := ApplyFsGroup(&apis.ZFSVolume{}, &MountInfo{MountPath: root}, FsGroupKubelet, gid); err != nil {
+		t.Errorf("ApplyFsGroup: %v", err)
+	}
+	checkPerm(t, root, 0700)
+
+	if err := ApplyFsGroup(&apis.ZFSVolume{}, &MountInfo{MountPath: root}, FsGroupRecursive, ""); err != nil {
+		t.Errorf("ApplyFsGroup: %v", err)
+	}
+	ro := &apis.ZFSVolume{}
+	ro.Spec.ReadOnly = "yes"
+	if err := ApplyFsGroup(ro, &MountInfo{MountPath: root}, FsGroupRecursive, gid); err != nil {
+		t.Errorf("ApplyFsGroup: %v", err)
+	}
+	mnt := &MountInfo{MountPath: root, MountOptions: []string{"ro"}}
+	if err := ApplyFsGroup(&apis.ZFSVolume{}, mnt, FsGroupRecursive, gid); err != nil {
+		t.Errorf("ApplyFsGroup: %v", err)
+	}
+	// without fsGroup and on a read-only volume nothing is changed
+	checkPerm(t, root, 0700)
+
+	if err := ApplyFsGroup(&apis.ZFSVolume{}, &MountInfo{MountPath: root}, FsGroupRecursive, "staff"); err == nil {
+		t.Errorf("expected an invalid fsGroup to be refused")
+	}
+}
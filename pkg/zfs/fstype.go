@@ -0,0 +1,89 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+)
+
+// DefaultFsType is the filesystem used for the zvol when none is requested
+const DefaultFsType = "ext4"
+
+// SupportedFsTypes is the list of filesystems the driver can create
+var SupportedFsTypes = []string{"ext2", "ext3", "ext4", "xfs", "btrfs", FSTypeZFS}
+
+// lookPath finds the binary in the PATH, can be replaced in unit tests
+var lookPath = exec.LookPath
+
+// ValidateFsType returns an error if the filesystem is not supported
+func ValidateFsType(fstype string) error {
+	for _, fs := range SupportedFsTypes {
+		if fs == fstype {
+			return nil
+		}
+	}
+	return fmt.Errorf("zfs: unsupported fstype %q, supported fstypes are %s",
+		fstype, strings.Join(SupportedFsTypes, ", "))
+}
+
+// CheckMkfs verifies the mkfs binary needed for the filesystem is present
+// on this node. A zfs dataset does not need to be formatted.
+func CheckMkfs(fstype string) error {
+	if fstype == "" || fstype == FSTypeZFS {
+		return nil
+	}
+	bin := "mkfs." + fstype
+	if _, err := lookPath(bin); err != nil {
+		return fmt.Errorf("zfs: %s not found on node %s, can not create %s filesystem", bin, NodeID, fstype)
+	}
+	return nil
+}
+
+// ListFsTypeTools checks which mkfs and fsck binaries of the filesystems
+// the zvols can be formatted with are present on this node, a zfs dataset
+// needs none
+func ListFsTypeTools() []apis.FsTypeTools {
+	var tools []apis.FsTypeTools
+	for _, fs := range SupportedFsTypes {
+		if fs == FSTypeZFS {
+			continue
+		}
+		_, mkfsErr := lookPath("mkfs." + fs)
+		_, fsckErr := lookPath("fsck." + fs)
+		tools = append(tools, apis.FsTypeTools{Name: fs, Mkfs: mkfsErr == nil, Fsck: fsckErr == nil})
+	}
+	return tools
+}
+
+// CanFormat tells whether a node having the tools can format a zvol with
+// the filesystem. It is true if the node does not report its tools, the
+// creation then fails on the node if mkfs is missing.
+func CanFormat(tools []apis.FsTypeTools, fstype string) bool {
+	if tools == nil || fstype == "" || fstype == FSTypeZFS {
+		return true
+	}
+	for _, t := range tools {
+		if t.Name == fstype {
+			return t.Mkfs
+		}
+	}
+	return false
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+)
+
+func TestValidateFsType(t *testing.T) {
+	tests := []struct {
+		fstype  string
+		wantErr bool
+	}{
+		{"ext4", false},
+		{"xfs", false},
+		{"btrfs", false},
+		{"zfs", false},
+		{"ntfs", true},
+		{"EXT4", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fstype, func(t *testing.T) {
+			if err := ValidateFsType(tt.fstype); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateFsType(%s) error = %v, wantErr %v", tt.fstype, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckMkfs(t *testing.T) {
+	defer func(fn func(string) (string, error)) { lookPath = fn }(lookPath)
+	lookPath = func(bin string) (string, error) {
+		if bin == "mkfs.ext4" {
+			return "/sbin/mkfs.ext4", nil
+		}
+		return "", errors.New("not found")
+	}
+
+	if err := CheckMkfs("ext4"); err != nil {
+		t.Errorf("CheckMkfs(ext4) unexpected error %v", err)
+	}
+	if err := CheckMkfs(FSTypeZFS); err != nil {
+		t.Errorf("CheckMkfs(zfs) unexpected error %v", err)
+	}
+	if err := CheckMkfs(""); err != nil {
+		t.Errorf("CheckMkfs() unexpected error %v", err)
+	}
+
+	err := CheckMkfs("xfs")
+	if err == nil || !strings.Contains(err.Error(), "mkfs.xfs not found") {
+		t.Errorf("CheckMkfs(xfs) error = %v, want mkfs.xfs not found", err)
+	}
+}
+
+func TestListFsTypeTools(t *testing.T) {
+	defer func(fn func(string) (string, error)) { lookPath = fn }(lookPath)
+	lookPath = func(bin string) (string, error) {
+		switch bin {
+		case "mkfs.ext4", "fsck.ext4", "mkfs.xfs":
+			return "/sbin/" + bin, nil
+		}
+		return "", errors.New("not found")
+	}
+
+	want := []apis.FsTypeTools{
+		{Name: "ext2"},
+		{Name: "ext3"},
+		{Name: "ext4", Mkfs: true, Fsck: true},
+		{Name: "xfs", Mkfs: true},
+		{Name: "btrfs"},
+	}
+	if got := ListFsTypeTools(); !reflect.DeepEqual(got, want) {
+		t.Errorf("ListFsTypeTools() = %+v, want %+v", got, want)
+	}
+}
+
+func TestCanFormat(t *testing.T) {
+	tools := []apis.FsTypeTools{{Name: "ext4", Mkfs: true}, {Name: "xfs", Fsck: true}}
+	tests := []struct {
+		tools  []apis.FsTypeTools
+		fstype string
+		want   bool
+	}{
+		{tools, "ext4", true},
+		{tools, "xfs", false},
+		{tools, "btrfs", false},
+		{tools, FSTypeZFS, true},
+		{tools, "", true},
+		// the tools of the node are not known
+		{nil, "xfs", true},
+	}
+	for _, tt := range tests {
+		if got := CanFormat(tt.tools, tt.fstype); got != tt.want {
+			t.Errorf("CanFormat(%+v, %q) = %v, want %v", tt.tools, tt.fstype, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+)
+
+// ZPoolCmd is the command used to query the pools
+const ZPoolCmd = "zpool"
+
+// PoolHealthOnline is the health of a pool without any fault
+const PoolHealthOnline = "ONLINE"
+
+// VolumeExists returns an error if the dataset or zvol of the volume is
+// not present on the node
+func VolumeExists(vol *apis.ZFSVolume) error {
+	volume := VolumeDataset(vol)
+	if err := getVolume(volume); err != nil {
+		return fmt.Errorf("zfs: volume %s is not present on node %s", volume, NodeID)
+	}
+	return nil
+}
+
+// GetPoolHealth returns the health of the zpool of the pool, which can be
+// a dataset of the zpool, as reported by zpool, e.g. ONLINE, DEGRADED,
+// FAULTED
+func GetPoolHealth(pool string) (string, error) {
+	pool = zpoolOf(pool)
+	out, err := zpoolCommand(context.Background(), "list", "-H", "-o", "health", pool).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("zfs: could not get health of pool %s: %s", pool, strings.TrimSpace(string(out)))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
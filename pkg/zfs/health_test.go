@@ -0,0 +1,44 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGetPoolHealth(t *testing.T) {
+	// the fake zpool reports the health of zfspv only
+	script := filepath.Join(t.TempDir(), "zpool")
+	body := "#!/bin/sh\n[ \"$5\" = zfspv ] && echo ONLINE\n"
+	if err := os.WriteFile(script, []byte(body), 0755); err != nil {
+		t.Fatal(err)
+	}
+	orig := commands
+	t.Cleanup(func() { commands = orig })
+	commands = Commands{ZFS: script, ZPool: script}
+
+	for _, pool := range []string{"zfspv", "zfspv/parent"} {
+		if health, err := GetPoolHealth(pool); err != nil || health != PoolHealthOnline {
+			t.Errorf("GetPoolHealth(%s) = %q, %v, want %s", pool, health, err, PoolHealthOnline)
+		}
+	}
+	if _, err := GetPoolHealth("other"); err == nil {
+		t.Errorf("GetPoolHealth() expected error for a missing pool")
+	}
+}
@@ -0,0 +1,185 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/mount"
+)
+
+// systemPaths are the directories of the node the host mountpoints can
+// not be in, they belong to the system, to the kubelet or to the
+// container runtimes
+var systemPaths = []string{
+	"/bin", "/boot", "/dev", "/etc", "/lib", "/lib32", "/lib64", "/libx32",
+	"/proc", "/root", "/run", "/sbin", "/sys", "/usr",
+	"/var/lib/kubelet", "/var/lib/docker", "/var/lib/containerd", "/var/run",
+}
+
+// listMountpoints runs `zfs list` for the mountpoints of all the datasets,
+// can be replaced in unit tests
+var listMountpoints = func() ([]byte, error) {
+	return zfsCommand(ZFSListArg, "-H", "-o", "name,mountpoint", "-t", "filesystem").CombinedOutput()
+}
+
+// bindMount bind mounts the host mountpoint of the dataset on the target
+// path, can be replaced in unit tests
+var bindMount = func(source, target string, options []string) error {
+	return mount.New("").Mount(source, target, "", append(options, "bind"))
+}
+
+// ValidateHostMountPoint returns an error if the directory can not hold
+// the host mountpoints of the datasets, it must be an absolute and clean
+// path out of the system directories
+func ValidateHostMountPoint(dir string) error {
+	if !path.IsAbs(dir) || path.Clean(dir) != dir {
+		return fmt.Errorf("invalid hostmountpoint %q, it should be an absolute path", dir)
+	}
+	if dir == "/" || strings.ContainsAny(dir, ",\t\n") {
+		return fmt.Errorf("invalid hostmountpoint %q", dir)
+	}
+	for _, p := range systemPaths {
+		if dir == p || strings.HasPrefix(dir, p+"/") {
+			return fmt.Errorf("invalid hostmountpoint %q, the datasets can not be mounted in %s", dir, p)
+		}
+	}
+	return nil
+}
+
+// HostMountPath returns the host mountpoint of the volume in the
+// directory, it is named after the pvc if known and after the volume
+// otherwise
+func HostMountPath(dir, pvcNamespace, pvcName, volName string) string {
+	if pvcNamespace != "" && pvcName != "" {
+		return path.Join(dir, pvcNamespace, pvcName)
+	}
+	return path.Join(dir, volName)
+}
+
+// mountpointProperty returns the mountpoint the dataset is created with,
+// it is mounted by zfs at its host mountpoint if it has one and by the
+// node agent otherwise
+func mountpointProperty(vol *apis.ZFSVolume) string {
+	if vol.Spec.HostMountPoint != "" {
+		return "mountpoint=" + vol.Spec.HostMountPoint
+	}
+	return "mountpoint=legacy"
+}
+
+// CheckHostMountPoint returns an error if the host mountpoint of the
+// volume is already the mountpoint of another dataset, e.g. of a retained
+// volume of a pvc which has been created again with the same name
+func CheckHostMountPoint(vol *apis.ZFSVolume) error {
+	if vol.Spec.HostMountPoint == "" {
+		return nil
+	}
+	out, err := listMountpoints()
+	if err != nil {
+		return fmt.Errorf("zfs: could not list the mountpoints: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	volume := VolumeDataset(vol)
+	scanner := bufio.NewScanner(strings.NewReader(string(out)))
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), "\t", 2)
+		if len(fields) != 2 || fields[0] == volume {
+			continue
+		}
+		if fields[1] == vol.Spec.HostMountPoint {
+			return fmt.Errorf("zfs: hostmountpoint %s of %s is already used by dataset %s",
+				vol.Spec.HostMountPoint, volume, fields[0])
+		}
+	}
+	return scanner.Err()
+}
+
+// SharedMountDir is the directory of the node the shared datasets are
+// mounted in by zfs, they are bind mounted from there on the target paths
+var SharedMountDir = "/var/lib/kubelet/plugins/zfs-localpv/shared"
+
+// hostMountPath returns the path of the node where zfs keeps the dataset
+// mounted, its host mountpoint, or the one of a shared dataset as zfs only
+// shares the datasets it has mounted. It is empty for the datasets the
+// node agent mounts on the target paths.
+func hostMountPath(vol *apis.ZFSVolume) string {
+	if vol.Spec.HostMountPoint != "" {
+		return vol.Spec.HostMountPoint
+	}
+	if IsShared(vol) {
+		return path.Join(SharedMountDir, vol.Name)
+	}
+	return ""
+}
+
+// withoutHostMountPoint drops the host mountpoint of the volume from its
+// current mounts, it is not a mount of a pod
+func withoutHostMountPoint(vol *apis.ZFSVolume, mounts []string) []string {
+	host := hostMountPath(vol)
+	if host == "" {
+		return mounts
+	}
+	var out []string
+	for _, mp := range mounts {
+		if mp != host {
+			out = append(out, mp)
+		}
+	}
+	return out
+}
+
+// mountHostDataset mounts the dataset at its host mount path, where zfs
+// keeps it mounted, and bind mounts it on the target path with the options
+// of the pod, e.g. ro. The mountpoint is set again as it may have been
+// reset, e.g. by a restore.
+func mountHostDataset(vol *apis.ZFSVolume, mnt *MountInfo) error {
+	volume := VolumeDataset(vol)
+	host := hostMountPath(vol)
+	if err := MountZFSDataset(vol, host); err != nil {
+		return status.Errorf(codes.Internal, "zfs: mount failed err : %s", err.Error())
+	}
+	if err := os.MkdirAll(mnt.MountPath, 0750); err != nil {
+		return status.Errorf(codes.Internal, "could not create dir {%q}, err: %v", mnt.MountPath, err)
+	}
+	if err := bindMount(host, mnt.MountPath, mnt.MountOptions); err != nil {
+		klog.Errorf("zfs: could not bind mount %s on %s: %v", host, mnt.MountPath, err)
+		return status.Errorf(codes.Internal, "dataset: bind mount failed err : %s", err.Error())
+	}
+	klog.Infof("dataset : mounted %s => %s => %s", volume, host, mnt.MountPath)
+	return nil
+}
+
+// mountedByPods tells whether the dataset mounted at a host mount path is
+// still bind mounted by a pod
+func mountedByPods(vol *apis.ZFSVolume) bool {
+	if hostMountPath(vol) == "" {
+		return false
+	}
+	mounts, err := volumeMounts(vol)
+	if err != nil {
+		klog.Warningf("zfs: could not get the mounts of %s: %v", vol.Name, err)
+		return true
+	}
+	return len(withoutHostMountPoint(vol, mounts)) != 0
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+)
+
+func hostMountVolume(mountpoint string) *apis.ZFSVolume {
+	vol := &apis.ZFSVolume{}
+	vol.Name = "pvc-1"
+	vol.Spec.PoolName = "zfspv"
+	vol.Spec.VolumeType = VolTypeDataset
+	vol.Spec.HostMountPoint = mountpoint
+	return vol
+}
+
+func TestValidateHostMountPoint(t *testing.T) {
+	for _, dir := range []string{"/mnt/zfs", "/srv/volumes", "/var/lib/zfs-volumes", "/data"} {
+		if err := ValidateHostMountPoint(dir); err != nil {
+			t.Errorf("ValidateHostMountPoint(%q) = %v", dir, err)
+		}
+	}
+	for _, dir := range []string{"", "mnt/zfs", "/mnt/zfs/", "/mnt/../etc", "/",
+		"/etc", "/etc/zfs", "/usr/local/data", "/var/lib/kubelet/pods", "/proc", "/mnt/a,b"} {
+		if err := ValidateHostMountPoint(dir); err == nil {
+			t.Errorf("ValidateHostMountPoint(%q) succeeded", dir)
+		}
+	}
+}
+
+func TestHostMountPath(t *testing.T) {
+	if got := HostMountPath("/mnt/zfs", "app", "data", "pvc-1"); got != "/mnt/zfs/app/data" {
+		t.Errorf("HostMountPath() = %s, want the pvc path", got)
+	}
+	if got := HostMountPath("/mnt/zfs", "", "", "pvc-1"); got != "/mnt/zfs/pvc-1" {
+		t.Errorf("HostMountPath() = %s, want the volume path", got)
+	}
+}
+
+func TestHostMountPointArgs(t *testing.T) {
+	vol := hostMountVolume("/mnt/zfs/app/data")
+	want := []string{"create", "-o", "mountpoint=/mnt/zfs/app/data", "zfspv/pvc-1"}
+	if got := buildDatasetCreateArgs(vol); !reflect.DeepEqual(got, want) {
+		t.Errorf("buildDatasetCreateArgs() = %v, want %v", got, want)
+	}
+
+	vol.Spec.SnapName = "pvc-0@snap-1"
+	want = []string{"clone", "-o", "mountpoint=/mnt/zfs/app/data", "zfspv/pvc-0@snap-1", "zfspv/pvc-1"}
+	if got := buildCloneCreateArgs(vol); !reflect.DeepEqual(got, want) {
+		t.Errorf("buildCloneCreateArgs() = %v, want %v", got, want)
+	}
+
+	if got := mountpointProperty(hostMountVolume("")); got != "mountpoint=legacy" {
+		t.Errorf("mountpointProperty() = %s, want legacy", got)
+	}
+}
+
+func TestCheckHostMountPoint(t *testing.T) {
+	defer func(f func() ([]byte, error)) { listMountpoints = f }(listMountpoints)
+	listMountpoints = func() ([]byte, error) {
+		return []byte("zfspv\t/zfspv\n" +
+			"zfspv/pvc-0\t/mnt/zfs/app/data\n" +
+			"zfspv/pvc-1\t/mnt/zfs/app/logs\n" +
+			"zfspv/pvc-2\tlegacy\n"), nil
+	}
+
+	if err := CheckHostMountPoint(hostMountVolume("/mnt/zfs/app/data")); err == nil {
+		t.Error("CheckHostMountPoint() accepted the mountpoint of another dataset")
+	}
+	// the volume itself is already mounted there, e.g. on a retry
+	if err := CheckHostMountPoint(hostMountVolume("/mnt/zfs/app/logs")); err != nil {
+		t.Errorf("CheckHostMountPoint() = %v for the mountpoint of the volume", err)
+	}
+	if err := CheckHostMountPoint(hostMountVolume("/mnt/zfs/app/cache")); err != nil {
+		t.Errorf("CheckHostMountPoint() = %v for a free mountpoint", err)
+	}
+	// nothing is listed without a host mountpoint
+	listMountpoints = func() ([]byte, error) { return nil, errors.New("zfs not found") }
+	if err := CheckHostMountPoint(hostMountVolume("")); err != nil {
+		t.Errorf("CheckHostMountPoint() = %v without a host mountpoint", err)
+	}
+	if err := CheckHostMountPoint(hostMountVolume("/mnt/zfs/app/cache")); err == nil {
+		t.Error("CheckHostMountPoint() ignored the list failure")
+	}
+}
+
+func TestWithoutHostMountPoint(t *testing.T) {
+	mounts := []string{"/mnt/zfs/app/data", "/var/lib/kubelet/pods/uid/volumes/mount"}
+	got := withoutHostMountPoint(hostMountVolume("/mnt/zfs/app/data"), mounts)
+	if !reflect.DeepEqual(got, mounts[1:]) {
+		t.Errorf("withoutHostMountPoint() = %v, want the pod mounts", got)
+	}
+	if got := withoutHostMountPoint(hostMountVolume(""), mounts); !reflect.DeepEqual(got, mounts) {
+		t.Errorf("withoutHostMountPoint() = %v without a host mountpoint", got)
+	}
+}
+
+func TestSharedHostMountPath(t *testing.T) {
+	vol := hostMountVolume("")
+	if got := hostMountPath(vol); got != "" {
+		t.Errorf("hostMountPath() = %q for a dataset mounted on the target path", got)
+	}
+	vol.Spec.ShareNFS = "on"
+	want := SharedMountDir + "/pvc-1"
+	if got := hostMountPath(vol); got != want {
+		t.Errorf("hostMountPath() = %q, want %q", got, want)
+	}
+
+	// the shared dataset stays mounted while a pod bind mounts it
+	defer func(f func(*apis.ZFSVolume) ([]string, error)) { volumeMounts = f }(volumeMounts)
+	mounts := []string{want, "/var/lib/kubelet/pods/uid/volumes/mount"}
+	volumeMounts = func(*apis.ZFSVolume) ([]string, error) { return mounts, nil }
+	if !mountedByPods(vol) {
+		t.Error("mountedByPods() = false while a pod mounts the dataset")
+	}
+	mounts = mounts[:1]
+	if mountedByPods(vol) {
+		t.Error("mountedByPods() = true once the pods are gone")
+	}
+}
@@ -0,0 +1,213 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrLatencyUnsupported is returned when zfs can not report the latency
+// histograms of the pools, they came with zfs 0.7
+var ErrLatencyUnsupported = errors.New("zfs: the latency histograms of the pools are not supported by this zfs version")
+
+// LatencyQuantiles are the quantiles of the latency of the IOs of the
+// pools which are reported
+var LatencyQuantiles = []float64{0.5, 0.9, 0.99}
+
+// LatencyHistogram is the number of IOs of a pool completed within each
+// latency bucket since the pool was imported. The total wait is the time
+// from the IO being queued by zfs to its completion and the disk wait the
+// time spent in the device.
+type LatencyHistogram struct {
+	// Bounds are the upper bounds of the buckets, in seconds
+	Bounds []float64
+	// the number of IOs of each bucket
+	TotalRead, TotalWrite, DiskRead, DiskWrite []uint64
+}
+
+// latencyUnits are the units of the buckets of `zpool iostat -w` when not
+// printed in nanoseconds, with their number per second
+var latencyUnits = []struct {
+	suffix    string
+	perSecond float64
+}{{"ns", 1e9}, {"us", 1e6}, {"ms", 1e3}, {"s", 1}}
+
+// zpoolLatency runs `zpool iostat -w -p` for the latency histograms of all
+// the pools, can be replaced in unit tests
+var zpoolLatency = func(ctx context.Context) ([]byte, error) {
+	return zpoolCommand(ctx, "iostat", "-w", "-p").CombinedOutput()
+}
+
+// ListPoolLatency returns the latency histograms of all the pools with a
+// single `zpool iostat` call. It returns ErrLatencyUnsupported if zfs does
+// not know the histograms.
+func ListPoolLatency() (map[string]LatencyHistogram, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), poolSummaryTimeout)
+	defer cancel()
+	out, err := zpoolLatency(ctx)
+	if err != nil {
+		if strings.Contains(string(out), "invalid option") {
+			return nil, ErrLatencyUnsupported
+		}
+		return nil, fmt.Errorf("zfs: could not get the latency of the pools: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return parsePoolLatency(out)
+}
+
+// parsePoolLatency parses the latency histograms of `zpool iostat -w`,
+// each pool has its own table whose header names the pool, e.g.
+//
+//	zfspv-pool   total_wait     disk_wait    syncq_wait    asyncq_wait
+//	latency      read  write   read  write   read  write   read  write  scrub   trim
+//	----------  -----  -----  -----  -----  -----  -----  -----  -----  -----  -----
+//	1               0      0      0      0      0      0      0      0      0      0
+//	3               0      0      0      0      0      0      0      0      0      0
+//
+// the buckets are in nanoseconds with -p, only the total and the disk
+// waits are kept.
+func parsePoolLatency(raw []byte) (map[string]LatencyHistogram, error) {
+	pools := map[string]LatencyHistogram{}
+
+	var pool string
+	var hist LatencyHistogram
+	flush := func() {
+		if pool != "" && len(hist.Bounds) != 0 {
+			pools[pool] = hist
+		}
+		pool, hist = "", LatencyHistogram{}
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(string(raw)))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		switch {
+		case len(fields) == 0 || strings.HasPrefix(fields[0], "---") || fields[0] == "latency":
+			continue
+		case len(fields) > 2 && fields[1] == "total_wait":
+			flush()
+			if fields[2] != "disk_wait" {
+				return nil, fmt.Errorf("zfs: unknown latency columns %q", scanner.Text())
+			}
+			pool = fields[0]
+			continue
+		}
+		if pool == "" {
+			return nil, fmt.Errorf("zfs: latency bucket %q out of a pool", scanner.Text())
+		}
+		if len(fields) < 5 {
+			return nil, fmt.Errorf("zfs: invalid latency bucket %q of pool %s", scanner.Text(), pool)
+		}
+		bound, err := parseLatencyBound(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("zfs: invalid latency bucket of pool %s: %v", pool, err)
+		}
+		var counts [4]uint64
+		for i := range counts {
+			if counts[i], err = strconv.ParseUint(fields[i+1], 10, 64); err != nil {
+				return nil, fmt.Errorf("zfs: invalid latency bucket %q of pool %s", scanner.Text(), pool)
+			}
+		}
+		hist.Bounds = append(hist.Bounds, bound)
+		hist.TotalRead = append(hist.TotalRead, counts[0])
+		hist.TotalWrite = append(hist.TotalWrite, counts[1])
+		hist.DiskRead = append(hist.DiskRead, counts[2])
+		hist.DiskWrite = append(hist.DiskWrite, counts[3])
+	}
+	flush()
+	return pools, scanner.Err()
+}
+
+// parseLatencyBound parses the upper bound of a latency bucket, in
+// nanoseconds or with a unit, e.g. 127, 127ns, 16us or 2s
+func parseLatencyBound(val string) (float64, error) {
+	perSecond := 1e9
+	num := val
+	for _, u := range latencyUnits {
+		if strings.HasSuffix(val, u.suffix) {
+			perSecond, num = u.perSecond, strings.TrimSuffix(val, u.suffix)
+			break
+		}
+	}
+	n, err := strconv.ParseUint(num, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid latency %q", val)
+	}
+	return float64(n) / perSecond, nil
+}
+
+// Since returns the IOs completed since the previous histogram of the
+// pool. The counters start over when the pool is imported again, the
+// whole histogram is returned then.
+func (h LatencyHistogram) Since(prev LatencyHistogram) LatencyHistogram {
+	if len(prev.Bounds) != len(h.Bounds) {
+		return h
+	}
+	delta := LatencyHistogram{Bounds: h.Bounds}
+	var ok bool
+	if delta.TotalRead, ok = subCounts(h.TotalRead, prev.TotalRead); !ok {
+		return h
+	}
+	if delta.TotalWrite, ok = subCounts(h.TotalWrite, prev.TotalWrite); !ok {
+		return h
+	}
+	if delta.DiskRead, ok = subCounts(h.DiskRead, prev.DiskRead); !ok {
+		return h
+	}
+	if delta.DiskWrite, ok = subCounts(h.DiskWrite, prev.DiskWrite); !ok {
+		return h
+	}
+	return delta
+}
+
+// subCounts subtracts the previous counts of the buckets, it is false if
+// a counter has gone back
+func subCounts(cur, prev []uint64) ([]uint64, bool) {
+	out := make([]uint64, len(cur))
+	for i := range cur {
+		if cur[i] < prev[i] {
+			return nil, false
+		}
+		out[i] = cur[i] - prev[i]
+	}
+	return out, true
+}
+
+// LatencyQuantile returns the upper bound of the bucket the quantile q of
+// the IOs falls in, in seconds. It is false if there is no IO.
+func LatencyQuantile(bounds []float64, counts []uint64, q float64) (float64, bool) {
+	var total uint64
+	for _, c := range counts {
+		total += c
+	}
+	if total == 0 {
+		return 0, false
+	}
+	rank := q * float64(total)
+	var seen uint64
+	for i, c := range counts {
+		seen += c
+		if float64(seen) >= rank {
+			return bounds[i], true
+		}
+	}
+	return bounds[len(bounds)-1], true
+}
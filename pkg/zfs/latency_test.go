@@ -0,0 +1,161 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+const zpoolLatencyOutput = `zfspv-pool   total_wait     disk_wait    syncq_wait    asyncq_wait
+latency      read  write   read  write   read  write   read  write  scrub   trim
+----------  -----  -----  -----  -----  -----  -----  -----  -----  -----  -----
+1023            0      0      0      0      0      0      0      0      0      0
+2047            2      0      5      1      0      0      0      0      0      0
+4095            6      1      4      3      0      0      0      0      0      0
+8191            2      5      1      6      0      0      0      0      0      0
+16383           0      4      0      0      0      0      0      0      0      0
+--------------------------------------------------------------------------------
+
+backup       total_wait     disk_wait    syncq_wait    asyncq_wait
+latency      read  write   read  write   read  write   read  write  scrub   trim
+----------  -----  -----  -----  -----  -----  -----  -----  -----  -----  -----
+1023            0      0      0      0      0      0      0      0      0      0
+2047            0      0      0      0      0      0      0      0      0      0
+--------------------------------------------------------------------------------
+`
+
+func TestParsePoolLatency(t *testing.T) {
+	pools, err := parsePoolLatency([]byte(zpoolLatencyOutput))
+	if err != nil {
+		t.Fatalf("parsePoolLatency() unexpected error %v", err)
+	}
+	bounds := []float64{1023e-9, 2047e-9, 4095e-9, 8191e-9, 16383e-9}
+	want := map[string]LatencyHistogram{
+		"zfspv-pool": {
+			Bounds:     bounds,
+			TotalRead:  []uint64{0, 2, 6, 2, 0},
+			TotalWrite: []uint64{0, 0, 1, 5, 4},
+			DiskRead:   []uint64{0, 5, 4, 1, 0},
+			DiskWrite:  []uint64{0, 1, 3, 6, 0},
+		},
+		"backup": {
+			Bounds:     bounds[:2],
+			TotalRead:  []uint64{0, 0},
+			TotalWrite: []uint64{0, 0},
+			DiskRead:   []uint64{0, 0},
+			DiskWrite:  []uint64{0, 0},
+		},
+	}
+	if !reflect.DeepEqual(pools, want) {
+		t.Errorf("parsePoolLatency() = %+v, want %+v", pools, want)
+	}
+
+	for _, out := range []string{
+		// a bucket out of a pool
+		"1023  0  0  0  0\n",
+		// the columns of an unknown version
+		"zfspv-pool   total_wait     syncq_wait\n",
+		"zfspv-pool   total_wait     disk_wait\n1023  0  x  0  0\n",
+		"zfspv-pool   total_wait     disk_wait\n1023  0  0\n",
+	} {
+		if _, err := parsePoolLatency([]byte(out)); err == nil {
+			t.Errorf("parsePoolLatency(%q) expected an error", out)
+		}
+	}
+}
+
+func TestParseLatencyBound(t *testing.T) {
+	tests := map[string]float64{
+		"127":   127e-9,
+		"127ns": 127e-9,
+		"16us":  16e-6,
+		"4ms":   4e-3,
+		"2s":    2,
+	}
+	for val, want := range tests {
+		got, err := parseLatencyBound(val)
+		if err != nil || got != want {
+			t.Errorf("parseLatencyBound(%q) = %v, %v, want %v", val, got, err, want)
+		}
+	}
+	if _, err := parseLatencyBound("1.5ms"); err == nil {
+		t.Errorf("parseLatencyBound(1.5ms) expected an error")
+	}
+}
+
+func TestListPoolLatencyUnsupported(t *testing.T) {
+	orig := zpoolLatency
+	defer func() { zpoolLatency = orig }()
+	zpoolLatency = func(ctx context.Context) ([]byte, error) {
+		return []byte("invalid option 'w'\nusage:\n\tiostat [-c | -C] [-gLPvy]"), errors.New("exit status 2")
+	}
+	if _, err := ListPoolLatency(); !errors.Is(err, ErrLatencyUnsupported) {
+		t.Errorf("ListPoolLatency() = %v, want %v", err, ErrLatencyUnsupported)
+	}
+
+	zpoolLatency = func(ctx context.Context) ([]byte, error) {
+		return []byte("no pools available"), errors.New("exit status 1")
+	}
+	if _, err := ListPoolLatency(); err == nil || errors.Is(err, ErrLatencyUnsupported) {
+		t.Errorf("ListPoolLatency() = %v, want a failure", err)
+	}
+}
+
+func TestLatencySince(t *testing.T) {
+	prev := LatencyHistogram{
+		Bounds:    []float64{1, 2},
+		TotalRead: []uint64{1, 2}, TotalWrite: []uint64{0, 0},
+		DiskRead: []uint64{1, 2}, DiskWrite: []uint64{0, 0},
+	}
+	cur := LatencyHistogram{
+		Bounds:    []float64{1, 2},
+		TotalRead: []uint64{3, 2}, TotalWrite: []uint64{0, 4},
+		DiskRead: []uint64{3, 2}, DiskWrite: []uint64{0, 4},
+	}
+	want := LatencyHistogram{
+		Bounds:    []float64{1, 2},
+		TotalRead: []uint64{2, 0}, TotalWrite: []uint64{0, 4},
+		DiskRead: []uint64{2, 0}, DiskWrite: []uint64{0, 4},
+	}
+	if got := cur.Since(prev); !reflect.DeepEqual(got, want) {
+		t.Errorf("Since() = %+v, want %+v", got, want)
+	}
+
+	// the pool has been imported again, or was not known before
+	if got := prev.Since(cur); !reflect.DeepEqual(got, prev) {
+		t.Errorf("Since() = %+v after a reimport, want %+v", got, prev)
+	}
+	if got := cur.Since(LatencyHistogram{}); !reflect.DeepEqual(got, cur) {
+		t.Errorf("Since() = %+v of a new pool, want %+v", got, cur)
+	}
+}
+
+func TestLatencyQuantile(t *testing.T) {
+	bounds := []float64{1, 2, 4, 8}
+	counts := []uint64{50, 40, 9, 1}
+	for q, want := range map[float64]float64{0.5: 1, 0.9: 2, 0.99: 4, 1: 8} {
+		if got, ok := LatencyQuantile(bounds, counts, q); !ok || got != want {
+			t.Errorf("LatencyQuantile(%v) = %v, %v, want %v", q, got, ok, want)
+		}
+	}
+	if _, ok := LatencyQuantile(bounds, make([]uint64, 4), 0.5); ok {
+		t.Errorf("LatencyQuantile() of no IO should not be reported")
+	}
+}
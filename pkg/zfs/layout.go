@@ -0,0 +1,231 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/mount"
+)
+
+// layoutChild is a child dataset of a layout, mounted in the directory of
+// its name below the volume
+type layoutChild struct {
+	name       string
+	recordsize string
+}
+
+// layouts are the named profiles of the child datasets, tuned for the
+// io of each part of the database: the pages of the data files, and the
+// sequential writes of the logs
+var layouts = map[string][]layoutChild{
+	"postgres": {
+		{name: "data", recordsize: "8K"},
+		{name: "wal", recordsize: "128K"},
+		{name: "logs", recordsize: "128K"},
+	},
+	"mysql": {
+		{name: "data", recordsize: "16K"},
+		{name: "log", recordsize: "128K"},
+		{name: "binlog", recordsize: "128K"},
+	},
+}
+
+// runLayout runs the zfs command creating a child dataset
+func runLayout(args []string) ([]byte, error) {
+	// zfs create fails while the pool is busy, e.g. during a scrub
+	return runRetryBusy(args, func() ([]byte, error) {
+		return zfsCommand(args...).CombinedOutput()
+	})
+}
+
+// ValidateLayout returns an error if the layout of the storageclass is not
+// a known profile or can not be used with the other parameters. The
+// children have to share the quota of the volume, which a refquota does
+// not bound, and are mounted by the node agent below the volume.
+func ValidateLayout(layout, vtype, quotatype, shared, hostmountpoint string) error {
+	if layout == "" {
+		return nil
+	}
+	if _, ok := layouts[layout]; !ok {
+		return fmt.Errorf("invalid layout %q, it should be postgres or mysql", layout)
+	}
+	switch {
+	case vtype != VolTypeDataset:
+		return fmt.Errorf("layout is only supported for fstype %s", FSTypeZFS)
+	case quotatype == "refquota":
+		return fmt.Errorf("layout can not be used with quotatype refquota, it does not bound the child datasets")
+	case shared == "yes":
+		return fmt.Errorf("layout can not be used with a shared volume")
+	case hostmountpoint != "":
+		return fmt.Errorf("layout can not be used with hostmountpoint")
+	}
+	return nil
+}
+
+// volumeLayout returns the child datasets of the volume, none for a
+// volume without a layout
+func volumeLayout(vol *apis.ZFSVolume) []layoutChild {
+	return layouts[vol.Spec.Layout]
+}
+
+// buildLayoutChildArgs returns the zfs command creating the child dataset
+// of the volume, or cloning it from the snapshot of the child of the
+// origin if it is set. The child has no quota of its own, the quota of the
+// volume bounds all of its children, and it is mounted by the node agent.
+func buildLayoutChildArgs(vol *apis.ZFSVolume, child layoutChild, origin string) []string {
+	args := []string{ZFSCreateArg}
+	if origin != "" {
+		args = []string{ZFSCloneArg}
+	}
+	args = append(args, "-o", "recordsize="+child.recordsize, "-o", "mountpoint=legacy")
+	if origin != "" {
+		args = append(args, origin)
+	}
+	return append(args, VolumeDataset(vol)+"/"+child.name)
+}
+
+// childSnapshot returns the snapshot of the child of the origin snapshot,
+// e.g. zfspv-pool/pvc-1/data@snap for zfspv-pool/pvc-1@snap
+func childSnapshot(origin, child string) string {
+	parts := strings.SplitN(origin, "@", 2)
+	if len(parts) != 2 {
+		return ""
+	}
+	return parts[0] + "/" + child + "@" + parts[1]
+}
+
+// layoutSendArgs returns the zfs send option which takes the child
+// datasets of a layout along with the snapshot of the volume
+func layoutSendArgs(vol *apis.ZFSVolume) []string {
+	if vol.Spec.Layout == "" {
+		return nil
+	}
+	return []string{"-R"}
+}
+
+// createLayout creates the child datasets of the volume which are missing,
+// it can be run again after a failure. The children of a clone are cloned
+// from the children of its origin snapshot if it has them, they are
+// created empty otherwise. The children of a copy from another zpool are
+// received from the children of the snapshot.
+func createLayout(vol *apis.ZFSVolume, origin string) error {
+	for _, child := range volumeLayout(vol) {
+		dataset := VolumeDataset(vol) + "/" + child.name
+		if datasetExists(dataset) {
+			continue
+		}
+		source := ""
+		if origin != "" {
+			if snap := childSnapshot(origin, child.name); snap != "" && datasetExists(snap) {
+				source = snap
+			} else {
+				klog.Warningf("zfs: origin %s of %s has no child %s, it is created empty", origin, VolumeDataset(vol), child.name)
+			}
+		}
+		if source != "" && IsPoolCopy(vol) {
+			args := buildLayoutChildArgs(vol, child, "")
+			// drop the create subcommand and the dataset
+			if err := receiveCopy(source, dataset, args[1:len(args)-1]); err != nil {
+				return fmt.Errorf("could not copy the child %s of the %s layout, %v", child.name, vol.Spec.Layout, err)
+			}
+			klog.Infof("copied the child %s of volume %s", dataset, vol.Name)
+			continue
+		}
+		args := buildLayoutChildArgs(vol, child, source)
+		if out, err := runLayout(args); err != nil {
+			klog.Errorf("zfs: could not create the child %s of volume %s cmd %v error: %s",
+				child.name, vol.Name, args, string(out))
+			return fmt.Errorf("could not create the child %s of the %s layout, %s", child.name, vol.Spec.Layout, string(out))
+		}
+		klog.Infof("created the child %s of volume %s", dataset, vol.Name)
+	}
+	return nil
+}
+
+// mountLayout mounts the child datasets of the volume in the directories
+// of their names below the target path, with the options of the volume.
+// The children already mounted there are skipped.
+func mountLayout(vol *apis.ZFSVolume, mnt *MountInfo) error {
+	return mountLayoutChildren(mount.New(""), vol, mnt)
+}
+
+func mountLayoutChildren(mounter mount.Interface, vol *apis.ZFSVolume, mnt *MountInfo) error {
+	children := volumeLayout(vol)
+	if len(children) == 0 {
+		return nil
+	}
+	mps, err := mounter.List()
+	if err != nil {
+		return status.Errorf(codes.Internal, "could not list the mounts: %v", err)
+	}
+	mounted := map[string]string{}
+	for _, mp := range mps {
+		mounted[mp.Path] = mp.Device
+	}
+	for _, child := range children {
+		dataset := VolumeDataset(vol) + "/" + child.name
+		dir := filepath.Join(mnt.MountPath, child.name)
+		if mounted[dir] == dataset {
+			continue
+		}
+		if err := os.MkdirAll(dir, 0750); err != nil {
+			return status.Errorf(codes.Internal, "could not create dir {%q}, err: %v", dir, err)
+		}
+		if err := mounter.Mount(dataset, dir, "zfs", mnt.MountOptions); err != nil {
+			klog.Errorf("zfs: could not mount the child %v on %s error: %v", dataset, dir, err)
+			return status.Errorf(codes.Internal, "dataset: mount of child %s failed err : %v", child.name, err)
+		}
+		klog.Infof("dataset : legacy mounted %s => %s", dataset, dir)
+	}
+	return nil
+}
+
+// unmountLayout unmounts the child datasets of the volume mounted below
+// the target path, in the reverse order of their mounts, before the volume
+// itself can be unmounted
+func unmountLayout(mounter mount.Interface, vol *apis.ZFSVolume, targetPath string) error {
+	children := volumeLayout(vol)
+	if len(children) == 0 {
+		return nil
+	}
+	mps, err := mounter.List()
+	if err != nil {
+		return fmt.Errorf("could not list the mounts: %v", err)
+	}
+	mounted := map[string]bool{}
+	for _, mp := range mps {
+		mounted[mp.Path] = true
+	}
+	for i := len(children) - 1; i >= 0; i-- {
+		dir := filepath.Join(targetPath, children[i].name)
+		if !mounted[dir] {
+			continue
+		}
+		if err := mounter.Unmount(dir); err != nil {
+			return fmt.Errorf("could not unmount the child %s: %v", children[i].name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"k8s.io/utils/mount"
+)
+
+func layoutVolume(layout string) *apis.ZFSVolume {
+	vol := &apis.ZFSVolume{}
+	vol.Name = "pvc-db"
+	vol.Spec.PoolName = "zfspv-pool"
+	vol.Spec.VolumeType = VolTypeDataset
+	vol.Spec.QuotaType = "quota"
+	vol.Spec.Capacity = "10737418240"
+	vol.Spec.Layout = layout
+	return vol
+}
+
+func TestValidateLayout(t *testing.T) {
+	tests := []struct {
+		layout, vtype, quotatype, shared, hostmountpoint string
+		valid                                            bool
+	}{
+		{"", VolTypeZVol, "refquota", "yes", "/data", true},
+		{"postgres", VolTypeDataset, "quota", "", "", true},
+		{"mysql", VolTypeDataset, "", "no", "", true},
+		{"oracle", VolTypeDataset, "quota", "", "", false},
+		{"postgres", VolTypeZVol, "", "", "", false},
+		{"postgres", VolTypeDataset, "refquota", "", "", false},
+		{"postgres", VolTypeDataset, "quota", "yes", "", false},
+		{"postgres", VolTypeDataset, "quota", "", "/data/zfs", false},
+	}
+	for _, tt := range tests {
+		err := ValidateLayout(tt.layout, tt.vtype, tt.quotatype, tt.shared, tt.hostmountpoint)
+		if (err == nil) != tt.valid {
+			t.Errorf("ValidateLayout(%q, %q, %q, %q, %q) = %v, want valid %v",
+				tt.layout, tt.vtype, tt.quotatype, tt.shared, tt.hostmountpoint, err, tt.valid)
+		}
+	}
+}
+
+func TestCreateLayout(t *testing.T) {
+	f := newFakeZFS(t, "zfspv-pool/pvc-db")
+
+	// the postgres profile expands into its children, bounded by the
+	// quota of the volume
+	vol := layoutVolume("postgres")
+	if err := createLayout(vol, ""); err != nil {
+		t.Fatalf("createLayout() failed: %v", err)
+	}
+	want := map[string]string{"data": "8K", "wal": "128K", "logs": "128K"}
+	for child, recordsize := range want {
+		ds := "zfspv-pool/pvc-db/" + child
+		if got := f.Prop(ds, "recordsize"); got != recordsize || f.Prop(ds, "mountpoint") != "legacy" {
+			t.Errorf("child %s has recordsize %s mountpoint %s, want %s legacy", ds, got, f.Prop(ds, "mountpoint"), recordsize)
+		}
+	}
+
+	// the children already created are skipped on a retry
+	ran := len(f.Ran())
+	if err := createLayout(vol, ""); err != nil {
+		t.Errorf("createLayout() retry failed: %v", err)
+	}
+	for _, cmd := range f.Ran()[ran:] {
+		if strings.HasPrefix(cmd, "create ") {
+			t.Errorf("createLayout() retry ran %q", cmd)
+		}
+	}
+
+	// a clone takes the children of its origin snapshot, the missing ones
+	// are created empty
+	f.Create(t, "zfspv-pool/pvc-src/data@snap-1")
+	f.Create(t, "zfspv-pool/pvc-src/log@snap-1")
+	clone := layoutVolume("mysql")
+	clone.Name = "pvc-clone"
+	ran = len(f.Ran())
+	if err := createLayout(clone, "zfspv-pool/pvc-src@snap-1"); err != nil {
+		t.Fatalf("createLayout() failed: %v", err)
+	}
+	var created []string
+	for _, cmd := range f.Ran()[ran:] {
+		if !strings.HasPrefix(cmd, "list ") {
+			created = append(created, cmd)
+		}
+	}
+	wantCmds := []string{
+		"clone -o recordsize=16K -o mountpoint=legacy zfspv-pool/pvc-src/data@snap-1 zfspv-pool/pvc-clone/data",
+		"clone -o recordsize=128K -o mountpoint=legacy zfspv-pool/pvc-src/log@snap-1 zfspv-pool/pvc-clone/log",
+		"create -o recordsize=128K -o mountpoint=legacy zfspv-pool/pvc-clone/binlog",
+	}
+	if !reflect.DeepEqual(created, wantCmds) {
+		t.Errorf("createLayout() ran %q, want %q", created, wantCmds)
+	}
+
+	// a volume without a layout has no children
+	ran = len(f.Ran())
+	if err := createLayout(layoutVolume(""), ""); err != nil || len(f.Ran()) != ran {
+		t.Errorf("createLayout() = %v, ran %q for a volume without a layout", err, f.Ran()[ran:])
+	}
+
+	f.Fail(t, "create", "cannot create 'zfspv-pool/pvc-full/data': out of space")
+	vol.Name = "pvc-full"
+	if err := createLayout(vol, ""); err == nil {
+		t.Error("createLayout() succeeded on a failed zfs create")
+	}
+}
+
+func TestMountLayout(t *testing.T) {
+	target := filepath.Join(t.TempDir(), "mount")
+	// the data child has been mounted by an earlier call
+	mounter := mount.NewFakeMounter([]mount.MountPoint{
+		{Device: "zfspv-pool/pvc-db", Path: target},
+		{Device: "zfspv-pool/pvc-db/data", Path: filepath.Join(target, "data")},
+	})
+
+	vol := layoutVolume("postgres")
+	if err := mountLayoutChildren(mounter, vol, &MountInfo{MountPath: target, MountOptions: []string{"noatime", "ro"}}); err != nil {
+		t.Fatalf("mountLayout() failed: %v", err)
+	}
+	want := []mount.MountPoint{
+		{Device: "zfspv-pool/pvc-db", Path: target},
+		{Device: "zfspv-pool/pvc-db/data", Path: filepath.Join(target, "data")},
+		{Device: "zfspv-pool/pvc-db/wal", Path: filepath.Join(target, "wal"), Type: "zfs", Opts: []string{"noatime", "ro"}},
+		{Device: "zfspv-pool/pvc-db/logs", Path: filepath.Join(target, "logs"), Type: "zfs", Opts: []string{"noatime", "ro"}},
+	}
+	if !reflect.DeepEqual(mounter.MountPoints, want) {
+		t.Errorf("mountLayout() mounts %v, want %v", mounter.MountPoints, want)
+	}
+	for _, dir := range []string{"wal", "logs"} {
+		if fi, err := os.Stat(filepath.Join(target, dir)); err != nil || !fi.IsDir() {
+			t.Errorf("mountLayout() did not create %s: %v", dir, err)
+		}
+	}
+
+	// the children are unmounted in the reverse order, the ones not
+	// mounted are skipped
+	mounter = mount.NewFakeMounter([]mount.MountPoint{
+		{Device: "zfspv-pool/pvc-db", Path: target},
+		{Device: "zfspv-pool/pvc-db/data", Path: filepath.Join(target, "data")},
+		{Device: "zfspv-pool/pvc-db/logs", Path: filepath.Join(target, "logs")},
+	})
+	if err := unmountLayout(mounter, vol, target); err != nil {
+		t.Fatalf("unmountLayout() failed: %v", err)
+	}
+	var unmounted []string
+	for _, action := range mounter.GetLog() {
+		unmounted = append(unmounted, action.Target)
+	}
+	if want := []string{filepath.Join(target, "logs"), filepath.Join(target, "data")}; !reflect.DeepEqual(unmounted, want) {
+		t.Errorf("unmountLayout() unmounted %v, want %v", unmounted, want)
+	}
+}
+
+func TestLayoutSnapshotArgs(t *testing.T) {
+	snap := &apis.ZFSSnapshot{}
+	snap.Name = "snap-1"
+	snap.Labels = map[string]string{ZFSVolKey: "pvc-db"}
+	snap.Spec.VolumeInfo = layoutVolume("postgres").Spec
+
+	// the snapshot of a volume with a layout holds its children
+	if got, want := buildZFSSnapCreateArgs(snap), []string{"snapshot", "-r", "zfspv-pool/pvc-db@snap-1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("buildZFSSnapCreateArgs() = %v, want %v", got, want)
+	}
+	if got, want := buildZFSSnapDestroyArgs(snap), []string{"destroy", "-r", "zfspv-pool/pvc-db@snap-1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("buildZFSSnapDestroyArgs() = %v, want %v", got, want)
+	}
+
+	snap.Spec.Layout = ""
+	if got, want := buildZFSSnapCreateArgs(snap), []string{"snapshot", "zfspv-pool/pvc-db@snap-1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("buildZFSSnapCreateArgs() = %v, want %v", got, want)
+	}
+}
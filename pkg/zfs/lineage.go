@@ -0,0 +1,145 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"bufio"
+	"fmt"
+	"sort"
+	"strings"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"k8s.io/klog/v2"
+)
+
+// Lineage is the clone/origin relationship of a volume
+type Lineage struct {
+	// Dataset is the zfs dataset of the volume
+	Dataset string
+	// Origin is the snapshot the volume has been cloned from, empty if
+	// the volume is not a clone
+	Origin string
+	// Ancestors is the chain of origin snapshots starting from the origin
+	// of the volume up to the snapshot of a volume which is not a clone
+	Ancestors []string
+	// Clones has the datasets cloned from each snapshot of the volume
+	Clones map[string][]string
+	// Dependents is every dataset which depends on the snapshots of the
+	// volume directly or through other clones, listed in the order they
+	// can be safely deleted
+	Dependents []string
+}
+
+// datasetOf returns the dataset of the snapshot
+func datasetOf(snap string) string {
+	return strings.SplitN(snap, "@", 2)[0]
+}
+
+// parseOriginOutput parses the output of zfs list -H -o name,origin and
+// returns the origin snapshot of all the clones. Sample output:
+// $ zfs list -H -o name,origin -t filesystem,volume -r zfspv-pool
+// zfspv-pool	-
+// zfspv-pool/pvc-1	-
+// zfspv-pool/pvc-2	zfspv-pool/pvc-1@snap-1
+func parseOriginOutput(out []byte) (map[string]string, error) {
+	origins := map[string]string{}
+	scanner := bufio.NewScanner(strings.NewReader(string(out)))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		items := strings.Split(line, "\t")
+		if len(items) != 2 {
+			return nil, fmt.Errorf("zfs: invalid origin output %q", line)
+		}
+		if items[1] != "-" && items[1] != "" {
+			origins[items[0]] = items[1]
+		}
+	}
+	return origins, scanner.Err()
+}
+
+// buildLineage computes the lineage of the dataset from the origins of all
+// the clones in the pool. A promoted clone simply shows up with the origin
+// reversed, so it needs no special handling.
+func buildLineage(dataset string, origins map[string]string) *Lineage {
+	l := &Lineage{
+		Dataset: dataset,
+		Origin:  origins[dataset],
+		Clones:  map[string][]string{},
+	}
+
+	// walk up the origin chain, guard against loops
+	seen := map[string]bool{dataset: true}
+	for snap := l.Origin; snap != ""; snap = origins[datasetOf(snap)] {
+		parent := datasetOf(snap)
+		if seen[parent] {
+			break
+		}
+		seen[parent] = true
+		l.Ancestors = append(l.Ancestors, snap)
+	}
+
+	// children of each dataset keyed by the origin snapshot
+	children := map[string][]string{}
+	for clone, snap := range origins {
+		children[datasetOf(snap)] = append(children[datasetOf(snap)], clone)
+		if datasetOf(snap) == dataset {
+			l.Clones[snap] = append(l.Clones[snap], clone)
+		}
+	}
+	for _, clones := range l.Clones {
+		sort.Strings(clones)
+	}
+
+	// depth first, the deepest clones have to be deleted first
+	visited := map[string]bool{dataset: true}
+	var walk func(ds string)
+	walk = func(ds string) {
+		kids := children[ds]
+		sort.Strings(kids)
+		for _, kid := range kids {
+			if visited[kid] {
+				continue
+			}
+			visited[kid] = true
+			walk(kid)
+			l.Dependents = append(l.Dependents, kid)
+		}
+	}
+	walk(dataset)
+
+	return l
+}
+
+// GetLineage returns the clone/origin relationship of the volume as per
+// the origin property of the datasets in its pool
+func GetLineage(vol *apis.ZFSVolume) (*Lineage, error) {
+	args := []string{ZFSListArg, "-H", "-o", "name,origin", "-t", "filesystem,volume", "-r", vol.Spec.PoolName}
+	out, err := zfsCommand(args...).Output()
+	if err != nil {
+		klog.Errorf("zfs: could not list the origins cmd %v error: %v", args, err)
+		return nil, err
+	}
+
+	origins, err := parseOriginOutput(out)
+	if err != nil {
+		return nil, err
+	}
+	return buildLineage(VolumeDataset(vol), origins), nil
+}
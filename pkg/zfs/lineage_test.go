@@ -0,0 +1,113 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"reflect"
+	"testing"
+)
+
+// pvc-b and pvc-c are clones of the snapshots of pvc-a and
+// pvc-d is a clone of the snapshot of pvc-b
+const originOutput = `pool	-
+pool/pvc-a	-
+pool/pvc-b	pool/pvc-a@s1
+pool/pvc-c	pool/pvc-a@s2
+pool/pvc-d	pool/pvc-b@s3
+pool/pvc-e	-
+`
+
+func TestParseOriginOutput(t *testing.T) {
+	origins, err := parseOriginOutput([]byte(originOutput))
+	if err != nil {
+		t.Fatalf("parseOriginOutput() unexpected error %v", err)
+	}
+	want := map[string]string{
+		"pool/pvc-b": "pool/pvc-a@s1",
+		"pool/pvc-c": "pool/pvc-a@s2",
+		"pool/pvc-d": "pool/pvc-b@s3",
+	}
+	if !reflect.DeepEqual(origins, want) {
+		t.Errorf("parseOriginOutput() = %v, want %v", origins, want)
+	}
+
+	if _, err := parseOriginOutput([]byte("pool/pvc-a\n")); err == nil {
+		t.Errorf("parseOriginOutput() expected error for malformed output")
+	}
+}
+
+func TestBuildLineage(t *testing.T) {
+	origins, _ := parseOriginOutput([]byte(originOutput))
+
+	root := buildLineage("pool/pvc-a", origins)
+	if root.Origin != "" || len(root.Ancestors) != 0 {
+		t.Errorf("root volume should not have an origin, got %+v", root)
+	}
+	wantClones := map[string][]string{
+		"pool/pvc-a@s1": {"pool/pvc-b"},
+		"pool/pvc-a@s2": {"pool/pvc-c"},
+	}
+	if !reflect.DeepEqual(root.Clones, wantClones) {
+		t.Errorf("Clones = %v, want %v", root.Clones, wantClones)
+	}
+	// pvc-d has to go before pvc-b
+	wantDeps := []string{"pool/pvc-d", "pool/pvc-b", "pool/pvc-c"}
+	if !reflect.DeepEqual(root.Dependents, wantDeps) {
+		t.Errorf("Dependents = %v, want %v", root.Dependents, wantDeps)
+	}
+
+	leaf := buildLineage("pool/pvc-d", origins)
+	if leaf.Origin != "pool/pvc-b@s3" {
+		t.Errorf("Origin = %s, want pool/pvc-b@s3", leaf.Origin)
+	}
+	wantAnc := []string{"pool/pvc-b@s3", "pool/pvc-a@s1"}
+	if !reflect.DeepEqual(leaf.Ancestors, wantAnc) {
+		t.Errorf("Ancestors = %v, want %v", leaf.Ancestors, wantAnc)
+	}
+	if len(leaf.Clones) != 0 || len(leaf.Dependents) != 0 {
+		t.Errorf("leaf volume should not have clones, got %+v", leaf)
+	}
+
+	standalone := buildLineage("pool/pvc-e", origins)
+	if standalone.Origin != "" || len(standalone.Dependents) != 0 {
+		t.Errorf("standalone volume should have no relationship, got %+v", standalone)
+	}
+}
+
+func TestBuildLineagePromoted(t *testing.T) {
+	// pvc-b has been promoted, pvc-a is now the clone of pvc-b@s1 and
+	// the other clones of the snapshots moved along with the snapshots
+	out := `pool/pvc-a	pool/pvc-b@s1
+pool/pvc-b	-
+pool/pvc-c	pool/pvc-a@s2
+`
+	origins, _ := parseOriginOutput([]byte(out))
+
+	promoted := buildLineage("pool/pvc-b", origins)
+	if promoted.Origin != "" {
+		t.Errorf("promoted clone should not have an origin, got %s", promoted.Origin)
+	}
+	wantDeps := []string{"pool/pvc-c", "pool/pvc-a"}
+	if !reflect.DeepEqual(promoted.Dependents, wantDeps) {
+		t.Errorf("Dependents = %v, want %v", promoted.Dependents, wantDeps)
+	}
+
+	old := buildLineage("pool/pvc-a", origins)
+	if old.Origin != "pool/pvc-b@s1" {
+		t.Errorf("Origin = %s, want pool/pvc-b@s1", old.Origin)
+	}
+}
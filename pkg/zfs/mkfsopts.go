@@ -0,0 +1,185 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"k8s.io/klog/v2"
+)
+
+// mkfsFlag is an option of mkfs which may be passed by the storage class.
+// Its value is a list of suboptions, e.g. su=64k,sw=4, if keys is set and
+// a single value matching value otherwise. It takes no value if both are
+// unset.
+type mkfsFlag struct {
+	keys  []string
+	value *regexp.Regexp
+}
+
+var (
+	mkfsNumber  = regexp.MustCompile(`^[0-9]+[kKmMgG]?$`)
+	mkfsLabel   = regexp.MustCompile(`^[A-Za-z0-9._-]{1,16}$`)
+	mkfsList    = regexp.MustCompile(`^\^?[a-z0-9_]+(,\^?[a-z0-9_]+)*$`)
+	mkfsProfile = regexp.MustCompile(`^(single|dup|raid0|raid1|raid1c3|raid1c4|raid10|raid5|raid6)$`)
+	// mkfsSubValue is the value of a suboption, e.g. 64k
+	mkfsSubValue = regexp.MustCompile(`^[A-Za-z0-9.]+$`)
+)
+
+// the options of mkfs which can be set, the ones formatting another
+// device, reading a file or taking a path are left out
+var (
+	extMkfsFlags = map[string]mkfsFlag{
+		"-b": {value: mkfsNumber},
+		"-i": {value: mkfsNumber},
+		"-I": {value: mkfsNumber},
+		"-N": {value: mkfsNumber},
+		"-m": {value: mkfsNumber},
+		"-g": {value: mkfsNumber},
+		"-G": {value: mkfsNumber},
+		"-L": {value: mkfsLabel},
+		"-T": {value: mkfsList},
+		"-O": {value: mkfsList},
+		"-E": {keys: []string{"stride", "stripe_width", "stripe-width", "lazy_itable_init",
+			"lazy_journal_init", "packed_meta_blocks", "num_backup_sb", "resize", "discard", "nodiscard"}},
+		"-J": {keys: []string{"size"}},
+		"-j": {},
+	}
+	xfsMkfsFlags = map[string]mkfsFlag{
+		"-b": {keys: []string{"size"}},
+		"-d": {keys: []string{"su", "sw", "sunit", "swidth", "agcount", "agsize"}},
+		"-i": {keys: []string{"size", "maxpct", "align", "sparse"}},
+		"-l": {keys: []string{"size", "su", "sunit", "lazy-count", "version"}},
+		"-n": {keys: []string{"size", "ftype"}},
+		"-m": {keys: []string{"crc", "finobt", "reflink", "rmapbt", "bigtime", "inobtcount"}},
+		"-s": {keys: []string{"size"}},
+		"-L": {value: mkfsLabel},
+	}
+	btrfsMkfsFlags = map[string]mkfsFlag{
+		"-n": {value: mkfsNumber},
+		"-s": {value: mkfsNumber},
+		"-m": {value: mkfsProfile},
+		"-d": {value: mkfsProfile},
+		"-O": {value: mkfsList},
+		"-R": {value: mkfsList},
+		"-L": {value: mkfsLabel},
+	}
+	mkfsFlags = map[string]map[string]mkfsFlag{
+		"ext2":  extMkfsFlags,
+		"ext3":  extMkfsFlags,
+		"ext4":  extMkfsFlags,
+		"xfs":   xfsMkfsFlags,
+		"btrfs": btrfsMkfsFlags,
+	}
+)
+
+// validSubOptions tells whether the value is a list of the suboptions,
+// e.g. su=64k,sw=4
+func validSubOptions(value string, keys []string) bool {
+	for _, opt := range strings.Split(value, ",") {
+		kv := strings.SplitN(opt, "=", 2)
+		if !isOneOf(kv[0], keys) || (len(kv) == 2 && !mkfsSubValue.MatchString(kv[1])) {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseMkfsOptions returns the arguments of the mkfs options of the
+// storage class, e.g. "-E stride=16,stripe_width=64". Only the options of
+// the allowlist of the filesystem are accepted, each followed by its
+// value if it takes one.
+func ParseMkfsOptions(fstype, options string) ([]string, error) {
+	fields := strings.Fields(options)
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	flags, ok := mkfsFlags[fstype]
+	if !ok {
+		return nil, fmt.Errorf("invalid mkfsoptions %q, the mkfs options can not be set for fstype %s", options, fstype)
+	}
+	for i := 0; i < len(fields); i++ {
+		flag, ok := flags[fields[i]]
+		if !ok {
+			return nil, fmt.Errorf("invalid mkfsoptions %q, option %s is not allowed for %s", options, fields[i], fstype)
+		}
+		if flag.keys == nil && flag.value == nil {
+			continue
+		}
+		if i+1 == len(fields) {
+			return nil, fmt.Errorf("invalid mkfsoptions %q, option %s needs a value", options, fields[i])
+		}
+		i++
+		value := fields[i]
+		if (flag.keys != nil && !validSubOptions(value, flag.keys)) ||
+			(flag.value != nil && !flag.value.MatchString(value)) {
+			return nil, fmt.Errorf("invalid mkfsoptions %q, invalid value %q of option %s",
+				options, value, fields[i-1])
+		}
+	}
+	return fields, nil
+}
+
+// mkfsArgs returns the arguments of mkfs formatting the device with the
+// options of the storage class, after the defaults of the mounter so that
+// they override them. The blocks are not discarded for a preallocated
+// zvol, mkfs would otherwise free them. It is nil for a filesystem left to
+// the mounter.
+func mkfsArgs(fstype, devicePath string, nodiscard bool, options []string) []string {
+	var args []string
+	switch fstype {
+	case "ext2", "ext3", "ext4":
+		args = []string{"-F", "-m0"}
+		if nodiscard {
+			args = append(args, "-E", "nodiscard")
+		}
+	case "xfs", "btrfs":
+		if nodiscard {
+			args = append(args, "-K")
+		}
+	default:
+		return nil
+	}
+	if !nodiscard && len(options) == 0 {
+		return nil
+	}
+	args = append(args, options...)
+	return append(args, devicePath)
+}
+
+// formatZvol formats a zvol which has no filesystem yet with the mkfs
+// options of the volume, without discarding the blocks of a preallocated
+// zvol. A zvol which has already been formatted is left to the mounter,
+// so the options only apply to the first format.
+func formatZvol(devicePath, fstype string, nodiscard bool, mkfsOptions string) error {
+	options, err := ParseMkfsOptions(fstype, mkfsOptions)
+	if err != nil {
+		return err
+	}
+	args := mkfsArgs(fstype, devicePath, nodiscard, options)
+	if args == nil {
+		return nil
+	}
+	format, err := diskFormat(devicePath)
+	if err != nil || format != "" {
+		return err
+	}
+	klog.Infof("zfs: formatting zvol %s as %s with %v", devicePath, fstype, args)
+	return runMkfs("mkfs."+fstype, args...)
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMkfsOptions(t *testing.T) {
+	valid := []struct {
+		fstype, options string
+		want            []string
+	}{
+		{"ext4", "", nil},
+		{"ext4", "-i 65536 -E stride=16,stripe_width=64", []string{"-i", "65536", "-E", "stride=16,stripe_width=64"}},
+		{"ext4", "-b 4096 -O ^has_journal,metadata_csum -m 1 -L data", []string{"-b", "4096", "-O", "^has_journal,metadata_csum", "-m", "1", "-L", "data"}},
+		{"ext3", "-j -J size=64", []string{"-j", "-J", "size=64"}},
+		{"xfs", "-d su=64k,sw=4 -m reflink=1", []string{"-d", "su=64k,sw=4", "-m", "reflink=1"}},
+		{"btrfs", "-m dup -d single -n 16k", []string{"-m", "dup", "-d", "single", "-n", "16k"}},
+	}
+	for _, tt := range valid {
+		got, err := ParseMkfsOptions(tt.fstype, tt.options)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseMkfsOptions(%s, %q) = %v, %v, want %v", tt.fstype, tt.options, got, err, tt.want)
+		}
+	}
+
+	invalid := []struct{ fstype, options string }{
+		// options outside of the allowlist
+		{"ext4", "-d /etc"},
+		{"ext4", "-c"},
+		{"xfs", "-f"},
+		{"ext4", "/dev/sda"},
+		{"ext4", "-E root_owner=0:0"},
+		{"xfs", "-d file=1,name=/etc/passwd"},
+		// injected values
+		{"ext4", "-L data;reboot"},
+		{"ext4", "-i $(id)"},
+		{"xfs", "-d su=64k,sw=`id`"},
+		{"btrfs", "-m raid7"},
+		// missing value
+		{"ext4", "-E"},
+		// filesystems without an allowlist
+		{"zfs", "-E stride=16"},
+		{"vfat", "-F 32"},
+	}
+	for _, tt := range invalid {
+		if _, err := ParseMkfsOptions(tt.fstype, tt.options); err == nil {
+			t.Errorf("ParseMkfsOptions(%s, %q) succeeded", tt.fstype, tt.options)
+		}
+	}
+}
+
+func TestMkfsArgs(t *testing.T) {
+	dev := "/dev/zvol/zfspv/pvc-1"
+	tests := []struct {
+		fstype    string
+		nodiscard bool
+		options   []string
+		want      []string
+	}{
+		{"ext4", false, nil, nil},
+		{"ext4", true, nil, []string{"-F", "-m0", "-E", "nodiscard", dev}},
+		{"ext4", false, []string{"-m", "1"}, []string{"-F", "-m0", "-m", "1", dev}},
+		{"xfs", false, []string{"-d", "su=64k,sw=4"}, []string{"-d", "su=64k,sw=4", dev}},
+		{"xfs", true, []string{"-d", "su=64k"}, []string{"-K", "-d", "su=64k", dev}},
+		{"vfat", true, nil, nil},
+	}
+	for _, tt := range tests {
+		if got := mkfsArgs(tt.fstype, dev, tt.nodiscard, tt.options); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("mkfsArgs(%s, %v, %v) = %v, want %v", tt.fstype, tt.nodiscard, tt.options, got, tt.want)
+		}
+	}
+}
+
+func TestFormatZvolOptions(t *testing.T) {
+	origFormat, origMkfs := diskFormat, runMkfs
+	t.Cleanup(func() { diskFormat, runMkfs = origFormat, origMkfs })
+	format := ""
+	diskFormat = func(string) (string, error) { return format, nil }
+	var ran []string
+	runMkfs = func(bin string, args ...string) error {
+		ran = append(append(ran, bin), args...)
+		return nil
+	}
+
+	if err := formatZvol("/dev/zvol/zfspv/pvc-1", "xfs", false, "-d su=64k,sw=4"); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"mkfs.xfs", "-d", "su=64k,sw=4", "/dev/zvol/zfspv/pvc-1"}
+	if !reflect.DeepEqual(ran, want) {
+		t.Errorf("mkfs = %v, want %v", ran, want)
+	}
+
+	// the options only apply to the first format, not to a restage
+	ran, format = nil, "xfs"
+	if err := formatZvol("/dev/zvol/zfspv/pvc-1", "xfs", false, "-d su=64k,sw=4"); err != nil || ran != nil {
+		t.Errorf("formatted zvol: mkfs = %v, error %v", ran, err)
+	}
+
+	// the options are checked again on the node
+	ran, format = nil, ""
+	if err := formatZvol("/dev/zvol/zfspv/pvc-1", "ext4", false, "-d /etc"); err == nil || ran != nil {
+		t.Errorf("invalid options: mkfs = %v, error %v", ran, err)
+	}
+}
@@ -16,10 +16,13 @@ limitations under the License.
 package zfs
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
+	"strings"
 
 	mnt "github.com/openebs/lib-csi/pkg/mount"
 	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
@@ -100,6 +103,12 @@ func UmountVolume(vol *apis.ZFSVolume, targetPath string,
 		return nil
 	}
 
+	// the child datasets are mounted below the volume
+	if err = unmountLayout(mounter, vol, targetPath); err != nil {
+		klog.Errorf("zfs: failed to unmount the children of %s: path %s err: %v", vol.Name, targetPath, err)
+		return err
+	}
+
 	if err = mounter.Unmount(targetPath); err != nil {
 		klog.Errorf(
 			"zfs: failed to unmount %s: path %s err: %v",
@@ -108,13 +117,22 @@ func UmountVolume(vol *apis.ZFSVolume, targetPath string,
 		return err
 	}
 
-	if err = SetDatasetLegacyMount(vol); err != nil {
-		// ignoring the failure as the volume has already
-		// been umounted, now the new pod can mount it
-		klog.Warningf(
-			"zfs: failed to set legacy mountpoint: %s err: %v",
-			vol.Name, err,
-		)
+	// the shared dataset stays mounted and shared at its host mount path
+	// while another pod mounts it
+	if !mountedByPods(vol) {
+		if IsShared(vol) {
+			if err = UnshareVolume(vol); err != nil {
+				klog.Warningf("zfs: failed to unshare %s: %v", vol.Name, err)
+			}
+		}
+		if err = SetDatasetLegacyMount(vol); err != nil {
+			// ignoring the failure as the volume has already
+			// been umounted, now the new pod can mount it
+			klog.Warningf(
+				"zfs: failed to set legacy mountpoint: %s err: %v",
+				vol.Name, err,
+			)
+		}
 	}
 
 	if err := os.Remove(targetPath); err != nil {
@@ -126,6 +144,54 @@ func UmountVolume(vol *apis.ZFSVolume, targetPath string,
 	return nil
 }
 
+// CleanupMountPoint unmounts everything mounted on the path or below it as
+// per the mount table and removes the path. It is idempotent, a path which
+// is not mounted or does not exist anymore is treated as success.
+func CleanupMountPoint(path string) error {
+	return cleanupMountPoint(mount.New(""), path)
+}
+
+func cleanupMountPoint(mounter mount.Interface, path string) error {
+	path = filepath.Clean(path)
+
+	mps, err := mounter.List()
+	if err != nil {
+		return fmt.Errorf("failed to list the mounts: %v", err)
+	}
+
+	// unmount the deepest mounts first, a path can have stacked mounts
+	var stale []string
+	for _, mp := range mps {
+		if mp.Path == path || strings.HasPrefix(mp.Path, path+"/") {
+			stale = append(stale, mp.Path)
+		}
+	}
+	sort.Slice(stale, func(i, j int) bool {
+		return len(stale[i]) > len(stale[j])
+	})
+
+	for _, mp := range stale {
+		if err := mounter.Unmount(mp); err != nil {
+			// might have been unmounted along with the parent
+			if notMnt, cerr := mounter.IsLikelyNotMountPoint(mp); cerr == nil && notMnt {
+				continue
+			} else if os.IsNotExist(cerr) {
+				continue
+			}
+			return fmt.Errorf("failed to unmount %s: %v", mp, err)
+		}
+		klog.Infof("unmounted stale mount %s", mp)
+		if mp != path {
+			_ = os.Remove(mp)
+		}
+	}
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove %s: %v", path, err)
+	}
+	return nil
+}
+
 func verifyMountRequest(vol *apis.ZFSVolume, mountpath string) (bool, error) {
 	if len(mountpath) == 0 {
 		return false, status.Error(codes.InvalidArgument, "verifyMount: mount path missing in request")
@@ -154,6 +220,8 @@ func verifyMountRequest(vol *apis.ZFSVolume, mountpath string) (bool, error) {
 	 * operation.
 	 */
 	currentMounts, err := mnt.GetMounts(devicePath)
+	// the dataset stays mounted at its host mountpoint
+	currentMounts = withoutHostMountPoint(vol, currentMounts)
 	if err != nil {
 		klog.Errorf("can not get mounts for volume:%s dev %s err: %v",
 			vol.Name, devicePath, err.Error())
@@ -180,7 +248,7 @@ func verifyMountRequest(vol *apis.ZFSVolume, mountpath string) (bool, error) {
 
 // MountZvol mounts the disk to the specified path
 func MountZvol(vol *apis.ZFSVolume, mount *MountInfo) error {
-	volume := vol.Spec.PoolName + "/" + vol.Name
+	volume := VolumeDataset(vol)
 	mounted, err := verifyMountRequest(vol, mount.MountPath)
 	if err != nil {
 		return err
@@ -193,6 +261,21 @@ func MountZvol(vol *apis.ZFSVolume, mount *MountInfo) error {
 
 	devicePath := ZFSDevPath + volume
 
+	// the mounter formats the zvol with its defaults, a preallocated zvol
+	// or one having mkfs options is formatted first
+	if vol.Spec.Preallocate == "yes" || vol.Spec.MkfsOptions != "" {
+		fstype := mount.FSType
+		if fstype == "" {
+			fstype = vol.Spec.FsType
+		}
+		if fstype == "" {
+			fstype = DefaultFsType
+		}
+		if err = formatZvol(devicePath, fstype, vol.Spec.Preallocate == "yes", vol.Spec.MkfsOptions); err != nil {
+			return status.Errorf(codes.Internal, "not able to format the zvol: %v", err)
+		}
+	}
+
 	err = FormatAndMountZvol(devicePath, mount)
 	if err != nil {
 		return status.Error(codes.Internal, "not able to format and mount the zvol")
@@ -205,7 +288,7 @@ func MountZvol(vol *apis.ZFSVolume, mount *MountInfo) error {
 
 // MountDataset mounts the zfs dataset to the specified path
 func MountDataset(vol *apis.ZFSVolume, mount *MountInfo) error {
-	volume := vol.Spec.PoolName + "/" + vol.Name
+	volume := VolumeDataset(vol)
 	mounted, err := verifyMountRequest(vol, mount.MountPath)
 	if err != nil {
 		return err
@@ -213,7 +296,20 @@ func MountDataset(vol *apis.ZFSVolume, mount *MountInfo) error {
 
 	if mounted {
 		klog.Infof("dataset : already mounted %s => %s", volume, mount.MountPath)
-		return nil
+		// the children may not have been mounted by the earlier call
+		if err = mountLayout(vol, mount); err != nil {
+			return err
+		}
+		return shareDataset(vol)
+	}
+
+	// the dataset mounted by zfs at a path of the node is bind mounted on
+	// the target path, zfs only shares the datasets it has mounted itself
+	if hostMountPath(vol) != "" {
+		if err = mountHostDataset(vol, mount); err != nil {
+			return err
+		}
+		return shareDataset(vol)
 	}
 
 	val, err := GetVolumeProperty(vol, "mountpoint")
@@ -251,9 +347,56 @@ func MountDataset(vol *apis.ZFSVolume, mount *MountInfo) error {
 		klog.Infof("dataset : mounted %s => %s", volume, mount.MountPath)
 	}
 
+	if err = mountLayout(vol, mount); err != nil {
+		return err
+	}
+	return shareDataset(vol)
+}
+
+// shareDataset shares the mounted dataset if it asks for it
+func shareDataset(vol *apis.ZFSVolume) error {
+	if !IsShared(vol) {
+		return nil
+	}
+	if err := ShareVolume(vol); err != nil {
+		if errors.Is(err, ErrShareServiceUnavailable) {
+			return status.Error(codes.FailedPrecondition, err.Error())
+		}
+		return status.Errorf(codes.Internal, "dataset: share failed err : %s", err.Error())
+	}
 	return nil
 }
 
+// readOnlyMountOptions makes the mount options consistent with the readonly
+// property of the volume, "ro" is added for the read-only volume
+func readOnlyMountOptions(vol *apis.ZFSVolume, options []string) []string {
+	if !IsReadOnly(vol) {
+		return options
+	}
+	opts := make([]string, 0, len(options)+1)
+	for _, o := range options {
+		// rw would override the ro option
+		if o != "rw" && o != "ro" {
+			opts = append(opts, o)
+		}
+	}
+	return append(opts, "ro")
+}
+
+// readOnlyMount tells whether the volume is mounted read-only, by its
+// readonly property or by the ro option of the mount
+func readOnlyMount(vol *apis.ZFSVolume, mnt *MountInfo) bool {
+	if IsReadOnly(vol) {
+		return true
+	}
+	for _, o := range mnt.MountOptions {
+		if o == "ro" || o == "readonly" {
+			return true
+		}
+	}
+	return false
+}
+
 // MountFilesystem mounts the disk to the specified path
 func MountFilesystem(vol *apis.ZFSVolume, mount *MountInfo) error {
 	// creating the directory with 0750 permission so that it can be accessed by other person.
@@ -262,6 +405,9 @@ func MountFilesystem(vol *apis.ZFSVolume, mount *MountInfo) error {
 		return status.Errorf(codes.Internal, "Could not create dir {%q}, err: %v", mount.MountPath, err)
 	}
 
+	// a read-only volume is always mounted read-only
+	mount.MountOptions = readOnlyMountOptions(vol, mount.MountOptions)
+
 	switch vol.Spec.VolumeType {
 	case VolTypeDataset:
 		return MountDataset(vol, mount)
@@ -273,8 +419,8 @@ func MountFilesystem(vol *apis.ZFSVolume, mount *MountInfo) error {
 // MountBlock mounts the block disk to the specified path
 func MountBlock(vol *apis.ZFSVolume, mountinfo *MountInfo) error {
 	target := mountinfo.MountPath
-	devicePath := ZFSDevPath + vol.Spec.PoolName + "/" + vol.Name
-	mountopt := []string{"bind"}
+	devicePath := ZFSDevPath + VolumeDataset(vol)
+	mountopt := readOnlyMountOptions(vol, []string{"bind"})
 
 	mounter := &mount.SafeFormatAndMount{Interface: mount.New(""), Exec: utilexec.New()}
 
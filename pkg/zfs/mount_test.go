@@ -0,0 +1,109 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"k8s.io/utils/mount"
+)
+
+func TestCleanupMountPoint(t *testing.T) {
+	tests := []struct {
+		name      string
+		createDir bool
+		mounts    func(path string) []mount.MountPoint
+	}{
+		{"already unmounted", true, func(string) []mount.MountPoint { return nil }},
+		{"dataset missing", false, func(string) []mount.MountPoint { return nil }},
+		{"mounted", true, func(path string) []mount.MountPoint {
+			return []mount.MountPoint{{Device: "zfspv/pvc-1", Path: path, Type: "zfs"}}
+		}},
+		{"partially mounted with a lingering bind mount", true, func(path string) []mount.MountPoint {
+			return []mount.MountPoint{
+				{Device: "/dev/zd0", Path: filepath.Join(path, "bind"), Type: "ext4"},
+				{Device: "/dev/sda1", Path: "/other", Type: "ext4"},
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "staging")
+			if tt.createDir {
+				if err := os.MkdirAll(path, 0750); err != nil {
+					t.Fatal(err)
+				}
+			}
+			mps := tt.mounts(path)
+			for _, mp := range mps {
+				if tt.createDir && filepath.Dir(mp.Path) == path {
+					if err := os.Mkdir(mp.Path, 0750); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			mounter := mount.NewFakeMounter(mps)
+
+			if err := cleanupMountPoint(mounter, path); err != nil {
+				t.Fatalf("cleanupMountPoint() unexpected error %v", err)
+			}
+
+			mps, _ = mounter.List()
+			for _, mp := range mps {
+				if mp.Path != "/other" {
+					t.Errorf("mount %s not cleaned up", mp.Path)
+				}
+			}
+			if _, err := os.Stat(path); !os.IsNotExist(err) {
+				t.Errorf("path %s not removed, err %v", path, err)
+			}
+
+			// calling it again is a no-op
+			if err := cleanupMountPoint(mounter, path); err != nil {
+				t.Errorf("cleanupMountPoint() second call unexpected error %v", err)
+			}
+		})
+	}
+}
+
+func TestReadOnlyMountOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		readonly string
+		options  []string
+		want     []string
+	}{
+		{"writable volume keeps the options", "no", []string{"noatime", "rw"}, []string{"noatime", "rw"}},
+		{"read-only volume adds ro", "yes", []string{"noatime"}, []string{"noatime", "ro"}},
+		{"read-only volume drops rw", "yes", []string{"rw", "noatime"}, []string{"noatime", "ro"}},
+		{"ro is not repeated", "yes", []string{"ro"}, []string{"ro"}},
+		{"bind mount of a read-only zvol", "yes", []string{"bind"}, []string{"bind", "ro"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vol := &apis.ZFSVolume{}
+			vol.Spec.ReadOnly = tt.readonly
+			if got := readOnlyMountOptions(vol, tt.options); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readOnlyMountOptions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"golang.org/x/sys/unix"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/mount"
+)
+
+// what the node agent does when the parent directory of the target path
+// is missing, it is created by the kubelet and may have been removed,
+// e.g. by a cleanup of the pod directories
+const (
+	// MissingParentCreate creates the missing parent directories
+	MissingParentCreate = "create"
+	// MissingParentFail fails the publish, the kubelet retries it once
+	// it has created the directory again
+	MissingParentFail = "fail"
+)
+
+// ValidateMissingParentPolicy returns an error if the policy is not one of
+// MissingParentCreate and MissingParentFail
+func ValidateMissingParentPolicy(policy string) error {
+	switch policy {
+	case MissingParentCreate, MissingParentFail:
+		return nil
+	}
+	return fmt.Errorf("invalid missing target parent policy %q, it should be %s or %s",
+		policy, MissingParentCreate, MissingParentFail)
+}
+
+// deviceNumber returns the device number of a device node, a file the
+// device node is bind mounted on has the same one
+var deviceNumber = func(path string) (uint64, error) {
+	var st unix.Stat_t
+	if err := unix.Stat(path, &st); err != nil {
+		return 0, err
+	}
+	return uint64(st.Rdev), nil
+}
+
+// PrepareTargetParent makes sure the parent directory of the target path
+// exists. A missing one is created with the permissions the kubelet gives
+// the volume directories, or fails the mount with the MissingParentFail
+// policy.
+func PrepareTargetParent(target, policy string) error {
+	parent := filepath.Dir(filepath.Clean(target))
+	fi, err := os.Stat(parent)
+	switch {
+	case err == nil && fi.IsDir():
+		return nil
+	case err == nil:
+		return status.Errorf(codes.FailedPrecondition, "parent %s of target %s is not a directory", parent, target)
+	case !os.IsNotExist(err):
+		return status.Errorf(codes.Internal, "could not stat parent %s of target %s: %v", parent, target, err)
+	}
+	if policy == MissingParentFail {
+		return status.Errorf(codes.FailedPrecondition, "parent directory %s of target %s is missing", parent, target)
+	}
+	if err := os.MkdirAll(parent, 0750); err != nil {
+		return status.Errorf(codes.Internal, "could not create parent directory %s of target %s: %v", parent, target, err)
+	}
+	klog.Infof("zfs: created the missing parent directory %s of target %s", parent, target)
+	return nil
+}
+
+// CheckTargetMount returns an error if the target path is already the
+// mount of another volume, e.g. of a pod which has not been cleaned up.
+// The mount of the volume itself, by an earlier call, is accepted.
+func CheckTargetMount(vol *apis.ZFSVolume, target string) error {
+	return checkTargetMount(mount.New(""), vol, target)
+}
+
+func checkTargetMount(mounter mount.Interface, vol *apis.ZFSVolume, target string) error {
+	mps, err := mounter.List()
+	if err != nil {
+		return status.Errorf(codes.Internal, "could not list the mounts: %v", err)
+	}
+	// the last mount on the target is the one in use
+	source, found := "", false
+	for _, mp := range mps {
+		if mp.Path == target {
+			source, found = mp.Device, true
+		}
+	}
+	if !found || isVolumeMount(vol, source, target) {
+		return nil
+	}
+	klog.Errorf("zfs: target %s of volume %s is already a mount of %s", target, vol.Name, source)
+	return status.Errorf(codes.FailedPrecondition,
+		"target %s is already a mount of %s, not of volume %s", target, source, vol.Name)
+}
+
+// isVolumeMount tells whether the mount of the source on the target is the
+// volume. A dataset, also bind mounted from its host mountpoint, is the
+// source of its mounts and a zvol formatted with a filesystem is mounted
+// from its device. A zvol bind mounted as a block device has the source
+// of the devtmpfs, it is told by the device number of the target.
+func isVolumeMount(vol *apis.ZFSVolume, source, target string) bool {
+	if vol.Spec.VolumeType == VolTypeDataset {
+		return source == VolumeDataset(vol)
+	}
+	devicePath := ZFSDevPath + VolumeDataset(vol)
+	if source == devicePath {
+		return true
+	}
+	if resolved, err := resolveDevicePath(devicePath); err == nil && source == resolved {
+		return true
+	}
+	dev, err := deviceNumber(devicePath)
+	if err != nil {
+		return false
+	}
+	targetDev, err := deviceNumber(target)
+	return err == nil && dev != 0 && dev == targetDev
+}
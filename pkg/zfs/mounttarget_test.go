@@ -0,0 +1,146 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/utils/mount"
+)
+
+func TestPrepareTargetParent(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "pods", "uid", "volumes", "pvc-1", "mount")
+
+	err := PrepareTargetParent(target, MissingParentFail)
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("missing parent with the fail policy: got %v, want FailedPrecondition", err)
+	}
+	if _, err := os.Stat(filepath.Dir(target)); !os.IsNotExist(err) {
+		t.Errorf("parent created with the fail policy: %v", err)
+	}
+
+	if err := PrepareTargetParent(target, MissingParentCreate); err != nil {
+		t.Fatalf("missing parent with the create policy: %v", err)
+	}
+	fi, err := os.Stat(filepath.Dir(target))
+	if err != nil || !fi.IsDir() {
+		t.Fatalf("parent not created: %v", err)
+	}
+	if perm := fi.Mode().Perm(); perm&^0750 != 0 {
+		t.Errorf("parent created with %v, want at most 0750", perm)
+	}
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Errorf("target itself created: %v", err)
+	}
+	// the existing parent is left as it is
+	if err := PrepareTargetParent(target, MissingParentFail); err != nil {
+		t.Errorf("existing parent: %v", err)
+	}
+
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := PrepareTargetParent(filepath.Join(file, "mount"), MissingParentCreate); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("parent is a file: got %v, want FailedPrecondition", err)
+	}
+
+	if err := ValidateMissingParentPolicy("ignore"); err == nil {
+		t.Error("invalid policy accepted")
+	}
+}
+
+func TestCheckTargetMount(t *testing.T) {
+	defer func(f func(string) (string, error)) { resolveDevicePath = f }(resolveDevicePath)
+	defer func(f func(string) (uint64, error)) { deviceNumber = f }(deviceNumber)
+
+	resolveDevicePath = func(path string) (string, error) {
+		if path == "/dev/zvol/zfspv/pvc-zvol" {
+			return "/dev/zd0", nil
+		}
+		return path, nil
+	}
+	// the block target of pvc-block is bind mounted from /dev/zd16
+	devices := map[string]uint64{
+		"/dev/zvol/zfspv/pvc-zvol":  230,
+		"/dev/zvol/zfspv/pvc-block": 246,
+		"/mnt/block":                246,
+		"/mnt/other-block":          230,
+	}
+	deviceNumber = func(path string) (uint64, error) { return devices[path], nil }
+
+	dataset := &apis.ZFSVolume{}
+	dataset.Name = "pvc-ds"
+	dataset.Spec.PoolName = "zfspv"
+	dataset.Spec.VolumeType = VolTypeDataset
+	zvol := &apis.ZFSVolume{}
+	zvol.Name = "pvc-zvol"
+	zvol.Spec.PoolName = "zfspv"
+	zvol.Spec.VolumeType = VolTypeZVol
+	block := &apis.ZFSVolume{}
+	block.Name = "pvc-block"
+	block.Spec.PoolName = "zfspv"
+	block.Spec.VolumeType = VolTypeZVol
+
+	tests := []struct {
+		name   string
+		vol    *apis.ZFSVolume
+		target string
+		mounts []mount.MountPoint
+		want   codes.Code
+	}{
+		{"not mounted", dataset, "/mnt/fs", []mount.MountPoint{
+			{Device: "zfspv/pvc-other", Path: "/mnt/other"},
+		}, codes.OK},
+		{"dataset mounted", dataset, "/mnt/fs", []mount.MountPoint{
+			{Device: "zfspv/pvc-ds", Path: "/mnt/fs"},
+		}, codes.OK},
+		{"another dataset mounted", dataset, "/mnt/fs", []mount.MountPoint{
+			{Device: "zfspv/pvc-other", Path: "/mnt/fs"},
+		}, codes.FailedPrecondition},
+		{"zvol mounted from its device", zvol, "/mnt/fs", []mount.MountPoint{
+			{Device: "/dev/zd0", Path: "/mnt/fs"},
+		}, codes.OK},
+		{"another zvol mounted", zvol, "/mnt/fs", []mount.MountPoint{
+			{Device: "/dev/zd16", Path: "/mnt/fs"},
+		}, codes.FailedPrecondition},
+		{"last mount is another volume", dataset, "/mnt/fs", []mount.MountPoint{
+			{Device: "zfspv/pvc-ds", Path: "/mnt/fs"},
+			{Device: "zfspv/pvc-other", Path: "/mnt/fs"},
+		}, codes.FailedPrecondition},
+		{"block device bind mounted", block, "/mnt/block", []mount.MountPoint{
+			{Device: "udev", Path: "/mnt/block"},
+		}, codes.OK},
+		{"another block device bind mounted", block, "/mnt/other-block", []mount.MountPoint{
+			{Device: "udev", Path: "/mnt/other-block"},
+		}, codes.FailedPrecondition},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mounter := mount.NewFakeMounter(tt.mounts)
+			if got := status.Code(checkTargetMount(mounter, tt.vol, tt.target)); got != tt.want {
+				t.Errorf("CheckTargetMount() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"context"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	k8serror "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+// NodeLeaseDuration is the time the lease of a node agent stays fresh, the
+// agent renews it 4 times within it
+const NodeLeaseDuration = 40 * time.Second
+
+// nodeLeaseName is the name of the lease of the agent of the node, in the
+// openebs namespace
+func nodeLeaseName(nodeid string) string {
+	return "zfs-node-" + nodeid
+}
+
+// RenewNodeLease creates the lease of the agent of the node, or renews it
+// if it exists
+func RenewNodeLease(cs kubernetes.Interface, nodeid string, now time.Time) error {
+	leases := cs.CoordinationV1().Leases(OpenEBSNamespace)
+	duration := int32(NodeLeaseDuration / time.Second)
+	renew := metav1.NewMicroTime(now)
+
+	lease, err := leases.Get(context.TODO(), nodeLeaseName(nodeid), metav1.GetOptions{})
+	if k8serror.IsNotFound(err) {
+		lease = &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      nodeLeaseName(nodeid),
+				Namespace: OpenEBSNamespace,
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &nodeid,
+				LeaseDurationSeconds: &duration,
+				AcquireTime:          &renew,
+				RenewTime:            &renew,
+			},
+		}
+		_, err = leases.Create(context.TODO(), lease, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	lease.Spec.HolderIdentity = &nodeid
+	lease.Spec.LeaseDurationSeconds = &duration
+	lease.Spec.RenewTime = &renew
+	_, err = leases.Update(context.TODO(), lease, metav1.UpdateOptions{})
+	return err
+}
+
+// RunNodeLease renews the lease of the agent of the node until stopCh is
+// closed
+func RunNodeLease(cs kubernetes.Interface, nodeid string, stopCh <-chan struct{}) {
+	wait.Until(func() {
+		if err := RenewNodeLease(cs, nodeid, time.Now()); err != nil {
+			klog.Errorf("zfs: failed to renew the lease of node %s: %v", nodeid, err)
+		}
+	}, NodeLeaseDuration/4, stopCh)
+}
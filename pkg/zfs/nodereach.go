@@ -0,0 +1,155 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serror "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+// ErrNodeUnreachable is returned for a node whose agent has stopped
+// renewing its lease, e.g. during a reboot or a network partition, but for
+// less than the gone threshold. The node is expected to come back, the
+// provisioning is retried.
+var ErrNodeUnreachable = errors.New("node is unreachable")
+
+// ErrNodeGone is returned for a node which has been deleted or whose agent
+// has not renewed its lease for longer than the gone threshold. The volume
+// has to be placed on another node.
+var ErrNodeGone = errors.New("node is gone")
+
+// NodeUnreachable is how the controller waits for a node whose agent is
+// not alive before placing a volume on it
+type NodeUnreachable struct {
+	// Retries is the number of times the node is checked again, the wait
+	// doubles from Interval for each retry
+	Retries  int
+	Interval time.Duration
+	// GoneAfter is the time after which a node whose agent is not alive
+	// is considered gone
+	GoneAfter time.Duration
+}
+
+// nodeUnreachable is set by SetNodeUnreachable
+var nodeUnreachable = NodeUnreachable{Retries: 3, Interval: 2 * time.Second, GoneAfter: 5 * time.Minute}
+
+// SetNodeUnreachable validates and sets how the controller waits for the
+// nodes whose agent is not alive
+func SetNodeUnreachable(retries int, interval, goneAfter time.Duration) error {
+	if retries < 0 {
+		return fmt.Errorf("invalid node unreachable retries %d", retries)
+	}
+	if retries > 0 && interval <= 0 {
+		return fmt.Errorf("invalid node unreachable retry interval %v", interval)
+	}
+	if goneAfter <= 0 {
+		return fmt.Errorf("invalid node gone threshold %v", goneAfter)
+	}
+	nodeUnreachable = NodeUnreachable{Retries: retries, Interval: interval, GoneAfter: goneAfter}
+	return nil
+}
+
+// classifyNode returns nil if the lease of the agent of the node is fresh,
+// ErrNodeUnreachable if it has expired and ErrNodeGone if it has expired
+// for longer than goneAfter. A node without a lease is given goneAfter from
+// its creation for its agent to start.
+func classifyNode(node *corev1.Node, lease *coordinationv1.Lease, now time.Time, goneAfter time.Duration) error {
+	expired := node.CreationTimestamp.Time
+	if lease != nil && lease.Spec.RenewTime != nil {
+		duration := NodeLeaseDuration
+		if lease.Spec.LeaseDurationSeconds != nil {
+			duration = time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second
+		}
+		expired = lease.Spec.RenewTime.Add(duration)
+		if now.Before(expired) {
+			return nil
+		}
+	}
+	if now.Sub(expired) >= goneAfter {
+		return ErrNodeGone
+	}
+	return ErrNodeUnreachable
+}
+
+// nodeID returns the nodeid of the kubernetes node
+func nodeID(node *corev1.Node) string {
+	nodeid, ok := node.Labels[ZFSTopologyKey]
+	if !ok {
+		// node is not labelled, use node name as nodeid
+		return node.Name
+	}
+	return nodeid
+}
+
+// checkNode fetches the node and the lease of its agent, it returns the
+// nodeid of the node and whether its agent is alive
+func checkNode(ctx context.Context, cs kubernetes.Interface, nodename string, now time.Time) (string, error) {
+	node, err := cs.CoreV1().Nodes().Get(ctx, nodename, metav1.GetOptions{})
+	if k8serror.IsNotFound(err) {
+		return "", ErrNodeGone
+	}
+	if err != nil {
+		return "", err
+	}
+	nodeid := nodeID(node)
+	lease, err := cs.CoordinationV1().Leases(OpenEBSNamespace).Get(ctx, nodeLeaseName(nodeid), metav1.GetOptions{})
+	if k8serror.IsNotFound(err) {
+		lease, err = nil, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return nodeid, classifyNode(node, lease, now, nodeUnreachable.GoneAfter)
+}
+
+// ReachableNodeID returns the nodeid of the node once its agent is alive,
+// i.e. renews its lease. A node whose agent is not alive is checked again
+// with a backoff, the returned error wraps ErrNodeUnreachable if it is
+// still not alive after the retries and ErrNodeGone if it is gone.
+func ReachableNodeID(ctx context.Context, cs kubernetes.Interface, nodename string) (string, error) {
+	wait := nodeUnreachable.Interval
+	for attempt := 0; ; attempt++ {
+		nodeid, err := checkNode(ctx, cs, nodename, time.Now())
+		switch {
+		case err == nil:
+			return nodeid, nil
+		case errors.Is(err, ErrNodeGone):
+			return "", fmt.Errorf("zfs: %s: %w", nodename, err)
+		case !errors.Is(err, ErrNodeUnreachable):
+			return "", fmt.Errorf("failed to get the node %s: %w", nodename, err)
+		}
+		if attempt >= nodeUnreachable.Retries {
+			return "", fmt.Errorf("zfs: %s: %w after %d retries", nodename, err, attempt)
+		}
+		klog.Warningf("zfs: the agent of node %s is not alive, retry %d/%d in %v", nodename, attempt+1, nodeUnreachable.Retries, wait)
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("zfs: %s: %w, %v", nodename, ErrNodeUnreachable, ctx.Err())
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
@@ -0,0 +1,210 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func kubeNode(name string, age time.Duration) *corev1.Node {
+	node := &corev1.Node{}
+	node.Name = name
+	node.Labels = map[string]string{ZFSTopologyKey: "nodeid-1"}
+	node.CreationTimestamp = metav1.NewTime(time.Now().Add(-age))
+	return node
+}
+
+// nodeLease returns the lease of nodeid-1 last renewed ago
+func nodeLease(ago time.Duration) *coordinationv1.Lease {
+	duration := int32(NodeLeaseDuration / time.Second)
+	renew := metav1.NewMicroTime(time.Now().Add(-ago))
+	lease := &coordinationv1.Lease{}
+	lease.Name = nodeLeaseName("nodeid-1")
+	lease.Namespace = OpenEBSNamespace
+	lease.Spec.LeaseDurationSeconds = &duration
+	lease.Spec.RenewTime = &renew
+	return lease
+}
+
+// fastRetries makes the retries of the unreachable nodes quick
+func fastRetries(t *testing.T) {
+	orig := nodeUnreachable
+	t.Cleanup(func() { nodeUnreachable = orig })
+	if err := SetNodeUnreachable(3, time.Millisecond, 5*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// countLeaseGets counts the gets of the leases, the fresh lease is
+// returned from the get number renewedAt on if it is not zero
+func countLeaseGets(cs *fake.Clientset, renewedAt int) *int {
+	gets := 0
+	cs.PrependReactor("get", "leases", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		gets++
+		if renewedAt > 0 && gets >= renewedAt {
+			return true, nodeLease(0), nil
+		}
+		return false, nil, nil
+	})
+	return &gets
+}
+
+func TestClassifyNode(t *testing.T) {
+	now := time.Now()
+	tests := map[string]struct {
+		node  *corev1.Node
+		lease *coordinationv1.Lease
+		want  error
+	}{
+		"lease fresh":                {node: kubeNode("node1", time.Hour), lease: nodeLease(10 * time.Second)},
+		"lease expired recently":     {node: kubeNode("node1", time.Hour), lease: nodeLease(time.Minute), want: ErrNodeUnreachable},
+		"lease expired for too long": {node: kubeNode("node1", time.Hour), lease: nodeLease(10 * time.Minute), want: ErrNodeGone},
+		"agent starting":             {node: kubeNode("node1", time.Minute), want: ErrNodeUnreachable},
+		"agent never started":        {node: kubeNode("node1", time.Hour), want: ErrNodeGone},
+	}
+	for name, tt := range tests {
+		if err := classifyNode(tt.node, tt.lease, now, 5*time.Minute); err != tt.want {
+			t.Errorf("%s: classifyNode() = %v, want %v", name, err, tt.want)
+		}
+	}
+}
+
+func TestRenewNodeLease(t *testing.T) {
+	cs := fake.NewSimpleClientset()
+	first := time.Now().Add(-time.Minute)
+	if err := RenewNodeLease(cs, "nodeid-1", first); err != nil {
+		t.Fatalf("RenewNodeLease() = %v", err)
+	}
+	now := time.Now()
+	if err := RenewNodeLease(cs, "nodeid-1", now); err != nil {
+		t.Fatalf("RenewNodeLease() of an existing lease = %v", err)
+	}
+
+	lease, err := cs.CoordinationV1().Leases(OpenEBSNamespace).Get(context.TODO(), nodeLeaseName("nodeid-1"), metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !lease.Spec.RenewTime.Time.Equal(now) || *lease.Spec.HolderIdentity != "nodeid-1" {
+		t.Errorf("lease renewed at %v by %s, want %v by nodeid-1", lease.Spec.RenewTime, *lease.Spec.HolderIdentity, now)
+	}
+	if err := classifyNode(kubeNode("node1", time.Hour), lease, now, 5*time.Minute); err != nil {
+		t.Errorf("classifyNode() of a renewed lease = %v", err)
+	}
+}
+
+func TestReachableNodeIDTransient(t *testing.T) {
+	fastRetries(t)
+	cs := fake.NewSimpleClientset(kubeNode("node1", time.Hour), nodeLease(time.Minute))
+	gets := countLeaseGets(cs, 3)
+
+	nodeid, err := ReachableNodeID(context.Background(), cs, "node1")
+	if err != nil || nodeid != "nodeid-1" {
+		t.Errorf("ReachableNodeID() = %q, %v, want nodeid-1", nodeid, err)
+	}
+	if *gets != 3 {
+		t.Errorf("lease fetched %d times, want 3", *gets)
+	}
+}
+
+func TestReachableNodeIDUnreachable(t *testing.T) {
+	fastRetries(t)
+	if err := SetNodeUnreachable(2, time.Millisecond, 5*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	cs := fake.NewSimpleClientset(kubeNode("node1", time.Hour), nodeLease(time.Minute))
+	gets := countLeaseGets(cs, 0)
+
+	if _, err := ReachableNodeID(context.Background(), cs, "node1"); !errors.Is(err, ErrNodeUnreachable) {
+		t.Errorf("ReachableNodeID() = %v, want ErrNodeUnreachable", err)
+	}
+	if *gets != 3 {
+		t.Errorf("lease fetched %d times, want 3", *gets)
+	}
+}
+
+func TestReachableNodeIDGone(t *testing.T) {
+	fastRetries(t)
+	cs := fake.NewSimpleClientset(kubeNode("node1", time.Hour), nodeLease(10*time.Minute))
+	gets := countLeaseGets(cs, 0)
+
+	if _, err := ReachableNodeID(context.Background(), cs, "node1"); !errors.Is(err, ErrNodeGone) {
+		t.Errorf("ReachableNodeID() of a node whose agent is dead for too long = %v, want ErrNodeGone", err)
+	}
+	if *gets != 1 {
+		t.Errorf("lease fetched %d times, a gone node should not be retried", *gets)
+	}
+
+	if _, err := ReachableNodeID(context.Background(), fake.NewSimpleClientset(), "node1"); !errors.Is(err, ErrNodeGone) {
+		t.Errorf("ReachableNodeID() of a deleted node = %v, want ErrNodeGone", err)
+	}
+}
+
+func TestReachableNodeIDError(t *testing.T) {
+	fastRetries(t)
+	cs := fake.NewSimpleClientset(kubeNode("node1", time.Hour))
+	apiErr := errors.New("connection refused")
+	cs.PrependReactor("get", "leases", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apiErr
+	})
+
+	if _, err := ReachableNodeID(context.Background(), cs, "node1"); !errors.Is(err, apiErr) {
+		t.Errorf("ReachableNodeID() with an api error = %v, want it wrapped", err)
+	}
+}
+
+func TestReachableNodeIDCancelled(t *testing.T) {
+	orig := nodeUnreachable
+	t.Cleanup(func() { nodeUnreachable = orig })
+	if err := SetNodeUnreachable(3, time.Hour, 5*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	cs := fake.NewSimpleClientset(kubeNode("node1", time.Hour), nodeLease(time.Minute))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := ReachableNodeID(ctx, cs, "node1"); !errors.Is(err, ErrNodeUnreachable) {
+		t.Errorf("ReachableNodeID() with a done context = %v, want ErrNodeUnreachable", err)
+	}
+}
+
+func TestSetNodeUnreachable(t *testing.T) {
+	orig := nodeUnreachable
+	t.Cleanup(func() { nodeUnreachable = orig })
+
+	if err := SetNodeUnreachable(-1, time.Second, time.Minute); err == nil {
+		t.Error("negative retries accepted")
+	}
+	if err := SetNodeUnreachable(3, 0, time.Minute); err == nil {
+		t.Error("zero retry interval accepted")
+	}
+	if err := SetNodeUnreachable(0, 0, 0); err == nil {
+		t.Error("zero gone threshold accepted")
+	}
+	if err := SetNodeUnreachable(0, 0, time.Minute); err != nil {
+		t.Errorf("SetNodeUnreachable() without retries = %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"fmt"
+	"strings"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+)
+
+// getOrigin returns the origin property of the dataset, empty if it is
+// not a clone, can be replaced in unit tests
+var getOrigin = func(dataset string) (string, error) {
+	out, err := zfsCommand(ZFSGetArg, "-H", "-o", "value", "origin", dataset).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("zfs get origin of %s failed, %s", dataset, string(out))
+	}
+	if origin := strings.TrimSpace(string(out)); origin != "-" {
+		return origin, nil
+	}
+	return "", nil
+}
+
+// GetCloneOrigin returns the snapshot the volume depends on, it is empty
+// if the volume is not, or no longer, a clone
+func GetCloneOrigin(vol *apis.ZFSVolume) (string, error) {
+	return getOrigin(VolumeDataset(vol))
+}
+
+// OriginVolume returns the name of the volume the origin snapshot has
+// been taken of, it is empty if none of the volumes owns its dataset
+func OriginVolume(origin string, vols []*apis.ZFSVolume) string {
+	if origin == "" {
+		return ""
+	}
+	dataset := datasetOf(origin)
+	for _, v := range vols {
+		if VolumeDataset(v) == dataset {
+			return v.Name
+		}
+	}
+	return ""
+}
+
+// SetCloneOrigin records the origin snapshot of the clone and the volume
+// it belongs to in the status. A clone without an origin is marked as
+// independent, a volume which has never been a clone is left alone. It
+// returns true if the status has changed.
+func SetCloneOrigin(vol *apis.ZFSVolume, origin, originVol string) bool {
+	old := vol.Status.Origin
+	switch {
+	case origin != "":
+		vol.Status.Origin = &apis.CloneOrigin{Snapshot: origin, Volume: originVol}
+	case old != nil:
+		// the clone has been promoted or replaced by a full copy, the
+		// volume it was cloned from is kept for the record
+		vol.Status.Origin = &apis.CloneOrigin{Volume: old.Volume, Independent: true}
+	default:
+		return false
+	}
+	return old == nil || *old != *vol.Status.Origin
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"testing"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+)
+
+func TestSetCloneOrigin(t *testing.T) {
+	vol := &apis.ZFSVolume{}
+	vol.Name = "pvc-clone"
+
+	if !SetCloneOrigin(vol, "zfspv/pvc-1@snap-1", "pvc-1") {
+		t.Fatal("SetCloneOrigin() of a new clone did not change the status")
+	}
+	want := apis.CloneOrigin{Snapshot: "zfspv/pvc-1@snap-1", Volume: "pvc-1"}
+	if *vol.Status.Origin != want {
+		t.Errorf("Origin = %+v, want %+v", *vol.Status.Origin, want)
+	}
+	if SetCloneOrigin(vol, "zfspv/pvc-1@snap-1", "pvc-1") {
+		t.Error("SetCloneOrigin() with the same origin changed the status")
+	}
+
+	// zfs promote or a full copy leaves the clone without an origin
+	if !SetCloneOrigin(vol, "", "") {
+		t.Fatal("SetCloneOrigin() of a promoted clone did not change the status")
+	}
+	want = apis.CloneOrigin{Volume: "pvc-1", Independent: true}
+	if *vol.Status.Origin != want {
+		t.Errorf("Origin after the promotion = %+v, want %+v", *vol.Status.Origin, want)
+	}
+	if SetCloneOrigin(vol, "", "") {
+		t.Error("SetCloneOrigin() of an independent clone changed the status")
+	}
+}
+
+func TestSetCloneOriginNotAClone(t *testing.T) {
+	vol := &apis.ZFSVolume{}
+	if SetCloneOrigin(vol, "", "") || vol.Status.Origin != nil {
+		t.Errorf("SetCloneOrigin() of a volume which is not a clone set %+v", vol.Status.Origin)
+	}
+}
+
+func TestOriginVolume(t *testing.T) {
+	vol := func(name, dataset string) *apis.ZFSVolume {
+		v := &apis.ZFSVolume{}
+		v.Name = name
+		v.Spec.PoolName = "zfspv"
+		v.Spec.DatasetName = dataset
+		return v
+	}
+	vols := []*apis.ZFSVolume{vol("pvc-1", ""), vol("pvc-long", "pvc-short"), vol("pvc-2", "")}
+
+	for origin, want := range map[string]string{
+		"zfspv/pvc-1@snap-1":     "pvc-1",
+		"zfspv/pvc-short@snap-1": "pvc-long",
+		"zfspv/other@snap-1":     "",
+		"":                       "",
+	} {
+		if got := OriginVolume(origin, vols); got != want {
+			t.Errorf("OriginVolume(%q) = %q, want %q", origin, got, want)
+		}
+	}
+}
+
+func TestGetCloneOrigin(t *testing.T) {
+	orig := getOrigin
+	t.Cleanup(func() { getOrigin = orig })
+
+	var asked string
+	getOrigin = func(dataset string) (string, error) {
+		asked = dataset
+		return "zfspv/pvc-1@snap-1", nil
+	}
+	vol := &apis.ZFSVolume{}
+	vol.Name = "pvc-clone"
+	vol.Spec.PoolName = "zfspv"
+	if origin, err := GetCloneOrigin(vol); err != nil || origin != "zfspv/pvc-1@snap-1" {
+		t.Errorf("GetCloneOrigin() = %q, %v", origin, err)
+	}
+	if asked != "zfspv/pvc-clone" {
+		t.Errorf("origin asked for %q, want zfspv/pvc-clone", asked)
+	}
+}
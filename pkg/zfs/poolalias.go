@@ -0,0 +1,188 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"github.com/openebs/zfs-localpv/pkg/builder/nodebuilder"
+	clientset "github.com/openebs/zfs-localpv/pkg/generated/clientset/internalclientset"
+	listers "github.com/openebs/zfs-localpv/pkg/generated/lister/zfs/v1"
+	k8serror "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// PoolAliasUpdate tells whether the spec of the ZFSVolumes and the
+// ZFSSnapshots of a renamed pool is updated with its current name, they are
+// only resolved in memory by default
+var PoolAliasUpdate bool
+
+// poolAliasNodes is the lister of the ZFSNode of the node the pool
+// aliases are read from once it has synced, set by SetPoolAliasLister
+var poolAliasNodes struct {
+	lister listers.ZFSNodeLister
+	synced cache.InformerSynced
+}
+
+// SetPoolAliasLister sets the lister the pool aliases are read from, the
+// ZFSNode is read from the API until it has synced
+func SetPoolAliasLister(lister listers.ZFSNodeLister, synced cache.InformerSynced) {
+	poolAliasNodes.lister, poolAliasNodes.synced = lister, synced
+}
+
+// getPoolAliases returns the pool aliases of the ZFSNode of the node, there
+// are none out of the node agent
+func getPoolAliases() (map[string]string, error) {
+	if NodeID == "" {
+		return nil, nil
+	}
+	var node *apis.ZFSNode
+	var err error
+	if poolAliasNodes.lister != nil && poolAliasNodes.synced() {
+		node, err = poolAliasNodes.lister.ZFSNodes(OpenEBSNamespace).Get(NodeID)
+	} else {
+		node, err = nodebuilder.NewKubeclient().WithNamespace(OpenEBSNamespace).Get(NodeID, metav1.GetOptions{})
+	}
+	if k8serror.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return node.PoolAliases, nil
+}
+
+// resolvedVolumePools and resolvedSnapshotPools are the pools the volumes
+// and the snapshots resolved in memory only have in the API, by name. They
+// are written back with them, the resolution is only persisted with
+// PoolAliasUpdate.
+var (
+	resolvedVolumePools   sync.Map
+	resolvedSnapshotPools sync.Map
+)
+
+// apiVolume returns the volume to write to the API, with the pool it has
+// there if it has only been resolved in memory
+func apiVolume(vol *apis.ZFSVolume) *apis.ZFSVolume {
+	pool, ok := resolvedVolumePools.Load(vol.Name)
+	if !ok || pool.(string) == vol.Spec.PoolName {
+		return vol
+	}
+	out := vol.DeepCopy()
+	out.Spec.PoolName = pool.(string)
+	return out
+}
+
+// apiSnapshot returns the snapshot to write to the API, as apiVolume does
+// for a volume
+func apiSnapshot(snap *apis.ZFSSnapshot) *apis.ZFSSnapshot {
+	pool, ok := resolvedSnapshotPools.Load(snap.Name)
+	if !ok || pool.(string) == snap.Spec.PoolName {
+		return snap
+	}
+	out := snap.DeepCopy()
+	out.Spec.PoolName = pool.(string)
+	return out
+}
+
+// ResolvePoolAlias returns the current name of the pool of a volume, the
+// pool may be a dataset within a zpool, e.g. old-pool/parent, and only the
+// zpool is looked up in the aliases. The aliases are followed as long as
+// the zpool has been renamed, e.g. twice, and the name is returned as it
+// is if the zpool has no alias or if the aliases loop.
+func ResolvePoolAlias(aliases map[string]string, pool string) string {
+	zpool, rest := pool, ""
+	if i := strings.IndexByte(pool, '/'); i >= 0 {
+		zpool, rest = pool[:i], pool[i:]
+	}
+	current := zpool
+	for seen := map[string]bool{zpool: true}; ; {
+		next, ok := aliases[current]
+		if !ok || next == "" || next == current {
+			break
+		}
+		if seen[next] {
+			klog.Warningf("zfs: the aliases of pool %s loop, it is not resolved", zpool)
+			return pool
+		}
+		seen[next] = true
+		current = next
+	}
+	return current + rest
+}
+
+// ResolveVolumePool sets the pool of the volume to the current name of its
+// zpool if it has been renamed, as per the pool aliases of the ZFSNode.
+// The ZFSVolume is updated with the client as well if asked for, the volume
+// is then the updated one. It returns whether the pool has been renamed.
+func ResolveVolumePool(client clientset.Interface, vol *apis.ZFSVolume, update bool) (bool, error) {
+	aliases, err := getPoolAliases()
+	if err != nil || len(aliases) == 0 {
+		return false, err
+	}
+	pool := ResolvePoolAlias(aliases, vol.Spec.PoolName)
+	if pool == vol.Spec.PoolName {
+		return false, nil
+	}
+	klog.Infof("zfs: pool %s of volume %s has been renamed to %s", vol.Spec.PoolName, vol.Name, pool)
+	if !update {
+		resolvedVolumePools.Store(vol.Name, vol.Spec.PoolName)
+	}
+	vol.Spec.PoolName = pool
+	if update {
+		updated, err := client.ZfsV1().ZFSVolumes(OpenEBSNamespace).
+			Update(context.TODO(), vol, metav1.UpdateOptions{})
+		if err != nil {
+			return true, fmt.Errorf("zfs: could not update the pool of volume %s to %s: %v", vol.Name, pool, err)
+		}
+		*vol = *updated
+	}
+	return true, nil
+}
+
+// ResolveSnapshotPool sets the pool of the snapshot to the current name of
+// its zpool if it has been renamed, as ResolveVolumePool does for a volume
+func ResolveSnapshotPool(client clientset.Interface, snap *apis.ZFSSnapshot, update bool) (bool, error) {
+	aliases, err := getPoolAliases()
+	if err != nil || len(aliases) == 0 {
+		return false, err
+	}
+	pool := ResolvePoolAlias(aliases, snap.Spec.PoolName)
+	if pool == snap.Spec.PoolName {
+		return false, nil
+	}
+	klog.Infof("zfs: pool %s of snapshot %s has been renamed to %s", snap.Spec.PoolName, snap.Name, pool)
+	if !update {
+		resolvedSnapshotPools.Store(snap.Name, snap.Spec.PoolName)
+	}
+	snap.Spec.PoolName = pool
+	if update {
+		updated, err := client.ZfsV1().ZFSSnapshots(OpenEBSNamespace).
+			Update(context.TODO(), snap, metav1.UpdateOptions{})
+		if err != nil {
+			return true, fmt.Errorf("zfs: could not update the pool of snapshot %s to %s: %v", snap.Name, pool, err)
+		}
+		*snap = *updated
+	}
+	return true, nil
+}
@@ -0,0 +1,173 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	openebsfake "github.com/openebs/zfs-localpv/pkg/generated/clientset/internalclientset/fake"
+	listers "github.com/openebs/zfs-localpv/pkg/generated/lister/zfs/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestResolvePoolAlias(t *testing.T) {
+	aliases := map[string]string{
+		"old-pool":   "zfspv-pool",
+		"first":      "second",
+		"second":     "third",
+		"loop-a":     "loop-b",
+		"loop-b":     "loop-a",
+		"empty":      "",
+		"zfspv-pool": "zfspv-pool",
+	}
+	tests := map[string]string{
+		"old-pool":            "zfspv-pool",
+		"old-pool/parent":     "zfspv-pool/parent",
+		"old-pool/parent/sub": "zfspv-pool/parent/sub",
+		"zfspv-pool":          "zfspv-pool",
+		"other":               "other",
+		// renamed twice
+		"first/parent": "third/parent",
+		// the aliases which can not be resolved are ignored
+		"loop-a": "loop-a",
+		"empty":  "empty",
+		// only the zpool is renamed
+		"parent/old-pool": "parent/old-pool",
+	}
+	for pool, want := range tests {
+		if got := ResolvePoolAlias(aliases, pool); got != want {
+			t.Errorf("ResolvePoolAlias(%q) = %q, want %q", pool, got, want)
+		}
+	}
+	if got := ResolvePoolAlias(nil, "old-pool"); got != "old-pool" {
+		t.Errorf("ResolvePoolAlias() without aliases = %q", got)
+	}
+}
+
+// fakeAliases sets the aliases of the ZFSNode of the node in its lister,
+// the volumes and the snapshots are updated in the returned clientset
+func fakeAliases(t *testing.T, aliases map[string]string, objs ...runtime.Object) *openebsfake.Clientset {
+	origNode, origNamespace, origLister := NodeID, OpenEBSNamespace, poolAliasNodes
+	t.Cleanup(func() { NodeID, OpenEBSNamespace, poolAliasNodes = origNode, origNamespace, origLister })
+	NodeID, OpenEBSNamespace = "node-1", "openebs"
+
+	node := &apis.ZFSNode{}
+	node.Name = NodeID
+	node.Namespace = OpenEBSNamespace
+	node.PoolAliases = aliases
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	if err := indexer.Add(node); err != nil {
+		t.Fatal(err)
+	}
+	SetPoolAliasLister(listers.NewZFSNodeLister(indexer), func() bool { return true })
+	return openebsfake.NewSimpleClientset(objs...)
+}
+
+// countUpdates returns the number of the updates made with the clientset
+func countUpdates(client *openebsfake.Clientset) int {
+	n := 0
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "update" {
+			n++
+		}
+	}
+	return n
+}
+
+func TestResolveVolumePool(t *testing.T) {
+	vol := &apis.ZFSVolume{}
+	vol.Name = "pvc-1"
+	vol.Namespace = "openebs"
+	vol.Spec.PoolName = "old-pool/parent"
+	client := fakeAliases(t, map[string]string{"old-pool": "zfspv-pool"}, vol.DeepCopy())
+
+	// resolved in memory only
+	renamed, err := ResolveVolumePool(client, vol, false)
+	if err != nil || !renamed || vol.Spec.PoolName != "zfspv-pool/parent" || countUpdates(client) != 0 {
+		t.Errorf("ResolveVolumePool() = %v, %v, pool %s after %d updates",
+			renamed, err, vol.Spec.PoolName, countUpdates(client))
+	}
+	if got := VolumeDataset(vol); got != "zfspv-pool/parent/pvc-1" {
+		t.Errorf("VolumeDataset() = %s after the resolution", got)
+	}
+
+	// the volume is written back with the pool it has in the API
+	if got := apiVolume(vol); got.Spec.PoolName != "old-pool/parent" || vol.Spec.PoolName != "zfspv-pool/parent" {
+		t.Errorf("apiVolume() pool = %s, resolved pool %s", got.Spec.PoolName, vol.Spec.PoolName)
+	}
+	if got := apiVolume(&apis.ZFSVolume{}); got.Spec.PoolName != "" {
+		t.Errorf("apiVolume() of a volume not resolved = %s", got.Spec.PoolName)
+	}
+
+	// and in the ZFSVolume
+	resolvedVolumePools.Delete(vol.Name)
+	vol.Spec.PoolName = "old-pool/parent"
+	renamed, err = ResolveVolumePool(client, vol, true)
+	if err != nil || !renamed || countUpdates(client) != 1 {
+		t.Errorf("ResolveVolumePool() = %v, %v after %d updates", renamed, err, countUpdates(client))
+	}
+	stored, err := client.ZfsV1().ZFSVolumes(OpenEBSNamespace).Get(context.TODO(), vol.Name, metav1.GetOptions{})
+	if err != nil || stored.Spec.PoolName != "zfspv-pool/parent" {
+		t.Errorf("ZFSVolume = %v, %v, want the pool updated", stored, err)
+	}
+
+	// a volume of the current pool is left alone
+	renamed, err = ResolveVolumePool(client, vol, true)
+	if err != nil || renamed || countUpdates(client) != 1 {
+		t.Errorf("ResolveVolumePool() of a current pool = %v, %v after %d updates",
+			renamed, err, countUpdates(client))
+	}
+
+	// the update may fail
+	client.PrependReactor("update", "zfsvolumes", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("conflict")
+	})
+	vol.Spec.PoolName = "old-pool"
+	if _, err = ResolveVolumePool(client, vol, true); err == nil {
+		t.Errorf("ResolveVolumePool() ignored the failed update")
+	}
+}
+
+func TestResolveSnapshotPool(t *testing.T) {
+	snap := &apis.ZFSSnapshot{}
+	snap.Name = "snap-1"
+	snap.Namespace = "openebs"
+	snap.Labels = map[string]string{ZFSVolKey: "pvc-1"}
+	snap.Spec.PoolName = "old-pool"
+	client := fakeAliases(t, map[string]string{"old-pool": "zfspv-pool"}, snap.DeepCopy())
+
+	renamed, err := ResolveSnapshotPool(client, snap, true)
+	if err != nil || !renamed || countUpdates(client) != 1 {
+		t.Errorf("ResolveSnapshotPool() = %v, %v after %d updates", renamed, err, countUpdates(client))
+	}
+	if got := SnapshotDataset(snap); got != "zfspv-pool/pvc-1@snap-1" {
+		t.Errorf("SnapshotDataset() = %s after the resolution", got)
+	}
+
+	// nothing to resolve without aliases
+	fakeAliases(t, nil)
+	snap.Spec.PoolName = "old-pool"
+	if renamed, err := ResolveSnapshotPool(client, snap, true); err != nil || renamed {
+		t.Errorf("ResolveSnapshotPool() without aliases = %v, %v", renamed, err)
+	}
+}
@@ -0,0 +1,182 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"k8s.io/klog/v2"
+)
+
+// zpoolOf returns the zpool of a pool, which may be a dataset of the
+// zpool, e.g. zfspv-pool of zfspv-pool/volumes
+func zpoolOf(pool string) string {
+	return strings.SplitN(pool, "/", 2)[0]
+}
+
+// NeedsPoolCopy tells whether a volume of srcPool has to be copied to be
+// cloned on dstPool. A zfs clone must be in the zpool of its origin
+// snapshot, so the volume is copied with send/receive to another zpool.
+func NeedsPoolCopy(srcPool, dstPool string) bool {
+	return zpoolOf(srcPool) != zpoolOf(dstPool)
+}
+
+// sourcePool returns the pool of the snapshot the volume is cloned from
+func sourcePool(vol *apis.ZFSVolume) string {
+	if vol.Spec.SourcePool != "" {
+		return vol.Spec.SourcePool
+	}
+	return vol.Spec.PoolName
+}
+
+// IsPoolCopy tells whether the volume is copied from a volume of another
+// zpool of the node, the copy does not depend on the source once it has
+// been received
+func IsPoolCopy(vol *apis.ZFSVolume) bool {
+	return len(vol.Spec.SnapName) != 0 && NeedsPoolCopy(sourcePool(vol), vol.Spec.PoolName)
+}
+
+var (
+	// getCopySpace returns the bytes referenced by the snapshot and the
+	// bytes available in the pool, can be replaced in unit tests
+	getCopySpace = func(snapshot, pool string) (int64, int64, error) {
+		var values [2]int64
+		for i, arg := range [][]string{{"referenced", snapshot}, {"available", pool}} {
+			out, err := zfsCommand(ZFSGetArg, "-pH", "-o", "value", arg[0], arg[1]).CombinedOutput()
+			if err != nil {
+				return 0, 0, fmt.Errorf("zfs get %s of %s failed, %s", arg[0], arg[1], strings.TrimSpace(string(out)))
+			}
+			if values[i], err = strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64); err != nil {
+				return 0, 0, fmt.Errorf("zfs: invalid %s %q of %s", arg[0], strings.TrimSpace(string(out)), arg[1])
+			}
+		}
+		return values[0], values[1], nil
+	}
+
+	// receiveCopy sends the snapshot to the dataset with the given
+	// receive options, can be replaced in unit tests
+	receiveCopy = func(snapshot, dataset string, opts []string) error {
+		cmd := zfsShell() + " " + ZFSSendArg + " " + snapshot + " | " +
+			zfsShell() + " " + ZFSRecvArg + " -u " + strings.Join(opts, " ") + " " + dataset
+		out, err := exec.Command("bash", "-c", cmd).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("zfs: could not copy %s to %s: %s", snapshot, dataset, string(out))
+		}
+		return nil
+	}
+)
+
+// copySpace returns the bytes the copy of the snapshot needs in the pool,
+// a thick volume reserves its whole capacity
+func copySpace(vol *apis.ZFSVolume, referenced int64) (int64, error) {
+	thick := vol.Spec.ThinProvision == "no"
+	if vol.Spec.VolumeType != VolTypeDataset {
+		thick = vol.Spec.ThinProvision != "yes"
+	}
+	if !thick {
+		return referenced, nil
+	}
+	capacity, err := strconv.ParseInt(vol.Spec.Capacity, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("zfs: invalid capacity %q of volume %s", vol.Spec.Capacity, vol.Name)
+	}
+	if capacity > referenced {
+		return capacity, nil
+	}
+	return referenced, nil
+}
+
+// checkCopySpace returns an error if the pool of the volume has not enough
+// space for the copy of the snapshot
+func checkCopySpace(vol *apis.ZFSVolume, snapshot string) error {
+	referenced, available, err := getCopySpace(snapshot, vol.Spec.PoolName)
+	if err != nil {
+		return err
+	}
+	needed, err := copySpace(vol, referenced)
+	if err != nil {
+		return err
+	}
+	if needed > available {
+		return fmt.Errorf("zfs: not enough space in pool %s to copy %s: %d bytes needed, %d available",
+			vol.Spec.PoolName, snapshot, needed, available)
+	}
+	return nil
+}
+
+// copyReceiveOptions returns the options the copy is received with, the
+// properties of the clone are set on the copy instead of the ones of the
+// source
+func copyReceiveOptions(vol *apis.ZFSVolume) []string {
+	args := buildCloneCreateArgs(vol)
+	// drop the clone subcommand, the snapshot and the dataset
+	return args[1 : len(args)-2]
+}
+
+// copyVolume receives the volume from the snapshot of its source on
+// another zpool, the copy takes a send slot. The snapshot is only
+// destroyed once the copy is complete, zfs receive does not leave a
+// partial dataset behind.
+func copyVolume(vol *apis.ZFSVolume, snapshot string) error {
+	volume := VolumeDataset(vol)
+	if vol.Spec.Encryption != "" && vol.Spec.Encryption != "off" {
+		return fmt.Errorf("zfs: %s is encrypted, it can not be copied to pool %s", snapshot, vol.Spec.PoolName)
+	}
+	if err := checkCopySpace(vol, snapshot); err != nil {
+		return err
+	}
+	release, err := acquireSendSlot(context.TODO())
+	if err != nil {
+		return err
+	}
+	defer release()
+	klog.Infof("zfs: copying %s to %s", snapshot, volume)
+	if err := receiveCopy(snapshot, volume, copyReceiveOptions(vol)); err != nil {
+		rollbackPartialVolume(vol)
+		return err
+	}
+	klog.Infof("zfs: copied %s to %s", snapshot, volume)
+	return nil
+}
+
+// finishCopy destroys the snapshot received along with the copy and the
+// snapshot of the source volume taken for it, the copy does not need them
+func finishCopy(vol *apis.ZFSVolume, snapshot string) error {
+	snaps := []string{VolumeDataset(vol) + "@" + strings.SplitN(snapshot, "@", 2)[1]}
+	if _, ok := vol.Labels[ZFSSrcVolKey]; ok {
+		snaps = append(snaps, snapshot)
+	}
+	for _, snap := range snaps {
+		if !datasetExists(snap) {
+			continue
+		}
+		args := []string{ZFSDestroyArg}
+		if vol.Spec.Layout != "" {
+			// along with the snapshots of the children
+			args = append(args, "-r")
+		}
+		if err := runIndependence(append(args, snap)...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,320 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"github.com/openebs/zfs-localpv/pkg/zfs/zfstest"
+)
+
+func copyVol(volType string) *apis.ZFSVolume {
+	vol := &apis.ZFSVolume{}
+	vol.Name = "pvc-copy"
+	vol.Labels = map[string]string{ZFSSrcVolKey: "pvc-src"}
+	vol.Spec.PoolName = "nvme"
+	vol.Spec.SourcePool = "hdd/volumes"
+	vol.Spec.SnapName = "pvc-src@pvc-copy"
+	vol.Spec.VolumeType = volType
+	vol.Spec.Capacity = "10737418240"
+	vol.Spec.QuotaType = "refquota"
+	return vol
+}
+
+// fakeCopy records the copies, the datasets are kept in the fake zfs
+type fakeCopy struct {
+	referenced int64
+	available  int64
+	copies     []string
+	opts       []string
+	recvErr    error
+	zfs        *zfstest.FakeZFS
+}
+
+func (f *fakeCopy) install(t *testing.T) {
+	origSpace, origRecv := getCopySpace, receiveCopy
+	t.Cleanup(func() {
+		getCopySpace, receiveCopy = origSpace, origRecv
+	})
+	f.zfs = newFakeZFS(t, "hdd/volumes/pvc-src", "hdd/volumes/pvc-src@pvc-copy")
+	getCopySpace = func(snapshot, pool string) (int64, int64, error) {
+		return f.referenced, f.available, nil
+	}
+	receiveCopy = func(snapshot, dataset string, opts []string) error {
+		if f.recvErr != nil {
+			return f.recvErr
+		}
+		f.copies = append(f.copies, snapshot+" "+dataset)
+		f.opts = opts
+		f.zfs.Create(t, dataset)
+		f.zfs.Create(t, dataset+"@"+strings.SplitN(snapshot, "@", 2)[1])
+		return nil
+	}
+}
+
+func newFakeCopy() *fakeCopy {
+	return &fakeCopy{
+		referenced: 1 << 30,
+		available:  100 << 30,
+	}
+}
+
+func TestNeedsPoolCopy(t *testing.T) {
+	tests := []struct {
+		src, dst string
+		copy     bool
+	}{
+		{"zfspv", "zfspv", false},
+		// a clone can be in another dataset of the same zpool
+		{"zfspv/hdd", "zfspv/fast", false},
+		{"zfspv", "zfspv/volumes", false},
+		{"hdd", "nvme", true},
+		{"hdd/volumes", "nvme/volumes", true},
+	}
+	for _, tt := range tests {
+		if got := NeedsPoolCopy(tt.src, tt.dst); got != tt.copy {
+			t.Errorf("NeedsPoolCopy(%s, %s) = %v, want %v", tt.src, tt.dst, got, tt.copy)
+		}
+	}
+}
+
+func TestIsPoolCopy(t *testing.T) {
+	vol := copyVol(VolTypeDataset)
+	if !IsPoolCopy(vol) {
+		t.Errorf("IsPoolCopy() = false for a volume of another zpool")
+	}
+	vol.Spec.SourcePool = "nvme/other"
+	if IsPoolCopy(vol) {
+		t.Errorf("IsPoolCopy() = true for a clone of the same zpool")
+	}
+	vol.Spec.SourcePool = ""
+	if IsPoolCopy(vol) || sourcePool(vol) != "nvme" {
+		t.Errorf("IsPoolCopy() = true for a clone of the same pool")
+	}
+	vol = copyVol(VolTypeDataset)
+	vol.Spec.SnapName = ""
+	if IsPoolCopy(vol) {
+		t.Errorf("IsPoolCopy() = true for a volume which is not a clone")
+	}
+}
+
+func TestCloneOfAnotherDataset(t *testing.T) {
+	vol := copyVol(VolTypeZVol)
+	vol.Spec.PoolName = "zfspv/fast"
+	vol.Spec.SourcePool = "zfspv/hdd"
+	args := buildCloneCreateArgs(vol)
+	if got := args[len(args)-2]; got != "zfspv/hdd/pvc-src@pvc-copy" {
+		t.Errorf("clone of %s, want the snapshot of the source pool", got)
+	}
+	if got := cloneOrigin(vol); got != "zfspv/hdd/pvc-src" {
+		t.Errorf("cloneOrigin() = %s", got)
+	}
+	if got := cloneOrigin(copyVol(VolTypeZVol)); got != "" {
+		t.Errorf("cloneOrigin() of a copy = %s, want none", got)
+	}
+}
+
+func TestCopySpace(t *testing.T) {
+	tests := []struct {
+		volType, thin string
+		referenced    int64
+		want          int64
+	}{
+		{VolTypeDataset, "", 1 << 30, 1 << 30},
+		{VolTypeDataset, "no", 1 << 30, 10 << 30},
+		{VolTypeZVol, "yes", 1 << 30, 1 << 30},
+		{VolTypeZVol, "", 1 << 30, 10 << 30},
+		// the data of the source can exceed its capacity, e.g. the
+		// metadata of a zvol
+		{VolTypeZVol, "", 11 << 30, 11 << 30},
+	}
+	for _, tt := range tests {
+		vol := copyVol(tt.volType)
+		vol.Spec.ThinProvision = tt.thin
+		if got, err := copySpace(vol, tt.referenced); err != nil || got != tt.want {
+			t.Errorf("copySpace(%s, %q, %d) = %d, %v, want %d", tt.volType, tt.thin, tt.referenced, got, err, tt.want)
+		}
+	}
+}
+
+func TestCopyReceiveOptions(t *testing.T) {
+	vol := copyVol(VolTypeDataset)
+	vol.Spec.Compression = "zstd"
+	vol.Spec.RecordSize = "1M"
+	vol.Spec.ReadOnly = "yes"
+	opts := copyReceiveOptions(vol)
+	for _, want := range []string{"refquota=10737418240", "recordsize=1M", "compression=zstd",
+		"mountpoint=legacy"} {
+		found := false
+		for i, opt := range opts {
+			found = found || (opt == want && i > 0 && opts[i-1] == "-o")
+		}
+		if !found {
+			t.Errorf("copyReceiveOptions() = %v, missing -o %s", opts, want)
+		}
+	}
+	for _, opt := range opts {
+		if opt == ZFSCloneArg || strings.Contains(opt, "@") || opt == VolumeDataset(vol) {
+			t.Errorf("copyReceiveOptions() = %v, it has the clone arguments", opts)
+		}
+	}
+}
+
+func TestCopyVolume(t *testing.T) {
+	f := newFakeCopy()
+	f.install(t)
+	vol := copyVol(VolTypeDataset)
+	snapshot := "hdd/volumes/pvc-src@pvc-copy"
+
+	if err := copyVolume(vol, snapshot); err != nil {
+		t.Fatalf("copyVolume() = %v", err)
+	}
+	if want := []string{snapshot + " nvme/pvc-copy"}; !reflect.DeepEqual(f.copies, want) {
+		t.Errorf("copies = %v, want %v", f.copies, want)
+	}
+	if err := finishCopy(vol, snapshot); err != nil {
+		t.Fatalf("finishCopy() = %v", err)
+	}
+	// the copy is independent of the source, which keeps its data
+	want := []string{"hdd/volumes/pvc-src", "nvme/pvc-copy"}
+	if got := f.zfs.Datasets(); !reflect.DeepEqual(got, want) {
+		t.Errorf("datasets = %v, want %v", got, want)
+	}
+	// nothing is left to do once the copy is complete
+	if err := finishCopy(vol, snapshot); err != nil {
+		t.Errorf("finishCopy() again = %v", err)
+	}
+}
+
+func TestCopyVolumeSendSlot(t *testing.T) {
+	withMaxSends(t, 1)
+	f := newFakeCopy()
+	f.install(t)
+	held := 0
+	receiveCopy = func(snapshot, dataset string, opts []string) error {
+		held = len(sendSlots)
+		return nil
+	}
+
+	if err := copyVolume(copyVol(VolTypeDataset), "hdd/volumes/pvc-src@pvc-copy"); err != nil {
+		t.Fatalf("copyVolume() = %v", err)
+	}
+	if held != 1 || len(sendSlots) != 0 {
+		t.Errorf("copyVolume() held %d send slots, %d left taken", held, len(sendSlots))
+	}
+}
+
+func TestCopyLayout(t *testing.T) {
+	f := newFakeCopy()
+	f.install(t)
+	f.zfs.Create(t, "hdd/volumes/pvc-src/data@pvc-copy")
+	f.zfs.Create(t, "hdd/volumes/pvc-src/log@pvc-copy")
+	vol := copyVol(VolTypeDataset)
+	vol.Spec.Layout = "mysql"
+	snapshot := "hdd/volumes/pvc-src@pvc-copy"
+
+	if err := copyVolume(vol, snapshot); err != nil {
+		t.Fatalf("copyVolume() = %v", err)
+	}
+	if err := createLayout(vol, snapshot); err != nil {
+		t.Fatalf("createLayout() = %v", err)
+	}
+	// the children carry the data of the source, the missing one is
+	// created empty
+	want := []string{
+		snapshot + " nvme/pvc-copy",
+		"hdd/volumes/pvc-src/data@pvc-copy nvme/pvc-copy/data",
+		"hdd/volumes/pvc-src/log@pvc-copy nvme/pvc-copy/log",
+	}
+	if !reflect.DeepEqual(f.copies, want) || !f.zfs.Exists("nvme/pvc-copy/binlog") {
+		t.Errorf("copies = %v, binlog created %v, want %v", f.copies, f.zfs.Exists("nvme/pvc-copy/binlog"), want)
+	}
+	if want := []string{"-o", "recordsize=128K", "-o", "mountpoint=legacy"}; !reflect.DeepEqual(f.opts, want) {
+		t.Errorf("child received with %v, want %v", f.opts, want)
+	}
+	if err := finishCopy(vol, snapshot); err != nil {
+		t.Fatalf("finishCopy() = %v", err)
+	}
+	var destroyed []string
+	for _, cmd := range zfsWrites(f.zfs) {
+		if strings.HasPrefix(cmd, ZFSDestroyArg+" ") {
+			destroyed = append(destroyed, cmd)
+		}
+	}
+	want = []string{"destroy -r nvme/pvc-copy@pvc-copy", "destroy -r " + snapshot}
+	if !reflect.DeepEqual(destroyed, want) {
+		t.Errorf("destroyed %v, want %v", destroyed, want)
+	}
+}
+
+func TestFinishCopyKeepsSnapshotSource(t *testing.T) {
+	f := newFakeCopy()
+	f.install(t)
+	f.zfs.Create(t, "nvme/pvc-copy")
+	f.zfs.Create(t, "nvme/pvc-copy@pvc-copy")
+	// cloned from a VolumeSnapshot, which is not the copy's to destroy
+	vol := copyVol(VolTypeDataset)
+	vol.Labels = nil
+	if err := finishCopy(vol, "hdd/volumes/pvc-src@pvc-copy"); err != nil {
+		t.Fatalf("finishCopy() = %v", err)
+	}
+	if !f.zfs.Exists("hdd/volumes/pvc-src@pvc-copy") || f.zfs.Exists("nvme/pvc-copy@pvc-copy") {
+		t.Errorf("datasets = %v", f.zfs.Datasets())
+	}
+}
+
+func TestCopyVolumeNoSpace(t *testing.T) {
+	f := newFakeCopy()
+	f.install(t)
+	f.available = 5 << 30
+	vol := copyVol(VolTypeZVol)
+	// a thick zvol reserves its 10Gi
+	err := copyVolume(vol, "hdd/volumes/pvc-src@pvc-copy")
+	if err == nil || !strings.Contains(err.Error(), "not enough space in pool nvme") {
+		t.Errorf("copyVolume() = %v, want a space error", err)
+	}
+	if len(f.copies) != 0 {
+		t.Errorf("copied %v without the space", f.copies)
+	}
+	// a thin one only needs the data of the source
+	vol.Spec.ThinProvision = "yes"
+	if err := copyVolume(vol, "hdd/volumes/pvc-src@pvc-copy"); err != nil {
+		t.Errorf("copyVolume() of a thin zvol = %v", err)
+	}
+}
+
+func TestCopyVolumeErrors(t *testing.T) {
+	f := newFakeCopy()
+	f.install(t)
+	f.recvErr = errors.New("cannot receive")
+	if err := copyVolume(copyVol(VolTypeDataset), "hdd/volumes/pvc-src@pvc-copy"); err != f.recvErr {
+		t.Errorf("copyVolume() = %v, want the receive error", err)
+	}
+	if !f.zfs.Exists("hdd/volumes/pvc-src@pvc-copy") {
+		t.Errorf("the snapshot of the source has been destroyed after a failed copy")
+	}
+
+	vol := copyVol(VolTypeDataset)
+	vol.Spec.Encryption = "on"
+	if err := copyVolume(vol, "hdd/volumes/pvc-src@pvc-copy"); err == nil {
+		t.Errorf("copyVolume() copied an encrypted volume")
+	}
+}
@@ -0,0 +1,82 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"fmt"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ConditionPoolDegraded is the condition type of the volumes whose pool is
+// not healthy, it is set by the controller
+const ConditionPoolDegraded = "PoolDegraded"
+
+// poolReasonRecovered is the reason of the PoolDegraded condition once the
+// pool is healthy again, the reason is the health of the pool otherwise
+const poolReasonRecovered = "PoolOnline"
+
+// PoolDegraded tells whether the health of a pool is known and is not
+// ONLINE, e.g. DEGRADED, FAULTED or SUSPENDED
+func PoolDegraded(health string) bool {
+	return health != "" && health != PoolHealthOnline
+}
+
+// VolumePoolHealth returns the zpool of the volume and its health as
+// reported in the summary of the ZFSNode of the volume. The health is
+// empty if it is not known, e.g. the summary is disabled.
+func VolumePoolHealth(node *apis.ZFSNode, vol *apis.ZFSVolume) (string, string) {
+	zpool := zpoolOf(ResolvePoolAlias(node.PoolAliases, vol.Spec.PoolName))
+	for _, summary := range node.Status.Pools {
+		if summary.Name == zpool {
+			return zpool, summary.Health
+		}
+	}
+	return zpool, ""
+}
+
+// SetPoolDegradedCondition sets the PoolDegraded condition of the volume
+// for the health of its pool and returns true if it has changed. The
+// condition is only added once the pool is degraded and is set back to
+// False once the pool is ONLINE again.
+func SetPoolDegradedCondition(vol *apis.ZFSVolume, zpool, health string) bool {
+	cond := meta.FindStatusCondition(vol.Status.Conditions, ConditionPoolDegraded)
+	if !PoolDegraded(health) {
+		if health == "" || cond == nil || cond.Status == metav1.ConditionFalse {
+			return false
+		}
+		meta.SetStatusCondition(&vol.Status.Conditions, metav1.Condition{
+			Type:    ConditionPoolDegraded,
+			Status:  metav1.ConditionFalse,
+			Reason:  poolReasonRecovered,
+			Message: fmt.Sprintf("pool %s on node %s is %s", zpool, vol.Spec.OwnerNodeID, health),
+		})
+		return true
+	}
+	if cond != nil && cond.Status == metav1.ConditionTrue && cond.Reason == health {
+		return false
+	}
+	meta.SetStatusCondition(&vol.Status.Conditions, metav1.Condition{
+		Type:    ConditionPoolDegraded,
+		Status:  metav1.ConditionTrue,
+		Reason:  health,
+		Message: fmt.Sprintf("pool %s on node %s is %s, the data of the volume is at risk", zpool, vol.Spec.OwnerNodeID, health),
+	})
+	return true
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"testing"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPoolDegraded(t *testing.T) {
+	tests := map[string]bool{
+		"ONLINE":    false,
+		"":          false,
+		"DEGRADED":  true,
+		"FAULTED":   true,
+		"SUSPENDED": true,
+	}
+	for health, want := range tests {
+		if got := PoolDegraded(health); got != want {
+			t.Errorf("PoolDegraded(%q) = %v, want %v", health, got, want)
+		}
+	}
+}
+
+func TestVolumePoolHealth(t *testing.T) {
+	node := &apis.ZFSNode{PoolAliases: map[string]string{"old-pool": "zfspv"}}
+	node.Status.Pools = []apis.PoolSummary{{Name: "zfspv", Health: "DEGRADED"}, {Name: "backup", Health: "ONLINE"}}
+
+	tests := map[string][2]string{
+		"zfspv/parent": {"zfspv", "DEGRADED"},
+		"old-pool":     {"zfspv", "DEGRADED"},
+		"backup":       {"backup", "ONLINE"},
+		"spare":        {"spare", ""},
+	}
+	for pool, want := range tests {
+		vol := &apis.ZFSVolume{}
+		vol.Spec.PoolName = pool
+		if zpool, health := VolumePoolHealth(node, vol); zpool != want[0] || health != want[1] {
+			t.Errorf("VolumePoolHealth(%s) = %s, %s, want %v", pool, zpool, health, want)
+		}
+	}
+}
+
+func TestSetPoolDegradedCondition(t *testing.T) {
+	vol := &apis.ZFSVolume{}
+	vol.Spec.OwnerNodeID = "node1"
+
+	// a healthy pool or one whose health is not known adds no condition
+	if SetPoolDegradedCondition(vol, "zfspv", "ONLINE") || SetPoolDegradedCondition(vol, "zfspv", "") {
+		t.Errorf("SetPoolDegradedCondition() changed the volume of a healthy pool")
+	}
+	if len(vol.Status.Conditions) != 0 {
+		t.Fatalf("conditions %v, want none", vol.Status.Conditions)
+	}
+
+	if !SetPoolDegradedCondition(vol, "zfspv", "DEGRADED") {
+		t.Errorf("SetPoolDegradedCondition() did not mark the volume")
+	}
+	cond := meta.FindStatusCondition(vol.Status.Conditions, ConditionPoolDegraded)
+	if cond == nil || cond.Status != metav1.ConditionTrue || cond.Reason != "DEGRADED" {
+		t.Fatalf("condition %+v, want True with reason DEGRADED", cond)
+	}
+	if SetPoolDegradedCondition(vol, "zfspv", "DEGRADED") {
+		t.Errorf("SetPoolDegradedCondition() changed an unchanged condition")
+	}
+	if !SetPoolDegradedCondition(vol, "zfspv", "FAULTED") {
+		t.Errorf("SetPoolDegradedCondition() did not follow the health of the pool")
+	}
+
+	// the health is not known, the condition is kept
+	if SetPoolDegradedCondition(vol, "zfspv", "") {
+		t.Errorf("SetPoolDegradedCondition() changed the condition of an unknown health")
+	}
+
+	if !SetPoolDegradedCondition(vol, "zfspv", "ONLINE") {
+		t.Errorf("SetPoolDegradedCondition() did not clear the condition")
+	}
+	cond = meta.FindStatusCondition(vol.Status.Conditions, ConditionPoolDegraded)
+	if cond == nil || cond.Status != metav1.ConditionFalse || cond.Reason != poolReasonRecovered {
+		t.Errorf("condition %+v, want False once the pool has recovered", cond)
+	}
+	if SetPoolDegradedCondition(vol, "zfspv", "ONLINE") {
+		t.Errorf("SetPoolDegradedCondition() cleared the condition twice")
+	}
+}
@@ -0,0 +1,254 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+)
+
+// the classes of the vdevs which do not hold the data, their header is
+// printed at the level of the pool name in the config of `zpool status`
+var vdevClasses = map[string]bool{
+	"logs": true, "cache": true, "spares": true, "special": true, "dedup": true,
+}
+
+// vdevWas is the former path of a missing device, printed after its
+// counters, e.g. "was /dev/disk/by-id/wwn-0x5000c500a1b2c3d4-part1"
+var vdevWas = regexp.MustCompile(`\bwas (/\S+)`)
+
+// lsblkPair is a pair of the output of `lsblk -P`, e.g. NAME="sdb"
+var lsblkPair = regexp.MustCompile(`([A-Z:-]+)="([^"]*)"`)
+
+// lsblkDevices runs `lsblk` for the block devices of the node, can be
+// replaced in unit tests
+var lsblkDevices = func(ctx context.Context) ([]byte, error) {
+	return exec.CommandContext(ctx, "lsblk", "-P", "-o", "NAME,KNAME,PKNAME,TYPE,SERIAL,WWN").CombinedOutput()
+}
+
+// resolveDevicePath resolves the symlinks of the path of a device, e.g. of
+// /dev/disk/by-id/wwn-0x5000c500a1b2c3d4-part1 to /dev/sdb1, can be
+// replaced in unit tests
+var resolveDevicePath = filepath.EvalSymlinks
+
+// parsePoolVdevs returns the vdevs of each pool from the config of the
+// output of `zpool status -P`, e.g.
+//
+//	config:
+//
+//		NAME                 STATE     READ WRITE CKSUM
+//		zfspv-pool           ONLINE       0     0     0
+//		  mirror-0           ONLINE       0     0     0
+//		    /dev/sdb1        ONLINE       0     0     0
+//		    /dev/sdc1        ONLINE       0     0     0
+//		logs
+//		  /dev/nvme0n1p1     ONLINE       0     0     0
+//
+//	errors: No known data errors
+//
+// The lines are indented with a tab and then with two spaces a level. The
+// devices of a vdev may be nested in a replacing-N or a spare-N vdev, only
+// the devices are kept. A missing device is printed as its guid, followed
+// by the path it was at.
+func parsePoolVdevs(raw []byte) (map[string][]apis.PoolVdev, error) {
+	pools := map[string][]apis.PoolVdev{}
+
+	var pool, class string
+	var vdevs []apis.PoolVdev
+	inConfig := false
+	scanner := bufio.NewScanner(strings.NewReader(string(raw)))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if m := statusHeader.FindStringSubmatch(line); m != nil {
+			switch m[1] {
+			case "pool":
+				pool = m[2]
+			case "config":
+				inConfig, class, vdevs = true, "", nil
+			default:
+				if inConfig && pool != "" {
+					pools[pool] = vdevs
+				}
+				inConfig = false
+			}
+			continue
+		}
+		if !inConfig || !strings.HasPrefix(line, "\t") {
+			continue
+		}
+		body := strings.TrimPrefix(line, "\t")
+		fields := strings.Fields(body)
+		if len(fields) == 0 || fields[0] == "NAME" {
+			continue
+		}
+		level := (len(body) - len(strings.TrimLeft(body, " "))) / 2
+		if level == 0 {
+			// the pool itself or the header of a class of vdevs
+			if vdevClasses[fields[0]] {
+				class = fields[0]
+			} else {
+				class = ""
+			}
+			continue
+		}
+		var state string
+		if len(fields) > 1 {
+			state = fields[1]
+		}
+		path := devicePath(body, fields[0])
+		if level == 1 {
+			vdev := apis.PoolVdev{Name: fields[0], Class: class, State: state}
+			if path != "" {
+				// a single device vdev
+				vdev.Name = path
+				vdev.Devices = []apis.PoolDevice{{Path: path, State: state}}
+			}
+			vdevs = append(vdevs, vdev)
+			continue
+		}
+		if len(vdevs) == 0 {
+			return nil, fmt.Errorf("zfs: invalid config of pool %s, device %q out of a vdev", pool, fields[0])
+		}
+		if path != "" {
+			last := &vdevs[len(vdevs)-1]
+			last.Devices = append(last.Devices, apis.PoolDevice{Path: path, State: state})
+		}
+	}
+	if inConfig && pool != "" {
+		pools[pool] = vdevs
+	}
+	return pools, scanner.Err()
+}
+
+// devicePath returns the path of the device of a line of the config, or
+// the path a missing device was at. It is empty for a line of a vdev
+// holding other ones, e.g. mirror-0 or replacing-1.
+func devicePath(line, name string) string {
+	if strings.HasPrefix(name, "/") {
+		return name
+	}
+	if m := vdevWas.FindStringSubmatch(line); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// blockDevice is a block device listed by lsblk
+type blockDevice struct {
+	name, kname, pkname, typ, serial, wwn string
+}
+
+// parseBlockDevices parses the output of
+// `lsblk -P -o NAME,KNAME,PKNAME,TYPE,SERIAL,WWN`, e.g.
+//
+//	NAME="sdb" KNAME="sdb" PKNAME="" TYPE="disk" SERIAL="ZA1B2C3D" WWN="0x5000c500a1b2c3d4"
+//	NAME="sdb1" KNAME="sdb1" PKNAME="sdb" TYPE="part" SERIAL="" WWN="0x5000c500a1b2c3d4"
+//
+// A multipath device is listed once under each of its paths, with the
+// path as its parent.
+func parseBlockDevices(raw []byte) []blockDevice {
+	var devices []blockDevice
+	scanner := bufio.NewScanner(strings.NewReader(string(raw)))
+	for scanner.Scan() {
+		var dev blockDevice
+		for _, m := range lsblkPair.FindAllStringSubmatch(scanner.Text(), -1) {
+			val := strings.TrimSpace(m[2])
+			switch m[1] {
+			case "NAME":
+				dev.name = val
+			case "KNAME":
+				dev.kname = val
+			case "PKNAME":
+				dev.pkname = val
+			case "TYPE":
+				dev.typ = val
+			case "SERIAL":
+				dev.serial = val
+			case "WWN":
+				dev.wwn = val
+			}
+		}
+		if dev.kname != "" {
+			devices = append(devices, dev)
+		}
+	}
+	return devices
+}
+
+// resolvePoolDevices sets the disk, the serial and the paths of a
+// multipath device of the devices of the vdevs. The path of a device is
+// resolved to its kernel name, e.g. sdb1 or dm-1, and a partition to the
+// disk or the multipath device holding it. The devices which can not be
+// resolved, e.g. a missing disk or a file, are left as they are.
+func resolvePoolDevices(devices []blockDevice, vdevs map[string][]apis.PoolVdev) {
+	byKname := map[string][]blockDevice{}
+	for _, dev := range devices {
+		byKname[dev.kname] = append(byKname[dev.kname], dev)
+	}
+	for _, pool := range vdevs {
+		for i := range pool {
+			for j := range pool[i].Devices {
+				resolvePoolDevice(byKname, &pool[i].Devices[j])
+			}
+		}
+	}
+}
+
+// resolvePoolDevice resolves a device of a vdev to its disk
+func resolvePoolDevice(byKname map[string][]blockDevice, device *apis.PoolDevice) {
+	path, err := resolveDevicePath(device.Path)
+	if err != nil || !strings.HasPrefix(path, "/dev/") {
+		return
+	}
+	records := byKname[filepath.Base(path)]
+	// a partition is resolved to its parent, bounded by the devices in
+	// case of a loop in the output
+	for n := 0; len(records) != 0 && records[0].typ == "part" && n < len(byKname); n++ {
+		records = byKname[records[0].pkname]
+	}
+	if len(records) == 0 {
+		return
+	}
+	disk := records[0]
+	device.Disk, device.Serial, device.WWN = disk.name, disk.serial, disk.wwn
+	if disk.typ != "mpath" {
+		return
+	}
+	device.Paths = nil
+	for _, r := range records {
+		if r.pkname == "" {
+			continue
+		}
+		device.Paths = append(device.Paths, r.pkname)
+		// the serial of the multipath device is the one of its paths
+		if p := byKname[r.pkname]; len(p) != 0 {
+			if device.Serial == "" {
+				device.Serial = p[0].serial
+			}
+			if device.WWN == "" {
+				device.WWN = p[0].wwn
+			}
+		}
+	}
+}
@@ -0,0 +1,190 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+)
+
+const zpoolStatusPathsOutput = `  pool: zfspv-pool
+ state: DEGRADED
+status: One or more devices could not be used because the label is missing or
+	invalid.  Sufficient replicas exist for the pool to continue
+	functioning in a degraded state.
+  scan: resilver in progress since Sun Oct 15 10:00:01 2026
+	5G / 10G scanned at 512M/s, 2.5G / 10G issued at 256M/s
+	2.5G resilvered, 25.00% done, 00:10:00 to go
+config:
+
+	NAME                                                STATE     READ WRITE CKSUM
+	zfspv-pool                                          DEGRADED     0     0     0
+	  mirror-0                                          ONLINE       0     0     0
+	    /dev/disk/by-id/wwn-0x5000c500a1b2c3d4-part1    ONLINE       0     0     0
+	    /dev/mapper/mpatha-part1                        ONLINE       0     0     0
+	  mirror-1                                          DEGRADED     0     0     0
+	    replacing-0                                     DEGRADED     0     0     0
+	      8472518904127348012                           UNAVAIL      0     0     0  was /dev/disk/by-id/wwn-0x5000c500deadbeef-part1
+	      /dev/sdd1                                     ONLINE       0     0     0  (resilvering)
+	    /dev/sde1                                       ONLINE       0     0     0
+	logs
+	  /dev/nvme0n1p1                                    ONLINE       0     0     0
+	cache
+	  /dev/nvme0n1p2                                    ONLINE       0     0     0
+	spares
+	  /dev/sdf1                                         AVAIL
+
+errors: No known data errors
+
+  pool: backup
+ state: ONLINE
+  scan: none requested
+config:
+
+	NAME              STATE     READ WRITE CKSUM
+	backup            ONLINE       0     0     0
+	  /var/tmp/file   ONLINE       0     0     0
+
+errors: No known data errors
+`
+
+const lsblkOutput = `NAME="sda" KNAME="sda" PKNAME="" TYPE="disk" SERIAL="ZA1B2C3D" WWN="0x5000c500a1b2c3d4"
+NAME="sda1" KNAME="sda1" PKNAME="sda" TYPE="part" SERIAL="" WWN="0x5000c500a1b2c3d4"
+NAME="sdb" KNAME="sdb" PKNAME="" TYPE="disk" SERIAL="MP0001  " WWN="0x600a0b800012"
+NAME="mpatha" KNAME="dm-0" PKNAME="sdb" TYPE="mpath" SERIAL="" WWN=""
+NAME="mpatha1" KNAME="dm-1" PKNAME="dm-0" TYPE="part" SERIAL="" WWN=""
+NAME="sdc" KNAME="sdc" PKNAME="" TYPE="disk" SERIAL="MP0001" WWN="0x600a0b800012"
+NAME="mpatha" KNAME="dm-0" PKNAME="sdc" TYPE="mpath" SERIAL="" WWN=""
+NAME="mpatha1" KNAME="dm-1" PKNAME="dm-0" TYPE="part" SERIAL="" WWN=""
+NAME="sdd" KNAME="sdd" PKNAME="" TYPE="disk" SERIAL="ZD4" WWN=""
+NAME="sdd1" KNAME="sdd1" PKNAME="sdd" TYPE="part" SERIAL="" WWN=""
+`
+
+func TestParsePoolVdevs(t *testing.T) {
+	vdevs, err := parsePoolVdevs([]byte(zpoolStatusPathsOutput))
+	if err != nil {
+		t.Fatalf("parsePoolVdevs() unexpected error %v", err)
+	}
+	want := map[string][]apis.PoolVdev{
+		"zfspv-pool": {
+			{Name: "mirror-0", State: "ONLINE", Devices: []apis.PoolDevice{
+				{Path: "/dev/disk/by-id/wwn-0x5000c500a1b2c3d4-part1", State: "ONLINE"},
+				{Path: "/dev/mapper/mpatha-part1", State: "ONLINE"},
+			}},
+			{Name: "mirror-1", State: "DEGRADED", Devices: []apis.PoolDevice{
+				{Path: "/dev/disk/by-id/wwn-0x5000c500deadbeef-part1", State: "UNAVAIL"},
+				{Path: "/dev/sdd1", State: "ONLINE"},
+				{Path: "/dev/sde1", State: "ONLINE"},
+			}},
+			{Name: "/dev/nvme0n1p1", Class: "logs", State: "ONLINE", Devices: []apis.PoolDevice{
+				{Path: "/dev/nvme0n1p1", State: "ONLINE"}}},
+			{Name: "/dev/nvme0n1p2", Class: "cache", State: "ONLINE", Devices: []apis.PoolDevice{
+				{Path: "/dev/nvme0n1p2", State: "ONLINE"}}},
+			{Name: "/dev/sdf1", Class: "spares", State: "AVAIL", Devices: []apis.PoolDevice{
+				{Path: "/dev/sdf1", State: "AVAIL"}}},
+		},
+		"backup": {
+			{Name: "/var/tmp/file", State: "ONLINE", Devices: []apis.PoolDevice{
+				{Path: "/var/tmp/file", State: "ONLINE"}}},
+		},
+	}
+	if !reflect.DeepEqual(vdevs, want) {
+		t.Errorf("parsePoolVdevs() = %+v, want %+v", vdevs, want)
+	}
+
+	if _, err := parsePoolVdevs([]byte("  pool: p\nconfig:\n\n\tp  ONLINE\n\t    /dev/sdb  ONLINE\n")); err == nil {
+		t.Errorf("parsePoolVdevs() expected error for a device out of a vdev")
+	}
+}
+
+func TestResolvePoolDevices(t *testing.T) {
+	defer func(f func(string) (string, error)) { resolveDevicePath = f }(resolveDevicePath)
+	links := map[string]string{
+		"/dev/disk/by-id/wwn-0x5000c500a1b2c3d4-part1": "/dev/sda1",
+		"/dev/mapper/mpatha-part1":                     "/dev/dm-1",
+		"/dev/sdd1":                                    "/dev/sdd1",
+		"/var/tmp/file":                                "/var/tmp/file",
+	}
+	resolveDevicePath = func(path string) (string, error) {
+		if p, ok := links[path]; ok {
+			return p, nil
+		}
+		return "", os.ErrNotExist
+	}
+
+	vdevs := map[string][]apis.PoolVdev{
+		"zfspv-pool": {{Name: "mirror-0", Devices: []apis.PoolDevice{
+			{Path: "/dev/disk/by-id/wwn-0x5000c500a1b2c3d4-part1"},
+			{Path: "/dev/mapper/mpatha-part1"},
+			{Path: "/dev/disk/by-id/wwn-0x5000c500deadbeef-part1"},
+			{Path: "/dev/sdd1"},
+		}}},
+		"backup": {{Name: "/var/tmp/file", Devices: []apis.PoolDevice{{Path: "/var/tmp/file"}}}},
+	}
+	resolvePoolDevices(parseBlockDevices([]byte(lsblkOutput)), vdevs)
+
+	want := []apis.PoolDevice{
+		{Path: "/dev/disk/by-id/wwn-0x5000c500a1b2c3d4-part1", Disk: "sda", Serial: "ZA1B2C3D", WWN: "0x5000c500a1b2c3d4"},
+		{Path: "/dev/mapper/mpatha-part1", Disk: "mpatha", Serial: "MP0001", WWN: "0x600a0b800012",
+			Paths: []string{"sdb", "sdc"}},
+		{Path: "/dev/disk/by-id/wwn-0x5000c500deadbeef-part1"},
+		{Path: "/dev/sdd1", Disk: "sdd", Serial: "ZD4"},
+	}
+	if got := vdevs["zfspv-pool"][0].Devices; !reflect.DeepEqual(got, want) {
+		t.Errorf("resolvePoolDevices() = %+v, want %+v", got, want)
+	}
+	if got := vdevs["backup"][0].Devices[0]; got.Disk != "" || got.Serial != "" {
+		t.Errorf("resolvePoolDevices() resolved a file to %+v", got)
+	}
+}
+
+func TestListPoolStatus(t *testing.T) {
+	defer func(f func(context.Context) ([]byte, error)) { lsblkDevices = f }(lsblkDevices)
+	defer func(f func(string) (string, error)) { resolveDevicePath = f }(resolveDevicePath)
+	f := newFakeZFS(t)
+	f.Pool(t, "status", zpoolStatusPathsOutput)
+	lsblkDevices = func(context.Context) ([]byte, error) { return []byte(lsblkOutput), nil }
+	resolveDevicePath = func(path string) (string, error) { return path, nil }
+
+	scans, vdevs, err := ListPoolStatus(context.Background())
+	if err != nil {
+		t.Fatalf("ListPoolStatus() unexpected error %v", err)
+	}
+	if scans["zfspv-pool"] == nil || scans["backup"] != nil {
+		t.Errorf("ListPoolStatus() scans = %+v", scans)
+	}
+	if dev := vdevs["zfspv-pool"][1].Devices[1]; dev.Disk != "sdd" || dev.Serial != "ZD4" {
+		t.Errorf("ListPoolStatus() device = %+v, want disk sdd", dev)
+	}
+
+	// the vdevs are still reported if lsblk fails
+	lsblkDevices = func(context.Context) ([]byte, error) { return nil, errors.New("exit status 32") }
+	if _, vdevs, err = ListPoolStatus(context.Background()); err != nil || len(vdevs["zfspv-pool"]) != 5 ||
+		vdevs["zfspv-pool"][1].Devices[1].Disk != "" {
+		t.Errorf("ListPoolStatus() without lsblk = %+v, %v", vdevs, err)
+	}
+
+	f.Fail(t, "zpool status", "cannot open pools")
+	if _, _, err = ListPoolStatus(context.Background()); err == nil {
+		t.Errorf("ListPoolStatus() expected error if the status can not be read")
+	}
+}
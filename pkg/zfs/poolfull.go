@@ -0,0 +1,283 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"github.com/openebs/zfs-localpv/pkg/builder/bkpbuilder"
+	"github.com/openebs/zfs-localpv/pkg/builder/snapbuilder"
+	"github.com/openebs/zfs-localpv/pkg/builder/volbuilder"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// DefaultPoolFullThreshold is used when the threshold is not set
+	DefaultPoolFullThreshold = 90
+
+	// PoolCleanupOff only reports the full pools
+	PoolCleanupOff = "off"
+	// PoolCleanupSnapshots destroys the oldest unreferenced snapshots of
+	// the volumes of a full pool until it is back below the threshold
+	PoolCleanupSnapshots = "unreferenced-snapshots"
+
+	// PoolConditionFull is the condition type of the pool summary
+	// reporting that the pool is filled above the threshold
+	PoolConditionFull = "PoolFull"
+
+	// reasons of the PoolFull condition
+	fullReasonAbove = "CapacityAboveThreshold"
+	fullReasonBelow = "CapacityBelowThreshold"
+)
+
+var (
+	// PoolFullThreshold is the percent of the size of a pool above which
+	// it is reported as full, 0 disables the detection
+	PoolFullThreshold = DefaultPoolFullThreshold
+
+	// PoolFullCleanup is what is done to reclaim the space of a full pool
+	PoolFullCleanup = PoolCleanupOff
+)
+
+// listPoolSnapshots runs `zfs list` for the snapshots of the pool, oldest
+// first, can be replaced in unit tests
+var listPoolSnapshots = func(pool string) ([]byte, error) {
+	return zfsCommand(ZFSListArg, "-H", "-p", "-t", "snapshot", "-o", "name,used,clones",
+		"-s", "createtxg", "-r", pool).CombinedOutput()
+}
+
+// destroyPoolSnapshot destroys a zfs snapshot, can be replaced in unit tests
+var destroyPoolSnapshot = func(snapshot string) error {
+	out, err := zfsCommand(ZFSDestroyArg, snapshot).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("zfs: could not destroy snapshot %s: %v: %s", snapshot, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// SetPoolFull validates and sets the percent of the size of a pool above
+// which it is reported as full and what is done to reclaim its space
+func SetPoolFull(threshold int, cleanup string) error {
+	if threshold < 0 || threshold > 100 {
+		return fmt.Errorf("invalid pool full threshold %d, it should be a percent between 0 and 100", threshold)
+	}
+	switch cleanup {
+	case PoolCleanupOff, PoolCleanupSnapshots:
+	default:
+		return fmt.Errorf("invalid pool full cleanup %q, it should be %s or %s",
+			cleanup, PoolCleanupOff, PoolCleanupSnapshots)
+	}
+	PoolFullThreshold, PoolFullCleanup = threshold, cleanup
+	return nil
+}
+
+// PoolFillPercent returns the percent of the size of the pool allocated
+func PoolFillPercent(pool *apis.PoolSummary) int64 {
+	size := pool.Size.Value()
+	if size <= 0 {
+		return 0
+	}
+	return pool.Allocated.Value() * 100 / size
+}
+
+// poolFullCondition returns the PoolFull condition of the pool, it is true
+// once the pool is filled up to the threshold
+func poolFullCondition(pool *apis.PoolSummary, threshold int) metav1.Condition {
+	percent := PoolFillPercent(pool)
+	if percent < int64(threshold) {
+		return metav1.Condition{
+			Type:    PoolConditionFull,
+			Status:  metav1.ConditionFalse,
+			Reason:  fullReasonBelow,
+			Message: fmt.Sprintf("pool is %d%% full, below the threshold of %d%%", percent, threshold),
+		}
+	}
+	return metav1.Condition{
+		Type:   PoolConditionFull,
+		Status: metav1.ConditionTrue,
+		Reason: fullReasonAbove,
+		Message: fmt.Sprintf("pool is %d%% full, above the threshold of %d%%, %s free, the writes will fail with ENOSPC once it is full",
+			percent, threshold, pool.Free.String()),
+	}
+}
+
+// setPoolFullConditions sets the PoolFull condition of each pool in the
+// summary
+func setPoolFullConditions(summary []apis.PoolSummary, threshold int) {
+	for i := range summary {
+		meta.SetStatusCondition(&summary[i].Conditions, poolFullCondition(&summary[i], threshold))
+	}
+}
+
+// PoolFull tells whether the pool summary reports the pool as full
+func PoolFull(pool *apis.PoolSummary) bool {
+	return meta.IsStatusConditionTrue(pool.Conditions, PoolConditionFull)
+}
+
+// PoolReclaimBytes returns the space to reclaim to bring the pool back
+// below the threshold
+func PoolReclaimBytes(pool *apis.PoolSummary, threshold int) int64 {
+	need := pool.Allocated.Value() - pool.Size.Value()*int64(threshold)/100
+	if need < 0 {
+		return 0
+	}
+	return need
+}
+
+// SnapshotReferences are the datasets of the volumes of this node and the
+// snapshots which must not be cleaned up
+type SnapshotReferences struct {
+	// Volumes are the datasets of the volumes of this node, only their
+	// snapshots are cleaned up
+	Volumes map[string]bool
+	// Snapshots are the snapshots of the ZFSSnapshots and of the backups
+	Snapshots map[string]bool
+}
+
+// ListSnapshotReferences lists the volumes of this node, the ZFSSnapshots
+// and the ZFSBackups to find the unreferenced snapshots
+func ListSnapshotReferences() (*SnapshotReferences, error) {
+	vols, err := volbuilder.NewKubeclient().WithNamespace(OpenEBSNamespace).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("zfs: could not list the volumes: %v", err)
+	}
+	snaps, err := snapbuilder.NewKubeclient().WithNamespace(OpenEBSNamespace).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("zfs: could not list the snapshots: %v", err)
+	}
+	bkps, err := bkpbuilder.NewKubeclient().WithNamespace(OpenEBSNamespace).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("zfs: could not list the backups: %v", err)
+	}
+	return snapshotReferences(vols.Items, snaps.Items, bkps.Items), nil
+}
+
+// snapshotReferences returns the references of the volumes, the snapshots
+// and the backups
+func snapshotReferences(vols []apis.ZFSVolume, snaps []apis.ZFSSnapshot, bkps []apis.ZFSBackup) *SnapshotReferences {
+	refs := &SnapshotReferences{Volumes: map[string]bool{}, Snapshots: map[string]bool{}}
+	datasets := map[string]string{}
+	for i := range vols {
+		dataset := VolumeDataset(&vols[i])
+		datasets[vols[i].Name] = dataset
+		if vols[i].Spec.OwnerNodeID == NodeID {
+			refs.Volumes[dataset] = true
+		}
+	}
+	for i := range snaps {
+		refs.Snapshots[SnapshotDataset(&snaps[i])] = true
+	}
+	for _, bkp := range bkps {
+		dataset, ok := datasets[bkp.Spec.VolumeName]
+		if !ok {
+			continue
+		}
+		for _, name := range []string{bkp.Spec.SnapName, bkp.Spec.PrevSnapName} {
+			if name != "" {
+				refs.Snapshots[dataset+"@"+name] = true
+			}
+		}
+	}
+	return refs
+}
+
+// poolSnapshot is a snapshot of a pool as listed for the cleanup
+type poolSnapshot struct {
+	name   string
+	used   int64
+	cloned bool
+}
+
+// parsePoolSnapshots parses the output of
+// `zfs list -H -p -t snapshot -o name,used,clones`, e.g.
+// zfspv-pool/pvc-1@snap-1	1048576	zfspv-pool/pvc-2
+// The clones are empty for a snapshot which has none.
+func parsePoolSnapshots(raw []byte) ([]poolSnapshot, error) {
+	var snaps []poolSnapshot
+	scanner := bufio.NewScanner(strings.NewReader(string(raw)))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		items := strings.Split(line, "\t")
+		if len(items) < 2 || len(items) > 3 {
+			return nil, fmt.Errorf("zfs: invalid snapshot list output %q", line)
+		}
+		used, err := strconv.ParseInt(items[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("zfs: invalid used space %q of snapshot %s", items[1], items[0])
+		}
+		snap := poolSnapshot{name: items[0], used: used}
+		if len(items) == 3 {
+			clones := strings.TrimSpace(items[2])
+			snap.cloned = clones != "" && clones != "-"
+		}
+		snaps = append(snaps, snap)
+	}
+	return snaps, scanner.Err()
+}
+
+// unreferencedSnapshot tells whether the snapshot may be cleaned up, it
+// must belong to a volume of this node, not be referenced and not have
+// clones. The snapshots made by the driver for its own operations are
+// kept.
+func unreferencedSnapshot(snap poolSnapshot, refs *SnapshotReferences) bool {
+	parts := strings.SplitN(snap.name, "@", 2)
+	if len(parts) != 2 || strings.HasPrefix(parts[1], "openebs-") {
+		return false
+	}
+	return refs.Volumes[parts[0]] && !refs.Snapshots[snap.name] && !snap.cloned
+}
+
+// CleanupPoolSnapshots destroys the oldest unreferenced snapshots of the
+// volumes of the pool until the space they use reaches need bytes. It
+// returns the destroyed snapshots, the cleanup stops at the first failure.
+func CleanupPoolSnapshots(pool string, need int64, refs *SnapshotReferences) ([]string, error) {
+	out, err := listPoolSnapshots(pool)
+	if err != nil {
+		return nil, fmt.Errorf("zfs: could not list the snapshots of pool %s: %v: %s", pool, err, strings.TrimSpace(string(out)))
+	}
+	snaps, err := parsePoolSnapshots(out)
+	if err != nil {
+		return nil, err
+	}
+
+	var destroyed []string
+	var reclaimed int64
+	for _, snap := range snaps {
+		if reclaimed >= need {
+			break
+		}
+		if !unreferencedSnapshot(snap, refs) {
+			continue
+		}
+		if err := destroyPoolSnapshot(snap.name); err != nil {
+			return destroyed, err
+		}
+		klog.Infof("zfs: destroyed snapshot %s of full pool %s, reclaiming %d bytes", snap.name, pool, snap.used)
+		destroyed = append(destroyed, snap.name)
+		reclaimed += snap.used
+	}
+	return destroyed, nil
+}
@@ -0,0 +1,189 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSetPoolFull(t *testing.T) {
+	defer func(n int, cleanup string) { PoolFullThreshold, PoolFullCleanup = n, cleanup }(PoolFullThreshold, PoolFullCleanup)
+
+	for _, n := range []int{0, 85, 100} {
+		if err := SetPoolFull(n, PoolCleanupSnapshots); err != nil || PoolFullThreshold != n || PoolFullCleanup != PoolCleanupSnapshots {
+			t.Errorf("SetPoolFull(%d) = %v, threshold %d, cleanup %q", n, err, PoolFullThreshold, PoolFullCleanup)
+		}
+	}
+	for _, n := range []int{-1, 101} {
+		if err := SetPoolFull(n, PoolCleanupOff); err == nil {
+			t.Errorf("SetPoolFull(%d) succeeded", n)
+		}
+	}
+	for _, cleanup := range []string{"", "all-snapshots"} {
+		if err := SetPoolFull(DefaultPoolFullThreshold, cleanup); err == nil {
+			t.Errorf("SetPoolFull() accepted the cleanup %q", cleanup)
+		}
+	}
+}
+
+func filledPool(allocated string) *apis.PoolSummary {
+	return &apis.PoolSummary{Name: "zfspv", Size: resource.MustParse("100Gi"),
+		Allocated: resource.MustParse(allocated), Free: resource.MustParse("10Gi")}
+}
+
+func TestPoolFullCondition(t *testing.T) {
+	tests := map[string]struct {
+		allocated string
+		threshold int
+		want      metav1.ConditionStatus
+	}{
+		"below":         {"89Gi", 90, metav1.ConditionFalse},
+		"at threshold":  {"90Gi", 90, metav1.ConditionTrue},
+		"above":         {"97Gi", 90, metav1.ConditionTrue},
+		"low threshold": {"50Gi", 40, metav1.ConditionTrue},
+	}
+	for name, tt := range tests {
+		cond := poolFullCondition(filledPool(tt.allocated), tt.threshold)
+		if cond.Type != PoolConditionFull || cond.Status != tt.want {
+			t.Errorf("%s: poolFullCondition() = %+v, want %s", name, cond, tt.want)
+		}
+	}
+
+	// an empty summary is never full
+	if cond := poolFullCondition(&apis.PoolSummary{}, 90); cond.Status != metav1.ConditionFalse {
+		t.Errorf("poolFullCondition() of an unknown size = %s", cond.Status)
+	}
+
+	summary := []apis.PoolSummary{*filledPool("95Gi"), *filledPool("10Gi")}
+	setPoolFullConditions(summary, 90)
+	if !PoolFull(&summary[0]) || PoolFull(&summary[1]) {
+		t.Errorf("setPoolFullConditions() = %+v", summary)
+	}
+}
+
+func TestPoolReclaimBytes(t *testing.T) {
+	if got, want := PoolReclaimBytes(filledPool("95Gi"), 90), int64(5<<30); got != want {
+		t.Errorf("PoolReclaimBytes() = %d, want %d", got, want)
+	}
+	if got := PoolReclaimBytes(filledPool("80Gi"), 90); got != 0 {
+		t.Errorf("PoolReclaimBytes() below the threshold = %d", got)
+	}
+}
+
+func TestParsePoolSnapshots(t *testing.T) {
+	out := "zfspv/pvc-1@daily-1\t1048576\t\n" +
+		"zfspv/pvc-1@daily-2\t2048\tzfspv/pvc-2\n" +
+		"zfspv/pvc-1@daily-3\t0\t-\n"
+	snaps, err := parsePoolSnapshots([]byte(out))
+	if err != nil {
+		t.Fatalf("parsePoolSnapshots() failed: %v", err)
+	}
+	want := []poolSnapshot{
+		{name: "zfspv/pvc-1@daily-1", used: 1048576},
+		{name: "zfspv/pvc-1@daily-2", used: 2048, cloned: true},
+		{name: "zfspv/pvc-1@daily-3"},
+	}
+	if !reflect.DeepEqual(snaps, want) {
+		t.Errorf("parsePoolSnapshots() = %+v, want %+v", snaps, want)
+	}
+
+	if _, err := parsePoolSnapshots([]byte("zfspv/pvc-1@daily-1\tlots\t\n")); err == nil {
+		t.Errorf("parsePoolSnapshots() accepted an invalid size")
+	}
+}
+
+func TestSnapshotReferences(t *testing.T) {
+	defer func(id string) { NodeID = id }(NodeID)
+	NodeID = "node-1"
+
+	vol := func(name, node string) apis.ZFSVolume {
+		v := apis.ZFSVolume{}
+		v.Name = name
+		v.Spec.PoolName = "zfspv"
+		v.Spec.OwnerNodeID = node
+		return v
+	}
+	snap := apis.ZFSSnapshot{}
+	snap.Name = "snapshot-1"
+	snap.Labels = map[string]string{ZFSVolKey: "pvc-1"}
+	snap.Spec.PoolName = "zfspv"
+	bkp := apis.ZFSBackup{}
+	bkp.Spec.VolumeName = "pvc-1"
+	bkp.Spec.SnapName = "backup-2"
+	bkp.Spec.PrevSnapName = "backup-1"
+
+	refs := snapshotReferences([]apis.ZFSVolume{vol("pvc-1", "node-1"), vol("pvc-2", "node-2")},
+		[]apis.ZFSSnapshot{snap}, []apis.ZFSBackup{bkp})
+	if !reflect.DeepEqual(refs.Volumes, map[string]bool{"zfspv/pvc-1": true}) {
+		t.Errorf("snapshotReferences() volumes = %v", refs.Volumes)
+	}
+	want := map[string]bool{
+		"zfspv/pvc-1@snapshot-1": true,
+		"zfspv/pvc-1@backup-2":   true,
+		"zfspv/pvc-1@backup-1":   true,
+	}
+	if !reflect.DeepEqual(refs.Snapshots, want) {
+		t.Errorf("snapshotReferences() snapshots = %v, want %v", refs.Snapshots, want)
+	}
+}
+
+func TestCleanupPoolSnapshots(t *testing.T) {
+	defer func(f func(string) ([]byte, error)) { listPoolSnapshots = f }(listPoolSnapshots)
+	defer func(f func(string) error) { destroyPoolSnapshot = f }(destroyPoolSnapshot)
+	listPoolSnapshots = func(pool string) ([]byte, error) {
+		return []byte("zfspv/pvc-1@daily-1\t100\t\n" +
+			"zfspv/pvc-1@snapshot-1\t100\t\n" +
+			"zfspv/pvc-1@daily-2\t100\tzfspv/pvc-3\n" +
+			"zfspv/pvc-1@openebs-defrag\t100\t\n" +
+			"zfspv/pvc-2@daily-1\t100\t\n" +
+			"zfspv/pvc-1@daily-3\t100\t\n" +
+			"zfspv/pvc-1@daily-4\t100\t\n"), nil
+	}
+	var destroyed []string
+	var destroyErr error
+	destroyPoolSnapshot = func(snapshot string) error {
+		if destroyErr != nil {
+			return destroyErr
+		}
+		destroyed = append(destroyed, snapshot)
+		return nil
+	}
+	refs := &SnapshotReferences{
+		Volumes:   map[string]bool{"zfspv/pvc-1": true},
+		Snapshots: map[string]bool{"zfspv/pvc-1@snapshot-1": true},
+	}
+
+	// the referenced, cloned and internal snapshots and the ones of the
+	// volumes of the other nodes are kept, the oldest go first
+	got, err := CleanupPoolSnapshots("zfspv", 150, refs)
+	want := []string{"zfspv/pvc-1@daily-1", "zfspv/pvc-1@daily-3"}
+	if err != nil || !reflect.DeepEqual(got, want) || !reflect.DeepEqual(destroyed, want) {
+		t.Errorf("CleanupPoolSnapshots() = %v, %v, want %v", got, err, want)
+	}
+
+	destroyed = nil
+	destroyErr = errors.New("dataset is busy")
+	if got, err := CleanupPoolSnapshots("zfspv", 150, refs); err == nil || len(got) != 0 {
+		t.Errorf("CleanupPoolSnapshots() = %v, %v, want the failure", got, err)
+	}
+}
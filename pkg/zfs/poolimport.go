@@ -0,0 +1,201 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// results of a pool import
+	PoolImported      = "Imported"
+	PoolImportRefused = "Refused"
+	PoolImportFailed  = "Failed"
+
+	// poolImportTimeout bounds the time a `zpool import` may take
+	poolImportTimeout = 5 * time.Minute
+)
+
+// PoolAutoImport tells whether the expected pools of the ZFSNode which are
+// not imported are imported by the node agent, it is disabled by default
+var PoolAutoImport bool
+
+// seams for the unit tests
+var (
+	zpoolImportList = func(ctx context.Context) ([]byte, error) {
+		return zpoolCommand(ctx, "import").CombinedOutput()
+	}
+	zpoolImport = func(ctx context.Context, id string) ([]byte, error) {
+		// the datasets are not mounted, the driver mounts the volumes
+		return zpoolCommand(ctx, "import", "-N", id).CombinedOutput()
+	}
+)
+
+// importablePool is a pool listed by `zpool import`
+type importablePool struct {
+	Name   string
+	ID     string
+	State  string
+	Status string
+	Action string
+}
+
+var importField = regexp.MustCompile(`^\s*(pool|id|state|status|action|see|config):\s?(.*)$`)
+
+// parseImportablePools parses the `zpool import` output, e.g.
+//
+//	   pool: zfspv-pool
+//	     id: 15451357997522795478
+//	  state: ONLINE
+//	 status: The pool was last accessed by another system.
+//	 action: The pool can be imported using its name or numeric identifier and
+//		the '-f' flag.
+//	 config:
+//
+// The status and the action may be continued on the next lines.
+func parseImportablePools(out []byte) []importablePool {
+	var pools []importablePool
+	var cur *importablePool
+	field := ""
+	scanner := bufio.NewScanner(strings.NewReader(string(out)))
+	for scanner.Scan() {
+		line := scanner.Text()
+		m := importField.FindStringSubmatch(line)
+		if m == nil {
+			// continued status or action
+			if cur != nil && strings.TrimSpace(line) != "" {
+				switch field {
+				case "status":
+					cur.Status += " " + strings.TrimSpace(line)
+				case "action":
+					cur.Action += " " + strings.TrimSpace(line)
+				}
+			}
+			continue
+		}
+		field = m[1]
+		val := strings.TrimSpace(m[2])
+		if field == "pool" {
+			pools = append(pools, importablePool{Name: val})
+			cur = &pools[len(pools)-1]
+			continue
+		}
+		if cur == nil {
+			continue
+		}
+		switch field {
+		case "id":
+			cur.ID = val
+		case "state":
+			cur.State = val
+		case "status":
+			cur.Status = val
+		case "action":
+			cur.Action = val
+		}
+	}
+	return pools
+}
+
+// importRefusal returns why the pool is not imported, and whether it is
+// because the pool might be in use by another host. An empty reason means
+// the pool can be imported. The pool is never forced, `zpool import` also
+// refuses a pool whose multihost protection sees it active elsewhere.
+func importRefusal(p importablePool) (string, bool) {
+	hint := strings.ToLower(p.Status + " " + p.Action)
+	if strings.Contains(hint, "another system") || strings.Contains(hint, "hostid") ||
+		strings.Contains(hint, "'-f'") {
+		return fmt.Sprintf("pool %s might be in use by another host: %s", p.Name, p.Status), true
+	}
+	switch p.State {
+	case "ONLINE", "DEGRADED":
+		return "", false
+	}
+	return fmt.Sprintf("pool %s is %s: %s", p.Name, p.State, p.Status), false
+}
+
+// MissingPools returns the expected pools which are not imported
+func MissingPools(expected []string, pools []apis.Pool) []string {
+	imported := make(map[string]bool, len(pools))
+	for _, p := range pools {
+		imported[p.Name] = true
+	}
+	var missing []string
+	for _, name := range expected {
+		if name != "" && !imported[name] {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+// ImportPool imports the exported pool without forcing it. It is refused
+// if the pool was last used by another host, as per its hostid, or if
+// more than one importable pool has the name.
+func ImportPool(name string) apis.PoolImport {
+	ctx, cancel := context.WithTimeout(context.Background(), poolImportTimeout)
+	defer cancel()
+
+	result := apis.PoolImport{Name: name, LastAttempt: metav1.Now()}
+	fail := func(res, format string, args ...interface{}) apis.PoolImport {
+		result.Result, result.Message = res, fmt.Sprintf(format, args...)
+		klog.Warningf("zfs: pool import: %s", result.Message)
+		return result
+	}
+
+	// zpool exits with an error when there is no pool to import
+	out, err := zpoolImportList(ctx)
+	if err != nil && !strings.Contains(string(out), "no pools available") {
+		return fail(PoolImportFailed, "could not list the importable pools: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	var found []importablePool
+	for _, p := range parseImportablePools(out) {
+		if p.Name == name {
+			found = append(found, p)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return fail(PoolImportFailed, "pool %s is not importable on node %s", name, NodeID)
+	case 1:
+	default:
+		return fail(PoolImportRefused, "%d importable pools are named %s, import the right one by id", len(found), name)
+	}
+
+	if reason, inUse := importRefusal(found[0]); reason != "" {
+		if inUse {
+			return fail(PoolImportRefused, "%s", reason)
+		}
+		return fail(PoolImportFailed, "%s", reason)
+	}
+
+	if out, err = zpoolImport(ctx, found[0].ID); err != nil {
+		return fail(PoolImportFailed, "zpool import %s failed: %v: %s", name, err, strings.TrimSpace(string(out)))
+	}
+	klog.Infof("zfs: imported pool %s (id %s)", name, found[0].ID)
+	result.Result, result.Message = PoolImported, fmt.Sprintf("pool %s imported on node %s", name, NodeID)
+	return result
+}
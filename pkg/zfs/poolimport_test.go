@@ -0,0 +1,165 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+)
+
+const importableOutput = `   pool: zfspv-pool
+     id: 15451357997522795478
+  state: ONLINE
+ action: The pool can be imported using its name or numeric identifier.
+ config:
+
+	zfspv-pool  ONLINE
+	  sdb       ONLINE
+
+   pool: shared-pool
+     id: 1186443730583185387
+  state: ONLINE
+ status: The pool was last accessed by another system.
+ action: The pool can be imported using its name or numeric identifier and
+	the '-f' flag.
+   see: https://openzfs.github.io/openzfs-docs/msg/ZFS-8000-EY
+ config:
+
+	shared-pool  ONLINE
+	  sdc        ONLINE
+
+   pool: broken-pool
+     id: 9012
+  state: UNAVAIL
+ status: One or more devices are missing from the system.
+ action: The pool cannot be imported. Attach the missing
+	devices and try again.
+ config:
+
+	broken-pool  UNAVAIL  insufficient replicas
+	  sdd        UNAVAIL
+`
+
+// fakeImport serves the zpool import output and records the imports
+type fakeImport struct {
+	out      string
+	listErr  error
+	imported []string
+}
+
+func (f *fakeImport) install(t *testing.T) {
+	origList, origImport := zpoolImportList, zpoolImport
+	t.Cleanup(func() { zpoolImportList, zpoolImport = origList, origImport })
+	zpoolImportList = func(context.Context) ([]byte, error) { return []byte(f.out), f.listErr }
+	zpoolImport = func(_ context.Context, id string) ([]byte, error) {
+		f.imported = append(f.imported, id)
+		return nil, nil
+	}
+}
+
+func TestParseImportablePools(t *testing.T) {
+	pools := parseImportablePools([]byte(importableOutput))
+	if len(pools) != 3 {
+		t.Fatalf("parseImportablePools() = %+v, want 3 pools", pools)
+	}
+	if pools[0].Name != "zfspv-pool" || pools[0].ID != "15451357997522795478" || pools[0].State != "ONLINE" {
+		t.Errorf("first pool = %+v", pools[0])
+	}
+	if !strings.HasSuffix(pools[1].Action, "the '-f' flag.") {
+		t.Errorf("continued action not parsed: %q", pools[1].Action)
+	}
+	if pools[2].State != "UNAVAIL" {
+		t.Errorf("third pool = %+v", pools[2])
+	}
+}
+
+func TestImportRefusal(t *testing.T) {
+	pools := parseImportablePools([]byte(importableOutput))
+	if reason, _ := importRefusal(pools[0]); reason != "" {
+		t.Errorf("importRefusal() of an exported pool = %q", reason)
+	}
+	if reason, inUse := importRefusal(pools[1]); reason == "" || !inUse {
+		t.Errorf("importRefusal() of a pool of another host = %q, %v", reason, inUse)
+	}
+	mmp := importablePool{Name: "mmp-pool", State: "UNAVAIL",
+		Status: "The pool is currently imported by another system.",
+		Action: "The pool must be exported from node2 (hostid=7f0001) before it can be safely imported."}
+	if reason, inUse := importRefusal(mmp); reason == "" || !inUse {
+		t.Errorf("importRefusal() of a pool active on another host = %q, %v", reason, inUse)
+	}
+	if reason, inUse := importRefusal(pools[2]); reason == "" || inUse {
+		t.Errorf("importRefusal() of an unavailable pool = %q, %v", reason, inUse)
+	}
+}
+
+func TestMissingPools(t *testing.T) {
+	pools := []apis.Pool{{Name: "zfspv-pool"}}
+	if got := MissingPools([]string{"zfspv-pool"}, pools); len(got) != 0 {
+		t.Errorf("MissingPools() of imported pools = %v", got)
+	}
+	if got := MissingPools([]string{"zfspv-pool", "data", ""}, pools); !reflect.DeepEqual(got, []string{"data"}) {
+		t.Errorf("MissingPools() = %v, want [data]", got)
+	}
+}
+
+func TestImportPool(t *testing.T) {
+	f := &fakeImport{out: importableOutput}
+	f.install(t)
+
+	res := ImportPool("zfspv-pool")
+	if res.Result != PoolImported || !reflect.DeepEqual(f.imported, []string{"15451357997522795478"}) {
+		t.Errorf("ImportPool() = %+v, imported %v", res, f.imported)
+	}
+
+	f.imported = nil
+	tests := map[string]string{
+		"shared-pool": PoolImportRefused,
+		"broken-pool": PoolImportFailed,
+		"gone-pool":   PoolImportFailed,
+	}
+	for name, want := range tests {
+		if res := ImportPool(name); res.Result != want || res.Message == "" {
+			t.Errorf("ImportPool(%s) = %+v, want %s", name, res, want)
+		}
+	}
+	if len(f.imported) != 0 {
+		t.Errorf("ImportPool() imported %v", f.imported)
+	}
+}
+
+func TestImportPoolDuplicateName(t *testing.T) {
+	f := &fakeImport{out: "   pool: data\n     id: 1\n  state: ONLINE\n   pool: data\n     id: 2\n  state: ONLINE\n"}
+	f.install(t)
+
+	if res := ImportPool("data"); res.Result != PoolImportRefused || len(f.imported) != 0 {
+		t.Errorf("ImportPool() of an ambiguous name = %+v, imported %v", res, f.imported)
+	}
+}
+
+func TestImportPoolNothingToImport(t *testing.T) {
+	f := &fakeImport{out: "no pools available to import\n", listErr: errors.New("exit status 1")}
+	f.install(t)
+
+	if res := ImportPool("zfspv-pool"); res.Result != PoolImportFailed || !strings.Contains(res.Message, "not importable") {
+		t.Errorf("ImportPool() without importable pools = %+v", res)
+	}
+}
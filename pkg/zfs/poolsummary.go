@@ -0,0 +1,189 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// DefaultPoolSummaryInterval is the interval at which the capacity
+	// summary of the pools is refreshed when it is not set
+	DefaultPoolSummaryInterval = time.Minute
+
+	// poolSummaryTimeout bounds the time a single `zpool list` may take,
+	// so a hung pool does not block the node agent
+	poolSummaryTimeout = 30 * time.Second
+)
+
+// PoolSummaryInterval is the interval at which the node agent refreshes
+// the capacity summary of the pools
+var PoolSummaryInterval = DefaultPoolSummaryInterval
+
+// SetPoolSummaryInterval validates and sets the interval at which the
+// capacity summary of the pools is refreshed, 0 disables the summary
+func SetPoolSummaryInterval(interval time.Duration) error {
+	if interval < 0 {
+		return fmt.Errorf("invalid pool summary interval %v, it should be a positive duration", interval)
+	}
+	PoolSummaryInterval = interval
+	return nil
+}
+
+// ListPoolSummary returns the capacity summary of all the pools on the
+// node. All the pools are listed with a single `zpool list` call, all
+// their snapshots with a single `zfs list` call, their scans and their
+// vdevs with a single `zpool status` call and their deadman events with a
+// single `zpool events` call.
+func ListPoolSummary() ([]apis.PoolSummary, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), poolSummaryTimeout)
+	defer cancel()
+
+	out, err := zpoolCommand(ctx, "list", "-H", "-p", "-o", "name,size,alloc,free,frag,health").CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("zfs: could not list the pools: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	summary, err := parsePoolSummary(out)
+	if err != nil {
+		return nil, err
+	}
+	if PoolFullThreshold > 0 {
+		setPoolFullConditions(summary, PoolFullThreshold)
+	}
+
+	// the feature flags, the snapshot space, the datasets, the scans, the
+	// vdevs and the deadman events are best effort, the capacity is
+	// reported without them
+	out, err = zpoolCommand(ctx, "get", "-H", "-p", "-o", "name,property,value", "all").CombinedOutput()
+	if err != nil {
+		klog.Warningf("zfs: could not get the feature flags of the pools: %v: %s", err, strings.TrimSpace(string(out)))
+	} else {
+		features := parsePoolFeatures(out)
+		for i := range summary {
+			summary[i].Features = features[summary[i].Name]
+		}
+	}
+
+	space, err := ListSnapshotSpace(ctx)
+	if err != nil {
+		klog.Warningf("%v", err)
+	} else {
+		setSnapshotSpace(summary, space)
+	}
+
+	counts, err := ListDatasetCounts(ctx)
+	if err != nil {
+		klog.Warningf("%v", err)
+	} else {
+		setDatasetCounts(summary, counts)
+	}
+
+	scans, vdevs, err := ListPoolStatus(ctx)
+	if err != nil {
+		klog.Warningf("%v", err)
+	} else {
+		for i := range summary {
+			summary[i].Scan = scans[summary[i].Name]
+			summary[i].Vdevs = vdevs[summary[i].Name]
+		}
+	}
+
+	if DeadmanThreshold == 0 {
+		return summary, nil
+	}
+	events, err := ListDeadmanEvents(ctx)
+	if err != nil {
+		klog.Warningf("%v", err)
+		return summary, nil
+	}
+	setDeadmanConditions(summary, events, time.Now())
+	return summary, nil
+}
+
+// parsePoolSummary parses the output of
+// `zpool list -H -p -o name,size,alloc,free,frag,health`, e.g.
+// zfspv-pool	10670309376	1892352	10668417024	0	ONLINE
+// The fragmentation is reported as "-" when zfs can not compute it.
+func parsePoolSummary(raw []byte) ([]apis.PoolSummary, error) {
+	summary := []apis.PoolSummary{}
+	scanner := bufio.NewScanner(strings.NewReader(string(raw)))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		items := strings.Split(line, "\t")
+		if len(items) != 6 {
+			return nil, fmt.Errorf("zfs: invalid zpool list output %q", line)
+		}
+
+		pool := apis.PoolSummary{Name: items[0], Health: items[5]}
+		for i, q := range []*resource.Quantity{&pool.Size, &pool.Allocated, &pool.Free} {
+			bytes, err := strconv.ParseInt(items[i+1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("zfs: invalid capacity %q of pool %s: %v", items[i+1], pool.Name, err)
+			}
+			*q = *resource.NewQuantity(bytes, resource.BinarySI)
+		}
+		if frag := items[4]; frag != "-" {
+			pool.Fragmentation = strings.TrimSuffix(frag, "%") + "%"
+		}
+		summary = append(summary, pool)
+	}
+	return summary, scanner.Err()
+}
+
+// parsePoolFeatures returns the feature flags of each pool from the output
+// of `zpool get -H -p -o name,property,value all`, e.g.
+// zfspv-pool	feature@encryption	enabled
+// The other properties are skipped.
+func parsePoolFeatures(raw []byte) map[string]map[string]string {
+	features := map[string]map[string]string{}
+	scanner := bufio.NewScanner(strings.NewReader(string(raw)))
+	for scanner.Scan() {
+		items := strings.Split(strings.TrimSpace(scanner.Text()), "\t")
+		if len(items) != 3 || !strings.HasPrefix(items[1], "feature@") {
+			continue
+		}
+		if features[items[0]] == nil {
+			features[items[0]] = map[string]string{}
+		}
+		features[items[0]][strings.TrimPrefix(items[1], "feature@")] = items[2]
+	}
+	return features
+}
+
+// MissingPoolFeatures returns the features missing from the pool, a feature is
+// there if it is enabled or active
+func MissingPoolFeatures(pool map[string]string, required []string) []string {
+	var missing []string
+	for _, f := range required {
+		if state := pool[f]; state != "enabled" && state != "active" {
+			missing = append(missing, f)
+		}
+	}
+	return missing
+}
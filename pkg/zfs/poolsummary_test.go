@@ -0,0 +1,175 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestListPoolSummary(t *testing.T) {
+	defer func(f func(context.Context, func(io.Reader) error) error) { listSnapshotUsed = f }(listSnapshotUsed)
+	defer func(f func(context.Context) ([]byte, error)) { zpoolEvents = f }(zpoolEvents)
+	defer func(f func(context.Context) ([]byte, error)) { lsblkDevices = f }(lsblkDevices)
+	lsblkDevices = func(context.Context) ([]byte, error) { return nil, errors.New("lsblk: not found") }
+	f := newFakeZFS(t)
+	f.Pool(t, "status", "  pool: zfspv-pool\n state: ONLINE\n"+
+		"  scan: scrub repaired 0B in 00:01:02 with 0 errors on Sun Oct 15 10:01:03 2026\n"+
+		"config:\n\n  pool: backup\n state: DEGRADED\n  scan: none requested\nconfig:\n")
+	listSnapshotUsed = func(ctx context.Context, parse func(io.Reader) error) error {
+		return parse(strings.NewReader("zfspv-pool/pvc-1@snap-1\t1024\n"))
+	}
+	zpoolEvents = func(context.Context) ([]byte, error) {
+		return []byte(fmt.Sprintf("Oct 15 2026 10:00:01.000000000 %s\n"+
+			"        pool = \"zfspv-pool\"\n"+
+			"        time = 0x%x 0x0\n", deadmanClass, time.Now().Unix())), nil
+	}
+	f.Pool(t, "get", "zfspv-pool\tsize\t10737418240\t-\n"+
+		"zfspv-pool\tfeature@encryption\tenabled\n")
+	f.Pool(t, "list", "zfspv-pool\t10737418240\t1073741824\t9663676416\t12\tONLINE\n"+
+		"backup\t2147483648\t0\t2147483648\t-\tDEGRADED\n")
+	summary, err := ListPoolSummary()
+	if err != nil {
+		t.Fatalf("ListPoolSummary() unexpected error %v", err)
+	}
+	if len(summary) != 2 {
+		t.Fatalf("ListPoolSummary() = %+v, want 2 pools", summary)
+	}
+
+	p := summary[0]
+	if p.Name != "zfspv-pool" || p.Health != PoolHealthOnline || p.Fragmentation != "12%" {
+		t.Errorf("ListPoolSummary() pool = %+v", p)
+	}
+	for _, c := range []struct {
+		got  resource.Quantity
+		want string
+	}{{p.Size, "10Gi"}, {p.Allocated, "1Gi"}, {p.Free, "9Gi"}} {
+		if c.got.Cmp(resource.MustParse(c.want)) != 0 {
+			t.Errorf("ListPoolSummary() capacity = %s, want %s", c.got.String(), c.want)
+		}
+	}
+	if summary[1].Fragmentation != "" || summary[1].Health != "DEGRADED" {
+		t.Errorf("ListPoolSummary() pool = %+v", summary[1])
+	}
+	if !reflect.DeepEqual(p.Features, map[string]string{"encryption": "enabled"}) || summary[1].Features != nil {
+		t.Errorf("ListPoolSummary() features = %v, %v", p.Features, summary[1].Features)
+	}
+	if p.SnapshotUsed == nil || p.SnapshotUsed.Value() != 1024 || p.Snapshots != 1 ||
+		summary[1].SnapshotUsed == nil || summary[1].SnapshotUsed.Value() != 0 {
+		t.Errorf("ListPoolSummary() snapshot space = %v/%d, %v", p.SnapshotUsed, p.Snapshots, summary[1].SnapshotUsed)
+	}
+
+	if !PoolIOHung(&p) || p.DeadmanEvents != 1 || PoolIOHung(&summary[1]) || len(summary[1].Conditions) != 2 {
+		t.Errorf("ListPoolSummary() deadman = %+v, %+v", p.Conditions, summary[1].Conditions)
+	}
+
+	if PoolFull(&p) || meta.FindStatusCondition(p.Conditions, PoolConditionFull) == nil {
+		t.Errorf("ListPoolSummary() pool full = %+v", p.Conditions)
+	}
+
+	if p.Scan == nil || p.Scan.State != apis.PoolScanFinished || summary[1].Scan != nil {
+		t.Errorf("ListPoolSummary() scans = %+v, %+v", p.Scan, summary[1].Scan)
+	}
+
+	// the summary is still reported if the status can not be read
+	f.Fail(t, "zpool status", "cannot open pools")
+	if summary, err = ListPoolSummary(); err != nil || len(summary) != 2 || summary[0].Scan != nil {
+		t.Errorf("ListPoolSummary() without status = %+v, %v", summary, err)
+	}
+
+	// the summary is still reported if the events can not be read
+	zpoolEvents = func(context.Context) ([]byte, error) { return nil, errors.New("exit status 1") }
+	if summary, err = ListPoolSummary(); err != nil || len(summary) != 2 ||
+		meta.FindStatusCondition(summary[0].Conditions, PoolConditionIOHung) != nil {
+		t.Errorf("ListPoolSummary() without events = %+v, %v", summary, err)
+	}
+
+	// the summary is still reported if the snapshots can not be listed
+	listSnapshotUsed = func(context.Context, func(io.Reader) error) error { return errors.New("exit status 1") }
+	if summary, err = ListPoolSummary(); err != nil || len(summary) != 2 || summary[0].SnapshotUsed != nil {
+		t.Errorf("ListPoolSummary() without snapshots = %+v, %v", summary, err)
+	}
+
+	// the summary is still reported if the features can not be probed
+	f.Fail(t, "zpool get", "cannot get the properties")
+	if summary, err = ListPoolSummary(); err != nil || len(summary) != 2 || summary[0].Features != nil {
+		t.Errorf("ListPoolSummary() without features = %+v, %v", summary, err)
+	}
+
+	f.Pool(t, "list", "zfspv-pool\t10737418240\tbad\t9663676416\t12\tONLINE\n")
+	if _, err = ListPoolSummary(); err == nil {
+		t.Errorf("ListPoolSummary() expected error for invalid capacity")
+	}
+
+	f.Pool(t, "list", "zfspv-pool\t10737418240\n")
+	if _, err = ListPoolSummary(); err == nil {
+		t.Errorf("ListPoolSummary() expected error for missing columns")
+	}
+
+	f.Fail(t, "zpool list", "no pools available")
+	if _, err = ListPoolSummary(); err == nil {
+		t.Errorf("ListPoolSummary() expected error when zpool fails")
+	}
+}
+
+func TestSetPoolSummaryInterval(t *testing.T) {
+	defer func(d time.Duration) { PoolSummaryInterval = d }(PoolSummaryInterval)
+
+	if err := SetPoolSummaryInterval(0); err != nil || PoolSummaryInterval != 0 {
+		t.Errorf("SetPoolSummaryInterval(0) = %v, interval %v", err, PoolSummaryInterval)
+	}
+	if err := SetPoolSummaryInterval(-time.Minute); err == nil {
+		t.Errorf("SetPoolSummaryInterval(-1m) expected error")
+	}
+}
+
+func TestParsePoolFeatures(t *testing.T) {
+	raw := []byte("zfspv-pool\tsize\t10737418240\t-\n" +
+		"zfspv-pool\tfeature@encryption\tenabled\n" +
+		"zfspv-pool\tfeature@bookmark_v2\tactive\n" +
+		"zfspv-pool\tfeature@zstd_compress\tdisabled\n" +
+		"backup\tfeature@encryption\tdisabled\n" +
+		"backup\tunsupported@com.example:foo\tinactive\n")
+	want := map[string]map[string]string{
+		"zfspv-pool": {"encryption": "enabled", "bookmark_v2": "active", "zstd_compress": "disabled"},
+		"backup":     {"encryption": "disabled"},
+	}
+	if got := parsePoolFeatures(raw); !reflect.DeepEqual(got, want) {
+		t.Errorf("parsePoolFeatures() = %v, want %v", got, want)
+	}
+}
+
+func TestMissingPoolFeatures(t *testing.T) {
+	pool := map[string]string{"encryption": "enabled", "bookmark_v2": "active", "zstd_compress": "disabled"}
+	if got := MissingPoolFeatures(pool, []string{"encryption", "bookmark_v2"}); got != nil {
+		t.Errorf("MissingPoolFeatures() = %v, want none", got)
+	}
+	want := []string{"zstd_compress", "large_dnode"}
+	if got := MissingPoolFeatures(pool, []string{"encryption", "zstd_compress", "large_dnode"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("MissingPoolFeatures() = %v, want %v", got, want)
+	}
+}
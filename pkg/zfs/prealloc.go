@@ -0,0 +1,193 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// PreallocCancelKey is the ZFSVolume annotation cancelling the
+	// preallocation of the zvol, the rest of the zvol is then allocated
+	// on the first write
+	PreallocCancelKey = "openebs.io/cancel-preallocation"
+
+	// preallocChunk is the size of the zeros written at once
+	preallocChunk = 1 << 20
+)
+
+// preallocProgressInterval is the interval at which the progress of the
+// preallocation is synced and reported, can be replaced in unit tests
+var preallocProgressInterval = 30 * time.Second
+
+// ErrPreallocating is returned when a zvol is published while it is
+// still being preallocated
+var ErrPreallocating = errors.New("volume is being preallocated")
+
+// zvolWriter is the zvol device the preallocation writes to
+type zvolWriter interface {
+	io.WriterAt
+	Sync() error
+	Close() error
+}
+
+// seams for the unit tests
+var (
+	openZvol = func(path string) (zvolWriter, error) {
+		return os.OpenFile(path, os.O_WRONLY, 0)
+	}
+	runMkfs = func(bin string, args ...string) error {
+		out, err := exec.Command(bin, args...).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("%s failed, %s", bin, string(out))
+		}
+		return nil
+	}
+)
+
+// ValidatePreallocate validates the preallocate parameter of the
+// storageclass, only the thick zvols can be preallocated
+func ValidatePreallocate(preallocate, thinprovision, vtype string) error {
+	switch preallocate {
+	case "", "no":
+		return nil
+	case "yes":
+	default:
+		return fmt.Errorf("invalid preallocate %q, it should be yes or no", preallocate)
+	}
+	if thinprovision == "yes" {
+		return fmt.Errorf("preallocate can not be used with thinprovision, only the thick volumes are preallocated")
+	}
+	if vtype == VolTypeDataset {
+		return fmt.Errorf("preallocate is only supported for zvols, not for fstype %s", FSTypeZFS)
+	}
+	return nil
+}
+
+// PreallocationPending tells whether the zvol is still to be preallocated.
+// A clone already holds the data of its origin, it is never preallocated.
+func PreallocationPending(vol *apis.ZFSVolume) bool {
+	if vol.Spec.Preallocate != "yes" || vol.Spec.SnapName != "" {
+		return false
+	}
+	p := vol.Status.Preallocation
+	return p == nil || p.Phase == apis.PreallocationInProgress
+}
+
+// PreallocCancelRequested tells whether the preallocation of the zvol has
+// been cancelled with the annotation
+func PreallocCancelRequested(vol *apis.ZFSVolume) bool {
+	return vol.Annotations[PreallocCancelKey] == "true"
+}
+
+// NewPreallocation returns the preallocation status of a zvol which is
+// about to be preallocated
+func NewPreallocation(vol *apis.ZFSVolume) *apis.Preallocation {
+	total, _ := strconv.ParseInt(vol.Spec.Capacity, 10, 64)
+	return &apis.Preallocation{Phase: apis.PreallocationInProgress, Total: total}
+}
+
+// CheckPreallocated returns ErrPreallocating while the zvol is being
+// preallocated, it must not be written to in the meantime
+func CheckPreallocated(vol *apis.ZFSVolume) error {
+	p := vol.Status.Preallocation
+	if p == nil || p.Phase != apis.PreallocationInProgress {
+		return nil
+	}
+	var percent int64
+	if p.Total > 0 {
+		percent = p.Written * 100 / p.Total
+	}
+	return fmt.Errorf("%w: %d%% of volume %s written, annotate it with %s=true to use it right away",
+		ErrPreallocating, percent, vol.Name, PreallocCancelKey)
+}
+
+// Preallocate writes zeros to the zvol from the offset up to its size. The
+// progress is synced and reported at regular intervals. It returns the
+// bytes written so far along with ctx.Err() once ctx is cancelled, the
+// preallocation can be resumed from there.
+//
+// The zeros are not allocated if the zvol is compressed, as zfs stores
+// them as holes, the preallocation fails for such a zvol.
+func Preallocate(ctx context.Context, vol *apis.ZFSVolume, offset int64, progress func(written int64)) (int64, error) {
+	compression, err := GetVolumeProperty(vol, "compression")
+	if err != nil {
+		return offset, err
+	}
+	if compression != "off" {
+		return offset, fmt.Errorf("compression is %s on %s, the zeros would not be allocated, set compression off on the volume", compression, VolumeDataset(vol))
+	}
+	total, err := strconv.ParseInt(vol.Spec.Capacity, 10, 64)
+	if err != nil {
+		return offset, fmt.Errorf("zfs: invalid capacity %q of volume %s", vol.Spec.Capacity, vol.Name)
+	}
+
+	dev, err := openZvol(ZFSDevPath + VolumeDataset(vol))
+	if err != nil {
+		return offset, err
+	}
+	defer dev.Close()
+
+	klog.Infof("zfs: preallocating volume %s from %d to %d", vol.Name, offset, total)
+	zeros := make([]byte, preallocChunk)
+	last := time.Now()
+	for offset < total {
+		if err = ctx.Err(); err != nil {
+			break
+		}
+		n := int64(len(zeros))
+		if total-offset < n {
+			n = total - offset
+		}
+		if _, err = dev.WriteAt(zeros[:n], offset); err != nil {
+			return offset, fmt.Errorf("zfs: could not preallocate volume %s at %d: %v", vol.Name, offset, err)
+		}
+		offset += n
+		if time.Since(last) >= preallocProgressInterval {
+			if err = dev.Sync(); err != nil {
+				return offset, err
+			}
+			progress(offset)
+			last = time.Now()
+		}
+	}
+	if serr := dev.Sync(); serr != nil {
+		return offset, serr
+	}
+	return offset, err
+}
+
+// UpdatePreallocation records the preallocation status on the latest
+// version of the ZFSVolume
+func UpdatePreallocation(name string, p apis.Preallocation) error {
+	vol, err := GetZFSVolume(name)
+	if err != nil {
+		return err
+	}
+	vol.Status.Preallocation = &p
+	return UpdateVolumeStatus(vol)
+}
@@ -0,0 +1,193 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+)
+
+// fakeZvol records the bytes written to the zvol
+type fakeZvol struct {
+	written int64
+	syncs   int
+	// cancel is called once limit bytes have been written
+	limit  int64
+	cancel context.CancelFunc
+}
+
+func (f *fakeZvol) WriteAt(p []byte, off int64) (int, error) {
+	if off != f.written {
+		return 0, errors.New("unexpected offset")
+	}
+	f.written += int64(len(p))
+	if f.cancel != nil && f.written >= f.limit {
+		f.cancel()
+	}
+	return len(p), nil
+}
+
+func (f *fakeZvol) Sync() error  { f.syncs++; return nil }
+func (f *fakeZvol) Close() error { return nil }
+
+func (f *fakeZvol) install(t *testing.T, compression string) {
+	origOpen, origInterval := openZvol, preallocProgressInterval
+	t.Cleanup(func() { openZvol, preallocProgressInterval = origOpen, origInterval })
+	openZvol = func(string) (zvolWriter, error) { return f, nil }
+	newFakeZFS(t).Set(t, "zfspv/pvc-1", "compression", compression)
+	preallocProgressInterval = 0
+}
+
+func preallocVol() *apis.ZFSVolume {
+	vol := &apis.ZFSVolume{}
+	vol.Name = "pvc-1"
+	vol.Spec.PoolName = "zfspv"
+	vol.Spec.VolumeType = VolTypeZVol
+	vol.Spec.Capacity = "3145728"
+	vol.Spec.Preallocate = "yes"
+	return vol
+}
+
+func TestValidatePreallocate(t *testing.T) {
+	tests := map[string]struct {
+		preallocate, thin, vtype string
+		wantErr                  bool
+	}{
+		"not set":      {"", "yes", VolTypeZVol, false},
+		"off":          {"no", "yes", VolTypeDataset, false},
+		"thick zvol":   {"yes", "no", VolTypeZVol, false},
+		"default thin": {"yes", "", VolTypeZVol, false},
+		"thin zvol":    {"yes", "yes", VolTypeZVol, true},
+		"dataset":      {"yes", "no", VolTypeDataset, true},
+		"invalid":      {"true", "no", VolTypeZVol, true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := ValidatePreallocate(tt.preallocate, tt.thin, tt.vtype)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidatePreallocate(%q, %q, %q) error = %v, wantErr %v",
+					tt.preallocate, tt.thin, tt.vtype, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPreallocationPending(t *testing.T) {
+	vol := preallocVol()
+	if !PreallocationPending(vol) {
+		t.Errorf("new volume is not pending")
+	}
+	vol.Status.Preallocation = NewPreallocation(vol)
+	if !PreallocationPending(vol) || vol.Status.Preallocation.Total != 3145728 {
+		t.Errorf("in progress volume is not pending, status %+v", vol.Status.Preallocation)
+	}
+	vol.Status.Preallocation.Phase = apis.PreallocationDone
+	if PreallocationPending(vol) {
+		t.Errorf("preallocated volume is pending")
+	}
+
+	clone := preallocVol()
+	clone.Spec.SnapName = "pvc-0@snap-1"
+	if PreallocationPending(clone) {
+		t.Errorf("clone is pending")
+	}
+}
+
+func TestCheckPreallocated(t *testing.T) {
+	vol := preallocVol()
+	if err := CheckPreallocated(vol); err != nil {
+		t.Errorf("CheckPreallocated() without status error = %v", err)
+	}
+	vol.Status.Preallocation = &apis.Preallocation{Phase: apis.PreallocationInProgress, Written: 1048576, Total: 3145728}
+	if err := CheckPreallocated(vol); !errors.Is(err, ErrPreallocating) {
+		t.Errorf("CheckPreallocated() in progress error = %v, want ErrPreallocating", err)
+	}
+	vol.Status.Preallocation.Phase = apis.PreallocationCancelled
+	if err := CheckPreallocated(vol); err != nil {
+		t.Errorf("CheckPreallocated() cancelled error = %v", err)
+	}
+}
+
+func TestPreallocate(t *testing.T) {
+	f := &fakeZvol{}
+	f.install(t, "off")
+
+	var progress []int64
+	written, err := Preallocate(context.Background(), preallocVol(), 0, func(w int64) { progress = append(progress, w) })
+	if err != nil || written != 3145728 || f.written != 3145728 {
+		t.Fatalf("Preallocate() = %d, %v, wrote %d", written, err, f.written)
+	}
+	if want := []int64{1048576, 2097152, 3145728}; !reflect.DeepEqual(progress, want) {
+		t.Errorf("progress = %v, want %v", progress, want)
+	}
+}
+
+func TestPreallocateResumeAndCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	f := &fakeZvol{written: 1048576, limit: 2097152, cancel: cancel}
+	f.install(t, "off")
+
+	written, err := Preallocate(ctx, preallocVol(), 1048576, func(int64) {})
+	if !errors.Is(err, context.Canceled) || written != 2097152 {
+		t.Errorf("Preallocate() = %d, %v, want 2097152 and context.Canceled", written, err)
+	}
+	if f.syncs == 0 {
+		t.Errorf("cancelled preallocation was not synced")
+	}
+}
+
+func TestPreallocateCompressed(t *testing.T) {
+	f := &fakeZvol{}
+	f.install(t, "lz4")
+
+	if _, err := Preallocate(context.Background(), preallocVol(), 0, func(int64) {}); err == nil {
+		t.Errorf("Preallocate() of a compressed zvol did not fail")
+	}
+	if f.written != 0 {
+		t.Errorf("compressed zvol has been written")
+	}
+}
+
+func TestFormatPreallocated(t *testing.T) {
+	origFormat, origMkfs := diskFormat, runMkfs
+	t.Cleanup(func() { diskFormat, runMkfs = origFormat, origMkfs })
+	format := ""
+	diskFormat = func(string) (string, error) { return format, nil }
+	var ran []string
+	runMkfs = func(bin string, args ...string) error {
+		ran = append(append(ran, bin), args...)
+		return nil
+	}
+
+	if err := formatZvol("/dev/zvol/zfspv/pvc-1", "ext4", true, ""); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"mkfs.ext4", "-F", "-m0", "-E", "nodiscard", "/dev/zvol/zfspv/pvc-1"}
+	if !reflect.DeepEqual(ran, want) {
+		t.Errorf("mkfs = %v, want %v", ran, want)
+	}
+
+	// an already formatted zvol is left to the mounter
+	ran, format = nil, "ext4"
+	if err := formatZvol("/dev/zvol/zfspv/pvc-1", "ext4", true, ""); err != nil || ran != nil {
+		t.Errorf("formatted zvol: mkfs = %v, error %v", ran, err)
+	}
+}
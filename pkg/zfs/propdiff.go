@@ -0,0 +1,198 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"bufio"
+	"fmt"
+	"reflect"
+	"strings"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"k8s.io/klog/v2"
+)
+
+// managedProperty is a property the driver sets from the spec, only these
+// are reported in the status of the volume
+type managedProperty struct {
+	name string
+	// volType is the only type of volume the property applies to, it
+	// applies to both if empty
+	volType   string
+	requested func(vol *apis.ZFSVolume) string
+}
+
+// managedProperties are the properties reported in the status, in the
+// order they are listed
+var managedProperties = []managedProperty{
+	{"compression", "", func(vol *apis.ZFSVolume) string { return vol.Spec.Compression }},
+	{"dedup", "", func(vol *apis.ZFSVolume) string { return vol.Spec.Dedup }},
+	{"redundant_metadata", "", func(vol *apis.ZFSVolume) string { return vol.Spec.RedundantMetadata }},
+	{"encryption", "", func(vol *apis.ZFSVolume) string { return vol.Spec.Encryption }},
+	{"keyformat", "", func(vol *apis.ZFSVolume) string { return vol.Spec.KeyFormat }},
+	{"keylocation", "", func(vol *apis.ZFSVolume) string { return vol.Spec.KeyLocation }},
+	{"readonly", "", func(vol *apis.ZFSVolume) string {
+		if IsReadOnly(vol) {
+			return "on"
+		}
+		return ""
+	}},
+	{"recordsize", VolTypeDataset, func(vol *apis.ZFSVolume) string { return vol.Spec.RecordSize }},
+	{"dnodesize", VolTypeDataset, func(vol *apis.ZFSVolume) string { return vol.Spec.DnodeSize }},
+	{"acltype", VolTypeDataset, func(vol *apis.ZFSVolume) string { return vol.Spec.AclType }},
+	{"aclmode", VolTypeDataset, func(vol *apis.ZFSVolume) string { return vol.Spec.AclMode }},
+	{"xattr", VolTypeDataset, func(vol *apis.ZFSVolume) string { return vol.Spec.Xattr }},
+	{"sharenfs", VolTypeDataset, func(vol *apis.ZFSVolume) string { return vol.Spec.ShareNFS }},
+	{"sharesmb", VolTypeDataset, func(vol *apis.ZFSVolume) string { return vol.Spec.ShareSMB }},
+	{"volblocksize", VolTypeZVol, func(vol *apis.ZFSVolume) string { return vol.Spec.VolBlockSize }},
+}
+
+// propertyValue is a property as reported by `zfs get`
+type propertyValue struct {
+	value  string
+	source string
+}
+
+// volumeManagedProperties returns the managed properties which apply to
+// the type of the volume
+func volumeManagedProperties(vol *apis.ZFSVolume) []managedProperty {
+	volType := vol.Spec.VolumeType
+	if volType != VolTypeDataset {
+		volType = VolTypeZVol
+	}
+	var props []managedProperty
+	for _, p := range managedProperties {
+		if p.volType == "" || p.volType == volType {
+			props = append(props, p)
+		}
+	}
+	return props
+}
+
+// getManagedProperties runs `zfs get` for the properties of the volume,
+// can be replaced in unit tests
+var getManagedProperties = func(vol *apis.ZFSVolume, names []string) ([]byte, error) {
+	return zfsCommand(ZFSGetArg, "-pH", "-o", "property,value,source",
+		strings.Join(names, ","), VolumeDataset(vol)).CombinedOutput()
+}
+
+// parsePropertyValues parses the output of `zfs get -H -o
+// property,value,source`, a source of "-" is left empty
+func parsePropertyValues(raw []byte) (map[string]propertyValue, error) {
+	values := map[string]propertyValue{}
+	scanner := bufio.NewScanner(strings.NewReader(string(raw)))
+	for scanner.Scan() {
+		if scanner.Text() == "" {
+			continue
+		}
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("invalid property line %q", scanner.Text())
+		}
+		source := fields[2]
+		if source == "-" {
+			source = ""
+		}
+		values[fields[0]] = propertyValue{value: fields[1], source: source}
+	}
+	return values, scanner.Err()
+}
+
+// sameValue tells whether the effective value of the property is the
+// requested one. The block sizes are compared in bytes, and encryption=on
+// is any encryption the pool picks.
+func sameValue(name, requested, effective string) bool {
+	switch name {
+	case "recordsize", "volblocksize":
+		r, rerr := ParseBlockSize(requested)
+		e, eerr := ParseBlockSize(effective)
+		if rerr == nil && eerr == nil {
+			return r == e
+		}
+	case "encryption":
+		if requested == "on" {
+			return effective != "" && effective != "off"
+		}
+	}
+	return strings.EqualFold(requested, effective)
+}
+
+// DiffProperties returns the managed properties of the volume with the
+// requested and the effective values. A property left unset in the spec
+// never differs, whatever it has inherited from the pool, and one missing
+// from the live values has no effective value.
+func DiffProperties(vol *apis.ZFSVolume, live map[string]propertyValue) []apis.PropertyStatus {
+	var props []apis.PropertyStatus
+	for _, p := range volumeManagedProperties(vol) {
+		requested := p.requested(vol)
+		effective, ok := live[p.name]
+		if effective.value == "-" {
+			effective.value = ""
+		}
+		props = append(props, apis.PropertyStatus{
+			Name:      p.name,
+			Requested: requested,
+			Effective: effective.value,
+			Source:    effective.source,
+			Differs:   requested != "" && (!ok || !sameValue(p.name, requested, effective.value)),
+		})
+	}
+	return props
+}
+
+// ReconcileProperties reads the managed properties of the volume and sets
+// them in its status, which is updated in place. It returns true if the
+// status has changed and the ZFSVolume has to be updated.
+func ReconcileProperties(vol *apis.ZFSVolume) (bool, error) {
+	managed := volumeManagedProperties(vol)
+	names := make([]string, 0, len(managed))
+	for _, p := range managed {
+		names = append(names, p.name)
+	}
+	out, err := getManagedProperties(vol, names)
+	if err != nil {
+		return false, fmt.Errorf("zfs: could not get the properties of volume %s: %v: %s",
+			vol.Name, err, strings.TrimSpace(string(out)))
+	}
+	live, err := parsePropertyValues(out)
+	if err != nil {
+		return false, fmt.Errorf("zfs: could not parse the properties of volume %s: %v", vol.Name, err)
+	}
+	props := DiffProperties(vol, live)
+	if reflect.DeepEqual(props, vol.Status.Properties) {
+		return false, nil
+	}
+	for _, p := range props {
+		if p.Differs {
+			klog.Warningf("zfs: %s of volume %s is %q, %q has been requested",
+				p.Name, vol.Name, p.Effective, p.Requested)
+		}
+	}
+	vol.Status.Properties = props
+	return true, nil
+}
+
+// UpdateVolumeProperties sets the properties in the status of the latest
+// version of the ZFSVolume, which may have been updated by the reconcile
+func UpdateVolumeProperties(name string, props []apis.PropertyStatus) error {
+	vol, err := GetZFSVolume(name)
+	if err != nil {
+		return err
+	}
+	vol.Status.Properties = props
+	return UpdateVolumeStatus(vol)
+}
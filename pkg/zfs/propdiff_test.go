@@ -0,0 +1,192 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+)
+
+func propVolume(volType string) *apis.ZFSVolume {
+	vol := &apis.ZFSVolume{}
+	vol.Name = "pvc-1"
+	vol.Spec.PoolName = "zfspv-pool"
+	vol.Spec.VolumeType = volType
+	return vol
+}
+
+// findProperty returns the property of the status by its name
+func findProperty(t *testing.T, props []apis.PropertyStatus, name string) apis.PropertyStatus {
+	for _, p := range props {
+		if p.Name == name {
+			return p
+		}
+	}
+	t.Fatalf("property %s is missing from %v", name, props)
+	return apis.PropertyStatus{}
+}
+
+func TestParsePropertyValues(t *testing.T) {
+	out := "compression\tlz4\tinherited from zfspv-pool\n" +
+		"volblocksize\t16384\t-\n" +
+		"dedup\toff\tdefault\n"
+	got, err := parsePropertyValues([]byte(out))
+	if err != nil {
+		t.Fatalf("parsePropertyValues() = %v", err)
+	}
+	want := map[string]propertyValue{
+		"compression":  {value: "lz4", source: "inherited from zfspv-pool"},
+		"volblocksize": {value: "16384"},
+		"dedup":        {value: "off", source: "default"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parsePropertyValues() = %v, want %v", got, want)
+	}
+	if _, err := parsePropertyValues([]byte("compression lz4\n")); err == nil {
+		t.Errorf("parsePropertyValues() accepted an invalid line")
+	}
+}
+
+func TestManagedPropertiesByType(t *testing.T) {
+	names := func(vol *apis.ZFSVolume) map[string]bool {
+		m := map[string]bool{}
+		for _, p := range volumeManagedProperties(vol) {
+			m[p.name] = true
+		}
+		return m
+	}
+	zvol := names(propVolume(VolTypeZVol))
+	if !zvol["volblocksize"] || zvol["recordsize"] || zvol["sharenfs"] || !zvol["compression"] {
+		t.Errorf("managed properties of a zvol = %v", zvol)
+	}
+	dataset := names(propVolume(VolTypeDataset))
+	if dataset["volblocksize"] || !dataset["recordsize"] || !dataset["acltype"] || !dataset["compression"] {
+		t.Errorf("managed properties of a dataset = %v", dataset)
+	}
+	if len(dataset) > len(managedProperties) || len(zvol) > len(managedProperties) {
+		t.Errorf("more properties reported than managed")
+	}
+}
+
+func TestDiffProperties(t *testing.T) {
+	vol := propVolume(VolTypeDataset)
+	vol.Spec.Compression = "zstd"
+	vol.Spec.Dedup = "on"
+	vol.Spec.RecordSize = "128k"
+	vol.Spec.Encryption = "on"
+	vol.Spec.ReadOnly = "yes"
+	vol.Spec.AclType = "posix"
+	live := map[string]propertyValue{
+		// could not be applied
+		"compression": {value: "lz4", source: "inherited from zfspv-pool"},
+		"dedup":       {value: "on", source: "local"},
+		// the same size in bytes
+		"recordsize": {value: "131072", source: "local"},
+		// picked by zfs for encryption=on
+		"encryption": {value: "aes-256-gcm"},
+		"readonly":   {value: "on", source: "local"},
+		// unset in the spec, inherited from the pool
+		"xattr": {value: "sa", source: "inherited from zfspv-pool"},
+		// unset in the spec, not encrypted
+		"keylocation": {value: "none", source: "default"},
+		"keyformat":   {value: "-"},
+		// acltype is missing
+	}
+
+	props := DiffProperties(vol, live)
+	tests := map[string]apis.PropertyStatus{
+		"compression": {Name: "compression", Requested: "zstd", Effective: "lz4",
+			Source: "inherited from zfspv-pool", Differs: true},
+		"dedup":      {Name: "dedup", Requested: "on", Effective: "on", Source: "local"},
+		"recordsize": {Name: "recordsize", Requested: "128k", Effective: "131072", Source: "local"},
+		"encryption": {Name: "encryption", Requested: "on", Effective: "aes-256-gcm"},
+		"readonly":   {Name: "readonly", Requested: "on", Effective: "on", Source: "local"},
+		"xattr":      {Name: "xattr", Effective: "sa", Source: "inherited from zfspv-pool"},
+		"keyformat":  {Name: "keyformat"},
+		"acltype":    {Name: "acltype", Requested: "posix", Differs: true},
+		"dnodesize":  {Name: "dnodesize"},
+	}
+	for name, want := range tests {
+		if got := findProperty(t, props, name); got != want {
+			t.Errorf("%s = %+v, want %+v", name, got, want)
+		}
+	}
+}
+
+func TestSameValue(t *testing.T) {
+	tests := []struct {
+		name, requested, effective string
+		same                       bool
+	}{
+		{"recordsize", "1M", "1048576", true},
+		{"volblocksize", "16K", "8192", false},
+		{"encryption", "on", "off", false},
+		{"encryption", "aes-128-ccm", "aes-256-gcm", false},
+		{"compression", "LZ4", "lz4", true},
+		{"sharenfs", "rw=@10.0.0.0/8", "rw=@10.0.0.0/8", true},
+	}
+	for _, tt := range tests {
+		if got := sameValue(tt.name, tt.requested, tt.effective); got != tt.same {
+			t.Errorf("sameValue(%s, %q, %q) = %v, want %v", tt.name, tt.requested, tt.effective, got, tt.same)
+		}
+	}
+}
+
+func TestReconcileProperties(t *testing.T) {
+	orig := getManagedProperties
+	t.Cleanup(func() { getManagedProperties = orig })
+	var asked []string
+	out := "compression\tlz4\tlocal\nvolblocksize\t16384\tdefault\n"
+	getManagedProperties = func(vol *apis.ZFSVolume, names []string) ([]byte, error) {
+		asked = names
+		return []byte(out), nil
+	}
+
+	vol := propVolume(VolTypeZVol)
+	vol.Spec.Compression = "lz4"
+	changed, err := ReconcileProperties(vol)
+	if err != nil || !changed {
+		t.Fatalf("ReconcileProperties() = %v, %v", changed, err)
+	}
+	if len(asked) != len(volumeManagedProperties(vol)) {
+		t.Errorf("asked for %v", asked)
+	}
+	if p := findProperty(t, vol.Status.Properties, "compression"); p.Differs || p.Effective != "lz4" {
+		t.Errorf("compression = %+v", p)
+	}
+	if changed, err = ReconcileProperties(vol); err != nil || changed {
+		t.Errorf("ReconcileProperties() again = %v, %v, want no change", changed, err)
+	}
+
+	out = "compression\toff\tlocal\nvolblocksize\t16384\tdefault\n"
+	if changed, err = ReconcileProperties(vol); err != nil || !changed {
+		t.Errorf("ReconcileProperties() after a change = %v, %v", changed, err)
+	}
+	if p := findProperty(t, vol.Status.Properties, "compression"); !p.Differs {
+		t.Errorf("compression = %+v, want a difference", p)
+	}
+
+	getManagedProperties = func(vol *apis.ZFSVolume, names []string) ([]byte, error) {
+		return []byte("dataset does not exist"), errors.New("exit status 1")
+	}
+	if _, err = ReconcileProperties(vol); err == nil {
+		t.Errorf("ReconcileProperties() ignored the failed zfs get")
+	}
+}
@@ -0,0 +1,212 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"k8s.io/klog/v2"
+)
+
+// RedundantMetadataValues is the list of values ZFS accepts for the
+// redundant_metadata property
+var RedundantMetadataValues = []string{"all", "most", "some", "none"}
+
+// the redundant_metadata values which need OpenZFS 2.2 or later
+var redundantMetadata22 = map[string]bool{"some": true, "none": true}
+
+// seams for the unit tests
+var (
+	zfsVersion = func() (string, error) {
+		out, err := zfsCommand("version").CombinedOutput()
+		if err != nil {
+			return "", fmt.Errorf("zfs version failed, %s", string(out))
+		}
+		return string(out), nil
+	}
+	poolStatus = func(pool string) (string, error) {
+		out, err := zpoolCommand(context.Background(), "status", pool).CombinedOutput()
+		if err != nil {
+			return "", fmt.Errorf("zpool status %s failed, %s", pool, string(out))
+		}
+		return string(out), nil
+	}
+)
+
+// ValidateRedundantMetadata returns an error if the value is not a legal
+// redundant_metadata value
+func ValidateRedundantMetadata(value string) error {
+	for _, v := range RedundantMetadataValues {
+		if v == value {
+			return nil
+		}
+	}
+	return fmt.Errorf("zfs: invalid redundant_metadata %q, valid values are %s",
+		value, strings.Join(RedundantMetadataValues, ", "))
+}
+
+// zfsVersionString returns the version in the output of zfs version, e.g.
+// 2.1.5-1ubuntu6, the version of the kernel module is used when present as
+// it decides which properties are supported
+func zfsVersionString(out string) string {
+	version := ""
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if v := strings.TrimPrefix(line, "zfs-kmod-"); v != line {
+			return v
+		}
+		if v := strings.TrimPrefix(line, "zfs-"); v != line && version == "" {
+			version = v
+		}
+	}
+	return version
+}
+
+// parseZFSVersion returns the major and minor version from the output of
+// zfs version
+func parseZFSVersion(out string) (int, int, error) {
+	version := zfsVersionString(out)
+	if version == "" {
+		return 0, 0, fmt.Errorf("zfs: can not parse the zfs version %q", strings.TrimSpace(out))
+	}
+	return parseMajorMinor(version)
+}
+
+// parseMajorMinor returns the major and minor numbers of a version, e.g.
+// 2.1.5 or 2.2-rc1
+func parseMajorMinor(version string) (int, int, error) {
+	parts := strings.SplitN(version, ".", 3)
+	if len(parts) < 2 {
+		return 0, 0, fmt.Errorf("zfs: can not parse the zfs version %q", version)
+	}
+	// the minor version may be followed by the release, e.g. 2.2-rc1
+	minorDigits := parts[1]
+	if i := strings.IndexFunc(minorDigits, func(r rune) bool { return r < '0' || r > '9' }); i >= 0 {
+		minorDigits = minorDigits[:i]
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("zfs: can not parse the zfs version %q", version)
+	}
+	minor, err := strconv.Atoi(minorDigits)
+	if err != nil {
+		return 0, 0, fmt.Errorf("zfs: can not parse the zfs version %q", version)
+	}
+	return major, minor, nil
+}
+
+// checkRedundantMetadataVersion returns an error if the value is not
+// supported by the zfs version
+func checkRedundantMetadataVersion(value, version string) error {
+	if !redundantMetadata22[value] {
+		return nil
+	}
+	major, minor, err := parseZFSVersion(version)
+	if err != nil {
+		return fmt.Errorf("zfs: redundant_metadata=%s needs OpenZFS 2.2 or later: %v", value, err)
+	}
+	if major < 2 || (major == 2 && minor < 2) {
+		return fmt.Errorf("zfs: redundant_metadata=%s needs OpenZFS 2.2 or later, node %s has %d.%d",
+			value, NodeID, major, minor)
+	}
+	return nil
+}
+
+// CheckRedundantMetadata returns an error if the redundant_metadata of the
+// volume is not supported by zfs on this node
+func CheckRedundantMetadata(vol *apis.ZFSVolume) error {
+	value := vol.Spec.RedundantMetadata
+	if !redundantMetadata22[value] {
+		return nil
+	}
+	version, err := zfsVersion()
+	if err != nil {
+		return fmt.Errorf("zfs: redundant_metadata=%s needs OpenZFS 2.2 or later: %v", value, err)
+	}
+	return checkRedundantMetadataVersion(value, version)
+}
+
+// isPoolRedundant returns true if all the top level data vdevs in the
+// output of zpool status are mirror, raidz or draid vdevs. The log, cache,
+// spare and the other special vdevs are not taken into account.
+func isPoolRedundant(out string) bool {
+	inConfig := false
+	poolIndent := -1
+	vdevs := 0
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimLeft(scanner.Text(), "\t")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			if poolIndent >= 0 {
+				break
+			}
+			continue
+		}
+		if !inConfig {
+			inConfig = fields[0] == "NAME"
+			continue
+		}
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+		if poolIndent < 0 {
+			poolIndent = indent
+			continue
+		}
+		if indent <= poolIndent {
+			// logs, cache, spares etc.
+			break
+		}
+		if indent != poolIndent+2 {
+			continue
+		}
+		vdevs++
+		name := fields[0]
+		if !strings.HasPrefix(name, "mirror") &&
+			!strings.HasPrefix(name, "raidz") &&
+			!strings.HasPrefix(name, "draid") {
+			return false
+		}
+	}
+	return vdevs > 0
+}
+
+// RedundantMetadataWarning returns a warning when the volume reduces the
+// metadata redundancy on a pool having no redundancy of its own, it is
+// empty otherwise
+func RedundantMetadataWarning(vol *apis.ZFSVolume) string {
+	value := vol.Spec.RedundantMetadata
+	if value == "" || value == "all" {
+		return ""
+	}
+	pool := strings.SplitN(vol.Spec.PoolName, "/", 2)[0]
+	out, err := poolStatus(pool)
+	if err != nil {
+		klog.Warningf("zfs: could not check the redundancy of pool %s: %v", pool, err)
+		return ""
+	}
+	if isPoolRedundant(out) {
+		return ""
+	}
+	return fmt.Sprintf("redundant_metadata=%s reduces the metadata copies on pool %s which is not redundant, "+
+		"a single bad block can lose the metadata", value, pool)
+}
@@ -0,0 +1,194 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+)
+
+const mirrorStatus = `  pool: zfspv
+ state: ONLINE
+config:
+
+	NAME        STATE     READ WRITE CKSUM
+	zfspv       ONLINE       0     0     0
+	  mirror-0  ONLINE       0     0     0
+	    sda     ONLINE       0     0     0
+	    sdb     ONLINE       0     0     0
+	logs
+	  sdc       ONLINE       0     0     0
+
+errors: No known data errors
+`
+
+const stripeStatus = `  pool: zfspv
+ state: ONLINE
+config:
+
+	NAME        STATE     READ WRITE CKSUM
+	zfspv       ONLINE       0     0     0
+	  raidz1-0  ONLINE       0     0     0
+	    sda     ONLINE       0     0     0
+	    sdb     ONLINE       0     0     0
+	    sdc     ONLINE       0     0     0
+	  sdd       ONLINE       0     0     0
+
+errors: No known data errors
+`
+
+func TestValidateRedundantMetadata(t *testing.T) {
+	for _, v := range []string{"all", "most", "some", "none"} {
+		if err := ValidateRedundantMetadata(v); err != nil {
+			t.Errorf("ValidateRedundantMetadata(%s) unexpected error %v", v, err)
+		}
+	}
+	for _, v := range []string{"", "half", "ALL"} {
+		if err := ValidateRedundantMetadata(v); err == nil {
+			t.Errorf("ValidateRedundantMetadata(%q) expected an error", v)
+		}
+	}
+}
+
+func TestCheckRedundantMetadataVersion(t *testing.T) {
+	tests := []struct {
+		value   string
+		version string
+		wantErr bool
+	}{
+		{"most", "zfs-0.8.3-1ubuntu12\nzfs-kmod-0.8.3-1ubuntu12\n", false},
+		{"some", "zfs-2.2.2-0ubuntu9\nzfs-kmod-2.2.2-0ubuntu9\n", false},
+		{"none", "zfs-2.3.0-1\nzfs-kmod-2.3.0-1\n", false},
+		{"some", "zfs-2.1.5-1ubuntu6\nzfs-kmod-2.1.5-1ubuntu6\n", true},
+		// the kernel module decides
+		{"some", "zfs-2.2.0-1\nzfs-kmod-2.1.9-1\n", true},
+		{"none", "zfs-2.2-rc1\n", false},
+		{"some", "unrecognized command 'version'\n", true},
+	}
+	for _, tt := range tests {
+		if err := checkRedundantMetadataVersion(tt.value, tt.version); (err != nil) != tt.wantErr {
+			t.Errorf("checkRedundantMetadataVersion(%s, %q) error = %v, wantErr %v", tt.value, tt.version, err, tt.wantErr)
+		}
+	}
+}
+
+func TestCheckRedundantMetadata(t *testing.T) {
+	orig := zfsVersion
+	defer func() { zfsVersion = orig }()
+	called := false
+	zfsVersion = func() (string, error) {
+		called = true
+		return "", errors.New("zfs version failed")
+	}
+
+	vol := &apis.ZFSVolume{}
+	vol.Spec.RedundantMetadata = "most"
+	if err := CheckRedundantMetadata(vol); err != nil || called {
+		t.Errorf("expected most to be accepted without the zfs version, got %v", err)
+	}
+	vol.Spec.RedundantMetadata = "none"
+	if err := CheckRedundantMetadata(vol); err == nil || !called {
+		t.Errorf("expected none to fail when the zfs version is unknown")
+	}
+}
+
+func TestIsPoolRedundant(t *testing.T) {
+	if !isPoolRedundant(mirrorStatus) {
+		t.Errorf("expected the mirror pool to be redundant")
+	}
+	if isPoolRedundant(stripeStatus) {
+		t.Errorf("expected the pool with a single disk vdev not to be redundant")
+	}
+	single := strings.Replace(mirrorStatus, "\t  mirror-0  ONLINE       0     0     0\n\t    sda     ONLINE       0     0     0\n\t    sdb",
+		"\t  sda", 1)
+	if isPoolRedundant(single) {
+		t.Errorf("expected the single disk pool not to be redundant")
+	}
+	if isPoolRedundant("") {
+		t.Errorf("expected no redundancy without any vdev")
+	}
+}
+
+func TestRedundantMetadataWarning(t *testing.T) {
+	orig := poolStatus
+	defer func() { poolStatus = orig }()
+	var status string
+	var asked string
+	poolStatus = func(pool string) (string, error) {
+		asked = pool
+		return status, nil
+	}
+
+	vol := &apis.ZFSVolume{}
+	vol.Spec.PoolName = "zfspv/nested"
+	vol.Spec.RedundantMetadata = "most"
+
+	status = stripeStatus
+	if msg := RedundantMetadataWarning(vol); !strings.Contains(msg, "not redundant") {
+		t.Errorf("expected a warning on the stripe pool, got %q", msg)
+	}
+	if asked != "zfspv" {
+		t.Errorf("expected the status of pool zfspv, got %s", asked)
+	}
+
+	status = mirrorStatus
+	if msg := RedundantMetadataWarning(vol); msg != "" {
+		t.Errorf("expected no warning on the mirror pool, got %q", msg)
+	}
+
+	status = stripeStatus
+	vol.Spec.RedundantMetadata = "all"
+	if msg := RedundantMetadataWarning(vol); msg != "" {
+		t.Errorf("expected no warning for all, got %q", msg)
+	}
+}
+
+func TestRedundantMetadataArgs(t *testing.T) {
+	vol := &apis.ZFSVolume{}
+	vol.Name = "pvc-1"
+	vol.Spec.PoolName = "zfspv"
+	vol.Spec.Capacity = "1024"
+	vol.Spec.QuotaType = "quota"
+	vol.Spec.VolumeType = VolTypeDataset
+	vol.Spec.RedundantMetadata = "most"
+
+	want := []string{"create", "-o", "quota=1024", "-o", "redundant_metadata=most", "-o", "mountpoint=legacy", "zfspv/pvc-1"}
+	if got := buildDatasetCreateArgs(vol); !reflect.DeepEqual(got, want) {
+		t.Errorf("buildDatasetCreateArgs() = %v, want %v", got, want)
+	}
+
+	zvol := vol.DeepCopy()
+	zvol.Spec.VolumeType = VolTypeZVol
+	want = []string{"create", "-V", "1024", "-o", "redundant_metadata=most", "zfspv/pvc-1"}
+	if got := buildZvolCreateArgs(zvol); !reflect.DeepEqual(got, want) {
+		t.Errorf("buildZvolCreateArgs() = %v, want %v", got, want)
+	}
+
+	edited := vol.DeepCopy()
+	edited.Spec.RedundantMetadata = "some"
+	if !PropertyChanged(vol, edited) {
+		t.Errorf("expected the redundant_metadata edit to be a property change")
+	}
+	want = []string{"set", "redundant_metadata=some", "zfspv/pvc-1"}
+	if got := buildVolumeSetArgs(edited); !reflect.DeepEqual(got, want) {
+		t.Errorf("buildVolumeSetArgs() = %v, want %v", got, want)
+	}
+}
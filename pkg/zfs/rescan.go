@@ -0,0 +1,132 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"golang.org/x/sys/unix"
+	"k8s.io/klog/v2"
+)
+
+// what the node agent does when the kernel has not noticed the new
+// volsize of a zvol yet, the filesystem would only grow to the old size
+const (
+	// DeviceRescanAuto asks the kernel to read the size of the device
+	// again and waits for it before growing the filesystem
+	DeviceRescanAuto = "auto"
+	// DeviceRescanOff grows the filesystem right away
+	DeviceRescanOff = "off"
+)
+
+// sectorSize is the unit of the sizes of the block devices in sysfs
+const sectorSize = 512
+
+// deviceRescanPolicy is set by SetDeviceRescanPolicy
+var deviceRescanPolicy = DeviceRescanAuto
+
+// the device size is checked rescanAttempts times, rescanInterval apart,
+// before the grow fails
+var (
+	rescanAttempts = 20
+	rescanInterval = 500 * time.Millisecond
+)
+
+// SetDeviceRescanPolicy validates and sets what the node agent does when
+// the kernel still sees the old size of an expanded zvol
+func SetDeviceRescanPolicy(policy string) error {
+	switch policy {
+	case DeviceRescanAuto, DeviceRescanOff:
+		deviceRescanPolicy = policy
+		return nil
+	}
+	return fmt.Errorf("invalid device rescan policy %q, it should be %s or %s",
+		policy, DeviceRescanAuto, DeviceRescanOff)
+}
+
+// blockDeviceSize returns the size of the block device as seen by the
+// kernel, can be replaced in unit tests
+var blockDeviceSize = func(dev string) (int64, error) {
+	raw, err := os.ReadFile(filepath.Join("/sys/class/block", filepath.Base(dev), "size"))
+	if err != nil {
+		return 0, err
+	}
+	sectors, err := strconv.ParseInt(strings.TrimSpace(string(raw)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size of %s: %v", dev, err)
+	}
+	return sectors * sectorSize, nil
+}
+
+// rescanBlockDevice asks the kernel to read the size of the block device
+// again, through the rescan of its device if it has one and by reading
+// its partitions again otherwise, as for the zvols. Can be replaced in
+// unit tests.
+var rescanBlockDevice = func(dev string) error {
+	rescan := filepath.Join("/sys/class/block", filepath.Base(dev), "device", "rescan")
+	if _, err := os.Stat(rescan); err == nil {
+		return os.WriteFile(rescan, []byte("1"), 0200)
+	}
+	f, err := os.Open(dev)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return unix.IoctlSetInt(int(f.Fd()), unix.BLKRRPART, 0)
+}
+
+// waitDeviceSize makes sure the kernel sees the volsize of the zvol
+// before its filesystem is grown. Nothing is done if the device already
+// has the size, otherwise the device is rescanned until it does, and an
+// error is returned if it still has the old size once the attempts are
+// over, so that the expand is retried instead of growing the filesystem
+// to the old size.
+func waitDeviceSize(vol *apis.ZFSVolume, dev string) error {
+	if deviceRescanPolicy == DeviceRescanOff || vol.Spec.VolumeType != VolTypeZVol {
+		return nil
+	}
+	want, err := strconv.ParseInt(vol.Spec.Capacity, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid capacity %q of %s: %v", vol.Spec.Capacity, vol.Name, err)
+	}
+	size, err := blockDeviceSize(dev)
+	if err != nil {
+		klog.Warningf("zfs: could not get the size of %s, growing the filesystem of %s: %v", dev, vol.Name, err)
+		return nil
+	}
+	for attempt := 0; size < want; attempt++ {
+		if attempt == rescanAttempts {
+			return fmt.Errorf("zfs: the kernel still sees %d bytes for %s of volume %s, expanded to %d bytes",
+				size, dev, vol.Name, want)
+		}
+		klog.Infof("zfs: %s of volume %s has %d bytes, rescanning it for %d bytes", dev, vol.Name, size, want)
+		if err := rescanBlockDevice(dev); err != nil {
+			klog.Warningf("zfs: could not rescan %s: %v", dev, err)
+		}
+		sleep(rescanInterval)
+		if size, err = blockDeviceSize(dev); err != nil {
+			return fmt.Errorf("zfs: could not get the size of %s: %v", dev, err)
+		}
+	}
+	return nil
+}
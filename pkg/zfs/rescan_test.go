@@ -0,0 +1,119 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"testing"
+	"time"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+)
+
+// fakeDevice is a zvol whose size is seen by the kernel after a number of
+// rescans
+type fakeDevice struct {
+	size, newSize int64
+	// lag is the number of rescans before the kernel sees the new size
+	lag     int
+	rescans int
+}
+
+func stubDevice(t *testing.T, dev *fakeDevice) {
+	origSize, origRescan, origSleep, origPolicy := blockDeviceSize, rescanBlockDevice, sleep, deviceRescanPolicy
+	t.Cleanup(func() {
+		blockDeviceSize, rescanBlockDevice, sleep, deviceRescanPolicy = origSize, origRescan, origSleep, origPolicy
+	})
+	blockDeviceSize = func(string) (int64, error) { return dev.size, nil }
+	rescanBlockDevice = func(string) error {
+		dev.rescans++
+		if dev.rescans >= dev.lag {
+			dev.size = dev.newSize
+		}
+		return nil
+	}
+	sleep = func(time.Duration) {}
+}
+
+func expandedZvol() *apis.ZFSVolume {
+	vol := &apis.ZFSVolume{}
+	vol.Name = "pvc-1"
+	vol.Spec.VolumeType = VolTypeZVol
+	vol.Spec.Capacity = "2147483648"
+	return vol
+}
+
+func TestWaitDeviceSizeRescansBeforeGrow(t *testing.T) {
+	dev := &fakeDevice{size: 1 << 30, newSize: 2 << 30, lag: 3}
+	stubDevice(t, dev)
+
+	if err := waitDeviceSize(expandedZvol(), "/dev/zd0"); err != nil {
+		t.Fatalf("waitDeviceSize() failed: %v", err)
+	}
+	if dev.rescans != 3 {
+		t.Errorf("waitDeviceSize() rescanned %d times, want 3", dev.rescans)
+	}
+}
+
+func TestWaitDeviceSizeNoop(t *testing.T) {
+	dev := &fakeDevice{size: 2 << 30, newSize: 2 << 30}
+	stubDevice(t, dev)
+
+	// the kernel already sees the new size
+	if err := waitDeviceSize(expandedZvol(), "/dev/zd0"); err != nil || dev.rescans != 0 {
+		t.Errorf("waitDeviceSize() = %v, rescanned %d times, want no rescan", err, dev.rescans)
+	}
+
+	// the datasets have no device and the rescan may be turned off
+	dev.size = 1 << 30
+	ds := expandedZvol()
+	ds.Spec.VolumeType = VolTypeDataset
+	if err := waitDeviceSize(ds, "zfspv-pool/pvc-1"); err != nil || dev.rescans != 0 {
+		t.Errorf("waitDeviceSize() = %v, rescanned %d times for a dataset", err, dev.rescans)
+	}
+	if err := SetDeviceRescanPolicy(DeviceRescanOff); err != nil {
+		t.Fatal(err)
+	}
+	if err := waitDeviceSize(expandedZvol(), "/dev/zd0"); err != nil || dev.rescans != 0 {
+		t.Errorf("waitDeviceSize() = %v, rescanned %d times with the rescan off", err, dev.rescans)
+	}
+}
+
+func TestWaitDeviceSizeTimeout(t *testing.T) {
+	// the kernel never sees the new size, the filesystem is not grown
+	dev := &fakeDevice{size: 1 << 30, newSize: 2 << 30, lag: rescanAttempts + 1}
+	stubDevice(t, dev)
+
+	if err := waitDeviceSize(expandedZvol(), "/dev/zd0"); err == nil {
+		t.Error("waitDeviceSize() succeeded with the old size")
+	}
+	if dev.rescans != rescanAttempts {
+		t.Errorf("waitDeviceSize() rescanned %d times, want %d", dev.rescans, rescanAttempts)
+	}
+}
+
+func TestSetDeviceRescanPolicy(t *testing.T) {
+	defer func(p string) { deviceRescanPolicy = p }(deviceRescanPolicy)
+
+	for _, policy := range []string{DeviceRescanAuto, DeviceRescanOff} {
+		if err := SetDeviceRescanPolicy(policy); err != nil || deviceRescanPolicy != policy {
+			t.Errorf("SetDeviceRescanPolicy(%q) = %v", policy, err)
+		}
+	}
+	if err := SetDeviceRescanPolicy("always"); err == nil {
+		t.Error("SetDeviceRescanPolicy() accepted an invalid policy")
+	}
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"fmt"
+	"strconv"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"k8s.io/klog/v2"
+)
+
+// ReservedDataset is the driver owned placeholder dataset created in each
+// pool, it holds a reservation for the reserved space
+const ReservedDataset string = "openebs-reserved"
+
+// ReservedSpacePercent is the percentage of each pool reserved so that the
+// pool never gets full. The space is reserved by the placeholder dataset,
+// thus the available space reported for the pool is reduced by it.
+var ReservedSpacePercent int
+
+// SetReservedSpacePercent validates and sets the percentage of each pool
+// to keep free as emergency space
+func SetReservedSpacePercent(pct int) error {
+	if pct < 0 || pct >= 100 {
+		return fmt.Errorf("invalid reserved space percentage %d, it should be between 0 and 99", pct)
+	}
+	ReservedSpacePercent = pct
+	return nil
+}
+
+// reservedSpaceSize returns the space to be reserved for the pool. The
+// used and available space of the pool together is its usable size.
+func reservedSpaceSize(pool apis.Pool, percent int) int64 {
+	total := pool.Free.Value() + pool.Used.Value()
+	return total / 100 * int64(percent)
+}
+
+// buildReservedSpaceArgs returns the zfs command to converge the placeholder
+// dataset of the pool to the given size, nil if nothing has to be done.
+func buildReservedSpaceArgs(pool string, size int64, exists bool, current int64) []string {
+	dataset := pool + "/" + ReservedDataset
+	reservation := "reservation=" + strconv.FormatInt(size, 10)
+
+	switch {
+	case size == 0 && exists:
+		return []string{ZFSDestroyArg, dataset}
+	case size == 0:
+		return nil
+	case !exists:
+		return []string{ZFSCreateArg,
+			"-o", reservation,
+			"-o", "mountpoint=none",
+			"-o", "canmount=off",
+			dataset}
+	case current != size:
+		return []string{ZFSSetArg, reservation, dataset}
+	}
+	return nil
+}
+
+// getReservation returns the reservation of the dataset, false if the
+// dataset does not exist
+func getReservation(dataset string) (int64, bool) {
+	out, err := zfsCommand(ZFSGetArg, "-pH", "-o", "value", "reservation", dataset).Output()
+	if err != nil {
+		return 0, false
+	}
+	val, err := strconv.ParseInt(string(out[:len(out)-1]), 10, 64)
+	if err != nil {
+		return 0, true
+	}
+	return val, true
+}
+
+// ReconcileReservedSpace makes sure the placeholder dataset of every pool
+// reserves the configured percentage of the pool. It returns true if any
+// of the reservations were changed.
+func ReconcileReservedSpace(pools []apis.Pool) (bool, error) {
+	changed := false
+	for _, pool := range pools {
+		dataset := pool.Name + "/" + ReservedDataset
+		current, exists := getReservation(dataset)
+
+		args := buildReservedSpaceArgs(pool.Name, reservedSpaceSize(pool, ReservedSpacePercent), exists, current)
+		if args == nil {
+			continue
+		}
+
+		out, err := zfsCommand(args...).CombinedOutput()
+		if err != nil {
+			return changed, fmt.Errorf("zfs: could not reserve space on pool %s cmd %v error: %s",
+				pool.Name, args, string(out))
+		}
+		klog.Infof("zfs: reserved space updated for pool %s cmd %v", pool.Name, args)
+		changed = true
+	}
+	return changed, nil
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"reflect"
+	"testing"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestSetReservedSpacePercent(t *testing.T) {
+	defer func(pct int) { ReservedSpacePercent = pct }(ReservedSpacePercent)
+
+	tests := []struct {
+		pct     int
+		wantErr bool
+	}{
+		{0, false},
+		{10, false},
+		{99, false},
+		{100, true},
+		{-1, true},
+	}
+	for _, tt := range tests {
+		err := SetReservedSpacePercent(tt.pct)
+		if (err != nil) != tt.wantErr || (err == nil && ReservedSpacePercent != tt.pct) {
+			t.Errorf("SetReservedSpacePercent(%d) = %v, percent %d, wantErr %v", tt.pct, err, ReservedSpacePercent, tt.wantErr)
+		}
+	}
+}
+
+func TestReservedSpaceSize(t *testing.T) {
+	pool := apis.Pool{
+		Name: "zfspv",
+		Free: *resource.NewQuantity(600, resource.BinarySI),
+		Used: *resource.NewQuantity(400, resource.BinarySI),
+	}
+	if got := reservedSpaceSize(pool, 10); got != 100 {
+		t.Errorf("reservedSpaceSize() = %d, want 100", got)
+	}
+	if got := reservedSpaceSize(pool, 0); got != 0 {
+		t.Errorf("reservedSpaceSize() = %d, want 0", got)
+	}
+
+	// once reserved, the space moves from free to used, the size
+	// is stable across the reconciliations
+	pool.Free = *resource.NewQuantity(500, resource.BinarySI)
+	pool.Used = *resource.NewQuantity(500, resource.BinarySI)
+	if got := reservedSpaceSize(pool, 10); got != 100 {
+		t.Errorf("reservedSpaceSize() after reservation = %d, want 100", got)
+	}
+}
+
+func TestBuildReservedSpaceArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		size    int64
+		exists  bool
+		current int64
+		want    []string
+	}{
+		{"create", 100, false, 0,
+			[]string{"create", "-o", "reservation=100", "-o", "mountpoint=none", "-o", "canmount=off", "zfspv/openebs-reserved"}},
+		{"update", 200, true, 100, []string{"set", "reservation=200", "zfspv/openebs-reserved"}},
+		{"in sync", 100, true, 100, nil},
+		{"disabled", 0, false, 0, nil},
+		{"remove", 0, true, 100, []string{"destroy", "zfspv/openebs-reserved"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := buildReservedSpaceArgs("zfspv", tt.size, tt.exists, tt.current)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("buildReservedSpaceArgs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeListOutputSkipsReservedDataset(t *testing.T) {
+	out := "zfspv\t4734063099997348493\t900\t100\n" +
+		"zfspv/openebs-reserved\t3380225606535803752\t0\t100\n"
+
+	pools, err := decodeListOutput([]byte(out))
+	if err != nil {
+		t.Fatalf("decodeListOutput() unexpected error %v", err)
+	}
+	if len(pools) != 1 || pools[0].Free.Value() != 900 {
+		t.Errorf("decodeListOutput() = %+v, want only the pool with 900 free", pools)
+	}
+}
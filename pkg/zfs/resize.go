@@ -64,6 +64,12 @@ func handleVolResize(vol *apis.ZFSVolume, volumePath string) error {
 	list, _ := mounter.List()
 	for _, mpt := range list {
 		if mpt.Path == volumePath {
+			if fsType != "zfs" {
+				// the kernel may not have noticed the new volsize yet
+				if err = waitDeviceSize(vol, devpath); err != nil {
+					return err
+				}
+			}
 			switch fsType {
 			case "xfs":
 				err = ResizeXFS(volumePath)
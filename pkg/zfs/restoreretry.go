@@ -0,0 +1,160 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"fmt"
+	"strings"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"k8s.io/klog/v2"
+)
+
+// RestoreProp is the user property marking a dataset being received by a
+// restore, its value is the uid of the ZFSRestore. It is cleared once the
+// restore is done.
+const RestoreProp string = "openebs.io:restore"
+
+// RecvRecovery is what is done with the dataset of a failed zfs receive
+type RecvRecovery int
+
+const (
+	// RecvRestart receives the stream again from the start, the partial
+	// dataset has been destroyed if there was one
+	RecvRestart RecvRecovery = iota
+	// RecvResume keeps the partial dataset, the next receive resumes it
+	RecvResume
+	// RecvPreserve keeps the dataset which is not known to be a partial
+	// restore, the restore can not be attempted again
+	RecvPreserve
+)
+
+func (r RecvRecovery) String() string {
+	switch r {
+	case RecvRestart:
+		return "restart"
+	case RecvResume:
+		return "resume"
+	}
+	return "preserve"
+}
+
+// recvState is the state of the dataset after a failed zfs receive
+type recvState struct {
+	exists bool
+	// marker is the value of RestoreProp on the dataset
+	marker string
+	// token is the receive_resume_token of the dataset
+	token string
+}
+
+// getRecvState returns the state of the dataset left by a failed receive
+func getRecvState(dataset string) (recvState, error) {
+	out, err := zfsCommand(ZFSGetArg, "-H", "-o", "value",
+		RestoreProp+",receive_resume_token", dataset).CombinedOutput()
+	if err != nil {
+		if strings.Contains(string(out), "does not exist") {
+			return recvState{}, nil
+		}
+		return recvState{}, fmt.Errorf("zfs get %s failed, %s", dataset, string(out))
+	}
+	return parseRecvState(string(out))
+}
+
+// destroyPartialRestore destroys the partial dataset of a failed receive
+func destroyPartialRestore(dataset string) error {
+	out, err := zfsCommand(ZFSDestroyArg, "-r", dataset).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("zfs destroy %s failed, %s", dataset, string(out))
+	}
+	return nil
+}
+
+// clearRestoreMarker clears RestoreProp once the restore is done
+func clearRestoreMarker(dataset string) error {
+	out, err := zfsCommand("inherit", RestoreProp, dataset).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("zfs inherit %s failed, %s", RestoreProp, string(out))
+	}
+	return nil
+}
+
+// parseRecvState parses the output of zfs get for the restore marker and
+// the resume token, "-" is the value of a property which is not set
+func parseRecvState(out string) (recvState, error) {
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 2 {
+		return recvState{}, fmt.Errorf("unexpected zfs get output %q", out)
+	}
+	value := func(v string) string {
+		if v = strings.TrimSpace(v); v == "-" {
+			return ""
+		}
+		return v
+	}
+	return recvState{exists: true, marker: value(lines[0]), token: value(lines[1])}, nil
+}
+
+// recvRecovery decides what is done with the dataset left by a failed
+// receive of the restore. A partial dataset having a resume token is kept
+// for the next receive to resume it. Only a dataset marked by this restore
+// is ever destroyed or resumed, anything else in its place is preserved.
+func recvRecovery(rstr *apis.ZFSRestore, st recvState) RecvRecovery {
+	switch {
+	case !st.exists:
+		return RecvRestart
+	case rstr.UID == "" || st.marker != string(rstr.UID):
+		return RecvPreserve
+	case st.token != "":
+		return RecvResume
+	}
+	return RecvRestart
+}
+
+// CanRetryRestore returns true if the failed restore has retries left
+func CanRetryRestore(rstr *apis.ZFSRestore) bool {
+	return rstr.Retry.Attempts < rstr.Spec.MaxRetries
+}
+
+// RecoverRestore prepares the dataset of the failed restore for the next
+// attempt: the partial dataset is destroyed for a restart, or its resume
+// token is recorded in the retry status for a resume.
+func RecoverRestore(rstr *apis.ZFSRestore) (RecvRecovery, error) {
+	dataset := restoreDataset(rstr)
+	st, err := getRecvState(dataset)
+	if err != nil {
+		return RecvPreserve, err
+	}
+
+	recovery := recvRecovery(rstr, st)
+	switch recovery {
+	case RecvRestart:
+		rstr.Retry.ResumeToken = ""
+		if st.exists {
+			if err := destroyPartialRestore(dataset); err != nil {
+				return RecvPreserve, err
+			}
+			klog.Infof("zfs: destroyed the partial dataset %s of restore %s", dataset, rstr.Name)
+		}
+	case RecvResume:
+		rstr.Retry.ResumeToken = st.token
+		klog.Infof("zfs: keeping the partial dataset %s of restore %s to resume it", dataset, rstr.Name)
+	case RecvPreserve:
+		klog.Warningf("zfs: keeping %s, it is not a partial dataset of restore %s", dataset, rstr.Name)
+	}
+	return recovery, nil
+}
@@ -0,0 +1,176 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"strings"
+	"testing"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"github.com/openebs/zfs-localpv/pkg/zfs/zfstest"
+)
+
+func retryRestore() *apis.ZFSRestore {
+	rstr := &apis.ZFSRestore{}
+	rstr.Name = "restore-1"
+	rstr.UID = "uid-1"
+	rstr.Spec.VolumeName = "pvc-1"
+	rstr.Spec.RestoreSrc = "10.0.0.1:9010"
+	rstr.Spec.MaxRetries = 2
+	rstr.VolSpec.PoolName = "zfspv"
+	return rstr
+}
+
+func TestParseRecvState(t *testing.T) {
+	st, err := parseRecvState("uid-1\n1-abc-def\n")
+	if err != nil || !st.exists || st.marker != "uid-1" || st.token != "1-abc-def" {
+		t.Errorf("got %+v %v", st, err)
+	}
+	st, err = parseRecvState("-\n-\n")
+	if err != nil || !st.exists || st.marker != "" || st.token != "" {
+		t.Errorf("unset properties: got %+v %v", st, err)
+	}
+	if _, err = parseRecvState("uid-1\n"); err == nil {
+		t.Errorf("expected error for a short output")
+	}
+}
+
+func TestRecvRecovery(t *testing.T) {
+	tests := []struct {
+		name string
+		st   recvState
+		want RecvRecovery
+	}{
+		{"nothing left", recvState{}, RecvRestart},
+		{"partial of this restore", recvState{exists: true, marker: "uid-1"}, RecvRestart},
+		{"resumable partial", recvState{exists: true, marker: "uid-1", token: "1-abc"}, RecvResume},
+		{"unmarked dataset", recvState{exists: true, token: "1-abc"}, RecvPreserve},
+		{"other restore", recvState{exists: true, marker: "uid-2"}, RecvPreserve},
+	}
+	for _, tt := range tests {
+		if got := recvRecovery(retryRestore(), tt.st); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+// recvZFS returns a fake zfs holding the dataset of the restore with the
+// marker and the resume token set, if any
+func recvZFS(t *testing.T, marker, token string) *zfstest.FakeZFS {
+	f := newFakeZFS(t, "zfspv/pvc-1")
+	if marker != "" {
+		f.Set(t, "zfspv/pvc-1", RestoreProp, marker)
+	}
+	if token != "" {
+		f.Set(t, "zfspv/pvc-1", "receive_resume_token", token)
+	}
+	return f
+}
+
+func TestRecoverRestore(t *testing.T) {
+	// only the partial dataset of this restore is destroyed
+	for marker, want := range map[string]RecvRecovery{"uid-1": RecvRestart, "uid-2": RecvPreserve, "": RecvPreserve} {
+		f := recvZFS(t, marker, "")
+		if got, err := RecoverRestore(retryRestore()); err != nil || got != want {
+			t.Errorf("marker %q: got %s %v, want %s", marker, got, err, want)
+		}
+		if destroyed := !f.Exists("zfspv/pvc-1"); destroyed != (want == RecvRestart) {
+			t.Errorf("marker %q: ran %v", marker, zfsWrites(f))
+		}
+	}
+
+	// nothing is left to destroy
+	f := newFakeZFS(t)
+	if got, err := RecoverRestore(retryRestore()); err != nil || got != RecvRestart || len(zfsWrites(f)) != 0 {
+		t.Errorf("missing dataset: got %s %v, ran %v", got, err, zfsWrites(f))
+	}
+
+	// a resumable partial dataset is kept along with its token
+	f = recvZFS(t, "uid-1", "1-abc")
+	rstr := retryRestore()
+	if got, err := RecoverRestore(rstr); err != nil || got != RecvResume {
+		t.Errorf("got %s %v", got, err)
+	}
+	if rstr.Retry.ResumeToken != "1-abc" || !f.Exists("zfspv/pvc-1") {
+		t.Errorf("token %q, ran %v", rstr.Retry.ResumeToken, zfsWrites(f))
+	}
+
+	// a restart drops the token of an earlier resume
+	recvZFS(t, "uid-1", "")
+	if got, err := RecoverRestore(rstr); err != nil || got != RecvRestart || rstr.Retry.ResumeToken != "" {
+		t.Errorf("got %s %v, token %q", got, err, rstr.Retry.ResumeToken)
+	}
+
+	f = recvZFS(t, "uid-1", "")
+	f.Fail(t, "destroy", "cannot destroy 'zfspv/pvc-1': dataset is busy")
+	if got, err := RecoverRestore(retryRestore()); err == nil || got != RecvPreserve {
+		t.Errorf("failed destroy: got %s %v", got, err)
+	}
+}
+
+func TestClearRestoreMarker(t *testing.T) {
+	f := recvZFS(t, "uid-1", "")
+	if err := clearRestoreMarker("zfspv/pvc-1"); err != nil || f.Prop("zfspv/pvc-1", RestoreProp) != "-" {
+		t.Errorf("clearRestoreMarker() = %v, marker %s", err, f.Prop("zfspv/pvc-1", RestoreProp))
+	}
+	if err := clearRestoreMarker("zfspv/pvc-2"); err == nil {
+		t.Errorf("clearRestoreMarker() expected error for a missing dataset")
+	}
+}
+
+func TestCanRetryRestore(t *testing.T) {
+	rstr := retryRestore()
+	rstr.Retry.Attempts = 1
+	if !CanRetryRestore(rstr) {
+		t.Errorf("expected a retry left")
+	}
+	rstr.Retry.Attempts = 2
+	if CanRetryRestore(rstr) {
+		t.Errorf("expected no retry left")
+	}
+	rstr.Spec.MaxRetries = 0
+	rstr.Retry.Attempts = 0
+	if CanRetryRestore(rstr) {
+		t.Errorf("expected no retry by default")
+	}
+}
+
+func TestRestoreArgsMarker(t *testing.T) {
+	args, err := buildVolumeRestoreArgs(retryRestore())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !strings.Contains(args[1], "-o "+RestoreProp+"=uid-1") || !strings.Contains(args[1], " -F zfspv/pvc-1") {
+		t.Errorf("unexpected args %v", args)
+	}
+	if strings.Contains(args[1], " -s") {
+		t.Errorf("receive is resumable without resumable set: %v", args)
+	}
+
+	rstr := retryRestore()
+	rstr.Spec.Resumable = true
+	if args, _ = buildVolumeRestoreArgs(rstr); !strings.Contains(args[1], " -s -F zfspv/pvc-1") {
+		t.Errorf("resumable receive: unexpected args %v", args)
+	}
+
+	// the resumed receive does not set the properties again
+	rstr.Retry.ResumeToken = "1-abc"
+	args, _ = buildVolumeRestoreArgs(rstr)
+	if want := "nc -w 3 10.0.0.1 9010 | zfs recv -s zfspv/pvc-1"; args[1] != want {
+		t.Errorf("resumed receive = %q, want %q", args[1], want)
+	}
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"syscall"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"k8s.io/klog/v2"
+)
+
+// RootPermsProp is the user property marking the volume whose root
+// directory permissions have been set, they are only set on the first mount
+const RootPermsProp string = "openebs.io:rootperms"
+
+// parseRootID parses the uid or gid of the root directory, -1 leaves it
+// unchanged as per chown
+func parseRootID(name, val string) (int, error) {
+	if val == "" {
+		return -1, nil
+	}
+	id, err := strconv.ParseUint(val, 10, 31)
+	if err != nil {
+		return -1, fmt.Errorf("zfs: invalid %s %q, it should be a number", name, val)
+	}
+	return int(id), nil
+}
+
+// parseRootMode parses the octal mode of the root directory, which can
+// have the setgid and sticky bits
+func parseRootMode(val string) (uint32, bool, error) {
+	if val == "" {
+		return 0, false, nil
+	}
+	mode, err := strconv.ParseUint(val, 8, 32)
+	if err != nil || mode > 07777 {
+		return 0, false, fmt.Errorf("zfs: invalid root mode %q, it should be an octal mode like 0775", val)
+	}
+	return uint32(mode), true, nil
+}
+
+// ValidateRootPermissions returns an error if the uid, gid or mode of the
+// root directory is invalid
+func ValidateRootPermissions(uid, gid, mode string) error {
+	if _, err := parseRootID("root uid", uid); err != nil {
+		return err
+	}
+	if _, err := parseRootID("root gid", gid); err != nil {
+		return err
+	}
+	_, _, err := parseRootMode(mode)
+	return err
+}
+
+// ApplyRootPermissions sets the owner and the mode of the root directory
+// of the filesystem volume mounted as per mnt. The mountGroup is the fsGroup
+// of the pod passed by the kubelet, it is used when the volume has no
+// RootGID.
+//
+// The permissions are only set on the first mount, the volume is marked
+// with RootPermsProp afterwards so that the permissions changed by the
+// application are kept when it is mounted again. A read-only volume, or
+// one mounted read-only, is left as it is.
+func ApplyRootPermissions(vol *apis.ZFSVolume, mnt *MountInfo, mountGroup string) error {
+	gid := vol.Spec.RootGID
+	if gid == "" {
+		gid = mountGroup
+	}
+	if (vol.Spec.RootUID == "" && gid == "" && vol.Spec.RootMode == "") || readOnlyMount(vol, mnt) {
+		return nil
+	}
+	path := mnt.MountPath
+
+	marker, err := GetVolumeProperty(vol, RootPermsProp)
+	if err != nil {
+		return err
+	}
+	if marker != "" && marker != "-" {
+		return nil
+	}
+
+	u, err := parseRootID("root uid", vol.Spec.RootUID)
+	if err != nil {
+		return err
+	}
+	g, err := parseRootID("root gid", gid)
+	if err != nil {
+		return err
+	}
+	mode, setMode, err := parseRootMode(vol.Spec.RootMode)
+	if err != nil {
+		return err
+	}
+
+	if u != -1 || g != -1 {
+		if err = os.Chown(path, u, g); err != nil {
+			return fmt.Errorf("zfs: could not change the owner of %s: %v", path, err)
+		}
+	}
+	if setMode {
+		if err = syscall.Chmod(path, mode); err != nil {
+			return fmt.Errorf("zfs: could not change the mode of %s: %v", path, err)
+		}
+	}
+	klog.Infof("zfs: root of volume %s set to uid %d gid %d mode %q", vol.Name, u, g, vol.Spec.RootMode)
+	return setVolumeProperty(vol, RootPermsProp, "applied")
+}
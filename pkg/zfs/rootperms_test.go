@@ -0,0 +1,148 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+)
+
+// rootVolume returns the root directory of the fake volume pvc-1 with the
+// mode 0700, and the uid and gid of the test which it can be given
+func rootVolume(t *testing.T) (*MountInfo, string, string) {
+	newFakeZFS(t, "zfspv/pvc-1")
+	dir := t.TempDir()
+	if err := os.Chmod(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	return &MountInfo{MountPath: dir}, strconv.Itoa(os.Getuid()), strconv.Itoa(os.Getegid())
+}
+
+// rootMarked tells whether the volume is marked as set up
+func rootMarked(t *testing.T) bool {
+	vol := rootVol("", "", "")
+	marker, err := GetVolumeProperty(vol, RootPermsProp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return marker != "-"
+}
+
+// rootGID returns the gid of the root directory
+func rootGID(t *testing.T, mnt *MountInfo) string {
+	info, err := os.Stat(mnt.MountPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strconv.Itoa(int(info.Sys().(*syscall.Stat_t).Gid))
+}
+
+func rootVol(uid, gid, mode string) *apis.ZFSVolume {
+	vol := &apis.ZFSVolume{}
+	vol.Name = "pvc-1"
+	vol.Spec.PoolName = "zfspv"
+	vol.Spec.RootUID = uid
+	vol.Spec.RootGID = gid
+	vol.Spec.RootMode = mode
+	return vol
+}
+
+func TestValidateRootPermissions(t *testing.T) {
+	valid := [][3]string{{"", "", ""}, {"1000", "2000", "2775"}, {"0", "", "0750"}, {"", "", "755"}}
+	for _, v := range valid {
+		if err := ValidateRootPermissions(v[0], v[1], v[2]); err != nil {
+			t.Errorf("ValidateRootPermissions(%q) failed: %v", v, err)
+		}
+	}
+	invalid := [][3]string{{"-1", "", ""}, {"", "staff", ""}, {"", "", "0799"}, {"", "", "17777"}, {"", "", "rwx"}}
+	for _, v := range invalid {
+		if err := ValidateRootPermissions(v[0], v[1], v[2]); err == nil {
+			t.Errorf("ValidateRootPermissions(%q) did not fail", v)
+		}
+	}
+}
+
+func TestApplyRootPermissionsFirstMountOnly(t *testing.T) {
+	mnt, uid, gid := rootVolume(t)
+	vol := rootVol(uid, gid, "2775")
+
+	if err := ApplyRootPermissions(vol, mnt, ""); err != nil {
+		t.Fatalf("ApplyRootPermissions() failed: %v", err)
+	}
+	checkPerm(t, mnt.MountPath, os.ModeSetgid|0775)
+	if rootGID(t, mnt) != gid {
+		t.Errorf("root gid is %s, want %s", rootGID(t, mnt), gid)
+	}
+	if !rootMarked(t) {
+		t.Errorf("volume is not marked once the permissions are set")
+	}
+
+	// the application changed the permissions, the next mount keeps them
+	if err := os.Chmod(mnt.MountPath, 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := ApplyRootPermissions(vol, mnt, ""); err != nil {
+		t.Fatalf("ApplyRootPermissions() failed: %v", err)
+	}
+	checkPerm(t, mnt.MountPath, 0750)
+}
+
+func TestApplyRootPermissionsMountGroup(t *testing.T) {
+	mnt, _, gid := rootVolume(t)
+
+	if err := ApplyRootPermissions(rootVol("", "", ""), mnt, gid); err != nil {
+		t.Fatalf("ApplyRootPermissions() failed: %v", err)
+	}
+	if rootGID(t, mnt) != gid {
+		t.Errorf("root gid is %s, want the fsGroup %s", rootGID(t, mnt), gid)
+	}
+	checkPerm(t, mnt.MountPath, 0700)
+
+	// the gid of the volume takes precedence over the fsGroup, which is
+	// not even parsed
+	mnt, _, gid = rootVolume(t)
+	if err := ApplyRootPermissions(rootVol("", gid, ""), mnt, "staff"); err != nil {
+		t.Errorf("ApplyRootPermissions() failed: %v", err)
+	}
+}
+
+func TestApplyRootPermissionsSkipped(t *testing.T) {
+	mnt, uid, _ := rootVolume(t)
+
+	readonly := rootVol(uid, "", "0755")
+	readonly.Spec.ReadOnly = "yes"
+	for name, vol := range map[string]*apis.ZFSVolume{"unset": rootVol("", "", ""), "readonly": readonly} {
+		if err := ApplyRootPermissions(vol, mnt, ""); err != nil {
+			t.Errorf("ApplyRootPermissions() of the %s volume failed: %v", name, err)
+		}
+	}
+	// the pod mounts the volume read-only
+	for _, opt := range []string{"ro", "readonly"} {
+		ro := &MountInfo{MountPath: mnt.MountPath, MountOptions: []string{"noatime", opt}}
+		if err := ApplyRootPermissions(rootVol(uid, "", "0755"), ro, ""); err != nil {
+			t.Errorf("ApplyRootPermissions() mounted with %s failed: %v", opt, err)
+		}
+	}
+	checkPerm(t, mnt.MountPath, 0700)
+	if rootMarked(t) {
+		t.Errorf("skipped volume is marked")
+	}
+}
@@ -0,0 +1,306 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// scanTimeLayout is the layout of the times of the scan line, as printed
+// by ctime
+const scanTimeLayout = "Mon Jan _2 15:04:05 2006"
+
+// the first line of the scan, e.g.
+// scrub in progress since Sun Oct 15 10:00:01 2026
+// scrub repaired 0B in 00:01:02 with 0 errors on Sun Oct 15 10:01:03 2026
+// resilvered 10.5G in 00:05:12 with 0 errors on Sun Oct 15 10:05:13 2026
+var (
+	scanInProgress = regexp.MustCompile(`^(\w+) in progress since (.+)$`)
+	scanPaused     = regexp.MustCompile(`^(\w+) paused since (.+)$`)
+	scanCanceled   = regexp.MustCompile(`^(\w+) canceled on (.+)$`)
+	scanRepaired   = regexp.MustCompile(`^(\w+) repaired (\S+) in (.+) with (\d+) errors on (.+)$`)
+	scanResilvered = regexp.MustCompile(`^resilvered (\S+) in (.+) with (\d+) errors on (.+)$`)
+)
+
+// the progress of a scan not over, e.g.
+// 1.23G scanned at 105M/s, 845M issued at 72.0M/s, 10.5G total
+// 0B repaired, 7.86% done, 00:02:17 to go
+// the recent versions of zfs print the total along with the counters, e.g.
+// 1.23G / 10.5G scanned at 105M/s, 845M / 10.5G issued at 72.0M/s
+// and the older ones print
+// 1.23G scanned out of 10.5G at 105M/s, 00:02:17 to go
+var (
+	scanScanned   = regexp.MustCompile(`(\S+)(?: / (\S+))? scanned`)
+	scanOutOf     = regexp.MustCompile(`scanned out of (\S+)`)
+	scanIssued    = regexp.MustCompile(`(\S+)(?: / (\S+))? issued`)
+	scanTotal     = regexp.MustCompile(`(\S+) total`)
+	scanProcessed = regexp.MustCompile(`(\S+) (?:repaired|resilvered),`)
+	scanPercent   = regexp.MustCompile(`([\d.]+)% done`)
+	scanToGo      = regexp.MustCompile(`([^,]+) to go`)
+	scanHMS       = regexp.MustCompile(`^(?:(\d+) days )?(\d+):(\d+):(\d+)$`)
+)
+
+// statusHeader is a header of `zpool status`, e.g. "  pool: zfspv-pool"
+var statusHeader = regexp.MustCompile(`^ *([a-z]+):(?: (.*))?$`)
+
+// ListPoolStatus returns the scan of each pool which has been scrubbed or
+// resilvered and the vdevs of each pool, all the pools are read with a
+// single `zpool status` call. The disks backing the vdevs are resolved
+// with lsblk, they are left out if lsblk fails.
+func ListPoolStatus(ctx context.Context) (map[string]*apis.PoolScan, map[string][]apis.PoolVdev, error) {
+	// the full paths of the devices are needed to resolve their disks
+	out, err := zpoolCommand(ctx, "status", "-P").CombinedOutput()
+	if err != nil {
+		return nil, nil, fmt.Errorf("zfs: could not get the status of the pools: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	scans, err := parsePoolScans(out)
+	if err != nil {
+		return nil, nil, err
+	}
+	vdevs, err := parsePoolVdevs(out)
+	if err != nil {
+		return nil, nil, err
+	}
+	if devices, err := lsblkDevices(ctx); err != nil {
+		klog.Warningf("zfs: could not list the block devices: %v: %s", err, strings.TrimSpace(string(devices)))
+	} else {
+		resolvePoolDevices(parseBlockDevices(devices), vdevs)
+	}
+	return scans, vdevs, nil
+}
+
+// parsePoolScans returns the scan of each pool from the output of `zpool
+// status`. The scan is printed after the pool name and may go on over the
+// next lines, e.g.
+//
+//	  pool: zfspv-pool
+//	 state: ONLINE
+//	  scan: scrub in progress since Sun Oct 15 10:00:01 2026
+//		1.23G scanned at 105M/s, 845M issued at 72.0M/s, 10.5G total
+//		0B repaired, 7.86% done, 00:02:17 to go
+//	config:
+//
+// The pools which have never been scanned are left out.
+func parsePoolScans(raw []byte) (map[string]*apis.PoolScan, error) {
+	scans := map[string]*apis.PoolScan{}
+
+	var pool string
+	var lines []string
+	flush := func() error {
+		if pool != "" && len(lines) != 0 {
+			scan, err := parseScan(lines)
+			if err != nil {
+				return fmt.Errorf("zfs: invalid scan of pool %s: %v", pool, err)
+			}
+			if scan != nil {
+				scans[pool] = scan
+			}
+		}
+		lines = nil
+		return nil
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(string(raw)))
+	inScan := false
+	for scanner.Scan() {
+		line := scanner.Text()
+		// the headers are right aligned with spaces, the lines going on
+		// and the vdevs of the config are indented with tabs
+		if m := statusHeader.FindStringSubmatch(line); m != nil {
+			inScan = false
+			switch m[1] {
+			case "pool":
+				if err := flush(); err != nil {
+					return nil, err
+				}
+				pool = m[2]
+			case "scan":
+				inScan = true
+				lines = append(lines, strings.TrimSpace(m[2]))
+			}
+			continue
+		}
+		if trimmed := strings.TrimSpace(line); inScan && trimmed != "" {
+			lines = append(lines, trimmed)
+		}
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return scans, scanner.Err()
+}
+
+// parseScan parses the scan of a pool, the first line tells its function
+// and its state and the next lines its progress. It returns nil if the
+// pool has never been scanned.
+func parseScan(lines []string) (*apis.PoolScan, error) {
+	first := lines[0]
+	if first == "none requested" {
+		return nil, nil
+	}
+
+	scan := &apis.PoolScan{}
+	var err error
+	if m := scanInProgress.FindStringSubmatch(first); m != nil {
+		scan.Function, scan.State = m[1], apis.PoolScanInProgress
+		scan.StartTime, err = parseScanTime(m[2])
+	} else if m := scanPaused.FindStringSubmatch(first); m != nil {
+		scan.Function, scan.State = m[1], apis.PoolScanPaused
+	} else if m := scanCanceled.FindStringSubmatch(first); m != nil {
+		scan.Function, scan.State = m[1], apis.PoolScanCanceled
+		scan.EndTime, err = parseScanTime(m[2])
+	} else if m := scanRepaired.FindStringSubmatch(first); m != nil {
+		scan.Function, scan.State = m[1], apis.PoolScanFinished
+		if scan.Processed, err = parseScanBytes(m[2]); err == nil {
+			if scan.Errors, err = strconv.ParseInt(m[4], 10, 64); err == nil {
+				scan.EndTime, err = parseScanTime(m[5])
+			}
+		}
+	} else if m := scanResilvered.FindStringSubmatch(first); m != nil {
+		scan.Function, scan.State = "resilver", apis.PoolScanFinished
+		if scan.Processed, err = parseScanBytes(m[1]); err == nil {
+			if scan.Errors, err = strconv.ParseInt(m[3], 10, 64); err == nil {
+				scan.EndTime, err = parseScanTime(m[4])
+			}
+		}
+	} else {
+		return nil, fmt.Errorf("unknown scan %q", first)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if scan.State == apis.PoolScanFinished {
+		return scan, nil
+	}
+
+	// the progress of the scan not over, a paused scrub has its start
+	// time on the second line, which is skipped
+	progress := strings.Join(lines[1:], ", ")
+	if err = parseScanProgress(scan, progress); err != nil {
+		return nil, err
+	}
+	return scan, nil
+}
+
+// parseScanProgress parses the counters, the percent done and the time to
+// go of the scan in progress
+func parseScanProgress(scan *apis.PoolScan, progress string) error {
+	var err error
+	bytes := func(m []string, i int, v *int64) {
+		if err == nil && m != nil && i < len(m) && m[i] != "" {
+			*v, err = parseScanBytes(m[i])
+		}
+	}
+	if m := scanOutOf.FindStringSubmatch(progress); m != nil {
+		bytes(m, 1, &scan.Total)
+		m = scanScanned.FindStringSubmatch(progress)
+		bytes(m, 1, &scan.Scanned)
+	} else {
+		m = scanScanned.FindStringSubmatch(progress)
+		bytes(m, 1, &scan.Scanned)
+		bytes(m, 2, &scan.Total)
+	}
+	m := scanIssued.FindStringSubmatch(progress)
+	bytes(m, 1, &scan.Issued)
+	bytes(m, 2, &scan.Total)
+	bytes(scanTotal.FindStringSubmatch(progress), 1, &scan.Total)
+	bytes(scanProcessed.FindStringSubmatch(progress), 1, &scan.Processed)
+	if err != nil {
+		return err
+	}
+
+	if m := scanPercent.FindStringSubmatch(progress); m != nil {
+		scan.Percent = m[1]
+	}
+	if m := scanToGo.FindStringSubmatch(progress); m != nil {
+		d, err := parseScanDuration(strings.TrimSpace(m[1]))
+		if err != nil {
+			return err
+		}
+		scan.Remaining = &metav1.Duration{Duration: d}
+	}
+	return nil
+}
+
+// parseScanBytes parses a size printed by zfs, e.g. 0B, 845M or 1.23G,
+// the units are powers of 1024
+func parseScanBytes(val string) (int64, error) {
+	num := strings.TrimSuffix(val, "B")
+	shift := 0
+	if n := len(num); n > 0 {
+		if i := strings.IndexByte("KMGTPE", num[n-1]); i >= 0 {
+			shift = 10 * (i + 1)
+			num = num[:n-1]
+		}
+	}
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("invalid size %q", val)
+	}
+	return int64(math.Round(f * float64(uint64(1)<<shift))), nil
+}
+
+// parseScanDuration parses a duration printed by zfs, e.g. 00:02:17,
+// 1 days 02:03:04 or 0h2m for the older versions
+func parseScanDuration(val string) (time.Duration, error) {
+	if m := scanHMS.FindStringSubmatch(val); m != nil {
+		var d time.Duration
+		for i, unit := range []time.Duration{24 * time.Hour, time.Hour, time.Minute, time.Second} {
+			if m[i+1] == "" {
+				continue
+			}
+			n, err := strconv.ParseInt(m[i+1], 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid duration %q", val)
+			}
+			d += time.Duration(n) * unit
+		}
+		return d, nil
+	}
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q", val)
+	}
+	return d, nil
+}
+
+// parseScanTime parses a time of the scan line, in the local time of the
+// node
+func parseScanTime(val string) (*metav1.Time, error) {
+	t, err := time.ParseInLocation(scanTimeLayout, strings.TrimSpace(val), time.Local)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time %q", val)
+	}
+	mt := metav1.NewTime(t)
+	return &mt, nil
+}
+
+// PoolScanActive tells whether the pool is being scrubbed or resilvered
+func PoolScanActive(pool *apis.PoolSummary) bool {
+	return pool.Scan != nil && pool.Scan.State == apis.PoolScanInProgress
+}
@@ -0,0 +1,227 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const zpoolStatusOutput = `  pool: zfspv-pool
+ state: ONLINE
+  scan: scrub in progress since Sun Oct 15 10:00:01 2026
+	1.25G scanned at 105M/s, 845M issued at 72.0M/s, 10.5G total
+	0B repaired, 7.86% done, 00:02:17 to go
+config:
+
+	NAME        STATE     READ WRITE CKSUM
+	zfspv-pool  ONLINE       0     0     0
+	  sdb       ONLINE       0     0     0
+
+errors: No known data errors
+
+  pool: backup
+ state: DEGRADED
+status: One or more devices is currently being resilvered.  The pool will
+	continue to function, possibly in a degraded state.
+action: Wait for the resilver to complete.
+  scan: resilver in progress since Sun Oct 15 10:00:01 2026
+	5G / 10G scanned at 512M/s, 2.5G / 10G issued at 256M/s
+	2.5G resilvered, 25.00% done, 1 days 02:03:04 to go
+config:
+
+	NAME          STATE     READ WRITE CKSUM
+	backup        DEGRADED     0     0     0
+	  mirror-0    DEGRADED     0     0     0
+	    sdc       ONLINE       0     0     0
+	    sdd       DEGRADED     0     0     0  (resilvering)
+
+errors: No known data errors
+
+  pool: spare
+ state: ONLINE
+  scan: none requested
+config:
+
+	NAME        STATE     READ WRITE CKSUM
+	spare       ONLINE       0     0     0
+	  sde       ONLINE       0     0     0
+
+errors: No known data errors
+`
+
+func scanTime(t *testing.T, val string) *metav1.Time {
+	mt, err := parseScanTime(val)
+	if err != nil {
+		t.Fatalf("parseScanTime(%q) unexpected error %v", val, err)
+	}
+	return mt
+}
+
+func TestParsePoolScans(t *testing.T) {
+	scans, err := parsePoolScans([]byte(zpoolStatusOutput))
+	if err != nil {
+		t.Fatalf("parsePoolScans() unexpected error %v", err)
+	}
+	start := scanTime(t, "Sun Oct 15 10:00:01 2026")
+	want := map[string]*apis.PoolScan{
+		"zfspv-pool": {
+			Function:  "scrub",
+			State:     apis.PoolScanInProgress,
+			Percent:   "7.86",
+			Scanned:   5 << 28,
+			Issued:    845 << 20,
+			Total:     21 << 29,
+			Remaining: &metav1.Duration{Duration: 2*time.Minute + 17*time.Second},
+			StartTime: start,
+		},
+		"backup": {
+			Function:  "resilver",
+			State:     apis.PoolScanInProgress,
+			Percent:   "25.00",
+			Scanned:   5 << 30,
+			Issued:    5 << 29,
+			Total:     10 << 30,
+			Processed: 5 << 29,
+			Remaining: &metav1.Duration{Duration: 26*time.Hour + 3*time.Minute + 4*time.Second},
+			StartTime: start,
+		},
+	}
+	if !reflect.DeepEqual(scans, want) {
+		t.Errorf("parsePoolScans() = %+v, want %+v", scans, want)
+	}
+
+	if scans, err = parsePoolScans(nil); err != nil || len(scans) != 0 {
+		t.Errorf("parsePoolScans(nil) = %v, %v", scans, err)
+	}
+	if _, err = parsePoolScans([]byte("  pool: zfspv-pool\n  scan: defragmenting\n")); err == nil {
+		t.Errorf("parsePoolScans() expected error for an unknown scan")
+	}
+}
+
+func TestParseScan(t *testing.T) {
+	end := scanTime(t, "Sun Oct 15 10:05:13 2026")
+	tests := map[string]struct {
+		lines   []string
+		want    *apis.PoolScan
+		wantErr bool
+	}{
+		"no scan": {
+			lines: []string{"none requested"},
+		},
+		"scrub finished": {
+			lines: []string{"scrub repaired 1M in 00:05:12 with 2 errors on Sun Oct 15 10:05:13 2026"},
+			want: &apis.PoolScan{Function: "scrub", State: apis.PoolScanFinished,
+				Processed: 1 << 20, Errors: 2, EndTime: end},
+		},
+		"resilver finished": {
+			lines: []string{"resilvered 10.5G in 00:05:12 with 0 errors on Sun Oct 15 10:05:13 2026"},
+			want: &apis.PoolScan{Function: "resilver", State: apis.PoolScanFinished,
+				Processed: 21 << 29, EndTime: end},
+		},
+		"scrub canceled": {
+			lines: []string{"scrub canceled on Sun Oct 15 10:05:13 2026"},
+			want:  &apis.PoolScan{Function: "scrub", State: apis.PoolScanCanceled, EndTime: end},
+		},
+		"scrub paused": {
+			lines: []string{
+				"scrub paused since Sun Oct 15 10:05:13 2026",
+				"scrub started on Sun Oct 15 10:00:01 2026",
+				"1G scanned, 512M issued, 2G total",
+				"0B repaired, 25.00% done",
+			},
+			want: &apis.PoolScan{Function: "scrub", State: apis.PoolScanPaused,
+				Percent: "25.00", Scanned: 1 << 30, Issued: 1 << 29, Total: 2 << 30},
+		},
+		"no estimate": {
+			lines: []string{
+				"resilver in progress since Sun Oct 15 10:05:13 2026",
+				"12K scanned at 12K/s, 0B issued at 0B/s, 2G total",
+				"0B resilvered, 0.00% done, no estimated completion time",
+			},
+			want: &apis.PoolScan{Function: "resilver", State: apis.PoolScanInProgress,
+				Percent: "0.00", Scanned: 12 << 10, Total: 2 << 30, StartTime: end},
+		},
+		"older format": {
+			lines: []string{
+				"scrub in progress since Sun Oct 15 10:05:13 2026",
+				"1G scanned out of 4G at 100M/s, 0h2m to go",
+				"0B repaired, 25.00% done",
+			},
+			want: &apis.PoolScan{Function: "scrub", State: apis.PoolScanInProgress,
+				Percent: "25.00", Scanned: 1 << 30, Total: 4 << 30, StartTime: end,
+				Remaining: &metav1.Duration{Duration: 2 * time.Minute}},
+		},
+		"invalid time": {
+			lines:   []string{"scrub canceled on yesterday"},
+			wantErr: true,
+		},
+		"invalid size": {
+			lines:   []string{"resilvered 10.5X in 00:05:12 with 0 errors on Sun Oct 15 10:05:13 2026"},
+			wantErr: true,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := parseScan(tt.lines)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseScan() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseScan() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseScanBytes(t *testing.T) {
+	for val, want := range map[string]int64{
+		"0B":    0,
+		"512":   512,
+		"845M":  845 << 20,
+		"1.50G": 3 << 29,
+		"2T":    2 << 40,
+	} {
+		if got, err := parseScanBytes(val); err != nil || got != want {
+			t.Errorf("parseScanBytes(%q) = %d, %v, want %d", val, got, err, want)
+		}
+	}
+	for _, val := range []string{"", "G", "-1M", "1.2X"} {
+		if _, err := parseScanBytes(val); err == nil {
+			t.Errorf("parseScanBytes(%q) expected error", val)
+		}
+	}
+}
+
+func TestParseScanDuration(t *testing.T) {
+	for val, want := range map[string]time.Duration{
+		"00:02:17":        2*time.Minute + 17*time.Second,
+		"1 days 02:03:04": 26*time.Hour + 3*time.Minute + 4*time.Second,
+		"0h2m":            2 * time.Minute,
+	} {
+		if got, err := parseScanDuration(val); err != nil || got != want {
+			t.Errorf("parseScanDuration(%q) = %v, %v, want %v", val, got, err, want)
+		}
+	}
+	if _, err := parseScanDuration("soon"); err == nil {
+		t.Errorf("parseScanDuration() expected error")
+	}
+}
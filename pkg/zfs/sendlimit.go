@@ -0,0 +1,61 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"context"
+	"fmt"
+)
+
+// DefaultMaxSends is the number of zfs send run at the same time on the
+// node when it is not set
+const DefaultMaxSends = 2
+
+// sendSlots bounds the zfs send of the backups and the migrations running
+// at the same time on the node, the sends are not bounded if it is nil.
+// The other zfs commands do not take a slot.
+var sendSlots = make(chan struct{}, DefaultMaxSends)
+
+// SetMaxSends sets the number of zfs send run at the same time on the
+// node, they are not bounded if it is 0. It must be set before any send
+// is started.
+func SetMaxSends(n int) error {
+	if n < 0 {
+		return fmt.Errorf("invalid max concurrent sends %d", n)
+	}
+	if n == 0 {
+		sendSlots = nil
+		return nil
+	}
+	sendSlots = make(chan struct{}, n)
+	return nil
+}
+
+// acquireSendSlot waits for a free send slot and returns the function
+// releasing it. It returns the error of the context if it is done first.
+func acquireSendSlot(ctx context.Context) (func(), error) {
+	slots := sendSlots
+	if slots == nil {
+		return func() {}, nil
+	}
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+)
+
+func withMaxSends(t *testing.T, n int) {
+	orig := sendSlots
+	t.Cleanup(func() { sendSlots = orig })
+	if err := SetMaxSends(n); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSendBackupConcurrencyCapped(t *testing.T) {
+	withMaxSends(t, 2)
+	var inflight, max, sent int32
+	release := make(chan struct{})
+	fakeBackupSend(t, "size\t10\n", func(ctx context.Context, w io.Writer) error {
+		n := atomic.AddInt32(&inflight, 1)
+		for {
+			m := atomic.LoadInt32(&max)
+			if n <= m || atomic.CompareAndSwapInt32(&max, m, n) {
+				break
+			}
+		}
+		<-release
+		atomic.AddInt32(&inflight, -1)
+		atomic.AddInt32(&sent, 1)
+		return nil
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		bkp, vol := backupFixture()
+		bkp.Name = fmt.Sprintf("bkp%d", i)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := sendBackup(context.Background(), bkp, vol, func(apis.ZFSBackupProgress) {}); err != nil {
+				t.Errorf("sendBackup(%s) failed: %v", bkp.Name, err)
+			}
+		}()
+	}
+
+	waitFor(t, "two sends to run", func() bool { return atomic.LoadInt32(&inflight) == 2 })
+	time.Sleep(20 * time.Millisecond)
+	if n := atomic.LoadInt32(&inflight); n != 2 {
+		t.Errorf("%d sends running, want the 2 allowed", n)
+	}
+
+	close(release)
+	wg.Wait()
+	if sent != 5 || max != 2 {
+		t.Errorf("%d sends done with %d at most at the same time, want 5 and 2", sent, max)
+	}
+}
+
+func TestSendBackupQueuedCancel(t *testing.T) {
+	withMaxSends(t, 1)
+	var first sync.WaitGroup
+	defer first.Wait()
+	release := make(chan struct{})
+	defer close(release)
+	var calls int32
+	fakeBackupSend(t, "size\t10\n", func(ctx context.Context, w io.Writer) error {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return nil
+	})
+
+	bkp, vol := backupFixture()
+	first.Add(1)
+	go func() {
+		defer first.Done()
+		sendBackup(context.Background(), bkp, vol, func(apis.ZFSBackupProgress) {})
+	}()
+	waitFor(t, "the first send", func() bool { return atomic.LoadInt32(&calls) == 1 })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	queued, _ := backupFixture()
+	queued.Name = "bkp2"
+	go func() { done <- sendBackup(ctx, queued, vol, func(apis.ZFSBackupProgress) {}) }()
+	cancel()
+
+	if err := <-done; !errors.Is(err, ErrBackupCancelled) {
+		t.Errorf("queued sendBackup() = %v, want ErrBackupCancelled", err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("%d sends started, the cancelled one must not be sent", n)
+	}
+}
+
+func TestSetMaxSends(t *testing.T) {
+	withMaxSends(t, 0)
+	for i := 0; i < 100; i++ {
+		if _, err := acquireSendSlot(context.Background()); err != nil {
+			t.Fatalf("unbounded acquireSendSlot() failed: %v", err)
+		}
+	}
+	if err := SetMaxSends(-1); err == nil {
+		t.Error("SetMaxSends(-1) did not fail")
+	}
+}
@@ -0,0 +1,153 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"k8s.io/klog/v2"
+)
+
+// ErrShareServiceUnavailable is the class of the errors of zfs share when
+// the NFS server or samba is not configured on the node
+var ErrShareServiceUnavailable = errors.New("share service is not configured on the node")
+
+// shareServiceMessages are the messages of zfs share, exportfs and net
+// usershare which classify a failed share as ErrShareServiceUnavailable
+var shareServiceMessages = []string{
+	"exportfs: not found",
+	"exportfs: command not found",
+	"nfs server is not running",
+	"usershares are currently disabled",
+	"net: not found",
+	"smb add share failed",
+	"nfs add share failed",
+}
+
+// already shared or unshared datasets are not an error
+const (
+	alreadyShared   = "filesystem already shared"
+	alreadyUnshared = "not currently shared"
+)
+
+// runShare runs zfs share or zfs unshare, can be replaced in unit tests
+var runShare = func(args ...string) ([]byte, error) {
+	return zfsCommand(args...).CombinedOutput()
+}
+
+// ValidateShare returns an error if the value can not be set as the
+// sharenfs or sharesmb property
+func ValidateShare(prop, value string) error {
+	if strings.ContainsAny(value, " \t\n") {
+		return fmt.Errorf("invalid %s %q, the share options can not contain white spaces", prop, value)
+	}
+	return nil
+}
+
+// isShareOn tells whether the sharenfs or sharesmb value shares the dataset
+func isShareOn(value string) bool {
+	return value != "" && value != "off"
+}
+
+// IsShared tells whether the dataset volume is exported over NFS or SMB
+func IsShared(vol *apis.ZFSVolume) bool {
+	return vol.Spec.VolumeType == VolTypeDataset &&
+		(isShareOn(vol.Spec.ShareNFS) || isShareOn(vol.Spec.ShareSMB))
+}
+
+// shareProperties returns the sharenfs and sharesmb properties of the
+// dataset volume, the unset ones are left as inherited
+func shareProperties(vol *apis.ZFSVolume) []string {
+	if vol.Spec.VolumeType != VolTypeDataset {
+		return nil
+	}
+	var props []string
+	if len(vol.Spec.ShareNFS) != 0 {
+		props = append(props, "sharenfs="+vol.Spec.ShareNFS)
+	}
+	if len(vol.Spec.ShareSMB) != 0 {
+		props = append(props, "sharesmb="+vol.Spec.ShareSMB)
+	}
+	return props
+}
+
+// shareError returns the error of a failed zfs share or zfs unshare
+func shareError(args []string, out []byte) error {
+	output := strings.TrimSpace(string(out))
+	cerr := &CommandError{Args: args, Output: output}
+	lower := strings.ToLower(output)
+	for _, msg := range shareServiceMessages {
+		if strings.Contains(lower, msg) {
+			cerr.class = ErrShareServiceUnavailable
+			return fmt.Errorf("zfs: can not share the volume on node %s, "+
+				"check that the NFS server or samba is configured: %w", NodeID, cerr)
+		}
+	}
+	return cerr
+}
+
+// ShareVolume exports the mounted dataset as per its sharenfs and
+// sharesmb properties
+func ShareVolume(vol *apis.ZFSVolume) error {
+	args := []string{"share", VolumeDataset(vol)}
+	out, err := runShare(args...)
+	if err != nil && !strings.Contains(string(out), alreadyShared) {
+		klog.Errorf("zfs: could not share the volume %s cmd %v error: %s", vol.Name, args, string(out))
+		return shareError(args, out)
+	}
+	klog.Infof("zfs: volume %s shared", vol.Name)
+	return nil
+}
+
+// UnshareVolume stops exporting the dataset
+func UnshareVolume(vol *apis.ZFSVolume) error {
+	args := []string{"unshare", VolumeDataset(vol)}
+	out, err := runShare(args...)
+	if err != nil && !strings.Contains(string(out), alreadyUnshared) {
+		klog.Errorf("zfs: could not unshare the volume %s cmd %v error: %s", vol.Name, args, string(out))
+		return shareError(args, out)
+	}
+	return nil
+}
+
+// ReconcileShare shares or unshares the dataset as per its properties,
+// once they have been edited. A dataset which is not mounted by zfs is not
+// shared, it is shared when it is published.
+func ReconcileShare(vol *apis.ZFSVolume) error {
+	if vol.Spec.VolumeType != VolTypeDataset ||
+		(len(vol.Spec.ShareNFS) == 0 && len(vol.Spec.ShareSMB) == 0) {
+		return nil
+	}
+	mounted, err := GetVolumeProperty(vol, "mounted")
+	if err != nil {
+		return err
+	}
+	mountpoint, err := GetVolumeProperty(vol, "mountpoint")
+	if err != nil {
+		return err
+	}
+	if mounted != "yes" || mountpoint == "legacy" || mountpoint == "none" {
+		return nil
+	}
+	if IsShared(vol) {
+		return ShareVolume(vol)
+	}
+	return UnshareVolume(vol)
+}
@@ -0,0 +1,172 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+)
+
+func shareVolume(sharenfs, sharesmb string) *apis.ZFSVolume {
+	vol := &apis.ZFSVolume{}
+	vol.Name = "pvc-1"
+	vol.Spec.PoolName = "zfspv"
+	vol.Spec.Capacity = "1024"
+	vol.Spec.QuotaType = "quota"
+	vol.Spec.VolumeType = VolTypeDataset
+	vol.Spec.ShareNFS = sharenfs
+	vol.Spec.ShareSMB = sharesmb
+	return vol
+}
+
+// fakeShare records the zfs share and unshare commands, they fail with out
+func fakeShare(t *testing.T, out string) *[][]string {
+	orig := runShare
+	t.Cleanup(func() { runShare = orig })
+	var cmds [][]string
+	runShare = func(args ...string) ([]byte, error) {
+		cmds = append(cmds, args)
+		if out != "" {
+			return []byte(out), errors.New("exit status 1")
+		}
+		return nil, nil
+	}
+	return &cmds
+}
+
+func TestValidateShare(t *testing.T) {
+	for _, val := range []string{"", "on", "off", "rw=@10.0.0.0/24,no_root_squash"} {
+		if err := ValidateShare("sharenfs", val); err != nil {
+			t.Errorf("ValidateShare(%q) = %v", val, err)
+		}
+	}
+	if err := ValidateShare("sharenfs", "rw=@10.0.0.0/24 ro"); err == nil {
+		t.Error("ValidateShare() accepted the options with a space")
+	}
+}
+
+func TestIsShared(t *testing.T) {
+	if IsShared(shareVolume("", "")) || IsShared(shareVolume("off", "off")) {
+		t.Error("a dataset without share is shared")
+	}
+	if !IsShared(shareVolume("rw", "")) || !IsShared(shareVolume("off", "on")) {
+		t.Error("a dataset with a share is not shared")
+	}
+	zvol := shareVolume("on", "")
+	zvol.Spec.VolumeType = VolTypeZVol
+	if IsShared(zvol) {
+		t.Error("a zvol is shared")
+	}
+}
+
+func TestShareArgs(t *testing.T) {
+	vol := shareVolume("rw=@10.0.0.0/24", "off")
+
+	want := []string{"create", "-o", "quota=1024", "-o", "sharenfs=rw=@10.0.0.0/24", "-o", "sharesmb=off",
+		"-o", "mountpoint=legacy", "zfspv/pvc-1"}
+	if got := buildDatasetCreateArgs(vol); !reflect.DeepEqual(got, want) {
+		t.Errorf("buildDatasetCreateArgs() = %v, want %v", got, want)
+	}
+
+	edited := vol.DeepCopy()
+	edited.Spec.ShareNFS = "off"
+	if !PropertyChanged(vol, edited) {
+		t.Error("expected the sharenfs edit to be a property change")
+	}
+	want = []string{"set", "sharenfs=off", "sharesmb=off", "zfspv/pvc-1"}
+	if got := buildVolumeSetArgs(edited); !reflect.DeepEqual(got, want) {
+		t.Errorf("buildVolumeSetArgs() = %v, want %v", got, want)
+	}
+
+	zvol := vol.DeepCopy()
+	zvol.Spec.VolumeType = VolTypeZVol
+	if props := shareProperties(zvol); props != nil {
+		t.Errorf("shareProperties() of a zvol = %v", props)
+	}
+}
+
+func TestShareVolume(t *testing.T) {
+	cmds := fakeShare(t, "")
+	vol := shareVolume("on", "")
+
+	if err := ShareVolume(vol); err != nil {
+		t.Errorf("ShareVolume() = %v", err)
+	}
+	if err := UnshareVolume(vol); err != nil {
+		t.Errorf("UnshareVolume() = %v", err)
+	}
+	want := [][]string{{"share", "zfspv/pvc-1"}, {"unshare", "zfspv/pvc-1"}}
+	if !reflect.DeepEqual(*cmds, want) {
+		t.Errorf("commands = %v, want %v", *cmds, want)
+	}
+
+	fakeShare(t, "cannot share 'zfspv/pvc-1': filesystem already shared")
+	if err := ShareVolume(vol); err != nil {
+		t.Errorf("ShareVolume() of a shared dataset = %v", err)
+	}
+	fakeShare(t, "cannot unshare 'zfspv/pvc-1': not currently shared")
+	if err := UnshareVolume(vol); err != nil {
+		t.Errorf("UnshareVolume() of a dataset not shared = %v", err)
+	}
+}
+
+func TestShareVolumeServiceUnavailable(t *testing.T) {
+	vol := shareVolume("on", "")
+
+	fakeShare(t, "sh: 1: exportfs: not found\ncannot share 'zfspv/pvc-1': nfs add share failed")
+	err := ShareVolume(vol)
+	if !errors.Is(err, ErrShareServiceUnavailable) {
+		t.Errorf("ShareVolume() without an NFS server = %v, want ErrShareServiceUnavailable", err)
+	}
+
+	fakeShare(t, "cannot share 'zfspv/pvc-1': permission denied")
+	err = ShareVolume(vol)
+	if err == nil || errors.Is(err, ErrShareServiceUnavailable) {
+		t.Errorf("ShareVolume() failing for another reason = %v", err)
+	}
+}
+
+func TestReconcileShare(t *testing.T) {
+	z := newFakeZFS(t)
+	z.Set(t, "zfspv/pvc-1", "mounted", "yes")
+	z.Set(t, "zfspv/pvc-1", "mountpoint", "/var/lib/kubelet/pods/1/mount")
+
+	cmds := fakeShare(t, "")
+	if err := ReconcileShare(shareVolume("on", "")); err != nil {
+		t.Errorf("ReconcileShare() = %v", err)
+	}
+	if err := ReconcileShare(shareVolume("off", "")); err != nil {
+		t.Errorf("ReconcileShare() = %v", err)
+	}
+	want := [][]string{{"share", "zfspv/pvc-1"}, {"unshare", "zfspv/pvc-1"}}
+	if !reflect.DeepEqual(*cmds, want) {
+		t.Errorf("commands = %v, want %v", *cmds, want)
+	}
+
+	// not published, or legacy mounted by a previous version
+	cmds = fakeShare(t, "")
+	z.Set(t, "zfspv/pvc-1", "mountpoint", "legacy")
+	if err := ReconcileShare(shareVolume("on", "")); err != nil || len(*cmds) != 0 {
+		t.Errorf("ReconcileShare() of a legacy mount = %v, commands %v", err, *cmds)
+	}
+	if err := ReconcileShare(shareVolume("", "")); err != nil || len(*cmds) != 0 {
+		t.Errorf("ReconcileShare() without share = %v, commands %v", err, *cmds)
+	}
+}
@@ -0,0 +1,155 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"k8s.io/klog/v2"
+)
+
+// what is done with the clones of a snapshot being deleted, zfs refuses to
+// destroy a snapshot which has clones
+const (
+	// SnapshotClonesRefuse keeps the snapshot until its clones have been
+	// deleted
+	SnapshotClonesRefuse = "refuse"
+	// SnapshotClonesPromote promotes a clone, which takes the snapshot
+	// over so that the ZFSSnapshot can be deleted
+	SnapshotClonesPromote = "promote"
+)
+
+// snapshotClonesPolicy is set by SetSnapshotClonesPolicy
+var snapshotClonesPolicy = SnapshotClonesRefuse
+
+// SetSnapshotClonesPolicy validates and sets what is done with the clones
+// of a snapshot being deleted
+func SetSnapshotClonesPolicy(policy string) error {
+	switch policy {
+	case SnapshotClonesRefuse, SnapshotClonesPromote:
+		snapshotClonesPolicy = policy
+		return nil
+	}
+	return fmt.Errorf("invalid snapshot clones policy %q, it should be %s or %s",
+		policy, SnapshotClonesRefuse, SnapshotClonesPromote)
+}
+
+// getSnapshotClones returns the clones of the snapshot
+func getSnapshotClones(snapshot string) ([]string, error) {
+	out, err := zfsCommand(ZFSGetArg, "-H", "-o", "value", "clones", snapshot).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("zfs get clones of %s failed, %s", snapshot, string(out))
+	}
+	return parseSnapshotClones(out), nil
+}
+
+// promoteClone promotes the clone, the snapshot it has been cloned from
+// moves to it
+func promoteClone(clone string) error {
+	out, err := zfsCommand(ZFSPromoteArg, clone).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("zfs promote of %s failed, %s", clone, string(out))
+	}
+	return nil
+}
+
+// SnapshotClonesError is returned when a snapshot can not be destroyed
+// because of its clones
+type SnapshotClonesError struct {
+	Snapshot string
+	Clones   []string
+	// Older are the snapshots which would move along with the promoted
+	// clone, the clone is then not promoted
+	Older []string
+}
+
+func (e *SnapshotClonesError) Error() string {
+	msg := fmt.Sprintf("snapshot %s has dependent clones: [%s]", e.Snapshot, strings.Join(e.Clones, ", "))
+	if len(e.Older) != 0 {
+		msg += fmt.Sprintf(", they can not be promoted without moving the older snapshots [%s]",
+			strings.Join(e.Older, ", "))
+	}
+	return msg
+}
+
+// parseSnapshotClones parses the clones property of a snapshot, a comma
+// separated list of datasets or - if it has none, e.g.
+// zfspv-pool/pvc-2,zfspv-pool/pvc-3
+func parseSnapshotClones(out []byte) []string {
+	var clones []string
+	for _, clone := range strings.Split(strings.TrimSpace(string(out)), ",") {
+		if clone = strings.TrimSpace(clone); clone != "" && clone != "-" {
+			clones = append(clones, clone)
+		}
+	}
+	sort.Strings(clones)
+	return clones
+}
+
+// olderSnapshots returns the snapshots of the dataset taken before the
+// snapshot, zfs promote moves them along with it
+func olderSnapshots(dataset, name string) ([]string, error) {
+	snaps, err := listSnapshots(dataset)
+	if err != nil {
+		return nil, err
+	}
+	for i, s := range snaps {
+		if s == name {
+			return snaps[:i], nil
+		}
+	}
+	return nil, nil
+}
+
+// ReleaseSnapshotClones checks the clones of the snapshot before it is
+// destroyed. With the refuse policy it returns a SnapshotClonesError
+// naming them. With the promote policy the first clone is promoted and
+// the snapshot moves to it, where it stays as the origin of the volume the
+// snapshot was taken of. It returns the promoted clone, empty if the
+// snapshot has no clones. A snapshot whose clones can not be read, e.g. it
+// has already been destroyed, is left to DestroySnapshot.
+func ReleaseSnapshotClones(snap *apis.ZFSSnapshot) (string, error) {
+	snapshot := resolveSnapshot(snap.Spec.PoolName, snapshotVolume(snap), snap.Name)
+	clones, err := getSnapshotClones(snapshot)
+	if err != nil || len(clones) == 0 {
+		return "", nil
+	}
+	clonesErr := &SnapshotClonesError{Snapshot: snapshot, Clones: clones}
+	if snapshotClonesPolicy != SnapshotClonesPromote {
+		return "", clonesErr
+	}
+
+	// the older snapshots may belong to other ZFSSnapshots, which would
+	// not find them anymore
+	older, err := olderSnapshots(datasetOf(snapshot), snap.Name)
+	if err != nil {
+		return "", err
+	}
+	if len(older) != 0 {
+		clonesErr.Older = older
+		return "", clonesErr
+	}
+	if err = promoteClone(clones[0]); err != nil {
+		return "", err
+	}
+	klog.Infof("zfs: promoted clone %s of %s, the snapshot has moved to %s@%s",
+		clones[0], snapshot, clones[0], snap.Name)
+	return clones[0], nil
+}
@@ -0,0 +1,162 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"github.com/openebs/zfs-localpv/pkg/zfs/zfstest"
+)
+
+// withSnapshotClones sets the policy and returns the fake zfs holding the
+// snapshots of the source volume, snap-2 with the clones
+func withSnapshotClones(t *testing.T, policy string, clones string, snaps ...string) *zfstest.FakeZFS {
+	orig := snapshotClonesPolicy
+	t.Cleanup(func() { snapshotClonesPolicy = orig })
+	if err := SetSnapshotClonesPolicy(policy); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	f := newFakeZFS(t, "zfspv/pvc-src", "zfspv/pvc-a", "zfspv/pvc-b")
+	for _, snap := range snaps {
+		f.Create(t, "zfspv/pvc-src@"+snap)
+	}
+	if clones != "" {
+		f.Set(t, "zfspv/pvc-src@snap-2", "clones", clones)
+	}
+	return f
+}
+
+// promoted returns the clones promoted on the fake zfs
+func promoted(f *zfstest.FakeZFS) []string {
+	var clones []string
+	for _, cmd := range f.Ran() {
+		if clone, ok := strings.CutPrefix(cmd, "promote "); ok {
+			clones = append(clones, clone)
+		}
+	}
+	return clones
+}
+
+func clonesSnap() *apis.ZFSSnapshot {
+	snap := &apis.ZFSSnapshot{}
+	snap.Name = "snap-2"
+	snap.Spec.PoolName = "zfspv"
+	snap.Labels = map[string]string{ZFSVolKey: "pvc-src"}
+	return snap
+}
+
+func TestSetSnapshotClonesPolicy(t *testing.T) {
+	orig := snapshotClonesPolicy
+	defer func() { snapshotClonesPolicy = orig }()
+
+	for _, policy := range []string{SnapshotClonesRefuse, SnapshotClonesPromote} {
+		if err := SetSnapshotClonesPolicy(policy); err != nil || snapshotClonesPolicy != policy {
+			t.Errorf("SetSnapshotClonesPolicy(%s) = %v", policy, err)
+		}
+	}
+	for _, policy := range []string{"", "delete"} {
+		if err := SetSnapshotClonesPolicy(policy); err == nil {
+			t.Errorf("SetSnapshotClonesPolicy(%q) did not fail", policy)
+		}
+	}
+}
+
+func TestParseSnapshotClones(t *testing.T) {
+	for out, want := range map[string][]string{
+		"-\n":                       nil,
+		"\n":                        nil,
+		"zfspv/pvc-2\n":             {"zfspv/pvc-2"},
+		"zfspv/pvc-3,zfspv/pvc-2\n": {"zfspv/pvc-2", "zfspv/pvc-3"},
+	} {
+		if got := parseSnapshotClones([]byte(out)); !reflect.DeepEqual(got, want) {
+			t.Errorf("parseSnapshotClones(%q) = %v, want %v", out, got, want)
+		}
+	}
+}
+
+func TestReleaseSnapshotClonesNone(t *testing.T) {
+	f := withSnapshotClones(t, SnapshotClonesPromote, "", "snap-2")
+	if clone, err := ReleaseSnapshotClones(clonesSnap()); err != nil || clone != "" {
+		t.Errorf("ReleaseSnapshotClones() = %q, %v, want nothing", clone, err)
+	}
+
+	// the clones which can not be read are left to the destroy
+	f.Fail(t, "get", "dataset does not exist")
+	if clone, err := ReleaseSnapshotClones(clonesSnap()); err != nil || clone != "" {
+		t.Errorf("ReleaseSnapshotClones() = %q, %v, want nothing", clone, err)
+	}
+	if got := promoted(f); len(got) != 0 {
+		t.Errorf("promoted %v without clones", got)
+	}
+}
+
+func TestReleaseSnapshotClonesRefuse(t *testing.T) {
+	f := withSnapshotClones(t, SnapshotClonesRefuse, "zfspv/pvc-b,zfspv/pvc-a", "snap-2")
+
+	_, err := ReleaseSnapshotClones(clonesSnap())
+	var clonesErr *SnapshotClonesError
+	if !errors.As(err, &clonesErr) {
+		t.Fatalf("expected SnapshotClonesError, got %v", err)
+	}
+	want := "snapshot zfspv/pvc-src@snap-2 has dependent clones: [zfspv/pvc-a, zfspv/pvc-b]"
+	if err.Error() != want {
+		t.Errorf("error = %q, want %q", err, want)
+	}
+	if got := promoted(f); len(got) != 0 {
+		t.Errorf("promoted %v with the refuse policy", got)
+	}
+}
+
+func TestReleaseSnapshotClonesPromote(t *testing.T) {
+	f := withSnapshotClones(t, SnapshotClonesPromote, "zfspv/pvc-b,zfspv/pvc-a", "snap-2", "snap-3")
+
+	clone, err := ReleaseSnapshotClones(clonesSnap())
+	if err != nil || clone != "zfspv/pvc-a" {
+		t.Errorf("ReleaseSnapshotClones() = %q, %v, want zfspv/pvc-a", clone, err)
+	}
+	if got := promoted(f); !reflect.DeepEqual(got, []string{"zfspv/pvc-a"}) {
+		t.Errorf("promoted %v, want only the first clone", got)
+	}
+
+	f.Fail(t, "promote", "promote failed")
+	if _, err = ReleaseSnapshotClones(clonesSnap()); err == nil || !strings.Contains(err.Error(), "promote failed") {
+		t.Errorf("failed promote: got %v", err)
+	}
+}
+
+func TestReleaseSnapshotClonesOlder(t *testing.T) {
+	f := withSnapshotClones(t, SnapshotClonesPromote, "zfspv/pvc-a", "snap-0", "snap-1", "snap-2")
+
+	_, err := ReleaseSnapshotClones(clonesSnap())
+	var clonesErr *SnapshotClonesError
+	if !errors.As(err, &clonesErr) {
+		t.Fatalf("expected SnapshotClonesError, got %v", err)
+	}
+	if !reflect.DeepEqual(clonesErr.Older, []string{"snap-0", "snap-1"}) {
+		t.Errorf("older snapshots = %v", clonesErr.Older)
+	}
+	if !strings.Contains(err.Error(), "without moving the older snapshots [snap-0, snap-1]") {
+		t.Errorf("error = %q", err)
+	}
+	if got := promoted(f); len(got) != 0 {
+		t.Errorf("promoted %v although older snapshots would move", got)
+	}
+}
@@ -0,0 +1,215 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"k8s.io/klog/v2"
+)
+
+// SnapshotDurabilityKey is the annotation on the ZFSSnapshot holding how
+// the node agent confirms that the snapshot is on stable storage before
+// it is ready, it is set from the "snapshotDurability" parameter of the
+// VolumeSnapshotClass
+const SnapshotDurabilityKey string = "openebs.io/snapshot-durability"
+
+// the ways of confirming that the snapshot is on stable storage
+const (
+	// SnapshotDurabilityNone marks the snapshot ready once `zfs snapshot`
+	// returns, it is the default
+	SnapshotDurabilityNone = "none"
+	// SnapshotDurabilityTxg waits for the transaction group of the pool
+	// open when the snapshot has been taken to be synced
+	SnapshotDurabilityTxg = "txg"
+	// SnapshotDurabilitySync syncs the pool with `zpool sync` once the
+	// snapshot has been taken
+	SnapshotDurabilitySync = "sync"
+)
+
+const (
+	// txgKstatDir is the directory of the kstats of the pools
+	txgKstatDir = "/proc/spl/kstat/zfs"
+	// txgStateCommitted is the state of a synced txg in the txgs kstat
+	txgStateCommitted = "C"
+	// txgPollInterval is the interval at which the txgs of the pool are
+	// read while waiting for the sync
+	txgPollInterval = 100 * time.Millisecond
+	// txgSyncTimeout bounds the wait for the sync of the txg, a txg is
+	// synced every 5 seconds by default
+	txgSyncTimeout = time.Minute
+)
+
+// errDurabilityUnsupported is returned when the node can not confirm the
+// sync in the given way, the other way is tried then
+var errDurabilityUnsupported = errors.New("not supported")
+
+// readTxgs reads the txgs kstat of the pool, can be replaced in unit
+// tests
+var readTxgs = func(zpool string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(txgKstatDir, zpool, "txgs"))
+}
+
+// ParseSnapshotDurability parses the snapshot durability, empty means
+// none
+func ParseSnapshotDurability(val string) (string, error) {
+	switch val {
+	case "":
+		return SnapshotDurabilityNone, nil
+	case SnapshotDurabilityNone, SnapshotDurabilityTxg, SnapshotDurabilitySync:
+		return val, nil
+	}
+	return "", fmt.Errorf("invalid snapshotdurability %q, it should be %s, %s or %s",
+		val, SnapshotDurabilityNone, SnapshotDurabilityTxg, SnapshotDurabilitySync)
+}
+
+// parseTxgHistory returns the last txg and the last committed txg of the
+// txgs kstat, which has a header line followed by one line per recent txg:
+//
+//	txg birth state ndirty nread nwritten reads writes otime qtime wtime stime
+//
+// It returns errDurabilityUnsupported if the kstat keeps no history, i.e.
+// zfs_txg_history is 0.
+func parseTxgHistory(raw []byte) (uint64, uint64, error) {
+	var last, committed uint64
+	txgCol, stateCol := -1, -1
+	lines := 0
+
+	scanner := bufio.NewScanner(strings.NewReader(string(raw)))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if txgCol < 0 {
+			// skip the kstat preamble up to the header
+			for i, f := range fields {
+				switch f {
+				case "txg":
+					txgCol = i
+				case "state":
+					stateCol = i
+				}
+			}
+			if txgCol >= 0 && stateCol < 0 {
+				return 0, 0, fmt.Errorf("txgs: missing column state")
+			}
+			continue
+		}
+		if len(fields) <= txgCol || len(fields) <= stateCol {
+			return 0, 0, fmt.Errorf("txgs: malformed line %q", scanner.Text())
+		}
+		txg, err := strconv.ParseUint(fields[txgCol], 10, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("txgs: invalid txg %q", fields[txgCol])
+		}
+		lines++
+		if txg > last {
+			last = txg
+		}
+		if fields[stateCol] == txgStateCommitted && txg > committed {
+			committed = txg
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, 0, err
+	}
+	if lines == 0 {
+		return 0, 0, fmt.Errorf("%w: the txgs kstat keeps no history, zfs_txg_history is 0", errDurabilityUnsupported)
+	}
+	return last, committed, nil
+}
+
+// waitTxgSync waits for the last txg of the pool, which holds the writes
+// done so far, to be synced
+func waitTxgSync(ctx context.Context, zpool string) error {
+	ctx, cancel := context.WithTimeout(ctx, txgSyncTimeout)
+	defer cancel()
+
+	var target uint64
+	for {
+		raw, err := readTxgs(zpool)
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%w: the txgs of pool %s are not exposed", errDurabilityUnsupported, zpool)
+		}
+		if err != nil {
+			return fmt.Errorf("could not read the txgs of pool %s: %v", zpool, err)
+		}
+		last, committed, err := parseTxgHistory(raw)
+		if err != nil {
+			return err
+		}
+		if target == 0 {
+			target = last
+		}
+		if committed >= target {
+			klog.Infof("zfs: txg %d of pool %s is synced", target, zpool)
+			return nil
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("txg %d of pool %s is not synced, the last synced is %d: %v", target, zpool, committed, ctx.Err())
+		}
+		sleep(txgPollInterval)
+	}
+}
+
+// syncPool commits the pending txg of the pool with `zpool sync`
+func syncPool(ctx context.Context, zpool string) error {
+	out, err := zpoolCommand(ctx, "sync", zpool).CombinedOutput()
+	if err == nil {
+		return nil
+	}
+	if strings.Contains(string(out), "unrecognized command") {
+		return fmt.Errorf("%w: zpool sync is not known by this zfs version", errDurabilityUnsupported)
+	}
+	return fmt.Errorf("could not sync pool %s: %v: %s", zpool, err, strings.TrimSpace(string(out)))
+}
+
+// confirmSnapshotDurable returns once the snapshot is on stable storage,
+// as asked by its snapshot durability, so that it is not ready before. If
+// the node can not confirm it in the asked way, e.g. zfs is too old to
+// have `zpool sync`, the other way is tried.
+func confirmSnapshotDurable(ctx context.Context, snap *apis.ZFSSnapshot) error {
+	mode, err := ParseSnapshotDurability(snap.Annotations[SnapshotDurabilityKey])
+	if err != nil || mode == SnapshotDurabilityNone {
+		return err
+	}
+	zpool := strings.SplitN(snap.Spec.PoolName, "/", 2)[0]
+	confirms := []func(context.Context, string) error{syncPool, waitTxgSync}
+	if mode == SnapshotDurabilityTxg {
+		confirms = []func(context.Context, string) error{waitTxgSync, syncPool}
+	}
+	for _, confirm := range confirms {
+		if err = confirm(ctx, zpool); !errors.Is(err, errDurabilityUnsupported) {
+			break
+		}
+		klog.Warningf("zfs: %v, trying the other way for snapshot %s", err, snap.Name)
+	}
+	if err != nil {
+		return fmt.Errorf("zfs: could not confirm that snapshot %s is on stable storage: %v", snap.Name, err)
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+)
+
+// txgsKstat returns the txgs kstat of a pool whose last txg is open and
+// whose txgs up to committed are synced
+func txgsKstat(committed, last int) string {
+	out := "18 1 0x01 100 11200 7512419418 8312419418\n" +
+		"txg      birth            state ndirty       nread        nwritten     reads    writes   otime        qtime        wtime        stime\n"
+	for txg := committed - 1; txg <= last; txg++ {
+		state := "C"
+		if txg > committed {
+			state = "O"
+		}
+		out += strings.Join([]string{strconv.Itoa(txg), "7512419418", state, "0", "0", "0", "0", "0", "0", "0", "0", "0"}, " ") + "\n"
+	}
+	return out
+}
+
+// fakeTxgs serves the txgs kstats in turn, the last one is kept
+func fakeTxgs(t *testing.T, kstats ...string) *int {
+	origRead, origSleep := readTxgs, sleep
+	t.Cleanup(func() { readTxgs, sleep = origRead, origSleep })
+	reads := 0
+	readTxgs = func(zpool string) ([]byte, error) {
+		if zpool != "zfspv" {
+			t.Errorf("read the txgs of pool %s, want zfspv", zpool)
+		}
+		if len(kstats) == 0 {
+			return nil, os.ErrNotExist
+		}
+		i := reads
+		if i >= len(kstats) {
+			i = len(kstats) - 1
+		}
+		reads++
+		return []byte(kstats[i]), nil
+	}
+	sleep = func(time.Duration) {}
+	return &reads
+}
+
+func durableSnapshot(durability string) *apis.ZFSSnapshot {
+	snap := syncSnapshot(VolTypeDataset, "")
+	snap.Spec.PoolName = "zfspv/parent"
+	snap.Annotations = map[string]string{SnapshotDurabilityKey: durability}
+	return snap
+}
+
+func TestParseSnapshotDurability(t *testing.T) {
+	for val, want := range map[string]string{"": SnapshotDurabilityNone, "none": SnapshotDurabilityNone,
+		"txg": SnapshotDurabilityTxg, "sync": SnapshotDurabilitySync} {
+		if got, err := ParseSnapshotDurability(val); err != nil || got != want {
+			t.Errorf("ParseSnapshotDurability(%q) = %q, %v, want %q", val, got, err, want)
+		}
+	}
+	if _, err := ParseSnapshotDurability("fsync"); err == nil {
+		t.Errorf("ParseSnapshotDurability() expected error for an unknown durability")
+	}
+}
+
+func TestParseTxgHistory(t *testing.T) {
+	last, committed, err := parseTxgHistory([]byte(txgsKstat(120, 122)))
+	if err != nil || last != 122 || committed != 120 {
+		t.Errorf("parseTxgHistory() = %d, %d, %v, want 122, 120", last, committed, err)
+	}
+
+	// zfs_txg_history is 0
+	_, _, err = parseTxgHistory([]byte("18 1 0x01 0 0 7512419418 8312419418\ntxg birth state ndirty nread nwritten reads writes otime qtime wtime stime\n"))
+	if !errors.Is(err, errDurabilityUnsupported) {
+		t.Errorf("parseTxgHistory() without history = %v, want %v", err, errDurabilityUnsupported)
+	}
+	if _, _, err = parseTxgHistory([]byte("txg birth state\nx 1 C\n")); err == nil {
+		t.Errorf("parseTxgHistory() expected error for an invalid txg")
+	}
+}
+
+func TestWaitTxgSync(t *testing.T) {
+	// the txg open at first is synced on the third read
+	reads := fakeTxgs(t, txgsKstat(120, 122), txgsKstat(121, 123), txgsKstat(122, 124))
+	if err := waitTxgSync(context.Background(), "zfspv"); err != nil {
+		t.Fatalf("waitTxgSync() unexpected error %v", err)
+	}
+	if *reads != 3 {
+		t.Errorf("waitTxgSync() read the txgs %d times, want 3", *reads)
+	}
+
+	// the txg is not synced before the deadline
+	fakeTxgs(t, txgsKstat(120, 122))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := waitTxgSync(ctx, "zfspv"); err == nil || errors.Is(err, errDurabilityUnsupported) {
+		t.Errorf("waitTxgSync() = %v, want a timeout", err)
+	}
+}
+
+func TestConfirmSnapshotDurable(t *testing.T) {
+	unknown := "unrecognized command 'sync'"
+	tests := map[string]struct {
+		durability string
+		kstats     []string
+		poolErr    string
+		wantSync   bool
+		wantErr    bool
+	}{
+		"none":                  {durability: "none"},
+		"sync":                  {durability: "sync", wantSync: true},
+		"sync failed":           {durability: "sync", poolErr: "I/O error", wantSync: true, wantErr: true},
+		"sync unknown, txg":     {durability: "sync", poolErr: unknown, wantSync: true, kstats: []string{txgsKstat(120, 121), txgsKstat(121, 122)}},
+		"txg":                   {durability: "txg", kstats: []string{txgsKstat(120, 121), txgsKstat(121, 122)}},
+		"txg without history":   {durability: "txg", wantSync: true},
+		"neither sync nor txg":  {durability: "txg", poolErr: unknown, wantSync: true, wantErr: true},
+		"sync unknown, no txgs": {durability: "sync", poolErr: unknown, wantSync: true, wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			calls := fakeSnapshotSync(t, nil)
+			if tt.poolErr != "" {
+				calls.zfs.Fail(t, "zpool sync", tt.poolErr)
+			}
+			fakeTxgs(t, tt.kstats...)
+			err := confirmSnapshotDurable(context.Background(), durableSnapshot(tt.durability))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("confirmSnapshotDurable() = %v, want error %v", err, tt.wantErr)
+			}
+			synced := reflect.DeepEqual(calls.ran(), []string{"zpool sync zfspv"})
+			if synced != tt.wantSync {
+				t.Errorf("confirmSnapshotDurable() ran %v, want the pool synced %v", calls.ran(), tt.wantSync)
+			}
+		})
+	}
+}
+
+func TestCreateSnapshotDurable(t *testing.T) {
+	// the pool is synced once the snapshot has been taken
+	calls := fakeSnapshotSync(t, nil)
+	fakeTxgs(t)
+	if err := CreateSnapshot(durableSnapshot(SnapshotDurabilitySync)); err != nil {
+		t.Fatalf("CreateSnapshot() unexpected error %v", err)
+	}
+	want := []string{"snapshot zfspv/parent/pvc-1@snap-1", "zpool sync zfspv"}
+	if !reflect.DeepEqual(calls.ran(), want) {
+		t.Errorf("CreateSnapshot() ran %v, want %v", calls.ran(), want)
+	}
+
+	// the snapshot is not ready while its sync is not confirmed
+	calls = fakeSnapshotSync(t, nil)
+	calls.zfs.Fail(t, "zpool sync", "cannot sync 'zfspv': I/O error")
+	if err := CreateSnapshot(durableSnapshot(SnapshotDurabilitySync)); err == nil {
+		t.Errorf("CreateSnapshot() expected error for an unconfirmed sync")
+	}
+}
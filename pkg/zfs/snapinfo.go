@@ -0,0 +1,66 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// parseSnapshotInfo parses the `zfs get -Hp -o value creation,referenced`
+// output of a snapshot, the creation is in seconds since the epoch and the
+// referenced size in bytes
+func parseSnapshotInfo(out []byte) (time.Time, int64, error) {
+	fields := strings.Fields(string(out))
+	if len(fields) != 2 {
+		return time.Time{}, 0, fmt.Errorf("zfs: invalid snapshot properties %q", strings.TrimSpace(string(out)))
+	}
+	creation, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("zfs: invalid snapshot creation %q", fields[0])
+	}
+	referenced, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("zfs: invalid snapshot size %q", fields[1])
+	}
+	return time.Unix(creation, 0), referenced, nil
+}
+
+// GetSnapshotInfo returns the creation time and the referenced size of
+// the zfs snapshot
+func GetSnapshotInfo(snap *apis.ZFSSnapshot) (time.Time, int64, error) {
+	dataset := SnapshotDataset(snap)
+	args := []string{ZFSGetArg, "-Hp", "-o", "value", "creation,referenced", dataset}
+	out, err := zfsCommand(args...).CombinedOutput()
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("zfs: could not get the properties of %s: %s", dataset, strings.TrimSpace(string(out)))
+	}
+	return parseSnapshotInfo(out)
+}
+
+// setSnapshotInfo records the creation time and the size of the zfs
+// snapshot in the status
+func setSnapshotInfo(snap *apis.ZFSSnapshot, creation time.Time, size int64) {
+	t := metav1.NewTime(creation)
+	snap.Status.CreationTime = &t
+	snap.Status.Size = strconv.FormatInt(size, 10)
+}
@@ -0,0 +1,46 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"testing"
+	"time"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+)
+
+func TestParseSnapshotInfo(t *testing.T) {
+	creation, size, err := parseSnapshotInfo([]byte("1682935200\n1048576\n"))
+	if err != nil {
+		t.Fatalf("parseSnapshotInfo() unexpected error %v", err)
+	}
+	if !creation.Equal(time.Unix(1682935200, 0)) || size != 1048576 {
+		t.Errorf("parseSnapshotInfo() = %v, %d", creation, size)
+	}
+
+	for _, out := range []string{"", "1682935200\n", "now\n1048576\n", "1682935200\n1M\n"} {
+		if _, _, err := parseSnapshotInfo([]byte(out)); err == nil {
+			t.Errorf("parseSnapshotInfo(%q) expected error", out)
+		}
+	}
+
+	snap := &apis.ZFSSnapshot{}
+	setSnapshotInfo(snap, creation, size)
+	if snap.Status.Size != "1048576" || !snap.Status.CreationTime.Time.Equal(creation) {
+		t.Errorf("setSnapshotInfo() status = %+v", snap.Status)
+	}
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"bufio"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"k8s.io/klog/v2"
+)
+
+// snapshot policies on volume deletion
+const (
+	// SnapshotPolicyBlock fails the volume deletion if it has snapshots
+	SnapshotPolicyBlock = "block"
+	// SnapshotPolicyDelete deletes the snapshots along with the volume
+	SnapshotPolicyDelete = "delete"
+	// SnapshotPolicyOrphan keeps the snapshots in an independent dataset
+	SnapshotPolicyOrphan = "orphan"
+
+	// OrphanDataset is the dataset in the pool holding the orphaned snapshots
+	OrphanDataset = "openebs-orphans"
+)
+
+// ValidateSnapshotPolicy returns an error if the policy is not supported
+func ValidateSnapshotPolicy(policy string) error {
+	switch policy {
+	case "", SnapshotPolicyBlock, SnapshotPolicyDelete, SnapshotPolicyOrphan:
+		return nil
+	}
+	return fmt.Errorf("zfs: invalid snapshot policy %q, valid values are %s, %s, %s",
+		policy, SnapshotPolicyBlock, SnapshotPolicyDelete, SnapshotPolicyOrphan)
+}
+
+// orphanDataset returns the dataset holding the orphaned snapshots of the volume
+func orphanDataset(pool, volume string) string {
+	return pool + "/" + OrphanDataset + "/" + volume
+}
+
+// buildOrphanArgs returns the command replicating the volume along with all
+// its snapshots up to the last one into the orphan dataset. The received
+// dataset has no origin relationship with the volume, so the volume can be
+// destroyed afterwards.
+func buildOrphanArgs(pool, volume, lastSnap string) []string {
+	src := pool + "/" + volume + "@" + lastSnap
+	cmd := zfsShell() + " " + ZFSSendArg + " -R " + src + " | " +
+		zfsShell() + " " + ZFSRecvArg + " -u " + orphanDataset(pool, volume)
+	return []string{"-c", cmd}
+}
+
+// listSnapshots returns the snapshot names of the dataset, oldest first
+func listSnapshots(dataset string) ([]string, error) {
+	args := []string{ZFSListArg, "-H", "-t", "snapshot", "-o", "name", "-s", "createtxg", "-d", "1", dataset}
+	out, err := zfsCommand(args...).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("zfs: could not list snapshots of %s: %s", dataset, string(out))
+	}
+	return parseSnapshotList(out), nil
+}
+
+// parseSnapshotList returns the snapshot names from the zfs list output
+func parseSnapshotList(out []byte) []string {
+	var snaps []string
+	scanner := bufio.NewScanner(strings.NewReader(string(out)))
+	for scanner.Scan() {
+		if parts := strings.SplitN(strings.TrimSpace(scanner.Text()), "@", 2); len(parts) == 2 {
+			snaps = append(snaps, parts[1])
+		}
+	}
+	return snaps
+}
+
+// OrphanSnapshots moves the snapshots of the volume into the orphan dataset
+// so that they survive the destruction of the volume
+func OrphanSnapshots(vol *apis.ZFSVolume) error {
+	name := datasetName(&vol.Spec, vol.Name)
+	volume := vol.Spec.PoolName + "/" + name
+	orphan := orphanDataset(vol.Spec.PoolName, name)
+
+	if err := getVolume(orphan); err == nil {
+		klog.Infof("zfs: snapshots of %s already orphaned to %s", volume, orphan)
+		return nil
+	}
+
+	snaps, err := listSnapshots(volume)
+	if err != nil {
+		return err
+	}
+	if len(snaps) == 0 {
+		return nil
+	}
+
+	parent := vol.Spec.PoolName + "/" + OrphanDataset
+	if err := getVolume(parent); err != nil {
+		out, err := zfsCommand(ZFSCreateArg,
+			"-o", "canmount=off", "-o", "mountpoint=none", parent).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("zfs: could not create %s: %s", parent, string(out))
+		}
+	}
+
+	args := buildOrphanArgs(vol.Spec.PoolName, name, snaps[len(snaps)-1])
+	out, err := exec.Command("bash", args...).CombinedOutput()
+	if err != nil {
+		klog.Errorf("zfs: could not orphan snapshots of %s cmd %v error: %s", volume, args, string(out))
+		return fmt.Errorf("zfs: could not orphan snapshots of %s: %s", volume, string(out))
+	}
+
+	klog.Infof("zfs: orphaned snapshots %v of %s to %s", snaps, volume, orphan)
+	return nil
+}
+
+// resolveSnapshot returns the dataset of the snapshot, which is the orphaned
+// copy once the volume has been deleted with the orphan policy
+func resolveSnapshot(pool, volume, snap string) string {
+	snapDataset := pool + "/" + volume + "@" + snap
+	if err := getVolume(snapDataset); err != nil {
+		orphan := orphanDataset(pool, volume) + "@" + snap
+		if err := getVolume(orphan); err == nil {
+			return orphan
+		}
+	}
+	return snapDataset
+}
+
+// destroyOrphanIfEmpty destroys the orphan dataset of the volume once its
+// last snapshot has been deleted
+func destroyOrphanIfEmpty(pool, volume string) {
+	orphan := orphanDataset(pool, volume)
+	snaps, err := listSnapshots(orphan)
+	if err != nil || len(snaps) != 0 {
+		return
+	}
+	if out, err := zfsCommand(ZFSDestroyArg, orphan).CombinedOutput(); err != nil {
+		klog.Errorf("zfs: could not destroy orphan dataset %s: %s", orphan, string(out))
+		return
+	}
+	klog.Infof("zfs: destroyed orphan dataset %s", orphan)
+}
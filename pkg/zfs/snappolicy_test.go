@@ -0,0 +1,55 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidateSnapshotPolicy(t *testing.T) {
+	for _, policy := range []string{"", "block", "delete", "orphan"} {
+		if err := ValidateSnapshotPolicy(policy); err != nil {
+			t.Errorf("policy %q: unexpected error %v", policy, err)
+		}
+	}
+	for _, policy := range []string{"keep", "Delete"} {
+		if err := ValidateSnapshotPolicy(policy); err == nil {
+			t.Errorf("policy %q: expected an error", policy)
+		}
+	}
+}
+
+func TestBuildOrphanArgs(t *testing.T) {
+	got := buildOrphanArgs("zfspv", "pvc-1", "snapshot-2")
+	want := []string{"-c", "zfs send -R zfspv/pvc-1@snapshot-2 | zfs recv -u zfspv/openebs-orphans/pvc-1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestParseSnapshotList(t *testing.T) {
+	out := []byte("zfspv/pvc-1@snapshot-1\nzfspv/pvc-1@snapshot-2\n\n")
+	got := parseSnapshotList(out)
+	want := []string{"snapshot-1", "snapshot-2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got := parseSnapshotList(nil); len(got) != 0 {
+		t.Errorf("expected no snapshots, got %v", got)
+	}
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+)
+
+const (
+	// maxUserPropNameLen is the longest name of a user property zfs accepts
+	maxUserPropNameLen = 256
+	// maxUserPropValueLen is the longest value of a user property zfs accepts
+	maxUserPropValueLen = 8192
+
+	// driverPropPrefix is the namespace of the user properties set by the
+	// driver itself, e.g. the provisioning marker and the fence
+	driverPropPrefix = "openebs.io:"
+)
+
+// setSnapshotProperty sets the property on the zfs snapshot, can be
+// replaced in unit tests
+var setSnapshotProperty = func(snap *apis.ZFSSnapshot, prop, value string) error {
+	out, err := zfsCommand(ZFSSetArg, prop+"="+value, SnapshotDataset(snap)).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("zfs set %s failed, %s", prop, string(out))
+	}
+	return nil
+}
+
+// validateUserPropName checks the name as per the zfs rules for the user
+// properties: it has a colon, at most 256 lowercase letters, numbers and
+// ":", "+", ".", "_" or "-", and does not start with a dash.
+func validateUserPropName(name string) error {
+	if !strings.Contains(name, ":") {
+		return fmt.Errorf("user property %q should contain a colon", name)
+	}
+	if len(name) > maxUserPropNameLen {
+		return fmt.Errorf("user property %q is longer than %d characters", name, maxUserPropNameLen)
+	}
+	if strings.HasPrefix(name, "-") {
+		return fmt.Errorf("user property %q should not start with a dash", name)
+	}
+	for _, c := range name {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && !strings.ContainsRune(":+._-", c) {
+			return fmt.Errorf("user property %q has an invalid character %q", name, c)
+		}
+	}
+	if strings.HasPrefix(name, driverPropPrefix) {
+		return fmt.Errorf("user property %q is reserved for the driver", name)
+	}
+	return nil
+}
+
+// ValidateSnapshotProperties returns an error if a user property of the
+// snapshot can not be set by zfs
+func ValidateSnapshotProperties(props map[string]string) error {
+	for _, name := range sortedKeys(props) {
+		if err := validateUserPropName(name); err != nil {
+			return err
+		}
+		if len(props[name]) > maxUserPropValueLen {
+			return fmt.Errorf("value of user property %q is longer than %d bytes", name, maxUserPropValueLen)
+		}
+	}
+	return nil
+}
+
+// ParseSnapshotProperties parses the comma separated list of name=value
+// user properties given in the snapshotproperties parameter of the
+// VolumeSnapshotClass
+func ParseSnapshotProperties(val string) (map[string]string, error) {
+	if strings.TrimSpace(val) == "" {
+		return nil, nil
+	}
+	props := map[string]string{}
+	for _, kv := range strings.Split(val, ",") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(kv, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid snapshot property %q, it should be name=value", kv)
+		}
+		props[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	if err := ValidateSnapshotProperties(props); err != nil {
+		return nil, err
+	}
+	return props, nil
+}
+
+// setSnapshotProperties sets the user properties of the spec on the zfs
+// snapshot, setting them again is harmless
+func setSnapshotProperties(snap *apis.ZFSSnapshot) error {
+	props := snap.Spec.SnapshotProperties
+	for _, name := range sortedKeys(props) {
+		if err := setSnapshotProperty(snap, name, props[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
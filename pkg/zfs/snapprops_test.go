@@ -0,0 +1,108 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+)
+
+func TestValidateSnapshotProperties(t *testing.T) {
+	valid := map[string]string{
+		"com.example:retention": "gold",
+		"backup:job-id":         "Job 42",
+		"a+b:c_d.e":             "",
+	}
+	if err := ValidateSnapshotProperties(valid); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	for _, name := range []string{
+		"retention",
+		"-backup:job",
+		"Backup:job",
+		"backup:job id",
+		"openebs.io:fence",
+		"a:" + strings.Repeat("x", maxUserPropNameLen),
+	} {
+		if err := ValidateSnapshotProperties(map[string]string{name: "v"}); err == nil {
+			t.Errorf("expected error for name %q", name)
+		}
+	}
+
+	long := map[string]string{"backup:job": strings.Repeat("x", maxUserPropValueLen+1)}
+	if err := ValidateSnapshotProperties(long); err == nil {
+		t.Errorf("expected error for a long value")
+	}
+}
+
+func TestParseSnapshotProperties(t *testing.T) {
+	got, err := ParseSnapshotProperties(" com.example:retention=gold , backup:job=Nightly=1,")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	want := map[string]string{"com.example:retention": "gold", "backup:job": "Nightly=1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if got, err := ParseSnapshotProperties(""); err != nil || got != nil {
+		t.Errorf("empty: got %v %v", got, err)
+	}
+	for _, val := range []string{"backup:job", "retention=gold"} {
+		if _, err := ParseSnapshotProperties(val); err == nil {
+			t.Errorf("expected error for %q", val)
+		}
+	}
+}
+
+func TestSetSnapshotProperties(t *testing.T) {
+	orig := setSnapshotProperty
+	defer func() { setSnapshotProperty = orig }()
+
+	snap := &apis.ZFSSnapshot{}
+	snap.Name = "snap-1"
+	snap.Spec.PoolName = "zfspv"
+	snap.Spec.SnapshotProperties = map[string]string{"b:job": "42", "a:retention": "gold"}
+
+	var set []string
+	setSnapshotProperty = func(s *apis.ZFSSnapshot, prop, value string) error {
+		set = append(set, prop+"="+value)
+		return nil
+	}
+	if err := setSnapshotProperties(snap); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if want := []string{"a:retention=gold", "b:job=42"}; !reflect.DeepEqual(set, want) {
+		t.Errorf("got %v, want %v", set, want)
+	}
+
+	setSnapshotProperty = func(*apis.ZFSSnapshot, string, string) error { return errors.New("busy") }
+	if err := setSnapshotProperties(snap); err == nil {
+		t.Errorf("expected the error of zfs set")
+	}
+
+	// nothing to set without properties
+	snap.Spec.SnapshotProperties = nil
+	if err := setSnapshotProperties(snap); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// SnapshotSpace is the space used by the snapshots of a pool
+type SnapshotSpace struct {
+	// Used is the sum of the space used by each snapshot in bytes
+	Used int64
+	// Count is the number of snapshots
+	Count int64
+}
+
+// listSnapshotUsed runs `zfs list` for all the snapshots on the node and
+// hands its output to parse as it is produced, can be replaced in unit
+// tests. A node may have a lot of snapshots, so the output is not buffered.
+var listSnapshotUsed = func(ctx context.Context, parse func(io.Reader) error) error {
+	cmd := zfsCommandContext(ctx, "list", "-H", "-p", "-t", "snapshot", "-o", "name,used")
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err = cmd.Start(); err != nil {
+		return err
+	}
+
+	perr := parse(stdout)
+	if perr != nil {
+		// drain the output so that zfs does not block on a full pipe
+		_, _ = io.Copy(io.Discard, stdout)
+	}
+	if err = cmd.Wait(); err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return perr
+}
+
+// ListSnapshotSpace returns the space used by the snapshots of each pool,
+// all the snapshots are listed with a single `zfs list` call
+func ListSnapshotSpace(ctx context.Context) (map[string]SnapshotSpace, error) {
+	var space map[string]SnapshotSpace
+	err := listSnapshotUsed(ctx, func(r io.Reader) error {
+		var err error
+		space, err = sumSnapshotUsed(r)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("zfs: could not list the snapshots: %v", err)
+	}
+	return space, nil
+}
+
+// sumSnapshotUsed sums the space used by the snapshots of each pool from
+// the output of `zfs list -H -p -t snapshot -o name,used`, e.g.
+// zfspv-pool/pvc-1@snapshot-1	81920
+func sumSnapshotUsed(r io.Reader) (map[string]SnapshotSpace, error) {
+	space := map[string]SnapshotSpace{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		items := strings.Split(line, "\t")
+		if len(items) != 2 {
+			return nil, fmt.Errorf("invalid zfs list output %q", line)
+		}
+		used, err := strconv.ParseInt(items[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid used space %q of snapshot %s: %v", items[1], items[0], err)
+		}
+
+		pool := items[0]
+		if i := strings.IndexAny(pool, "/@"); i >= 0 {
+			pool = pool[:i]
+		}
+		s := space[pool]
+		s.Used += used
+		s.Count++
+		space[pool] = s
+	}
+	return space, scanner.Err()
+}
+
+// setSnapshotSpace sets the space used by the snapshots of each pool in
+// the summary, a pool without snapshots uses none
+func setSnapshotSpace(summary []apis.PoolSummary, space map[string]SnapshotSpace) {
+	for i := range summary {
+		s := space[summary[i].Name]
+		summary[i].SnapshotUsed = resource.NewQuantity(s.Used, resource.BinarySI)
+		summary[i].Snapshots = s.Count
+	}
+}
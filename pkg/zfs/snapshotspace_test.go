@@ -0,0 +1,85 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestSumSnapshotUsed(t *testing.T) {
+	space, err := sumSnapshotUsed(strings.NewReader("zfspv-pool/pvc-1@snap-1\t81920\n" +
+		"zfspv-pool/pvc-1@snap-2\t0\n" +
+		"\n" +
+		"zfspv-pool/parent/pvc-2@snap-1\t4096\n" +
+		"backup@weekly\t512\n"))
+	if err != nil {
+		t.Fatalf("sumSnapshotUsed() unexpected error %v", err)
+	}
+	if got := space["zfspv-pool"]; got != (SnapshotSpace{Used: 86016, Count: 3}) {
+		t.Errorf("sumSnapshotUsed() zfspv-pool = %+v", got)
+	}
+	if got := space["backup"]; got != (SnapshotSpace{Used: 512, Count: 1}) {
+		t.Errorf("sumSnapshotUsed() backup = %+v", got)
+	}
+
+	for _, out := range []string{
+		"zfspv-pool/pvc-1@snap-1\n",
+		"zfspv-pool/pvc-1@snap-1\t-\n",
+	} {
+		if _, err := sumSnapshotUsed(strings.NewReader(out)); err == nil {
+			t.Errorf("sumSnapshotUsed(%q) expected error", out)
+		}
+	}
+}
+
+func TestListSnapshotSpaceLargeList(t *testing.T) {
+	defer func(f func(context.Context, func(io.Reader) error) error) { listSnapshotUsed = f }(listSnapshotUsed)
+
+	const volumes, snapshots = 1000, 200
+	listSnapshotUsed = func(ctx context.Context, parse func(io.Reader) error) error {
+		// the output is streamed to the parser as zfs would write it
+		r, w := io.Pipe()
+		go func() {
+			for v := 0; v < volumes; v++ {
+				for s := 0; s < snapshots; s++ {
+					fmt.Fprintf(w, "zfspv-pool/pvc-%d@snap-%d\t%d\n", v, s, 1<<20)
+				}
+			}
+			w.Close()
+		}()
+		return parse(r)
+	}
+
+	space, err := ListSnapshotSpace(context.Background())
+	if err != nil {
+		t.Fatalf("ListSnapshotSpace() unexpected error %v", err)
+	}
+	want := SnapshotSpace{Used: volumes * snapshots << 20, Count: volumes * snapshots}
+	if got := space["zfspv-pool"]; got != want || len(space) != 1 {
+		t.Errorf("ListSnapshotSpace() = %+v, want %+v", space, want)
+	}
+
+	listSnapshotUsed = func(context.Context, func(io.Reader) error) error { return errors.New("exit status 1") }
+	if _, err = ListSnapshotSpace(context.Background()); err == nil {
+		t.Errorf("ListSnapshotSpace() expected error when zfs fails")
+	}
+}
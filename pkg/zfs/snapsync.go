@@ -0,0 +1,125 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	mnt "github.com/openebs/lib-csi/pkg/mount"
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"k8s.io/klog/v2"
+)
+
+// SnapshotSyncKey is the annotation on the ZFSSnapshot holding how the
+// writes to the volume are flushed before the snapshot is taken, it is set
+// from the "snapshotsync" parameter of the VolumeSnapshotClass
+const SnapshotSyncKey string = "openebs.io/snapshot-sync"
+
+// the ways of flushing the volume before its snapshot
+const (
+	// SnapshotSyncNone takes the snapshot right away, it is the default
+	SnapshotSyncNone = "none"
+	// SnapshotSyncVolume flushes the filesystem of the volume mounted on
+	// the node and the device of a zvol
+	SnapshotSyncVolume = "volume"
+	// SnapshotSyncPool also commits the pending transaction group of the
+	// pool with `zpool sync`
+	SnapshotSyncPool = "pool"
+)
+
+// runSync runs sync with the arguments, can be replaced in unit tests
+var runSync = func(ctx context.Context, args ...string) error {
+	out, err := exec.CommandContext(ctx, "sync", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("sync %s failed: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// snapshotMounts returns the device of the volume of the snapshot and the
+// paths it is mounted at on the node, the device is empty for a dataset.
+// It can be replaced in unit tests.
+var snapshotMounts = func(snap *apis.ZFSSnapshot) (string, []string, error) {
+	volume := snapshotVolumeDataset(snap)
+	if snap.Spec.VolumeType == VolTypeDataset {
+		mounts, err := mnt.GetMounts(volume)
+		return "", mounts, err
+	}
+	dev, err := filepath.EvalSymlinks(ZFSDevPath + volume)
+	if err != nil {
+		return "", nil, err
+	}
+	mounts, err := mnt.GetMounts(dev)
+	return dev, mounts, err
+}
+
+// ParseSnapshotSync parses the snapshot sync, empty means none
+func ParseSnapshotSync(val string) (string, error) {
+	switch val {
+	case "":
+		return SnapshotSyncNone, nil
+	case SnapshotSyncNone, SnapshotSyncVolume, SnapshotSyncPool:
+		return val, nil
+	}
+	return "", fmt.Errorf("invalid snapshotsync %q, it should be %s, %s or %s",
+		val, SnapshotSyncNone, SnapshotSyncVolume, SnapshotSyncPool)
+}
+
+// syncSnapshotVolume flushes the writes acknowledged to the volume of the
+// snapshot before it is taken, as asked by its snapshot sync. The flush is
+// scoped to the volume: the filesystem it is mounted with is synced with
+// `sync -f`, once as all its mounts share it, and the device of a zvol is
+// synced for the writes of a raw block volume. With the pool sync, `zpool
+// sync` then commits the pool, it is skipped on a zfs too old to have it.
+func syncSnapshotVolume(ctx context.Context, snap *apis.ZFSSnapshot) error {
+	mode, err := ParseSnapshotSync(snap.Annotations[SnapshotSyncKey])
+	if err != nil || mode == SnapshotSyncNone {
+		return err
+	}
+	dev, mounts, err := snapshotMounts(snap)
+	if err != nil {
+		return fmt.Errorf("zfs: could not find the mounts of snapshot %s: %v", snap.Name, err)
+	}
+	if len(mounts) != 0 {
+		if err := runSync(ctx, "-f", mounts[0]); err != nil {
+			return err
+		}
+	}
+	if dev != "" {
+		if err := runSync(ctx, dev); err != nil {
+			return err
+		}
+	}
+	if mode != SnapshotSyncPool {
+		return nil
+	}
+	pool := zpoolOf(snap.Spec.PoolName)
+	out, err := zpoolCommand(ctx, "sync", pool).CombinedOutput()
+	if err == nil {
+		return nil
+	}
+	if strings.Contains(string(out), "unrecognized command") {
+		klog.Warningf("zfs: zpool sync is not supported, pool %s is not synced for snapshot %s",
+			pool, snap.Name)
+		return nil
+	}
+	return fmt.Errorf("zfs: could not sync pool %s: %v: %s", pool, err, strings.TrimSpace(string(out)))
+}
@@ -0,0 +1,159 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"github.com/openebs/zfs-localpv/pkg/zfs/zfstest"
+)
+
+func syncSnapshot(volType, sync string) *apis.ZFSSnapshot {
+	snap := &apis.ZFSSnapshot{}
+	snap.Name = "snap-1"
+	snap.Labels = map[string]string{ZFSVolKey: "pvc-1"}
+	snap.Spec.PoolName = "zfspv"
+	snap.Spec.VolumeType = volType
+	if sync != "" {
+		snap.Annotations = map[string]string{SnapshotSyncKey: sync}
+	}
+	return snap
+}
+
+// syncCalls records the syncs and the zfs and zpool commands in the order
+// they are run, the lookups are left out
+type syncCalls struct {
+	zfs   *zfstest.FakeZFS
+	seen  int
+	calls []string
+}
+
+// ran returns the commands run so far
+func (c *syncCalls) ran() []string {
+	writes := zfsWrites(c.zfs)
+	c.calls = append(c.calls, writes[c.seen:]...)
+	c.seen = len(writes)
+	return c.calls
+}
+
+// fakeSnapshotSync runs the commands on the fake zfs holding the volumes
+// of the snapshots, the volume is mounted at mounts
+func fakeSnapshotSync(t *testing.T, mounts []string) *syncCalls {
+	origSync, origMounts := runSync, snapshotMounts
+	t.Cleanup(func() { runSync, snapshotMounts = origSync, origMounts })
+	c := &syncCalls{zfs: newFakeZFS(t, "zfspv/pvc-1", "zfspv/parent/pvc-1")}
+	runSync = func(ctx context.Context, args ...string) error {
+		c.calls = append(c.ran(), "sync "+strings.Join(args, " "))
+		return nil
+	}
+	snapshotMounts = func(snap *apis.ZFSSnapshot) (string, []string, error) {
+		if snap.Spec.VolumeType == VolTypeDataset {
+			return "", mounts, nil
+		}
+		return "/dev/zd0", mounts, nil
+	}
+	return c
+}
+
+func TestParseSnapshotSync(t *testing.T) {
+	for val, want := range map[string]string{"": SnapshotSyncNone, "none": SnapshotSyncNone,
+		"volume": SnapshotSyncVolume, "pool": SnapshotSyncPool} {
+		if got, err := ParseSnapshotSync(val); err != nil || got != want {
+			t.Errorf("ParseSnapshotSync(%q) = %q, %v, want %q", val, got, err, want)
+		}
+	}
+	if _, err := ParseSnapshotSync("all"); err == nil {
+		t.Errorf("ParseSnapshotSync() expected error for an unknown sync")
+	}
+}
+
+func TestSyncSnapshotVolume(t *testing.T) {
+	tests := []struct {
+		name   string
+		snap   *apis.ZFSSnapshot
+		mounts []string
+		want   []string
+	}{
+		{"none", syncSnapshot(VolTypeDataset, ""), []string{"/mnt/a"}, nil},
+		{"dataset", syncSnapshot(VolTypeDataset, SnapshotSyncVolume), []string{"/mnt/a", "/mnt/b"},
+			[]string{"sync -f /mnt/a"}},
+		{"unmounted dataset", syncSnapshot(VolTypeDataset, SnapshotSyncVolume), nil, nil},
+		{"zvol", syncSnapshot(VolTypeZVol, SnapshotSyncVolume), []string{"/mnt/a"},
+			[]string{"sync -f /mnt/a", "sync /dev/zd0"}},
+		{"raw block zvol", syncSnapshot(VolTypeZVol, SnapshotSyncPool), nil,
+			[]string{"sync /dev/zd0", "zpool sync zfspv"}},
+		{"pool", syncSnapshot(VolTypeDataset, SnapshotSyncPool), []string{"/mnt/a"},
+			[]string{"sync -f /mnt/a", "zpool sync zfspv"}},
+	}
+	for _, tt := range tests {
+		calls := fakeSnapshotSync(t, tt.mounts)
+		if err := syncSnapshotVolume(context.Background(), tt.snap); err != nil {
+			t.Errorf("%s: syncSnapshotVolume() unexpected error %v", tt.name, err)
+		}
+		if got := calls.ran(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: syncSnapshotVolume() ran %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// the zpool is synced for a volume in a parent dataset
+	calls := fakeSnapshotSync(t, nil)
+	snap := syncSnapshot(VolTypeDataset, SnapshotSyncPool)
+	snap.Spec.PoolName = "zfspv/parent"
+	if err := syncSnapshotVolume(context.Background(), snap); err != nil {
+		t.Errorf("syncSnapshotVolume() in a parent dataset = %v", err)
+	}
+	if want := []string{"zpool sync zfspv"}; !reflect.DeepEqual(calls.ran(), want) {
+		t.Errorf("syncSnapshotVolume() in a parent dataset ran %v, want %v", calls.ran(), want)
+	}
+
+	// the pool is not synced by a zfs without zpool sync
+	calls = fakeSnapshotSync(t, nil)
+	calls.zfs.Fail(t, "zpool sync", "unrecognized command 'sync'")
+	if err := syncSnapshotVolume(context.Background(), syncSnapshot(VolTypeDataset, SnapshotSyncPool)); err != nil {
+		t.Errorf("syncSnapshotVolume() without zpool sync = %v", err)
+	}
+	calls.zfs.Fail(t, "zpool sync", "cannot sync 'zfspv': I/O error")
+	if err := syncSnapshotVolume(context.Background(), syncSnapshot(VolTypeDataset, SnapshotSyncPool)); err == nil {
+		t.Errorf("syncSnapshotVolume() expected error for a failed zpool sync")
+	}
+}
+
+func TestCreateSnapshotSync(t *testing.T) {
+	calls := fakeSnapshotSync(t, []string{"/mnt/a"})
+	if err := CreateSnapshot(syncSnapshot(VolTypeDataset, SnapshotSyncPool)); err != nil {
+		t.Fatalf("CreateSnapshot() unexpected error %v", err)
+	}
+	want := []string{"sync -f /mnt/a", "zpool sync zfspv", "snapshot zfspv/pvc-1@snap-1"}
+	if !reflect.DeepEqual(calls.ran(), want) {
+		t.Errorf("CreateSnapshot() ran %v, want %v", calls.ran(), want)
+	}
+
+	// the snapshot is not taken if the volume can not be flushed
+	calls = fakeSnapshotSync(t, []string{"/mnt/a"})
+	runSync = func(ctx context.Context, args ...string) error { return errors.New("sync -f /mnt/a failed") }
+	if err := CreateSnapshot(syncSnapshot(VolTypeDataset, SnapshotSyncVolume)); err == nil {
+		t.Errorf("CreateSnapshot() expected error for a failed sync")
+	}
+	if got := calls.ran(); len(got) != 0 || calls.zfs.Exists("zfspv/pvc-1@snap-1") {
+		t.Errorf("CreateSnapshot() ran %v after a failed sync", got)
+	}
+}
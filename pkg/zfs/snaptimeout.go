@@ -0,0 +1,92 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"fmt"
+	"time"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"github.com/openebs/zfs-localpv/pkg/builder/snapbuilder"
+	"k8s.io/klog/v2"
+)
+
+// SnapshotTimeoutKey is the annotation on the ZFSSnapshot holding the time
+// the snapshot has to be taken within, it is set from the "snapshottimeout"
+// parameter of the VolumeSnapshotClass
+const SnapshotTimeoutKey string = "openebs.io/snapshot-timeout"
+
+// ParseSnapshotTimeout parses the snapshot timeout, empty means the
+// snapshot has no timeout
+func ParseSnapshotTimeout(val string) (time.Duration, error) {
+	if val == "" {
+		return 0, nil
+	}
+	timeout, err := time.ParseDuration(val)
+	if err != nil || timeout <= 0 {
+		return 0, fmt.Errorf("invalid snapshottimeout %q, it should be a positive duration like 10m", val)
+	}
+	return timeout, nil
+}
+
+// SnapshotDeadline returns the time the snapshot has to be taken by, it
+// is false if the snapshot has no timeout
+func SnapshotDeadline(snap *apis.ZFSSnapshot) (time.Time, bool) {
+	timeout, err := ParseSnapshotTimeout(snap.Annotations[SnapshotTimeoutKey])
+	if err != nil || timeout == 0 || snap.CreationTimestamp.IsZero() {
+		return time.Time{}, false
+	}
+	return snap.CreationTimestamp.Add(timeout), true
+}
+
+// SnapshotExpired returns true if the snapshot is still pending after its
+// deadline
+func SnapshotExpired(snap *apis.ZFSSnapshot, now time.Time) bool {
+	if snap.Status.State == ZFSStatusReady || snap.Status.State == ZFSStatusFailed {
+		return false
+	}
+	deadline, ok := SnapshotDeadline(snap)
+	return ok && !now.Before(deadline)
+}
+
+// SnapshotTimeoutError returns the reason of a snapshot failed on its timeout
+func SnapshotTimeoutError(snap *apis.ZFSSnapshot) string {
+	return fmt.Sprintf("snapshot has not been taken on node %s within %s",
+		snap.Spec.OwnerNodeID, snap.Annotations[SnapshotTimeoutKey])
+}
+
+// FailSnapshot marks the snapshot as Failed with the reason, it is not
+// attempted again
+func FailSnapshot(snap *apis.ZFSSnapshot, reason string) error {
+	newSnap := snap.DeepCopy()
+	newSnap.Status.State = ZFSStatusFailed
+	newSnap.Status.Error = reason
+	klog.Errorf("zfs: snapshot %s failed: %s", snap.Name, reason)
+	_, err := snapbuilder.NewKubeclient().WithNamespace(OpenEBSNamespace).Update(apiSnapshot(newSnap))
+	return err
+}
+
+// FailExpiredSnapshot marks the snapshot not taken within its timeout as
+// Failed. zfs snapshot may have completed right before it was killed, the
+// snapshot is then destroyed first, as a Failed snapshot is not attempted
+// again and would be left behind on the node.
+func FailExpiredSnapshot(snap *apis.ZFSSnapshot) error {
+	if err := DestroySnapshot(snap); err != nil {
+		return err
+	}
+	return FailSnapshot(snap, SnapshotTimeoutError(snap))
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"testing"
+	"time"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParseSnapshotTimeout(t *testing.T) {
+	if timeout, err := ParseSnapshotTimeout(""); err != nil || timeout != 0 {
+		t.Errorf("ParseSnapshotTimeout(\"\") = %v, %v, want no timeout", timeout, err)
+	}
+	if timeout, err := ParseSnapshotTimeout("90s"); err != nil || timeout != 90*time.Second {
+		t.Errorf("ParseSnapshotTimeout(90s) = %v, %v", timeout, err)
+	}
+	for _, val := range []string{"0s", "-1m", "10"} {
+		if _, err := ParseSnapshotTimeout(val); err == nil {
+			t.Errorf("ParseSnapshotTimeout(%s) expected an error", val)
+		}
+	}
+}
+
+func TestSnapshotExpired(t *testing.T) {
+	createdAt := time.Unix(1682935200, 0)
+	snap := &apis.ZFSSnapshot{}
+	snap.CreationTimestamp = metav1.NewTime(createdAt)
+	snap.Status.State = ZFSStatusPending
+
+	// no timeout asked for
+	if SnapshotExpired(snap, createdAt.Add(24*time.Hour)) {
+		t.Errorf("snapshot without timeout expired")
+	}
+
+	snap.Annotations = map[string]string{SnapshotTimeoutKey: "5m"}
+	if deadline, ok := SnapshotDeadline(snap); !ok || !deadline.Equal(createdAt.Add(5*time.Minute)) {
+		t.Errorf("SnapshotDeadline() = %v, %v", deadline, ok)
+	}
+	if SnapshotExpired(snap, createdAt.Add(4*time.Minute)) {
+		t.Errorf("snapshot expired before its deadline")
+	}
+	if !SnapshotExpired(snap, createdAt.Add(5*time.Minute)) {
+		t.Errorf("pending snapshot did not expire at its deadline")
+	}
+
+	for _, state := range []string{ZFSStatusReady, ZFSStatusFailed} {
+		snap.Status.State = state
+		if SnapshotExpired(snap, createdAt.Add(time.Hour)) {
+			t.Errorf("%s snapshot expired", state)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"fmt"
+	"strconv"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+)
+
+// usedByProperties are the properties breaking down the space used by a
+// dataset or a zvol, the sum of which is its used property
+var usedByProperties = []string{"usedbysnapshots", "usedbydataset", "usedbychildren", "usedbyrefreservation"}
+
+// setUsedBy sets the usedby property of the breakdown, a property which
+// does not apply to the type of the dataset is printed as "-" and is 0
+func setUsedBy(usage *apis.VolumeSpaceUsage, name, value string) error {
+	var bytes int64
+	if value != "-" {
+		var err error
+		if bytes, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return fmt.Errorf("invalid %s %q", name, value)
+		}
+	}
+	switch name {
+	case "usedbysnapshots":
+		usage.UsedBySnapshots = bytes
+	case "usedbydataset":
+		usage.UsedByDataset = bytes
+	case "usedbychildren":
+		usage.UsedByChildren = bytes
+	case "usedbyrefreservation":
+		usage.UsedByRefreservation = bytes
+	default:
+		return fmt.Errorf("unknown property %q", name)
+	}
+	return nil
+}
+
+// UpdateVolumeSpaceUsage sets the space usage in the status of the latest
+// version of the ZFSVolume
+func UpdateVolumeSpaceUsage(name string, usage *apis.VolumeSpaceUsage) error {
+	vol, err := GetZFSVolume(name)
+	if err != nil {
+		return err
+	}
+	vol.Status.SpaceUsage = usage
+	return UpdateVolumeStatus(vol)
+}
@@ -0,0 +1,147 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/openebs/zfs-localpv/pkg/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// DefaultStatusUpdateInterval is the minimum interval in between two writes
+// of the noisy status fields of an object when it is not set
+const DefaultStatusUpdateInterval = time.Minute
+
+// StatusCoalescer suppresses the status writes which would not change
+// anything and debounces the writes of the noisy fields to at most one per
+// interval per object. Critical transitions are always written right away.
+type StatusCoalescer struct {
+	mtx      sync.Mutex
+	interval time.Duration
+	written  map[string]time.Time
+	updated  map[string]StatusObject
+
+	// now returns the current time, can be replaced in unit tests
+	now func() time.Time
+}
+
+// NewStatusCoalescer returns the coalescer writing the noisy status fields
+// of an object at most once per interval
+func NewStatusCoalescer(interval time.Duration) *StatusCoalescer {
+	return &StatusCoalescer{
+		interval: interval,
+		written:  map[string]time.Time{},
+		updated:  map[string]StatusObject{},
+		now:      time.Now,
+	}
+}
+
+// StatusObject is an object whose updates go through the coalescer
+type StatusObject interface {
+	metav1.Object
+	runtime.Object
+}
+
+// StatusUpdates is the coalescer used by the node agent for the status writes
+var StatusUpdates = NewStatusCoalescer(DefaultStatusUpdateInterval)
+
+// SetStatusUpdateInterval validates the minimum interval in between two
+// writes of the noisy status fields of an object and replaces the
+// coalescer of the node agent with one using it
+func SetStatusUpdateInterval(interval time.Duration) error {
+	if interval < 0 {
+		return fmt.Errorf("invalid status update interval %v, it should be a positive duration", interval)
+	}
+	StatusUpdates = NewStatusCoalescer(interval)
+	return nil
+}
+
+// ShouldWrite tells whether the desired status of the object identified by
+// the key has to be written. Nothing is written if it is the same as the
+// current one, a critical change is always written and any other change is
+// written only if the object has not been written within the interval.
+func (c *StatusCoalescer) ShouldWrite(key string, current, desired interface{}, critical bool) bool {
+	if equality.Semantic.DeepEqual(current, desired) {
+		return false
+	}
+	if critical || c.interval <= 0 {
+		return true
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	last, ok := c.written[key]
+	return !ok || c.now().Sub(last) >= c.interval
+}
+
+// ShouldUpdate tells whether the object identified by the key has to be
+// updated to desired. The object is compared with the one returned by its
+// last update, if desired is still at its version: the update is skipped if
+// nothing has changed and debounced if only the noisy fields, cleared by
+// quiet, have changed. desired is always written otherwise.
+func (c *StatusCoalescer) ShouldUpdate(key string, desired StatusObject, quiet func(StatusObject)) bool {
+	c.mtx.Lock()
+	last, ok := c.updated[key]
+	c.mtx.Unlock()
+	if !ok || last.GetResourceVersion() != desired.GetResourceVersion() {
+		return true
+	}
+
+	// the type meta is not always set on the decoded objects
+	l, d := last.DeepCopyObject().(StatusObject), desired.DeepCopyObject().(StatusObject)
+	l.GetObjectKind().SetGroupVersionKind(schema.GroupVersionKind{})
+	d.GetObjectKind().SetGroupVersionKind(schema.GroupVersionKind{})
+	if equality.Semantic.DeepEqual(l, d) {
+		return false
+	}
+	critical := true
+	if quiet != nil {
+		quiet(l)
+		quiet(d)
+		critical = !equality.Semantic.DeepEqual(l, d)
+	}
+	return c.ShouldWrite(key, last, desired, critical)
+}
+
+// Written records the successful status write of the object
+func (c *StatusCoalescer) Written(key string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.written[key] = c.now()
+}
+
+// Updated records the successful update of the object, obj being the
+// object returned by the update
+func (c *StatusCoalescer) Updated(key string, obj StatusObject) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.written[key] = c.now()
+	c.updated[key] = obj.DeepCopyObject().(StatusObject)
+}
+
+// Forget drops the object, e.g. once it has been deleted
+func (c *StatusCoalescer) Forget(key string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	delete(c.written, key)
+	delete(c.updated, key)
+}
@@ -0,0 +1,145 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"testing"
+	"time"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestStatusCoalescer(t *testing.T) {
+	now := time.Now()
+	c := NewStatusCoalescer(time.Minute)
+	c.now = func() time.Time { return now }
+
+	pool := func(free string) []apis.Pool {
+		return []apis.Pool{{Name: "zfspv", UUID: "123", Free: resource.MustParse(free)}}
+	}
+	written := 0
+	write := func(current, desired interface{}, critical bool) {
+		if c.ShouldWrite("zfsnode/node1", current, desired, critical) {
+			written++
+			c.Written("zfsnode/node1")
+		}
+	}
+
+	// the same value in another format is a no-op
+	write(pool("1Gi"), pool("1024Mi"), false)
+	if written != 0 {
+		t.Fatalf("no-op status write was not suppressed")
+	}
+
+	write(pool("1Gi"), pool("2Gi"), false)
+	if written != 1 {
+		t.Fatalf("changed status was not written")
+	}
+
+	// debounced within the interval
+	write(pool("2Gi"), pool("3Gi"), false)
+	if written != 1 {
+		t.Fatalf("noisy status was written within the interval")
+	}
+
+	// critical changes are written right away
+	write(pool("2Gi"), nil, true)
+	if written != 2 {
+		t.Fatalf("critical status was not written")
+	}
+
+	// and the noisy ones once the interval is over
+	now = now.Add(time.Minute)
+	write(pool("2Gi"), pool("3Gi"), false)
+	if written != 3 {
+		t.Fatalf("changed status was not written after the interval")
+	}
+
+	// a critical write of the same status is still a no-op
+	write(pool("3Gi"), pool("3Gi"), true)
+	if written != 3 {
+		t.Fatalf("no-op critical status write was not suppressed")
+	}
+}
+
+func TestStatusCoalescerUpdate(t *testing.T) {
+	now := time.Now()
+	c := NewStatusCoalescer(time.Minute)
+	c.now = func() time.Time { return now }
+
+	vol := &apis.ZFSVolume{}
+	vol.Name = "pvc-1"
+	vol.ResourceVersion = "1"
+	vol.Status.State = ZFSStatusPending
+	key := volStatusKey(vol.Name)
+	// nothing is known of the object before its first update
+	if !c.ShouldUpdate(key, vol, quietVolume) {
+		t.Fatalf("the first update was suppressed")
+	}
+	updated := vol.DeepCopy()
+	updated.ResourceVersion = "2"
+	c.Updated(key, updated)
+
+	// the object read back after the update is not written again
+	same := updated.DeepCopy()
+	same.Kind = "ZFSVolume"
+	if c.ShouldUpdate(key, same, quietVolume) {
+		t.Errorf("no-op update was not suppressed")
+	}
+
+	// the space usage is debounced, the state is not
+	usage := updated.DeepCopy()
+	usage.Status.SpaceUsage = &apis.VolumeSpaceUsage{UsedByDataset: 1}
+	if c.ShouldUpdate(key, usage, quietVolume) {
+		t.Errorf("space usage was written within the interval")
+	}
+	ready := updated.DeepCopy()
+	ready.Status.State = ZFSStatusReady
+	if !c.ShouldUpdate(key, ready, quietVolume) {
+		t.Errorf("changed state was not written")
+	}
+	now = now.Add(time.Minute)
+	if !c.ShouldUpdate(key, usage, quietVolume) {
+		t.Errorf("space usage was not written after the interval")
+	}
+
+	// the object changed by someone else is always written
+	other := updated.DeepCopy()
+	other.ResourceVersion = "3"
+	if !c.ShouldUpdate(key, other, quietVolume) {
+		t.Errorf("update of another version was suppressed")
+	}
+
+	c.Forget(key)
+	if !c.ShouldUpdate(key, same, quietVolume) {
+		t.Errorf("update of a forgotten object was suppressed")
+	}
+}
+
+func TestSetStatusUpdateInterval(t *testing.T) {
+	defer func(c *StatusCoalescer) { StatusUpdates = c }(StatusUpdates)
+
+	for _, interval := range []time.Duration{0, 5 * time.Minute} {
+		if err := SetStatusUpdateInterval(interval); err != nil || StatusUpdates.interval != interval {
+			t.Errorf("SetStatusUpdateInterval(%v) = %v, interval %v", interval, err, StatusUpdates.interval)
+		}
+	}
+	if err := SetStatusUpdateInterval(-5 * time.Minute); err == nil {
+		t.Errorf("SetStatusUpdateInterval(-5m) expected an error")
+	}
+}
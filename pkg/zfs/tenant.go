@@ -0,0 +1,159 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/klog/v2"
+)
+
+// tenantDatasetRegex matches the tenant datasets, a child dataset of a
+// zpool, e.g. zfspv-pool/tenant-a
+var tenantDatasetRegex = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_.:-]*(/[A-Za-z0-9_.:-]+)+$`)
+
+// parseTenantUsage parses the quota, the used and the available space of
+// a dataset, one value per line, e.g.
+// 107374182400
+// 53687091200
+// 53687091200
+func parseTenantUsage(out []byte) (int64, int64, int64, error) {
+	fields := strings.Fields(string(out))
+	if len(fields) != 3 {
+		return 0, 0, 0, fmt.Errorf("zfs: invalid quota output %q", string(out))
+	}
+	var vals [3]int64
+	for i, f := range fields {
+		v, err := strconv.ParseInt(f, 10, 64)
+		if err != nil {
+			return 0, 0, 0, fmt.Errorf("zfs: invalid quota output %q", string(out))
+		}
+		vals[i] = v
+	}
+	return vals[0], vals[1], vals[2], nil
+}
+
+// getTenantUsage returns the quota, the used and the available space of
+// the dataset
+func getTenantUsage(dataset string) (int64, int64, int64, error) {
+	out, err := zfsCommand(ZFSGetArg, "-Hp", "-o", "value", "quota,used,available", dataset).CombinedOutput()
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("zfs get quota of %s failed, %s", dataset, strings.TrimSpace(string(out)))
+	}
+	return parseTenantUsage(out)
+}
+
+// runTenantCommand runs the zfs command changing the tenant dataset
+func runTenantCommand(args ...string) error {
+	out, err := zfsCommand(args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("zfs %s failed, %s", args[0], strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// applyTenantQuota creates the tenant dataset if it does not exist and
+// sets its quota if it has changed. The dataset is not mounted, its
+// volumes are mounted on their own.
+func applyTenantQuota(dataset string, quota int64) (int64, int64, error) {
+	q := strconv.FormatInt(quota, 10)
+	if !datasetExists(dataset) {
+		if err := runTenantCommand(ZFSCreateArg, "-p", "-o", "canmount=off", "-o", "mountpoint=none",
+			"-o", "quota="+q, dataset); err != nil {
+			return 0, 0, err
+		}
+		klog.Infof("zfs: created tenant dataset %s with the quota %s", dataset, q)
+	}
+	current, used, available, err := getTenantUsage(dataset)
+	if err != nil || current == quota {
+		return used, available, err
+	}
+	if err = runTenantCommand(ZFSSetArg, "quota="+q, dataset); err != nil {
+		return 0, 0, err
+	}
+	klog.Infof("zfs: set the quota of tenant dataset %s to %s", dataset, q)
+	_, used, available, err = getTenantUsage(dataset)
+	return used, available, err
+}
+
+// ApplyTenantQuotas keeps the quota of each tenant dataset of the node and
+// returns their usage, sorted by name. The tenants whose quota could not
+// be applied have the reason in their message.
+func ApplyTenantQuotas(quotas map[string]resource.Quantity, pools []apis.Pool) []apis.TenantUsage {
+	names := make([]string, 0, len(quotas))
+	for name := range quotas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	imported := map[string]bool{}
+	for _, p := range pools {
+		imported[p.Name] = true
+	}
+
+	var tenants []apis.TenantUsage
+	for _, name := range names {
+		quota := quotas[name]
+		t := apis.TenantUsage{Name: name, Quota: quota}
+		zpool := strings.SplitN(name, "/", 2)[0]
+		switch {
+		case !tenantDatasetRegex.MatchString(name):
+			t.Message = fmt.Sprintf("invalid tenant dataset %q, it should be a child dataset of a zpool", name)
+		case quota.Sign() <= 0:
+			t.Message = fmt.Sprintf("invalid quota %s, it should be positive", quota.String())
+		case !imported[zpool]:
+			t.Message = fmt.Sprintf("zpool %s is not imported on the node", zpool)
+		default:
+			used, available, err := applyTenantQuota(name, quota.Value())
+			if err != nil {
+				t.Message = err.Error()
+				break
+			}
+			t.Used = resource.NewQuantity(used, resource.BinarySI)
+			t.Available = resource.NewQuantity(available, resource.BinarySI)
+		}
+		if t.Message != "" {
+			klog.Errorf("zfs: could not apply the quota of tenant %s: %s", name, t.Message)
+		}
+		tenants = append(tenants, t)
+	}
+	return tenants
+}
+
+// TenantAvailable returns the space left to the volumes of the pool on the
+// node, which is the least available space of the tenants holding it. The
+// pool may be the tenant dataset or a child of it. It returns false if
+// the pool is not in a tenant or the node has not reported its usage.
+func TenantAvailable(node *apis.ZFSNode, pool string) (int64, bool) {
+	var avail int64
+	found := false
+	for _, t := range node.Status.Tenants {
+		if t.Available == nil || (pool != t.Name && !strings.HasPrefix(pool, t.Name+"/")) {
+			continue
+		}
+		if v := t.Available.Value(); !found || v < avail {
+			avail, found = v, true
+		}
+	}
+	return avail, found
+}
@@ -0,0 +1,147 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"github.com/openebs/zfs-localpv/pkg/zfs/zfstest"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// tenantZFS returns the fake zfs holding the datasets, each with its quota
+// and used space
+func tenantZFS(t *testing.T, datasets map[string][2]int64) *zfstest.FakeZFS {
+	f := newFakeZFS(t)
+	for ds, usage := range datasets {
+		f.Set(t, ds, "quota", strconv.FormatInt(usage[0], 10))
+		f.Set(t, ds, "used", strconv.FormatInt(usage[1], 10))
+	}
+	return f
+}
+
+func TestParseTenantUsage(t *testing.T) {
+	quota, used, avail, err := parseTenantUsage([]byte("107374182400\n53687091200\n1073741824\n"))
+	if err != nil || quota != 100<<30 || used != 50<<30 || avail != 1<<30 {
+		t.Errorf("parseTenantUsage() = %d, %d, %d, %v", quota, used, avail, err)
+	}
+	for _, out := range []string{"", "0\n1\n", "none\n0\n0\n"} {
+		if _, _, _, err := parseTenantUsage([]byte(out)); err == nil {
+			t.Errorf("parseTenantUsage(%q) did not fail", out)
+		}
+	}
+}
+
+func TestApplyTenantQuotas(t *testing.T) {
+	f := tenantZFS(t, map[string][2]int64{
+		"zfspv/tenant-b": {10 << 30, 4 << 30},
+		"zfspv/tenant-c": {20 << 30, 4 << 30},
+	})
+	quotas := map[string]resource.Quantity{
+		"zfspv/tenant-a": resource.MustParse("5Gi"),
+		"zfspv/tenant-b": resource.MustParse("10Gi"),
+		"zfspv/tenant-c": resource.MustParse("8Gi"),
+	}
+	pools := []apis.Pool{{Name: "zfspv"}}
+
+	tenants := ApplyTenantQuotas(quotas, pools)
+	want := []string{
+		"create -p -o canmount=off -o mountpoint=none -o quota=5368709120 zfspv/tenant-a",
+		"set quota=8589934592 zfspv/tenant-c",
+	}
+	if got := zfsWrites(f); !reflect.DeepEqual(got, want) {
+		t.Errorf("commands = %v, want %v", got, want)
+	}
+	if len(tenants) != 3 {
+		t.Fatalf("tenants = %+v", tenants)
+	}
+	for i, avail := range []int64{5 << 30, 6 << 30, 4 << 30} {
+		tu := tenants[i]
+		if tu.Message != "" || tu.Available == nil || tu.Available.Value() != avail {
+			t.Errorf("tenant %s = %+v, want %d available", tu.Name, tu, avail)
+		}
+	}
+
+	// nothing is changed once the quotas are applied
+	ApplyTenantQuotas(quotas, pools)
+	if got := zfsWrites(f); len(got) != len(want) {
+		t.Errorf("commands run again: %v", got[len(want):])
+	}
+}
+
+func TestApplyTenantQuotasFailed(t *testing.T) {
+	f := tenantZFS(t, map[string][2]int64{"zfspv/tenant-b": {10 << 30, 9 << 30}})
+	f.Fail(t, ZFSSetArg, "cannot set property for 'zfspv/tenant-b': size is less than current used or reserved space")
+	quotas := map[string]resource.Quantity{
+		"zfspv":          resource.MustParse("5Gi"),
+		"zfspv/tenant-a": resource.MustParse("0"),
+		"zfspv/tenant-b": resource.MustParse("8Gi"),
+		"other/tenant":   resource.MustParse("5Gi"),
+	}
+
+	tenants := ApplyTenantQuotas(quotas, []apis.Pool{{Name: "zfspv"}})
+	want := map[string]string{
+		"other/tenant":   "zpool other is not imported on the node",
+		"zfspv":          "it should be a child dataset of a zpool",
+		"zfspv/tenant-a": "invalid quota 0",
+		"zfspv/tenant-b": "size is less than current used",
+	}
+	if len(tenants) != len(want) {
+		t.Fatalf("tenants = %+v", tenants)
+	}
+	for _, tu := range tenants {
+		if !strings.Contains(tu.Message, want[tu.Name]) || tu.Used != nil || tu.Available != nil {
+			t.Errorf("tenant %s = %+v, want the message %q", tu.Name, tu, want[tu.Name])
+		}
+	}
+	if got := zfsWrites(f); len(got) != 1 {
+		t.Errorf("commands = %v, want only the quota of the valid tenant", got)
+	}
+}
+
+func TestTenantAvailable(t *testing.T) {
+	avail := func(q string) *resource.Quantity {
+		v := resource.MustParse(q)
+		return &v
+	}
+	node := &apis.ZFSNode{}
+	node.Status.Tenants = []apis.TenantUsage{
+		{Name: "zfspv/tenant-a", Available: avail("10Gi")},
+		{Name: "zfspv/tenant-a/team-1", Available: avail("2Gi")},
+		{Name: "zfspv/tenant-b", Message: "zpool zfspv is not imported on the node"},
+	}
+
+	for pool, want := range map[string]int64{
+		"zfspv/tenant-a":            10 << 30,
+		"zfspv/tenant-a/k8s":        10 << 30,
+		"zfspv/tenant-a/team-1":     2 << 30,
+		"zfspv/tenant-a/team-1/k8s": 2 << 30,
+	} {
+		if got, ok := TenantAvailable(node, pool); !ok || got != want {
+			t.Errorf("TenantAvailable(%s) = %d, %v, want %d", pool, got, ok, want)
+		}
+	}
+	for _, pool := range []string{"zfspv", "zfspv/tenant-ab", "zfspv/tenant-b", "other/tenant-a"} {
+		if got, ok := TenantAvailable(node, pool); ok {
+			t.Errorf("TenantAvailable(%s) = %d, want none", pool, got)
+		}
+	}
+}
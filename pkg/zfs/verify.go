@@ -0,0 +1,134 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// ConditionSourceVerified is the condition type of a clone whose origin
+// snapshot has been verified before the clone was created
+const ConditionSourceVerified = "SourceVerified"
+
+// readSnapshot reads all the blocks of the snapshot, zfs checks their
+// checksums while reading them. Only the snapshot is read, which is much
+// cheaper than a scrub of the whole pool. The blocks are sent as they are
+// stored on the disk (-Lec), so they are not even decompressed. It can be
+// replaced in unit tests.
+var readSnapshot = func(ctx context.Context, snapshot string) error {
+	var stderr bytes.Buffer
+	cmd := zfsCommandContext(ctx, ZFSSendArg, "-Lec", snapshot)
+	cmd.Stdout = io.Discard
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%v %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// VerifySnapshot reads the whole snapshot, it returns the result of the
+// verification along with an error if the snapshot could not be read. The
+// verification takes a send slot, it reads as much as a send does.
+func VerifySnapshot(ctx context.Context, snapshot string) (*apis.SnapshotVerification, error) {
+	release, err := acquireSendSlot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	klog.Infof("zfs: verifying snapshot %s", snapshot)
+	ver := &apis.SnapshotVerification{
+		Snapshot: snapshot,
+		Result:   apis.SnapshotVerificationPassed,
+	}
+	err = readSnapshot(ctx, snapshot)
+	ver.Time = metav1.NewTime(time.Now())
+	if err != nil {
+		ver.Result = apis.SnapshotVerificationFailed
+		ver.Message = err.Error()
+		klog.Errorf("zfs: verification of snapshot %s failed: %v", snapshot, err)
+		return ver, fmt.Errorf("zfs: snapshot %s is corrupted, %s", snapshot, ver.Message)
+	}
+	return ver, nil
+}
+
+// verifyCloneSource verifies the origin snapshot of the clone if it asks
+// for it, the result is recorded in the status of the clone
+func verifyCloneSource(vol *apis.ZFSVolume, snapshot string) error {
+	if vol.Spec.VerifySource != "yes" {
+		return nil
+	}
+	ver, err := VerifySnapshot(context.Background(), snapshot)
+	if ver == nil {
+		return err
+	}
+	vol.Status.SourceVerification = ver
+	cond := metav1.Condition{
+		Type:    ConditionSourceVerified,
+		Status:  metav1.ConditionTrue,
+		Reason:  string(ver.Result),
+		Message: fmt.Sprintf("snapshot %s has been read without error", snapshot),
+	}
+	if err != nil {
+		cond.Status = metav1.ConditionFalse
+		cond.Message = err.Error()
+	}
+	meta.SetStatusCondition(&vol.Status.Conditions, cond)
+	return err
+}
+
+// recordBkpVerification records the verification of the snapshot in the
+// backup, can be replaced in unit tests
+var recordBkpVerification = UpdateBkpVerification
+
+// verifyBackupSnapshot verifies the snapshot of the backup before it is
+// sent if the backup asks for it
+func verifyBackupSnapshot(ctx context.Context, bkp *apis.ZFSBackup, snapshot string) error {
+	if !bkp.Spec.VerifySnapshot {
+		return nil
+	}
+	ver, err := VerifySnapshot(ctx, snapshot)
+	if ctx.Err() != nil {
+		return fmt.Errorf("zfs: backup %s verifying %s: %w", bkp.Name, snapshot, ErrBackupCancelled)
+	}
+	if ver != nil {
+		if uerr := recordBkpVerification(bkp, ver); uerr != nil {
+			klog.Warningf("backup %s: could not record the verification %v", bkp.Name, uerr)
+		}
+	}
+	return err
+}
+
+// SourceVerificationError returns the error of the verification of the
+// origin snapshot, nil if it has not failed
+func SourceVerificationError(vol *apis.ZFSVolume) error {
+	cond := meta.FindStatusCondition(vol.Status.Conditions, ConditionSourceVerified)
+	if cond == nil || cond.Status != metav1.ConditionFalse {
+		return nil
+	}
+	return fmt.Errorf("%s", cond.Message)
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// fakeRead makes the snapshots of corrupted fail the read
+func fakeRead(t *testing.T, corrupted ...string) *[]string {
+	orig := readSnapshot
+	t.Cleanup(func() { readSnapshot = orig })
+	var read []string
+	readSnapshot = func(ctx context.Context, snapshot string) error {
+		read = append(read, snapshot)
+		for _, c := range corrupted {
+			if c == snapshot {
+				return errors.New("exit status 1 warning: cannot send 'zfspv/pvc-1@snap-1': Input/output error")
+			}
+		}
+		return nil
+	}
+	return &read
+}
+
+func TestVerifySnapshot(t *testing.T) {
+	fakeRead(t, "zfspv/pvc-1@bad")
+
+	ver, err := VerifySnapshot(context.Background(), "zfspv/pvc-1@good")
+	if err != nil || ver.Result != apis.SnapshotVerificationPassed || ver.Snapshot != "zfspv/pvc-1@good" || ver.Time.IsZero() {
+		t.Errorf("VerifySnapshot() of a good snapshot = %+v, %v", ver, err)
+	}
+
+	ver, err = VerifySnapshot(context.Background(), "zfspv/pvc-1@bad")
+	if err == nil || ver.Result != apis.SnapshotVerificationFailed || !strings.Contains(ver.Message, "Input/output error") {
+		t.Errorf("VerifySnapshot() of a corrupted snapshot = %+v, %v", ver, err)
+	}
+}
+
+func TestVerifyCloneSource(t *testing.T) {
+	read := fakeRead(t, "zfspv/pvc-1@bad")
+	vol := &apis.ZFSVolume{}
+
+	if err := verifyCloneSource(vol, "zfspv/pvc-1@good"); err != nil || len(*read) != 0 {
+		t.Fatalf("verifyCloneSource() without VerifySource = %v, read %v", err, *read)
+	}
+
+	vol.Spec.VerifySource = "yes"
+	if err := verifyCloneSource(vol, "zfspv/pvc-1@good"); err != nil {
+		t.Fatalf("verifyCloneSource() of a good snapshot = %v", err)
+	}
+	if vol.Status.SourceVerification.Result != apis.SnapshotVerificationPassed ||
+		!meta.IsStatusConditionTrue(vol.Status.Conditions, ConditionSourceVerified) {
+		t.Errorf("status after a passed verification = %+v", vol.Status)
+	}
+	if err := SourceVerificationError(vol); err != nil {
+		t.Errorf("SourceVerificationError() after a passed verification = %v", err)
+	}
+
+	err := verifyCloneSource(vol, "zfspv/pvc-1@bad")
+	if err == nil {
+		t.Fatal("verifyCloneSource() of a corrupted snapshot did not fail")
+	}
+	if vol.Status.SourceVerification.Result != apis.SnapshotVerificationFailed {
+		t.Errorf("verification result = %q, want Failed", vol.Status.SourceVerification.Result)
+	}
+	cond := meta.FindStatusCondition(vol.Status.Conditions, ConditionSourceVerified)
+	if cond.Status != metav1.ConditionFalse || cond.Reason != "Failed" {
+		t.Errorf("condition after a failed verification = %+v", cond)
+	}
+	if verr := SourceVerificationError(vol); verr == nil || verr.Error() != err.Error() {
+		t.Errorf("SourceVerificationError() = %v, want %v", verr, err)
+	}
+}
+
+func TestVerifyBackupSnapshot(t *testing.T) {
+	read := fakeRead(t, "zfspv/pvc-1@bad")
+	orig := recordBkpVerification
+	t.Cleanup(func() { recordBkpVerification = orig })
+	var recorded []*apis.SnapshotVerification
+	recordBkpVerification = func(bkp *apis.ZFSBackup, ver *apis.SnapshotVerification) error {
+		recorded = append(recorded, ver)
+		return nil
+	}
+
+	bkp, _ := backupFixture()
+	if err := verifyBackupSnapshot(context.Background(), bkp, "zfspv/pvc-1@bad"); err != nil || len(*read) != 0 {
+		t.Fatalf("verifyBackupSnapshot() without VerifySnapshot = %v, read %v", err, *read)
+	}
+
+	bkp.Spec.VerifySnapshot = true
+	if err := verifyBackupSnapshot(context.Background(), bkp, "zfspv/pvc-1@good"); err != nil {
+		t.Errorf("verifyBackupSnapshot() of a good snapshot = %v", err)
+	}
+	if err := verifyBackupSnapshot(context.Background(), bkp, "zfspv/pvc-1@bad"); err == nil {
+		t.Error("verifyBackupSnapshot() of a corrupted snapshot did not fail")
+	}
+	if len(recorded) != 2 || recorded[0].Result != apis.SnapshotVerificationPassed ||
+		recorded[1].Result != apis.SnapshotVerificationFailed {
+		t.Errorf("recorded %+v, want a passed then a failed verification", recorded)
+	}
+}
+
+func TestVerifyBackupSnapshotCancelled(t *testing.T) {
+	withMaxSends(t, 1)
+	read := fakeRead(t)
+	release, _ := acquireSendSlot(context.Background())
+	defer release()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	bkp, _ := backupFixture()
+	bkp.Spec.VerifySnapshot = true
+	if err := verifyBackupSnapshot(ctx, bkp, "zfspv/pvc-1@snap2"); !errors.Is(err, ErrBackupCancelled) {
+		t.Errorf("verifyBackupSnapshot() waiting for a send slot = %v, want ErrBackupCancelled", err)
+	}
+	if len(*read) != 0 {
+		t.Errorf("snapshot read %v while the backup was cancelled", *read)
+	}
+}
@@ -42,16 +42,21 @@ const (
 	ZFSFinalizer string = "zfs.openebs.io/finalizer"
 	// ZFSVolKey for the ZfsSnapshot CR to store Persistence Volume name
 	ZFSVolKey string = "openebs.io/persistent-volume"
+	// ZFSVolNamespaceKey for the ZfsSnapshot CR to store the namespace of
+	// the volume when the volume can not be its owner
+	ZFSVolNamespaceKey string = "openebs.io/persistent-volume-namespace"
+	// VolumeSnapshotKey for the ZfsSnapshot CR to store the name of its
+	// VolumeSnapshot
+	VolumeSnapshotKey string = "openebs.io/volumesnapshot"
+	// VolumeSnapshotNamespaceKey for the ZfsSnapshot CR to store the
+	// namespace of its VolumeSnapshot
+	VolumeSnapshotNamespaceKey string = "openebs.io/volumesnapshot-namespace"
 	// ZFSSrcVolKey key for the source Volume name
 	ZFSSrcVolKey string = "openebs.io/source-volume"
 	// PoolNameKey is key for ZFS pool name
 	PoolNameKey string = "openebs.io/poolname"
 	// ZFSNodeKey will be used to insert Label in ZfsVolume CR
 	ZFSNodeKey string = "kubernetes.io/nodename"
-	// ZFSTopologyKey is supported topology key for the zfs driver
-	ZFSTopologyKey string = "openebs.io/nodeid"
-	// ZFSTopoNodenameKey is supported topology key for the zfs driver
-	ZFSTopoNodenameKey string = "openebs.io/nodename"
 	// ZFSStatusPending shows object has not handled yet
 	ZFSStatusPending string = "Pending"
 	// ZFSStatusFailed shows object operation has failed
@@ -71,6 +76,13 @@ var (
 	// NodeID is the NodeID of the node on which the pod is present
 	NodeID string
 
+	// ZFSTopologyKey is supported topology key for the zfs driver, it is
+	// set by SetDriverName
+	ZFSTopologyKey = DefaultTopologyKey
+	// ZFSTopoNodenameKey is supported topology key for the zfs driver, it
+	// is set by SetDriverName
+	ZFSTopoNodenameKey = DefaultTopoNodenameKey
+
 	// GoogleAnalyticsEnabled should send google analytics or not
 	GoogleAnalyticsEnabled string
 )
@@ -106,13 +118,7 @@ func GetNodeID(nodename string) (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("failed to get the node %s: %w", nodename, err)
 	}
-
-	nodeid, ok := node.Labels[ZFSTopologyKey]
-	if !ok {
-		// node is not labelled, use node name as nodeid
-		return nodename, nil
-	}
-	return nodeid, nil
+	return nodeID(node), nil
 }
 
 func checkVolCreation(ctx context.Context, volname string) (bool, error) {
@@ -133,6 +139,9 @@ func checkVolCreation(ctx context.Context, volname string) (bool, error) {
 			case ZFSStatusReady:
 				return false, nil
 			case ZFSStatusFailed:
+				if err := SourceVerificationError(vol); err != nil {
+					return false, fmt.Errorf("zfs: volume creation failed: %v", err)
+				}
 				return false, fmt.Errorf("zfs: volume creation failed")
 			}
 
@@ -183,6 +192,57 @@ func ResizeVolume(vol *apis.ZFSVolume, newSize int64) error {
 	return err
 }
 
+// volStatusKey is the key of the volume for the status coalescer
+func volStatusKey(name string) string {
+	return "zfsvolume/" + name
+}
+
+// quietVolume clears the noisy fields of the volume, whose writes are
+// debounced
+func quietVolume(obj StatusObject) {
+	obj.(*apis.ZFSVolume).Status.SpaceUsage = nil
+}
+
+// writeVolume updates the ZFSVolume unless the update would not change it,
+// a change of its space usage only is written at most once per interval
+func writeVolume(vol *apis.ZFSVolume) error {
+	key := volStatusKey(vol.Name)
+	vol = apiVolume(vol)
+	if !StatusUpdates.ShouldUpdate(key, vol, quietVolume) {
+		return nil
+	}
+	newVol, err := volbuilder.NewKubeclient().WithNamespace(OpenEBSNamespace).Update(vol)
+	if err == nil {
+		StatusUpdates.Updated(key, newVol)
+	}
+	return err
+}
+
+// writeSnapshot updates the ZFSSnapshot unless the update would not
+// change it
+func writeSnapshot(snap *apis.ZFSSnapshot) error {
+	key := snapStatusKey(snap.Name)
+	snap = apiSnapshot(snap)
+	if !StatusUpdates.ShouldUpdate(key, snap, nil) {
+		return nil
+	}
+	newSnap, err := snapbuilder.NewKubeclient().WithNamespace(OpenEBSNamespace).Update(snap)
+	if err == nil {
+		StatusUpdates.Updated(key, newSnap)
+	}
+	return err
+}
+
+// UpdateVolumeStatus updates the ZFSVolume with its status
+func UpdateVolumeStatus(vol *apis.ZFSVolume) error {
+	return writeVolume(vol)
+}
+
+// UpdateSnapStatus updates the ZFSSnapshot with its status
+func UpdateSnapStatus(snap *apis.ZFSSnapshot) error {
+	return writeSnapshot(snap)
+}
+
 // ProvisionSnapshot creates a ZFSSnapshot CR,
 // watcher for zvc is present in CSI agent
 func ProvisionSnapshot(
@@ -226,6 +286,29 @@ func DeleteVolume(volumeID string) (err error) {
 	return
 }
 
+// RetiredVolume returns the ZFSVolume which destroys the dataset of the
+// volume on the given node, once the volume has been moved away from the
+// node or its copy on the node is dropped. It is created and deleted right
+// away so that the node agent destroys the dataset, the volume itself is
+// left alone.
+func RetiredVolume(vol *apis.ZFSVolume, node, name string) *apis.ZFSVolume {
+	retired := &apis.ZFSVolume{}
+	retired.Name = name
+	retired.Namespace = OpenEBSNamespace
+	retired.Labels = map[string]string{ZFSNodeKey: node}
+	retired.Finalizers = []string{ZFSFinalizer}
+	retired.Spec = vol.Spec
+	retired.Spec.OwnerNodeID = node
+	retired.Spec.DatasetName = datasetName(&vol.Spec, vol.Name)
+	// the dataset is destroyed as it is, the volume lives on elsewhere
+	retired.Spec.ReclaimPolicy = ""
+	retired.Spec.SnapshotPolicy = ""
+	// the key of the volume is not deleted along with the dataset
+	retired.Spec.KeySecret = ""
+	retired.Status.State = ZFSStatusReady
+	return retired
+}
+
 // GetVolList fetches the current Published Volume list
 func GetVolList(volumeID string) (*apis.ZFSVolumeList, error) {
 	listOptions := metav1.ListOptions{
@@ -279,15 +362,18 @@ func UpdateZvolInfo(vol *apis.ZFSVolume, status string) error {
 		return err
 	}
 
-	_, err = volbuilder.NewKubeclient().WithNamespace(OpenEBSNamespace).Update(newVol)
-	return err
+	return writeVolume(newVol)
 }
 
 // RemoveVolumeFinalizer removes finalizer from ZFSVolume CR
 func RemoveVolumeFinalizer(vol *apis.ZFSVolume) error {
 	vol.Finalizers = nil
 
-	_, err := volbuilder.NewKubeclient().WithNamespace(OpenEBSNamespace).Update(vol)
+	_, err := volbuilder.NewKubeclient().WithNamespace(OpenEBSNamespace).Update(apiVolume(vol))
+	if err == nil {
+		resolvedVolumePools.Delete(vol.Name)
+		StatusUpdates.Forget(volStatusKey(vol.Name))
+	}
 	return err
 }
 
@@ -299,6 +385,14 @@ func GetZFSSnapshot(snapID string) (*apis.ZFSSnapshot, error) {
 	return snap, err
 }
 
+// GetZFSBackup fetches the given ZFSBackup
+func GetZFSBackup(name string) (*apis.ZFSBackup, error) {
+	getOptions := metav1.GetOptions{}
+	bkp, err := bkpbuilder.NewKubeclient().
+		WithNamespace(OpenEBSNamespace).Get(name, getOptions)
+	return bkp, err
+}
+
 // GetZFSSnapshotStatus returns ZFSSnapshot status
 func GetZFSSnapshotStatus(snapID string) (string, error) {
 	getOptions := metav1.GetOptions{}
@@ -340,15 +434,22 @@ func UpdateSnapInfo(snap *apis.ZFSSnapshot) error {
 		WithFinalizer(finalizers).
 		WithLabels(labels).Build()
 
-	// set the status to ready
-	newSnap.Status.State = ZFSStatusReady
-
 	if err != nil {
 		klog.Errorf("Update snapshot failed %s err: %s", snap.Name, err.Error())
 		return err
 	}
 
-	_, err = snapbuilder.NewKubeclient().WithNamespace(OpenEBSNamespace).Update(newSnap)
+	// set the status to ready along with the actual creation time and
+	// size of the zfs snapshot
+	newSnap.Status.State = ZFSStatusReady
+	if creation, size, err := GetSnapshotInfo(newSnap); err != nil {
+		klog.Errorf("zfs: snapshot %s is ready, but its properties are not known: %v", snap.Name, err)
+	} else {
+		setSnapshotInfo(newSnap, creation, size)
+	}
+	newSnap.Status.Properties = newSnap.Spec.SnapshotProperties
+
+	_, err = snapbuilder.NewKubeclient().WithNamespace(OpenEBSNamespace).Update(apiSnapshot(newSnap))
 	return err
 }
 
@@ -356,7 +457,11 @@ func UpdateSnapInfo(snap *apis.ZFSSnapshot) error {
 func RemoveSnapFinalizer(snap *apis.ZFSSnapshot) error {
 	snap.Finalizers = nil
 
-	_, err := snapbuilder.NewKubeclient().WithNamespace(OpenEBSNamespace).Update(snap)
+	_, err := snapbuilder.NewKubeclient().WithNamespace(OpenEBSNamespace).Update(apiSnapshot(snap))
+	if err == nil {
+		resolvedSnapshotPools.Delete(snap.Name)
+		StatusUpdates.Forget(snapStatusKey(snap.Name))
+	}
 	return err
 }
 
@@ -385,6 +490,41 @@ func UpdateBkpInfo(bkp *apis.ZFSBackup, status apis.ZFSBackupStatus) error {
 	return err
 }
 
+// UpdateBkpProgress records the progress of the running backup, bkp is
+// refreshed with the updated object so that the final status update does
+// not conflict with it
+func UpdateBkpProgress(bkp *apis.ZFSBackup, progress apis.ZFSBackupProgress) error {
+	latest, err := GetZFSBackup(bkp.Name)
+	if err != nil {
+		return err
+	}
+	latest.Progress = progress
+
+	latest, err = bkpbuilder.NewKubeclient().WithNamespace(OpenEBSNamespace).Update(latest)
+	if err != nil {
+		return err
+	}
+	*bkp = *latest
+	return nil
+}
+
+// UpdateBkpVerification records the verification of the snapshot of the
+// backup
+func UpdateBkpVerification(bkp *apis.ZFSBackup, ver *apis.SnapshotVerification) error {
+	latest, err := GetZFSBackup(bkp.Name)
+	if err != nil {
+		return err
+	}
+	latest.Verification = ver
+
+	latest, err = bkpbuilder.NewKubeclient().WithNamespace(OpenEBSNamespace).Update(latest)
+	if err != nil {
+		return err
+	}
+	*bkp = *latest
+	return nil
+}
+
 // UpdateRestoreInfo updates the rstr info with the status
 func UpdateRestoreInfo(rstr *apis.ZFSRestore, status apis.ZFSRestoreStatus) error {
 	newRstr, err := restorebuilder.BuildFrom(rstr).Build()
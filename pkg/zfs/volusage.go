@@ -0,0 +1,160 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+)
+
+// VolumeUsage is the space used by a volume against its size
+type VolumeUsage struct {
+	// Pool is the pool of the volume
+	Pool string
+	// Used is the space used in bytes and Size the space the volume can
+	// use before it is full, its volsize, refquota or quota. Size is 0
+	// for a volume without a size.
+	Used, Size int64
+	// UsedBy is the breakdown of the space used by the volume
+	UsedBy apis.VolumeSpaceUsage
+	// Ready tells whether the volume is ready, its breakdown is then
+	// reported in its status, and Reported is the breakdown in the status
+	Ready    bool
+	Reported *apis.VolumeSpaceUsage
+}
+
+// UsedPercent returns the percent of the size of the volume used, it is 0
+// for a volume without a size
+func (u VolumeUsage) UsedPercent() float64 {
+	if u.Size <= 0 {
+		return 0
+	}
+	return float64(u.Used) * 100 / float64(u.Size)
+}
+
+// datasetSpace is the space of a dataset or a zvol as listed by zfs, the
+// properties not set or not relevant to its type are 0
+type datasetSpace struct {
+	used, referenced, quota, refquota, volsize int64
+	usedBy                                     apis.VolumeSpaceUsage
+}
+
+// usage returns the space used by the volume against its size. A zvol is
+// full once its volsize is referenced, whether it is thin or thick, as its
+// refreservation is not part of the referenced space. A dataset is full
+// once its refquota is referenced or its quota, which also counts its
+// snapshots, is used. A dataset without a quota has no size.
+func (s datasetSpace) usage() (used, size int64) {
+	switch {
+	case s.volsize > 0:
+		return s.referenced, s.volsize
+	case s.refquota > 0:
+		return s.referenced, s.refquota
+	default:
+		return s.used, s.quota
+	}
+}
+
+// listDatasetSpace runs `zfs list` for the space of all the datasets and
+// zvols along with its breakdown, can be replaced in unit tests
+var listDatasetSpace = func(ctx context.Context) ([]byte, error) {
+	return zfsCommandContext(ctx, ZFSListArg, "-H", "-p", "-t", "filesystem,volume",
+		"-o", "name,used,referenced,quota,refquota,volsize,"+strings.Join(usedByProperties, ",")).CombinedOutput()
+}
+
+// parseDatasetSpace parses the output of `zfs list -H -p -o
+// name,used,referenced,quota,refquota,volsize,usedbysnapshots,usedbydataset,usedbychildren,usedbyrefreservation`,
+// e.g.
+// zfspv-pool/pvc-1	1048576	1048576	4294967296	0	-	0	1048576	0	0
+// zfspv-pool/pvc-2	4362076160	65536	-	-	4294967296	0	65536	0	4296010000
+// The properties which do not apply to the type are printed as "-".
+func parseDatasetSpace(raw []byte) (map[string]datasetSpace, error) {
+	space := map[string]datasetSpace{}
+	scanner := bufio.NewScanner(strings.NewReader(string(raw)))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		items := strings.Split(line, "\t")
+		if len(items) != 6+len(usedByProperties) {
+			return nil, fmt.Errorf("zfs: invalid zfs list output %q", line)
+		}
+		var s datasetSpace
+		for i, v := range []*int64{&s.used, &s.referenced, &s.quota, &s.refquota, &s.volsize} {
+			if items[i+1] == "-" {
+				continue
+			}
+			bytes, err := strconv.ParseInt(items[i+1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("zfs: invalid space %q of %s: %v", items[i+1], items[0], err)
+			}
+			*v = bytes
+		}
+		for i, name := range usedByProperties {
+			if err := setUsedBy(&s.usedBy, name, items[6+i]); err != nil {
+				return nil, fmt.Errorf("zfs: invalid space of %s: %v", items[0], err)
+			}
+		}
+		space[items[0]] = s
+	}
+	return space, scanner.Err()
+}
+
+// ListVolumeUsage returns the space used by each volume of the node
+// against its size along with its breakdown, the volumes whose dataset is
+// missing are left out. All the datasets are listed with a single `zfs
+// list` call.
+func ListVolumeUsage() (map[string]VolumeUsage, error) {
+	vols, err := GetVolList("")
+	if err != nil {
+		return nil, fmt.Errorf("zfs: could not list the volumes: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), poolSummaryTimeout)
+	defer cancel()
+	out, err := listDatasetSpace(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("zfs: could not list the space of the volumes: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	space, err := parseDatasetSpace(out)
+	if err != nil {
+		return nil, err
+	}
+	return volumeUsage(vols.Items, space), nil
+}
+
+// volumeUsage returns the usage of each volume from the space of the
+// datasets
+func volumeUsage(vols []apis.ZFSVolume, space map[string]datasetSpace) map[string]VolumeUsage {
+	usage := map[string]VolumeUsage{}
+	for i := range vols {
+		s, ok := space[VolumeDataset(&vols[i])]
+		if !ok {
+			continue
+		}
+		used, size := s.usage()
+		usage[vols[i].Name] = VolumeUsage{Pool: vols[i].Spec.PoolName, Used: used, Size: size, UsedBy: s.usedBy,
+			Ready: IsVolumeReady(&vols[i]), Reported: vols[i].Status.SpaceUsage}
+	}
+	return usage
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"reflect"
+	"testing"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+)
+
+const datasetSpaceOutput = `zfspv-pool/thin-quota	1073741824	1073741824	4294967296	0	-	0	1073741824	0	0
+zfspv-pool/thin-refquota	3221225472	1073741824	0	4294967296	-	2147483648	1073741824	0	0
+zfspv-pool/no-quota	1073741824	1073741824	0	0	-	0	1073741824	0	0
+zfspv-pool/thin-zvol	2147483648	2147483648	-	-	4294967296	0	2147483648	0	0
+zfspv-pool/thick-zvol	4362076160	1073741824	-	-	4294967296	0	1073741824	0	3288334336
+zfspv-pool	12884901888	98304	0	0	-	0	98304	12884803584	0
+`
+
+func TestParseDatasetSpace(t *testing.T) {
+	space, err := parseDatasetSpace([]byte(datasetSpaceOutput))
+	if err != nil {
+		t.Fatalf("parseDatasetSpace() failed: %v", err)
+	}
+	thick := datasetSpace{
+		used:       4362076160,
+		referenced: 1073741824,
+		volsize:    4294967296,
+		usedBy:     apis.VolumeSpaceUsage{UsedByDataset: 1073741824, UsedByRefreservation: 3288334336},
+	}
+	if got, want := space["zfspv-pool/thick-zvol"], thick; got != want {
+		t.Errorf("parseDatasetSpace() thick-zvol = %+v, want %+v", got, want)
+	}
+	if len(space) != 6 {
+		t.Errorf("parseDatasetSpace() returned %d datasets, want 6", len(space))
+	}
+
+	for _, raw := range []string{
+		"zfspv-pool/pvc-1\t1024\n",
+		"zfspv-pool/pvc-1\tx\t0\t0\t0\t-\t0\t0\t0\t0\n",
+		"zfspv-pool/pvc-1\t0\t0\t0\t0\t-\t0\tx\t0\t0\n",
+	} {
+		if _, err := parseDatasetSpace([]byte(raw)); err == nil {
+			t.Errorf("parseDatasetSpace(%q) succeeded", raw)
+		}
+	}
+}
+
+func usageVolume(name string) apis.ZFSVolume {
+	vol := apis.ZFSVolume{}
+	vol.Name = name
+	vol.Spec.PoolName = "zfspv-pool"
+	return vol
+}
+
+func TestVolumeUsage(t *testing.T) {
+	space, err := parseDatasetSpace([]byte(datasetSpaceOutput))
+	if err != nil {
+		t.Fatalf("parseDatasetSpace() failed: %v", err)
+	}
+	var vols []apis.ZFSVolume
+	for _, name := range []string{"thin-quota", "thin-refquota", "no-quota", "thin-zvol", "thick-zvol", "missing"} {
+		vols = append(vols, usageVolume(name))
+	}
+	// the breakdown of a ready volume is reported in its status
+	vols[4].Status.State = ZFSStatusReady
+
+	got := volumeUsage(vols, space)
+	want := map[string]VolumeUsage{
+		// the quota counts the snapshots, the refquota does not
+		"thin-quota": {Pool: "zfspv-pool", Used: 1073741824, Size: 4294967296,
+			UsedBy: apis.VolumeSpaceUsage{UsedByDataset: 1073741824}},
+		"thin-refquota": {Pool: "zfspv-pool", Used: 1073741824, Size: 4294967296,
+			UsedBy: apis.VolumeSpaceUsage{UsedBySnapshots: 2147483648, UsedByDataset: 1073741824}},
+		// a volume without a size only has its breakdown
+		"no-quota": {Pool: "zfspv-pool", Used: 1073741824,
+			UsedBy: apis.VolumeSpaceUsage{UsedByDataset: 1073741824}},
+		// the refreservation of a thick zvol is not used by its data
+		"thin-zvol": {Pool: "zfspv-pool", Used: 2147483648, Size: 4294967296,
+			UsedBy: apis.VolumeSpaceUsage{UsedByDataset: 2147483648}},
+		"thick-zvol": {Pool: "zfspv-pool", Used: 1073741824, Size: 4294967296,
+			UsedBy: apis.VolumeSpaceUsage{UsedByDataset: 1073741824, UsedByRefreservation: 3288334336}, Ready: true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("volumeUsage() = %+v, want %+v", got, want)
+	}
+}
+
+func TestUsedPercent(t *testing.T) {
+	tests := []struct {
+		usage VolumeUsage
+		want  float64
+	}{
+		{VolumeUsage{Used: 1 << 30, Size: 4 << 30}, 25},
+		{VolumeUsage{Used: 4 << 30, Size: 4 << 30}, 100},
+		// a dataset over its quota by the metadata
+		{VolumeUsage{Used: 5 << 30, Size: 4 << 30}, 125},
+		{VolumeUsage{Used: 1 << 30}, 0},
+	}
+	for _, tt := range tests {
+		if got := tt.usage.UsedPercent(); got != tt.want {
+			t.Errorf("UsedPercent(%+v) = %v, want %v", tt.usage, got, tt.want)
+		}
+	}
+}
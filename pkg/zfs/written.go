@@ -0,0 +1,107 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"github.com/openebs/zfs-localpv/pkg/builder/snapbuilder"
+)
+
+// SnapshotWritten is the data written to the volume in the interval
+// ending with the snapshot
+type SnapshotWritten struct {
+	// Name of the snapshot
+	Name string
+	// Predecessor is the previous snapshot, empty for the first one
+	Predecessor string
+	// Written is the bytes written since the predecessor
+	Written int64
+}
+
+// parseWrittenOutput parses the `zfs list -t snapshot -o name,written`
+// output sorted by createtxg. The written property of a snapshot is the
+// data written since the previous snapshot, or since the creation of the
+// dataset for the first snapshot.
+func parseWrittenOutput(out []byte) ([]SnapshotWritten, error) {
+	var snaps []SnapshotWritten
+	prev := ""
+	scanner := bufio.NewScanner(strings.NewReader(string(out)))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("zfs: invalid written output %q", line)
+		}
+		parts := strings.SplitN(fields[0], "@", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("zfs: %s is not a snapshot", fields[0])
+		}
+		written, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("zfs: invalid written value %q for %s", fields[1], fields[0])
+		}
+		snaps = append(snaps, SnapshotWritten{Name: parts[1], Predecessor: prev, Written: written})
+		prev = parts[1]
+	}
+	return snaps, nil
+}
+
+// GetSnapshotsWritten returns the data written in between the snapshots of
+// the volume of the snapshot, ordered from the oldest snapshot
+func GetSnapshotsWritten(snap *apis.ZFSSnapshot) ([]SnapshotWritten, error) {
+	dataset := snapshotVolumeDataset(snap)
+	args := []string{ZFSListArg, "-Hp", "-t", "snapshot", "-o", "name,written", "-s", "createtxg", "-d", "1", dataset}
+	out, err := zfsCommand(args...).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("zfs: could not get written of the snapshots of %s: %s", dataset, string(out))
+	}
+	return parseWrittenOutput(out)
+}
+
+// snapStatusKey is the key of the snapshot for the status coalescer
+func snapStatusKey(name string) string {
+	return "zfssnapshot/" + name
+}
+
+// UpdateSnapWritten updates the written status of the snapshot. The
+// written data keeps growing, so the writes are debounced unless the
+// predecessor of the snapshot has changed.
+func UpdateSnapWritten(snap *apis.ZFSSnapshot, w SnapshotWritten) error {
+	newSnap := snap.DeepCopy()
+	newSnap.Status.Written = strconv.FormatInt(w.Written, 10)
+	newSnap.Status.Predecessor = w.Predecessor
+
+	key := snapStatusKey(snap.Name)
+	critical := snap.Status.Predecessor != w.Predecessor || snap.Status.Written == ""
+	if !StatusUpdates.ShouldWrite(key, snap.Status, newSnap.Status, critical) {
+		return nil
+	}
+
+	newSnap, err := snapbuilder.NewKubeclient().WithNamespace(OpenEBSNamespace).Update(apiSnapshot(newSnap))
+	if err == nil {
+		StatusUpdates.Updated(key, newSnap)
+	}
+	return err
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+)
+
+func TestParseWrittenOutput(t *testing.T) {
+	out := []byte("zfspv/pvc-1@snapshot-a\t1048576\n" +
+		"zfspv/pvc-1@snapshot-b\t0\n" +
+		"zfspv/pvc-1@snapshot-c\t4096\n")
+
+	got, err := parseWrittenOutput(out)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	want := []SnapshotWritten{
+		// the first snapshot has no predecessor
+		{Name: "snapshot-a", Predecessor: "", Written: 1048576},
+		{Name: "snapshot-b", Predecessor: "snapshot-a", Written: 0},
+		{Name: "snapshot-c", Predecessor: "snapshot-b", Written: 4096},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseWrittenOutput() = %v, want %v", got, want)
+	}
+}
+
+func TestParseWrittenOutputErrors(t *testing.T) {
+	tests := map[string]string{
+		"not a snapshot":  "zfspv/pvc-1\t1024\n",
+		"invalid written": "zfspv/pvc-1@snapshot-a\t-\n",
+		"missing written": "zfspv/pvc-1@snapshot-a\n",
+	}
+	for name, out := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := parseWrittenOutput([]byte(out)); err == nil {
+				t.Errorf("expected an error for %q", out)
+			}
+		})
+	}
+
+	got, err := parseWrittenOutput(nil)
+	if err != nil || len(got) != 0 {
+		t.Errorf("expected no snapshots, got %v, %v", got, err)
+	}
+}
+
+func TestGetSnapshotsWritten(t *testing.T) {
+	// the fake zfs lists the snapshots of zfspv/volumes/pvc-1 only
+	script := filepath.Join(t.TempDir(), "zfs")
+	body := "#!/bin/sh\nfor a; do ds=$a; done\n" +
+		"[ \"$ds\" = zfspv/volumes/pvc-1 ] && printf '%s@snapshot-a\\t4096\\n' \"$ds\"\n"
+	if err := os.WriteFile(script, []byte(body), 0755); err != nil {
+		t.Fatal(err)
+	}
+	orig := commands
+	t.Cleanup(func() { commands = orig })
+	commands = Commands{ZFS: script, ZPool: script}
+
+	// the volume is in a parent dataset of the pool
+	snap := &apis.ZFSSnapshot{}
+	snap.Name = "snapshot-a"
+	snap.Labels = map[string]string{ZFSVolKey: "pvc-1"}
+	snap.Spec.PoolName = "zfspv"
+	snap.Spec.DatasetName = "volumes/pvc-1"
+	got, err := GetSnapshotsWritten(snap)
+	if err != nil {
+		t.Fatalf("GetSnapshotsWritten() failed: %v", err)
+	}
+	if want := []SnapshotWritten{{Name: "snapshot-a", Written: 4096}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("GetSnapshotsWritten() = %v, want %v", got, want)
+	}
+}
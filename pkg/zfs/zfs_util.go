@@ -18,6 +18,7 @@ package zfs
 
 import (
 	"bufio"
+	"context"
 	"os/exec"
 	"path/filepath"
 	"strconv"
@@ -54,6 +55,7 @@ const (
 	ZFSSnapshotArg = "snapshot"
 	ZFSSendArg     = "send"
 	ZFSRecvArg     = "recv"
+	ZFSPromoteArg  = "promote"
 )
 
 // constants to define volume type
@@ -71,7 +73,10 @@ func PropertyChanged(oldVol *apis.ZFSVolume, newVol *apis.ZFSVolume) bool {
 	}
 
 	return oldVol.Spec.Compression != newVol.Spec.Compression ||
-		oldVol.Spec.Dedup != newVol.Spec.Dedup
+		oldVol.Spec.Dedup != newVol.Spec.Dedup ||
+		oldVol.Spec.RedundantMetadata != newVol.Spec.RedundantMetadata ||
+		oldVol.Spec.ShareNFS != newVol.Spec.ShareNFS ||
+		oldVol.Spec.ShareSMB != newVol.Spec.ShareSMB
 }
 
 // GetVolumeType returns the volume type
@@ -89,11 +94,95 @@ func GetVolumeType(fstype string) string {
 	}
 }
 
+// DnodeSizes is the list of values ZFS accepts for the dnodesize property
+var DnodeSizes = []string{"legacy", "auto", "1k", "2k", "4k", "8k", "16k"}
+
+// ValidateDnodeSize returns an error if the dnodesize is not a legal value
+func ValidateDnodeSize(size string) error {
+	for _, ds := range DnodeSizes {
+		if ds == size {
+			return nil
+		}
+	}
+	return fmt.Errorf("zfs: invalid dnodesize %q, valid values are %s",
+		size, strings.Join(DnodeSizes, ", "))
+}
+
+// acl related property values
+var (
+	AclTypes = []string{"off", "posix", "nfsv4"}
+	AclModes = []string{"discard", "groupmask", "passthrough", "restricted"}
+	Xattrs   = []string{"on", "off", "sa", "dir"}
+)
+
+func isOneOf(val string, list []string) bool {
+	for _, v := range list {
+		if v == val {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateAcl returns an error if the acl properties are not legal values
+// or if their combination is not supported. POSIX ACLs are stored as
+// xattrs, so they need the xattrs to be stored as system attributes.
+func ValidateAcl(acltype, aclmode, xattr string) error {
+	if acltype != "" && !isOneOf(acltype, AclTypes) {
+		return fmt.Errorf("zfs: invalid acltype %q, valid values are %s",
+			acltype, strings.Join(AclTypes, ", "))
+	}
+	if aclmode != "" && !isOneOf(aclmode, AclModes) {
+		return fmt.Errorf("zfs: invalid aclmode %q, valid values are %s",
+			aclmode, strings.Join(AclModes, ", "))
+	}
+	if xattr != "" && !isOneOf(xattr, Xattrs) {
+		return fmt.Errorf("zfs: invalid xattr %q, valid values are %s",
+			xattr, strings.Join(Xattrs, ", "))
+	}
+	if acltype == "posix" && xattr != "" && xattr != "sa" {
+		return fmt.Errorf("zfs: acltype posix needs xattr sa, got xattr %q", xattr)
+	}
+	return nil
+}
+
+// aclProperties returns the acl related properties of the dataset, xattr
+// is set to sa for the POSIX ACLs if it has not been asked explicitly
+func aclProperties(acltype, aclmode, xattr string) []string {
+	var props []string
+	if acltype == "posix" && xattr == "" {
+		xattr = "sa"
+	}
+	if len(acltype) != 0 {
+		props = append(props, "acltype="+acltype)
+	}
+	if len(aclmode) != 0 {
+		props = append(props, "aclmode="+aclmode)
+	}
+	if len(xattr) != 0 {
+		props = append(props, "xattr="+xattr)
+	}
+	return props
+}
+
+// IsReadOnly tells whether the volume has been asked to be read-only
+func IsReadOnly(vol *apis.ZFSVolume) bool {
+	return vol.Spec.ReadOnly == "yes"
+}
+
+// readOnlyProperty returns the create option for the read-only volume
+func readOnlyProperty(vol *apis.ZFSVolume) []string {
+	if !IsReadOnly(vol) {
+		return nil
+	}
+	return []string{"-o", "readonly=on"}
+}
+
 // builldZvolCreateArgs returns zfs create command for zvol along with attributes as a string array
 func buildZvolCreateArgs(vol *apis.ZFSVolume) []string {
 	var ZFSVolArg []string
 
-	volume := vol.Spec.PoolName + "/" + vol.Name
+	volume := VolumeDataset(vol)
 
 	ZFSVolArg = append(ZFSVolArg, ZFSCreateArg)
 
@@ -114,6 +203,10 @@ func buildZvolCreateArgs(vol *apis.ZFSVolume) []string {
 		compressionProperty := "compression=" + vol.Spec.Compression
 		ZFSVolArg = append(ZFSVolArg, "-o", compressionProperty)
 	}
+	if len(vol.Spec.RedundantMetadata) != 0 {
+		redundantMetadataProperty := "redundant_metadata=" + vol.Spec.RedundantMetadata
+		ZFSVolArg = append(ZFSVolArg, "-o", redundantMetadataProperty)
+	}
 	if len(vol.Spec.Encryption) != 0 {
 		encryptionProperty := "encryption=" + vol.Spec.Encryption
 		ZFSVolArg = append(ZFSVolArg, "-o", encryptionProperty)
@@ -127,6 +220,8 @@ func buildZvolCreateArgs(vol *apis.ZFSVolume) []string {
 		ZFSVolArg = append(ZFSVolArg, "-o", keyFormat)
 	}
 
+	ZFSVolArg = append(ZFSVolArg, readOnlyProperty(vol)...)
+	ZFSVolArg = append(ZFSVolArg, provisioningMarker(vol)...)
 	ZFSVolArg = append(ZFSVolArg, volume)
 
 	return ZFSVolArg
@@ -136,8 +231,8 @@ func buildZvolCreateArgs(vol *apis.ZFSVolume) []string {
 func buildCloneCreateArgs(vol *apis.ZFSVolume) []string {
 	var ZFSVolArg []string
 
-	volume := vol.Spec.PoolName + "/" + vol.Name
-	snapshot := vol.Spec.PoolName + "/" + vol.Spec.SnapName
+	volume := VolumeDataset(vol)
+	snapshot := sourcePool(vol) + "/" + vol.Spec.SnapName
 
 	ZFSVolArg = append(ZFSVolArg, ZFSCloneArg)
 
@@ -153,7 +248,10 @@ func buildCloneCreateArgs(vol *apis.ZFSVolume) []string {
 		if vol.Spec.ThinProvision == "no" {
 			ZFSVolArg = append(ZFSVolArg, "-o", reservationProperty(vol.Spec.QuotaType, vol.Spec.Capacity))
 		}
-		ZFSVolArg = append(ZFSVolArg, "-o", "mountpoint=legacy")
+		for _, prop := range shareProperties(vol) {
+			ZFSVolArg = append(ZFSVolArg, "-o", prop)
+		}
+		ZFSVolArg = append(ZFSVolArg, "-o", mountpointProperty(vol))
 	}
 
 	if len(vol.Spec.Dedup) != 0 {
@@ -164,6 +262,10 @@ func buildCloneCreateArgs(vol *apis.ZFSVolume) []string {
 		compressionProperty := "compression=" + vol.Spec.Compression
 		ZFSVolArg = append(ZFSVolArg, "-o", compressionProperty)
 	}
+	if len(vol.Spec.RedundantMetadata) != 0 {
+		redundantMetadataProperty := "redundant_metadata=" + vol.Spec.RedundantMetadata
+		ZFSVolArg = append(ZFSVolArg, "-o", redundantMetadataProperty)
+	}
 	if len(vol.Spec.Encryption) != 0 {
 		encryptionProperty := "encryption=" + vol.Spec.Encryption
 		ZFSVolArg = append(ZFSVolArg, "-o", encryptionProperty)
@@ -176,6 +278,8 @@ func buildCloneCreateArgs(vol *apis.ZFSVolume) []string {
 		keyFormat := "keyformat=" + vol.Spec.KeyFormat
 		ZFSVolArg = append(ZFSVolArg, "-o", keyFormat)
 	}
+	// readonly is set by CreateClone once the fs uuid is regenerated
+	ZFSVolArg = append(ZFSVolArg, provisioningMarker(vol)...)
 	ZFSVolArg = append(ZFSVolArg, snapshot, volume)
 	return ZFSVolArg
 }
@@ -185,10 +289,15 @@ func buildCloneCreateArgs(vol *apis.ZFSVolume) []string {
 func buildZFSSnapCreateArgs(snap *apis.ZFSSnapshot) []string {
 	var ZFSSnapArg []string
 
-	volname := snap.Labels[ZFSVolKey]
+	volname := snapshotVolume(snap)
 	snapDataset := snap.Spec.PoolName + "/" + volname + "@" + snap.Name
 
-	ZFSSnapArg = append(ZFSSnapArg, ZFSSnapshotArg, snapDataset)
+	ZFSSnapArg = append(ZFSSnapArg, ZFSSnapshotArg)
+	// the child datasets of a layout are part of the snapshot
+	if snap.Spec.Layout != "" {
+		ZFSSnapArg = append(ZFSSnapArg, "-r")
+	}
+	ZFSSnapArg = append(ZFSSnapArg, snapDataset)
 
 	return ZFSSnapArg
 }
@@ -198,10 +307,14 @@ func buildZFSSnapCreateArgs(snap *apis.ZFSSnapshot) []string {
 func buildZFSSnapDestroyArgs(snap *apis.ZFSSnapshot) []string {
 	var ZFSSnapArg []string
 
-	volname := snap.Labels[ZFSVolKey]
+	volname := snapshotVolume(snap)
 	snapDataset := snap.Spec.PoolName + "/" + volname + "@" + snap.Name
 
-	ZFSSnapArg = append(ZFSSnapArg, ZFSDestroyArg, snapDataset)
+	ZFSSnapArg = append(ZFSSnapArg, ZFSDestroyArg)
+	if snap.Spec.Layout != "" {
+		ZFSSnapArg = append(ZFSSnapArg, "-r")
+	}
+	ZFSSnapArg = append(ZFSSnapArg, snapDataset)
 
 	return ZFSSnapArg
 }
@@ -210,7 +323,7 @@ func buildZFSSnapDestroyArgs(snap *apis.ZFSSnapshot) []string {
 func buildDatasetCreateArgs(vol *apis.ZFSVolume) []string {
 	var ZFSVolArg []string
 
-	volume := vol.Spec.PoolName + "/" + vol.Name
+	volume := VolumeDataset(vol)
 
 	ZFSVolArg = append(ZFSVolArg, ZFSCreateArg)
 
@@ -222,6 +335,16 @@ func buildDatasetCreateArgs(vol *apis.ZFSVolume) []string {
 		recordsizeProperty := "recordsize=" + vol.Spec.RecordSize
 		ZFSVolArg = append(ZFSVolArg, "-o", recordsizeProperty)
 	}
+	if len(vol.Spec.DnodeSize) != 0 {
+		dnodesizeProperty := "dnodesize=" + vol.Spec.DnodeSize
+		ZFSVolArg = append(ZFSVolArg, "-o", dnodesizeProperty)
+	}
+	for _, prop := range aclProperties(vol.Spec.AclType, vol.Spec.AclMode, vol.Spec.Xattr) {
+		ZFSVolArg = append(ZFSVolArg, "-o", prop)
+	}
+	for _, prop := range shareProperties(vol) {
+		ZFSVolArg = append(ZFSVolArg, "-o", prop)
+	}
 	if vol.Spec.ThinProvision == "no" {
 		ZFSVolArg = append(ZFSVolArg, "-o", reservationProperty(vol.Spec.QuotaType, vol.Spec.Capacity))
 	}
@@ -233,6 +356,10 @@ func buildDatasetCreateArgs(vol *apis.ZFSVolume) []string {
 		compressionProperty := "compression=" + vol.Spec.Compression
 		ZFSVolArg = append(ZFSVolArg, "-o", compressionProperty)
 	}
+	if len(vol.Spec.RedundantMetadata) != 0 {
+		redundantMetadataProperty := "redundant_metadata=" + vol.Spec.RedundantMetadata
+		ZFSVolArg = append(ZFSVolArg, "-o", redundantMetadataProperty)
+	}
 	if len(vol.Spec.Encryption) != 0 {
 		encryptionProperty := "encryption=" + vol.Spec.Encryption
 		ZFSVolArg = append(ZFSVolArg, "-o", encryptionProperty)
@@ -246,8 +373,12 @@ func buildDatasetCreateArgs(vol *apis.ZFSVolume) []string {
 		ZFSVolArg = append(ZFSVolArg, "-o", keyFormat)
 	}
 
-	// set the mount path to none, by default zfs mounts it to the default dataset path
-	ZFSVolArg = append(ZFSVolArg, "-o", "mountpoint=legacy", volume)
+	ZFSVolArg = append(ZFSVolArg, readOnlyProperty(vol)...)
+	ZFSVolArg = append(ZFSVolArg, provisioningMarker(vol)...)
+
+	// set the mount path to none, by default zfs mounts it to the default
+	// dataset path, unless it is to be mounted at a path of the node
+	ZFSVolArg = append(ZFSVolArg, "-o", mountpointProperty(vol), volume)
 
 	return ZFSVolArg
 }
@@ -257,7 +388,7 @@ func buildDatasetCreateArgs(vol *apis.ZFSVolume) []string {
 func buildVolumeSetArgs(vol *apis.ZFSVolume) []string {
 	var ZFSVolArg []string
 
-	volume := vol.Spec.PoolName + "/" + vol.Name
+	volume := VolumeDataset(vol)
 
 	ZFSVolArg = append(ZFSVolArg, ZFSSetArg)
 
@@ -275,6 +406,11 @@ func buildVolumeSetArgs(vol *apis.ZFSVolume) []string {
 		compressionProperty := "compression=" + vol.Spec.Compression
 		ZFSVolArg = append(ZFSVolArg, compressionProperty)
 	}
+	if len(vol.Spec.RedundantMetadata) != 0 {
+		redundantMetadataProperty := "redundant_metadata=" + vol.Spec.RedundantMetadata
+		ZFSVolArg = append(ZFSVolArg, redundantMetadataProperty)
+	}
+	ZFSVolArg = append(ZFSVolArg, shareProperties(vol)...)
 
 	ZFSVolArg = append(ZFSVolArg, volume)
 
@@ -285,7 +421,7 @@ func buildVolumeSetArgs(vol *apis.ZFSVolume) []string {
 func buildVolumeResizeArgs(vol *apis.ZFSVolume) []string {
 	var ZFSVolArg []string
 
-	volume := vol.Spec.PoolName + "/" + vol.Name
+	volume := VolumeDataset(vol)
 
 	ZFSVolArg = append(ZFSVolArg, ZFSSetArg)
 
@@ -302,42 +438,13 @@ func buildVolumeResizeArgs(vol *apis.ZFSVolume) []string {
 	return ZFSVolArg
 }
 
-// builldVolumeBackupArgs returns volume send command for sending the zfs volume
-func buildVolumeBackupArgs(bkp *apis.ZFSBackup, vol *apis.ZFSVolume) ([]string, error) {
-	var ZFSVolArg []string
-	backupDest := bkp.Spec.BackupDest
-
-	bkpAddr := strings.Split(backupDest, ":")
-	if len(bkpAddr) != 2 {
-		return ZFSVolArg, fmt.Errorf("zfs: invalid backup server address %s", backupDest)
-	}
-
-	curSnap := vol.Spec.PoolName + "/" + vol.Name + "@" + bkp.Spec.SnapName
-
-	remote := " | nc -w 3 " + bkpAddr[0] + " " + bkpAddr[1]
-
-	cmd := ZFSVolCmd + " "
-
-	if len(bkp.Spec.PrevSnapName) > 0 {
-		prevSnap := vol.Spec.PoolName + "/" + vol.Name + "@" + bkp.Spec.PrevSnapName
-		// do incremental send
-		cmd += ZFSSendArg + " -i " + prevSnap + " " + curSnap + " " + remote
-	} else {
-		cmd += ZFSSendArg + " " + curSnap + remote
-	}
-
-	ZFSVolArg = append(ZFSVolArg, "-c", cmd)
-
-	return ZFSVolArg, nil
-}
-
 // builldVolumeRestoreArgs returns volume recv command for receiving the zfs volume
 func buildVolumeRestoreArgs(rstr *apis.ZFSRestore) ([]string, error) {
 	var ZFSVolArg []string
 	var ZFSRecvParam string
 	restoreSrc := rstr.Spec.RestoreSrc
 
-	volume := rstr.VolSpec.PoolName + "/" + rstr.Spec.VolumeName
+	volume := restoreDataset(rstr)
 
 	rstrAddr := strings.Split(restoreSrc, ":")
 	if len(rstrAddr) != 2 {
@@ -346,6 +453,25 @@ func buildVolumeRestoreArgs(rstr *apis.ZFSRestore) ([]string, error) {
 
 	source := "nc -w 3 " + rstrAddr[0] + " " + rstrAddr[1] + " | "
 
+	// the partial dataset keeps the properties of the interrupted receive,
+	// the sender resumes its stream from the token
+	if len(rstr.Retry.ResumeToken) != 0 {
+		ZFSVolArg = append(ZFSVolArg, "-c", source+zfsShell()+" "+ZFSRecvArg+" -s "+volume)
+		return ZFSVolArg, nil
+	}
+
+	// a failed receive keeps the partial dataset along with its token
+	var resumable string
+	if rstr.Spec.Resumable {
+		resumable = " -s"
+	}
+
+	// the dataset and its properties are there from the previous restore
+	if rstr.Spec.Incremental {
+		ZFSVolArg = append(ZFSVolArg, "-c", source+zfsShell()+" "+ZFSRecvArg+resumable+" -F "+volume)
+		return ZFSVolArg, nil
+	}
+
 	if rstr.VolSpec.VolumeType == VolTypeDataset {
 		if len(rstr.VolSpec.Capacity) != 0 {
 			ZFSRecvParam += " -o " + rstr.VolSpec.QuotaType + "=" + rstr.VolSpec.Capacity
@@ -353,6 +479,15 @@ func buildVolumeRestoreArgs(rstr *apis.ZFSRestore) ([]string, error) {
 		if len(rstr.VolSpec.RecordSize) != 0 {
 			ZFSRecvParam += " -o recordsize=" + rstr.VolSpec.RecordSize
 		}
+		if len(rstr.VolSpec.DnodeSize) != 0 {
+			ZFSRecvParam += " -o dnodesize=" + rstr.VolSpec.DnodeSize
+		}
+		for _, prop := range aclProperties(rstr.VolSpec.AclType, rstr.VolSpec.AclMode, rstr.VolSpec.Xattr) {
+			ZFSRecvParam += " -o " + prop
+		}
+		if rstr.VolSpec.ReadOnly == "yes" {
+			ZFSRecvParam += " -o readonly=on"
+		}
 		if rstr.VolSpec.ThinProvision == "no" {
 			ZFSRecvParam += " -o reservation=" + rstr.VolSpec.Capacity
 		}
@@ -375,18 +510,36 @@ func buildVolumeRestoreArgs(rstr *apis.ZFSRestore) ([]string, error) {
 		ZFSRecvParam += " -o keyformat=" + rstr.VolSpec.KeyFormat
 	}
 
-	cmd := source + ZFSVolCmd + " " + ZFSRecvArg + ZFSRecvParam + " -F " + volume
+	// mark the dataset as being restored, so that a partial dataset left
+	// by a failed receive is known to be safe to destroy
+	if len(rstr.UID) != 0 {
+		ZFSRecvParam += " -o " + RestoreProp + "=" + string(rstr.UID)
+	}
+
+	cmd := source + zfsShell() + " " + ZFSRecvArg + ZFSRecvParam + resumable + " -F " + volume
 
 	ZFSVolArg = append(ZFSVolArg, "-c", cmd)
 
 	return ZFSVolArg, nil
 }
 
+// buildRestoreRecordSizeArgs returns the command setting the recordsize
+// asked in the restore on the received dataset, nil if there is nothing to
+// set. The recordsize is not retroactive, the received blocks keep the
+// size they had in the source and only the new writes use it.
+func buildRestoreRecordSizeArgs(rstr *apis.ZFSRestore) []string {
+	if rstr.VolSpec.VolumeType != VolTypeDataset || len(rstr.Spec.RecordSize) == 0 {
+		return nil
+	}
+	volume := restoreDataset(rstr)
+	return []string{ZFSSetArg, "recordsize=" + rstr.Spec.RecordSize, volume}
+}
+
 // builldVolumeDestroyArgs returns volume destroy command along with attributes as a string array
 func buildVolumeDestroyArgs(vol *apis.ZFSVolume) []string {
 	var ZFSVolArg []string
 
-	volume := vol.Spec.PoolName + "/" + vol.Name
+	volume := VolumeDataset(vol)
 
 	ZFSVolArg = append(ZFSVolArg, ZFSDestroyArg, "-r", volume)
 
@@ -398,50 +551,92 @@ func getVolume(volume string) error {
 
 	ZFSVolArg = append(ZFSVolArg, ZFSListArg, volume)
 
-	cmd := exec.Command(ZFSVolCmd, ZFSVolArg...)
+	cmd := zfsCommand(ZFSVolArg...)
 	_, err := cmd.CombinedOutput()
 	return err
 }
 
 // CreateVolume creates the zvol/dataset as per
-// info provided in ZFSVolume object
-func CreateVolume(vol *apis.ZFSVolume) error {
-	volume := vol.Spec.PoolName + "/" + vol.Name
+// info provided in ZFSVolume object. The generated encryption key of the
+// volume, if any, is only passed on the stdin, never to a file.
+func CreateVolume(vol *apis.ZFSVolume, key string) error {
+	volume := VolumeDataset(vol)
+
+	exists := getVolume(volume) == nil
+	if exists {
+		// the dataset left by a failed attempt is created again, a retry
+		// never adopts it
+		discarded, err := discardPartialVolume(vol)
+		if err != nil {
+			klog.Errorf("zfs: could not create volume %v: %v", volume, err)
+			return err
+		}
+		exists = !discarded
+	}
 
-	if err := getVolume(volume); err != nil {
+	if !exists {
+		if err := CheckRedundantMetadata(vol); err != nil {
+			klog.Errorf("zfs: could not create volume %v: %v", volume, err)
+			return err
+		}
 		var args []string
 		if vol.Spec.VolumeType == VolTypeDataset {
+			if err := CheckHostMountPoint(vol); err != nil {
+				klog.Errorf("zfs: could not create volume %v: %v", volume, err)
+				return err
+			}
 			args = buildDatasetCreateArgs(vol)
 		} else {
+			// fail early if the zvol can not be formatted on this node
+			if err := CheckMkfs(vol.Spec.FsType); err != nil {
+				klog.Errorf("zfs: could not create volume %v: %v", volume, err)
+				return err
+			}
 			args = buildZvolCreateArgs(vol)
 		}
-		cmd := exec.Command(ZFSVolCmd, args...)
-		out, err := cmd.CombinedOutput()
+		// zfs create fails while the pool is busy, e.g. during a scrub
+		out, err := runRetryBusy(args, func() ([]byte, error) {
+			cmd := zfsCommand(args...)
+			if key != "" {
+				cmd.Stdin = strings.NewReader(key)
+			}
+			return cmd.CombinedOutput()
+		})
 
 		if err != nil {
 			klog.Errorf(
 				"zfs: could not create volume %v cmd %v error: %s", volume, args, string(out),
 			)
+			// zfs create might have failed after creating the dataset
+			rollbackPartialVolume(vol)
 			return err
 		}
 		klog.Infof("created volume %s", volume)
-	} else if err == nil {
+	} else {
 		klog.Infof("using existing volume %v", volume)
 	}
 
+	if err := createLayout(vol, ""); err != nil {
+		rollbackPartialVolume(vol)
+		return err
+	}
 	return nil
 }
 
 // CreateClone creates clone for the zvol/dataset as per
 // info provided in ZFSVolume object
 func CreateClone(vol *apis.ZFSVolume) error {
-	volume := vol.Spec.PoolName + "/" + vol.Name
+	volume := VolumeDataset(vol)
 
 	if srcVol, ok := vol.Labels[ZFSSrcVolKey]; ok {
 		// datasource is volume, create the snapshot first
 		snap := &apis.ZFSSnapshot{}
 		snap.Name = vol.Name // use volname as snapname
-		snap.Spec = vol.Spec
+		snap.Spec.VolumeInfo = vol.Spec
+		snap.Spec.PoolName = sourcePool(vol)
+		// the snapshot is taken of the source dataset, which is the
+		// volume part of the SnapName
+		snap.Spec.DatasetName = strings.SplitN(vol.Spec.SnapName, "@", 2)[0]
 		// add src vol name
 		snap.Labels = map[string]string{ZFSVolKey: srcVol}
 
@@ -457,30 +652,103 @@ func CreateClone(vol *apis.ZFSVolume) error {
 		}
 	}
 
-	if err := getVolume(volume); err != nil {
-		args := buildCloneCreateArgs(vol)
-		cmd := exec.Command(ZFSVolCmd, args...)
-		out, err := cmd.CombinedOutput()
-
+	exists := getVolume(volume) == nil
+	if exists {
+		discarded, err := discardPartialVolume(vol)
 		if err != nil {
-			klog.Errorf(
-				"zfs: could not clone volume %v cmd %v error: %s", volume, args, string(out),
-			)
+			klog.Errorf("zfs: could not clone volume %v: %v", volume, err)
+			return err
+		}
+		exists = !discarded
+	}
+
+	if !exists {
+		if err := CheckRedundantMetadata(vol); err != nil {
+			klog.Errorf("zfs: could not clone volume %v: %v", volume, err)
 			return err
 		}
-		klog.Infof("created clone %s", volume)
-	} else if err == nil {
+		if err := CheckHostMountPoint(vol); err != nil {
+			klog.Errorf("zfs: could not clone volume %v: %v", volume, err)
+			return err
+		}
+		cloneVol := vol
+		srcPool := sourcePool(vol)
+		if parts := strings.SplitN(vol.Spec.SnapName, "@", 2); len(parts) == 2 {
+			// the snapshot might have been orphaned by the source volume deletion
+			src := resolveSnapshot(srcPool, parts[0], parts[1])
+			cloneVol = vol.DeepCopy()
+			cloneVol.Spec.SnapName = strings.TrimPrefix(src, srcPool+"/")
+		}
+		// do not propagate a corrupted snapshot into the clone
+		if err := verifyCloneSource(vol, srcPool+"/"+cloneVol.Spec.SnapName); err != nil {
+			klog.Errorf("zfs: could not clone volume %v: %v", volume, err)
+			return err
+		}
+		if IsPoolCopy(vol) {
+			// a clone can not be on another zpool than its origin
+			if err := copyVolume(cloneVol, srcPool+"/"+cloneVol.Spec.SnapName); err != nil {
+				klog.Errorf("zfs: could not copy volume %v: %v", volume, err)
+				return err
+			}
+		} else if err := createClone(vol, cloneVol); err != nil {
+			return err
+		}
+	} else {
 		klog.Infof("using existing clone volume %v", volume)
 	}
+	if err := createLayout(vol, sourcePool(vol)+"/"+vol.Spec.SnapName); err != nil {
+		rollbackPartialVolume(vol)
+		return err
+	}
+	if IsPoolCopy(vol) {
+		// also done for an existing copy, the agent may have been
+		// restarted in the middle
+		if err := finishCopy(vol, sourcePool(vol)+"/"+vol.Spec.SnapName); err != nil {
+			klog.Errorf("zfs: could not clean up the snapshots of the copy %s: %v", volume, err)
+			return err
+		}
+	}
 
+	var err error
 	if vol.Spec.FsType == "xfs" {
 		device := ZFSDevPath + volume
-		return xfs.GenerateUUID(device)
+		err = xfs.GenerateUUID(device)
 	}
 	if vol.Spec.FsType == "btrfs" {
 		device := ZFSDevPath + volume
-		return btrfs.GenerateUUID(device)
+		err = btrfs.GenerateUUID(device)
+	}
+	if err != nil {
+		klog.Errorf("zfs: could not generate uuid for the clone %s: %v", volume, err)
+		rollbackPartialVolume(vol)
+		return err
+	}
+	if IsReadOnly(vol) {
+		if err = setVolumeProperty(vol, "readonly", "on"); err != nil {
+			klog.Errorf("zfs: could not make the clone %s readonly: %v", volume, err)
+			rollbackPartialVolume(vol)
+		}
+	}
+	return err
+}
+
+// createClone clones the volume from the snapshot of cloneVol, which may
+// have been orphaned
+func createClone(vol, cloneVol *apis.ZFSVolume) error {
+	volume := VolumeDataset(vol)
+	args := buildCloneCreateArgs(cloneVol)
+	out, err := runRetryBusy(args, func() ([]byte, error) {
+		return zfsCommand(args...).CombinedOutput()
+	})
+
+	if err != nil {
+		klog.Errorf(
+			"zfs: could not clone volume %v cmd %v error: %s", volume, args, string(out),
+		)
+		rollbackPartialVolume(vol)
+		return err
 	}
+	klog.Infof("created clone %s", volume)
 	return nil
 }
 
@@ -491,7 +759,7 @@ func SetDatasetMountProp(volume string, mountpath string) error {
 	mountProperty := "mountpoint=" + mountpath
 	ZFSVolArg = append(ZFSVolArg, ZFSSetArg, mountProperty, volume)
 
-	cmd := exec.Command(ZFSVolCmd, ZFSVolArg...)
+	cmd := zfsCommand(ZFSVolArg...)
 	out, err := cmd.CombinedOutput()
 	if err != nil {
 		klog.Errorf("zfs: could not set mountpoint on dataset %v cmd %v error: %s",
@@ -503,7 +771,7 @@ func SetDatasetMountProp(volume string, mountpath string) error {
 
 // MountZFSDataset mounts the dataset to the given mountpoint
 func MountZFSDataset(vol *apis.ZFSVolume, mountpath string) error {
-	volume := vol.Spec.PoolName + "/" + vol.Name
+	volume := VolumeDataset(vol)
 
 	// set the mountpoint to the path where this volume should be mounted
 	err := SetDatasetMountProp(volume, mountpath)
@@ -525,7 +793,7 @@ func MountZFSDataset(vol *apis.ZFSVolume, mountpath string) error {
 	if mounted == "no" {
 		var MountVolArg []string
 		MountVolArg = append(MountVolArg, "mount", volume)
-		cmd := exec.Command(ZFSVolCmd, MountVolArg...)
+		cmd := zfsCommand(MountVolArg...)
 		out, err := cmd.CombinedOutput()
 		if err != nil {
 			klog.Errorf("zfs: could not mount the dataset %v cmd %v error: %s",
@@ -537,9 +805,10 @@ func MountZFSDataset(vol *apis.ZFSVolume, mountpath string) error {
 	return nil
 }
 
-// SetDatasetLegacyMount sets the dataset mountpoint to legacy if not set
+// SetDatasetLegacyMount sets the dataset mountpoint to legacy if not set,
+// the datasets having a host mountpoint stay mounted there
 func SetDatasetLegacyMount(vol *apis.ZFSVolume) error {
-	if vol.Spec.VolumeType != VolTypeDataset {
+	if vol.Spec.VolumeType != VolTypeDataset || vol.Spec.HostMountPoint != "" {
 		return nil
 	}
 
@@ -550,7 +819,7 @@ func SetDatasetLegacyMount(vol *apis.ZFSVolume) error {
 
 	if prop != "legacy" {
 		// set the mountpoint to legacy
-		volume := vol.Spec.PoolName + "/" + vol.Name
+		volume := VolumeDataset(vol)
 		err = SetDatasetMountProp(volume, "legacy")
 	}
 
@@ -560,11 +829,11 @@ func SetDatasetLegacyMount(vol *apis.ZFSVolume) error {
 // GetVolumeProperty gets zfs properties for the volume
 func GetVolumeProperty(vol *apis.ZFSVolume, prop string) (string, error) {
 	var ZFSVolArg []string
-	volume := vol.Spec.PoolName + "/" + vol.Name
+	volume := VolumeDataset(vol)
 
 	ZFSVolArg = append(ZFSVolArg, ZFSGetArg, "-pH", "-o", "value", prop, volume)
 
-	cmd := exec.Command(ZFSVolCmd, ZFSVolArg...)
+	cmd := zfsCommand(ZFSVolArg...)
 	out, err := cmd.CombinedOutput()
 	if err != nil {
 		klog.Errorf("zfs: could not get %s on dataset %v cmd %v error: %s",
@@ -578,15 +847,21 @@ func GetVolumeProperty(vol *apis.ZFSVolume, prop string) (string, error) {
 // SetVolumeProp sets the volume property
 func SetVolumeProp(vol *apis.ZFSVolume) error {
 	var err error
-	volume := vol.Spec.PoolName + "/" + vol.Name
+	volume := VolumeDataset(vol)
 
 	if len(vol.Spec.Compression) == 0 &&
 		len(vol.Spec.Dedup) == 0 &&
+		len(vol.Spec.RedundantMetadata) == 0 &&
+		len(shareProperties(vol)) == 0 &&
 		(vol.Spec.VolumeType != VolTypeDataset ||
 			len(vol.Spec.RecordSize) == 0) {
 		//nothing to set, just return
 		return nil
 	}
+	if err := CheckRedundantMetadata(vol); err != nil {
+		klog.Errorf("zfs: could not set property on volume %v: %v", volume, err)
+		return err
+	}
 	/* Case: Restart =>
 	 * In this case we get the add event but here we don't know which
 	 * property has changed when we were down, so firing the zfs set
@@ -602,8 +877,9 @@ func SetVolumeProp(vol *apis.ZFSVolume) error {
 	 */
 
 	args := buildVolumeSetArgs(vol)
-	cmd := exec.Command(ZFSVolCmd, args...)
-	out, err := cmd.CombinedOutput()
+	out, err := runRetryBusy(args, func() ([]byte, error) {
+		return zfsCommand(args...).CombinedOutput()
+	})
 
 	if err != nil {
 		klog.Errorf(
@@ -618,7 +894,7 @@ func SetVolumeProp(vol *apis.ZFSVolume) error {
 
 // DestroyVolume deletes the zfs volume
 func DestroyVolume(vol *apis.ZFSVolume) error {
-	volume := vol.Spec.PoolName + "/" + vol.Name
+	volume := VolumeDataset(vol)
 	parentDataset := vol.Spec.PoolName
 
 	// check if parent dataset is present or not before attempting to delete the volume
@@ -636,8 +912,15 @@ func DestroyVolume(vol *apis.ZFSVolume) error {
 		return nil
 	}
 
+	if vol.Spec.SnapshotPolicy == SnapshotPolicyOrphan {
+		// keep the snapshots around before destroying the volume
+		if err := OrphanSnapshots(vol); err != nil {
+			return err
+		}
+	}
+
 	args := buildVolumeDestroyArgs(vol)
-	cmd := exec.Command(ZFSVolCmd, args...)
+	cmd := zfsCommand(args...)
 	out, err := cmd.CombinedOutput()
 
 	if err != nil {
@@ -647,11 +930,16 @@ func DestroyVolume(vol *apis.ZFSVolume) error {
 		return err
 	}
 
-	if srcVol, ok := vol.Labels[ZFSSrcVolKey]; ok {
+	// the snapshot of a copy has been destroyed once it was received
+	if srcVol, ok := vol.Labels[ZFSSrcVolKey]; ok && !IsPoolCopy(vol) {
 		// datasource is volume, delete the dependent snapshot
 		snap := &apis.ZFSSnapshot{}
 		snap.Name = vol.Name // snapname is same as volname
-		snap.Spec = vol.Spec
+		snap.Spec.VolumeInfo = vol.Spec
+		snap.Spec.PoolName = sourcePool(vol)
+		// the snapshot is taken of the source dataset, which is the
+		// volume part of the SnapName
+		snap.Spec.DatasetName = strings.SplitN(vol.Spec.SnapName, "@", 2)[0]
 		// add src vol name
 		snap.Labels = map[string]string{ZFSVolKey: srcVol}
 
@@ -675,18 +963,35 @@ func DestroyVolume(vol *apis.ZFSVolume) error {
 // CreateSnapshot creates the zfs volume snapshot
 func CreateSnapshot(snap *apis.ZFSSnapshot) error {
 
-	volume := snap.Labels[ZFSVolKey]
+	volume := snapshotVolume(snap)
 	snapDataset := snap.Spec.PoolName + "/" + volume + "@" + snap.Name
 
+	// zfs snapshot is killed once the timeout of the snapshot is over
+	ctx := context.Background()
+	if deadline, ok := SnapshotDeadline(snap); ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+
 	if err := getVolume(snapDataset); err == nil {
 		klog.Infof("snapshot already there %s", snapDataset)
-		// snapshot already there just return
-		return nil
+		// snapshot already there, the properties might not have been set
+		// nor its sync confirmed
+		if err := confirmSnapshotDurable(ctx, snap); err != nil {
+			klog.Errorf("%v", err)
+			return err
+		}
+		return setSnapshotProperties(snap)
+	}
+
+	if err := syncSnapshotVolume(ctx, snap); err != nil {
+		klog.Errorf("zfs: could not flush volume %s for snapshot %s: %v", volume, snap.Name, err)
+		return err
 	}
 
 	args := buildZFSSnapCreateArgs(snap)
-	cmd := exec.Command(ZFSVolCmd, args...)
-	out, err := cmd.CombinedOutput()
+	out, err := zfsCommandContext(ctx, args...).CombinedOutput()
 
 	if err != nil {
 		klog.Errorf(
@@ -695,13 +1000,18 @@ func CreateSnapshot(snap *apis.ZFSSnapshot) error {
 		return err
 	}
 	klog.Infof("created snapshot %s@%s", volume, snap.Name)
-	return nil
+	// the snapshot is not ready until it is on stable storage, if asked
+	if err := confirmSnapshotDurable(ctx, snap); err != nil {
+		klog.Errorf("%v", err)
+		return err
+	}
+	return setSnapshotProperties(snap)
 }
 
 // DestroySnapshot deletes the zfs volume snapshot
 func DestroySnapshot(snap *apis.ZFSSnapshot) error {
 
-	volume := snap.Labels[ZFSVolKey]
+	volume := snapshotVolume(snap)
 	snapDataset := snap.Spec.PoolName + "/" + volume + "@" + snap.Name
 
 	parentDataset := snap.Spec.PoolName
@@ -715,15 +1025,19 @@ func DestroySnapshot(snap *apis.ZFSSnapshot) error {
 		return err
 	}
 
+	orphaned := false
 	if err := getVolume(snapDataset); err != nil {
-		klog.Errorf(
-			"destroy: snapshot %v is not present, error: %s", volume, err.Error(),
-		)
-		return nil
+		if snapDataset = resolveSnapshot(snap.Spec.PoolName, volume, snap.Name); snapDataset == snap.Spec.PoolName+"/"+volume+"@"+snap.Name {
+			klog.Errorf(
+				"destroy: snapshot %v is not present, error: %s", volume, err.Error(),
+			)
+			return nil
+		}
+		orphaned = true
 	}
 
-	args := buildZFSSnapDestroyArgs(snap)
-	cmd := exec.Command(ZFSVolCmd, args...)
+	args := []string{ZFSDestroyArg, snapDataset}
+	cmd := zfsCommand(args...)
 	out, err := cmd.CombinedOutput()
 
 	if err != nil {
@@ -732,13 +1046,17 @@ func DestroySnapshot(snap *apis.ZFSSnapshot) error {
 		)
 		return err
 	}
-	klog.Infof("deleted snapshot %s@%s", volume, snap.Name)
+	klog.Infof("deleted snapshot %s", snapDataset)
+
+	if orphaned {
+		destroyOrphanIfEmpty(snap.Spec.PoolName, volume)
+	}
 	return nil
 }
 
 // GetVolumeDevPath returns devpath for the given volume
 func GetVolumeDevPath(vol *apis.ZFSVolume) (string, error) {
-	volume := vol.Spec.PoolName + "/" + vol.Name
+	volume := VolumeDataset(vol)
 	if vol.Spec.VolumeType == VolTypeDataset {
 		return volume, nil
 	}
@@ -757,9 +1075,9 @@ func GetVolumeDevPath(vol *apis.ZFSVolume) (string, error) {
 // ResizeZFSVolume resize volume
 func ResizeZFSVolume(vol *apis.ZFSVolume, mountpath string, resizefs bool) error {
 
-	volume := vol.Spec.PoolName + "/" + vol.Name
+	volume := VolumeDataset(vol)
 	args := buildVolumeResizeArgs(vol)
-	cmd := exec.Command(ZFSVolCmd, args...)
+	cmd := zfsCommand(args...)
 	out, err := cmd.CombinedOutput()
 
 	if err != nil {
@@ -777,19 +1095,23 @@ func ResizeZFSVolume(vol *apis.ZFSVolume, mountpath string, resizefs bool) error
 	return err
 }
 
-// CreateBackup creates the backup
-func CreateBackup(bkp *apis.ZFSBackup) error {
+// CreateBackup creates the snapshot of the backup and sends it to the
+// backup destination, the progress is reported every
+// backupProgressInterval. The send is killed once the context is done and
+// ErrBackupCancelled is returned.
+func CreateBackup(ctx context.Context, bkp *apis.ZFSBackup, progress BackupProgressFunc) error {
 	vol, err := GetZFSVolume(bkp.Spec.VolumeName)
 	if err != nil {
 		return err
 	}
 
-	volume := vol.Spec.PoolName + "/" + vol.Name
+	volume := VolumeDataset(vol)
 
 	/* create the snapshot for the backup */
 	snap := &apis.ZFSSnapshot{}
 	snap.Name = bkp.Spec.SnapName
 	snap.Spec.PoolName = vol.Spec.PoolName
+	snap.Spec.DatasetName = vol.Spec.DatasetName
 	snap.Labels = map[string]string{ZFSVolKey: vol.Name}
 
 	err = CreateSnapshot(snap)
@@ -801,17 +1123,14 @@ func CreateBackup(bkp *apis.ZFSBackup) error {
 		return err
 	}
 
-	args, err := buildVolumeBackupArgs(bkp, vol)
-	if err != nil {
+	if err = verifyBackupSnapshot(ctx, bkp, volume+"@"+snap.Name); err != nil {
+		klog.Errorf("zfs: could not backup the volume %v: %v", volume, err)
 		return err
 	}
-	cmd := exec.Command("bash", args...)
-	out, err := cmd.CombinedOutput()
 
+	err = sendBackup(ctx, bkp, vol, progress)
 	if err != nil {
-		klog.Errorf(
-			"zfs: could not backup the volume %v cmd %v error: %s", volume, args, string(out),
-		)
+		klog.Errorf("zfs: could not backup the volume %v: %v", volume, err)
 	}
 
 	return err
@@ -828,12 +1147,13 @@ func DestoryBackup(bkp *apis.ZFSBackup) error {
 		return err
 	}
 
-	volume := vol.Spec.PoolName + "/" + vol.Name
+	volume := VolumeDataset(vol)
 
 	/* create the snapshot for the backup */
 	snap := &apis.ZFSSnapshot{}
 	snap.Name = bkp.Spec.SnapName
 	snap.Spec.PoolName = vol.Spec.PoolName
+	snap.Spec.DatasetName = vol.Spec.DatasetName
 	snap.Labels = map[string]string{ZFSVolKey: vol.Name}
 
 	err = DestroySnapshot(snap)
@@ -881,7 +1201,7 @@ func CreateRestore(rstr *apis.ZFSRestore) error {
 		return err
 	}
 
-	volume := rstr.VolSpec.PoolName + "/" + rstr.Spec.VolumeName
+	volume := restoreDataset(rstr)
 
 	cmd := exec.Command("bash", args...)
 	out, err := cmd.CombinedOutput()
@@ -890,9 +1210,23 @@ func CreateRestore(rstr *apis.ZFSRestore) error {
 		klog.Errorf(
 			"zfs: could not restore the volume %v cmd %v error: %s", volume, args, string(out),
 		)
+		return fmt.Errorf("zfs receive into %s failed: %v, %s", volume, err, strings.TrimSpace(string(out)))
+	}
+
+	if err := clearRestoreMarker(volume); err != nil {
+		klog.Errorf("zfs: could not clear the restore marker of %v: %v", volume, err)
 		return err
 	}
 
+	if rsArgs := buildRestoreRecordSizeArgs(rstr); rsArgs != nil {
+		if out, err := zfsCommand(rsArgs...).CombinedOutput(); err != nil {
+			klog.Errorf(
+				"zfs: could not set the recordsize of the restored volume %v cmd %v error: %s", volume, rsArgs, string(out),
+			)
+			return err
+		}
+	}
+
 	/*
 	 * need to generate a new uuid for zfs and btrfs volumes
 	 * so that we can mount it.
@@ -923,7 +1257,7 @@ func ListZFSPool() ([]apis.Pool, error) {
 		"-o", "name,guid,available,used",
 		"-H", "-p",
 	}
-	cmd := exec.Command(ZFSVolCmd, args...)
+	cmd := zfsCommand(args...)
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		klog.Errorf("zfs: could not list zpool cmd %v: %v", args, err)
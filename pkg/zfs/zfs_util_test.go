@@ -0,0 +1,215 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+)
+
+func TestValidateDnodeSize(t *testing.T) {
+	tests := []struct {
+		size    string
+		wantErr bool
+	}{
+		{"legacy", false},
+		{"auto", false},
+		{"1k", false},
+		{"16k", false},
+		{"32k", true},
+		{"3k", true},
+		{"AUTO", true},
+		{"", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.size, func(t *testing.T) {
+			if err := ValidateDnodeSize(tt.size); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateDnodeSize(%s) error = %v, wantErr %v", tt.size, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBuildDatasetCreateArgs(t *testing.T) {
+	volGen := func(dnodesize string) *apis.ZFSVolume {
+		vol := &apis.ZFSVolume{}
+		vol.Name = "pvc-1"
+		vol.Spec.PoolName = "zfspv"
+		vol.Spec.QuotaType = "quota"
+		vol.Spec.Capacity = "1024"
+		vol.Spec.ThinProvision = "yes"
+		vol.Spec.DnodeSize = dnodesize
+		return vol
+	}
+	tests := []struct {
+		name      string
+		dnodesize string
+		want      []string
+	}{
+		{"without dnodesize", "",
+			[]string{"create", "-o", "quota=1024", "-o", "mountpoint=legacy", "zfspv/pvc-1"}},
+		{"with dnodesize", "auto",
+			[]string{"create", "-o", "quota=1024", "-o", "dnodesize=auto", "-o", "mountpoint=legacy", "zfspv/pvc-1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := buildDatasetCreateArgs(volGen(tt.dnodesize)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("buildDatasetCreateArgs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateAcl(t *testing.T) {
+	tests := []struct {
+		name                    string
+		acltype, aclmode, xattr string
+		wantErr                 bool
+	}{
+		{"nothing set", "", "", "", false},
+		{"posix defaults xattr", "posix", "", "", false},
+		{"posix with sa", "posix", "passthrough", "sa", false},
+		{"posix with dir xattr", "posix", "", "dir", true},
+		{"nfsv4 with dir xattr", "nfsv4", "restricted", "dir", false},
+		{"invalid acltype", "windows", "", "", true},
+		{"invalid aclmode", "posix", "keep", "", true},
+		{"invalid xattr", "", "", "yes", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateAcl(tt.acltype, tt.aclmode, tt.xattr); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateAcl() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBuildDatasetCreateArgsAcl(t *testing.T) {
+	volGen := func(acltype, aclmode, xattr string) *apis.ZFSVolume {
+		vol := &apis.ZFSVolume{}
+		vol.Name = "pvc-1"
+		vol.Spec.PoolName = "zfspv"
+		vol.Spec.ThinProvision = "yes"
+		vol.Spec.AclType = acltype
+		vol.Spec.AclMode = aclmode
+		vol.Spec.Xattr = xattr
+		return vol
+	}
+	tests := []struct {
+		name string
+		vol  *apis.ZFSVolume
+		want []string
+	}{
+		{"without acl", volGen("", "", ""),
+			[]string{"create", "-o", "mountpoint=legacy", "zfspv/pvc-1"}},
+		{"posix sets xattr sa", volGen("posix", "", ""),
+			[]string{"create", "-o", "acltype=posix", "-o", "xattr=sa", "-o", "mountpoint=legacy", "zfspv/pvc-1"}},
+		{"posix with aclmode", volGen("posix", "passthrough", "sa"),
+			[]string{"create", "-o", "acltype=posix", "-o", "aclmode=passthrough", "-o", "xattr=sa", "-o", "mountpoint=legacy", "zfspv/pvc-1"}},
+		{"nfsv4 keeps xattr", volGen("nfsv4", "restricted", ""),
+			[]string{"create", "-o", "acltype=nfsv4", "-o", "aclmode=restricted", "-o", "mountpoint=legacy", "zfspv/pvc-1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := buildDatasetCreateArgs(tt.vol); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("buildDatasetCreateArgs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadOnlyCreateArgs(t *testing.T) {
+	vol := &apis.ZFSVolume{}
+	vol.Name = "pvc-1"
+	vol.Spec.PoolName = "zfspv"
+	vol.Spec.ThinProvision = "yes"
+	vol.Spec.ReadOnly = "yes"
+
+	want := []string{"create", "-o", "readonly=on", "-o", "mountpoint=legacy", "zfspv/pvc-1"}
+	if got := buildDatasetCreateArgs(vol); !reflect.DeepEqual(got, want) {
+		t.Errorf("buildDatasetCreateArgs() = %v, want %v", got, want)
+	}
+
+	vol.Spec.SnapName = "pvc-0@golden"
+	vol.Spec.VolumeType = VolTypeZVol
+	// the clone is made readonly after its fs uuid is regenerated
+	want = []string{"clone", "zfspv/pvc-0@golden", "zfspv/pvc-1"}
+	if got := buildCloneCreateArgs(vol); !reflect.DeepEqual(got, want) {
+		t.Errorf("buildCloneCreateArgs() = %v, want %v", got, want)
+	}
+}
+
+func TestRestoreRecordSizeArgs(t *testing.T) {
+	rstr := &apis.ZFSRestore{}
+	rstr.Spec.VolumeName = "pvc-1"
+	rstr.Spec.RestoreSrc = "10.0.0.1:9010"
+	rstr.Spec.RecordSize = "16k"
+	rstr.VolSpec.PoolName = "zfspv"
+	rstr.VolSpec.VolumeType = VolTypeDataset
+	rstr.VolSpec.RecordSize = "128k"
+
+	want := []string{"set", "recordsize=16k", "zfspv/pvc-1"}
+	if got := buildRestoreRecordSizeArgs(rstr); !reflect.DeepEqual(got, want) {
+		t.Errorf("buildRestoreRecordSizeArgs() = %v, want %v", got, want)
+	}
+
+	// the received blocks keep the recordsize of the source, the new
+	// recordsize is only set once the stream has been received
+	args, err := buildVolumeRestoreArgs(rstr)
+	if err != nil {
+		t.Fatalf("buildVolumeRestoreArgs() unexpected error %v", err)
+	}
+	if strings.Contains(args[1], "recordsize=16k") || !strings.Contains(args[1], "recordsize=128k") {
+		t.Errorf("buildVolumeRestoreArgs() = %v, want the source recordsize on receive", args)
+	}
+
+	rstr.Spec.RecordSize = ""
+	if got := buildRestoreRecordSizeArgs(rstr); got != nil {
+		t.Errorf("buildRestoreRecordSizeArgs() = %v, want nothing to set", got)
+	}
+
+	rstr.Spec.RecordSize = "16k"
+	rstr.VolSpec.VolumeType = VolTypeZVol
+	if got := buildRestoreRecordSizeArgs(rstr); got != nil {
+		t.Errorf("buildRestoreRecordSizeArgs() = %v, want nothing to set for a zvol", got)
+	}
+}
+
+func TestIncrementalRestoreArgs(t *testing.T) {
+	rstr := &apis.ZFSRestore{}
+	rstr.UID = "uid-1"
+	rstr.Spec.VolumeName = "pvc-1"
+	rstr.Spec.RestoreSrc = "10.0.0.1:9010"
+	rstr.Spec.Incremental = true
+	rstr.VolSpec.PoolName = "zfspv"
+	rstr.VolSpec.VolumeType = VolTypeDataset
+	rstr.VolSpec.Capacity = "4096"
+	rstr.VolSpec.Encryption = "on"
+
+	// the properties were set by the receive of the full stream
+	args, err := buildVolumeRestoreArgs(rstr)
+	if err != nil {
+		t.Fatalf("buildVolumeRestoreArgs() unexpected error %v", err)
+	}
+	want := []string{"-c", "nc -w 3 10.0.0.1 9010 | " + zfsShell() + " recv -F zfspv/pvc-1"}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("buildVolumeRestoreArgs() = %v, want %v", args, want)
+	}
+}
@@ -0,0 +1,277 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package zfstest provides a fake of the zfs and zpool commands for the
+// unit tests, keeping the datasets in a temporary directory
+package zfstest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// script keeps each dataset as a directory below ds, holding its
+// properties as files in .p with the slashes of their name replaced by %.
+// The dataset is the last argument of the zfs commands, the properties the
+// one before for zfs get. The space available below a quota is the quota
+// less the used space, which is 0 unless set. zfs list -t snapshot lists
+// the snapshots of the dataset by name. zfs send writes the file stream,
+// or the name of the snapshot without it, and hangs then if the file hang
+// exists. zfs recv creates the dataset, and the snapshot named by the
+// stream if any. The zpool commands are named zpool-<command> and write
+// the file of their name, if any. A command fails with the message of the
+// first line of the file fail whose prefix, separated by a tab, starts its
+// command line.
+const script = `#!/bin/sh
+S=%s
+# recv is logged after the send of its stream
+if [ "$1" = recv ]; then src=$(cat); fi
+line="$*"
+[ "${0##*/}" = zpool ] && line="zpool $line"
+echo "$line" >> "$S/log"
+if [ -f "$S/fail" ]; then
+	while IFS='	' read -r pre msg; do
+		case $line in "$pre"*)
+			echo "$msg" >&2
+			exit 1
+			;;
+		esac
+	done < "$S/fail"
+fi
+cmd=$1
+shift
+[ "${0##*/}" = zpool ] && cmd=zpool-$cmd
+for a; do prev=$ds; ds=$a; done
+d="$S/ds/$ds"
+missing() {
+	echo "cannot open '$ds': dataset does not exist" >&2
+	exit 1
+}
+case $cmd in
+list)
+	[ -d "$d" ] || missing
+	case " $* " in
+	*" -t snapshot "*)
+		for s in "$d"@*; do
+			if [ -d "$s" ]; then echo "${s#$S/ds/}"; fi
+		done
+		;;
+	*) echo "$ds" ;;
+	esac
+	;;
+get)
+	[ -d "$d" ] || missing
+	for p in $(echo "$prev" | tr ,/ ' %%'); do
+		if [ -f "$d/.p/$p" ]; then
+			cat "$d/.p/$p"
+		elif [ "$p" = used ]; then
+			echo 0
+		elif [ "$p" = available ] && [ -f "$d/.p/quota" ]; then
+			echo $(($(cat "$d/.p/quota") - $(cat "$d/.p/used" 2>/dev/null || echo 0)))
+		else
+			echo -
+		fi
+	done
+	;;
+set)
+	[ -d "$d" ] || missing
+	for a; do
+		case $a in *=*) echo "${a#*=}" > "$d/.p/${a%%%%=*}" ;; esac
+	done
+	;;
+inherit)
+	[ -d "$d" ] || missing
+	rm -f "$d/.p/$prev"
+	;;
+create | snapshot | clone | recv)
+	[ -d "$d" ] && { echo "cannot create '$ds': dataset already exists" >&2; exit 1; }
+	mkdir -p "$d/.p"
+	while [ $# -gt 1 ]; do
+		case $1 in
+		-o) echo "${2#*=}" > "$d/.p/${2%%%%=*}"; shift ;;
+		-V) echo "$2" > "$d/.p/volsize"; shift ;;
+		esac
+		shift
+	done
+	if [ "$cmd" = recv ] && [ -d "$S/ds/$src" ]; then
+		case $src in *@*) mkdir -p "$d@${src##*@}/.p" ;; esac
+	fi
+	;;
+destroy)
+	[ -d "$d" ] || missing
+	rm -rf "$d" "$d"@*
+	;;
+promote)
+	[ -d "$d" ] || missing
+	;;
+rename)
+	[ -d "$S/ds/$prev" ] || missing
+	for s in "$S/ds/$prev"@*; do
+		if [ -d "$s" ]; then mv "$s" "$d@${s##*@}"; fi
+	done
+	mv "$S/ds/$prev" "$d"
+	;;
+send)
+	[ -d "$d" ] || missing
+	if [ -f "$S/stream" ]; then cat "$S/stream"; else echo "$ds"; fi
+	if [ -f "$S/hang" ]; then exec sleep 10; fi
+	;;
+zpool-*)
+	if [ -f "$S/$cmd" ]; then cat "$S/$cmd"; fi
+	;;
+esac
+`
+
+// FakeZFS is a script standing for the zfs and zpool commands, the tests
+// check the datasets and their properties left by the code under test
+type FakeZFS struct {
+	// Path is the script run as zfs, ZPool the script run as zpool
+	Path  string
+	ZPool string
+
+	dir   string
+	fails map[string]string
+}
+
+// New returns the fake zfs with the datasets, the script is named zfs and
+// zpool in its directory
+func New(t *testing.T, datasets ...string) *FakeZFS {
+	f := &FakeZFS{dir: t.TempDir(), fails: map[string]string{}}
+	f.Path = filepath.Join(f.dir, "zfs")
+	f.ZPool = filepath.Join(f.dir, "zpool")
+	if err := os.WriteFile(f.Path, []byte(fmt.Sprintf(script, f.dir)), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(f.Path, f.ZPool); err != nil {
+		t.Fatal(err)
+	}
+	for _, ds := range datasets {
+		f.Create(t, ds)
+	}
+	return f
+}
+
+// InPath makes the zfs and zpool commands run the script until the test
+// ends, for the packages running them by name
+func (f *FakeZFS) InPath(t *testing.T) {
+	t.Setenv("PATH", f.dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+// Create adds the dataset
+func (f *FakeZFS) Create(t *testing.T, dataset string) {
+	if err := os.MkdirAll(filepath.Join(f.dir, "ds", dataset, ".p"), 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Set sets the property of the dataset
+func (f *FakeZFS) Set(t *testing.T, dataset, prop, value string) {
+	f.Create(t, dataset)
+	if err := os.WriteFile(propFile(f.dir, dataset, prop), []byte(value+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// propFile returns the file of the property of the dataset
+func propFile(dir, dataset, prop string) string {
+	return filepath.Join(dir, "ds", dataset, ".p", strings.ReplaceAll(prop, "/", "%"))
+}
+
+// Fail makes the commands starting with the prefix fail with the message,
+// until it is called again with an empty one. The zpool commands are given
+// as "zpool <command>".
+func (f *FakeZFS) Fail(t *testing.T, prefix, msg string) {
+	if msg == "" {
+		delete(f.fails, prefix)
+	} else {
+		f.fails[prefix] = msg
+	}
+	var rules []string
+	for pre, msg := range f.fails {
+		rules = append(rules, pre+"\t"+msg+"\n")
+	}
+	sort.Strings(rules)
+	if err := os.WriteFile(filepath.Join(f.dir, "fail"), []byte(strings.Join(rules, "")), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Stream sets the stream written by zfs send
+func (f *FakeZFS) Stream(t *testing.T, data string) {
+	if err := os.WriteFile(filepath.Join(f.dir, "stream"), []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Pool sets the output of the zpool command
+func (f *FakeZFS) Pool(t *testing.T, command, out string) {
+	if err := os.WriteFile(filepath.Join(f.dir, "zpool-"+command), []byte(out), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Hang makes zfs send hang once it has written the stream, until it is
+// killed
+func (f *FakeZFS) Hang(t *testing.T) {
+	if err := os.WriteFile(filepath.Join(f.dir, "hang"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Exists tells whether the dataset exists
+func (f *FakeZFS) Exists(dataset string) bool {
+	_, err := os.Stat(filepath.Join(f.dir, "ds", dataset))
+	return err == nil
+}
+
+// Datasets returns the datasets and the snapshots, sorted by name
+func (f *FakeZFS) Datasets() []string {
+	var datasets []string
+	root := filepath.Join(f.dir, "ds")
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.IsDir() && info.Name() == ".p" {
+			ds, _ := filepath.Rel(root, filepath.Dir(path))
+			datasets = append(datasets, ds)
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	sort.Strings(datasets)
+	return datasets
+}
+
+// Prop returns the property of the dataset, - if it is not set
+func (f *FakeZFS) Prop(dataset, prop string) string {
+	val, err := os.ReadFile(propFile(f.dir, dataset, prop))
+	if err != nil {
+		return "-"
+	}
+	return strings.TrimSpace(string(val))
+}
+
+// Ran returns the commands run, without the binary for zfs and with it for
+// zpool
+func (f *FakeZFS) Ran() []string {
+	out, _ := os.ReadFile(filepath.Join(f.dir, "log"))
+	if len(out) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSpace(string(out)), "\n")
+}
@@ -0,0 +1,165 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"fmt"
+	"sync"
+
+	apis "github.com/openebs/zfs-localpv/pkg/apis/openebs.io/zfs/v1"
+	"k8s.io/klog/v2"
+)
+
+// the policies of the node agent for a zfs version out of the supported
+// range
+const (
+	// ZFSVersionWarn logs a warning and starts the node agent anyway
+	ZFSVersionWarn = "warn"
+	// ZFSVersionRefuse stops the node agent
+	ZFSVersionRefuse = "refuse"
+)
+
+// the default range of the zfs versions the driver has been tested with,
+// both ends are included and compared on the major and minor numbers
+const (
+	DefaultMinZFSVersion = "0.8"
+	DefaultMaxZFSVersion = "2.2"
+)
+
+// zfsVersionRange is the range of the supported zfs versions
+type zfsVersionRange struct {
+	min, max string
+	// the major and minor numbers of min and max
+	minMajor, minMinor int
+	maxMajor, maxMinor int
+}
+
+// String returns the range as printed in the ZFSNode, e.g. 0.8-2.2
+func (r zfsVersionRange) String() string {
+	return r.min + "-" + r.max
+}
+
+// contains tells whether the major and minor version is in the range
+func (r zfsVersionRange) contains(major, minor int) bool {
+	if major < r.minMajor || (major == r.minMajor && minor < r.minMinor) {
+		return false
+	}
+	return major < r.maxMajor || (major == r.maxMajor && minor <= r.maxMinor)
+}
+
+// parseZFSVersionRange returns the range of the min and max versions, e.g.
+// 0.8 and 2.2
+func parseZFSVersionRange(min, max string) (zfsVersionRange, error) {
+	r := zfsVersionRange{min: min, max: max}
+	var err error
+	if r.minMajor, r.minMinor, err = parseMajorMinor(min); err != nil {
+		return r, fmt.Errorf("invalid min zfs version: %v", err)
+	}
+	if r.maxMajor, r.maxMinor, err = parseMajorMinor(max); err != nil {
+		return r, fmt.Errorf("invalid max zfs version: %v", err)
+	}
+	if !r.contains(r.maxMajor, r.maxMinor) {
+		return r, fmt.Errorf("invalid zfs version range, min %s is greater than max %s", min, max)
+	}
+	return r, nil
+}
+
+var (
+	versionPolicy = ZFSVersionWarn
+	versionRange  = zfsVersionRange{
+		min: DefaultMinZFSVersion, max: DefaultMaxZFSVersion,
+		minMajor: 0, minMinor: 8, maxMajor: 2, maxMinor: 2,
+	}
+
+	// nodeVersion is the zfs version detected at startup, it is nil
+	// until the version has been detected
+	nodeVersionMu sync.Mutex
+	nodeVersion   *apis.ZFSVersionStatus
+)
+
+// SetZFSVersionPolicy sets what the node agent does with a zfs version out
+// of the supported range, warn or refuse, and the range. It must be set
+// before the version is detected.
+func SetZFSVersionPolicy(policy, min, max string) error {
+	if policy != ZFSVersionWarn && policy != ZFSVersionRefuse {
+		return fmt.Errorf("invalid zfs version policy %q, it should be %s or %s",
+			policy, ZFSVersionWarn, ZFSVersionRefuse)
+	}
+	r, err := parseZFSVersionRange(min, max)
+	if err != nil {
+		return err
+	}
+	versionPolicy, versionRange = policy, r
+	return nil
+}
+
+// checkZFSVersion returns the status of the zfs version in the output of
+// zfs version against the supported range, and an error if the node agent
+// should not start as per the policy. A version which can not be parsed
+// is not supported.
+func checkZFSVersion(out, policy string, r zfsVersionRange) (*apis.ZFSVersionStatus, error) {
+	st := &apis.ZFSVersionStatus{
+		Version:        zfsVersionString(out),
+		SupportedRange: r.String(),
+	}
+	major, minor, err := parseZFSVersion(out)
+	if err == nil && r.contains(major, minor) {
+		st.Supported = true
+		return st, nil
+	}
+	msg := fmt.Sprintf("zfs version %q of node %s is not in the supported range %s", st.Version, NodeID, r)
+	if err != nil {
+		msg = fmt.Sprintf("%s: %v", msg, err)
+	}
+	if policy == ZFSVersionRefuse {
+		return st, fmt.Errorf("zfs: %s, refusing to start", msg)
+	}
+	klog.Warningf("zfs: %s, some properties may behave differently", msg)
+	return st, nil
+}
+
+// DetectZFSVersion detects the zfs version of the node and checks it
+// against the supported range. It returns an error if the version is out
+// of the range or can not be detected, e.g. zfs 0.7 has no version
+// command, and the policy is refuse.
+func DetectZFSVersion() error {
+	out, err := zfsVersion()
+	if err != nil {
+		klog.Errorf("zfs: could not detect the zfs version: %v", err)
+		out = ""
+	}
+	st, err := checkZFSVersion(out, versionPolicy, versionRange)
+	if err != nil {
+		return err
+	}
+	klog.Infof("zfs: node %s runs zfs %q, supported %t", NodeID, st.Version, st.Supported)
+	nodeVersionMu.Lock()
+	nodeVersion = st
+	nodeVersionMu.Unlock()
+	return nil
+}
+
+// NodeZFSVersion returns the zfs version detected at startup, it is nil
+// if the version has not been detected
+func NodeZFSVersion() *apis.ZFSVersionStatus {
+	nodeVersionMu.Lock()
+	defer nodeVersionMu.Unlock()
+	if nodeVersion == nil {
+		return nil
+	}
+	return nodeVersion.DeepCopy()
+}
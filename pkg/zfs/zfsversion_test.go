@@ -0,0 +1,182 @@
+/*
+Copyright 2023 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestZFSVersionString(t *testing.T) {
+	tests := []struct {
+		out  string
+		want string
+	}{
+		{"zfs-2.1.5-1ubuntu6~22.04.1\nzfs-kmod-2.1.5-1ubuntu6\n", "2.1.5-1ubuntu6"},
+		{"zfs-2.2.0-rc1\n", "2.2.0-rc1"},
+		{"zfs-kmod-2.0.7\nzfs-2.1.0\n", "2.0.7"},
+		{"unrecognized command 'version'\n", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := zfsVersionString(tt.out); got != tt.want {
+			t.Errorf("zfsVersionString(%q) = %q, want %q", tt.out, got, tt.want)
+		}
+	}
+}
+
+func TestParseMajorMinor(t *testing.T) {
+	tests := []struct {
+		version      string
+		major, minor int
+		wantErr      bool
+	}{
+		{"2.1.5-1ubuntu6", 2, 1, false},
+		{"0.8", 0, 8, false},
+		{"2.2-rc1", 2, 2, false},
+		{"2.10.0", 2, 10, false},
+		{"2", 0, 0, true},
+		{"x.1", 0, 0, true},
+		{"2.x", 0, 0, true},
+		{"", 0, 0, true},
+	}
+	for _, tt := range tests {
+		major, minor, err := parseMajorMinor(tt.version)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseMajorMinor(%q) error = %v, wantErr %v", tt.version, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && (major != tt.major || minor != tt.minor) {
+			t.Errorf("parseMajorMinor(%q) = %d.%d, want %d.%d", tt.version, major, minor, tt.major, tt.minor)
+		}
+	}
+}
+
+func TestZFSVersionRange(t *testing.T) {
+	r, err := parseZFSVersionRange("0.8", "2.2")
+	if err != nil {
+		t.Fatalf("parseZFSVersionRange: %v", err)
+	}
+	if r.String() != "0.8-2.2" {
+		t.Errorf("expected the range 0.8-2.2, got %s", r)
+	}
+	tests := []struct {
+		major, minor int
+		want         bool
+	}{
+		{0, 7, false},
+		{0, 8, true},
+		{1, 0, true},
+		{2, 1, true},
+		{2, 2, true},
+		{2, 3, false},
+		{3, 0, false},
+	}
+	for _, tt := range tests {
+		if got := r.contains(tt.major, tt.minor); got != tt.want {
+			t.Errorf("contains(%d.%d) = %t, want %t", tt.major, tt.minor, got, tt.want)
+		}
+	}
+
+	for _, bad := range [][2]string{{"2.2", "0.8"}, {"x", "2.2"}, {"0.8", ""}} {
+		if _, err := parseZFSVersionRange(bad[0], bad[1]); err == nil {
+			t.Errorf("expected the range %s-%s to be refused", bad[0], bad[1])
+		}
+	}
+	if _, err := parseZFSVersionRange("2.1", "2.1"); err != nil {
+		t.Errorf("expected a single version range to be accepted, got %v", err)
+	}
+}
+
+func TestCheckZFSVersion(t *testing.T) {
+	r, _ := parseZFSVersionRange(DefaultMinZFSVersion, DefaultMaxZFSVersion)
+	tests := []struct {
+		name          string
+		out           string
+		policy        string
+		wantSupported bool
+		wantErr       bool
+	}{
+		{"supported warn", "zfs-kmod-2.1.5\n", ZFSVersionWarn, true, false},
+		{"supported refuse", "zfs-kmod-2.1.5\n", ZFSVersionRefuse, true, false},
+		{"newer warn", "zfs-kmod-2.3.0\n", ZFSVersionWarn, false, false},
+		{"newer refuse", "zfs-kmod-2.3.0\n", ZFSVersionRefuse, false, true},
+		{"older refuse", "zfs-0.7.13\n", ZFSVersionRefuse, false, true},
+		{"unknown warn", "", ZFSVersionWarn, false, false},
+		{"unknown refuse", "", ZFSVersionRefuse, false, true},
+	}
+	for _, tt := range tests {
+		st, err := checkZFSVersion(tt.out, tt.policy, r)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+		if st.Supported != tt.wantSupported {
+			t.Errorf("%s: supported = %t, want %t", tt.name, st.Supported, tt.wantSupported)
+		}
+		if st.SupportedRange != "0.8-2.2" {
+			t.Errorf("%s: expected the range 0.8-2.2, got %s", tt.name, st.SupportedRange)
+		}
+	}
+}
+
+func TestDetectZFSVersion(t *testing.T) {
+	origVersion, origPolicy, origRange := zfsVersion, versionPolicy, versionRange
+	t.Cleanup(func() {
+		zfsVersion, versionPolicy, versionRange = origVersion, origPolicy, origRange
+		nodeVersion = nil
+	})
+
+	if err := SetZFSVersionPolicy("ignore", "0.8", "2.2"); err == nil {
+		t.Errorf("expected an unknown policy to be refused")
+	}
+	if err := SetZFSVersionPolicy(ZFSVersionRefuse, "2.0", "2.1"); err != nil {
+		t.Fatalf("SetZFSVersionPolicy: %v", err)
+	}
+
+	zfsVersion = func() (string, error) { return "zfs-2.2.0\nzfs-kmod-2.2.0\n", nil }
+	if err := DetectZFSVersion(); err == nil {
+		t.Errorf("expected zfs 2.2 to be refused out of 2.0-2.1")
+	}
+	if NodeZFSVersion() != nil {
+		t.Errorf("expected no version to be reported after a refusal")
+	}
+
+	zfsVersion = func() (string, error) { return "", errors.New("unrecognized command") }
+	if err := DetectZFSVersion(); err == nil {
+		t.Errorf("expected an undetected version to be refused")
+	}
+
+	if err := SetZFSVersionPolicy(ZFSVersionWarn, "2.0", "2.1"); err != nil {
+		t.Fatalf("SetZFSVersionPolicy: %v", err)
+	}
+	zfsVersion = func() (string, error) { return "zfs-kmod-2.2.0\n", nil }
+	if err := DetectZFSVersion(); err != nil {
+		t.Errorf("expected zfs 2.2 to be accepted with a warning, got %v", err)
+	}
+	st := NodeZFSVersion()
+	if st == nil || st.Version != "2.2.0" || st.Supported || st.SupportedRange != "2.0-2.1" {
+		t.Errorf("unexpected version status %+v", st)
+	}
+
+	zfsVersion = func() (string, error) { return "zfs-kmod-2.1.14\n", nil }
+	if err := DetectZFSVersion(); err != nil {
+		t.Errorf("expected zfs 2.1 to be accepted, got %v", err)
+	}
+	if st := NodeZFSVersion(); st == nil || !st.Supported {
+		t.Errorf("expected zfs 2.1 to be supported, got %+v", st)
+	}
+}